@@ -5,8 +5,10 @@ package ast
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
 
+	"github.com/cedrickchee/hou/lang"
 	"github.com/cedrickchee/hou/token"
 )
 
@@ -15,6 +17,9 @@ type Node interface {
 	// Returns the literal value of the token it's associated with.
 	// This method will be used only for debugging and testing.
 	TokenLiteral() string
+	// Returns the position of the token it's associated with, so errors
+	// can point at the source code of the node.
+	Pos() token.Position
 	// Returns a stringified version of the AST for debugging.
 	String() string
 }
@@ -43,6 +48,9 @@ type Program struct {
 	// A program consists of a slice of AST nodes that implement the Statement
 	// interface.
 	Statements []Statement
+	// Features are the features of the language version the program was
+	// parsed as.
+	Features lang.FeatureSet
 }
 
 // TokenLiteral prints the literal value of the token associated with this node.
@@ -53,12 +61,23 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+// Pos returns the position of the first statement of the program.
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 // String returns a stringified version of the AST for debugging.
 func (p *Program) String() string {
 	// Creates a buffer and writes the return value of each statements String()
 	// method to it.
 	var out bytes.Buffer
 
+	if v := p.Features.Version(); v != lang.Default {
+		fmt.Fprintf(&out, "#pragma version %d\n", v)
+	}
 	for _, s := range p.Statements {
 		// Delegates most of program work to the Statements of *ast.Program.
 		out.WriteString(s.String())
@@ -75,6 +94,8 @@ type LetStatement struct {
 	// that produces the value.
 	Name  *Identifier
 	Value Expression
+	// Type is the annotated type of the binding, nil if there's none.
+	Type *TypeName
 }
 
 func (ls *LetStatement) statementNode() {}
@@ -82,12 +103,18 @@ func (ls *LetStatement) statementNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (ls *LetStatement) Pos() token.Position { return ls.Token.Position }
+
 // String returns a stringified version of the AST `let` node for debugging.
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(ls.TokenLiteral() + " ")
 	out.WriteString(ls.Name.String())
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -103,6 +130,16 @@ func (ls *LetStatement) String() string {
 type Identifier struct {
 	Token token.Token // the token.IDENT token
 	Value string
+	// Skip is the number of environments the evaluator can skip when it looks
+	// the identifier up: the parser proved that the innermost Skip functions
+	// enclosing the identifier don't bind it, neither as a parameter nor with
+	// a let statement.
+	Skip int
+	// Slot is one more than the index of the slot that binds the identifier
+	// in the environment Skip environments out, if the parser found the
+	// binding there, see BlockStatement.Names. It's 0 if the identifier is
+	// looked up by name, e.g. if it's a global.
+	Slot int
 }
 
 // To hold the identifier of the binding, the x in let x = 5; , we have the
@@ -112,11 +149,31 @@ func (i *Identifier) expressionNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (i *Identifier) Pos() token.Position { return i.Token.Position }
+
 // String returns a stringified version of the identifier node.
 func (i *Identifier) String() string {
 	return i.Value
 }
 
+// TypeName is a type annotation, e.g. the Int of `let x: Int = 5;`. The
+// evaluator ignores annotations unless asked to check them, see package
+// typecheck.
+type TypeName struct {
+	Token token.Token // the token.IDENT token
+	Name  string
+}
+
+// TokenLiteral prints the literal value of the token associated with this node.
+func (tn *TypeName) TokenLiteral() string { return tn.Token.Literal }
+
+// Pos returns the position of the token associated with this node.
+func (tn *TypeName) Pos() token.Position { return tn.Token.Position }
+
+// String returns the name of the type.
+func (tn *TypeName) String() string { return tn.Name }
+
 // ReturnStatement the `return` statement that represents the AST node that
 // holds a return value to the outer stack in the call stack.
 type ReturnStatement struct {
@@ -129,6 +186,9 @@ func (rs *ReturnStatement) statementNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (rs *ReturnStatement) Pos() token.Position { return rs.Token.Position }
+
 // String returns a stringified version of the AST `return` node for debugging.
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
@@ -156,6 +216,9 @@ func (es *ExpressionStatement) statementNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (es *ExpressionStatement) Pos() token.Position { return es.Token.Position }
+
 // String returns a stringified version of the AST for debugging.
 func (es *ExpressionStatement) String() string {
 	// The nil-checks will be taken out, later on, when we can fully build
@@ -177,9 +240,30 @@ func (il *IntegerLiteral) expressionNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (il *IntegerLiteral) Pos() token.Position { return il.Token.Position }
+
 // String returns a stringified version of the AST for debugging.
 func (il *IntegerLiteral) String() string { return il.Token.Literal }
 
+// FloatLiteral represents a literal floating point number, e.g. 3.14 or 1e9,
+// and holds its value.
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode() {}
+
+// TokenLiteral prints the literal value of the token associated with this node.
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+
+// Pos returns the position of the token associated with this node.
+func (fl *FloatLiteral) Pos() token.Position { return fl.Token.Position }
+
+// String returns a stringified version of the AST for debugging.
+func (fl *FloatLiteral) String() string { return fl.Token.Literal }
+
 // PrefixExpression represents a prefix expression and holds the operator as
 // as well as the right-hand side expression.
 type PrefixExpression struct {
@@ -193,6 +277,9 @@ func (pe *PrefixExpression) expressionNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (pe *PrefixExpression) Pos() token.Position { return pe.Token.Position }
+
 // String returns a stringified version of the AST for debugging.
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
@@ -222,6 +309,9 @@ func (ie *InfixExpression) expressionNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (ie *InfixExpression) Pos() token.Position { return ie.Token.Position }
+
 // String returns a stringified version of the AST for debugging.
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
@@ -246,9 +336,67 @@ func (b *Boolean) expressionNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (b *Boolean) Pos() token.Position { return b.Token.Position }
+
 // String returns a stringified version of the AST for debugging.
 func (b *Boolean) String() string { return b.Token.Literal }
 
+// Null represents the null literal.
+type Null struct {
+	Token token.Token
+}
+
+func (n *Null) expressionNode() {}
+
+// TokenLiteral prints the literal value of the token associated with this node.
+func (n *Null) TokenLiteral() string { return n.Token.Literal }
+
+// Pos returns the position of the token associated with this node.
+func (n *Null) Pos() token.Position { return n.Token.Position }
+
+// String returns a stringified version of the AST for debugging.
+func (n *Null) String() string { return n.Token.Literal }
+
+// AssignExpression represents an assignment to a name bound by a let
+// statement, e.g. x = 5 or x += 1, or to an element of an array or hash, e.g.
+// h["key"] = 5, and holds the name or index expression, the operator and the
+// value.
+type AssignExpression struct {
+	Token token.Token // The assignment operator token, e.g. = or +=
+	// Name is the name assigned to, nil if Index is set instead.
+	Name *Identifier
+	// Index is the element assigned to, nil if Name is set instead.
+	Index    *IndexExpression
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode() {}
+
+// TokenLiteral prints the literal value of the token associated with this node.
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+
+// Pos returns the position of the token associated with this node.
+func (ae *AssignExpression) Pos() token.Position { return ae.Token.Position }
+
+// String returns a stringified version of the AST for debugging.
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	if ae.Index != nil {
+		out.WriteString(ae.Index.String())
+	} else {
+		out.WriteString(ae.Name.String())
+	}
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 // IfExpression represents an `if` expression and holds the condition,
 // consequence and alternative expressions
 type IfExpression struct {
@@ -263,6 +411,9 @@ func (ie *IfExpression) expressionNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (ie *IfExpression) Pos() token.Position { return ie.Token.Position }
+
 // String returns a stringified version of the AST for debugging.
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
@@ -280,10 +431,136 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// WhileExpression represents a `while` loop and holds the condition and the
+// body that's evaluated as long as the condition is truthy.
+type WhileExpression struct {
+	Token     token.Token // The 'while' token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (we *WhileExpression) expressionNode() {}
+
+// TokenLiteral prints the literal value of the token associated with this node.
+func (we *WhileExpression) TokenLiteral() string { return we.Token.Literal }
+
+// Pos returns the position of the token associated with this node.
+func (we *WhileExpression) Pos() token.Position { return we.Token.Position }
+
+// String returns a stringified version of the AST for debugging.
+func (we *WhileExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while")
+	out.WriteString(we.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(we.Body.String())
+
+	return out.String()
+}
+
+// ForInExpression represents a `for (value in iterable)` or a
+// `for (key, value in iterable)` loop, whose body is evaluated for every
+// element of an array, pair of a hash or character of a string. With one
+// name, the loop binds the elements of arrays, the keys of hashes and the
+// characters of strings. With two, it binds the index or key to the first,
+// and the element, value or character to the second.
+type ForInExpression struct {
+	Token    token.Token // The 'for' token
+	Key      *Identifier // nil if the loop binds one name
+	Value    *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fe *ForInExpression) expressionNode() {}
+
+// TokenLiteral prints the literal value of the token associated with this node.
+func (fe *ForInExpression) TokenLiteral() string { return fe.Token.Literal }
+
+// Pos returns the position of the token associated with this node.
+func (fe *ForInExpression) Pos() token.Position { return fe.Token.Position }
+
+// String returns a stringified version of the AST for debugging.
+func (fe *ForInExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for(")
+	if fe.Key != nil {
+		out.WriteString(fe.Key.String())
+		out.WriteString(", ")
+	}
+	out.WriteString(fe.Value.String())
+	out.WriteString(" in ")
+	out.WriteString(fe.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fe.Body.String())
+
+	return out.String()
+}
+
+// MatchExpression represents a `match` expression and holds the value matched
+// and the cases it's matched against, in order, and the default case. The
+// basic structure is
+// `match (<expression>) { case <expressions>: <block> ... default: <block> }`.
+type MatchExpression struct {
+	Token   token.Token // The 'match' token
+	Subject Expression
+	Cases   []*MatchCase
+	Default *BlockStatement // nil if there's no default case
+}
+
+// MatchCase is a case of a match expression: the body is evaluated if the
+// value matched equals one of the values of the case.
+type MatchCase struct {
+	Token  token.Token // The 'case' token
+	Values []Expression
+	Body   *BlockStatement
+}
+
+func (me *MatchExpression) expressionNode() {}
+
+// TokenLiteral prints the literal value of the token associated with this node.
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+
+// Pos returns the position of the token associated with this node.
+func (me *MatchExpression) Pos() token.Position { return me.Token.Position }
+
+// String returns a stringified version of the AST for debugging.
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("match(")
+	out.WriteString(me.Subject.String())
+	out.WriteString(") {")
+	for _, c := range me.Cases {
+		values := []string{}
+		for _, v := range c.Values {
+			values = append(values, v.String())
+		}
+		out.WriteString(" case ")
+		out.WriteString(strings.Join(values, ", "))
+		out.WriteString(": ")
+		out.WriteString(c.Body.String())
+	}
+	if me.Default != nil {
+		out.WriteString(" default: ")
+		out.WriteString(me.Default.String())
+	}
+	out.WriteString(" }")
+
+	return out.String()
+}
+
 // BlockStatement represents a block statement and holds a series of statements.
 type BlockStatement struct {
 	Token      token.Token // the { token
 	Statements []Statement
+	// Names are the names bound in the slots of the environment the block is
+	// evaluated in, if it's a scope, e.g. the body of a function: its
+	// parameters, and then the names its let statements bind. The parser
+	// records them.
+	Names []string
 }
 
 func (bs *BlockStatement) statementNode() {}
@@ -291,6 +568,9 @@ func (bs *BlockStatement) statementNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (bs *BlockStatement) Pos() token.Position { return bs.Token.Position }
+
 // String returns a stringified version of the AST for debugging.
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
@@ -305,9 +585,45 @@ func (bs *BlockStatement) String() string {
 // FunctionLiteral represents a literal function and has two main parts,
 // the list of parameters and the block statement that is the function's body.
 type FunctionLiteral struct {
-	Token      token.Token // The 'fn' token
+	Token token.Token // The 'fn' token
+	// Name is the optional name of the function, e.g. fact in
+	// `fn fact(n) { ... }`, which is bound to the function in its body, so
+	// that it can call itself. It's nil for anonymous functions.
+	Name       *Identifier
 	Parameters []*Identifier
 	Body       *BlockStatement
+	// Generator is true if the body yields values, which turns the function
+	// into a generator function.
+	Generator bool
+	// ParameterTypes holds the annotated types of the parameters, nil for
+	// the ones without annotation. It's nil if no parameter is annotated.
+	ParameterTypes []*TypeName
+	// Defaults holds the default values of the parameters, e.g. 10 in
+	// `fn(a, b = 10)`, nil for the ones without one. It's nil if no
+	// parameter has a default. Only the last parameters can have one.
+	Defaults []Expression
+	// ReturnType is the annotated type of the results, nil if there's none.
+	ReturnType *TypeName
+}
+
+// Default returns the default value of the i-th parameter, nil if it has
+// none.
+func (fl *FunctionLiteral) Default(i int) Expression {
+	if i < len(fl.Defaults) {
+		return fl.Defaults[i]
+	}
+	return nil
+}
+
+// Required returns the number of parameters without a default value, which
+// are the arguments a call needs at least.
+func (fl *FunctionLiteral) Required() int {
+	for i := range fl.Parameters {
+		if fl.Default(i) != nil {
+			return i
+		}
+	}
+	return len(fl.Parameters)
 }
 
 // The type of AST node for FunctionLiteral is expression.
@@ -316,6 +632,9 @@ func (fl *FunctionLiteral) expressionNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (fl *FunctionLiteral) Pos() token.Position { return fl.Token.Position }
+
 // String returns a stringified version of the AST for debugging.
 func (fl *FunctionLiteral) String() string {
 	// The abstract structure of a function literal is:
@@ -323,19 +642,74 @@ func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
+	for i, p := range fl.Parameters {
+		param := p.String()
+		if i < len(fl.ParameterTypes) && fl.ParameterTypes[i] != nil {
+			param += ": " + fl.ParameterTypes[i].String()
+		}
+		if d := fl.Default(i); d != nil {
+			param += " = " + d.String()
+		}
+		params = append(params, param)
 	}
 
 	out.WriteString(fl.TokenLiteral())
+	if fl.Name != nil {
+		out.WriteString(" " + fl.Name.String())
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") ")
+	if fl.ReturnType != nil {
+		out.WriteString("-> " + fl.ReturnType.String() + " ")
+	}
 	out.WriteString(fl.Body.String())
 
 	return out.String()
 }
 
+// YieldExpression represents a `yield` expression, which hands a value over
+// to the caller of a generator and suspends the generator until the next value
+// is requested.
+type YieldExpression struct {
+	Token token.Token // the 'yield' token
+	Value Expression
+}
+
+func (ye *YieldExpression) expressionNode() {}
+
+// TokenLiteral prints the literal value of the token associated with this node.
+func (ye *YieldExpression) TokenLiteral() string { return ye.Token.Literal }
+
+// Pos returns the position of the token associated with this node.
+func (ye *YieldExpression) Pos() token.Position { return ye.Token.Position }
+
+// String returns a stringified version of the AST for debugging.
+func (ye *YieldExpression) String() string {
+	return ye.TokenLiteral() + " " + ye.Value.String()
+}
+
+// SpawnExpression represents a `spawn fn() { ... }` expression, which calls
+// the function on a task of its own, like the spawn builtin, and evaluates to
+// the task.
+type SpawnExpression struct {
+	Token    token.Token // the 'spawn' token
+	Function *FunctionLiteral
+}
+
+func (se *SpawnExpression) expressionNode() {}
+
+// TokenLiteral prints the literal value of the token associated with this node.
+func (se *SpawnExpression) TokenLiteral() string { return se.Token.Literal }
+
+// Pos returns the position of the token associated with this node.
+func (se *SpawnExpression) Pos() token.Position { return se.Token.Position }
+
+// String returns a stringified version of the AST for debugging.
+func (se *SpawnExpression) String() string {
+	return se.TokenLiteral() + " " + se.Function.String()
+}
+
 // CallExpression represents a call expression and holds the function to be
 // called as well as the arguments to be passed to that function.
 type CallExpression struct {
@@ -352,6 +726,9 @@ func (ce *CallExpression) expressionNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (ce *CallExpression) Pos() token.Position { return ce.Token.Position }
+
 // String returns a stringified version of the AST for debugging.
 func (ce *CallExpression) String() string {
 	// Call expression structure:
@@ -383,6 +760,9 @@ func (sl *StringLiteral) expressionNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (sl *StringLiteral) Pos() token.Position { return sl.Token.Position }
+
 // String returns a stringified version of the AST for debugging.
 func (sl *StringLiteral) String() string { return sl.Token.Literal }
 
@@ -397,6 +777,9 @@ func (al *ArrayLiteral) expressionNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (al *ArrayLiteral) Pos() token.Position { return al.Token.Position }
+
 // String returns a stringified version of the AST for debugging.
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
@@ -427,6 +810,9 @@ func (ie *IndexExpression) expressionNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (ie *IndexExpression) Pos() token.Position { return ie.Token.Position }
+
 // String returns a stringified version of the AST for debugging.
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
@@ -440,11 +826,45 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// MemberExpression represents a member access expression, e.g: person.name,
+// which is sugar for person["name"]. The basic structure is
+// `<expression>.<identifier>`.
+type MemberExpression struct {
+	Token  token.Token // the . token
+	Object Expression
+	// Property is the name of the member. It's not a reference to a
+	// variable, so nothing resolves or evaluates it.
+	Property *Identifier
+}
+
+func (me *MemberExpression) expressionNode() {}
+
+// TokenLiteral prints the literal value of the token associated with this node.
+func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
+
+// Pos returns the position of the token associated with this node.
+func (me *MemberExpression) Pos() token.Position { return me.Token.Position }
+
+// String returns a stringified version of the AST for debugging.
+func (me *MemberExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(me.Object.String())
+	out.WriteString(".")
+	out.WriteString(me.Property.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 // HashLiteral represents a hash map or dictionary literal, a set of key/value
 // pairs.
 type HashLiteral struct {
 	Token token.Token // the '{' token
 	Pairs map[Expression]Expression
+	// Keys are the keys of Pairs in the order they're written in.
+	Keys []Expression
 }
 
 func (hl *HashLiteral) expressionNode() {}
@@ -452,13 +872,16 @@ func (hl *HashLiteral) expressionNode() {}
 // TokenLiteral prints the literal value of the token associated with this node.
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
 
+// Pos returns the position of the token associated with this node.
+func (hl *HashLiteral) Pos() token.Position { return hl.Token.Position }
+
 // String returns a stringified version of the AST for debugging.
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
 	}
 
 	out.WriteString("{")
@@ -1,6 +1,8 @@
 package ast
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
 
 	"github.com/cedrickchee/hou/token"
@@ -27,3 +29,137 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestInspect(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{
+				Expression: &IfExpression{
+					Condition: &Boolean{Value: true},
+					Consequence: &BlockStatement{
+						Statements: []Statement{
+							&ExpressionStatement{Expression: &IntegerLiteral{Value: 1}},
+						},
+					},
+				},
+			},
+			&ExpressionStatement{Expression: &StringLiteral{Value: "s"}},
+		},
+	}
+
+	var visited []string
+	Inspect(program, func(node Node) bool {
+		visited = append(visited, fmt.Sprintf("%T", node))
+		// Don't visit the statements in blocks.
+		_, isBlock := node.(*BlockStatement)
+		return !isBlock
+	})
+
+	expected := []string{
+		"*ast.Program",
+		"*ast.ExpressionStatement",
+		"*ast.IfExpression",
+		"*ast.Boolean",
+		"*ast.BlockStatement",
+		"*ast.ExpressionStatement",
+		"*ast.StringLiteral",
+	}
+	if fmt.Sprint(visited) != fmt.Sprint(expected) {
+		t.Errorf("wrong nodes visited. want=%v, got=%v", expected, visited)
+	}
+}
+
+// depthVisitor records the nodes it visits, indented by their depth, which
+// it tracks with the calls of Visit(nil) at the end of every node.
+type depthVisitor struct {
+	depth   *int
+	visited *[]string
+}
+
+func (v depthVisitor) Visit(node Node) Visitor {
+	if node == nil {
+		*v.depth--
+		return nil
+	}
+	*v.visited = append(*v.visited, fmt.Sprintf("%*s%T", 2*(*v.depth), "", node))
+	*v.depth++
+	return v
+}
+
+func TestWalk(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{
+				Expression: &MatchExpression{
+					Subject: &Identifier{Value: "x"},
+					Cases: []*MatchCase{
+						{
+							Values: []Expression{&IntegerLiteral{Value: 1}, &IntegerLiteral{Value: 2}},
+							Body:   &BlockStatement{},
+						},
+					},
+				},
+			},
+			&LetStatement{Name: &Identifier{Value: "y"}, Value: &Null{}},
+		},
+	}
+
+	depth := 0
+	var visited []string
+	Walk(depthVisitor{&depth, &visited}, program)
+
+	expected := []string{
+		"*ast.Program",
+		"  *ast.ExpressionStatement",
+		"    *ast.MatchExpression",
+		"      *ast.Identifier",
+		"      *ast.IntegerLiteral",
+		"      *ast.IntegerLiteral",
+		"      *ast.BlockStatement",
+		"  *ast.LetStatement",
+		"    *ast.Identifier",
+		"    *ast.Null",
+	}
+	if fmt.Sprint(visited) != fmt.Sprint(expected) {
+		t.Errorf("wrong nodes visited.\nwant=%q\ngot=%q", expected, visited)
+	}
+	if depth != 0 {
+		t.Errorf("Visit(nil) wasn't called once per node. depth=%d", depth)
+	}
+}
+
+func TestFprint(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let", Position: token.Position{Line: 1, Column: 1}},
+				Name: &Identifier{
+					Token: token.Token{Type: token.IDENT, Literal: "x", Position: token.Position{Line: 1, Column: 5}},
+					Value: "x",
+				},
+				Value: &PrefixExpression{
+					Token:    token.Token{Type: token.MINUS, Literal: "-", Position: token.Position{Line: 1, Column: 9}},
+					Operator: "-",
+					Right: &IntegerLiteral{
+						Token: token.Token{Type: token.INT, Literal: "1", Position: token.Position{Line: 1, Column: 10}},
+						Value: 1,
+					},
+				},
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := Fprint(&out, program); err != nil {
+		t.Fatalf("Fprint failed: %s", err)
+	}
+	expected := `[1:1] Program let x = (-1);
+  [1:1] LetStatement let x = (-1);
+    [1:5] Identifier x
+    [1:9] PrefixExpression (-1)
+      [1:10] IntegerLiteral 1
+`
+	if out.String() != expected {
+		t.Errorf("wrong output.\nwant=%q\ngot=%q", expected, out.String())
+	}
+}
@@ -0,0 +1,538 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/token"
+)
+
+// Encode returns the JSON encoding of the AST rooted at node, for tools to
+// dump, inspect or pass the parse tree to other processes. Every node is an
+// object whose "node" field is its type, e.g. "InfixExpression", with its
+// token and its fields in lower camel case; missing nodes are null. What the
+// parser records for the evaluator, like the slots of identifiers, is kept, so
+// Decode returns an AST that can be evaluated.
+func Encode(node Node) ([]byte, error) {
+	return json.Marshal(encode(node))
+}
+
+// fields are the fields of a node encoded as a JSON object.
+type fields map[string]interface{}
+
+func encodeToken(tok token.Token) fields {
+	return fields{
+		"type":    tok.Type,
+		"literal": tok.Literal,
+		"line":    tok.Line,
+		"column":  tok.Column,
+		"offset":  tok.Offset,
+	}
+}
+
+func encodeList(nodes []Node) []interface{} {
+	list := make([]interface{}, len(nodes))
+	for i, node := range nodes {
+		list[i] = encode(node)
+	}
+	return list
+}
+
+func encodeStatements(stmts []Statement) []interface{} {
+	nodes := make([]Node, len(stmts))
+	for i, s := range stmts {
+		nodes[i] = s
+	}
+	return encodeList(nodes)
+}
+
+func encodeExpressions(exprs []Expression) []interface{} {
+	nodes := make([]Node, len(exprs))
+	for i, e := range exprs {
+		nodes[i] = e
+	}
+	return encodeList(nodes)
+}
+
+func encode(node Node) interface{} {
+	if isNil(node) {
+		return nil
+	}
+
+	var f fields
+	switch n := node.(type) {
+	case *Program:
+		return fields{
+			"node":       "Program",
+			"version":    n.Features.Version(),
+			"statements": encodeStatements(n.Statements),
+		}
+	case *LetStatement:
+		f = fields{"name": encode(n.Name), "type": encode(n.Type), "value": encode(n.Value)}
+	case *ReturnStatement:
+		f = fields{"returnValue": encode(n.ReturnValue)}
+	case *ExpressionStatement:
+		f = fields{"expression": encode(n.Expression)}
+	case *BlockStatement:
+		f = fields{"statements": encodeStatements(n.Statements), "names": n.Names}
+	case *Identifier:
+		f = fields{"value": n.Value, "skip": n.Skip, "slot": n.Slot}
+	case *TypeName:
+		f = fields{"name": n.Name}
+	case *IntegerLiteral:
+		f = fields{"value": n.Value}
+	case *FloatLiteral:
+		f = fields{"value": n.Value}
+	case *StringLiteral:
+		f = fields{"value": n.Value}
+	case *Boolean:
+		f = fields{"value": n.Value}
+	case *Null:
+		f = fields{}
+	case *PrefixExpression:
+		f = fields{"operator": n.Operator, "right": encode(n.Right)}
+	case *InfixExpression:
+		f = fields{"left": encode(n.Left), "operator": n.Operator, "right": encode(n.Right)}
+	case *AssignExpression:
+		f = fields{
+			"name":     encode(n.Name),
+			"index":    encode(n.Index),
+			"operator": n.Operator,
+			"value":    encode(n.Value),
+		}
+	case *IfExpression:
+		f = fields{
+			"condition":   encode(n.Condition),
+			"consequence": encode(n.Consequence),
+			"alternative": encode(n.Alternative),
+		}
+	case *WhileExpression:
+		f = fields{"condition": encode(n.Condition), "body": encode(n.Body)}
+	case *ForInExpression:
+		f = fields{
+			"key":      encode(n.Key),
+			"value":    encode(n.Value),
+			"iterable": encode(n.Iterable),
+			"body":     encode(n.Body),
+		}
+	case *MatchExpression:
+		cases := make([]interface{}, len(n.Cases))
+		for i, c := range n.Cases {
+			cases[i] = fields{
+				"token":  encodeToken(c.Token),
+				"values": encodeExpressions(c.Values),
+				"body":   encode(c.Body),
+			}
+		}
+		f = fields{"subject": encode(n.Subject), "cases": cases, "default": encode(n.Default)}
+	case *FunctionLiteral:
+		params := make([]Node, len(n.Parameters))
+		for i, p := range n.Parameters {
+			params[i] = p
+		}
+		types := make([]Node, len(n.ParameterTypes))
+		for i, t := range n.ParameterTypes {
+			types[i] = t
+		}
+		f = fields{
+			"name":           encode(n.Name),
+			"parameters":     encodeList(params),
+			"parameterTypes": encodeList(types),
+			"defaults":       encodeExpressions(n.Defaults),
+			"returnType":     encode(n.ReturnType),
+			"generator":      n.Generator,
+			"body":           encode(n.Body),
+		}
+	case *YieldExpression:
+		f = fields{"value": encode(n.Value)}
+	case *SpawnExpression:
+		f = fields{"function": encode(n.Function)}
+	case *CallExpression:
+		f = fields{"function": encode(n.Function), "arguments": encodeExpressions(n.Arguments)}
+	case *ArrayLiteral:
+		f = fields{"elements": encodeExpressions(n.Elements)}
+	case *IndexExpression:
+		f = fields{"left": encode(n.Left), "index": encode(n.Index)}
+	case *MemberExpression:
+		f = fields{"object": encode(n.Object), "property": encode(n.Property)}
+	case *HashLiteral:
+		pairs := make([]interface{}, len(n.Keys))
+		for i, key := range n.Keys {
+			pairs[i] = fields{"key": encode(key), "value": encode(n.Pairs[key])}
+		}
+		f = fields{"pairs": pairs}
+	default:
+		return fields{"node": fmt.Sprintf("%T", node)}
+	}
+
+	f["node"] = nodeName(node)
+	f["token"] = encodeToken(tokenOf(node))
+	return f
+}
+
+// nodeName returns the name of the type of node, without the package.
+func nodeName(node Node) string {
+	name := fmt.Sprintf("%T", node)
+	return name[len("*ast."):]
+}
+
+// tokenOf returns the token of node.
+func tokenOf(node Node) token.Token {
+	switch n := node.(type) {
+	case *LetStatement:
+		return n.Token
+	case *ReturnStatement:
+		return n.Token
+	case *ExpressionStatement:
+		return n.Token
+	case *BlockStatement:
+		return n.Token
+	case *Identifier:
+		return n.Token
+	case *TypeName:
+		return n.Token
+	case *IntegerLiteral:
+		return n.Token
+	case *FloatLiteral:
+		return n.Token
+	case *StringLiteral:
+		return n.Token
+	case *Boolean:
+		return n.Token
+	case *Null:
+		return n.Token
+	case *PrefixExpression:
+		return n.Token
+	case *InfixExpression:
+		return n.Token
+	case *AssignExpression:
+		return n.Token
+	case *IfExpression:
+		return n.Token
+	case *WhileExpression:
+		return n.Token
+	case *ForInExpression:
+		return n.Token
+	case *MatchExpression:
+		return n.Token
+	case *FunctionLiteral:
+		return n.Token
+	case *YieldExpression:
+		return n.Token
+	case *SpawnExpression:
+		return n.Token
+	case *CallExpression:
+		return n.Token
+	case *ArrayLiteral:
+		return n.Token
+	case *IndexExpression:
+		return n.Token
+	case *MemberExpression:
+		return n.Token
+	case *HashLiteral:
+		return n.Token
+	}
+	return token.Token{}
+}
+
+// Decode returns the AST encoded in data by Encode.
+func Decode(data []byte) (Node, error) {
+	d := &decoder{}
+	node := d.node(data)
+	if d.err != nil {
+		return nil, d.err
+	}
+	return node, nil
+}
+
+// decoder decodes nodes, and keeps the first error, after which it returns
+// zero values.
+type decoder struct {
+	err error
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("ast: "+format, a...)
+	}
+}
+
+// object decodes a JSON object into its fields. It returns nil for null.
+func (d *decoder) object(data json.RawMessage) map[string]json.RawMessage {
+	var f map[string]json.RawMessage
+	if d.err == nil && len(data) > 0 {
+		if err := json.Unmarshal(data, &f); err != nil {
+			d.fail("%s", err)
+		}
+	}
+	return f
+}
+
+func (d *decoder) list(data json.RawMessage) []json.RawMessage {
+	var list []json.RawMessage
+	if d.err == nil && len(data) > 0 {
+		if err := json.Unmarshal(data, &list); err != nil {
+			d.fail("%s", err)
+		}
+	}
+	return list
+}
+
+// value decodes a JSON value into v, which points to a Go value.
+func (d *decoder) value(data json.RawMessage, v interface{}) {
+	if d.err == nil && len(data) > 0 {
+		if err := json.Unmarshal(data, v); err != nil {
+			d.fail("%s", err)
+		}
+	}
+}
+
+func (d *decoder) string(data json.RawMessage) string {
+	var s string
+	d.value(data, &s)
+	return s
+}
+
+func (d *decoder) int(data json.RawMessage) int {
+	var n int
+	d.value(data, &n)
+	return n
+}
+
+func (d *decoder) token(data json.RawMessage) token.Token {
+	f := d.object(data)
+	return token.Token{
+		Type:     token.TokenType(d.string(f["type"])),
+		Literal:  d.string(f["literal"]),
+		Position: token.Position{Line: d.int(f["line"]), Column: d.int(f["column"])},
+		Offset:   d.int(f["offset"]),
+	}
+}
+
+func (d *decoder) statements(data json.RawMessage) []Statement {
+	stmts := []Statement{}
+	for _, item := range d.list(data) {
+		stmt, ok := d.node(item).(Statement)
+		if !ok {
+			d.fail("statement expected")
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+func (d *decoder) expressions(data json.RawMessage) []Expression {
+	exprs := []Expression{}
+	for _, item := range d.list(data) {
+		exprs = append(exprs, d.expression(item))
+	}
+	return exprs
+}
+
+func (d *decoder) expression(data json.RawMessage) Expression {
+	node := d.node(data)
+	if node == nil {
+		return nil
+	}
+	expr, ok := node.(Expression)
+	if !ok {
+		d.fail("expression expected, got %s", nodeName(node))
+	}
+	return expr
+}
+
+func (d *decoder) identifier(data json.RawMessage) *Identifier {
+	ident, _ := d.expect(data, "Identifier").(*Identifier)
+	return ident
+}
+
+func (d *decoder) typeName(data json.RawMessage) *TypeName {
+	name, _ := d.expect(data, "TypeName").(*TypeName)
+	return name
+}
+
+func (d *decoder) block(data json.RawMessage) *BlockStatement {
+	block, _ := d.expect(data, "BlockStatement").(*BlockStatement)
+	return block
+}
+
+// expect decodes a node of the type called name, or null.
+func (d *decoder) expect(data json.RawMessage, name string) Node {
+	node := d.node(data)
+	if node != nil && nodeName(node) != name {
+		d.fail("%s expected, got %s", name, nodeName(node))
+		return nil
+	}
+	return node
+}
+
+// node decodes a node. It returns nil for null.
+func (d *decoder) node(data json.RawMessage) Node {
+	f := d.object(data)
+	if f == nil {
+		return nil
+	}
+	tok := d.token(f["token"])
+
+	switch name := d.string(f["node"]); name {
+	case "Program":
+		var version lang.Version
+		d.value(f["version"], &version)
+		return &Program{Statements: d.statements(f["statements"]), Features: lang.For(version)}
+	case "LetStatement":
+		return &LetStatement{
+			Token: tok,
+			Name:  d.identifier(f["name"]),
+			Type:  d.typeName(f["type"]),
+			Value: d.expression(f["value"]),
+		}
+	case "ReturnStatement":
+		return &ReturnStatement{Token: tok, ReturnValue: d.expression(f["returnValue"])}
+	case "ExpressionStatement":
+		return &ExpressionStatement{Token: tok, Expression: d.expression(f["expression"])}
+	case "BlockStatement":
+		block := &BlockStatement{Token: tok, Statements: d.statements(f["statements"])}
+		d.value(f["names"], &block.Names)
+		return block
+	case "Identifier":
+		return &Identifier{
+			Token: tok,
+			Value: d.string(f["value"]),
+			Skip:  d.int(f["skip"]),
+			Slot:  d.int(f["slot"]),
+		}
+	case "TypeName":
+		return &TypeName{Token: tok, Name: d.string(f["name"])}
+	case "IntegerLiteral":
+		lit := &IntegerLiteral{Token: tok}
+		d.value(f["value"], &lit.Value)
+		return lit
+	case "FloatLiteral":
+		lit := &FloatLiteral{Token: tok}
+		d.value(f["value"], &lit.Value)
+		return lit
+	case "StringLiteral":
+		return &StringLiteral{Token: tok, Value: d.string(f["value"])}
+	case "Boolean":
+		b := &Boolean{Token: tok}
+		d.value(f["value"], &b.Value)
+		return b
+	case "Null":
+		return &Null{Token: tok}
+	case "PrefixExpression":
+		return &PrefixExpression{
+			Token:    tok,
+			Operator: d.string(f["operator"]),
+			Right:    d.expression(f["right"]),
+		}
+	case "InfixExpression":
+		return &InfixExpression{
+			Token:    tok,
+			Left:     d.expression(f["left"]),
+			Operator: d.string(f["operator"]),
+			Right:    d.expression(f["right"]),
+		}
+	case "AssignExpression":
+		index, _ := d.expect(f["index"], "IndexExpression").(*IndexExpression)
+		return &AssignExpression{
+			Token:    tok,
+			Name:     d.identifier(f["name"]),
+			Index:    index,
+			Operator: d.string(f["operator"]),
+			Value:    d.expression(f["value"]),
+		}
+	case "IfExpression":
+		return &IfExpression{
+			Token:       tok,
+			Condition:   d.expression(f["condition"]),
+			Consequence: d.block(f["consequence"]),
+			Alternative: d.block(f["alternative"]),
+		}
+	case "WhileExpression":
+		return &WhileExpression{
+			Token:     tok,
+			Condition: d.expression(f["condition"]),
+			Body:      d.block(f["body"]),
+		}
+	case "ForInExpression":
+		return &ForInExpression{
+			Token:    tok,
+			Key:      d.identifier(f["key"]),
+			Value:    d.identifier(f["value"]),
+			Iterable: d.expression(f["iterable"]),
+			Body:     d.block(f["body"]),
+		}
+	case "MatchExpression":
+		match := &MatchExpression{
+			Token:   tok,
+			Subject: d.expression(f["subject"]),
+			Default: d.block(f["default"]),
+		}
+		for _, item := range d.list(f["cases"]) {
+			c := d.object(item)
+			match.Cases = append(match.Cases, &MatchCase{
+				Token:  d.token(c["token"]),
+				Values: d.expressions(c["values"]),
+				Body:   d.block(c["body"]),
+			})
+		}
+		return match
+	case "FunctionLiteral":
+		fn := &FunctionLiteral{
+			Token:      tok,
+			Name:       d.identifier(f["name"]),
+			Parameters: []*Identifier{},
+			ReturnType: d.typeName(f["returnType"]),
+			Body:       d.block(f["body"]),
+		}
+		for _, item := range d.list(f["parameters"]) {
+			fn.Parameters = append(fn.Parameters, d.identifier(item))
+		}
+		for _, item := range d.list(f["parameterTypes"]) {
+			fn.ParameterTypes = append(fn.ParameterTypes, d.typeName(item))
+		}
+		for _, item := range d.list(f["defaults"]) {
+			fn.Defaults = append(fn.Defaults, d.expression(item))
+		}
+		d.value(f["generator"], &fn.Generator)
+		return fn
+	case "YieldExpression":
+		return &YieldExpression{Token: tok, Value: d.expression(f["value"])}
+	case "SpawnExpression":
+		fn, _ := d.expect(f["function"], "FunctionLiteral").(*FunctionLiteral)
+		return &SpawnExpression{Token: tok, Function: fn}
+	case "CallExpression":
+		return &CallExpression{
+			Token:     tok,
+			Function:  d.expression(f["function"]),
+			Arguments: d.expressions(f["arguments"]),
+		}
+	case "ArrayLiteral":
+		return &ArrayLiteral{Token: tok, Elements: d.expressions(f["elements"])}
+	case "IndexExpression":
+		return &IndexExpression{
+			Token: tok,
+			Left:  d.expression(f["left"]),
+			Index: d.expression(f["index"]),
+		}
+	case "MemberExpression":
+		return &MemberExpression{
+			Token:    tok,
+			Object:   d.expression(f["object"]),
+			Property: d.identifier(f["property"]),
+		}
+	case "HashLiteral":
+		hash := &HashLiteral{Token: tok, Pairs: map[Expression]Expression{}}
+		for _, item := range d.list(f["pairs"]) {
+			pair := d.object(item)
+			key := d.expression(pair["key"])
+			hash.Pairs[key] = d.expression(pair["value"])
+			hash.Keys = append(hash.Keys, key)
+		}
+		return hash
+	default:
+		d.fail("unknown node %q", name)
+		return nil
+	}
+}
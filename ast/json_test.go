@@ -0,0 +1,126 @@
+package ast
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/token"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	ident := func(name string, slot int) *Identifier {
+		return &Identifier{
+			Token: token.Token{Type: token.IDENT, Literal: name, Position: token.Position{Line: 1, Column: 5}},
+			Value: name,
+			Slot:  slot,
+		}
+	}
+	program := &Program{
+		Features: lang.For(2),
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let", Position: token.Position{Line: 1, Column: 1}},
+				Name:  ident("f", 0),
+				Value: &FunctionLiteral{
+					Token:          token.Token{Type: token.FUNCTION, Literal: "fn", Offset: 8},
+					Parameters:     []*Identifier{ident("x", 1)},
+					ParameterTypes: []*TypeName{{Token: token.Token{Type: token.IDENT, Literal: "Int"}, Name: "Int"}},
+					Body: &BlockStatement{
+						Token: token.Token{Type: token.LBRACE, Literal: "{"},
+						Names: []string{"x"},
+						Statements: []Statement{
+							&ExpressionStatement{
+								Token: token.Token{Type: token.IF, Literal: "if"},
+								Expression: &IfExpression{
+									Token: token.Token{Type: token.IF, Literal: "if"},
+									Condition: &InfixExpression{
+										Token:    token.Token{Type: token.GT, Literal: ">"},
+										Left:     ident("x", 1),
+										Operator: ">",
+										Right:    &FloatLiteral{Token: token.Token{Type: token.FLOAT, Literal: "1.5"}, Value: 1.5},
+									},
+									Consequence: &BlockStatement{
+										Token: token.Token{Type: token.LBRACE, Literal: "{"},
+										Statements: []Statement{
+											&ReturnStatement{
+												Token: token.Token{Type: token.RETURN, Literal: "return"},
+												ReturnValue: &HashLiteral{
+													Token: token.Token{Type: token.LBRACE, Literal: "{"},
+													Pairs: map[Expression]Expression{},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	hash := program.Statements[0].(*LetStatement).Value.(*FunctionLiteral).Body.Statements[0].(*ExpressionStatement).
+		Expression.(*IfExpression).Consequence.Statements[0].(*ReturnStatement).ReturnValue.(*HashLiteral)
+	key := &StringLiteral{Token: token.Token{Type: token.STRING, Literal: "a"}, Value: "a"}
+	hash.Keys = []Expression{key}
+	hash.Pairs[key] = &ArrayLiteral{
+		Token:    token.Token{Type: token.LBRACKET, Literal: "["},
+		Elements: []Expression{&Null{Token: token.Token{Type: token.NULL, Literal: "null"}}},
+	}
+
+	data, err := Encode(program)
+	if err != nil {
+		t.Fatalf("Encode failed: %s", err)
+	}
+	node, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %s", err)
+	}
+	decoded, ok := node.(*Program)
+	if !ok {
+		t.Fatalf("node is not *Program. got=%T", node)
+	}
+	if decoded.Features != program.Features {
+		t.Errorf("wrong features. want=%v, got=%v", program.Features.Version(), decoded.Features.Version())
+	}
+	if decoded.String() != program.String() {
+		t.Errorf("wrong program. want=%q, got=%q", program.String(), decoded.String())
+	}
+	// Encoding the decoded program again gives the same JSON, so nothing,
+	// not even positions and slots, was lost.
+	again, err := Encode(decoded)
+	if err != nil {
+		t.Fatalf("Encode failed: %s", err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("wrong encoding of the decoded program.\nwant=%s\ngot=%s", data, again)
+	}
+	fn := decoded.Statements[0].(*LetStatement).Value.(*FunctionLiteral)
+	if !reflect.DeepEqual(fn.Parameters[0], ident("x", 1)) {
+		t.Errorf("wrong parameter. got=%+v", fn.Parameters[0])
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"node": "Bogus"}`, `ast: unknown node "Bogus"`},
+		{`{"node": "Program", "statements": [{"node": "Identifier"}]}`, "ast: statement expected"},
+		{`{"node": "ExpressionStatement", "expression": {"node": "LetStatement"}}`,
+			"ast: expression expected, got LetStatement"},
+		{`{"node": "IfExpression", "consequence": {"node": "Null"}}`,
+			"ast: BlockStatement expected, got Null"},
+		{`[1]`, "ast: json: cannot unmarshal array"},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode([]byte(tt.input))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("wrong error for %s. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// printWidth is the number of characters of the source of a node Fprint
+// writes at most.
+const printWidth = 60
+
+// Fprint writes the AST rooted at node to w, one node per line, indented by
+// its depth, with its position, its type and its source, e.g.
+//
+//	[1:1] LetStatement let double = fn(x) (x * 2);
+//	  [1:5] Identifier double
+//	  [1:14] FunctionLiteral fn(x) (x * 2)
+//
+// It's meant for people learning how programs are parsed and for bug
+// reports, not for tools: use Encode for those.
+func Fprint(w io.Writer, node Node) error {
+	var err error
+	Walk(&printer{w: w, err: &err}, node)
+	return err
+}
+
+// printer is the Visitor of Fprint. The visitor of the children of a node is
+// a copy of the printer of the node, one level deeper. They keep the first
+// error writing to w in err.
+type printer struct {
+	w     io.Writer
+	depth int
+	err   *error
+}
+
+func (p *printer) Visit(node Node) Visitor {
+	if node == nil || *p.err != nil {
+		return nil
+	}
+	src := strings.Join(strings.Fields(node.String()), " ")
+	if len(src) > printWidth {
+		src = src[:printWidth-3] + "..."
+	}
+	pos := node.Pos()
+	_, *p.err = fmt.Fprintf(p.w, "%s[%d:%d] %s %s\n", strings.Repeat("  ", p.depth),
+		pos.Line, pos.Column, nodeName(node), src)
+	return &printer{w: p.w, depth: p.depth + 1, err: p.err}
+}
@@ -0,0 +1,149 @@
+package ast
+
+// A Visitor's Visit method is called for every node Walk visits. If it
+// returns a visitor w, Walk visits the children of the node with w, and then
+// calls w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the AST rooted at node in depth-first order, like the
+// function of the same name in go/ast: it calls v.Visit(node), and then
+// walks every child of node with the visitor it returned, unless it was nil.
+// Missing children, e.g. the alternative of an if without an else, are
+// skipped.
+func Walk(v Visitor, node Node) {
+	if isNil(node) {
+		return
+	}
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			Walk(v, s)
+		}
+	case *LetStatement:
+		Walk(v, n.Name)
+		Walk(v, n.Type)
+		Walk(v, n.Value)
+	case *ReturnStatement:
+		Walk(v, n.ReturnValue)
+	case *ExpressionStatement:
+		Walk(v, n.Expression)
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			Walk(v, s)
+		}
+	case *PrefixExpression:
+		Walk(v, n.Right)
+	case *InfixExpression:
+		Walk(v, n.Left)
+		Walk(v, n.Right)
+	case *AssignExpression:
+		Walk(v, n.Name)
+		Walk(v, n.Index)
+		Walk(v, n.Value)
+	case *IfExpression:
+		Walk(v, n.Condition)
+		Walk(v, n.Consequence)
+		Walk(v, n.Alternative)
+	case *WhileExpression:
+		Walk(v, n.Condition)
+		Walk(v, n.Body)
+	case *ForInExpression:
+		Walk(v, n.Key)
+		Walk(v, n.Value)
+		Walk(v, n.Iterable)
+		Walk(v, n.Body)
+	case *MatchExpression:
+		Walk(v, n.Subject)
+		for _, c := range n.Cases {
+			for _, value := range c.Values {
+				Walk(v, value)
+			}
+			Walk(v, c.Body)
+		}
+		Walk(v, n.Default)
+	case *FunctionLiteral:
+		Walk(v, n.Name)
+		for i, p := range n.Parameters {
+			Walk(v, p)
+			if i < len(n.ParameterTypes) {
+				Walk(v, n.ParameterTypes[i])
+			}
+			Walk(v, n.Default(i))
+		}
+		Walk(v, n.ReturnType)
+		Walk(v, n.Body)
+	case *CallExpression:
+		Walk(v, n.Function)
+		for _, a := range n.Arguments {
+			Walk(v, a)
+		}
+	case *ArrayLiteral:
+		for _, el := range n.Elements {
+			Walk(v, el)
+		}
+	case *IndexExpression:
+		Walk(v, n.Left)
+		Walk(v, n.Index)
+	case *MemberExpression:
+		Walk(v, n.Object)
+		Walk(v, n.Property)
+	case *HashLiteral:
+		for _, key := range n.Keys {
+			Walk(v, key)
+			Walk(v, n.Pairs[key])
+		}
+	case *YieldExpression:
+		Walk(v, n.Value)
+	case *SpawnExpression:
+		Walk(v, n.Function)
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if node != nil && f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the AST rooted at node in depth-first order: it calls
+// f(node), and then Inspect on every child of node if f returned true. Unlike
+// the function of the same name in go/ast, it doesn't call f(nil) after the
+// children.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// isNil reports whether node is nil, including nil pointers to nodes, which
+// the parser leaves behind for missing parts.
+func isNil(node Node) bool {
+	switch n := node.(type) {
+	case nil:
+		return true
+	case *LetStatement:
+		return n == nil
+	case *ReturnStatement:
+		return n == nil
+	case *ExpressionStatement:
+		return n == nil
+	case *BlockStatement:
+		return n == nil
+	case *Identifier:
+		return n == nil
+	case *IndexExpression:
+		return n == nil
+	case *TypeName:
+		return n == nil
+	}
+	return false
+}
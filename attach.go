@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+// attach implements `hou attach`, the client of the inspector of embedded
+// interpreters, see interp.ServeInspector. It runs the command, or the
+// commands typed in, e.g. `stacks`.
+func attach(args []string) int {
+	fs := flag.NewFlagSet("attach", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: hou attach socket [command]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+	conn, err := textproto.Dial("unix", fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hou attach: %s\n", err)
+		return 1
+	}
+	defer conn.Close()
+
+	send := func(command string) bool {
+		err := conn.PrintfLine("%s", command)
+		var answer []string
+		if err == nil {
+			answer, err = conn.ReadDotLines()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hou attach: %s\n", err)
+			return false
+		}
+		for _, line := range answer {
+			fmt.Println(line)
+		}
+		return true
+	}
+
+	if fs.NArg() > 1 {
+		if !send(strings.Join(fs.Args()[1:], " ")) {
+			return 1
+		}
+		return 0
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("(attach) ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return 0
+		}
+		if !send(scanner.Text()) {
+			return 1
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cedrickchee/hou/benchmarks"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/object"
+	"github.com/cedrickchee/hou/repl"
+)
+
+// bench implements `hou bench`, which times a script. It runs the script
+// --count times and reports to stderr the wall time, the allocations and the
+// nodes evaluated per run, see package benchmarks.
+func bench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	version := lang.Default
+	fs.Var(&version, "lang", "`version` of the language of scripts without a version pragma")
+	engine := fs.String("engine", "eval", "engine running the script: eval, the tree-walker, or vm, the bytecode virtual machine")
+	count := fs.Int("count", 1, "number of times to run the script")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: hou bench [--engine=eval|vm] [--lang=n] [--count=n] script.hou\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if *count < 1 {
+		fmt.Fprintln(os.Stderr, "hou bench: --count must be at least 1")
+		return 2
+	}
+	var e *benchmarks.Engine
+	for i := range benchmarks.Engines {
+		if benchmarks.Engines[i].Name == *engine {
+			e = &benchmarks.Engines[i]
+		}
+	}
+	if e == nil {
+		fmt.Fprintf(os.Stderr, "hou bench: unknown engine %q\n", *engine)
+		return 2
+	}
+
+	filename := fs.Arg(0)
+	program, src, ok := parseFile("bench", filename, diagnostic.Text, version)
+	if !ok {
+		return 1
+	}
+
+	// The nodes are counted in a first run, which also warms up, and stops
+	// scripts that fail before they're timed.
+	nodes, result := benchmarks.CountNodes(program)
+	if err, ok := result.(*object.Error); ok {
+		fmt.Fprintf(os.Stderr, "%s: ", filename)
+		repl.PrintError(os.Stderr, src, err, repl.DefaultMaxFrames)
+		return 1
+	}
+
+	r := benchmarks.MeasureRuns(filename, program, *e, *count)
+	fmt.Fprintf(os.Stderr, "%s: %d runs on %s\n", filename, r.Ops, r.Engine)
+	fmt.Fprintf(os.Stderr, "wall time    %v/run\n", r.Duration/time.Duration(r.Ops))
+	fmt.Fprintf(os.Stderr, "allocations  %d/run, %d B/run\n", r.AllocsPerOp, r.BytesPerOp)
+	fmt.Fprintf(os.Stderr, "nodes        %d/run\n", nodes)
+	return 0
+}
@@ -0,0 +1,302 @@
+package benchmarks
+
+// Package benchmarks implements a small benchmark harness that runs a set of
+// standard workloads under the available execution engines and reports how
+// many operations per second each engine achieves, how much it allocates and
+// how many nodes the tree-walker evaluates. It's meant to catch performance
+// regressions in local runs, either through `go test -bench . ./benchmarks`,
+// by calling Run directly, or on any script with `hou bench`.
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/compiler"
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/lexer"
+	"github.com/cedrickchee/hou/object"
+	"github.com/cedrickchee/hou/parser"
+	"github.com/cedrickchee/hou/vm"
+)
+
+// Workload is a named Hou program used for benchmarking together with the
+// Inspect() output it's expected to produce.
+type Workload struct {
+	Name     string
+	Input    string
+	Expected string
+}
+
+// Workloads is the standard set of workloads. Since Hou has no loop construct
+// the "loops" are written as tail calls of recursive functions.
+var Workloads = []Workload{
+	{
+		Name: "fib",
+		Input: `
+let fib = fn(n) {
+	if (n < 2) { n } else { fib(n - 1) + fib(n - 2) }
+};
+fib(20);
+`,
+		Expected: "6765",
+	},
+	{
+		Name: "nested-closures",
+		Input: `
+let compose = fn(f, g) {
+	fn(x) {
+		fn() { f(g(x)) }()
+	}
+};
+let inc = compose(fn(x) { x + 1 }, fn(x) { x * 1 });
+let count = fn(n, acc) {
+	if (n == 0) { acc } else { count(n - 1, inc(acc)) }
+};
+count(500, 0);
+`,
+		Expected: "500",
+	},
+	{
+		Name: "closures",
+		Input: `
+let counter = fn() {
+	let n = 0;
+	fn() { n += 1; n }
+};
+let run = fn(i, next, acc) {
+	if (i == 0) { acc } else { run(i - 1, next, acc + next()) }
+};
+run(500, counter(), 0);
+`,
+		Expected: "125250",
+	},
+	{
+		Name: "loop-sum",
+		Input: `
+let sum = fn(n, acc) {
+	if (n == 0) { acc } else { sum(n - 1, acc + n) }
+};
+sum(1000, 0);
+`,
+		Expected: "500500",
+	},
+	{
+		Name: "arithmetic",
+		Input: `
+let poly = fn(n, acc) {
+	if (n == 0) { acc } else { poly(n - 1, acc + 3 * n * n - 2 * n + n / 2 - 7) }
+};
+poly(1000, 0);
+`,
+		Expected: "1000742500",
+	},
+	{
+		Name: "string-building",
+		Input: `
+let build = fn(n, s) {
+	if (n == 0) { s } else { build(n - 1, s + "hou") }
+};
+len(build(500, ""));
+`,
+		Expected: "1500",
+	},
+	{
+		Name: "string-builder",
+		Input: `
+let repeat = fn(n, b) {
+	if (n == 0) { b } else { repeat(n - 1, append(b, "hou")) }
+};
+len(build(repeat(500, strBuilder())));
+`,
+		Expected: "1500",
+	},
+	{
+		Name: "array-building",
+		Input: `
+let range = fn(n, acc) {
+	if (n == 0) { acc } else { range(n - 1, push(acc, n)) }
+};
+len(range(1000, []));
+`,
+		Expected: "1000",
+	},
+	{
+		Name: "hash-churn",
+		Input: `
+let churn = fn(n, acc) {
+	if (n == 0) {
+		acc
+	} else {
+		let h = {"a": n, "b": n * 2, n: "n", true: [n]};
+		churn(n - 1, acc + h["a"] + h["b"] + len(h[true]))
+	}
+};
+churn(500, 0);
+`,
+		Expected: "376250",
+	},
+}
+
+// Engine is an execution engine that can run a parsed program and return the
+// resulting object.
+type Engine struct {
+	Name string
+	Run  func(program *ast.Program) object.Object
+}
+
+// Engines is the list of engines every workload is run under.
+var Engines = []Engine{
+	{
+		Name: "eval",
+		Run: func(program *ast.Program) object.Object {
+			return evaluator.Eval(program, evaluator.NewEnvironment(program))
+		},
+	},
+	{
+		Name: "vm",
+		Run: func(program *ast.Program) object.Object {
+			bytecode, err := compiler.Compile(program)
+			if err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+			return vm.New(bytecode, evaluator.New()).Run()
+		},
+	},
+}
+
+// Result holds the measurements of running a workload under an engine.
+type Result struct {
+	Workload    string
+	Engine      string
+	Ops         int           // number of times the workload was run
+	Duration    time.Duration // total time spent running the workload
+	AllocsPerOp uint64        // heap allocations per run
+	BytesPerOp  uint64        // heap bytes allocated per run
+	NodesPerOp  uint64        // nodes the tree-walker evaluates per run
+}
+
+// OpsPerSec returns the number of workload runs per second.
+func (r Result) OpsPerSec() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Ops) / r.Duration.Seconds()
+}
+
+// String returns a single line report of the result.
+func (r Result) String() string {
+	return fmt.Sprintf("%-16s %-6s %8d ops %12.2f ops/sec %10d allocs/op %12d B/op %10d nodes/op",
+		r.Workload, r.Engine, r.Ops, r.OpsPerSec(), r.AllocsPerOp, r.BytesPerOp, r.NodesPerOp)
+}
+
+// Parse parses the workload's input and returns the program.
+func Parse(w Workload) (*ast.Program, error) {
+	l := lexer.New(w.Input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("workload %s: parser errors: %v", w.Name, p.Errors())
+	}
+	return program, nil
+}
+
+// CountNodes evaluates the program with the tree-walker and returns the
+// number of nodes it evaluated and the result.
+func CountNodes(program *ast.Program) (uint64, object.Object) {
+	var nodes uint64
+	e := evaluator.New()
+	e.Hooks = &evaluator.Hooks{
+		EnterNode: func(ast.Node) { nodes++ },
+	}
+	result := e.Eval(program, evaluator.NewEnvironment(program))
+	return nodes, result
+}
+
+// Measure runs the workload repeatedly under the engine for at least the given
+// duration and returns the measurements. Parsing happens once up front and
+// isn't part of the measurement.
+func Measure(w Workload, e Engine, d time.Duration) (Result, error) {
+	program, err := Parse(w)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Warm up once and make sure the engine computes the right thing, a fast
+	// but wrong engine is not interesting.
+	if got := e.Run(program); got == nil || got.Inspect() != w.Expected {
+		return Result{}, fmt.Errorf("workload %s: engine %s returned %v, want %s",
+			w.Name, e.Name, got, w.Expected)
+	}
+	nodes, _ := CountNodes(program)
+
+	r := measure(program, e, func(ops int, elapsed time.Duration) bool {
+		return elapsed < d
+	})
+	r.Workload = w.Name
+	r.NodesPerOp = nodes
+	return r, nil
+}
+
+// MeasureRuns runs the program n times under the engine and returns the
+// measurements, named after the script. Unlike Measure, it doesn't warm up
+// or count nodes, so that a script with side effects runs exactly n times.
+func MeasureRuns(script string, program *ast.Program, e Engine, n int) Result {
+	r := measure(program, e, func(ops int, elapsed time.Duration) bool {
+		return ops < n
+	})
+	r.Workload = script
+	return r
+}
+
+// measure runs the program under the engine for as long as more returns true
+// for the number of runs and the time they took so far, at least once.
+func measure(
+	program *ast.Program,
+	e Engine,
+	more func(ops int, elapsed time.Duration) bool,
+) Result {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	ops := 0
+	start := time.Now()
+	for ops == 0 || more(ops, time.Since(start)) {
+		e.Run(program)
+		ops++
+	}
+	elapsed := time.Since(start)
+
+	runtime.ReadMemStats(&after)
+
+	return Result{
+		Engine:      e.Name,
+		Ops:         ops,
+		Duration:    elapsed,
+		AllocsPerOp: (after.Mallocs - before.Mallocs) / uint64(ops),
+		BytesPerOp:  (after.TotalAlloc - before.TotalAlloc) / uint64(ops),
+	}
+}
+
+// Run measures every workload under every engine and writes a report line per
+// measurement to out.
+func Run(out io.Writer, d time.Duration) ([]Result, error) {
+	var results []Result
+
+	for _, w := range Workloads {
+		for _, e := range Engines {
+			r, err := Measure(w, e, d)
+			if err != nil {
+				return results, err
+			}
+			results = append(results, r)
+			fmt.Fprintln(out, r)
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,101 @@
+package benchmarks
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestWorkloads(t *testing.T) {
+	for _, w := range Workloads {
+		program, err := Parse(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, e := range Engines {
+			got := e.Run(program)
+			if got == nil {
+				t.Errorf("workload %s: engine %s returned nil", w.Name, e.Name)
+				continue
+			}
+			if got.Inspect() != w.Expected {
+				t.Errorf("workload %s: engine %s returned %s, want %s",
+					w.Name, e.Name, got.Inspect(), w.Expected)
+			}
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	results, err := Run(ioutil.Discard, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != len(Workloads)*len(Engines) {
+		t.Fatalf("wrong number of results. got=%d, want=%d",
+			len(results), len(Workloads)*len(Engines))
+	}
+
+	for _, r := range results {
+		if r.Ops < 1 {
+			t.Errorf("workload %s: engine %s didn't run", r.Workload, r.Engine)
+		}
+	}
+}
+
+func TestMeasureRuns(t *testing.T) {
+	program, err := Parse(Workloads[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range Engines {
+		r := MeasureRuns("fib.hou", program, e, 3)
+		if r.Ops != 3 || r.Workload != "fib.hou" || r.Engine != e.Name {
+			t.Errorf("engine %s: wrong result %+v", e.Name, r)
+		}
+	}
+}
+
+func TestCountNodes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected uint64
+	}{
+		// The program, the statement, the infix expression and its operands.
+		{"1 + 2", 5},
+		// The let statement and the function, then the statement, the call,
+		// the identifier, the body and its statement and integer, twice.
+		{"let f = fn() { 1 }; f(); f()", 1 + 2 + 2*6},
+	}
+
+	for _, tt := range tests {
+		program, err := Parse(Workload{Name: "test", Input: tt.input})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := CountNodes(program); got != tt.expected {
+			t.Errorf("wrong number of nodes for %q. got=%d, want=%d", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func BenchmarkWorkloads(b *testing.B) {
+	for _, w := range Workloads {
+		program, err := Parse(w)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for _, e := range Engines {
+			b.Run(w.Name+"/"+e.Name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					e.Run(program)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cedrickchee/hou/compiler"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/transpiler"
+)
+
+// build implements `hou build`, which compiles a script ahead of time. It
+// writes the bytecode to a .houc file, which hou run runs on the virtual
+// machine without parsing and compiling the script again, see
+// compiler.Encode. With --native, it translates the script to Go and compiles
+// it to a standalone binary instead, see package transpiler.
+func build(args []string) int {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	native := fs.Bool("native", false, "translate the script to Go and compile it to a standalone binary")
+	output := fs.String("o", "", "output file (default: the script name with extension .houc, or without extension with --native)")
+	houRoot := fs.String("hou-root", os.Getenv("HOUROOT"), "directory of the Hou source the binary is linked against")
+	diagnostics := fs.String("diagnostics", "text", "format of the errors: text or json")
+	noOpt := fs.Bool("no-opt", false, "don't optimize the bytecode, for debugging")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: hou build [--native] [--no-opt] [-o output] [--diagnostics=text|json] script.hou\n")
+		fs.PrintDefaults()
+	}
+	// The flags may also come after the script, e.g.
+	// `hou build script.hou -o script.houc`.
+	fs.Parse(args)
+	var scripts []string
+	for fs.NArg() > 0 {
+		scripts = append(scripts, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+
+	if len(scripts) != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	format, err := diagnostic.ParseFormat(*diagnostics)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hou build: %s\n", err)
+		return 2
+	}
+
+	filename := scripts[0]
+	if *output == "" {
+		*output = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+		if !*native {
+			*output += ".houc"
+		}
+	}
+
+	program, _, ok := parseFile("build", filename, format, lang.Default)
+	if !ok {
+		return 1
+	}
+
+	if !*native {
+		c := compiler.New()
+		c.Optimize = !*noOpt
+		bytecode, err := c.Compile(program)
+		var data []byte
+		if err == nil {
+			data, err = compiler.Encode(bytecode)
+		}
+		if err == nil {
+			err = ioutil.WriteFile(*output, data, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hou build: %s\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	src, err := transpiler.Transpile(program)
+	if err == nil {
+		err = transpiler.Build(src, *output, *houRoot)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hou build: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,100 @@
+package builtinerr
+
+// Package builtinerr builds the errors builtins return for bad arguments and
+// failed operations. Going through these helpers instead of formatting the
+// messages by hand keeps the errors of all builtins alike, in wording and in
+// their diagnostic codes, as the library of builtins grows.
+//
+// The errors don't carry positions themselves: the evaluator stamps them with
+// the position of the call when a builtin returns them, like all errors.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// Variadic is the max of ArgCount for builtins that take any number of
+// arguments from min on.
+const Variadic = -1
+
+// ArgCount returns an error if the builtin was called with fewer than min or
+// more than max arguments, and nil otherwise, e.g.
+//
+//	if err := builtinerr.ArgCount(args, 1, 2); err != nil {
+//		return err
+//	}
+func ArgCount(args []object.Object, min, max int) *object.Error {
+	n := len(args)
+	if n >= min && (max == Variadic || n <= max) {
+		return nil
+	}
+
+	var want string
+	switch {
+	case max == Variadic:
+		want = fmt.Sprintf("%d or more", min)
+	case max == min:
+		want = fmt.Sprint(min)
+	case max == min+1:
+		want = fmt.Sprintf("%d or %d", min, max)
+	default:
+		want = fmt.Sprintf("%d to %d", min, max)
+	}
+	return &object.Error{
+		Code:    diagnostic.WrongArgumentCount,
+		Message: fmt.Sprintf("wrong number of arguments. got=%d, want=%s", n, want),
+	}
+}
+
+// ordinals name the arguments in errors about one of several arguments.
+var ordinals = []string{"first", "second", "third", "fourth", "fifth"}
+
+// ArgType returns the error for the argument args[i] of the builtin called
+// name not being of one of the wanted types, e.g.
+// "second argument to `write` must be STRING, got INTEGER". The argument is
+// only numbered when the builtin was called with more than one.
+func ArgType(
+	name string,
+	args []object.Object,
+	i int,
+	want ...object.ObjectType,
+) *object.Error {
+	arg := "argument"
+	if len(args) > 1 {
+		if i < len(ordinals) {
+			arg = ordinals[i] + " argument"
+		} else {
+			arg = fmt.Sprintf("argument %d", i+1)
+		}
+	}
+	return &object.Error{
+		Code: diagnostic.WrongArgumentType,
+		Message: fmt.Sprintf("%s to `%s` must be %s, got %s",
+			arg, name, oneOf(want), args[i].Type()),
+	}
+}
+
+// oneOf lists the types, e.g. "ARRAY, HASH or STRING".
+func oneOf(types []object.ObjectType) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = string(t)
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	last := len(names) - 1
+	return strings.Join(names[:last], ", ") + " or " + names[last]
+}
+
+// Wrap returns the error for the Go error err that made the builtin called
+// name fail, e.g. "read: file already closed", tagged with code.
+func Wrap(code diagnostic.Code, name string, err error) *object.Error {
+	return &object.Error{
+		Code:    code,
+		Message: fmt.Sprintf("%s: %s", name, err),
+	}
+}
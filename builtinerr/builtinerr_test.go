@@ -0,0 +1,93 @@
+package builtinerr
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+func args(n int) []object.Object {
+	args := make([]object.Object, n)
+	for i := range args {
+		args[i] = &object.Integer{Value: int64(i)}
+	}
+	return args
+}
+
+func TestArgCount(t *testing.T) {
+	tests := []struct {
+		n, min, max int
+		expected    string
+	}{
+		{1, 1, 1, ""},
+		{2, 1, 1, "wrong number of arguments. got=2, want=1"},
+		{0, 0, 0, ""},
+		{2, 0, 1, "wrong number of arguments. got=2, want=0 or 1"},
+		{0, 1, Variadic, "wrong number of arguments. got=0, want=1 or more"},
+		{5, 1, Variadic, ""},
+		{4, 1, 3, "wrong number of arguments. got=4, want=1 to 3"},
+	}
+
+	for _, tt := range tests {
+		err := ArgCount(args(tt.n), tt.min, tt.max)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%d args, want %d..%d: unexpected error %q",
+					tt.n, tt.min, tt.max, err.Message)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%d args, want %d..%d: no error", tt.n, tt.min, tt.max)
+			continue
+		}
+		if err.Message != tt.expected {
+			t.Errorf("wrong message. want=%q, got=%q", tt.expected, err.Message)
+		}
+		if err.Code != diagnostic.WrongArgumentCount {
+			t.Errorf("wrong code. want=%s, got=%s",
+				diagnostic.WrongArgumentCount, err.Code)
+		}
+	}
+}
+
+func TestArgType(t *testing.T) {
+	tests := []struct {
+		args     []object.Object
+		i        int
+		want     []object.ObjectType
+		expected string
+	}{
+		{args(1), 0, []object.ObjectType{object.ARRAY_OBJ},
+			"argument to `f` must be ARRAY, got INTEGER"},
+		{args(2), 1, []object.ObjectType{object.STRING_OBJ, object.ARRAY_OBJ},
+			"second argument to `f` must be STRING or ARRAY, got INTEGER"},
+		{args(3), 0, []object.ObjectType{object.STRING_OBJ, object.ARRAY_OBJ, object.HASH_OBJ},
+			"first argument to `f` must be STRING, ARRAY or HASH, got INTEGER"},
+		{args(7), 6, []object.ObjectType{object.STRING_OBJ},
+			"argument 7 to `f` must be STRING, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		err := ArgType("f", tt.args, tt.i, tt.want...)
+		if err.Message != tt.expected {
+			t.Errorf("wrong message. want=%q, got=%q", tt.expected, err.Message)
+		}
+		if err.Code != diagnostic.WrongArgumentType {
+			t.Errorf("wrong code. want=%s, got=%s",
+				diagnostic.WrongArgumentType, err.Code)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	err := Wrap(diagnostic.IOError, "read", errors.New("file already closed"))
+	if err.Message != "read: file already closed" {
+		t.Errorf("wrong message. got=%q", err.Message)
+	}
+	if err.Code != diagnostic.IOError {
+		t.Errorf("wrong code. want=%s, got=%s", diagnostic.IOError, err.Code)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/typecheck"
+)
+
+// check implements `hou check`, which checks the type annotations of a
+// script without running it, see package typecheck.
+func check(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	diagnostics := fs.String("diagnostics", "text", "format of the errors: text or json")
+	version := lang.Default
+	fs.Var(&version, "lang", "`version` of the language of scripts without a version pragma")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: hou check [--diagnostics=text|json] [--lang=n] script.hou\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	format, err := diagnostic.ParseFormat(*diagnostics)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hou check: %s\n", err)
+		return 2
+	}
+
+	filename := fs.Arg(0)
+	program, _, ok := parseFile("check", filename, format, version)
+	if !ok {
+		return 1
+	}
+	if problems := typecheck.Check(program); len(problems) > 0 {
+		report(filename, format, problems)
+		return 1
+	}
+	return 0
+}
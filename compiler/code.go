@@ -0,0 +1,323 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Instructions is a sequence of bytecode instructions. An instruction is an
+// Opcode followed by its operands, big-endian encoded with the widths given by
+// the Definition of the opcode.
+type Instructions []byte
+
+// String returns the instructions disassembled, one per line, e.g.
+// `0000 OpConstant 1`.
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, fmtInstruction(def, operands))
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func fmtInstruction(def *Definition, operands []int) string {
+	if len(operands) != len(def.OperandWidths) {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), len(def.OperandWidths))
+	}
+
+	out := def.Name
+	for _, operand := range operands {
+		out += fmt.Sprintf(" %d", operand)
+	}
+	return out
+}
+
+// Opcode is the first byte of an instruction and tells the VM what to do.
+type Opcode byte
+
+// The opcodes of the VM. The comments tell what the operands are.
+const (
+	// OpConstant pushes the constant with the index of its operand.
+	OpConstant Opcode = iota
+	// OpPop pops the topmost value.
+	OpPop
+
+	// OpTrue, OpFalse and OpNull push the value.
+	OpTrue
+	OpFalse
+	OpNull
+
+	// The infix operators pop the right operand, then the left one, and
+	// push the result.
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpPow
+	OpEqual
+	OpNotEqual
+	OpLessThan
+	OpGreaterThan
+	OpLessEqual
+	OpGreaterEqual
+
+	// The prefix operators pop their operand and push the result.
+	OpMinus
+	OpBang
+
+	// OpJump jumps to the offset of its operand, OpJumpNotTruthy does so
+	// if the value it pops isn't truthy. OpJumpNotNull does so if the value
+	// on top of the stack isn't null, leaving it there, and else pops it,
+	// for the ?? operator.
+	OpJump
+	OpJumpNotTruthy
+	OpJumpNotNull
+	// OpMatch pops the value of a case of a match expression and, if the
+	// subject below it matches it, pops the subject too and jumps to the
+	// offset of its operand.
+	OpMatch
+
+	// OpIter pops the iterable of a for loop and pushes an iterator over
+	// it. OpNext jumps to the offset of its first operand, popping the
+	// iterator, if the iterator on top of the stack has no iterations left,
+	// and else pushes what the iteration binds to as many names as its
+	// second operand, see evaluator.Iterator.
+	OpIter
+	OpNext
+
+	// OpGetGlobal pushes the global with the index of its operand, and
+	// OpSetGlobal pops a value and binds the global to it.
+	OpGetGlobal
+	OpSetGlobal
+	// OpGetLocal and OpSetLocal do the same with the local of the current
+	// call with the index of their operand.
+	OpGetLocal
+	OpSetLocal
+	// OpGetCell and OpSetCell get and set the value of the cell held by the
+	// local with the index of their operand. Locals captured by closures
+	// are held in cells, so the closures share them with the function.
+	OpGetCell
+	OpSetCell
+	// OpGetFree and OpSetFree get and set the value of the free variable of
+	// the current closure with the index of their operand.
+	OpGetFree
+	OpSetFree
+	// OpFreeCell pushes the cell of the free variable with the index of its
+	// operand, to capture it in another closure.
+	OpFreeCell
+	// OpNewCell puts a new, unbound cell into the local with the index of
+	// its operand, and OpBox puts the value of the local into a new cell.
+	OpNewCell
+	OpBox
+	// OpGetBuiltin pushes the builtin whose name is the constant with the
+	// index of its operand.
+	OpGetBuiltin
+
+	// The assignment operators are like the set operators, but the
+	// variable must be bound already, and they leave the value on the
+	// stack as the result of the assignment.
+	OpAssignGlobal
+	OpAssignLocal
+	OpAssignCell
+	OpAssignFree
+
+	// OpArray pops as many elements as its operand and pushes an array of
+	// them, OpHash pops as many keys and values and pushes a hash.
+	OpArray
+	OpHash
+	// OpIndex pops an index and the value it indexes, and pushes the
+	// result.
+	OpIndex
+	// OpMember pops a value and pushes its member named by the string that
+	// is the constant with the index of its operand, e.g. for person.name.
+	OpMember
+	// OpSetIndex pops a value, an index and the array or hash it indexes,
+	// sets the element to the value and pushes the value. Its operand is
+	// the opcode of the operator of compound assignments, e.g. OpAdd for
+	// a[0] += 1, which is applied to the element and the value first, or
+	// OpSetIndex itself for plain ones.
+	OpSetIndex
+
+	// OpCall calls the function below as many arguments as its operand.
+	OpCall
+	// OpReturnValue returns the value it pops from the current call.
+	OpReturnValue
+	// OpClosure pushes a closure of the function that is the constant with
+	// the index of its first operand, capturing as many cells as its second
+	// operand, which it pops.
+	OpClosure
+	// OpError stops the program with the error that is the constant with
+	// the index of its operand.
+	OpError
+	// OpCurrentClosure pushes the closure being run, which named functions
+	// bind their name to.
+	OpCurrentClosure
+	// OpJumpArgument jumps to the offset of its first operand if the call
+	// being run was passed the argument with the index of its second
+	// operand, skipping the default value of the parameter.
+	OpJumpArgument
+	// OpYield pops a value and hands it over to the consumer of the
+	// generator being run, then pushes null once the next value is asked
+	// for.
+	OpYield
+)
+
+// Definition describes an Opcode: its name and the widths of its operands, in
+// bytes.
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+
+	OpAdd:          {"OpAdd", []int{}},
+	OpSub:          {"OpSub", []int{}},
+	OpMul:          {"OpMul", []int{}},
+	OpDiv:          {"OpDiv", []int{}},
+	OpMod:          {"OpMod", []int{}},
+	OpPow:          {"OpPow", []int{}},
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpLessThan:     {"OpLessThan", []int{}},
+	OpGreaterThan:  {"OpGreaterThan", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+
+	OpMinus: {"OpMinus", []int{}},
+	OpBang:  {"OpBang", []int{}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpJumpNotNull:   {"OpJumpNotNull", []int{2}},
+	OpMatch:         {"OpMatch", []int{2}},
+
+	OpIter: {"OpIter", []int{}},
+	OpNext: {"OpNext", []int{2, 1}},
+
+	OpGetGlobal:  {"OpGetGlobal", []int{2}},
+	OpSetGlobal:  {"OpSetGlobal", []int{2}},
+	OpGetLocal:   {"OpGetLocal", []int{1}},
+	OpSetLocal:   {"OpSetLocal", []int{1}},
+	OpGetCell:    {"OpGetCell", []int{1}},
+	OpSetCell:    {"OpSetCell", []int{1}},
+	OpGetFree:    {"OpGetFree", []int{1}},
+	OpSetFree:    {"OpSetFree", []int{1}},
+	OpFreeCell:   {"OpFreeCell", []int{1}},
+	OpNewCell:    {"OpNewCell", []int{1}},
+	OpBox:        {"OpBox", []int{1}},
+	OpGetBuiltin: {"OpGetBuiltin", []int{2}},
+
+	OpAssignGlobal: {"OpAssignGlobal", []int{2}},
+	OpAssignLocal:  {"OpAssignLocal", []int{1}},
+	OpAssignCell:   {"OpAssignCell", []int{1}},
+	OpAssignFree:   {"OpAssignFree", []int{1}},
+
+	OpArray: {"OpArray", []int{2}},
+	OpHash:  {"OpHash", []int{2}},
+	OpIndex: {"OpIndex", []int{}},
+
+	OpMember: {"OpMember", []int{2}},
+
+	OpSetIndex: {"OpSetIndex", []int{1}},
+
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpClosure:     {"OpClosure", []int{2, 1}},
+	OpError:       {"OpError", []int{2}},
+
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpJumpArgument:   {"OpJumpArgument", []int{2, 1}},
+	OpYield:          {"OpYield", []int{}},
+}
+
+// Lookup returns the Definition of the opcode op.
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// Make encodes the instruction with the opcode and the operands. It returns
+// an empty instruction if the opcode is undefined.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands of an instruction with the Definition def
+// from ins, which starts right after the opcode. It returns the operands and
+// how many bytes they take.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+// ReadUint16 decodes a two byte operand.
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+// ReadUint8 decodes a one byte operand.
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
@@ -0,0 +1,995 @@
+package compiler
+
+// Package compiler implements a compiler of Hou programs into bytecode for the
+// stack-based virtual machine of package vm. It translates the parsed AST
+// into the instructions of every function, and collects the constants of the
+// program in a constant pool, so the VM doesn't have to walk the AST at run
+// time, which is what makes it faster than the evaluator on hot loops and
+// recursive functions. Expressions of constants, like `60 * 60`, are folded
+// into the constant they evaluate to, and the instructions of every function
+// are optimized: the code that never runs is dropped, jumps to jumps are
+// collapsed and branches on constants folded. Compiler.Optimize turns both
+// off, to debug the compiler.
+//
+// The compiled program behaves like the evaluated one, with the operators,
+// indexing and builtins of package evaluator, but for a few differences in
+// how names are bound. Every scope is a function, the program or, with
+// lang.BlockScoping, a block, and the names its let statements bind are known
+// up front: they're variables of the scope from its start, which are unbound
+// until the let statement ran. So a name can't refer to a variable of an
+// outer scope before the let statement binding it in the inner one. Closures
+// capture the variables of the functions enclosing them, not environments.
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/object"
+	"github.com/cedrickchee/hou/token"
+)
+
+// Bytecode is a compiled program.
+type Bytecode struct {
+	// Main is the function that runs the statements of the program.
+	Main *CompiledFunction
+	// Constants is the constant pool of the program.
+	Constants []object.Object
+	// NumGlobals is the number of globals of the program.
+	NumGlobals int
+	// Features are the features of the language version of the program.
+	Features lang.FeatureSet
+}
+
+// Location is where an instruction came from: the position of the node it was
+// compiled from and, for instructions that use a variable or call a
+// function, the name of the variable or of the function.
+type Location struct {
+	Position token.Position
+	Name     string
+	// Suggestion is, for the instructions that load a builtin, the variable
+	// in scope the name is most likely a typo of, if any, for the error of
+	// a name that nothing binds.
+	Suggestion string
+}
+
+// CompiledFunction is a function literal compiled to bytecode. The constant
+// pool holds the compiled functions of the program, and the VM makes closures
+// of them.
+type CompiledFunction struct {
+	Instructions  Instructions
+	NumLocals     int
+	NumParameters int
+	// NumRequired is the number of parameters without a default value,
+	// which calls need arguments for.
+	NumRequired int
+	// Locations holds the locations of the instructions that can fail, by
+	// their offset, for the positions and traces of errors.
+	Locations map[int]Location
+	// Literal is the function literal the function was compiled from, nil
+	// for the main function of a program.
+	Literal *ast.FunctionLiteral
+}
+
+// Type returns the type of the object.
+func (cf *CompiledFunction) Type() object.ObjectType { return object.FUNCTION_OBJ }
+
+// Generator reports whether calling the function returns a generator, see
+// ast.FunctionLiteral.Generator.
+func (cf *CompiledFunction) Generator() bool {
+	return cf.Literal != nil && cf.Literal.Generator
+}
+
+// Inspect returns a stringified version of the object for debugging. It's
+// the same as the one of the functions of the evaluator.
+func (cf *CompiledFunction) Inspect() string {
+	if cf.Literal == nil {
+		return "fn() {\n<main>\n}"
+	}
+
+	var out bytes.Buffer
+
+	params := []string{}
+	for i, p := range cf.Literal.Parameters {
+		if d := cf.Literal.Default(i); d != nil {
+			params = append(params, p.String()+" = "+d.String())
+		} else {
+			params = append(params, p.String())
+		}
+	}
+
+	out.WriteString("fn")
+	if cf.Literal.Name != nil {
+		out.WriteString(" " + cf.Literal.Name.Value)
+	}
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(cf.Literal.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
+// operators are the opcodes of the infix operators.
+var operators = map[string]Opcode{
+	"+":  OpAdd,
+	"-":  OpSub,
+	"*":  OpMul,
+	"/":  OpDiv,
+	"%":  OpMod,
+	"**": OpPow,
+	"==": OpEqual,
+	"!=": OpNotEqual,
+	"<":  OpLessThan,
+	">":  OpGreaterThan,
+	"<=": OpLessEqual,
+	">=": OpGreaterEqual,
+}
+
+// maxOperand8 is the largest operand of one byte: the number of locals and
+// free variables of a function, and of arguments of calls, is limited to it.
+const maxOperand8 = 255
+
+// compilationScope holds the instructions of the function being compiled.
+type compilationScope struct {
+	instructions Instructions
+	locations    map[int]Location
+	// captured holds the names that the closures defined in the function
+	// refer to, see capturedNames.
+	captured map[string]bool
+}
+
+// Compiler compiles a program into bytecode.
+type Compiler struct {
+	// Optimize makes the compiler fold the operators applied to constants,
+	// e.g. `60 * 60` compiles to the constant 3600, and optimize the
+	// instructions of every function, see optimize. New enables it.
+	Optimize bool
+
+	constants []object.Object
+	// constantIndex holds the indexes of the literal constants, which are
+	// immutable, so each distinct literal is in the pool once.
+	constantIndex map[string]int
+
+	symbolTable *SymbolTable
+	scopes      []*compilationScope
+
+	// blocks is set if the blocks of if expressions and the bodies of while
+	// loops are scopes.
+	blocks bool
+	// features are the features of the language version of the program.
+	features lang.FeatureSet
+}
+
+// New returns a new Compiler that optimizes the programs it compiles.
+func New() *Compiler {
+	return &Compiler{
+		Optimize:      true,
+		constantIndex: make(map[string]int),
+		symbolTable:   NewSymbolTable(),
+	}
+}
+
+// Compile compiles the program and returns its bytecode.
+func Compile(program *ast.Program) (*Bytecode, error) {
+	return New().Compile(program)
+}
+
+// Compile compiles the program and returns its bytecode.
+func (c *Compiler) Compile(program *ast.Program) (*Bytecode, error) {
+	c.blocks = program.Features.Has(lang.BlockScoping)
+	c.features = program.Features
+
+	c.enterScope(capturedNames(program))
+	c.defineBindings(program.Statements)
+	if err := c.compileStatements(program.Statements); err != nil {
+		return nil, err
+	}
+	c.emit(OpReturnValue)
+	main := c.leaveScope(nil)
+	if main.NumLocals > maxOperand8 {
+		return nil, fmt.Errorf("compiler: too many variables in blocks")
+	}
+
+	return &Bytecode{
+		Main:       main,
+		Constants:  c.constants,
+		NumGlobals: c.symbolTable.NumGlobals(),
+		Features:   program.Features,
+	}, nil
+}
+
+// compileStatements compiles the statements such that they leave the result
+// of the last one on the stack, which is NULL if it isn't an expression.
+func (c *Compiler) compileStatements(statements []ast.Statement) error {
+	for i, s := range statements {
+		last := i == len(statements)-1
+
+		if es, ok := s.(*ast.ExpressionStatement); ok {
+			if err := c.compileExpression(es.Expression); err != nil {
+				return err
+			}
+			if !last {
+				c.emit(OpPop)
+			}
+			continue
+		}
+
+		if err := c.compileStatement(s); err != nil {
+			return err
+		}
+		if last {
+			c.emit(OpNull)
+		}
+	}
+
+	if len(statements) == 0 {
+		c.emit(OpNull)
+	}
+	return nil
+}
+
+func (c *Compiler) compileStatement(s ast.Statement) error {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		if err := c.compileExpression(s.Value); err != nil {
+			return err
+		}
+		c.setSymbol(s.Name.Value)
+
+	case *ast.ReturnStatement:
+		if err := c.compileExpression(s.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(OpReturnValue)
+
+	case *ast.ExpressionStatement:
+		if err := c.compileExpression(s.Expression); err != nil {
+			return err
+		}
+		c.emit(OpPop)
+
+	case *ast.BlockStatement:
+		if err := c.compileStatements(s.Statements); err != nil {
+			return err
+		}
+		c.emit(OpPop)
+
+	default:
+		return fmt.Errorf("compiler: unsupported statement %T", s)
+	}
+
+	return nil
+}
+
+// setSymbol pops a value and binds the name to it. The name was defined when
+// its scope was entered.
+func (c *Compiler) setSymbol(name string) {
+	symbol, _ := c.symbolTable.Resolve(name)
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emit(OpSetGlobal, symbol.Index)
+	case LocalScope:
+		c.emit(OpSetLocal, symbol.Index)
+	case CellScope:
+		c.emit(OpSetCell, symbol.Index)
+	}
+}
+
+// compileBlock compiles the block of an if expression or the body of a while
+// loop, which are scopes with lang.BlockScoping, such that it leaves its
+// result on the stack.
+func (c *Compiler) compileBlock(block *ast.BlockStatement) error {
+	if !c.blocks {
+		return c.compileStatements(block.Statements)
+	}
+
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	c.defineBindings(block.Statements)
+	err := c.compileStatements(block.Statements)
+	c.symbolTable = c.symbolTable.Outer
+	return err
+}
+
+func (c *Compiler) compileExpression(e ast.Expression) error {
+	if value, ok := c.constant(e); ok {
+		c.emitConstant(value)
+		return nil
+	}
+
+	switch e := e.(type) {
+	case *ast.Identifier:
+		c.loadSymbol(e)
+
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(e)
+
+	case *ast.PrefixExpression:
+		if err := c.compileExpression(e.Right); err != nil {
+			return err
+		}
+		switch e.Operator {
+		case "!":
+			c.emitAt(e, "", OpBang)
+		case "-":
+			c.emitAt(e, "", OpMinus)
+		default:
+			return fmt.Errorf("compiler: unknown operator %s", e.Operator)
+		}
+
+	case *ast.InfixExpression:
+		if e.Operator == "??" {
+			return c.compileCoalesce(e)
+		}
+		op, ok := operators[e.Operator]
+		if !ok {
+			return fmt.Errorf("compiler: unknown operator %s", e.Operator)
+		}
+		if err := c.compileExpression(e.Left); err != nil {
+			return err
+		}
+		if err := c.compileExpression(e.Right); err != nil {
+			return err
+		}
+		c.emitAt(e, "", op)
+
+	case *ast.IfExpression:
+		return c.compileIfExpression(e)
+
+	case *ast.WhileExpression:
+		return c.compileWhileExpression(e)
+
+	case *ast.ForInExpression:
+		return c.compileForInExpression(e)
+
+	case *ast.MatchExpression:
+		return c.compileMatchExpression(e)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunctionLiteral(e)
+
+	case *ast.CallExpression:
+		if err := c.compileExpression(e.Function); err != nil {
+			return err
+		}
+		for _, a := range e.Arguments {
+			if err := c.compileExpression(a); err != nil {
+				return err
+			}
+		}
+		if len(e.Arguments) > maxOperand8 {
+			return fmt.Errorf("compiler: too many arguments in call")
+		}
+		// Errors of calls point at the function that was called, and traces
+		// name it by the name it was called by.
+		name := "fn"
+		if ident, ok := e.Function.(*ast.Identifier); ok {
+			name = ident.Value
+		}
+		c.emitAt(e.Function, name, OpCall, len(e.Arguments))
+
+	case *ast.ArrayLiteral:
+		for _, el := range e.Elements {
+			if err := c.compileExpression(el); err != nil {
+				return err
+			}
+		}
+		c.emit(OpArray, len(e.Elements))
+
+	case *ast.HashLiteral:
+		for _, k := range e.Keys {
+			if err := c.compileExpression(k); err != nil {
+				return err
+			}
+			if err := c.compileExpression(e.Pairs[k]); err != nil {
+				return err
+			}
+		}
+		c.emitAt(e, "", OpHash, len(e.Keys))
+
+	case *ast.IndexExpression:
+		if err := c.compileExpression(e.Left); err != nil {
+			return err
+		}
+		if err := c.compileExpression(e.Index); err != nil {
+			return err
+		}
+		c.emitAt(e, "", OpIndex)
+
+	case *ast.MemberExpression:
+		if err := c.compileExpression(e.Object); err != nil {
+			return err
+		}
+		name := e.Property.Value
+		c.emitAt(e, "", OpMember,
+			c.literal("string:"+name, &object.String{Value: name}))
+
+	case *ast.YieldExpression:
+		if err := c.compileExpression(e.Value); err != nil {
+			return err
+		}
+		c.emitAt(e, "", OpYield)
+
+	case *ast.SpawnExpression:
+		// `spawn fn() { ... }` calls the spawn builtin with the function,
+		// even if the program binds spawn to something else.
+		c.emitAt(e, "spawn", OpGetBuiltin, c.literal("string:spawn", &object.String{Value: "spawn"}))
+		if err := c.compileFunctionLiteral(e.Function); err != nil {
+			return err
+		}
+		c.emitAt(e, "spawn", OpCall, 1)
+
+	default:
+		return fmt.Errorf("compiler: unsupported expression %T", e)
+	}
+
+	return nil
+}
+
+// constant returns the value of the expression if it's a literal or, if the
+// compiler optimizes, an operator applied to constants. The operators are
+// applied by the evaluator, with the features of the program, so the value is
+// the one the expression evaluates to. Expressions that fail, like `1 / 0` or
+// an integer overflow, aren't constants, so that they still fail when they
+// run.
+func (c *Compiler) constant(e ast.Expression) (object.Object, bool) {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: e.Value}, true
+	case *ast.FloatLiteral:
+		return &object.Float{Value: e.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: e.Value}, true
+	case *ast.Boolean:
+		return object.NativeBoolToBooleanObject(e.Value), true
+	case *ast.Null:
+		return object.NULL, true
+	}
+	if !c.Optimize {
+		return nil, false
+	}
+
+	var value object.Object
+	switch e := e.(type) {
+	case *ast.PrefixExpression:
+		right, ok := c.constant(e.Right)
+		if !ok {
+			return nil, false
+		}
+		value = evaluator.EvalPrefix(e.Operator, right)
+	case *ast.InfixExpression:
+		if _, ok := operators[e.Operator]; !ok {
+			return nil, false
+		}
+		left, ok := c.constant(e.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := c.constant(e.Right)
+		if !ok {
+			return nil, false
+		}
+		value = evaluator.EvalInfixWith(c.features, e.Operator, left, right)
+	default:
+		return nil, false
+	}
+
+	switch value.(type) {
+	case *object.Integer, *object.Float, *object.String, *object.Boolean, *object.Null:
+		return value, true
+	}
+	return nil, false
+}
+
+// emitConstant emits the instruction that pushes the value of a constant,
+// which is in the constant pool once.
+func (c *Compiler) emitConstant(value object.Object) {
+	switch value := value.(type) {
+	case *object.Integer:
+		c.emit(OpConstant, c.literal(fmt.Sprintf("int:%d", value.Value), value))
+	case *object.Float:
+		c.emit(OpConstant, c.literal("float:"+strconv.FormatFloat(value.Value, 'g', -1, 64), value))
+	case *object.String:
+		c.emit(OpConstant, c.literal("string:"+value.Value, value))
+	case *object.Boolean:
+		if value.Value {
+			c.emit(OpTrue)
+		} else {
+			c.emit(OpFalse)
+		}
+	case *object.Null:
+		c.emit(OpNull)
+	}
+}
+
+func (c *Compiler) compileIfExpression(e *ast.IfExpression) error {
+	if err := c.compileExpression(e.Condition); err != nil {
+		return err
+	}
+
+	// The offsets of the jumps are patched once they're known.
+	jumpNotTruthy := c.emit(OpJumpNotTruthy, 9999)
+	if err := c.compileBlock(e.Consequence); err != nil {
+		return err
+	}
+	jump := c.emit(OpJump, 9999)
+
+	c.changeOperand(jumpNotTruthy, len(c.scope().instructions))
+	if e.Alternative != nil {
+		if err := c.compileBlock(e.Alternative); err != nil {
+			return err
+		}
+	} else {
+		c.emit(OpNull)
+	}
+	c.changeOperand(jump, len(c.scope().instructions))
+
+	return nil
+}
+
+// compileCoalesce compiles `left ?? right`, which only evaluates right if left
+// is null.
+func (c *Compiler) compileCoalesce(e *ast.InfixExpression) error {
+	if err := c.compileExpression(e.Left); err != nil {
+		return err
+	}
+	jumpNotNull := c.emit(OpJumpNotNull, 9999)
+	if err := c.compileExpression(e.Right); err != nil {
+		return err
+	}
+	c.changeOperand(jumpNotNull, len(c.scope().instructions))
+	return nil
+}
+
+func (c *Compiler) compileWhileExpression(e *ast.WhileExpression) error {
+	start := len(c.scope().instructions)
+	if err := c.compileExpression(e.Condition); err != nil {
+		return err
+	}
+	jumpNotTruthy := c.emit(OpJumpNotTruthy, 9999)
+
+	if err := c.compileBlock(e.Body); err != nil {
+		return err
+	}
+	c.emit(OpPop)
+	c.emit(OpJump, start)
+
+	// A while loop evaluates to NULL.
+	c.changeOperand(jumpNotTruthy, len(c.scope().instructions))
+	c.emit(OpNull)
+
+	return nil
+}
+
+// compileForInExpression compiles a for loop, which keeps its iterator on the
+// stack while it runs. Its body is always a scope, see compileBlock, that
+// binds the names of the loop.
+func (c *Compiler) compileForInExpression(e *ast.ForInExpression) error {
+	if err := c.compileExpression(e.Iterable); err != nil {
+		return err
+	}
+	c.emitAt(e, "", OpIter)
+
+	names := []*ast.Identifier{e.Value}
+	if e.Key != nil {
+		names = []*ast.Identifier{e.Key, e.Value}
+	}
+	next := c.emit(OpNext, 9999, len(names))
+
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	for _, name := range names {
+		c.defineName(name.Value)
+	}
+	c.defineBindings(e.Body.Statements)
+	// The value is on top of the key.
+	for i := len(names) - 1; i >= 0; i-- {
+		c.setSymbol(names[i].Value)
+	}
+	err := c.compileStatements(e.Body.Statements)
+	c.symbolTable = c.symbolTable.Outer
+	if err != nil {
+		return err
+	}
+	c.emit(OpPop)
+	c.emit(OpJump, next)
+
+	// A for loop evaluates to NULL.
+	c.changeOperand(next, len(c.scope().instructions), len(names))
+	c.emit(OpNull)
+
+	return nil
+}
+
+// compileMatchExpression compiles a match expression, which keeps the subject
+// on the stack until a case matches or there are none left. Match expressions
+// came with block scoping, so compileBlock makes their cases scopes.
+func (c *Compiler) compileMatchExpression(e *ast.MatchExpression) error {
+	if err := c.compileExpression(e.Subject); err != nil {
+		return err
+	}
+
+	var ends []int
+	for _, mc := range e.Cases {
+		var matches []int
+		for _, v := range mc.Values {
+			if err := c.compileExpression(v); err != nil {
+				return err
+			}
+			matches = append(matches, c.emitAt(v, "", OpMatch, 9999))
+		}
+		next := c.emit(OpJump, 9999)
+
+		for _, match := range matches {
+			c.changeOperand(match, len(c.scope().instructions))
+		}
+		if err := c.compileBlock(mc.Body); err != nil {
+			return err
+		}
+		ends = append(ends, c.emit(OpJump, 9999))
+
+		c.changeOperand(next, len(c.scope().instructions))
+	}
+
+	// No case matched.
+	c.emit(OpPop)
+	if e.Default != nil {
+		if err := c.compileBlock(e.Default); err != nil {
+			return err
+		}
+	} else {
+		c.emit(OpNull)
+	}
+
+	for _, end := range ends {
+		c.changeOperand(end, len(c.scope().instructions))
+	}
+	return nil
+}
+
+func (c *Compiler) compileAssignExpression(e *ast.AssignExpression) error {
+	if e.Index != nil {
+		return c.compileIndexAssignment(e)
+	}
+
+	name := e.Name.Value
+	symbol, ok := c.symbolTable.Resolve(name)
+	if !ok {
+		// No let statement binds the name, so the assignment fails once it
+		// read the variable, or evaluated the value.
+		if e.Operator == "=" {
+			if err := c.compileExpression(e.Value); err != nil {
+				return err
+			}
+		}
+		err := &object.Error{
+			Code:    diagnostic.UndeclaredAssignment,
+			Message: fmt.Sprintf("cannot assign to %s: it wasn't declared with let", name),
+		}
+		c.emitAt(e, "", OpError, c.addConstant(err))
+		return nil
+	}
+
+	if e.Operator != "=" {
+		c.loadSymbol(e.Name)
+	}
+	if err := c.compileExpression(e.Value); err != nil {
+		return err
+	}
+	if e.Operator != "=" {
+		op, ok := operators[strings.TrimSuffix(e.Operator, "=")]
+		if !ok {
+			return fmt.Errorf("compiler: unknown operator %s", e.Operator)
+		}
+		c.emitAt(e, "", op)
+	}
+
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emitAt(e, name, OpAssignGlobal, symbol.Index)
+	case LocalScope:
+		c.emitAt(e, name, OpAssignLocal, symbol.Index)
+	case CellScope:
+		c.emitAt(e, name, OpAssignCell, symbol.Index)
+	case FreeScope:
+		c.emitAt(e, name, OpAssignFree, symbol.Index)
+	}
+	return nil
+}
+
+// compileIndexAssignment compiles an assignment to an element of an array or
+// hash, which evaluates the value after the array or hash and the index, like
+// the evaluator does.
+func (c *Compiler) compileIndexAssignment(e *ast.AssignExpression) error {
+	if err := c.compileExpression(e.Index.Left); err != nil {
+		return err
+	}
+	if err := c.compileExpression(e.Index.Index); err != nil {
+		return err
+	}
+	if err := c.compileExpression(e.Value); err != nil {
+		return err
+	}
+
+	op := OpSetIndex
+	if e.Operator != "=" {
+		var ok bool
+		if op, ok = operators[strings.TrimSuffix(e.Operator, "=")]; !ok {
+			return fmt.Errorf("compiler: unknown operator %s", e.Operator)
+		}
+	}
+	c.emitAt(e, "", OpSetIndex, int(op))
+	return nil
+}
+
+func (c *Compiler) compileFunctionLiteral(e *ast.FunctionLiteral) error {
+	// The default values are evaluated in the scope of the function, so
+	// closures in them capture its variables too.
+	scope := []ast.Node{e.Body}
+	for _, value := range e.Defaults {
+		if value != nil {
+			scope = append(scope, value)
+		}
+	}
+	c.enterScope(capturedNames(scope...))
+	for i, param := range e.Parameters {
+		symbol := c.symbolTable.Define(param.Value, c.scope().captured[param.Value])
+		if symbol.Scope == CellScope {
+			c.emit(OpBox, i)
+		}
+	}
+	// The name of a named function is a local bound to the closure, unless
+	// a parameter has the same name.
+	if name := e.Name; name != nil {
+		if _, ok := c.symbolTable.store[name.Value]; !ok {
+			symbol := c.symbolTable.Define(name.Value, c.scope().captured[name.Value])
+			c.emit(OpCurrentClosure)
+			if symbol.Scope == CellScope {
+				c.emit(OpNewCell, symbol.Index)
+				c.emit(OpSetCell, symbol.Index)
+			} else {
+				c.emit(OpSetLocal, symbol.Index)
+			}
+		}
+	}
+	c.defineBindings(e.Body.Statements)
+	if err := c.compileDefaults(e); err != nil {
+		return err
+	}
+	if err := c.compileStatements(e.Body.Statements); err != nil {
+		return err
+	}
+	c.emit(OpReturnValue)
+
+	free := c.symbolTable.FreeSymbols
+	fn := c.leaveScope(e)
+	if fn.NumLocals > maxOperand8 || len(free) > maxOperand8 {
+		return fmt.Errorf("compiler: too many variables in function")
+	}
+
+	// Capture the cells of the free variables.
+	for _, symbol := range free {
+		switch symbol.Scope {
+		case CellScope:
+			c.emit(OpGetLocal, symbol.Index)
+		case FreeScope:
+			c.emit(OpFreeCell, symbol.Index)
+		default:
+			return fmt.Errorf("compiler: %s captured from %s scope", symbol.Name, symbol.Scope)
+		}
+	}
+	c.emit(OpClosure, c.addConstant(fn), len(free))
+
+	return nil
+}
+
+// compileDefaults compiles the default values of the parameters of the
+// function literal, which are evaluated in order for the arguments a call
+// omits.
+func (c *Compiler) compileDefaults(e *ast.FunctionLiteral) error {
+	for i, param := range e.Parameters {
+		value := e.Default(i)
+		if value == nil {
+			continue
+		}
+		jump := c.emit(OpJumpArgument, 9999, i)
+		if err := c.compileExpression(value); err != nil {
+			return err
+		}
+		symbol, _ := c.symbolTable.Resolve(param.Value)
+		if symbol.Scope == CellScope {
+			c.emit(OpSetCell, symbol.Index)
+		} else {
+			c.emit(OpSetLocal, symbol.Index)
+		}
+		c.changeOperand(jump, len(c.scope().instructions), i)
+	}
+	return nil
+}
+
+// loadSymbol emits the instruction that pushes the value of the variable the
+// identifier refers to. Names that no let statement or parameter binds refer
+// to builtins.
+func (c *Compiler) loadSymbol(ident *ast.Identifier) {
+	name := ident.Value
+	symbol, ok := c.symbolTable.Resolve(name)
+	if !ok {
+		pos := c.emitAt(ident, name, OpGetBuiltin, c.literal("string:"+name, &object.String{Value: name}))
+		if suggestion := evaluator.Suggest(name, c.symbolTable.Names()); suggestion != "" {
+			loc := c.scope().locations[pos]
+			loc.Suggestion = suggestion
+			c.scope().locations[pos] = loc
+		}
+		return
+	}
+
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emitAt(ident, name, OpGetGlobal, symbol.Index)
+	case LocalScope:
+		c.emitAt(ident, name, OpGetLocal, symbol.Index)
+	case CellScope:
+		c.emitAt(ident, name, OpGetCell, symbol.Index)
+	case FreeScope:
+		c.emitAt(ident, name, OpGetFree, symbol.Index)
+	}
+}
+
+// defineBindings defines the names the let statements bind in the scope
+// being entered, and gives the ones closures capture their cells.
+func (c *Compiler) defineBindings(statements []ast.Statement) {
+	for _, name := range c.bindings(statements) {
+		c.defineName(name)
+	}
+}
+
+// defineName defines the name in the scope being entered, unless it's bound
+// there already, e.g. a parameter or a name bound twice.
+func (c *Compiler) defineName(name string) {
+	if _, ok := c.symbolTable.store[name]; ok {
+		return
+	}
+	symbol := c.symbolTable.Define(name, c.scope().captured[name])
+	if symbol.Scope == CellScope {
+		c.emit(OpNewCell, symbol.Index)
+	}
+}
+
+// bindings returns the names the let statements in the statements bind in
+// their scope, in the order they're bound: the ones in them, but not in the
+// functions, for loops and match expressions in them, or in the blocks of if
+// expressions and while loops if they're scopes.
+func (c *Compiler) bindings(statements []ast.Statement) []string {
+	var names []string
+	for _, s := range statements {
+		ast.Inspect(s, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FunctionLiteral, *ast.ForInExpression, *ast.MatchExpression:
+				return false
+			case *ast.IfExpression, *ast.WhileExpression:
+				if c.blocks {
+					return false
+				}
+			case *ast.LetStatement:
+				names = append(names, n.Name.Value)
+			}
+			return true
+		})
+	}
+	return names
+}
+
+// capturedNames returns the names that the function literals in nodes refer
+// to, in their bodies or in the default values of their parameters. Those are
+// all the variables of the scope of nodes that closures may capture: locals
+// with other names don't need cells.
+func capturedNames(nodes ...ast.Node) map[string]bool {
+	names := map[string]bool{}
+	collect := func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Identifier); ok {
+			names[ident.Value] = true
+		}
+		return true
+	}
+	for _, node := range nodes {
+		ast.Inspect(node, func(n ast.Node) bool {
+			fn, ok := n.(*ast.FunctionLiteral)
+			if !ok {
+				return true
+			}
+			for _, value := range fn.Defaults {
+				if value != nil {
+					ast.Inspect(value, collect)
+				}
+			}
+			ast.Inspect(fn.Body, collect)
+			return false
+		})
+	}
+	return names
+}
+
+func (c *Compiler) scope() *compilationScope {
+	return c.scopes[len(c.scopes)-1]
+}
+
+// enterScope starts compiling a function, or the program, whose closures
+// capture the names in captured.
+func (c *Compiler) enterScope(captured map[string]bool) {
+	c.scopes = append(c.scopes, &compilationScope{
+		locations: make(map[int]Location),
+		captured:  captured,
+	})
+	if len(c.scopes) > 1 {
+		c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+	}
+}
+
+// leaveScope finishes compiling the function literal, or the program if
+// literal is nil, and returns the compiled function.
+func (c *Compiler) leaveScope(literal *ast.FunctionLiteral) *CompiledFunction {
+	scope := c.scope()
+	fn := &CompiledFunction{
+		Instructions: scope.instructions,
+		NumLocals:    c.symbolTable.NumLocals(),
+		Locations:    scope.locations,
+		Literal:      literal,
+	}
+	if literal != nil {
+		fn.NumParameters = len(literal.Parameters)
+		fn.NumRequired = literal.Required()
+	}
+	if c.Optimize {
+		c.optimize(fn)
+	}
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	if len(c.scopes) > 0 {
+		c.symbolTable = c.symbolTable.Outer
+	}
+	return fn
+}
+
+// emit appends the instruction to the function being compiled and returns
+// its offset.
+func (c *Compiler) emit(op Opcode, operands ...int) int {
+	scope := c.scope()
+	pos := len(scope.instructions)
+	scope.instructions = append(scope.instructions, Make(op, operands...)...)
+	return pos
+}
+
+// emitAt emits the instruction like emit, with the location of the node and
+// the name of the variable or function it uses.
+func (c *Compiler) emitAt(node ast.Node, name string, op Opcode, operands ...int) int {
+	pos := c.emit(op, operands...)
+	c.scope().locations[pos] = Location{Position: node.Pos(), Name: name}
+	return pos
+}
+
+// changeOperand replaces the operand of the instruction at the offset.
+func (c *Compiler) changeOperand(pos int, operands ...int) {
+	scope := c.scope()
+	op := Opcode(scope.instructions[pos])
+	copy(scope.instructions[pos:], Make(op, operands...))
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// literal returns the index of the constant of a literal, identified by key.
+func (c *Compiler) literal(key string, obj object.Object) int {
+	if i, ok := c.constantIndex[key]; ok {
+		return i
+	}
+	i := c.addConstant(obj)
+	c.constantIndex[key] = i
+	return i
+}
@@ -0,0 +1,641 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cedrickchee/hou/lexer"
+	"github.com/cedrickchee/hou/object"
+	"github.com/cedrickchee/hou/parser"
+)
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+		if string(instruction) != string(tt.expected) {
+			t.Errorf("wrong instruction for %d. want=%v, got=%v", tt.op, tt.expected, instruction)
+		}
+
+		def, err := Lookup(byte(tt.op))
+		if err != nil {
+			t.Fatalf("definition not found: %s", err)
+		}
+		operands, read := ReadOperands(def, instruction[1:])
+		if read != len(tt.expected)-1 {
+			t.Errorf("wrong number of bytes read. want=%d, got=%d", len(tt.expected)-1, read)
+		}
+		if fmt.Sprint(operands) != fmt.Sprint(tt.operands) {
+			t.Errorf("wrong operands. want=%v, got=%v", tt.operands, operands)
+		}
+	}
+}
+
+func TestInstructionsString(t *testing.T) {
+	instructions := []Instructions{
+		Make(OpAdd),
+		Make(OpGetLocal, 1),
+		Make(OpConstant, 2),
+		Make(OpConstant, 65535),
+		Make(OpClosure, 65535, 255),
+	}
+
+	expected := `0000 OpAdd
+0001 OpGetLocal 1
+0003 OpConstant 2
+0006 OpConstant 65535
+0009 OpClosure 65535 255
+`
+
+	concatted := Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q", expected, concatted.String())
+	}
+}
+
+func TestSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a", true)
+	fn := NewEnclosedSymbolTable(global)
+	b := fn.Define("b", false)
+	c := fn.Define("c", true)
+	block := NewBlockSymbolTable(fn)
+	d := block.Define("d", false)
+	inner := NewEnclosedSymbolTable(block)
+
+	expected := map[string]Symbol{
+		"a": {Name: "a", Scope: GlobalScope, Index: 0},
+		"b": {Name: "b", Scope: LocalScope, Index: 0},
+		"c": {Name: "c", Scope: CellScope, Index: 1},
+		"d": {Name: "d", Scope: LocalScope, Index: 2},
+	}
+	for name, symbol := range map[string]Symbol{"a": a, "b": b, "c": c, "d": d} {
+		if symbol != expected[name] {
+			t.Errorf("wrong symbol of %s. want=%+v, got=%+v", name, expected[name], symbol)
+		}
+	}
+	if fn.NumLocals() != 3 || block.NumLocals() != 3 {
+		t.Errorf("the locals of blocks aren't locals of their function. got=%d", fn.NumLocals())
+	}
+	if symbol := fn.Define("b", true); symbol != b {
+		t.Errorf("b was defined twice. got=%+v", symbol)
+	}
+
+	// Blocks resolve the symbols of their function as they are, functions
+	// capture them as free variables.
+	if symbol, ok := block.Resolve("c"); !ok || symbol != c {
+		t.Errorf("wrong symbol of c in the block. got=%+v", symbol)
+	}
+	for i, name := range []string{"c", "d", "a"} {
+		symbol, ok := inner.Resolve(name)
+		if !ok {
+			t.Fatalf("%s not resolvable", name)
+		}
+		if name == "a" {
+			if symbol != a {
+				t.Errorf("globals aren't free variables. got=%+v", symbol)
+			}
+			continue
+		}
+		want := Symbol{Name: name, Scope: FreeScope, Index: i}
+		if symbol != want {
+			t.Errorf("wrong symbol of %s. want=%+v, got=%+v", name, want, symbol)
+		}
+	}
+	if len(inner.FreeSymbols) != 2 || inner.FreeSymbols[0] != c || inner.FreeSymbols[1] != d {
+		t.Errorf("wrong free symbols. got=%+v", inner.FreeSymbols)
+	}
+	if _, ok := inner.Resolve("e"); ok {
+		t.Errorf("e resolved without being defined")
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		input        string
+		constants    []interface{}
+		instructions []Instructions
+	}{
+		{
+			input:     "1 + 2; 3",
+			constants: []interface{}{1, 2, 3},
+			instructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpConstant, 1),
+				Make(OpAdd),
+				Make(OpPop),
+				Make(OpConstant, 2),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			// Literals are in the pool once.
+			input:     `"a" < "a"`,
+			constants: []interface{}{"a"},
+			instructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpConstant, 0),
+				Make(OpLessThan),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			input:     "1 % 2 ** 3 <= 4",
+			constants: []interface{}{1, 2, 3, 4},
+			instructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpConstant, 1),
+				Make(OpConstant, 2),
+				Make(OpPow),
+				Make(OpMod),
+				Make(OpConstant, 3),
+				Make(OpLessEqual),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			input:     "if (true) { 10 }; 20",
+			constants: []interface{}{10, 20},
+			instructions: []Instructions{
+				Make(OpTrue),
+				Make(OpJumpNotTruthy, 10),
+				Make(OpConstant, 0),
+				Make(OpJump, 11),
+				Make(OpNull),
+				Make(OpPop),
+				Make(OpConstant, 1),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			input:     "#pragma version 3\nfn() { yield 1 }",
+			constants: []interface{}{1, []Instructions{Make(OpConstant, 0), Make(OpYield), Make(OpReturnValue)}},
+			instructions: []Instructions{
+				Make(OpClosure, 1, 0),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			// Names are bound when the scope is entered, so functions can
+			// refer to the ones bound after them.
+			input:     "let f = fn() { g }; let g = 1; g = -g;",
+			constants: []interface{}{[]Instructions{Make(OpGetGlobal, 1), Make(OpReturnValue)}, 1},
+			instructions: []Instructions{
+				Make(OpClosure, 0, 0),
+				Make(OpSetGlobal, 0),
+				Make(OpConstant, 1),
+				Make(OpSetGlobal, 1),
+				Make(OpGetGlobal, 1),
+				Make(OpMinus),
+				Make(OpAssignGlobal, 1),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			// Captured locals are kept in cells.
+			input: "fn(a) { let b = 1; fn() { a + b } }",
+			constants: []interface{}{
+				1,
+				[]Instructions{
+					Make(OpGetFree, 0),
+					Make(OpGetFree, 1),
+					Make(OpAdd),
+					Make(OpReturnValue),
+				},
+				[]Instructions{
+					Make(OpBox, 0),
+					Make(OpNewCell, 1),
+					Make(OpConstant, 0),
+					Make(OpSetCell, 1),
+					Make(OpGetLocal, 0),
+					Make(OpGetLocal, 1),
+					Make(OpClosure, 1, 2),
+					Make(OpReturnValue),
+				},
+			},
+			instructions: []Instructions{
+				Make(OpClosure, 2, 0),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			input:     "len([1], {1: 2})",
+			constants: []interface{}{"len", 1, 2},
+			instructions: []Instructions{
+				Make(OpGetBuiltin, 0),
+				Make(OpConstant, 1),
+				Make(OpArray, 1),
+				Make(OpConstant, 1),
+				Make(OpConstant, 2),
+				Make(OpHash, 1),
+				Make(OpCall, 2),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			input:     "#pragma version 2\nwhile (true) { let x = 1; }",
+			constants: []interface{}{1},
+			instructions: []Instructions{
+				Make(OpTrue),
+				Make(OpJumpNotTruthy, 14),
+				Make(OpConstant, 0),
+				Make(OpSetLocal, 0),
+				Make(OpNull),
+				Make(OpPop),
+				Make(OpJump, 0),
+				Make(OpNull),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			input:     "let a = [1]; a[0] += 2",
+			constants: []interface{}{1, 0, 2},
+			instructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpArray, 1),
+				Make(OpSetGlobal, 0),
+				Make(OpGetGlobal, 0),
+				Make(OpConstant, 1),
+				Make(OpConstant, 2),
+				Make(OpSetIndex, int(OpAdd)),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			// The right operand of ?? is skipped, with the left one on the
+			// stack, if the left one isn't null.
+			input:     "#pragma version 2\nnull ?? 1",
+			constants: []interface{}{1},
+			instructions: []Instructions{
+				Make(OpNull),
+				Make(OpJumpNotNull, 7),
+				Make(OpConstant, 0),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			input:     `let p = {}; p.name`,
+			constants: []interface{}{"name"},
+			instructions: []Instructions{
+				Make(OpHash, 0),
+				Make(OpSetGlobal, 0),
+				Make(OpGetGlobal, 0),
+				Make(OpMember, 0),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			// The iterator of a for loop stays on the stack, below what each
+			// iteration binds to the names of the loop.
+			input:     "#pragma version 2\nfor (k, v in []) { v }",
+			constants: []interface{}{},
+			instructions: []Instructions{
+				Make(OpArray, 0),
+				Make(OpIter),
+				Make(OpNext, 18, 2),
+				Make(OpSetLocal, 1),
+				Make(OpSetLocal, 0),
+				Make(OpGetLocal, 1),
+				Make(OpPop),
+				Make(OpJump, 4),
+				Make(OpNull),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			// The subject of a match expression stays on the stack until a
+			// case matches, or none does.
+			input:     "#pragma version 2\nmatch (1) { case 2: { 3 } }",
+			constants: []interface{}{1, 2, 3},
+			instructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpConstant, 1),
+				Make(OpMatch, 12),
+				Make(OpJump, 18),
+				Make(OpConstant, 2),
+				Make(OpJump, 20),
+				Make(OpPop),
+				Make(OpNull),
+				Make(OpReturnValue),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		bytecode := compile(t, tt.input, false)
+		testInstructions(t, tt.input, tt.instructions, bytecode.Main.Instructions)
+		testConstants(t, tt.input, tt.constants, bytecode.Constants)
+	}
+}
+
+func TestFoldConstants(t *testing.T) {
+	tests := []struct {
+		input        string
+		constants    []interface{}
+		instructions []Instructions
+	}{
+		{
+			input:        "60 * 60 * 24",
+			constants:    []interface{}{86400},
+			instructions: []Instructions{Make(OpConstant, 0), Make(OpReturnValue)},
+		},
+		{
+			// Folded constants are in the pool once too.
+			input:     `["a" + "b", "ab", 1 % 2 ** 3 <= 4, -(1 - 3)]`,
+			constants: []interface{}{"ab", 2},
+			instructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpConstant, 0),
+				Make(OpTrue),
+				Make(OpConstant, 1),
+				Make(OpArray, 4),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			// Only the constant operands of an expression are folded.
+			input:     "let x = 1; x + 2 * 3",
+			constants: []interface{}{1, 6},
+			instructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpSetGlobal, 0),
+				Make(OpGetGlobal, 0),
+				Make(OpConstant, 1),
+				Make(OpAdd),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			// Expressions that fail are left to fail when they run.
+			input:     "1 / 0",
+			constants: []interface{}{1, 0},
+			instructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpConstant, 1),
+				Make(OpDiv),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			input:     "9223372036854775807 + 1",
+			constants: []interface{}{9223372036854775807, 1},
+			instructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpConstant, 1),
+				Make(OpAdd),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			input:     "#pragma version 2\n1 == \"1\"",
+			constants: []interface{}{1, "1"},
+			instructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpConstant, 1),
+				Make(OpEqual),
+				Make(OpReturnValue),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		bytecode := compile(t, tt.input, true)
+		testInstructions(t, tt.input, tt.instructions, bytecode.Main.Instructions)
+		testConstants(t, tt.input, tt.constants, bytecode.Constants)
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input        string
+		instructions []Instructions
+	}{
+		{
+			// Conditional jumps on constants are folded, and the branch
+			// that never runs is dropped.
+			input:        "if (1 < 2) { 3 } else { 4 }",
+			instructions: []Instructions{Make(OpConstant, 0), Make(OpReturnValue)},
+		},
+		{
+			input:        "if (!true) { 3 }",
+			instructions: []Instructions{Make(OpNull), Make(OpReturnValue)},
+		},
+		{
+			// Constants popped right away are dropped.
+			input: "1; 2; let x = 3; x",
+			instructions: []Instructions{
+				Make(OpConstant, 2),
+				Make(OpSetGlobal, 0),
+				Make(OpGetGlobal, 0),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			// Jumps to jumps jump to where those lead.
+			input: "let x = 1; if (x) { if (x) { 2 } } else { 3 }",
+			instructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpSetGlobal, 0),
+				Make(OpGetGlobal, 0),
+				Make(OpJumpNotTruthy, 28),
+				Make(OpGetGlobal, 0),
+				Make(OpJumpNotTruthy, 24),
+				Make(OpConstant, 1),
+				Make(OpJump, 31),
+				Make(OpNull),
+				Make(OpJump, 31),
+				Make(OpConstant, 2),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			// Jumps can land between instructions, which aren't fused.
+			input: "let x = 1; (x ?? true) == false",
+			instructions: []Instructions{
+				Make(OpConstant, 0),
+				Make(OpSetGlobal, 0),
+				Make(OpGetGlobal, 0),
+				Make(OpJumpNotNull, 13),
+				Make(OpTrue),
+				Make(OpFalse),
+				Make(OpEqual),
+				Make(OpReturnValue),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		bytecode := compile(t, tt.input, true)
+		testInstructions(t, tt.input, tt.instructions, bytecode.Main.Instructions)
+	}
+
+	// The code after a return is dropped, and the locations of the
+	// instructions follow them.
+	input := "let f = fn(a) { return a; 1; a + 2 }; f(1)"
+	var fn *CompiledFunction
+	for _, c := range compile(t, input, true).Constants {
+		if c, ok := c.(*CompiledFunction); ok {
+			fn = c
+		}
+	}
+	testInstructions(t, input, []Instructions{Make(OpGetLocal, 0), Make(OpReturnValue)}, fn.Instructions)
+	if loc := fn.Locations[0]; loc.Name != "a" || loc.Position.Column != 24 {
+		t.Errorf("wrong location. got=%v", fn.Locations)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"f(" + strings.Repeat("1, ", 256) + "1)", "compiler: too many arguments in call"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%q: parser errors: %v", tt.input, p.Errors())
+		}
+		_, err := Compile(program)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: want error %q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	// Undeclared names are an error when the assignment runs.
+	bytecode := compile(t, "x += 1", false)
+	err, ok := bytecode.Constants[0].(*object.Error)
+	if !ok || err.Message != "cannot assign to x: it wasn't declared with let" {
+		t.Errorf("wrong error constant. got=%v", bytecode.Constants[0])
+	}
+	testInstructions(t, "x += 1", []Instructions{Make(OpError, 0), Make(OpReturnValue)},
+		bytecode.Main.Instructions)
+}
+
+// compile compiles the input, with optimizations if optimize is set.
+func compile(t *testing.T, input string, optimize bool) *Bytecode {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: parser errors: %v", input, p.Errors())
+	}
+	c := New()
+	c.Optimize = optimize
+	bytecode, err := c.Compile(program)
+	if err != nil {
+		t.Fatalf("%q: compiler error: %s", input, err)
+	}
+	return bytecode
+}
+
+func testInstructions(t *testing.T, input string, expected []Instructions, actual Instructions) {
+	t.Helper()
+	concatted := Instructions{}
+	for _, ins := range expected {
+		concatted = append(concatted, ins...)
+	}
+	if actual.String() != concatted.String() {
+		t.Errorf("%q: wrong instructions.\nwant=\n%s\ngot=\n%s", input, concatted, actual)
+	}
+}
+
+func testConstants(t *testing.T, input string, expected []interface{}, actual []object.Object) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Errorf("%q: wrong number of constants. want=%d, got=%d", input, len(expected), len(actual))
+		return
+	}
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			if integer, ok := actual[i].(*object.Integer); !ok || integer.Value != int64(constant) {
+				t.Errorf("%q: constant %d is not %d. got=%s", input, i, constant, actual[i].Inspect())
+			}
+		case string:
+			if str, ok := actual[i].(*object.String); !ok || str.Value != constant {
+				t.Errorf("%q: constant %d is not %q. got=%s", input, i, constant, actual[i].Inspect())
+			}
+		case []Instructions:
+			fn, ok := actual[i].(*CompiledFunction)
+			if !ok {
+				t.Errorf("%q: constant %d is not a function. got=%T", input, i, actual[i])
+				continue
+			}
+			testInstructions(t, input, constant, fn.Instructions)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	input := `let f = fn(a, b = 1.5) { a + b + len("hou") }; let x = f(1); y = 2`
+	bytecode := compile(t, input, true)
+	data, err := Encode(bytecode)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if again, _ := Encode(decoded); string(again) != string(data) {
+		t.Errorf("decoded program encodes differently")
+	}
+	if decoded.NumGlobals != bytecode.NumGlobals || decoded.Features != bytecode.Features {
+		t.Errorf("wrong header. want=%d %v, got=%d %v", bytecode.NumGlobals, bytecode.Features,
+			decoded.NumGlobals, decoded.Features)
+	}
+	testInstructions(t, input, []Instructions{bytecode.Main.Instructions}, decoded.Main.Instructions)
+	for i, c := range bytecode.Constants {
+		if decoded.Constants[i].Inspect() != c.Inspect() {
+			t.Errorf("constant %d wrong. want=%s, got=%s", i, c.Inspect(), decoded.Constants[i].Inspect())
+		}
+	}
+
+	valid := string(data)
+	header := "HOUC" + string(rune(FormatVersion))
+	// main encodes a program without constants whose main function has
+	// no locals and the instructions.
+	main := func(ins ...byte) string {
+		return header + "\x01\x00\x00\x00\x00\x00" + string(rune(len(ins))) + string(ins) + "\x00\x00"
+	}
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{"", "compiler: not compiled hou code"},
+		{"#!/usr/bin/env hou", "compiler: not compiled hou code"},
+		{"HOUC" + string(rune(FormatVersion+1)), fmt.Sprintf(
+			"compiler: compiled by another version of hou: format %d, want %d", FormatVersion+1, FormatVersion)},
+		{header + "\x09", "compiler: malformed compiled code: unknown language version 9"},
+		{valid[:len(valid)-1], "compiler: malformed compiled code: "},
+		{valid + "\x00", "compiler: malformed compiled code: 1 trailing bytes"},
+		{main(255), "compiler: malformed compiled code: opcode 255 undefined"},
+		{main(Make(OpConstant, 5)...), "compiler: malformed compiled code: OpConstant refers to a bad constant 5"},
+		{main(Make(OpConstant, 5)[:2]...), "compiler: malformed compiled code: truncated OpConstant"},
+		{main(Make(OpGetGlobal, 0)...), "compiler: malformed compiled code: OpGetGlobal refers to a bad global 0"},
+		{main(Make(OpGetLocal, 0)...), "compiler: malformed compiled code: OpGetLocal refers to a bad local 0"},
+	}
+	for _, tt := range tests {
+		_, err := Decode([]byte(tt.data))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.data, tt.expected, err)
+		}
+	}
+}
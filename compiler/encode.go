@@ -0,0 +1,368 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/object"
+	"github.com/cedrickchee/hou/token"
+)
+
+// magic starts every encoded program, the .houc files of `hou build`.
+const magic = "HOUC"
+
+// FormatVersion is the version of the encoding of programs. It must change
+// whenever the encoding or the opcodes do, e.g. when an opcode is added or its
+// operands change, so that programs compiled by other versions of hou are
+// rejected instead of run wrongly.
+const FormatVersion = 4
+
+// The tags of the kinds of constants.
+const (
+	tagInteger  = 'i'
+	tagFloat    = 'f'
+	tagString   = 's'
+	tagError    = 'e'
+	tagFunction = 'c'
+)
+
+// Encode returns the binary encoding of the program, which Decode reads back,
+// e.g. to run it later without parsing and compiling it again. After a header
+// of magic, FormatVersion, the language version and the number of globals, it
+// holds the constant pool, each constant tagged with its kind, and the main
+// function. Functions hold their instructions, their locations and, encoded as
+// JSON by ast.Encode, their literals.
+func Encode(b *Bytecode) ([]byte, error) {
+	e := &encoder{}
+	e.buf.WriteString(magic)
+	e.uint(FormatVersion)
+	e.uint(uint64(b.Features.Version()))
+	e.uint(uint64(b.NumGlobals))
+
+	e.uint(uint64(len(b.Constants)))
+	for _, c := range b.Constants {
+		if err := e.constant(c); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.function(b.Main); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uint(n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutUvarint(b[:], n)])
+}
+
+func (e *encoder) int(n int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutVarint(b[:], n)])
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) constant(c object.Object) error {
+	switch c := c.(type) {
+	case *object.Integer:
+		e.buf.WriteByte(tagInteger)
+		e.int(c.Value)
+	case *object.Float:
+		e.buf.WriteByte(tagFloat)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(c.Value))
+		e.buf.Write(b[:])
+	case *object.String:
+		e.buf.WriteByte(tagString)
+		e.bytes([]byte(c.Value))
+	case *object.Error:
+		e.buf.WriteByte(tagError)
+		e.bytes([]byte(c.Code))
+		e.bytes([]byte(c.Message))
+	case *CompiledFunction:
+		e.buf.WriteByte(tagFunction)
+		return e.function(c)
+	default:
+		return fmt.Errorf("compiler: cannot encode constant of type %s", c.Type())
+	}
+	return nil
+}
+
+func (e *encoder) function(fn *CompiledFunction) error {
+	e.uint(uint64(fn.NumLocals))
+	e.uint(uint64(fn.NumParameters))
+	e.uint(uint64(fn.NumRequired))
+	e.bytes(fn.Instructions)
+
+	offsets := make([]int, 0, len(fn.Locations))
+	for offset := range fn.Locations {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+	e.uint(uint64(len(offsets)))
+	for _, offset := range offsets {
+		loc := fn.Locations[offset]
+		e.uint(uint64(offset))
+		e.uint(uint64(loc.Position.Line))
+		e.uint(uint64(loc.Position.Column))
+		e.bytes([]byte(loc.Name))
+		e.bytes([]byte(loc.Suggestion))
+	}
+
+	if fn.Literal == nil {
+		e.bytes(nil)
+		return nil
+	}
+	literal, err := ast.Encode(fn.Literal)
+	if err != nil {
+		return err
+	}
+	e.bytes(literal)
+	return nil
+}
+
+// Decode decodes a program encoded by Encode. It returns an error if the data
+// isn't one, was encoded by another FormatVersion, or is malformed, e.g. it
+// has undefined opcodes or refers to constants that don't exist.
+func Decode(data []byte) (*Bytecode, error) {
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, fmt.Errorf("compiler: not compiled hou code")
+	}
+	d := &decoder{data: data[len(magic):]}
+	if v := d.uint(); d.err == nil && v != FormatVersion {
+		return nil, fmt.Errorf("compiler: compiled by another version of hou: format %d, want %d", v, FormatVersion)
+	}
+	version := lang.Version(d.uint())
+	if d.err == nil && (version < lang.Default || version > lang.Latest) {
+		d.fail("unknown language version %d", version)
+	}
+
+	b := &Bytecode{
+		Features:   lang.For(version),
+		NumGlobals: d.count(),
+	}
+	b.Constants = make([]object.Object, d.count())
+	for i := range b.Constants {
+		b.Constants[i] = d.constant()
+	}
+	b.Main = d.function()
+	if d.err == nil && len(d.data) > 0 {
+		d.fail("%d trailing bytes", len(d.data))
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	for _, c := range b.Constants {
+		if fn, ok := c.(*CompiledFunction); ok {
+			d.check(fn, b)
+		}
+	}
+	d.check(b.Main, b)
+	if d.err != nil {
+		return nil, d.err
+	}
+	return b, nil
+}
+
+// decoder decodes programs, and keeps the first error, after which it returns
+// zero values.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("compiler: malformed compiled code: "+format, a...)
+	}
+}
+
+func (d *decoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(d.data)
+	if size <= 0 {
+		d.fail("bad number")
+		return 0
+	}
+	d.data = d.data[size:]
+	return n
+}
+
+func (d *decoder) int() int64 {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Varint(d.data)
+	if size <= 0 {
+		d.fail("bad number")
+		return 0
+	}
+	d.data = d.data[size:]
+	return n
+}
+
+// count decodes a number of things that follow, which can't be more than the
+// bytes left, so that malformed data can't make it allocate too much.
+func (d *decoder) count() int {
+	n := d.uint()
+	if n > uint64(len(d.data)) {
+		d.fail("bad count %d", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	if d.err != nil {
+		return nil
+	}
+	b := d.data[:n:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) == 0 {
+		d.fail("unexpected end")
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) constant() object.Object {
+	switch tag := d.byte(); tag {
+	case tagInteger:
+		return &object.Integer{Value: d.int()}
+	case tagFloat:
+		if len(d.data) < 8 {
+			d.fail("unexpected end")
+			return nil
+		}
+		bits := binary.BigEndian.Uint64(d.data)
+		d.data = d.data[8:]
+		return &object.Float{Value: math.Float64frombits(bits)}
+	case tagString:
+		return &object.String{Value: string(d.bytes())}
+	case tagError:
+		code := diagnostic.Code(d.bytes())
+		return &object.Error{Code: code, Message: string(d.bytes())}
+	case tagFunction:
+		return d.function()
+	default:
+		d.fail("unknown constant tag %q", tag)
+		return nil
+	}
+}
+
+func (d *decoder) function() *CompiledFunction {
+	fn := &CompiledFunction{
+		NumLocals:     int(d.uint()),
+		NumParameters: int(d.uint()),
+		NumRequired:   int(d.uint()),
+		Instructions:  Instructions(d.bytes()),
+	}
+	if n := d.count(); n > 0 {
+		fn.Locations = make(map[int]Location, n)
+		for i := 0; i < n; i++ {
+			offset := int(d.uint())
+			pos := token.Position{Line: int(d.uint()), Column: int(d.uint())}
+			fn.Locations[offset] = Location{
+				Position:   pos,
+				Name:       string(d.bytes()),
+				Suggestion: string(d.bytes()),
+			}
+		}
+	}
+
+	if literal := d.bytes(); len(literal) > 0 && d.err == nil {
+		node, err := ast.Decode(literal)
+		if err != nil {
+			d.fail("%s", err)
+			return fn
+		}
+		fn.Literal, _ = node.(*ast.FunctionLiteral)
+		if fn.Literal == nil {
+			d.fail("function literal is a %T", node)
+		}
+	}
+	if d.err == nil && (fn.NumRequired > fn.NumParameters || fn.NumParameters > fn.NumLocals || fn.NumLocals > 256) {
+		d.fail("bad parameter counts")
+	}
+	return fn
+}
+
+// check checks that the instructions of the function are well formed, that
+// the operands that refer to constants refer to ones of the right kind and
+// that the ones of variables are in range, since the VM trusts the compiler
+// not to get those wrong.
+func (d *decoder) check(fn *CompiledFunction, b *Bytecode) {
+	constants := b.Constants
+	ins := fn.Instructions
+	for i := 0; i < len(ins) && d.err == nil; {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			d.fail("%s", err)
+			return
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if i+1+width > len(ins) {
+			d.fail("truncated %s", def.Name)
+			return
+		}
+		operands, _ := ReadOperands(def, ins[i+1:])
+
+		var want func(object.Object) bool
+		switch Opcode(ins[i]) {
+		case OpConstant:
+			want = func(object.Object) bool { return true }
+		case OpGetBuiltin, OpMember:
+			want = func(c object.Object) bool { _, ok := c.(*object.String); return ok }
+		case OpError:
+			want = func(c object.Object) bool { _, ok := c.(*object.Error); return ok }
+		case OpClosure:
+			want = func(c object.Object) bool { _, ok := c.(*CompiledFunction); return ok }
+		case OpGetGlobal, OpSetGlobal, OpAssignGlobal:
+			if operands[0] >= b.NumGlobals {
+				d.fail("%s refers to a bad global %d", def.Name, operands[0])
+			}
+		case OpGetLocal, OpSetLocal, OpAssignLocal, OpGetCell, OpSetCell, OpAssignCell, OpNewCell, OpBox:
+			if operands[0] >= fn.NumLocals {
+				d.fail("%s refers to a bad local %d", def.Name, operands[0])
+			}
+		case OpJump, OpJumpNotTruthy, OpJumpNotNull, OpMatch, OpNext, OpJumpArgument:
+			if operands[0] > len(ins) {
+				d.fail("%s jumps out of the function", def.Name)
+			}
+		}
+		if want != nil && (operands[0] >= len(constants) || !want(constants[operands[0]])) {
+			d.fail("%s refers to a bad constant %d", def.Name, operands[0])
+		}
+		i += 1 + width
+	}
+}
@@ -0,0 +1,337 @@
+package compiler
+
+import (
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/object"
+)
+
+// comparisons maps the opcodes of comparisons to their operators, for the
+// optimizer to fold them.
+var comparisons = map[Opcode]string{
+	OpEqual:        "==",
+	OpNotEqual:     "!=",
+	OpLessThan:     "<",
+	OpGreaterThan:  ">",
+	OpLessEqual:    "<=",
+	OpGreaterEqual: ">=",
+}
+
+// instruction is an instruction decoded by the optimizer. The first operand of
+// jumps is the index of the instruction they jump to instead of its offset, so
+// that the instructions can be removed and replaced.
+type instruction struct {
+	op       Opcode
+	operands []int
+	location *Location
+	dead     bool
+}
+
+// jumps reports whether the first operand of the instruction is the offset
+// of an instruction it may jump to.
+func (ins *instruction) jumps() bool {
+	switch ins.op {
+	case OpJump, OpJumpNotTruthy, OpJumpNotNull, OpMatch, OpNext, OpJumpArgument:
+		return true
+	}
+	return false
+}
+
+// falls reports whether the instruction may be followed by the next one.
+func (ins *instruction) falls() bool {
+	switch ins.op {
+	case OpJump, OpReturnValue, OpError:
+		return false
+	}
+	return true
+}
+
+// optimizer rewrites the instructions of a function.
+type optimizer struct {
+	c    *Compiler
+	code []*instruction
+}
+
+// optimize rewrites the instructions of the function to do the same in fewer
+// of them: it drops the code no jump or instruction leads to, e.g. after a
+// return, makes jumps to jumps jump to where those lead, folds comparisons
+// and negations of constants and the conditional jumps on them, e.g. of
+// `while (true)`, and drops the constants that are popped right away, e.g.
+// of expression statements like `1;`. The locations of the instructions
+// follow them.
+func (c *Compiler) optimize(fn *CompiledFunction) {
+	o := &optimizer{c: c}
+	indexes := make(map[int]int)
+	for offset := 0; offset < len(fn.Instructions); {
+		def, err := Lookup(fn.Instructions[offset])
+		if err != nil {
+			return
+		}
+		operands, read := ReadOperands(def, fn.Instructions[offset+1:])
+		ins := &instruction{op: Opcode(fn.Instructions[offset]), operands: operands}
+		if loc, ok := fn.Locations[offset]; ok {
+			ins.location = &loc
+		}
+		indexes[offset] = len(o.code)
+		o.code = append(o.code, ins)
+		offset += 1 + read
+	}
+	indexes[len(fn.Instructions)] = len(o.code)
+	for _, ins := range o.code {
+		if ins.jumps() {
+			ins.operands[0] = indexes[ins.operands[0]]
+		}
+	}
+
+	for o.jumpChains() || o.constants() || o.pops() || o.unreachable() {
+	}
+	fn.Instructions, fn.Locations = o.assemble()
+}
+
+// next returns the index of the first live instruction at or after the index,
+// where jumps to the index land, or len(o.code) if there is none.
+func (o *optimizer) next(i int) int {
+	for i < len(o.code) && o.code[i].dead {
+		i++
+	}
+	return i
+}
+
+// targets returns whether jumps jump to each index. Jumps to an index land
+// on the first live instruction at or after it, so jumps to indexes between
+// instructions land on the second, which can't be fused with the first.
+func (o *optimizer) targets() []bool {
+	targets := make([]bool, len(o.code)+1)
+	for _, ins := range o.code {
+		if !ins.dead && ins.jumps() {
+			targets[ins.operands[0]] = true
+		}
+	}
+	return targets
+}
+
+// jumpChains makes jumps that land on unconditional jumps land where those
+// lead, and drops unconditional jumps to the next instruction. It reports
+// whether it changed anything.
+func (o *optimizer) jumpChains() bool {
+	changed := false
+	for i, ins := range o.code {
+		if ins.dead || !ins.jumps() {
+			continue
+		}
+		target := o.next(ins.operands[0])
+		// Stop at loops of jumps, which never end anyway.
+		seen := make(map[int]bool)
+		for target < len(o.code) && o.code[target].op == OpJump && !seen[target] {
+			seen[target] = true
+			target = o.next(o.code[target].operands[0])
+		}
+		if target != ins.operands[0] {
+			ins.operands[0] = target
+			changed = true
+		}
+		if ins.op == OpJump && target == o.next(i+1) {
+			ins.dead = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// value returns the value the live instruction at the index pushes, if it's
+// a constant.
+func (o *optimizer) value(i int) (object.Object, bool) {
+	ins := o.code[i]
+	switch ins.op {
+	case OpConstant:
+		switch value := o.c.constants[ins.operands[0]].(type) {
+		case *object.Integer, *object.Float, *object.String:
+			return value, true
+		}
+	case OpTrue:
+		return object.TRUE, true
+	case OpFalse:
+		return object.FALSE, true
+	case OpNull:
+		return object.NULL, true
+	}
+	return nil, false
+}
+
+// live returns the indexes of the n live instructions that end with the one
+// at the index, or nil if jumps land on any but the first of them.
+func (o *optimizer) live(i, n int, targets []bool) []int {
+	indexes := make([]int, n)
+	for n--; n >= 0; n-- {
+		if i < 0 {
+			return nil
+		}
+		indexes[n] = i
+		for i--; i >= 0 && o.code[i].dead; i-- {
+		}
+	}
+	for i := indexes[0] + 1; i <= indexes[len(indexes)-1]; i++ {
+		if targets[i] {
+			return nil
+		}
+	}
+	return indexes
+}
+
+// replace replaces the live instructions at the indexes, whose results are
+// the value, with the instruction that pushes the boolean.
+func (o *optimizer) replace(indexes []int, value object.Object) {
+	for _, i := range indexes[:len(indexes)-1] {
+		o.code[i].dead = true
+	}
+	op := OpFalse
+	if value == object.TRUE {
+		op = OpTrue
+	}
+	o.code[indexes[len(indexes)-1]] = &instruction{op: op, operands: []int{}}
+}
+
+// constants folds the comparisons and negations of constants, and the
+// conditional jumps on them. It reports whether it changed anything.
+func (o *optimizer) constants() bool {
+	changed := false
+	targets := o.targets()
+	for i, ins := range o.code {
+		if ins.dead {
+			continue
+		}
+		switch {
+		case comparisons[ins.op] != "":
+			indexes := o.live(i, 3, targets)
+			if indexes == nil {
+				continue
+			}
+			left, ok := o.value(indexes[0])
+			if !ok {
+				continue
+			}
+			right, ok := o.value(indexes[1])
+			if !ok {
+				continue
+			}
+			value := evaluator.EvalInfixWith(o.c.features, comparisons[ins.op], left, right)
+			if _, ok := value.(*object.Boolean); ok {
+				o.replace(indexes, value)
+				changed = true
+			}
+
+		case ins.op == OpBang:
+			indexes := o.live(i, 2, targets)
+			if indexes == nil {
+				continue
+			}
+			if right, ok := o.value(indexes[0]); ok {
+				o.replace(indexes, evaluator.EvalPrefix("!", right))
+				changed = true
+			}
+
+		case ins.op == OpJumpNotTruthy:
+			indexes := o.live(i, 2, targets)
+			if indexes == nil {
+				continue
+			}
+			if value, ok := o.value(indexes[0]); ok {
+				o.code[indexes[0]].dead = true
+				if evaluator.IsTruthy(value) {
+					ins.dead = true
+				} else {
+					ins.op = OpJump
+				}
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// pops drops the constants, and the closures being run, that are popped
+// right away. It reports whether it changed anything.
+func (o *optimizer) pops() bool {
+	changed := false
+	targets := o.targets()
+	for i, ins := range o.code {
+		if ins.dead || ins.op != OpPop {
+			continue
+		}
+		indexes := o.live(i, 2, targets)
+		if indexes == nil {
+			continue
+		}
+		push := o.code[indexes[0]]
+		if _, ok := o.value(indexes[0]); ok || push.op == OpCurrentClosure {
+			push.dead = true
+			ins.dead = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// unreachable drops the instructions that neither the start of the function
+// nor a jump leads to. It reports whether it changed anything.
+func (o *optimizer) unreachable() bool {
+	reached := make([]bool, len(o.code)+1)
+	stack := []int{o.next(0)}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if reached[i] {
+			continue
+		}
+		reached[i] = true
+		if i == len(o.code) {
+			continue
+		}
+		ins := o.code[i]
+		if ins.jumps() {
+			stack = append(stack, o.next(ins.operands[0]))
+		}
+		if ins.falls() {
+			stack = append(stack, o.next(i+1))
+		}
+	}
+
+	changed := false
+	for i, ins := range o.code {
+		if !ins.dead && !reached[i] {
+			ins.dead = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// assemble encodes the live instructions, with the offsets of the ones jumps
+// land on, and returns them and their locations.
+func (o *optimizer) assemble() (Instructions, map[int]Location) {
+	offsets := make([]int, len(o.code)+1)
+	offset := 0
+	for i, ins := range o.code {
+		offsets[i] = offset
+		if !ins.dead {
+			offset += len(Make(ins.op, ins.operands...))
+		}
+	}
+	offsets[len(o.code)] = offset
+
+	instructions := make(Instructions, 0, offset)
+	locations := make(map[int]Location)
+	for i, ins := range o.code {
+		if ins.dead {
+			continue
+		}
+		operands := ins.operands
+		if ins.jumps() {
+			operands = append([]int{offsets[o.next(operands[0])]}, operands[1:]...)
+		}
+		if ins.location != nil {
+			locations[offsets[i]] = *ins.location
+		}
+		instructions = append(instructions, Make(ins.op, operands...)...)
+	}
+	return instructions, locations
+}
@@ -0,0 +1,141 @@
+package compiler
+
+// SymbolScope tells where the value of a variable is kept.
+type SymbolScope string
+
+const (
+	// GlobalScope is the scope of the names bound at the top level of the
+	// program, outside of any block scope.
+	GlobalScope SymbolScope = "GLOBAL"
+	// LocalScope is the scope of parameters and names bound in functions,
+	// or in block scopes, that no closure captures.
+	LocalScope SymbolScope = "LOCAL"
+	// CellScope is the scope of locals that closures capture. They're kept
+	// in cells, which the closures share with the function.
+	CellScope SymbolScope = "CELL"
+	// FreeScope is the scope of the names a closure captured from the
+	// functions enclosing it.
+	FreeScope SymbolScope = "FREE"
+)
+
+// Symbol is a variable: its name, where it's kept and its index there.
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable holds the symbols of a scope: the program, a function or, with
+// lang.BlockScoping, a block. The locals of block scopes are locals of the
+// function the block is in, or of the main function of the program for
+// blocks at the top level.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	// FreeSymbols holds the symbols of the enclosing functions that the
+	// function of the table captures, in the order of its free variables.
+	FreeSymbols []Symbol
+
+	store map[string]Symbol
+	// function is the table of the function the scope belongs to: the
+	// table itself, unless it's the table of a block scope.
+	function *SymbolTable
+	// numLocals is the number of locals of the function, and numGlobals the
+	// number of globals if the table is the one of the program.
+	numLocals  int
+	numGlobals int
+}
+
+// NewSymbolTable returns the symbol table of a program.
+func NewSymbolTable() *SymbolTable {
+	s := &SymbolTable{store: make(map[string]Symbol)}
+	s.function = s
+	return s
+}
+
+// NewEnclosedSymbolTable returns the symbol table of a function defined in
+// the scope of outer.
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// NewBlockSymbolTable returns the symbol table of a block scope in the scope
+// of outer.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	return &SymbolTable{
+		Outer:    outer,
+		store:    make(map[string]Symbol),
+		function: outer.function,
+	}
+}
+
+// NumLocals returns the number of locals of the function of the table.
+func (s *SymbolTable) NumLocals() int { return s.function.numLocals }
+
+// NumGlobals returns the number of globals of the program, if s is the table
+// of the program.
+func (s *SymbolTable) NumGlobals() int { return s.numGlobals }
+
+// Define binds name in the scope of the table, unless it's bound there
+// already, and returns its symbol. Locals that closures capture are kept in
+// cells.
+func (s *SymbolTable) Define(name string, captured bool) Symbol {
+	if symbol, ok := s.store[name]; ok {
+		return symbol
+	}
+
+	var symbol Symbol
+	switch {
+	case s.Outer == nil:
+		symbol = Symbol{Name: name, Scope: GlobalScope, Index: s.numGlobals}
+		s.numGlobals++
+	case captured:
+		symbol = Symbol{Name: name, Scope: CellScope, Index: s.function.numLocals}
+		s.function.numLocals++
+	default:
+		symbol = Symbol{Name: name, Scope: LocalScope, Index: s.function.numLocals}
+		s.function.numLocals++
+	}
+
+	s.store[name] = symbol
+	return symbol
+}
+
+// Resolve returns the symbol name is bound to in the scope of the table or in
+// the scopes enclosing it. Symbols of the locals of enclosing functions
+// become free variables of the function of the table.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	if ok || s.Outer == nil {
+		return symbol, ok
+	}
+
+	symbol, ok = s.Outer.Resolve(name)
+	if !ok || s.function != s || symbol.Scope == GlobalScope {
+		// Block scopes share the locals of their function.
+		return symbol, ok
+	}
+	return s.defineFree(symbol), true
+}
+
+// Names returns the names bound in the scope of the table and in the scopes
+// enclosing it.
+func (s *SymbolTable) Names() []string {
+	var names []string
+	for table := s; table != nil; table = table.Outer {
+		for name := range table.store {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Scope: FreeScope, Index: len(s.FreeSymbols) - 1}
+	s.store[original.Name] = symbol
+	return symbol
+}
@@ -0,0 +1,247 @@
+package diagnostic
+
+// Package diagnostic defines the stable codes of the errors the parser and
+// the evaluator report and a machine-readable form of them, so that editors
+// and CI can consume the results of running a script without having to parse
+// error messages, which may change.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cedrickchee/hou/token"
+)
+
+// Code identifies a kind of error. Codes never change meaning once they're
+// released: new kinds of errors get new codes.
+//
+// Parser errors use codes E1xxx, runtime errors E2xxx, errors about the
+// limits of an evaluation E3xxx and type errors found by `hou check` E4xxx.
+// Warnings use codes Wxxxx.
+type Code string
+
+const (
+	// UnexpectedToken is reported when the parser expected a different
+	// token, e.g. a missing closing parenthesis.
+	UnexpectedToken Code = "E1001"
+	// NoPrefixParseFn is reported when a token can't start an expression.
+	NoPrefixParseFn Code = "E1002"
+	// InvalidInteger is reported for integer literals that don't fit 64 bits.
+	InvalidInteger Code = "E1003"
+	// YieldOutsideFunction is reported for `yield` outside of a function.
+	YieldOutsideFunction Code = "E1004"
+	// InvalidPragma is reported for pragmas the parser doesn't know, or
+	// that come after the first statement.
+	InvalidPragma Code = "E1005"
+	// InvalidFloat is reported for float literals too large for 64 bits.
+	InvalidFloat Code = "E1006"
+	// UnterminatedComment is reported for block comments missing their
+	// closing */.
+	UnterminatedComment Code = "E1007"
+	// InvalidAssignment is reported for assignments to something that isn't
+	// a name, e.g. 5 = x.
+	InvalidAssignment Code = "E1008"
+	// MissingDefault is reported for parameters without a default value
+	// after one with a default value, e.g. fn(a = 1, b).
+	MissingDefault Code = "E1009"
+	// KeywordNeedsVersion is reported for keywords of a later version of the
+	// language used as such in a script of an earlier one, e.g. a while loop
+	// without `#pragma version 2`.
+	KeywordNeedsVersion Code = "E1010"
+
+	// TypeMismatch is reported for operators applied to operands of
+	// different types, e.g. 1 + true.
+	TypeMismatch Code = "E2001"
+	// UnknownOperator is reported for operators that don't support the types
+	// of their operands, e.g. -true.
+	UnknownOperator Code = "E2002"
+	// IdentifierNotFound is reported for identifiers that aren't bound.
+	IdentifierNotFound Code = "E2003"
+	// NotAFunction is reported when calling something that isn't a function.
+	NotAFunction Code = "E2004"
+	// IndexNotSupported is reported when indexing something that can't be
+	// indexed.
+	IndexNotSupported Code = "E2005"
+	// UnusableHashKey is reported for hash keys that can't be hashed.
+	UnusableHashKey Code = "E2006"
+	// WrongArgumentCount is reported when a builtin is called with the wrong
+	// number of arguments.
+	WrongArgumentCount Code = "E2007"
+	// WrongArgumentType is reported when a builtin is called with an
+	// argument of the wrong type.
+	WrongArgumentType Code = "E2008"
+	// InvalidArgument is reported when a builtin is called with an argument
+	// of the right type but a value it doesn't accept.
+	InvalidArgument Code = "E2009"
+	// BuiltinNotAllowed is reported for builtins the sandbox doesn't allow.
+	BuiltinNotAllowed Code = "E2010"
+	// IOError is reported when reading or writing files, channels or the
+	// standard streams failed.
+	IOError Code = "E2011"
+	// YieldOutsideGenerator is reported for `yield` evaluated outside of a
+	// running generator.
+	YieldOutsideGenerator Code = "E2012"
+	// HostError is reported for errors of the Go functions and values an
+	// embedding application makes available to programs.
+	HostError Code = "E2013"
+	// AccessDenied is reported when the sandbox denied access to a file, the
+	// network, other programs or the environment.
+	AccessDenied Code = "E2014"
+	// TypeAssertion is reported when a value doesn't have the type it's
+	// annotated with, if the annotations are checked at runtime.
+	TypeAssertion Code = "E2015"
+	// DivisionByZero is reported for divisions by zero.
+	DivisionByZero Code = "E2016"
+	// UndeclaredAssignment is reported for assignments to names that no let
+	// statement or parameter bound.
+	UndeclaredAssignment Code = "E2017"
+	// NotIterable is reported for for loops over values that aren't arrays,
+	// hashes or strings.
+	NotIterable Code = "E2018"
+	// IndexOutOfRange is reported for assignments to elements of arrays past
+	// their end.
+	IndexOutOfRange Code = "E2019"
+	// MemberNotSupported is reported for member access, e.g. `x.name`, on
+	// values that aren't hashes.
+	MemberNotSupported Code = "E2020"
+	// ArithmeticOverflow is reported for integer arithmetic that overflowed
+	// 64 bits.
+	ArithmeticOverflow Code = "E2021"
+	// AssertionFailed is reported for the assertions of programs that
+	// failed, e.g. `assert(1 > 2)`.
+	AssertionFailed Code = "E2022"
+	// Exited is reported when a program stopped itself by calling `exit`,
+	// which isn't a failure unless its exit status says so.
+	Exited Code = "E2023"
+
+	// StepLimitExceeded is reported when an evaluation took too many steps.
+	StepLimitExceeded Code = "E3001"
+	// MemoryLimitExceeded is reported when an evaluation allocated too much.
+	MemoryLimitExceeded Code = "E3002"
+	// Cancelled is reported when an evaluation was cancelled or timed out.
+	Cancelled Code = "E3003"
+	// InternalError is reported when the interpreter panicked, which is a bug
+	// in the interpreter or in a builtin.
+	InternalError Code = "E3004"
+	// StackOverflow is reported when a program has too many calls in
+	// progress, e.g. because of infinite recursion.
+	StackOverflow Code = "E3005"
+	// TaskLimitExceeded is reported when a program started more tasks than
+	// the sandbox lets run at the same time.
+	TaskLimitExceeded Code = "E3006"
+
+	// UnknownType is reported for type annotations naming no known type.
+	UnknownType Code = "E4001"
+	// IncompatibleType is reported for values whose type isn't the one they're
+	// annotated with, e.g. let x: Int = "five";
+	IncompatibleType Code = "E4002"
+	// ArgumentCountMismatch is reported for calls of functions with the wrong
+	// number of arguments.
+	ArgumentCountMismatch Code = "E4003"
+
+	// DeprecatedBuiltin is reported for the use of deprecated builtins.
+	DeprecatedBuiltin Code = "W2002"
+)
+
+// Severity tells how bad a diagnostic is.
+type Severity string
+
+const (
+	// Error is the severity of problems that stop the program.
+	Error Severity = "error"
+	// Warning is the severity of problems that don't stop the program.
+	Warning Severity = "warning"
+)
+
+// Diagnostic is a problem found in a script, in a form meant for tools.
+type Diagnostic struct {
+	Code     Code     `json:"code"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	// File is the name of the script, if it's known.
+	File string `json:"file,omitempty"`
+	// Line and Column are zero if the position isn't known.
+	Line   int `json:"line,omitempty"`
+	Column int `json:"column,omitempty"`
+}
+
+// New returns a diagnostic with the severity Error.
+func New(code Code, pos token.Position, message string) Diagnostic {
+	return Diagnostic{
+		Code:     code,
+		Severity: Error,
+		Message:  message,
+		Line:     pos.Line,
+		Column:   pos.Column,
+	}
+}
+
+// String returns the diagnostic in the form compilers use, e.g.
+// `script.hou:12:8: error E2001: type mismatch: INTEGER + BOOLEAN`.
+func (d Diagnostic) String() string {
+	var parts []string
+	if d.File != "" {
+		parts = append(parts, d.File)
+	}
+	if d.Line > 0 {
+		parts = append(parts, fmt.Sprint(d.Line), fmt.Sprint(d.Column))
+	}
+	location := ""
+	if len(parts) > 0 {
+		location = strings.Join(parts, ":") + ": "
+	}
+	code := ""
+	if d.Code != "" {
+		code = " " + string(d.Code)
+	}
+	return fmt.Sprintf("%s%s%s: %s", location, d.Severity, code, d.Message)
+}
+
+// Describe returns the message followed by the position, if it's known, in
+// the form runtime errors are described in, e.g.
+// `no prefix parse function for = found at line 1, col 7`.
+func (d Diagnostic) Describe() string {
+	if d.Line <= 0 {
+		return d.Message
+	}
+	return fmt.Sprintf("%s at line %d, col %d", d.Message, d.Line, d.Column)
+}
+
+// Format is the format diagnostics are written in.
+type Format string
+
+const (
+	// Text writes one diagnostic per line, see Diagnostic.String.
+	Text Format = "text"
+	// JSON writes one JSON object per diagnostic and line.
+	JSON Format = "json"
+)
+
+// ParseFormat returns the format called name.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(name); f {
+	case Text, JSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown diagnostics format %q, want %q or %q",
+		name, Text, JSON)
+}
+
+// Write writes the diagnostics to w in the format f.
+func Write(w io.Writer, f Format, diagnostics []Diagnostic) error {
+	enc := json.NewEncoder(w)
+	for _, d := range diagnostics {
+		var err error
+		if f == JSON {
+			err = enc.Encode(d)
+		} else {
+			_, err = fmt.Fprintln(w, d)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
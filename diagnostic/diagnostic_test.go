@@ -0,0 +1,62 @@
+package diagnostic
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cedrickchee/hou/token"
+)
+
+func TestWrite(t *testing.T) {
+	diagnostics := []Diagnostic{
+		New(TypeMismatch, token.Position{Line: 12, Column: 8},
+			"type mismatch: INTEGER + BOOLEAN"),
+		New(Cancelled, token.Position{}, "evaluation cancelled: context canceled"),
+	}
+	diagnostics[0].File = "script.hou"
+
+	tests := []struct {
+		format   Format
+		expected string
+	}{
+		{Text, `script.hou:12:8: error E2001: type mismatch: INTEGER + BOOLEAN
+error E3003: evaluation cancelled: context canceled
+`},
+		{JSON, `{"code":"E2001","severity":"error","message":"type mismatch: INTEGER + BOOLEAN","file":"script.hou","line":12,"column":8}
+{"code":"E3003","severity":"error","message":"evaluation cancelled: context canceled"}
+`},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := Write(&out, tt.format, diagnostics); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.format, err)
+		}
+		if out.String() != tt.expected {
+			t.Errorf("%s: wrong output. want=%q, got=%q",
+				tt.format, tt.expected, out.String())
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	d := New(UnexpectedToken, token.Position{Line: 3, Column: 17}, "expected next token to be )")
+	if got := d.Describe(); got != "expected next token to be ) at line 3, col 17" {
+		t.Errorf("wrong description. got=%q", got)
+	}
+	d = New(Cancelled, token.Position{}, "evaluation cancelled")
+	if got := d.Describe(); got != "evaluation cancelled" {
+		t.Errorf("wrong description without a position. got=%q", got)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, name := range []string{"text", "json"} {
+		if f, err := ParseFormat(name); err != nil || string(f) != name {
+			t.Errorf("ParseFormat(%q) = %q, %v", name, f, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
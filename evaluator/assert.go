@@ -0,0 +1,106 @@
+package evaluator
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// Assertions records the failed assertions of programs, see the `assert` and
+// `assertEq` builtins, so that a program reports all its failures instead of
+// stopping at the first one. That's how `hou test` runs tests. Tasks started
+// by `spawn` record their failures in the same Assertions.
+type Assertions struct {
+	mu       sync.Mutex
+	failures []*object.Error
+}
+
+// Failures returns the failures recorded, in the order they happened. They're
+// errors with the position of the assertion that failed.
+func (a *Assertions) Failures() []*object.Error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]*object.Error(nil), a.failures...)
+}
+
+// Reset forgets the failures recorded.
+func (a *Assertions) Reset() {
+	a.mu.Lock()
+	a.failures = nil
+	a.mu.Unlock()
+}
+
+func (a *Assertions) record(err *object.Error) {
+	a.mu.Lock()
+	a.failures = append(a.failures, err)
+	a.mu.Unlock()
+}
+
+// assert returns TRUE if ok, or else the failure described by the message and
+// the format, which is an error unless the Evaluator records assertions, in
+// which case it returns FALSE.
+func (e *Evaluator) assert(ok bool, message object.Object, format string, a ...interface{}) object.Object {
+	if ok {
+		return TRUE
+	}
+	text := "assertion failed"
+	if message != nil {
+		text += ": " + message.Inspect()
+	}
+	if format != "" {
+		text += ": " + fmt.Sprintf(format, a...)
+	}
+
+	err := newError(diagnostic.AssertionFailed, "%s", text)
+	if e.Assertions == nil {
+		return err
+	}
+	if e.site != nil {
+		e.locate(err, e.site)
+	}
+	e.Assertions.record(err)
+	return FALSE
+}
+
+// equal reports whether a and b are equal: arrays and hashes element by
+// element, and other values with ==.
+func (e *Evaluator) equal(a, b object.Object) bool {
+	switch a := a.(type) {
+	case *object.Array:
+		b, ok := b.(*object.Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !e.equal(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *object.Hash:
+		b, ok := b.(*object.Hash)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		for _, pair := range a.Pairs() {
+			other, ok := b.Get(pair.Key.(object.Hashable).HashKey())
+			if !ok || !e.equal(pair.Value, other.Value) {
+				return false
+			}
+		}
+		return true
+	}
+	return EvalInfixWith(e.features, "==", a, b) == TRUE
+}
+
+// quote returns the value as it's written in failed assertions, strings in
+// quotes, so that `1` and `"1"` tell apart.
+func quote(obj object.Object) string {
+	if s, ok := obj.(*object.String); ok {
+		return strconv.Quote(s.Value)
+	}
+	return obj.Inspect()
+}
@@ -1,122 +1,1164 @@
 package evaluator
 
 import (
-	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
 
+	"github.com/cedrickchee/hou/builtinerr"
+	"github.com/cedrickchee/hou/diagnostic"
 	"github.com/cedrickchee/hou/object"
 )
 
-var builtins = map[string]*object.Builtin{
-	"len": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			// Error checking that makes sure that we can't call this function
-			// with the wrong number of arguments.
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
+// newBuiltins returns the builtin functions of the Evaluator. Every Evaluator
+// gets its own table, so builtins can write to the Evaluator's output and
+// embedders can add their own without affecting other Evaluators.
+func (e *Evaluator) newBuiltins() map[string]*object.Builtin {
+	return map[string]*object.Builtin{
+		"len": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Error checking that makes sure that we can't call this function
+				// with the wrong number of arguments.
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
 
-			switch arg := args[0].(type) {
-			case *object.Array:
-				return &object.Integer{Value: int64(len(arg.Elements))}
-			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
-			default:
-				// Error checking that makes sure that we can't call this
-				// function with an argument of an unsupported type.
-				return newError("argument to `len` not supported, got %s",
-					args[0].Type())
-			}
-		},
-	},
-	"first": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `first` must be ARRAY, got %s",
-					args[0].Type())
-			}
+				switch arg := args[0].(type) {
+				case *object.Array:
+					return object.NewInteger(int64(len(arg.Elements)))
+				case *object.String:
+					// The number of bytes, not of characters, see substr.
+					return object.NewInteger(int64(len(arg.Value)))
+				default:
+					// Error checking that makes sure that we can't call this
+					// function with an argument of an unsupported type.
+					return builtinerr.ArgType("len", args, 0,
+						object.STRING_OBJ, object.ARRAY_OBJ)
+				}
+			},
+		},
+		"first": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				if args[0].Type() != object.ARRAY_OBJ {
+					return builtinerr.ArgType("first", args, 0, object.ARRAY_OBJ)
+				}
 
-			arr := args[0].(*object.Array)
-			if len(arr.Elements) > 0 {
-				return arr.Elements[0]
-			}
+				arr := args[0].(*object.Array)
+				if len(arr.Elements) > 0 {
+					return arr.Elements[0]
+				}
 
-			return NULL
-		},
-	},
-	"last": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `last` must be ARRAY, got %s",
-					args[0].Type())
-			}
+				return NULL
+			},
+		},
+		"last": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				if args[0].Type() != object.ARRAY_OBJ {
+					return builtinerr.ArgType("last", args, 0, object.ARRAY_OBJ)
+				}
 
-			arr := args[0].(*object.Array)
-			length := len(arr.Elements)
-			if length > 0 {
-				return arr.Elements[length-1]
-			}
+				arr := args[0].(*object.Array)
+				length := len(arr.Elements)
+				if length > 0 {
+					return arr.Elements[length-1]
+				}
 
-			return NULL
-		},
-	},
-	"rest": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `rest` must be ARRAY, got %s",
-					args[0].Type())
-			}
+				return NULL
+			},
+		},
+		"rest": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				if args[0].Type() != object.ARRAY_OBJ {
+					return builtinerr.ArgType("rest", args, 0, object.ARRAY_OBJ)
+				}
 
-			arr := args[0].(*object.Array)
-			length := len(arr.Elements)
-			if length > 0 {
-				newElements := make([]object.Object, length-1, length-1)
-				copy(newElements, arr.Elements[1:length])
-				return &object.Array{Elements: newElements}
-			}
+				arr := args[0].(*object.Array)
+				if len(arr.Elements) == 0 {
+					return NULL
+				}
 
-			return NULL
-		},
-	},
-	"push": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 2 {
-				return newError("wrong number of arguments. got=%d, want=2",
-					len(args))
-			}
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `push` must be ARRAY, got %s",
-					args[0].Type())
-			}
+				// The rest shares the elements of the array.
+				rest, allocated := arr.Rest()
+				return e.allocatedArray(rest, allocated)
+			},
+		},
+		"push": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				if err := builtinerr.ArgCount(args, 2, 2); err != nil {
+					return err
+				}
+				if args[0].Type() != object.ARRAY_OBJ {
+					return builtinerr.ArgType("push", args, 0, object.ARRAY_OBJ)
+				}
 
-			arr := args[0].(*object.Array)
-			length := len(arr.Elements)
+				// Pushing onto the last array pushed onto doesn't copy the
+				// elements, so building an array with push is linear.
+				arr := args[0].(*object.Array)
+				result, allocated := arr.Push(args[1])
+				return e.allocatedArray(result, allocated)
+			},
+		},
+		"map": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the results of calling the function with every
+				// element of the array, or a generator of the results for
+				// every value of a generator.
+				seq, fn, err := sequenceAndFunction("map", args, 2, 2)
+				if err != nil {
+					return err
+				}
+				arr, ok := seq.(*object.Array)
+				if !ok {
+					return e.mapGenerator(seq.(object.Iterator), fn)
+				}
+				results := make([]object.Object, len(arr.Elements))
+				for i, el := range arr.Elements {
+					result := e.applyFunction(fn, []object.Object{el})
+					if isError(result) {
+						return result
+					}
+					results[i] = result
+				}
+				return e.allocated(&object.Array{Elements: results})
+			},
+		},
+		"filter": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the elements of the array the function returns a
+				// truthy value for, or a generator of the values of a
+				// generator it does.
+				seq, fn, err := sequenceAndFunction("filter", args, 2, 2)
+				if err != nil {
+					return err
+				}
+				arr, ok := seq.(*object.Array)
+				if !ok {
+					return e.filterGenerator(seq.(object.Iterator), fn)
+				}
+				results := []object.Object{}
+				for _, el := range arr.Elements {
+					result := e.applyFunction(fn, []object.Object{el})
+					if isError(result) {
+						return result
+					}
+					if isTruthy(result) {
+						results = append(results, el)
+					}
+				}
+				return e.allocated(&object.Array{Elements: results})
+			},
+		},
+		"reduce": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Folds the array or the values of the generator into one
+				// value by calling the function with the value so far and
+				// every element, starting with the optional initial value,
+				// or else the first element.
+				seq, fn, err := sequenceAndFunction("reduce", args, 2, 3)
+				if err != nil {
+					return err
+				}
+				it, err := e.NewIterator(seq)
+				if err != nil {
+					return err
+				}
+				var acc object.Object
+				if len(args) == 3 {
+					acc = args[2]
+				} else {
+					first, ok := it.NextElement()
+					if !ok {
+						if err := it.Err(); err != nil {
+							return err
+						}
+						return newError(diagnostic.InvalidArgument,
+							"reduce of an empty %s without an initial value",
+							strings.ToLower(string(seq.Type())))
+					}
+					acc = first
+				}
+				for {
+					el, ok := it.NextElement()
+					if !ok {
+						break
+					}
+					acc = e.applyFunction(fn, []object.Object{acc, el})
+					if isError(acc) {
+						return acc
+					}
+				}
+				if err := it.Err(); err != nil {
+					return err
+				}
+				return acc
+			},
+		},
+		"str": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the value as it's printed, strings as they are.
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				if str, ok := args[0].(*object.String); ok {
+					return str
+				}
+				return e.allocated(&object.String{Value: args[0].Inspect()})
+			},
+		},
+		"int": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				return toInteger(args)
+			},
+		},
+		"bool": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				return toBoolean(args[0])
+			},
+		},
+		"type": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the name of the type of the value, e.g. "INTEGER".
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				return e.allocated(&object.String{Value: string(args[0].Type())})
+			},
+		},
+		"puts": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				values := make([]string, len(args))
+				for i, arg := range args {
+					values[i] = arg.Inspect()
+				}
+				e.println(values...)
+				return NULL
+			},
+		},
+		"print": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Unlike puts, writes all the values on one line, separated
+				// by spaces.
+				values := make([]string, len(args))
+				for i, arg := range args {
+					values[i] = arg.Inspect()
+				}
+				e.println(strings.Join(values, " "))
+				return NULL
+			},
+		},
+		"input": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Reads a line of input, after printing the optional prompt.
+				if err := builtinerr.ArgCount(args, 0, 1); err != nil {
+					return err
+				}
+				prompt := ""
+				if len(args) == 1 {
+					str, ok := args[0].(*object.String)
+					if !ok {
+						return builtinerr.ArgType("input", args, 0, object.STRING_OBJ)
+					}
+					prompt = str.Value
+				}
 
-			newElements := make([]object.Object, length+1, length+1)
-			copy(newElements, arr.Elements)
-			newElements[length] = args[1]
+				line, err := e.readLine(prompt)
+				if err == io.EOF {
+					return NULL
+				}
+				if err != nil {
+					return builtinerr.Wrap(diagnostic.IOError, "input", err)
+				}
+				return e.allocated(&object.String{Value: line})
+			},
+		},
+		"args": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the command-line arguments of the program as an
+				// array of strings.
+				if err := builtinerr.ArgCount(args, 0, 0); err != nil {
+					return err
+				}
+				elements := make([]object.Object, len(e.Args))
+				for i, arg := range e.Args {
+					elements[i] = &object.String{Value: arg}
+				}
+				return e.allocated(&object.Array{Elements: elements})
+			},
+		},
+		"exit": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Stops the program with the exit status, 0 by default.
+				// It unwinds like an error, which `hou run` turns into
+				// the exit status of the process, so the status must be
+				// one a process can exit with.
+				if err := builtinerr.ArgCount(args, 0, 1); err != nil {
+					return err
+				}
+				status := int64(0)
+				if len(args) == 1 {
+					code, ok := args[0].(*object.Integer)
+					if !ok {
+						return builtinerr.ArgType("exit", args, 0, object.INTEGER_OBJ)
+					}
+					status = code.Value
+				}
+				if status < 0 || status > 255 {
+					return newError(diagnostic.InvalidArgument,
+						"argument to `exit` must be between 0 and 255, got %d", status)
+				}
+				err := newError(diagnostic.Exited, "exit status %d", status)
+				err.Status = int(status)
+				return err
+			},
+		},
+		"spawn": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Calls the function with the rest of the arguments on its own
+				// goroutine and returns a task to wait for its result with.
+				if err := builtinerr.ArgCount(args, 1, builtinerr.Variadic); err != nil {
+					return err
+				}
+				if !isCallable(args[0]) {
+					return builtinerr.ArgType("spawn", args, 0, object.FUNCTION_OBJ)
+				}
+				return e.spawn(args[0], args[1:])
+			},
+		},
+		"wait": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Waits for the task to be done and returns its result, or
+				// for the timer to be stopped.
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				switch arg := args[0].(type) {
+				case *object.Task:
+					return e.wait(arg)
+				case *object.Timer:
+					return e.waitTimer(arg)
+				default:
+					return builtinerr.ArgType("wait", args, 0, object.TASK_OBJ, object.TIMER_OBJ)
+				}
+			},
+		},
+		"chan": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Creates a channel with the optional capacity, 0 by default.
+				if err := builtinerr.ArgCount(args, 0, 1); err != nil {
+					return err
+				}
+				capacity := int64(0)
+				if len(args) == 1 {
+					n, ok := args[0].(*object.Integer)
+					if !ok {
+						return builtinerr.ArgType("chan", args, 0, object.INTEGER_OBJ)
+					}
+					if n.Value < 0 {
+						return newError(diagnostic.InvalidArgument,
+							"argument to `chan` must not be negative, got %d",
+							n.Value)
+					}
+					if n.Value > maxChannelCapacity {
+						return newError(diagnostic.InvalidArgument,
+							"argument to `chan` must be at most %d, got %d",
+							maxChannelCapacity, n.Value)
+					}
+					capacity = n.Value
+				}
+				// The buffer is allocated up front, so it's accounted for
+				// before.
+				if err := e.allocatedBytes(objectSize * (1 + capacity)); err != nil {
+					return err
+				}
+				return object.NewChannel(int(capacity))
+			},
+		},
+		"send": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				if err := builtinerr.ArgCount(args, 2, 2); err != nil {
+					return err
+				}
+				ch, ok := args[0].(*object.Channel)
+				if !ok {
+					return builtinerr.ArgType("send", args, 0, object.CHANNEL_OBJ)
+				}
+				return e.send(ch, args[1])
+			},
+		},
+		"recv": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				ch, ok := args[0].(*object.Channel)
+				if !ok {
+					return builtinerr.ArgType("recv", args, 0, object.CHANNEL_OBJ)
+				}
+				return e.recv(ch)
+			},
+		},
+		"close": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				// Closes a channel or a file.
+				closer, ok := args[0].(interface{ Close() error })
+				if !ok {
+					return builtinerr.ArgType("close", args, 0,
+						object.CHANNEL_OBJ, object.FILE_OBJ)
+				}
+				if err := closer.Close(); err != nil {
+					return builtinerr.Wrap(diagnostic.IOError, "close", err)
+				}
+				return NULL
+			},
+		},
+		"async": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Like spawn, but the task is meant to be awaited, like a
+				// promise.
+				if err := builtinerr.ArgCount(args, 1, builtinerr.Variadic); err != nil {
+					return err
+				}
+				if !isCallable(args[0]) {
+					return builtinerr.ArgType("async", args, 0, object.FUNCTION_OBJ)
+				}
+				return e.spawn(args[0], args[1:])
+			},
+		},
+		"await": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the result of the task, waiting for it if needed.
+				// Any other value is its own result.
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				return e.await(args[0])
+			},
+		},
+		"all": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Awaits all elements of the array and returns their results.
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				arr, ok := args[0].(*object.Array)
+				if !ok {
+					return builtinerr.ArgType("all", args, 0, object.ARRAY_OBJ)
+				}
 
-			return &object.Array{Elements: newElements}
+				results := make([]object.Object, len(arr.Elements))
+				for i, el := range arr.Elements {
+					result := e.await(el)
+					if isError(result) {
+						return result
+					}
+					results[i] = result
+				}
+				return e.allocated(&object.Array{Elements: results})
+			},
+		},
+		"after": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Calls the function once, after the given number of
+				// milliseconds.
+				return e.timerBuiltin("after", args, false)
+			},
+		},
+		"every": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Calls the function every given number of milliseconds.
+				return e.timerBuiltin("every", args, true)
+			},
+		},
+		"cancel": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				timer, ok := args[0].(*object.Timer)
+				if !ok {
+					return builtinerr.ArgType("cancel", args, 0, object.TIMER_OBJ)
+				}
+				timer.Stop()
+				return NULL
+			},
+		},
+		"onSignal": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Installs the function as the handler of the named signal,
+				// e.g. "INT". The handler is called with the name.
+				if err := builtinerr.ArgCount(args, 2, 2); err != nil {
+					return err
+				}
+				name, ok := args[0].(*object.String)
+				if !ok {
+					return builtinerr.ArgType("onSignal", args, 0, object.STRING_OBJ)
+				}
+				if !isCallable(args[1]) {
+					return builtinerr.ArgType("onSignal", args, 1, object.FUNCTION_OBJ)
+				}
+				return e.onSignal(name.Value, args[1])
+			},
+		},
+		"next": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the next value of a generator, or null once it's
+				// exhausted.
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				it, ok := args[0].(object.Iterator)
+				if !ok {
+					return builtinerr.ArgType("next", args, 0, object.GENERATOR_OBJ)
+				}
+				return e.next(it)
+			},
+		},
+		"open": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Opens the file with the optional mode: "r" (the default),
+				// "w" or "a".
+				if err := builtinerr.ArgCount(args, 1, 2); err != nil {
+					return err
+				}
+				path, ok := args[0].(*object.String)
+				if !ok {
+					return builtinerr.ArgType("open", args, 0, object.STRING_OBJ)
+				}
+				mode := "r"
+				if len(args) == 2 {
+					str, ok := args[1].(*object.String)
+					if !ok {
+						return builtinerr.ArgType("open", args, 1, object.STRING_OBJ)
+					}
+					mode = str.Value
+				}
+				return e.open(path.Value, mode)
+			},
+		},
+		"read": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Reads the rest of the file.
+				f, err := fileArgument("read", args)
+				if err != nil {
+					return err
+				}
+				s, rerr := f.ReadAll()
+				if rerr != nil {
+					return builtinerr.Wrap(diagnostic.IOError, "read", rerr)
+				}
+				return e.allocated(&object.String{Value: s})
+			},
+		},
+		"readLine": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Reads the next line of the file, or null at its end.
+				f, err := fileArgument("readLine", args)
+				if err != nil {
+					return err
+				}
+				line, rerr := f.ReadLine()
+				if rerr == io.EOF {
+					return NULL
+				}
+				if rerr != nil {
+					return builtinerr.Wrap(diagnostic.IOError, "readLine", rerr)
+				}
+				return e.allocated(&object.String{Value: line})
+			},
+		},
+		"write": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Writes the string to the file and returns the number of
+				// bytes written.
+				if err := builtinerr.ArgCount(args, 2, 2); err != nil {
+					return err
+				}
+				f, err := fileArgument("write", args[:1])
+				if err != nil {
+					return err
+				}
+				str, ok := args[1].(*object.String)
+				if !ok {
+					return builtinerr.ArgType("write", args, 1, object.STRING_OBJ)
+				}
+				n, werr := f.Write(str.Value)
+				if werr != nil {
+					return builtinerr.Wrap(diagnostic.IOError, "write", werr)
+				}
+				return object.NewInteger(int64(n))
+			},
+		},
+		"readFile": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the contents of the file.
+				values, err := pathArguments("readFile", args, 1)
+				if err != nil {
+					return err
+				}
+				return e.readFile(values[0])
+			},
 		},
-	},
-	"puts": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			for _, arg := range args {
-				fmt.Println(arg.Inspect())
-			}
-			return NULL
+		"writeFile": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Replaces the contents of the file, creating it if needed,
+				// and returns the number of bytes written.
+				values, err := pathArguments("writeFile", args, 2)
+				if err != nil {
+					return err
+				}
+				return e.writeFile("writeFile", values[0], values[1], os.O_TRUNC)
+			},
 		},
-	},
+		"appendFile": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Appends to the file, creating it if needed, and returns the
+				// number of bytes written.
+				values, err := pathArguments("appendFile", args, 2)
+				if err != nil {
+					return err
+				}
+				return e.writeFile("appendFile", values[0], values[1], os.O_APPEND)
+			},
+		},
+		"exists": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Reports whether there's a file or directory at the path.
+				values, err := pathArguments("exists", args, 1)
+				if err != nil {
+					return err
+				}
+				return e.exists(values[0])
+			},
+		},
+		"pmap": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Maps the function over the array in parallel, on the
+				// optional number of workers, by default one per CPU.
+				if err := builtinerr.ArgCount(args, 2, 3); err != nil {
+					return err
+				}
+				arr, ok := args[0].(*object.Array)
+				if !ok {
+					return builtinerr.ArgType("pmap", args, 0, object.ARRAY_OBJ)
+				}
+				if !isCallable(args[1]) {
+					return builtinerr.ArgType("pmap", args, 1, object.FUNCTION_OBJ)
+				}
+				workers := runtime.NumCPU()
+				if max := e.Sandbox; max != nil && max.MaxTasks > 0 && int64(workers) > max.MaxTasks {
+					workers = int(max.MaxTasks)
+				}
+				if len(args) == 3 {
+					n, ok := args[2].(*object.Integer)
+					if !ok {
+						return builtinerr.ArgType("pmap", args, 2, object.INTEGER_OBJ)
+					}
+					if n.Value < 1 {
+						return newError(diagnostic.InvalidArgument,
+							"number of workers must be positive, got %d",
+							n.Value)
+					}
+					workers = int(n.Value)
+				}
+				return e.pmap(arr, args[1], workers)
+			},
+		},
+		"strBuilder": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns a new string builder, optionally starting with the
+				// given strings.
+				b := &object.Builder{}
+				for i, arg := range args {
+					str, ok := arg.(*object.String)
+					if !ok {
+						return builtinerr.ArgType("strBuilder", args, i,
+							object.STRING_OBJ)
+					}
+					b.Append(str.Value)
+				}
+				return e.allocated(b)
+			},
+		},
+		"append": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Appends the strings to the builder and returns it, so that
+				// calls can be chained.
+				if err := builtinerr.ArgCount(args, 1, builtinerr.Variadic); err != nil {
+					return err
+				}
+				b, ok := args[0].(*object.Builder)
+				if !ok {
+					return builtinerr.ArgType("append", args, 0, object.BUILDER_OBJ)
+				}
+				for i, arg := range args[1:] {
+					str, ok := arg.(*object.String)
+					if !ok {
+						return builtinerr.ArgType("append", args, i+1,
+							object.STRING_OBJ)
+					}
+					if err := e.allocatedBytes(int64(len(str.Value))); err != nil {
+						return err
+					}
+					b.Append(str.Value)
+				}
+				return b
+			},
+		},
+		"build": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the string built so far. The builder can still be
+				// appended to afterwards.
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				b, ok := args[0].(*object.Builder)
+				if !ok {
+					return builtinerr.ArgType("build", args, 0, object.BUILDER_OBJ)
+				}
+				return &object.String{Value: b.String()}
+			},
+		},
+		"split": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Splits the string around the optional separator, into its
+				// characters if it's "", or around whitespace by default.
+				strs, err := stringArguments("split", args, 1, 2)
+				if err != nil {
+					return err
+				}
+				return e.allocated(split(strs[0], strs[1:]))
+			},
+		},
+		"join": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Joins an array of strings with the optional separator, ""
+				// by default.
+				if err := builtinerr.ArgCount(args, 1, 2); err != nil {
+					return err
+				}
+				array, ok := args[0].(*object.Array)
+				if !ok {
+					return builtinerr.ArgType("join", args, 0, object.ARRAY_OBJ)
+				}
+				sep := ""
+				if len(args) == 2 {
+					str, ok := args[1].(*object.String)
+					if !ok {
+						return builtinerr.ArgType("join", args, 1, object.STRING_OBJ)
+					}
+					sep = str.Value
+				}
+				return e.allocated(join(array, sep))
+			},
+		},
+		"trim": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Removes the leading and trailing characters that are in the
+				// optional cutset, whitespace by default.
+				strs, err := stringArguments("trim", args, 1, 2)
+				if err != nil {
+					return err
+				}
+				if len(strs) == 1 {
+					return e.allocated(&object.String{Value: strings.TrimSpace(strs[0])})
+				}
+				return e.allocated(&object.String{Value: strings.Trim(strs[0], strs[1])})
+			},
+		},
+		"replace": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Replaces all the occurrences of the second string in the
+				// first one with the third one.
+				strs, err := stringArguments("replace", args, 3, 3)
+				if err != nil {
+					return err
+				}
+				return e.allocated(&object.String{
+					Value: strings.ReplaceAll(strs[0], strs[1], strs[2]),
+				})
+			},
+		},
+		"contains": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Reports whether the second string is in the first one.
+				strs, err := stringArguments("contains", args, 2, 2)
+				if err != nil {
+					return err
+				}
+				return nativeBoolToBooleanObject(strings.Contains(strs[0], strs[1]))
+			},
+		},
+		"upper": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				strs, err := stringArguments("upper", args, 1, 1)
+				if err != nil {
+					return err
+				}
+				return e.allocated(&object.String{Value: strings.ToUpper(strs[0])})
+			},
+		},
+		"lower": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				strs, err := stringArguments("lower", args, 1, 1)
+				if err != nil {
+					return err
+				}
+				return e.allocated(&object.String{Value: strings.ToLower(strs[0])})
+			},
+		},
+		"substr": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the characters of the string from the start on, up
+				// to the optional length. A negative start counts from the
+				// end. Both count characters, not bytes like len does.
+				if err := builtinerr.ArgCount(args, 2, 3); err != nil {
+					return err
+				}
+				str, ok := args[0].(*object.String)
+				if !ok {
+					return builtinerr.ArgType("substr", args, 0, object.STRING_OBJ)
+				}
+				start, ok := args[1].(*object.Integer)
+				if !ok {
+					return builtinerr.ArgType("substr", args, 1, object.INTEGER_OBJ)
+				}
+				length := int64(-1)
+				if len(args) == 3 {
+					n, ok := args[2].(*object.Integer)
+					if !ok {
+						return builtinerr.ArgType("substr", args, 2, object.INTEGER_OBJ)
+					}
+					if n.Value < 0 {
+						return newError(diagnostic.InvalidArgument,
+							"length to `substr` must not be negative, got %d", n.Value)
+					}
+					length = n.Value
+				}
+				return e.allocated(substr(str.Value, start.Value, length))
+			},
+		},
+		"storeOpen": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Opens the key-value store in the file, which is created
+				// by the first change if it doesn't exist.
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				path, ok := args[0].(*object.String)
+				if !ok {
+					return builtinerr.ArgType("storeOpen", args, 0, object.STRING_OBJ)
+				}
+				return e.openStore(path.Value)
+			},
+		},
+		"storeGet": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the value of the key, or the optional default if
+				// the key isn't in the store, null by default.
+				s, key, err := storeArguments("storeGet", args, 2, 3)
+				if err != nil {
+					return err
+				}
+				value, ok, gerr := s.Get(key)
+				if gerr != nil {
+					return builtinerr.Wrap(diagnostic.IOError, "storeGet", gerr)
+				}
+				if !ok {
+					if len(args) == 3 {
+						return args[2]
+					}
+					return NULL
+				}
+				return e.allocated(value)
+			},
+		},
+		"storeSet": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Sets the value of the key and saves the store.
+				s, key, err := storeArguments("storeSet", args, 3, 3)
+				if err != nil {
+					return err
+				}
+				if serr := s.Set(key, args[2]); serr != nil {
+					if _, ok := serr.(*object.UnstorableError); ok {
+						return builtinerr.Wrap(diagnostic.InvalidArgument, "storeSet", serr)
+					}
+					return builtinerr.Wrap(diagnostic.IOError, "storeSet", serr)
+				}
+				return args[2]
+			},
+		},
+		"storeDelete": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Deletes the key and returns whether it was in the store.
+				s, key, err := storeArguments("storeDelete", args, 2, 2)
+				if err != nil {
+					return err
+				}
+				ok, derr := s.Delete(key)
+				if derr != nil {
+					return builtinerr.Wrap(diagnostic.IOError, "storeDelete", derr)
+				}
+				return nativeBoolToBooleanObject(ok)
+			},
+		},
+		"storeKeys": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the keys of the store, sorted.
+				s, _, err := storeArguments("storeKeys", args, 1, 1)
+				if err != nil {
+					return err
+				}
+				keys := s.Keys()
+				elements := make([]object.Object, len(keys))
+				for i, key := range keys {
+					elements[i] = &object.String{Value: key}
+				}
+				return e.allocated(&object.Array{Elements: elements})
+			},
+		},
+		"dateNow": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the current date in the local time zone.
+				if err := builtinerr.ArgCount(args, 0, 0); err != nil {
+					return err
+				}
+				return &object.Date{Time: time.Now()}
+			},
+		},
+		"dateParse": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Parses the date with the optional layout, RFC 3339 by
+				// default. Dates without a time zone are in UTC.
+				if err := builtinerr.ArgCount(args, 1, 2); err != nil {
+					return err
+				}
+				str, ok := args[0].(*object.String)
+				if !ok {
+					return builtinerr.ArgType("dateParse", args, 0, object.STRING_OBJ)
+				}
+				layout, err := layoutArgument("dateParse", args, 1)
+				if err != nil {
+					return err
+				}
+				t, perr := time.Parse(layout, str.Value)
+				if perr != nil {
+					return builtinerr.Wrap(diagnostic.InvalidArgument, "dateParse", perr)
+				}
+				return &object.Date{Time: t}
+			},
+		},
+		"dateFormat": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Formats the date with the optional layout, RFC 3339 by
+				// default.
+				if err := builtinerr.ArgCount(args, 1, 2); err != nil {
+					return err
+				}
+				d, ok := args[0].(*object.Date)
+				if !ok {
+					return builtinerr.ArgType("dateFormat", args, 0, object.DATE_OBJ)
+				}
+				layout, err := layoutArgument("dateFormat", args, 1)
+				if err != nil {
+					return err
+				}
+				return e.allocated(&object.String{Value: d.Time.Format(layout)})
+			},
+		},
+		"dateParts": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the year, month, day, ... of the date as a hash.
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				d, ok := args[0].(*object.Date)
+				if !ok {
+					return builtinerr.ArgType("dateParts", args, 0, object.DATE_OBJ)
+				}
+				return e.allocated(dateParts(d))
+			},
+		},
+		"dateUnix": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Converts a date to the number of seconds since January 1,
+				// 1970 UTC, or such a number to a date in UTC.
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				switch arg := args[0].(type) {
+				case *object.Date:
+					return object.NewInteger(arg.Time.Unix())
+				case *object.Integer:
+					return &object.Date{Time: time.Unix(arg.Value, 0).UTC()}
+				default:
+					return builtinerr.ArgType("dateUnix", args, 0,
+						object.DATE_OBJ, object.INTEGER_OBJ)
+				}
+			},
+		},
+		"duration": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the duration written like "1h30m" or "250ms", or of
+				// the number of milliseconds.
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				switch arg := args[0].(type) {
+				case *object.String:
+					d, err := time.ParseDuration(arg.Value)
+					if err != nil {
+						return builtinerr.Wrap(diagnostic.InvalidArgument, "duration", err)
+					}
+					return &object.Duration{Value: d}
+				case *object.Integer:
+					return &object.Duration{Value: time.Duration(arg.Value) * time.Millisecond}
+				default:
+					return builtinerr.ArgType("duration", args, 0,
+						object.STRING_OBJ, object.INTEGER_OBJ)
+				}
+			},
+		},
+		"durationMs": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Returns the duration in whole milliseconds.
+				if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+					return err
+				}
+				d, ok := args[0].(*object.Duration)
+				if !ok {
+					return builtinerr.ArgType("durationMs", args, 0, object.DURATION_OBJ)
+				}
+				return object.NewInteger(int64(d.Value / time.Millisecond))
+			},
+		},
+		"csvParse": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Parses CSV text into an array of records, with the options
+				// {"header": bool, "separator": string, "tsv": bool}.
+				if err := builtinerr.ArgCount(args, 1, 2); err != nil {
+					return err
+				}
+				text, ok := args[0].(*object.String)
+				if !ok {
+					return builtinerr.ArgType("csvParse", args, 0, object.STRING_OBJ)
+				}
+				opts, err := parseCSVOptions("csvParse", args, 1, csvOptions{})
+				if err != nil {
+					return err
+				}
+				return e.csvParse(text.Value, opts)
+			},
+		},
+		"csvStringify": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Writes an array of records as CSV text, with the same
+				// options as csvParse.
+				if err := builtinerr.ArgCount(args, 1, 2); err != nil {
+					return err
+				}
+				rows, ok := args[0].(*object.Array)
+				if !ok {
+					return builtinerr.ArgType("csvStringify", args, 0, object.ARRAY_OBJ)
+				}
+				opts, err := parseCSVOptions("csvStringify", args, 1,
+					csvOptions{header: true})
+				if err != nil {
+					return err
+				}
+				return e.csvStringify(rows, opts)
+			},
+		},
+		"yieldTask": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Lets other tasks run before the calling one continues.
+				if err := builtinerr.ArgCount(args, 0, 0); err != nil {
+					return err
+				}
+				if err := e.yieldTask(); err != nil {
+					return err
+				}
+				return NULL
+			},
+		},
+		"assert": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Fails unless the condition is truthy, with the optional
+				// message.
+				message, err := assertMessage("assert", args, 1)
+				if err != nil {
+					return err
+				}
+				return e.assert(isTruthy(args[0]), message, "")
+			},
+		},
+		"assertEq": &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				// Fails unless the value got equals the value wanted, with
+				// the optional message.
+				message, err := assertMessage("assertEq", args, 2)
+				if err != nil {
+					return err
+				}
+				return e.assert(e.equal(args[0], args[1]), message,
+					"got %s, want %s", quote(args[0]), quote(args[1]))
+			},
+		},
+	}
+}
+
+// assertMessage checks the arguments of the assertion builtin name, which
+// takes n values and an optional message, and returns the message, or nil.
+func assertMessage(name string, args []object.Object, n int) (object.Object, *object.Error) {
+	if err := builtinerr.ArgCount(args, n, n+1); err != nil {
+		return nil, err
+	}
+	if len(args) == n {
+		return nil, nil
+	}
+	if _, ok := args[n].(*object.String); !ok {
+		return nil, builtinerr.ArgType(name, args, n, object.STRING_OBJ)
+	}
+	return args[n], nil
+}
+
+// sequenceAndFunction checks the arguments of the builtin name that takes an
+// array or a generator and a function, and returns them.
+func sequenceAndFunction(
+	name string,
+	args []object.Object,
+	min, max int,
+) (object.Object, object.Object, *object.Error) {
+	if err := builtinerr.ArgCount(args, min, max); err != nil {
+		return nil, nil, err
+	}
+	switch args[0].(type) {
+	case *object.Array, object.Iterator:
+	default:
+		return nil, nil, builtinerr.ArgType(name, args, 0, object.ARRAY_OBJ, object.GENERATOR_OBJ)
+	}
+	if !isCallable(args[1]) {
+		return nil, nil, builtinerr.ArgType(name, args, 1, object.FUNCTION_OBJ)
+	}
+	return args[0], args[1], nil
 }
@@ -0,0 +1,60 @@
+package evaluator
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/cedrickchee/hou/builtinerr"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// toInteger converts the argument of `int` to an integer. Floats are truncated
+// toward zero, booleans are 1 or 0, and strings must hold a decimal integer,
+// with nothing around it: int("12x") and int(" 12") are errors.
+func toInteger(args []object.Object) object.Object {
+	switch arg := args[0].(type) {
+	case *object.Integer:
+		return arg
+	case *object.Float:
+		if math.IsNaN(arg.Value) || arg.Value < math.MinInt64 || arg.Value >= math.MaxInt64 {
+			return newError(diagnostic.InvalidArgument,
+				"int: can't convert %s to INTEGER", arg.Inspect())
+		}
+		return object.NewInteger(int64(arg.Value))
+	case *object.Boolean:
+		if arg.Value {
+			return object.NewInteger(1)
+		}
+		return object.NewInteger(0)
+	case *object.String:
+		n, err := strconv.ParseInt(arg.Value, 10, 64)
+		if err != nil {
+			return newError(diagnostic.InvalidArgument,
+				"int: can't convert %q to INTEGER", arg.Value)
+		}
+		return object.NewInteger(n)
+	default:
+		return builtinerr.ArgType("int", args, 0,
+			object.INTEGER_OBJ, object.FLOAT_OBJ, object.BOOLEAN_OBJ, object.STRING_OBJ)
+	}
+}
+
+// toBoolean converts the argument of `bool` to a boolean. Strings must be
+// "true" or "false", so that bool can parse input, and anything else is
+// converted to whether it's truthy.
+func toBoolean(arg object.Object) object.Object {
+	str, ok := arg.(*object.String)
+	if !ok {
+		return nativeBoolToBooleanObject(isTruthy(arg))
+	}
+	switch str.Value {
+	case "true":
+		return TRUE
+	case "false":
+		return FALSE
+	default:
+		return newError(diagnostic.InvalidArgument,
+			"bool: can't convert %q to BOOLEAN", str.Value)
+	}
+}
@@ -0,0 +1,245 @@
+package evaluator
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cedrickchee/hou/builtinerr"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// csvOptions are the options of csvParse and csvStringify, given as a hash
+// like {"header": true, "separator": ";"}.
+type csvOptions struct {
+	// header is set if the first record holds the names of the fields, and
+	// the other records are hashes keyed by them.
+	header bool
+	// separator separates the fields, "," by default. {"tsv": true} is a
+	// shorthand for a tab, since Hou strings have no escape sequences.
+	separator rune
+	// tsv is set for tab-separated values, which don't quote fields.
+	tsv bool
+}
+
+// parseCSVOptions reads the options hash, the argument i of the builtin
+// called name, over the defaults.
+func parseCSVOptions(
+	name string,
+	args []object.Object,
+	i int,
+	defaults csvOptions,
+) (csvOptions, *object.Error) {
+	opts := defaults
+	if len(args) <= i {
+		return opts, nil
+	}
+	hash, ok := args[i].(*object.Hash)
+	if !ok {
+		return opts, builtinerr.ArgType(name, args, i, object.HASH_OBJ)
+	}
+
+	for _, pair := range hash.Pairs() {
+		switch pair.Key.Inspect() {
+		case "header", "tsv":
+			b, ok := pair.Value.(*object.Boolean)
+			if !ok {
+				return opts, newError(diagnostic.InvalidArgument,
+					"%s: option %s must be BOOLEAN, got %s",
+					name, pair.Key.Inspect(), pair.Value.Type())
+			}
+			if pair.Key.Inspect() == "header" {
+				opts.header = b.Value
+			} else {
+				opts.tsv = b.Value
+			}
+		case "separator":
+			str, ok := pair.Value.(*object.String)
+			if !ok || utf8.RuneCountInString(str.Value) != 1 {
+				return opts, newError(diagnostic.InvalidArgument,
+					"%s: option separator must be a single character, got %s",
+					name, pair.Value.Inspect())
+			}
+			opts.separator, _ = utf8.DecodeRuneInString(str.Value)
+		default:
+			return opts, newError(diagnostic.InvalidArgument,
+				"%s: unknown option %s", name, pair.Key.Inspect())
+		}
+	}
+
+	if opts.tsv {
+		opts.separator = '\t'
+	}
+	return opts, nil
+}
+
+// csvParse parses the CSV text into an array of records, which are arrays of
+// strings, or hashes keyed by the fields of the header with opts.header.
+func (e *Evaluator) csvParse(text string, opts csvOptions) object.Object {
+	r := csv.NewReader(strings.NewReader(text))
+	if opts.separator != 0 {
+		r.Comma = opts.separator
+	}
+	// Quotes are just characters in tab-separated values.
+	r.LazyQuotes = opts.tsv
+	r.ReuseRecord = true
+
+	var header []*object.String
+	rows := []object.Object{}
+	for {
+		record, err := r.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return builtinerr.Wrap(diagnostic.InvalidArgument, "csvParse", err)
+		}
+
+		fields := make([]object.Object, len(record))
+		for i, field := range record {
+			str := e.allocated(&object.String{Value: field})
+			if isError(str) {
+				return str
+			}
+			fields[i] = str
+		}
+
+		if !opts.header {
+			row := e.allocated(&object.Array{Elements: fields})
+			if isError(row) {
+				return row
+			}
+			rows = append(rows, row)
+			continue
+		}
+		if header == nil {
+			header = make([]*object.String, len(fields))
+			for i, field := range fields {
+				header[i] = field.(*object.String)
+			}
+			continue
+		}
+
+		// The reader makes sure that all records have as many fields as the
+		// header.
+		hash := object.NewHash(len(fields))
+		for i, field := range fields {
+			hash.Set(header[i].HashKey(), object.HashPair{Key: header[i], Value: field})
+		}
+		row := e.allocated(hash)
+		if isError(row) {
+			return row
+		}
+		rows = append(rows, row)
+	}
+
+	return e.allocated(&object.Array{Elements: rows})
+}
+
+// csvStringify writes the rows as CSV text. The rows are either all arrays of
+// fields, or all hashes, whose keys make the header: the ones of the first
+// hash, in order. The header is left out if opts.header is false.
+func (e *Evaluator) csvStringify(rows *object.Array, opts csvOptions) object.Object {
+	var out strings.Builder
+	w := csv.NewWriter(&out)
+	if opts.separator != 0 {
+		w.Comma = opts.separator
+	}
+
+	var header []object.HashPair
+	for i, row := range rows.Elements {
+		var values []object.Object
+
+		switch row := row.(type) {
+		case *object.Array:
+			if header != nil {
+				return csvRowError(i, row, object.HASH_OBJ)
+			}
+			values = row.Elements
+
+		case *object.Hash:
+			if i == 0 {
+				header = row.Pairs()
+				if opts.header {
+					keys := make([]object.Object, len(header))
+					for i, pair := range header {
+						keys[i] = pair.Key
+					}
+					if err := csvWrite(w, keys); err != nil {
+						return err
+					}
+				}
+			} else if header == nil {
+				return csvRowError(i, row, object.ARRAY_OBJ)
+			}
+
+			// Fields missing from the row are empty.
+			values = make([]object.Object, len(header))
+			for i, pair := range header {
+				values[i] = NULL
+				if value, ok := row.Get(pair.Key.(object.Hashable).HashKey()); ok {
+					values[i] = value.Value
+				}
+			}
+
+		default:
+			return csvRowError(i, row, object.ARRAY_OBJ, object.HASH_OBJ)
+		}
+
+		if err := csvWrite(w, values); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return builtinerr.Wrap(diagnostic.InvalidArgument, "csvStringify", err)
+	}
+	return e.allocated(&object.String{Value: out.String()})
+}
+
+// csvWrite writes the values as a record.
+func csvWrite(w *csv.Writer, values []object.Object) *object.Error {
+	record, err := csvRecord(values)
+	if err != nil {
+		return err
+	}
+	if err := w.Write(record); err != nil {
+		return builtinerr.Wrap(diagnostic.InvalidArgument, "csvStringify", err)
+	}
+	return nil
+}
+
+// csvRecord returns the fields for the values: strings as they are, null as
+// an empty field, and numbers, booleans, dates and durations as they're
+// printed.
+func csvRecord(values []object.Object) ([]string, *object.Error) {
+	record := make([]string, len(values))
+	for i, value := range values {
+		switch value := value.(type) {
+		case *object.String:
+			record[i] = value.Value
+		case *object.Integer, *object.Float, *object.Boolean, *object.Date, *object.Duration:
+			record[i] = value.Inspect()
+		case *object.Null:
+			record[i] = ""
+		default:
+			return nil, newError(diagnostic.InvalidArgument,
+				"csvStringify: unsupported field of type %s", value.Type())
+		}
+	}
+	return record, nil
+}
+
+// csvRowError returns the error for the row at index i, which isn't of the
+// types wanted.
+func csvRowError(i int, row object.Object, want ...object.ObjectType) *object.Error {
+	wanted := string(want[0])
+	if len(want) > 1 {
+		wanted += " or " + string(want[1])
+	}
+	return newError(diagnostic.WrongArgumentType,
+		"csvStringify: row %d must be %s, got %s", i, wanted, row.Type())
+}
@@ -0,0 +1,171 @@
+package evaluator
+
+import (
+	"time"
+
+	"github.com/cedrickchee/hou/builtinerr"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// layouts are the names of common layouts of dates, which dateParse and
+// dateFormat take as well as Go layouts like "2006-01-02 15:04".
+var layouts = map[string]string{
+	"RFC3339":  time.RFC3339Nano,
+	"RFC1123":  time.RFC1123,
+	"date":     "2006-01-02",
+	"time":     "15:04:05",
+	"datetime": "2006-01-02 15:04:05",
+}
+
+// layoutArgument returns the layout that's the argument i of the builtin
+// called name, RFC 3339 if there's no such argument.
+func layoutArgument(name string, args []object.Object, i int) (string, *object.Error) {
+	if len(args) <= i {
+		return time.RFC3339Nano, nil
+	}
+	str, ok := args[i].(*object.String)
+	if !ok {
+		return "", builtinerr.ArgType(name, args, i, object.STRING_OBJ)
+	}
+	if layout, ok := layouts[str.Value]; ok {
+		return layout, nil
+	}
+	return str.Value, nil
+}
+
+// dateParts returns the parts of the date as a hash.
+func dateParts(d *object.Date) *object.Hash {
+	t := d.Time
+	zone, offset := t.Zone()
+	parts := []struct {
+		name  string
+		value object.Object
+	}{
+		{"year", object.NewInteger(int64(t.Year()))},
+		{"month", object.NewInteger(int64(t.Month()))},
+		{"day", object.NewInteger(int64(t.Day()))},
+		{"hour", object.NewInteger(int64(t.Hour()))},
+		{"minute", object.NewInteger(int64(t.Minute()))},
+		{"second", object.NewInteger(int64(t.Second()))},
+		{"nanosecond", object.NewInteger(int64(t.Nanosecond()))},
+		// Sunday is 0.
+		{"weekday", object.NewInteger(int64(t.Weekday()))},
+		{"yearday", object.NewInteger(int64(t.YearDay()))},
+		{"zone", &object.String{Value: zone}},
+		// The offset of the zone from UTC in seconds.
+		{"offset", object.NewInteger(int64(offset))},
+	}
+
+	hash := object.NewHash(len(parts))
+	for _, part := range parts {
+		key := &object.String{Value: part.name}
+		hash.Set(key.HashKey(), object.HashPair{Key: key, Value: part.value})
+	}
+	return hash
+}
+
+// isTime reports whether obj is a date or a duration.
+func isTime(obj object.Object) bool {
+	t := obj.Type()
+	return t == object.DATE_OBJ || t == object.DURATION_OBJ
+}
+
+// evalTimeInfixExpression applies the operator to dates and durations: dates
+// and durations add up to dates, dates subtract to durations, durations scale
+// by integers, and dates and durations compare with the ones of their type.
+func evalTimeInfixExpression(
+	operator string,
+	left, right object.Object,
+) object.Object {
+	switch l := left.(type) {
+	case *object.Date:
+		switch r := right.(type) {
+		case *object.Date:
+			switch operator {
+			case "-":
+				return &object.Duration{Value: l.Time.Sub(r.Time)}
+			case "<":
+				return nativeBoolToBooleanObject(l.Time.Before(r.Time))
+			case ">":
+				return nativeBoolToBooleanObject(l.Time.After(r.Time))
+			case "<=":
+				return nativeBoolToBooleanObject(!l.Time.After(r.Time))
+			case ">=":
+				return nativeBoolToBooleanObject(!l.Time.Before(r.Time))
+			case "==":
+				return nativeBoolToBooleanObject(l.Time.Equal(r.Time))
+			case "!=":
+				return nativeBoolToBooleanObject(!l.Time.Equal(r.Time))
+			}
+		case *object.Duration:
+			switch operator {
+			case "+":
+				return &object.Date{Time: l.Time.Add(r.Value)}
+			case "-":
+				return &object.Date{Time: l.Time.Add(-r.Value)}
+			}
+		}
+
+	case *object.Duration:
+		switch r := right.(type) {
+		case *object.Duration:
+			switch operator {
+			case "+":
+				return &object.Duration{Value: l.Value + r.Value}
+			case "-":
+				return &object.Duration{Value: l.Value - r.Value}
+			case "/":
+				if r.Value == 0 {
+					return newError(diagnostic.DivisionByZero, "division by zero")
+				}
+				return object.NewInteger(int64(l.Value / r.Value))
+			case "<":
+				return nativeBoolToBooleanObject(l.Value < r.Value)
+			case ">":
+				return nativeBoolToBooleanObject(l.Value > r.Value)
+			case "<=":
+				return nativeBoolToBooleanObject(l.Value <= r.Value)
+			case ">=":
+				return nativeBoolToBooleanObject(l.Value >= r.Value)
+			case "==":
+				return nativeBoolToBooleanObject(l.Value == r.Value)
+			case "!=":
+				return nativeBoolToBooleanObject(l.Value != r.Value)
+			}
+		case *object.Date:
+			if operator == "+" {
+				return &object.Date{Time: r.Time.Add(l.Value)}
+			}
+		case *object.Integer:
+			switch operator {
+			case "*":
+				return &object.Duration{Value: l.Value * time.Duration(r.Value)}
+			case "/":
+				if r.Value == 0 {
+					return newError(diagnostic.DivisionByZero, "division by zero")
+				}
+				return &object.Duration{Value: l.Value / time.Duration(r.Value)}
+			}
+		}
+
+	case *object.Integer:
+		if r, ok := right.(*object.Duration); ok && operator == "*" {
+			return &object.Duration{Value: time.Duration(l.Value) * r.Value}
+		}
+	}
+
+	// Values of different types are never equal, like everywhere else.
+	switch {
+	case operator == "==":
+		return FALSE
+	case operator == "!=":
+		return TRUE
+	case left.Type() != right.Type():
+		return newError(diagnostic.TypeMismatch, "type mismatch: %s %s %s",
+			left.Type(), operator, right.Type())
+	default:
+		return newError(diagnostic.UnknownOperator, "unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
@@ -6,27 +6,88 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
+	"strings"
 
 	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/builtinerr"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/lang"
 	"github.com/cedrickchee/hou/object"
+	"github.com/cedrickchee/hou/token"
+	"github.com/cedrickchee/hou/typecheck"
 )
 
 var (
 	// TRUE is a cached Boolean object holding the `true` value.
-	TRUE = &object.Boolean{Value: true}
+	TRUE = object.TRUE
 
 	// FALSE is a cached Boolean object holding the `false` value.
-	FALSE = &object.Boolean{Value: false}
+	FALSE = object.FALSE
 
 	// NULL is a cached Null object. There should only be one reference to a
 	// null value, just as there's only one 'true' and one 'false'.
 	// No kinda-but-not-quite-null, no half-null and no
 	// basically-thesame-as-the-other-null.
-	NULL = &object.Null{}
+	NULL = object.NULL
 )
 
-// Eval evaluates the node and returns an object.
-func Eval(node ast.Node, env *object.Environment) object.Object {
+// eval evaluates the node and returns an object.
+func (e *Evaluator) eval(node ast.Node, env *object.Environment) object.Object {
+	// Every evaluated node counts as one step. Enforcing the limits here is
+	// what makes them apply to any program, however it's written.
+	if err := e.step(); err != nil {
+		return e.locate(err, node)
+	}
+
+	var result object.Object
+	if e.tracesNodes() {
+		result = e.evalTraced(node, env)
+	} else {
+		result = e.evalNode(node, env)
+	}
+	if err, ok := result.(*object.Error); ok {
+		e.locate(err, node)
+	}
+	return result
+}
+
+// locate records the position of node as the position of err, and the calls
+// in progress as its trace, unless err already has a position. Errors are
+// passed up the tree as they are, so the first node to locate an error is the
+// innermost node that resulted in it.
+func (e *Evaluator) locate(err *object.Error, node ast.Node) *object.Error {
+	if err.Position.IsValid() {
+		return err
+	}
+	err.Position = callee(node).Pos()
+	err.Trace = e.trace(err.Position)
+	return err
+}
+
+// callee returns the function node calls, if it's a call, and node otherwise.
+// Errors of calls point at the function that was called rather than at the
+// parenthesis.
+func callee(node ast.Node) ast.Node {
+	if call, ok := node.(*ast.CallExpression); ok {
+		return call.Function
+	}
+	return node
+}
+
+// trace returns the calls in progress as the frames of an error at pos,
+// innermost first.
+func (e *Evaluator) trace(pos token.Position) []object.Frame {
+	frames := make([]object.Frame, 0, len(e.calls)+1)
+	for i := len(e.calls) - 1; i >= 0; i-- {
+		frames = append(frames, object.Frame{Function: e.calls[i].name(), Position: pos})
+		pos = callee(e.calls[i].node).Pos()
+	}
+	return append(frames, object.Frame{Function: "<main>", Position: pos})
+}
+
+// evalNode evaluates the node without accounting for it.
+func (e *Evaluator) evalNode(node ast.Node, env *object.Environment) object.Object {
 	// Traverse the AST by starting at the top of the tree, receiving an
 	// *ast.Program, and then traverse every node in it.
 	// Use object.Environment and keep track of the environment by passing it
@@ -37,36 +98,45 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	// Statements
 	case *ast.Program:
 		// Traverse the tree and evaluate every statement of the *ast.Program.
-		return evalProgram(node, env)
+		return e.evalProgram(node, env)
 
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return e.evalBlockStatement(node, env)
 
 	case *ast.ExpressionStatement:
 		// If the statement is an *ast.ExpressionStatement we evaluate its
 		// expression. An expression statement (not a return statement and not
 		// a let statement).
-		return Eval(node.Expression, env)
+		return e.eval(node.Expression, env)
 
 	case *ast.ReturnStatement:
 		// Evaluate the expression associated with the return statement.
-		val := Eval(node.ReturnValue, env)
+		val := e.eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
+		val := e.eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
+		if e.Checked && node.Type != nil {
+			if err := typecheck.Assert(node.Type, val, "let "+node.Name.Value); err != nil {
+				return err
+			}
+		}
 		// Keep track of values using Environment.
-		env.Set(node.Name.Value, val)
+		bind(env, node.Name, val)
+		e.hookSet(node.Name.Value, val, env)
 
 	// Expressions
 	case *ast.IntegerLiteral:
-		return &object.Integer{Value: node.Value}
+		return object.NewInteger(node.Value)
+
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
@@ -74,92 +144,157 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
+	case *ast.Null:
+		return NULL
+
 	case *ast.PrefixExpression:
 		// The first step is to evaluate its operand and then use the result of
 		// this evaluation with the operator.
-		right := Eval(node.Right, env)
+		right := e.eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
-			return left
+		value, obj := e.evalInfix(node, env)
+		if obj != nil {
+			return obj
 		}
+		// The result is an integer and its consumer needs an object.
+		return object.NewInteger(value)
 
-		right := Eval(node.Right, env)
-		if isError(right) {
-			return right
-		}
+	case *ast.IfExpression:
+		return e.evalIfExpression(node, env)
 
-		return evalInfixExpression(node.Operator, left, right)
+	case *ast.WhileExpression:
+		return e.evalWhileExpression(node, env)
 
-	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+	case *ast.ForInExpression:
+		return e.evalForInExpression(node, env)
+
+	case *ast.MatchExpression:
+		return e.evalMatchExpression(node, env)
+
+	case *ast.AssignExpression:
+		return e.evalAssignExpression(node, env)
 
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return e.evalIdentifier(node, env)
 
 	case *ast.FunctionLiteral:
 		// We just reuse the Parameters and Body fields of the AST node.
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		fn := &object.Function{
+			Parameters: params,
+			Env:        env,
+			Body:       body,
+			Generator:  node.Generator,
+
+			ParameterTypes: node.ParameterTypes,
+			ReturnType:     node.ReturnType,
+			Defaults:       node.Defaults,
+			Features:       e.features,
+		}
+		if node.Name != nil {
+			// A named function is defined in an environment binding its
+			// name to it, so that it can call itself whatever it's bound to
+			// outside.
+			fn.Name = node.Name.Value
+			fn.Env = object.NewScopeEnvironment(env, []string{fn.Name})
+			bind(fn.Env, node.Name, fn)
+		}
+		return fn
+
+	case *ast.YieldExpression:
+		value := e.eval(node.Value, env)
+		if isError(value) {
+			return value
+		}
+		return e.yieldValue(value)
+
+	case *ast.SpawnExpression:
+		// The keyword runs the function like the builtin, which the sandbox
+		// may not allow.
+		if !e.Sandbox.AllowsBuiltin("spawn") {
+			return newError(diagnostic.BuiltinNotAllowed, "builtin not allowed: spawn")
+		}
+		fn := e.eval(node.Function, env)
+		if isError(fn) {
+			return fn
+		}
+		return e.spawn(fn, nil)
 
 	case *ast.CallExpression:
 		// Using Eval to get the function we want to call.
 		// Whether that's an *ast.Identifier or an *ast.FunctionLiteral: Eval
 		// returns an *object.Function.
-		function := Eval(node.Function, env)
+		function := e.eval(node.Function, env)
 		if isError(function) {
 			return function
 		}
 
 		// Evaluate the arguments of a call expression.
-		args := evalExpressions(node.Arguments, env)
+		args := e.evalExpressions(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
 
 		// Call the function. Apply the function to the arguments.
-		return applyFunction(function, args)
+		e.hookCall(node.Function, function, args)
+		if _, ok := function.(*object.Function); !ok {
+			e.site = node
+			return e.applyFunction(function, args)
+		}
+		e.pushCall(node, function.(*object.Function))
+		result := e.applyFunction(function, args)
+		e.popCall()
+		return result
 
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
+		elements := e.evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return e.allocated(&object.Array{Elements: elements})
 
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
+		left := e.eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
+		index := e.eval(node.Index, env)
 		if isError(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
 
+	case *ast.MemberExpression:
+		obj := e.eval(node.Object, env)
+		if isError(obj) {
+			return obj
+		}
+		return evalMemberExpression(obj, node.Property.Value)
+
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return e.allocated(e.evalHashLiteral(node, env))
 	}
 
 	return nil
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+func (e *Evaluator) evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	// evalProgram was renamed from evalStatements and make less generic because
 	// we can’t reuse evalStatements function for evaluating block statements.
 	// We are using evalBlockStatement for evaluating block statements.
 
 	var result object.Object
 
+	features := e.setFeatures(program.Features)
+	defer e.setFeatures(features)
 	for _, statement := range program.Statements {
-		result = Eval(statement, env)
+		result = e.eval(statement, env)
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
@@ -175,7 +310,7 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
-func evalBlockStatement(
+func (e *Evaluator) evalBlockStatement(
 	block *ast.BlockStatement,
 	env *object.Environment,
 ) object.Object {
@@ -184,7 +319,7 @@ func evalBlockStatement(
 	var result object.Object
 
 	for _, statement := range block.Statements {
-		result = Eval(statement, env)
+		result = e.eval(statement, env)
 
 		// Here we explicitly don't unwrap the return value and only check the
 		// Type() of each evaluation result. If it's object.RETURN_VALUE_OBJ we
@@ -228,7 +363,8 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 	default:
 		// If the operator is not supported we don't return NULL since we now
 		// have error handling implemented.
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newError(diagnostic.UnknownOperator,
+			"unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -247,14 +383,23 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
+	if f, ok := right.(*object.Float); ok {
+		return &object.Float{Value: -f.Value}
+	}
+
 	// Check if the operand is an integer.
 	if right.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
+		return newError(diagnostic.UnknownOperator,
+			"unknown operator: -%s", right.Type())
 	}
 
 	value := right.(*object.Integer).Value
+	if value == math.MinInt64 {
+		return newError(diagnostic.ArithmeticOverflow,
+			"integer overflow: -(%d)", value)
+	}
 	// Allocate a new object to wrap a negated version of this value.
-	return &object.Integer{Value: -value}
+	return object.NewInteger(-value)
 }
 
 func evalInfixExpression(
@@ -270,8 +415,13 @@ func evalInfixExpression(
 		// The check for integer operands has to be higher up in the switch
 		// statement.
 		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		// Two numbers that aren't both integers.
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
+	case isTime(left) || isTime(right):
+		return evalTimeInfixExpression(operator, left, right)
 	case operator == "==":
 		// Using pointer comparison to check for equality between booleans.
 		return nativeBoolToBooleanObject(left == right)
@@ -279,96 +429,470 @@ func evalInfixExpression(
 		// Using pointer comparison to check for equality between booleans.
 		return nativeBoolToBooleanObject(left != right)
 	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s",
+		return newError(diagnostic.TypeMismatch, "type mismatch: %s %s %s",
 			left.Type(), operator, right.Type())
 	default:
-		return newError("unknown operator: %s %s %s",
+		return newError(diagnostic.UnknownOperator, "unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
 }
 
+// checkEquality returns an error if the operator compares values of different
+// types and the program asked for strict equality. Null can be compared with
+// everything, and numbers with numbers.
+func (e *Evaluator) checkEquality(operator string, left, right object.Object) object.Object {
+	return checkEquality(e.features, operator, left, right)
+}
+
+func checkEquality(
+	features lang.FeatureSet,
+	operator string,
+	left, right object.Object,
+) object.Object {
+	if operator != "==" && operator != "!=" || !features.Has(lang.StrictEquality) {
+		return nil
+	}
+	if left.Type() == right.Type() || left == NULL || right == NULL {
+		return nil
+	}
+	// Integers and floats are both numbers: 1 == 1.0.
+	if isNumber(left) && isNumber(right) {
+		return nil
+	}
+	return newError(diagnostic.TypeMismatch, "type mismatch: %s %s %s",
+		left.Type(), operator, right.Type())
+}
+
+// setFeatures makes the evaluator evaluate nodes with the features, and
+// returns the features it used before.
+func (e *Evaluator) setFeatures(features lang.FeatureSet) lang.FeatureSet {
+	old := e.features
+	e.features = features
+	return old
+}
+
 func evalIntegerInfixExpression(
 	operator string,
 	left, right object.Object,
 ) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
+	value, obj := evalUnboxedInfixExpression(
+		operator,
+		left.(*object.Integer).Value,
+		right.(*object.Integer).Value,
+	)
+	if obj != nil {
+		return obj
+	}
+	return object.NewInteger(value)
+}
+
+// evalStringInfixExpression concatenates strings with +, and compares them
+// by value, byte by byte, with the comparison operators.
+func evalStringInfixExpression(
+	operator string,
+	left, right object.Object,
+) object.Object {
+	// Unwrap the string objects.
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
 
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
-	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
-	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
-	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
-	case "<":
-		return nativeBoolToBooleanObject(leftVal < rightVal)
-	case ">":
-		return nativeBoolToBooleanObject(leftVal > rightVal)
+		// Construct a new string that's a concatenation of both operands.
+		return &object.String{Value: leftVal + rightVal}
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	default:
-		return newError("unknown operator: %s %s %s",
+		return newError(diagnostic.UnknownOperator, "unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
 }
 
-func evalStringInfixExpression(
-	operator string,
-	left, right object.Object,
+// evalMatchExpression evaluates the body of the first case with a value that
+// equals the subject, or else the default case. The values of the cases are
+// evaluated in order, up to the first match, and each body is a scope of its
+// own. Without a match or a default case, the expression evaluates to null.
+func (e *Evaluator) evalMatchExpression(
+	node *ast.MatchExpression,
+	env *object.Environment,
 ) object.Object {
-	// Check for the correct operator.
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s",
-			left.Type(), operator, right.Type())
+	subject := e.eval(node.Subject, env)
+	if isError(subject) {
+		return subject
 	}
 
-	// Unwrap the string objects and construct a new string that's a
-	// concatenation of both operands.
-	leftVal := left.(*object.String).Value
-	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+	for _, c := range node.Cases {
+		for _, v := range c.Values {
+			value := e.eval(v, env)
+			if isError(value) {
+				return value
+			}
+			matched := evalMatch(subject, value)
+			if isError(matched) {
+				return e.locate(matched.(*object.Error), v)
+			}
+			if matched == TRUE {
+				return e.eval(c.Body, object.NewScopeEnvironment(env, c.Body.Names))
+			}
+		}
+	}
+
+	if node.Default != nil {
+		return e.eval(node.Default, object.NewScopeEnvironment(env, node.Default.Names))
+	}
+	return NULL
+}
+
+// evalMatch reports whether the subject of a match expression matches the
+// value of a case, as TRUE or FALSE. They match if they're equal with ==, but
+// unlike with strict equality, values of different types just don't match.
+func evalMatch(subject, value object.Object) object.Object {
+	return evalInfixExpression("==", subject, value)
+}
+
+// evalCoalesce evaluates `left ?? right`: left, unless it's null, in which case
+// right. Like the branches of an if, right is only evaluated if it's needed.
+func (e *Evaluator) evalCoalesce(
+	node *ast.InfixExpression,
+	env *object.Environment,
+) object.Object {
+	left := e.eval(node.Left, env)
+	// An empty block evaluates to nil, which is as null as it gets.
+	if left != nil && left.Type() != object.NULL_OBJ {
+		return left
+	}
+	return e.eval(node.Right, env)
 }
 
-func evalIfExpression(
+func (e *Evaluator) evalIfExpression(
 	ie *ast.IfExpression,
 	env *object.Environment,
 ) object.Object {
 	// Deciding what to evaluate.
 
-	condition := Eval(ie.Condition, env)
+	condition := e.eval(ie.Condition, env)
 	if isError(condition) {
 		return condition
 	}
 
 	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+		return e.eval(ie.Consequence, e.blockEnv(ie.Consequence, env))
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return e.eval(ie.Alternative, e.blockEnv(ie.Alternative, env))
 	} else {
 		return NULL
 	}
 }
 
-func evalIdentifier(
+// blockEnv returns the environment to evaluate the block of an if expression
+// or the body of a while loop in: with block scoping, they bind names in an
+// environment of their own, like the resolver expects.
+func (e *Evaluator) blockEnv(block *ast.BlockStatement, env *object.Environment) *object.Environment {
+	if e.features.Has(lang.BlockScoping) {
+		return object.NewScopeEnvironment(env, block.Names)
+	}
+	return env
+}
+
+// evalWhileExpression evaluates the body of the loop for as long as the
+// condition is truthy. The loop itself evaluates to null, unless a return
+// statement or an error in the body stops it.
+func (e *Evaluator) evalWhileExpression(
+	we *ast.WhileExpression,
+	env *object.Environment,
+) object.Object {
+	for {
+		condition := e.eval(we.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		// Every iteration binds names in an environment of its own, so the
+		// lets of one don't leak into the next.
+		result := e.eval(we.Body, e.blockEnv(we.Body, env))
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+		}
+	}
+}
+
+// evalForInExpression evaluates the body of the loop for every element of the
+// iterable, see ast.ForInExpression. Like the ones of while loops, the loop
+// evaluates to null, unless a return statement or an error in the body stops
+// it. The body is a scope of its own in every iteration, which binds the
+// names of the loop, so the closures created in an iteration capture its
+// element.
+func (e *Evaluator) evalForInExpression(
+	fe *ast.ForInExpression,
+	env *object.Environment,
+) object.Object {
+	iterable := e.eval(fe.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+	it, err := e.NewIterator(iterable)
+	if err != nil {
+		return err
+	}
+
+	for {
+		scope := object.NewScopeEnvironment(env, fe.Body.Names)
+		if fe.Key == nil {
+			element, ok := it.NextElement()
+			if !ok {
+				return loopEnd(it)
+			}
+			bind(scope, fe.Value, element)
+		} else {
+			key, value, ok := it.Next()
+			if !ok {
+				return loopEnd(it)
+			}
+			bind(scope, fe.Key, key)
+			bind(scope, fe.Value, value)
+		}
+
+		result := e.eval(fe.Body, scope)
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+		}
+	}
+}
+
+// loopEnd returns what a for loop over the iterator evaluates to once there
+// are no iterations left: null, or the error that stopped the generator it
+// iterated over.
+func loopEnd(it *Iterator) object.Object {
+	if err := it.Err(); err != nil {
+		return err
+	}
+	return NULL
+}
+
+// evalAssignExpression rebinds the name to the value in the environment that
+// binds it, so that functions that closed over the name see the new value.
+// Compound assignments like x += 1 apply the operator to the value bound
+// before. The assignment evaluates to the value assigned.
+func (e *Evaluator) evalAssignExpression(
+	node *ast.AssignExpression,
+	env *object.Environment,
+) object.Object {
+	if node.Index != nil {
+		return e.evalIndexAssignment(node, env)
+	}
+
+	name := node.Name.Value
+
+	var current object.Object
+	if node.Operator != "=" {
+		var ok bool
+		if current, ok = lookup(env, node.Name); !ok {
+			return undeclaredError(name)
+		}
+	}
+
+	val := e.eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	if current != nil {
+		operator := strings.TrimSuffix(node.Operator, "=")
+		val = e.allocated(evalInfixExpression(operator, current, val))
+		if isError(val) {
+			return val
+		}
+	}
+
+	defining := assign(env, node.Name, val)
+	if defining == nil {
+		return undeclaredError(name)
+	}
+	e.hookSet(name, val, defining)
+	return val
+}
+
+// evalIndexAssignment sets the element of an array or the value of a key of a
+// hash, e.g. a[0] = 1. The array or hash is changed in place, so everything
+// that refers to it sees the new value. The value is evaluated after the
+// array or hash and the index, and compound assignments like a[0] += 1 apply
+// the operator to the element after that. The assignment evaluates to the
+// value assigned.
+func (e *Evaluator) evalIndexAssignment(
+	node *ast.AssignExpression,
+	env *object.Environment,
+) object.Object {
+	left := e.eval(node.Index.Left, env)
+	if isError(left) {
+		return left
+	}
+	index := e.eval(node.Index.Index, env)
+	if isError(index) {
+		return index
+	}
+	val := e.eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	if node.Operator != "=" {
+		current := evalIndexExpression(left, index)
+		if isError(current) {
+			return current
+		}
+		operator := strings.TrimSuffix(node.Operator, "=")
+		val = e.allocated(evalInfixExpression(operator, current, val))
+		if isError(val) {
+			return val
+		}
+	}
+
+	return evalSetIndex(left, index, val)
+}
+
+// evalSetIndex sets the element of the array left at index, or the value of
+// the key index of the hash left, to val, and returns val. Arrays don't grow:
+// their indexes must be less than their length.
+func evalSetIndex(left, index, val object.Object) object.Object {
+	if tasksRunning() {
+		elementLock.Lock()
+		defer elementLock.Unlock()
+	}
+
+	switch left := left.(type) {
+	case *object.Array:
+		idx, ok := index.(*object.Integer)
+		if !ok {
+			return newError(diagnostic.TypeMismatch,
+				"array index must be INTEGER, got %s", index.Type())
+		}
+		if idx.Value < 0 || idx.Value >= int64(len(left.Elements)) {
+			return newError(diagnostic.IndexOutOfRange,
+				"index out of range: %d with length %d", idx.Value, len(left.Elements))
+		}
+		left.Set(int(idx.Value), val)
+		return val
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError(diagnostic.UnusableHashKey,
+				"unusable as hash key: %s", index.Type())
+		}
+		left.Set(key.HashKey(), object.HashPair{Key: index, Value: val})
+		return val
+
+	default:
+		return newError(diagnostic.IndexNotSupported,
+			"index assignment not supported: %s", left.Type())
+	}
+}
+
+// undeclaredError returns the error for an assignment to a name that isn't
+// bound.
+func undeclaredError(name string) *object.Error {
+	return newError(diagnostic.UndeclaredAssignment,
+		"cannot assign to %s: it wasn't declared with let", name)
+}
+
+// skipScopes returns the environment to look the identifier up in: env, or
+// the one enclosing it after skipping the environments the parser proved
+// don't bind the identifier.
+func skipScopes(env *object.Environment, node *ast.Identifier) *object.Environment {
+	scope := env
+	for i := 0; i < node.Skip && scope.Outer() != nil; i++ {
+		scope = scope.Outer()
+	}
+	return scope
+}
+
+// lookup returns the object bound to the identifier: the one in its slot, if
+// the parser resolved it to one that's bound already, or else the one it's
+// bound to by name.
+func lookup(env *object.Environment, node *ast.Identifier) (object.Object, bool) {
+	// Skip the environments the parser proved don't bind the identifier.
+	scope := skipScopes(env, node)
+	if node.Slot > 0 {
+		if val, ok := scope.GetSlot(node.Slot - 1); ok {
+			return val, true
+		}
+		// A let statement that binds it hasn't been evaluated yet, so an
+		// enclosing environment may still bind it.
+	}
+	return scope.Get(node.Value)
+}
+
+// assign rebinds the identifier to val like object.Environment.Assign, in its
+// slot if the parser resolved it to one that's bound already.
+func assign(env *object.Environment, node *ast.Identifier, val object.Object) *object.Environment {
+	scope := skipScopes(env, node)
+	if node.Slot > 0 && scope.AssignSlot(node.Slot-1, val) {
+		return scope
+	}
+	return scope.Assign(node.Value, val)
+}
+
+// bind binds the identifier to val in env, in its slot if the parser resolved
+// it to one.
+func bind(env *object.Environment, node *ast.Identifier, val object.Object) {
+	if node.Slot > 0 {
+		env.SetSlot(node.Slot-1, val)
+	} else {
+		env.Set(node.Value, val)
+	}
+}
+
+func (e *Evaluator) evalIdentifier(
 	node *ast.Identifier,
 	env *object.Environment,
 ) object.Object {
-	if val, ok := env.Get(node.Value); ok {
+	if val, ok := lookup(env, node); ok {
 		return val
 	}
 
 	// Lookup built-in functions as a fallback when the given identifier is not
 	// bound to a value in the current environment.
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := e.builtins[node.Value]; ok {
+		if !e.Sandbox.AllowsBuiltin(node.Value) {
+			return newError(diagnostic.BuiltinNotAllowed,
+				"builtin not allowed: "+node.Value)
+		}
+		e.checkDeprecated(node, builtin)
 		return builtin
 	}
 
-	return newError("identifier not found: " + node.Value)
+	// A keyword of a later version is an identifier in the versions before
+	// it, e.g. `while` in a script that doesn't ask for version 2.
+	if f, ok := lang.Keyword(node.Value); ok && !e.features.Has(f) {
+		return newError(diagnostic.IdentifierNotFound,
+			"identifier not found: %s (%s came with version %s, see #pragma version)",
+			node.Value, f, f.Since())
+	}
+	if hint := e.suggest(node.Value, env); hint != "" {
+		return newError(diagnostic.IdentifierNotFound,
+			"identifier not found: %s, did you mean '%s'?", node.Value, hint)
+	}
+	return newError(diagnostic.IdentifierNotFound,
+		"identifier not found: "+node.Value)
 }
 
 func isTruthy(obj object.Object) bool {
@@ -384,13 +908,13 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
-func newError(format string, a ...interface{}) *object.Error {
+func newError(code diagnostic.Code, format string, a ...interface{}) *object.Error {
 	// Helper function to help create new Error type.
 	// Error type wraps the formatted error messages.
 	//
 	// This function finds its use in every place where we didn't know what to
 	// do before and returned NULL instead.
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+	return &object.Error{Code: code, Message: fmt.Sprintf(format, a...)}
 }
 
 func isError(obj object.Object) bool {
@@ -400,7 +924,7 @@ func isError(obj object.Object) bool {
 	return false
 }
 
-func evalExpressions(
+func (e *Evaluator) evalExpressions(
 	exps []ast.Expression,
 	env *object.Environment,
 ) []object.Object {
@@ -413,9 +937,9 @@ func evalExpressions(
 
 	// This part is where we decided to evaluate the arguments from
 	// left-to-right.
-	for _, e := range exps {
+	for _, exp := range exps {
 		// Evaluate ast.Expression in the context of the current environment.
-		evaluated := Eval(e, env)
+		evaluated := e.eval(exp, env)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
 		}
@@ -425,24 +949,125 @@ func evalExpressions(
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+func (e *Evaluator) applyFunction(
+	fn object.Object,
+	args []object.Object,
+) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
 		// Here, fn is the converted fn parameter to a *object.Function
 		// reference.
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		if fn.Generator {
+			return e.newGenerator(fn, args)
+		}
+		return e.callFunction(fn, args)
 
 	case *object.Builtin:
 		// Call the object.BuiltinFunction. Note that we don’t need to
 		// unwrapReturnValue when calling a built-in function. That’s because we
 		// never return an *object.ReturnValue from these functions.
-		return fn.Fn(args...)
+		return callBuiltin(fn, args)
+
+	case object.Callable:
+		return fn.Call(args...)
 
 	default:
-		return newError("not a function: %s", fn.Type())
+		return newError(diagnostic.NotAFunction, "not a function: %s", fn.Type())
+	}
+}
+
+// callFunction evaluates the body of the function with the arguments bound to
+// its parameters.
+func (e *Evaluator) callFunction(
+	fn *object.Function,
+	args []object.Object,
+) object.Object {
+	if err := checkArity(fn.Features, args, fn.Required(), len(fn.Parameters)); err != nil {
+		return err
+	}
+	if e.Checked {
+		if err := checkArguments(fn, args); err != nil {
+			return err
+		}
+	}
+
+	if max := e.MaxCallDepth(); e.depth >= max {
+		return newError(diagnostic.StackOverflow,
+			"stack overflow: max call depth %d exceeded", max)
+	}
+
+	extendedEnv := extendFunctionEnv(fn, args)
+	e.depth++
+	e.pushEnv(extendedEnv)
+	features := e.setFeatures(fn.Features)
+	evaluated := e.bindDefaults(fn, len(args), extendedEnv)
+	if evaluated == nil {
+		evaluated = e.eval(fn.Body, extendedEnv)
+	}
+	e.setFeatures(features)
+	e.popEnv()
+	e.depth--
+	result := unwrapReturnValue(evaluated)
+
+	if e.Checked && fn.ReturnType != nil && !fn.Generator && !isError(result) {
+		if result == nil {
+			result = NULL
+		}
+		if err := typecheck.Assert(fn.ReturnType, result, "result"); err != nil {
+			return err
+		}
+	}
+	return result
+}
+
+// checkArity returns the error for calling a function that has params
+// parameters, required of them without a default value, with args. Extra
+// arguments are ignored, unless the function has default values, which an
+// extra argument would most likely be meant for, or the features have
+// lang.StrictArity.
+func checkArity(
+	features lang.FeatureSet,
+	args []object.Object,
+	required, params int,
+) *object.Error {
+	n := len(args)
+	strict := required < params || features.Has(lang.StrictArity)
+	if n >= required && (n <= params || !strict) {
+		return nil
+	}
+	return builtinerr.ArgCount(args, required, params)
+}
+
+// bindDefaults binds the parameters of fn that a call with n arguments omits
+// to their default values, evaluated in env, the environment of the call. It
+// returns the error evaluating one of them, if any.
+func (e *Evaluator) bindDefaults(
+	fn *object.Function,
+	n int,
+	env *object.Environment,
+) object.Object {
+	for i := n; i < len(fn.Defaults); i++ {
+		value := e.eval(fn.Defaults[i], env)
+		if isError(value) {
+			return value
+		}
+		bind(env, fn.Parameters[i], value)
+	}
+	return nil
+}
+
+// checkArguments asserts the types the parameters of fn are annotated with.
+func checkArguments(fn *object.Function, args []object.Object) *object.Error {
+	for i, annotation := range fn.ParameterTypes {
+		if annotation == nil || i >= len(args) {
+			continue
+		}
+		what := fmt.Sprintf("argument %d (%s)", i+1, fn.Parameters[i])
+		if err := typecheck.Assert(annotation, args[i], what); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 func extendFunctionEnv(
@@ -451,12 +1076,16 @@ func extendFunctionEnv(
 ) *object.Environment {
 	// Creates a new *object.Environment that's enclosed by the function's
 	// environment.
-	env := object.NewEnclosedEnvironment(fn.Env)
+	env := object.NewScopeEnvironment(fn.Env, fn.Body.Names)
 
 	for paramIdx, param := range fn.Parameters {
+		if paramIdx >= len(args) {
+			// The parameters without arguments get their default values.
+			break
+		}
 		// In this new, enclosed environment, binds the arguments of the
 		// function call to the function's parameter names.
-		env.Set(param.Value, args[paramIdx])
+		bind(env, param, args[paramIdx])
 	}
 
 	return env
@@ -481,14 +1110,42 @@ func evalIndexExpression(left, index object.Object) object.Object {
 		return evalArrayIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case isIndexable(left):
+		return left.(object.Indexable).Index(index)
+	default:
+		return newError(diagnostic.IndexNotSupported,
+			"index operator not supported: %s", left.Type())
+	}
+}
+
+// evalMemberExpression evaluates `obj.name`, which is the same as
+// `obj["name"]` for hashes and the Go values bound by embedding applications,
+// the only objects that have members.
+func evalMemberExpression(obj object.Object, name string) object.Object {
+	switch obj := obj.(type) {
+	case *object.Hash:
+		return evalHashIndexExpression(obj, &object.String{Value: name})
+	case object.Indexable:
+		return obj.Index(&object.String{Value: name})
 	default:
-		return newError("index operator not supported: %s", left.Type())
+		return newError(diagnostic.MemberNotSupported,
+			"member access not supported: %s.%s", obj.Type(), name)
 	}
 }
 
+func isIndexable(obj object.Object) bool {
+	_, ok := obj.(object.Indexable)
+	return ok
+}
+
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	// Retrieve the element with the specified index from the array.
 
+	if tasksRunning() {
+		elementLock.RLock()
+		defer elementLock.RUnlock()
+	}
+
 	arrayObject := array.(*object.Array)
 	idx := index.(*object.Integer).Value
 	max := int64(len(arrayObject.Elements) - 1)
@@ -502,33 +1159,36 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
-func evalHashLiteral(
+func (e *Evaluator) evalHashLiteral(
 	node *ast.HashLiteral,
 	env *object.Environment,
 ) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash(len(node.Keys))
 
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
+	// Evaluate the pairs in the order they were written in, which is the
+	// order of the hash.
+	for _, keyNode := range node.Keys {
+		valueNode := node.Pairs[keyNode]
+		key := e.eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
 
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
+			return newError(diagnostic.UnusableHashKey,
+				"unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
+		value := e.eval(valueNode, env)
 		if isError(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashKey.HashKey(), object.HashPair{Key: key, Value: value})
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
@@ -536,10 +1196,15 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 
 	key, ok := index.(object.Hashable)
 	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+		return newError(diagnostic.UnusableHashKey,
+			"unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	if tasksRunning() {
+		elementLock.RLock()
+		defer elementLock.RUnlock()
+	}
+	pair, ok := hashObject.Get(key.HashKey())
 	if !ok {
 		return NULL
 	}
@@ -1,8 +1,21 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/diagnostic"
 	"github.com/cedrickchee/hou/lexer"
 	"github.com/cedrickchee/hou/object"
 	"github.com/cedrickchee/hou/parser"
@@ -35,6 +48,18 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"10 - 2 * 3 % 4", 8},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"(-2) ** 3", -8},
+		{"5 ** 0", 1},
+		{"2 ** -1", 0},
+		{"1 ** -5", 1},
+		{"(-1) ** -3", -1},
+		{"(-1) ** -2", 1},
 	}
 
 	for _, tt := range tests {
@@ -43,6 +68,50 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"3.14", "3.14"},
+		{"1e3", "1000.0"},
+		{"-2.5", "-2.5"},
+		{"1.5 + 1.5", "3.0"},
+		{"1 + 0.5", "1.5"},
+		{"0.5 * 3", "1.5"},
+		{"7 / 2.0", "3.5"},
+		{"7 / 2", "3"},
+		{"10 - 0.25 * 4", "9.0"},
+		{"1 / 0.0", "+Inf"},
+		{"1.5 < 2", "true"},
+		{"2 > 1.5", "true"},
+		{"1 == 1.0", "true"},
+		{"1.5 != 1.5", "false"},
+		{"1.5 <= 1.5", "true"},
+		{"2 >= 2.5", "false"},
+		{"7.5 % 2", "1.5"},
+		{"2 ** 0.5", "1.4142135623730951"},
+		{"4.0 ** -1", "0.25"},
+		{"[1.5, 2][0]", "1.5"},
+		{"1.5 + true", "ERROR:type mismatch: FLOAT + BOOLEAN"},
+		{`"a" * 1.5`, "ERROR:type mismatch: STRING * FLOAT"},
+		{"{1.5: 1}", "ERROR:unusable as hash key: FLOAT"},
+		{"#pragma version 2\n1 == 1.0", "true"},
+		{"#pragma version 2\n1.0 == true", "ERROR:type mismatch: FLOAT == BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		result := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			result = "ERROR:" + errObj.Message
+		}
+		if result != tt.expected {
+			t.Errorf("wrong result for %s. want=%q, got=%q", tt.input, tt.expected, result)
+		}
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -67,6 +136,12 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1 <= 2", true},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 2", false},
+		{"1 >= 1", true},
+		{"(1 + 1) >= 2 == 1 <= 1", true},
 	}
 
 	for _, tt := range tests {
@@ -240,6 +315,18 @@ if (10 > 1) {
 			`999[1]`,
 			"index operator not supported: INTEGER",
 		},
+		{
+			`[1, 2].length`,
+			"member access not supported: ARRAY.length",
+		},
+		{
+			"10 / (5 - 5)",
+			"division by zero: 10 / 0",
+		},
+		{
+			"-7 % 0",
+			"division by zero: -7 % 0",
+		},
 	}
 
 	for _, tt := range tests {
@@ -259,6 +346,211 @@ if (10 > 1) {
 	}
 }
 
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + true;", "type mismatch: INTEGER + BOOLEAN at line 1, col 3"},
+		{"-true", "unknown operator: -BOOLEAN at line 1, col 1"},
+		{"1 + 2 * true - 3", "type mismatch: INTEGER * BOOLEAN at line 1, col 7"},
+		{"let x = 1;\nlet y = x + foobar;", "identifier not found: foobar at line 2, col 13"},
+		{`let f = fn(x) {
+  x + true
+};
+f(1)`, "type mismatch: INTEGER + BOOLEAN at line 2, col 5"},
+		{"len(1, 2)", "wrong number of arguments. got=2, want=1 at line 1, col 1"},
+		{"let x = 1;\n  999[x]", "index operator not supported: INTEGER at line 2, col 6"},
+		{"let s = \"hou\";\ns.size", "member access not supported: STRING.size at line 2, col 2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)",
+				evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Describe() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q",
+				tt.expected, errObj.Describe())
+		}
+	}
+}
+
+func TestErrorTraces(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"1 + true", []string{"<main> at line 1, col 3"}},
+		{`let double = fn(x) { x + true };
+let twice = fn(x) { double(x) * 2 };
+twice(1)`, []string{
+			"double at line 1, col 24",
+			"twice at line 2, col 21",
+			"<main> at line 3, col 1",
+		}},
+		{`fn(x) { len(x) }(1)`, []string{
+			"fn at line 1, col 9",
+			"<main> at line 1, col 1",
+		}},
+		// Named functions are named after their literal.
+		{`let f = fn bad(n) { if (n == 0) { 1 + true } else { bad(n - 1) } }; f(1)`, []string{
+			"bad at line 1, col 37",
+			"bad at line 1, col 53",
+			"<main> at line 1, col 69",
+		}},
+		// Builtins don't get frames of their own.
+		{`let f = fn() { first(1) }; f()`, []string{
+			"f at line 1, col 16",
+			"<main> at line 1, col 28",
+		}},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)",
+				evaluated, evaluated)
+			continue
+		}
+
+		var frames []string
+		for _, frame := range errObj.Trace {
+			frames = append(frames, frame.Function+" at "+frame.Position.String())
+		}
+		if !reflect.DeepEqual(frames, tt.expected) {
+			t.Errorf("wrong trace for %s. expected=%q, got=%q",
+				tt.input, tt.expected, frames)
+		}
+	}
+}
+
+func TestIdentifierSuggestions(t *testing.T) {
+	tests := []struct {
+		input    string
+		sandbox  *Sandbox
+		expected string
+	}{
+		{`lne("abc")`, nil, "identifier not found: lne, did you mean 'len'?"},
+		{`let length = 1; lenght`, nil,
+			"identifier not found: lenght, did you mean 'length'?"},
+		{`let counter = 1; let f = fn() { conter + 1 }; f()`, nil,
+			"identifier not found: conter, did you mean 'counter'?"},
+		{`let f = fn(value) { vlaue }; f(1)`, nil,
+			"identifier not found: vlaue, did you mean 'value'?"},
+		{`let x = 1; y`, nil, "identifier not found: y"},
+		{`builder`, nil, "identifier not found: builder"},
+		{`let builders = 1; builder`, nil,
+			"identifier not found: builder, did you mean 'builders'?"},
+		{`ptus("hi")`, nil, "identifier not found: ptus, did you mean 'puts'?"},
+		{`ptus("hi")`, &Sandbox{DenyBuiltins: []string{"puts"}},
+			"identifier not found: ptus"},
+	}
+
+	for _, tt := range tests {
+		e := New()
+		e.Sandbox = tt.sandbox
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := e.Eval(program, NewEnvironment(program))
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)",
+				evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected diagnostic.Code
+	}{
+		{"5 + true;", diagnostic.TypeMismatch},
+		{"-true", diagnostic.UnknownOperator},
+		{"foobar", diagnostic.IdentifierNotFound},
+		{"5()", diagnostic.NotAFunction},
+		{"999[1]", diagnostic.IndexNotSupported},
+		{`{"name": "Monkey"}[fn(x) { x }];`, diagnostic.UnusableHashKey},
+		{"len(1, 2)", diagnostic.WrongArgumentCount},
+		{"first(1)", diagnostic.WrongArgumentType},
+		{"chan(-1)", diagnostic.InvalidArgument},
+		{"1 / 0", diagnostic.DivisionByZero},
+		{"let x = 5; x /= 0", diagnostic.DivisionByZero},
+		{"0 ** -1", diagnostic.DivisionByZero},
+		{"#pragma version 3\n9223372036854775807 + 1", diagnostic.ArithmeticOverflow},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)",
+				evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Code != tt.expected {
+			t.Errorf("%s: wrong error code. expected=%s, got=%s (%s)",
+				tt.input, tt.expected, errObj.Code, errObj.Message)
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	const recurse = "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(%d)"
+
+	tests := []struct {
+		maxDepth int
+		n        int
+		expected string
+	}{
+		{0, 5000, ""},
+		{0, 20000, "stack overflow: max call depth 10000 exceeded"},
+		{10, 9, ""},
+		{10, 10, "stack overflow: max call depth 10 exceeded"},
+	}
+
+	for _, tt := range tests {
+		e := New()
+		e.MaxDepth = tt.maxDepth
+		input := fmt.Sprintf(recurse, tt.n)
+		program := parser.New(lexer.New(input)).ParseProgram()
+		evaluated := e.Eval(program, NewEnvironment(program))
+
+		errObj, isErr := evaluated.(*object.Error)
+		if tt.expected == "" {
+			if isErr {
+				t.Errorf("%d calls with max depth %d failed: %s",
+					tt.n, tt.maxDepth, errObj.Message)
+			}
+			continue
+		}
+		if !isErr {
+			t.Errorf("%d calls with max depth %d didn't fail. got=%s",
+				tt.n, tt.maxDepth, evaluated.Inspect())
+			continue
+		}
+		if errObj.Code != diagnostic.StackOverflow || errObj.Message != tt.expected {
+			t.Errorf("wrong error. want=%s %q, got=%s %q",
+				diagnostic.StackOverflow, tt.expected, errObj.Code, errObj.Message)
+		}
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	// The test cases assert that these two things should work: evaluating the
 	// value-producing expression in a let statement and evaluating an
@@ -279,6 +571,195 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestWhileExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"while (false) { 1 }", nil},
+		{"let f = fn() { while (true) { return 10; } }; f()", 10},
+		{"let f = fn(x) { while (x) { let y = 5; return y; } 0 }; [f(true), f(false)]", "[5, 0]"},
+		// The body is a scope, and a fresh one in every iteration.
+		{"let x = 1; let f = fn() { while (true) { let x = 2; return x; } }; [f(), x]", "[2, 1]"},
+		{"while (true) { 1 + true }", "type mismatch: INTEGER + BOOLEAN"},
+		{"while (1 + true) { 1 }", "type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval("#pragma version 2\n" + tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("%q: want error %q, got=%q", tt.input, expected, errObj.Message)
+				}
+			} else if evaluated.Inspect() != expected {
+				t.Errorf("%q: want %s, got=%s", tt.input, expected, evaluated.Inspect())
+			}
+		}
+	}
+
+	// Loops stop when the evaluation is cancelled, like recursion.
+	e := New()
+	e.Sandbox = &Sandbox{MaxSteps: 1000}
+	program := parser.New(lexer.New("#pragma version 2\nwhile (true) { 1 }")).ParseProgram()
+	if errObj, ok := e.Eval(program, NewEnvironment(program)).(*object.Error); !ok ||
+		errObj.Code != diagnostic.StepLimitExceeded {
+		t.Errorf("infinite loop wasn't stopped by the step limit")
+	}
+}
+
+func TestForInExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let s = 0; for (x in [1, 2, 3]) { s += x; } s", 6},
+		{"for (x in []) { 1 }", nil},
+		{"for (x in [1]) { x }", nil},
+		// Two names bind the index or key too.
+		{"let s = []; for (i, x in [5, 6]) { s = push(s, [i, x]); } s", "[[0, 5], [1, 6]]"},
+		{`let s = []; for (k, v in {"a": 1, "b": 2}) { s = push(s, [k, v]); } s`, "[[a, 1], [b, 2]]"},
+		// One name binds the keys of hashes.
+		{`let s = []; for (k in {"a": 1, "b": 2}) { s = push(s, k); } s`, "[a, b]"},
+		// Strings are iterated over character by character.
+		{`let s = []; for (i, c in "héllo") { s = push(s, [i, c]); } s`,
+			"[[0, h], [1, é], [2, l], [3, l], [4, o]]"},
+		// The body is a scope, and a fresh one in every iteration.
+		{"let x = 0; for (x in [1, 2]) { let y = x; }; x", 0},
+		{"let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x }); }; [fs[0](), fs[1]()]", "[1, 2]"},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x > 1) { return x; } } }; f()", 2},
+		{"let a = [1]; for (x in a) { a = push(a, x); } a", "[1, 1]"},
+		{"for (x in 5) { x }", "cannot iterate over INTEGER"},
+		{"for (x in [1]) { x + true }", "type mismatch: INTEGER + BOOLEAN"},
+		{"for (x in 1 + true) { 1 }", "type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval("#pragma version 2\n" + tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("%q: want error %q, got=%q", tt.input, expected, errObj.Message)
+				}
+			} else if evaluated.Inspect() != expected {
+				t.Errorf("%q: want %s, got=%s", tt.input, expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"match (2) { case 1: { 10 } case 2: { 20 } default: { 30 } }", 20},
+		{"match (5) { case 1: { 10 } default: { 30 } }", 30},
+		{"match (5) { case 1: { 10 } }", nil},
+		{"match (5) {}", nil},
+		{`match ("b") { case "a", "b": { 1 } }`, 1},
+		{"match (1.0) { case 1: { 2 } }", 2},
+		{"match (null) { case 1: { 1 } case null: { 2 } }", 2},
+		// Values of different types don't match, even with strict equality.
+		{`match (1) { case "1", true: { 1 } default: { 2 } }`, 2},
+		// The values are evaluated in order, up to the first match.
+		{"let n = 0; let f = fn(i) { n += 1; i }; match (2) { case f(1), f(2), f(3): { n } }", 2},
+		// Every case is a scope.
+		{"let x = 1; match (x) { case 1: { let x = 2; } }; x", 1},
+		{"match (1) { default: { let y = 3; } }; y", "identifier not found: y"},
+		{"let f = fn(x) { match (x) { case 1: { return 5; } }; 6 }; [f(1), f(2)]", "[5, 6]"},
+		{"match (1 + true) { case 1: { 1 } }", "type mismatch: INTEGER + BOOLEAN"},
+		{"match (1) { case 1: { 1 + true } }", "type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval("#pragma version 2\n" + tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("%q: want error %q, got=%q", tt.input, expected, errObj.Message)
+				}
+			} else if evaluated.Inspect() != expected {
+				t.Errorf("%q: want %s, got=%s", tt.input, expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; x = 5; x", 5},
+		{"let x = 1; x = 5", 5},
+		{"let x = 10; x += 2; x -= 4; x *= 3; x /= 6; x", 4},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{"let a = 1; let b = 2; a = b = 3; [a, b]", "[3, 3]"},
+		// Closures assign to the environment that binds the name.
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()", 3},
+		{"let x = 1; let f = fn(x) { x = 2 }; f(0); x", 1},
+		{"y = 1", "cannot assign to y: it wasn't declared with let"},
+		{"let f = fn() { let z = 1 }; f(); z += 1", "cannot assign to z: it wasn't declared with let"},
+		{`let s = "a"; s -= 1`, "type mismatch: STRING - INTEGER"},
+		{"let x = 1; x += true", "type mismatch: INTEGER + BOOLEAN"},
+		{"let x = 1; x = 1 + true", "type mismatch: INTEGER + BOOLEAN"},
+		{"#pragma version 2\nlet i = 0; let sum = 0; while (i < 5) { i += 1; sum += i; } sum", 15},
+		// Elements of arrays and hashes are assigned to in place.
+		{"let a = [1, 2, 3]; a[1] = 5; a", "[1, 5, 3]"},
+		{"let a = [1, 2, 3]; let b = a; b[0] += 10; a", "[11, 2, 3]"},
+		// The arrays made by push and rest have their own elements.
+		{"let b = [1, 2, 3]; let c = push(b, 5); b[1] = 77; [b, c]", "[[1, 77, 3], [1, 2, 3, 5]]"},
+		{"let c = push([1, 2, 3], 5); let r = rest(c); c[2] = 55; [c, r]", "[[1, 2, 55, 5], [2, 3, 5]]"},
+		{`let h = {"a": 1}; h["a"] *= 3; h["b"] = 2; h`, "{a: 3, b: 2}"},
+		{`let h = {"a": [1]}; h["a"][0] = h["b"] = 2; h`, "{a: [2], b: 2}"},
+		{"let a = [0]; let f = fn() { a[0] += 1 }; f(); f(); a[0]", 2},
+		{"let a = [1]; a[0] = 5", 5},
+		{"let a = [1]; a[1] = 5", "index out of range: 1 with length 1"},
+		{"let a = [1]; a[-1] = 5", "index out of range: -1 with length 1"},
+		{`let a = [1]; a["0"] = 5`, "array index must be INTEGER, got STRING"},
+		{"let h = {}; h[fn() { 1 }] = 5", "unusable as hash key: FUNCTION"},
+		{`let s = "abc"; s[0] = "x"`, "index assignment not supported: STRING"},
+		{`let h = {}; h["missing"] += 1`, "type mismatch: NULL + INTEGER"},
+		{"let a = [1]; a[0] = 1 + true", "type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("%q: want error %q, got=%q", tt.input, expected, errObj.Message)
+				}
+			} else if evaluated.Inspect() != expected {
+				t.Errorf("%q: want %s, got=%s", tt.input, expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
 func TestFunctionObject(t *testing.T) {
 	// This test function asserts that evaluating a function literal results in
 	// the correct *object.Function being returned, with correct parameters and
@@ -308,6 +789,93 @@ func TestFunctionObject(t *testing.T) {
 	}
 }
 
+func TestNamedFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn fact(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; f(10)", 3628800},
+		{"fn fib(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }(15)", 610},
+		{"let g = fn self() { self }; g() == g", true},
+		// The name is bound outside of the function, so its parameters and
+		// lets shadow it.
+		{"let k = fn k(k) { k }; k(5)", 5},
+		{"let f = fn g() { let g = 2; g }; f()", 2},
+		// And it isn't bound outside of the literal.
+		{"let f = fn g() { 1 }; g", "identifier not found: g"},
+		{"fn fact(n) { n }", "fn fact(n) {\nn\n}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q",
+						expected, errObj.Message)
+				}
+			} else if evaluated.Inspect() != expected {
+				t.Errorf("wrong Inspect() for %q. expected=%q, got=%q",
+					tt.input, expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
+func TestDefaultParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn(a, b = 10) { a + b }; f(1)", 11},
+		{"let f = fn(a, b = 10) { a + b }; f(1, 2)", 3},
+		// Defaults are evaluated in the environment of the call, in order,
+		// every time they're needed.
+		{"let f = fn(a, b = a * 2, c = b + 1) { a + b + c }; f(1)", 6},
+		{"let n = 0; let f = fn(a = n += 1) { a }; f(); f(); f(10); n", 2},
+		{"let f = fn(a = 1) { fn() { a } }; f()()", 1},
+		{"let c = fn(n = 0) { fn() { n += 1 } }(); c(); c()", 2},
+		{"let f = fn(a = 1 + true) { a }; f(2)", 2},
+		{"let f = fn(a = 1 + true) { a }; f()", "type mismatch: INTEGER + BOOLEAN"},
+		// Missing arguments without defaults are an error.
+		{"let f = fn(a, b) { a }; f(1)", "wrong number of arguments. got=1, want=2"},
+		{"let f = fn(a, b = 1) { a }; f()", "wrong number of arguments. got=0, want=1 or 2"},
+		// Extra arguments are an error if there are defaults, and otherwise
+		// ignored, until version 3.
+		{"let f = fn(a, b = 1) { a + b }; f(1, 2, 3)", "wrong number of arguments. got=3, want=1 or 2"},
+		{"let f = fn(a, b) { a + b }; f(1, 2, 3)", 3},
+		// Closures in defaults capture the parameters before them.
+		{"let f = fn(a, b = fn() { a }) { b() }; f(7)", 7},
+		{"let x = 3; let f = fn(a = fn() { x }) { x = 4; a() }; f()", 4},
+		{"#pragma version 3\nlet f = fn(a, b = 1) { a + b }; f(1, 2, 3)", "wrong number of arguments. got=3, want=1 or 2"},
+		{"#pragma version 3\nmap([1], fn() { 1 })", "wrong number of arguments. got=1, want=0"},
+		{"fn(a, b = 10) { a }", "fn(a, b = 10) {\na\n}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error message for %q. expected=%q, got=%q",
+						tt.input, expected, errObj.Message)
+				}
+			} else if evaluated.Inspect() != expected {
+				t.Errorf("wrong Inspect() for %q. expected=%q, got=%q",
+					tt.input, expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
 func TestFunctionApplication(t *testing.T) {
 	// Each test case here does the same thing: define a function, apply it to
 	// arguments and then make an assertion about the produced value. But with
@@ -343,6 +911,30 @@ addTwo(2);`
 	testIntegerObject(t, testEval(input), 4)
 }
 
+func TestScopes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let x = 1; let f = fn(x) { fn() { x } }; f(2)()", 2},
+		{"let x = 1; let f = fn() { let x = 2; fn() { x } }; f()()", 2},
+		{"let x = 1; let f = fn() { fn() { x } }; f()()", 1},
+		// The global is shadowed only when the let is evaluated.
+		{"let x = 1; let f = fn(b) { if (b) { let x = 2; } x }; f(false)", 1},
+		{"let x = 1; let f = fn(b) { if (b) { let x = 2; } x }; f(true)", 2},
+		{"let f = fn(n) { if (n == 0) { 0 } else { n + f(n - 1) } }; f(10)", 55},
+		// Locals live in slots, which assignments and closures share.
+		{"let counter = fn() { let n = 0; fn() { n += 1; n } }; let c = counter(); c(); c(); c()", 3},
+		{"let x = 1; let f = fn(b) { if (b) { let x = 2; } x = 3; x }; [f(false), x][1]", 3},
+		{"let f = fn(x, x) { x }; f(1, 2)", 2},
+		{"let f = fn(a) { let g = fn() { a = a * 2; }; g(); g(); a }; f(3)", 12},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World!"`
 
@@ -371,6 +963,30 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestStringComparison(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "b"`, true},
+		{`"a" + "b" == "ab"`, true},
+		{`"apple" < "banana"`, true},
+		{`"apple" > "app"`, true},
+		{`"Z" < "a"`, true},
+		{`"" < "a"`, true},
+		{`"b" <= "b"`, true},
+		{`"b" >= "c"`, false},
+		{`"1" == 1`, false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	// Test cases that run len through its paces: an empty string, a normal
 	// string and a string containing whitespace.
@@ -385,7 +1001,7 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
-		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{`len(1)`, "argument to `len` must be STRING or ARRAY, got INTEGER"},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
@@ -399,7 +1015,77 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`rest([1, 2, 3])`, []int{2, 3}},
 		{`rest([])`, nil},
 		{`push([], 1)`, []int{1}},
-		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+		{`push(1, 1)`, "first argument to `push` must be ARRAY, got INTEGER"},
+		{`input(1)`, "argument to `input` must be STRING, got INTEGER"},
+		{`input("a", "b")`, "wrong number of arguments. got=2, want=0 or 1"},
+		{`wait(spawn(fn(a, b) { a + b }, 1, 2))`, 3},
+		{`wait(spawn(fn() { }))`, nil},
+		{`wait(spawn(len, "abc"))`, 3},
+		{`wait(spawn(fn() { 1 + true }))`, "type mismatch: INTEGER + BOOLEAN"},
+		{`let x = 20; wait(spawn fn() { x + 1 }) * 2`, 42},
+		{`let f = fn(spawn) { spawn(fn() { 1 }) }; f(fn(g) { g() + 1 })`, 2},
+		{`spawn(1)`, "argument to `spawn` must be FUNCTION, got INTEGER"},
+		{`spawn()`, "wrong number of arguments. got=0, want=1 or more"},
+		{`wait(1)`, "argument to `wait` must be TASK or TIMER, got INTEGER"},
+		{`let c = chan(1); send(c, 7); recv(c)`, 7},
+		{`let c = chan(1); send(c, 7); close(c); recv(c); recv(c)`, nil},
+		{`let c = chan(); close(c); send(c, 1)`, "send: channel is closed"},
+		{`let c = chan(); close(c); close(c)`, "close: channel is closed"},
+		{`chan(-1)`, "argument to `chan` must not be negative, got -1"},
+		{`chan(9223372036854775807)`,
+			"argument to `chan` must be at most 1048576, got 9223372036854775807"},
+		{`chan("1")`, "argument to `chan` must be INTEGER, got STRING"},
+		{`send(1, 1)`, "first argument to `send` must be CHANNEL, got INTEGER"},
+		{`recv(1)`, "argument to `recv` must be CHANNEL, got INTEGER"},
+		{`await(async(fn(x) { x * 2 }, 21))`, 42},
+		{`await(5)`, 5},
+		{`all([async(len, "ab"), 3, async(fn() { 4 })])`, []int{2, 3, 4}},
+		{`all([async(fn() { 1 }), async(fn() { -true })])`,
+			"unknown operator: -BOOLEAN"},
+		{`all(1)`, "argument to `all` must be ARRAY, got INTEGER"},
+		{`async("f")`, "argument to `async` must be FUNCTION, got STRING"},
+		{`let c = chan(1); after(1, fn() { send(c, 5) }); recv(c)`, 5},
+		{`let c = chan(10); let t = every(1, fn() { send(c, 1) });
+		  let sum = recv(c) + recv(c) + recv(c); cancel(t); sum`, 3},
+		{`after("1", len)`, "first argument to `after` must be INTEGER, got STRING"},
+		{`after(1, 2)`, "second argument to `after` must be FUNCTION, got INTEGER"},
+		{`every(0, len)`, "invalid interval for `every`: 0 ms"},
+		{`cancel(1)`, "argument to `cancel` must be TIMER, got INTEGER"},
+		{`onSignal("WINCH", len)`,
+			`unknown signal: "WINCH", want one of [HUP INT QUIT TERM]`},
+		{`onSignal(1, len)`, "first argument to `onSignal` must be STRING, got INTEGER"},
+		{`next(1)`, "argument to `next` must be GENERATOR, got INTEGER"},
+		{`pmap([1, 2, 3, 4, 5], fn(x) { x * x }, 2)`, []int{1, 4, 9, 16, 25}},
+		{`pmap([], fn(x) { x })`, []int{}},
+		{`pmap(["a", "bb"], len, 8)`, []int{1, 2}},
+		{`pmap([1, true, 3], fn(x) { -x }, 1)`, "unknown operator: -BOOLEAN"},
+		{`pmap([1], fn(x) { x }, 0)`, "number of workers must be positive, got 0"},
+		{`pmap(1, len)`, "first argument to `pmap` must be ARRAY, got INTEGER"},
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`map(["a", "bb"], len)`, []int{1, 2}},
+		{`map([], fn(x) { x })`, []int{}},
+		{`map([1, true], fn(x) { -x })`, "unknown operator: -BOOLEAN"},
+		{`map([1], 1)`, "second argument to `map` must be FUNCTION, got INTEGER"},
+		{`map(1, len)`, "first argument to `map` must be ARRAY or GENERATOR, got INTEGER"},
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 })`, []int{3, 4}},
+		{`filter([1, 2], fn(x) { if (x > 1) { return true; } false })`, []int{2}},
+		{`reduce([1, 2, 3, 4], fn(acc, x) { acc + x })`, 10},
+		{`reduce([1, 2, 3], fn(acc, x) { push(acc, x * x) }, [])`, []int{1, 4, 9}},
+		{`reduce([], fn(acc, x) { acc + x }, 0)`, 0},
+		{`reduce([], fn(acc, x) { acc + x })`,
+			"reduce of an empty array without an initial value"},
+		{`reduce([1, 2], fn(acc) { acc }, 0, 1)`,
+			"wrong number of arguments. got=4, want=2 or 3"},
+		{`len(build(append(strBuilder("a"), "b", "c")))`, 3},
+		{`let b = strBuilder(); append(b, "x"); let s = build(b);
+		  append(b, "yz"); len(s) + len(build(b))`, 4},
+		{`strBuilder(1)`, "argument to `strBuilder` must be STRING, got INTEGER"},
+		{`append("a", "b")`, "first argument to `append` must be BUILDER, got STRING"},
+		{`append(strBuilder(), "a", 1)`,
+			"third argument to `append` must be STRING, got INTEGER"},
+		{`build("a")`, "argument to `build` must be BUILDER, got STRING"},
+		{`yieldTask()`, nil},
+		{`yieldTask(1)`, "wrong number of arguments. got=1, want=0"},
 	}
 
 	for _, tt := range tests {
@@ -441,6 +1127,44 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestConversions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`str(12) + str(1.5) + str(true) + str("x")`, "121.5truex"},
+		{`str([1, "a"])`, "[1, a]"},
+		{`str(puts())`, "null"},
+		{`int("12") + int("-3") + int("+4")`, "13"},
+		{`int(1.9) + int(-1.9)`, "0"},
+		{`[int(true), int(false), int(7)]`, "[1, 0, 7]"},
+		{`int("12x")`, `ERROR:int: can't convert "12x" to INTEGER`},
+		{`int(" 12")`, `ERROR:int: can't convert " 12" to INTEGER`},
+		{`int("99999999999999999999")`, `ERROR:int: can't convert "99999999999999999999" to INTEGER`},
+		{`int(1.0 / 0)`, "ERROR:int: can't convert +Inf to INTEGER"},
+		{`int([])`, "ERROR:argument to `int` must be INTEGER, FLOAT, BOOLEAN or STRING, got ARRAY"},
+		{`int()`, "ERROR:wrong number of arguments. got=0, want=1"},
+		{`[bool("true"), bool("false"), bool(0), bool(puts()), bool(false)]`, "[true, false, true, false, false]"},
+		{`bool("yes")`, `ERROR:bool: can't convert "yes" to BOOLEAN`},
+		{`[type(1), type(1.5), type("a"), type([]), type({}), type(len), type(fn() {})]`,
+			"[INTEGER, FLOAT, STRING, ARRAY, HASH, BUILTIN, FUNCTION]"},
+		{`type(1, 2)`, "ERROR:wrong number of arguments. got=2, want=1"},
+		// Conversions round-trip.
+		{`int(str(42)) == 42`, "true"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		result := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			result = "ERROR:" + errObj.Message
+		}
+		if result != tt.expected {
+			t.Errorf("wrong result for %s. want=%q, got=%q", tt.input, tt.expected, result)
+		}
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
@@ -547,18 +1271,23 @@ func TestHashLiterals(t *testing.T) {
 		FALSE.HashKey():                            6,
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
 
 	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+		pair, ok := result.Get(expectedKey)
 		if !ok {
 			t.Errorf("no pair for given key in Pairs")
 		}
 
 		testIntegerObject(t, pair.Value, expectedValue)
 	}
+
+	// Hashes keep the order of their literals.
+	if result.Inspect() != "{one: 1, two: 2, three: 3, 4: 4, true: 5, false: 6}" {
+		t.Errorf("wrong order of pairs. got=%s", result.Inspect())
+	}
 }
 
 func TestHashIndexExpressions(t *testing.T) {
@@ -607,6 +1336,1421 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestMemberExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`{"foo": 5}.foo`, 5},
+		{`{"foo": 5}.bar`, nil},
+		{`{5: 5}.five`, nil},
+		{`let person = {"name": "hou", "age": 3}; person.age * 2`, 6},
+		{`let a = {"b": {"c": [1, 2]}}; a.b.c[1]`, 2},
+		{`let counter = {"next": fn(n) { n + 1 }}; counter.next(1)`, 2},
+		{`let h = {"x": 1}; h["x"] += 2; h.x`, 3},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestNullCoalescing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"null", nil},
+		{"null ?? null", nil},
+		{"null ?? 5", 5},
+		{"1 ?? 5", 1},
+		{"false ?? 5", "false"},
+		{`{}["a"] ?? "none"`, "none"},
+		{"if (false) { 1 } ?? 4", 4},
+		{"null ?? null ?? 3", 3},
+		{"(null ?? 2) * 3 + 1", 7},
+		{"let a = null; a ?? 2 == 2", "true"},
+		// The right operand is only evaluated if it's needed.
+		{"let x = 0; let f = fn() { x = x + 1 }; 1 ?? f(); null ?? f(); x", 1},
+		{"null ?? 1 + true", "type mismatch: INTEGER + BOOLEAN"},
+		{"1 ?? 1 + true", 1},
+		{"-true ?? 1", "unknown operator: -BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval("#pragma version 2\n" + tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("%q: want error %q, got=%q", tt.input, expected, errObj.Message)
+				}
+			} else if evaluated.Inspect() != expected {
+				t.Errorf("%q: want %s, got=%s", tt.input, expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	recurse := `let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(1000)`
+
+	tests := []struct {
+		input    string
+		sandbox  *Sandbox
+		expected interface{}
+	}{
+		{`len("abc")`, nil, 3},
+		{`len("abc")`, &Sandbox{}, 3},
+		{`len("abc")`, &Sandbox{AllowBuiltins: []string{"len"}}, 3},
+		{`puts("x")`, &Sandbox{AllowBuiltins: []string{"len"}},
+			"builtin not allowed: puts"},
+		{`len("abc")`, &Sandbox{DenyBuiltins: []string{"len"}},
+			"builtin not allowed: len"},
+		{`let len = fn(x) { 42 }; len("abc")`,
+			&Sandbox{DenyBuiltins: []string{"len"}}, 42},
+		{recurse, &Sandbox{MaxSteps: 100}, "step limit exceeded: 100 steps"},
+		{recurse, &Sandbox{Timeout: time.Nanosecond},
+			"evaluation cancelled: context deadline exceeded"},
+		{`[1, 2, 3]`, &Sandbox{MaxMemory: 1024}, []int{1, 2, 3}},
+		{`chan(100000); 1`, &Sandbox{MaxMemory: 1024}, "memory limit exceeded: 1024 bytes"},
+		{`let f = fn(s, n) { if (n == 0) { s } else { f(s + s, n - 1) } }; f("ab", 20)`,
+			&Sandbox{MaxMemory: 1024}, "memory limit exceeded: 1024 bytes"},
+		{`let f = fn(b, n) { if (n > 0) { f(append(b, "abcdefgh"), n - 1) } }; f(strBuilder(), 200)`,
+			&Sandbox{MaxMemory: 1024}, "memory limit exceeded: 1024 bytes"},
+		// The steps and the memory of tasks count against the limits too.
+		{`let f = fn(n) { if (n > 0) { f(n - 1) } else { 1 } }; let g = fn() { f(5) }; await(spawn(g)) + await(spawn(g)) + await(spawn(g))`,
+			&Sandbox{MaxSteps: 100}, "step limit exceeded: 100 steps"},
+		{`let f = fn() { len([1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12]) }; await(spawn(f)) + await(spawn(f)) + await(spawn(f))`,
+			&Sandbox{MaxMemory: 512}, "memory limit exceeded: 512 bytes"},
+		{`await(spawn(fn() { await(spawn(fn() { 1 })) }))`,
+			&Sandbox{MaxTasks: 2}, 1},
+		{`await(spawn(fn() { await(spawn(fn() { 1 })) }))`,
+			&Sandbox{MaxTasks: 1}, "task limit exceeded: 1 tasks"},
+		{`len(pmap([1, 2, 3], fn(x) { x }))`, &Sandbox{MaxTasks: 1}, 3},
+		{`wait(spawn fn() { 1 })`, &Sandbox{DenyBuiltins: []string{"spawn"}},
+			"builtin not allowed: spawn"},
+		{`pmap([1, 2, 3], fn(x) { x }, 2)`,
+			&Sandbox{MaxTasks: 1}, "task limit exceeded: 1 tasks"},
+	}
+
+	for _, tt := range tests {
+		e := New()
+		e.Sandbox = tt.sandbox
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := e.Eval(program, NewEnvironment(program))
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)",
+					evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q",
+					expected, errObj.Message)
+			}
+		case []int:
+			array, ok := evaluated.(*object.Array)
+			if !ok {
+				t.Errorf("obj not Array. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if len(array.Elements) != len(expected) {
+				t.Errorf("wrong num of elements. want=%d, got=%d",
+					len(expected), len(array.Elements))
+			}
+		}
+	}
+}
+
+func TestSandboxChecks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	var unrestricted *Sandbox
+	sandbox := &Sandbox{FileRoots: []string{root}}
+
+	// Links inside the root pointing outside of it, to a directory and to a
+	// file that doesn't exist yet.
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Skipf("can't create symbolic links: %s", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "new.txt"), filepath.Join(root, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(root, filepath.Join(outside, "in")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		ok   bool
+	}{
+		{"nil sandbox path", unrestricted.CheckPath("/etc/passwd"), true},
+		{"path in root", sandbox.CheckPath(filepath.Join(root, "a", "b.txt")), true},
+		{"root itself", sandbox.CheckPath(root), true},
+		{"path outside root", sandbox.CheckPath(filepath.Join(root, "..", "x")), false},
+		{"sibling with root prefix", sandbox.CheckPath(root + "x"), false},
+		{"link out of root", sandbox.CheckPath(filepath.Join(root, "escape")), false},
+		{"file through link", sandbox.CheckPath(filepath.Join(root, "escape", "x.txt")), false},
+		{"dangling link", sandbox.CheckPath(filepath.Join(root, "dangling")), false},
+		{"link into root", sandbox.CheckPath(filepath.Join(outside, "in", "x.txt")), true},
+	}
+
+	for _, tt := range tests {
+		if (tt.err == nil) != tt.ok {
+			t.Errorf("%s: wrong result. want ok=%t, got err=%v", tt.name, tt.ok, tt.err)
+		}
+	}
+}
+
+func TestNodeHooks(t *testing.T) {
+	input := `let f = fn(x) { x + 1 }; f(2)`
+
+	var trace []string
+	e := New()
+	e.Hooks = &Hooks{
+		EnterNode: func(node ast.Node) {
+			if _, ok := node.(*ast.InfixExpression); ok {
+				trace = append(trace, fmt.Sprintf("enter %s at depth %d",
+					node.String(), e.Depth()))
+			}
+		},
+		LeaveNode: func(node ast.Node, result object.Object) {
+			if _, ok := node.(*ast.InfixExpression); ok {
+				trace = append(trace, "leave "+result.Inspect())
+			}
+		},
+	}
+	program := parser.New(lexer.New(input)).ParseProgram()
+	testIntegerObject(t, e.Eval(program, NewEnvironment(program)), 3)
+
+	expected := []string{"enter (x + 1) at depth 1", "leave 3"}
+	if strings.Join(trace, "; ") != strings.Join(expected, "; ") {
+		t.Errorf("wrong trace. want=%q, got=%q", expected, trace)
+	}
+}
+
+func TestChecked(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x: Int = 5; x", 5},
+		{"let f = fn(a: Int, b) -> Int { a + b }; f(1, 2)", 3},
+		{"let f = fn(a: Any) -> Null { if (false) { a } }; f(1); 7", 7},
+		{`let x: Int = "s";`, "type assertion failed: let x is STRING, want Int"},
+		{`let f = fn(a: Int) { a }; f("s")`,
+			"type assertion failed: argument 1 (a) is STRING, want Int"},
+		{`let f = fn(a) -> Int { a }; f(true)`,
+			"type assertion failed: result is BOOLEAN, want Int"},
+		{`let f = fn() -> Int { return "s"; }; f()`,
+			"type assertion failed: result is STRING, want Int"},
+		{"let x: Num = 5;", "unknown type Num"},
+	}
+
+	for _, tt := range tests {
+		e := New()
+		e.Checked = true
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := e.Eval(program, NewEnvironment(program))
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%q: no error object returned. got=%T(%+v)",
+					tt.input, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("%q: wrong error message. want=%q, got=%q",
+					tt.input, expected, errObj.Message)
+			}
+		}
+	}
+
+	// Without Checked, annotations are ignored.
+	if str, ok := testEval(`let x: Int = "s"; x`).(*object.String); !ok || str.Value != "s" {
+		t.Errorf("annotations weren't ignored. got=%v", str)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		// Version 1: the blocks of ifs bind names in the enclosing scope and
+		// values of different types are never equal.
+		{"let x = 1; if (true) { let x = 2; } x", 2},
+		{"if (true) { let y = 3; } y", 3},
+		{"if (1 == true) { 1 } else { 2 }", 2},
+		// Version 2: block scoping and strict equality.
+		{"#pragma version 2\nlet x = 1; if (true) { let x = 2; } x", 1},
+		{"#pragma version 2\nif (true) { let y = 3; } y", "identifier not found: y"},
+		{"#pragma version 2\nlet f = fn(x) { if (x) { let x = 5; x } else { x } }; f(true)", 5},
+		{"#pragma version 2\nlet x = 1; if (true) { let y = x + 1; fn() { x + y } }()", 3},
+		{"#pragma version 2\n1 == true", "type mismatch: INTEGER == BOOLEAN"},
+		{"#pragma version 2\nif (1 != 2) { 4 }", 4},
+		{"#pragma version 2\nif (puts() == 1) { 0 } else { 6 }", 6},
+		{"while (true) { 1 }", "identifier not found: while (while loops came with version 2, see #pragma version)"},
+		{"let while = 7; while", 7},
+		{"let for = 1; let in = 2; for + in", 3},
+		{"for (x in [1]) { x }", "identifier not found: for (for loops came with version 2, see #pragma version)"},
+		{"null", "identifier not found: null (the null literal came with version 2, see #pragma version)"},
+		{"let null = 8; null ?? 1", 8},
+		{"let match = 1; let case = 2; let default = 3; match + case + default", 6},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("%q: want error %q, got=%T(%+v)",
+					tt.input, expected, evaluated, evaluated)
+			}
+		}
+	}
+
+	// Functions keep the version of the program that defined them.
+	e := New()
+	env := object.NewEnvironment()
+	define := parser.New(lexer.New("#pragma version 2\nlet f = fn() { 1 == true };")).ParseProgram()
+	e.Eval(define, env)
+	call := parser.New(lexer.New("f()")).ParseProgram()
+	if errObj, ok := e.Eval(call, env).(*object.Error); !ok || errObj.Code != diagnostic.TypeMismatch {
+		t.Errorf("f didn't keep strict equality")
+	}
+}
+
+func TestUnboxedIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2 * 3 - 8 / 4", "5"},
+		{"(1 + 2) * (3 - 4) < 0", "true"},
+		{"1 + 2 == 3", "true"},
+		{`let f = fn(x) { x * 2 }; f(1 + 2) + f(3) * 2`, "18"},
+		{`"a" + "b" + "c"`, "abc"},
+		{"1 + 2 + true", "ERROR:type mismatch: INTEGER + BOOLEAN at line 1, col 7"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+		// Hooks need the result of every node, so they turn off the fast
+		// path. The results and the steps counted must be the same.
+		unboxed := New()
+		boxed := New()
+		boxed.Hooks = &Hooks{LeaveNode: func(ast.Node, object.Object) {}}
+
+		got := unboxed.Eval(program, NewEnvironment(program))
+		if got.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s",
+				tt.input, tt.expected, got.Inspect())
+		}
+		if want := boxed.Eval(program, NewEnvironment(program)); want.Inspect() != got.Inspect() {
+			t.Errorf("wrong result for %q. boxed=%s, unboxed=%s",
+				tt.input, want.Inspect(), got.Inspect())
+		}
+		if unboxed.steps != boxed.steps {
+			t.Errorf("wrong steps for %q. boxed=%d, unboxed=%d",
+				tt.input, boxed.steps, unboxed.steps)
+		}
+	}
+}
+
+func BenchmarkIntegerArithmetic(b *testing.B) {
+	input := `
+let poly = fn(n, acc) {
+	if (n == 0) { acc } else { poly(n - 1, acc + 3 * n * n - 2 * n + n / 2 - 7) }
+};
+poly(500, 0);
+`
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		New().Eval(program, NewEnvironment(program))
+	}
+}
+
+// BenchmarkFib measures function calls and the lookup of parameters and
+// globals, which dominate recursive programs.
+func BenchmarkFib(b *testing.B) {
+	input := `
+let fib = fn(n) {
+	if (n < 2) { n } else { fib(n - 1) + fib(n - 2) }
+};
+fib(25);
+`
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		New().Eval(program, NewEnvironment(program))
+	}
+}
+
+// BenchmarkClosures measures closures that read and assign the locals of the
+// functions enclosing them.
+func BenchmarkClosures(b *testing.B) {
+	input := `
+let counter = fn() {
+	let n = 0;
+	fn(step) { let m = n + step; n = m; n }
+};
+let run = fn(i, next, acc) {
+	if (i == 0) { acc } else { run(i - 1, next, acc + next(1)) }
+};
+run(1000, counter(), 0);
+`
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		New().Eval(program, NewEnvironment(program))
+	}
+}
+
+func TestCSV(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`csvParse("a,b
+1,2")`, "[[a, b], [1, 2]]"},
+		{`csvParse("")`, "[]"},
+		{`csvParse("name,age
+ann,31
+bob,4", {"header": true})`, "[{name: ann, age: 31}, {name: bob, age: 4}]"},
+		{`csvParse("a;b
+1;2", {"separator": ";"})`, "[[a, b], [1, 2]]"},
+		{`csvParse("a b	c
+1	2", {"tsv": true})`, "[[a b, c], [1, 2]]"},
+		{`csvStringify([["a", "b,c"], [1, true]])`, "a,\"b,c\"\n1,true\n"},
+		{`csvStringify([{"a": 1, "b": 2}, {"b": 3}])`, "a,b\n1,2\n,3\n"},
+		{`csvStringify([{"a": 1}], {"header": false, "separator": ";"})`, "1\n"},
+		{`csvStringify([])`, ""},
+		// Errors.
+		{`csvParse("a,b
+1")`, "csvParse: record on line 2: wrong number of fields"},
+		{`csvParse("a", {"sep": ";"})`, "csvParse: unknown option sep"},
+		{`csvParse("a", {"separator": ";;"})`,
+			"csvParse: option separator must be a single character, got ;;"},
+		{`csvParse("a", {"header": 1})`, "csvParse: option header must be BOOLEAN, got INTEGER"},
+		{`csvStringify([[1], {"a": 1}])`, "csvStringify: row 1 must be ARRAY, got HASH"},
+		{`csvStringify([1])`, "csvStringify: row 0 must be ARRAY or HASH, got INTEGER"},
+		{`csvStringify([[[1]]])`, "csvStringify: unsupported field of type ARRAY"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch obj := evaluated.(type) {
+		case *object.String:
+			if obj.Value != tt.expected {
+				t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expected, obj.Value)
+			}
+		case *object.Error:
+			if obj.Message != tt.expected {
+				t.Errorf("%s: wrong error. want=%q, got=%q", tt.input, tt.expected, obj.Message)
+			}
+		default:
+			if obj.Inspect() != tt.expected {
+				t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expected, obj.Inspect())
+			}
+		}
+	}
+}
+
+func TestStringBuilder(t *testing.T) {
+	input := `
+	let repeat = fn(b, s, n) { if (n == 0) { b } else { repeat(append(b, s), s, n - 1) } };
+	build(repeat(strBuilder("<"), "ab", 3))
+	`
+
+	evaluated := testEval(input)
+	str, ok := evaluated.(*object.String)
+	if !ok {
+		t.Fatalf("object is not String. got=%T (%+v)", evaluated, evaluated)
+	}
+	if str.Value != "<ababab" {
+		t.Errorf("String has wrong value. got=%q", str.Value)
+	}
+}
+
+func TestStringFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`split("a,b,,c", ",")`, "[a, b, , c]"},
+		{`split("  one two   three ")`, "[one, two, three]"},
+		{`split("héllo", "")`, "[h, é, l, l, o]"},
+		{`split("", ",")`, "[]"},
+		{`join(["a", "b", "c"], ", ")`, "a, b, c"},
+		{`join(split("a b c"))`, "abc"},
+		{`join([])`, ""},
+		{`trim("  hi there  ")`, "hi there"},
+		{`trim("--hi--", "-")`, "hi"},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`contains("hello", "ell")`, "true"},
+		{`contains("hello", "le")`, "false"},
+		{`upper("hé")`, "HÉ"},
+		{`lower("HeLLo")`, "hello"},
+		{`substr("héllo", 1)`, "éllo"},
+		{`substr("héllo", 1, 2)`, "él"},
+		{`substr("héllo", -3, 10)`, "llo"},
+		{`substr("abc", 3)`, ""},
+		{`substr("abc", 1, 9223372036854775807)`, "bc"},
+		{`substr("héllo", 0, 2)`, "hé"},
+		// len counts bytes, substr characters.
+		{`len("héllo")`, "6"},
+		{`len(substr("héllo", 1, 1))`, "2"},
+		{`split(1)`, "ERROR:argument to `split` must be STRING, got INTEGER"},
+		{`split("a", 1)`, "ERROR:second argument to `split` must be STRING, got INTEGER"},
+		{`join("abc")`, "ERROR:argument to `join` must be ARRAY, got STRING"},
+		{`join(["a", 1])`, "ERROR:element 1 of the array to `join` must be STRING, got INTEGER"},
+		{`trim()`, "ERROR:wrong number of arguments. got=0, want=1 or 2"},
+		{`replace("a", "b")`, "ERROR:wrong number of arguments. got=2, want=3"},
+		{`contains(["a"], "a")`, "ERROR:first argument to `contains` must be STRING, got ARRAY"},
+		{`upper("a", "b")`, "ERROR:wrong number of arguments. got=2, want=1"},
+		{`substr("abc", "1")`, "ERROR:second argument to `substr` must be INTEGER, got STRING"},
+		{`substr("abc", 4)`, "ERROR:substr: start 4 out of range for 3 characters"},
+		{`substr("abc", -4)`, "ERROR:substr: start -4 out of range for 3 characters"},
+		{`substr("abc", 0, -1)`, "ERROR:length to `substr` must not be negative, got -1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if errObj, ok := evaluated.(*object.Error); ok {
+			evaluated = &object.Error{Message: errObj.Message}
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: want %s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestTraceHooks(t *testing.T) {
+	input := "let double = fn(x) { x * 2 };\ndouble(3)"
+
+	var out bytes.Buffer
+	e := New()
+	e.Hooks = e.TraceHooks(&out)
+	program := parser.New(lexer.New(input)).ParseProgram()
+	testIntegerObject(t, e.Eval(program, NewEnvironment(program)), 6)
+
+	expected := `[1:14] FunctionLiteral fn(x) (x * 2) => fn(x) { (x * 2) }
+[1:1] LetStatement let double = fn(x) (x * 2); => nil
+[2:1] Identifier double => fn(x) { (x * 2) }
+[2:8] IntegerLiteral 3 => 3
+  [1:22] Identifier x => 3
+  [1:26] IntegerLiteral 2 => 2
+  [1:24] InfixExpression (x * 2) => 6
+  [1:20] BlockStatement (x * 2) => 6
+[2:7] CallExpression double(3) => 6
+`
+	if out.String() != expected {
+		t.Errorf("wrong trace. want=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestProfile(t *testing.T) {
+	input := "let double = fn(x) { x * 2 };\nlet twice = fn(f, x) { f(f(x)) };\ntwice(double, 3) + fn() { 1 }() + len([])"
+
+	profile := NewProfile()
+	e := New()
+	e.Hooks = profile.Hooks()
+	program := parser.New(lexer.New(input)).ParseProgram()
+	profile.Start()
+	testIntegerObject(t, e.Eval(program, NewEnvironment(program)), 13)
+	profile.Stop()
+
+	if profile.Allocations == 0 || profile.AllocatedBytes == 0 {
+		t.Errorf("no allocations counted")
+	}
+	var out bytes.Buffer
+	profile.Write(&out)
+	expected := "nodes: 37\n" +
+		"       8  Identifier\n" +
+		"       5  CallExpression\n" +
+		"       5  ExpressionStatement\n" +
+		"       4  BlockStatement\n" +
+		"       4  InfixExpression\n" +
+		"       4  IntegerLiteral\n" +
+		"       3  FunctionLiteral\n" +
+		"       2  LetStatement\n" +
+		"       1  ArrayLiteral\n" +
+		"       1  Program\n" +
+		"calls: 5\n" +
+		"       2  f\n" +
+		"       1  fn\n" +
+		"       1  len\n" +
+		"       1  twice\n" +
+		fmt.Sprintf("allocations: %d (%d bytes)\n", profile.Allocations, profile.AllocatedBytes)
+	if out.String() != expected {
+		t.Errorf("wrong profile. want=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestAssertions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`assert(1 < 2); assertEq([1, {"a": 2.0}], [1, {"a": 2}]); 3`, "3"},
+		{`assert(1 > 2)`, "ERROR:assertion failed at line 1, col 1"},
+		{`let x = 5;
+assert(x < 2, "x is small")`, "ERROR:assertion failed: x is small at line 2, col 1"},
+		{`assertEq(1, "1")`, `ERROR:assertion failed: got 1, want "1" at line 1, col 1`},
+		{`assertEq([1, 2], [1], "lists")`, "ERROR:assertion failed: lists: got [1, 2], want [1] at line 1, col 1"},
+		{`assertEq({1: 2}, {2: 1})`, "ERROR:assertion failed: got {1: 2}, want {2: 1} at line 1, col 1"},
+		{`assert(true, 1)`, "ERROR:second argument to `assert` must be STRING, got INTEGER at line 1, col 1"},
+	}
+
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	// With Assertions, the failures are recorded and the program goes on.
+	input := "let check = fn(x) { assertEq(x, 1) };\nif (!check(2)) { assert(false, \"b\") };\n[check(1), wait(spawn(check, 3))]"
+	e := New()
+	e.Assertions = &Assertions{}
+	program := parser.New(lexer.New(input)).ParseProgram()
+	if got := e.Eval(program, NewEnvironment(program)).Inspect(); got != "[true, false]" {
+		t.Errorf("wrong result with assertions recorded. got=%s", got)
+	}
+	var failures []string
+	for _, err := range e.Assertions.Failures() {
+		failures = append(failures, err.Message+" at "+err.Position.String())
+	}
+	expected := []string{
+		"assertion failed: got 2, want 1 at line 1, col 21",
+		"assertion failed: b at line 2, col 18",
+		"assertion failed: got 3, want 1 at line 1, col 21",
+	}
+	if strings.Join(failures, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong failures. want=%q, got=%q", expected, failures)
+	}
+}
+
+func TestArgsAndExit(t *testing.T) {
+	input := `let a = args();
+let f = fn(n) { if (n > 1) { exit(len(a)) }; f(n + 1) };
+puts(a);
+f(0);
+puts("unreachable")`
+
+	var out bytes.Buffer
+	e := New()
+	e.Stdout = &out
+	e.Args = []string{"one", "-v"}
+	program := parser.New(lexer.New(input)).ParseProgram()
+	err, ok := e.Eval(program, NewEnvironment(program)).(*object.Error)
+	if !ok || err.Code != diagnostic.Exited || err.Status != 2 {
+		t.Fatalf("expected exit status 2. got=%+v", err)
+	}
+	if out.String() != "[one, -v]\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`exit()`, "ERROR:exit status 0 at line 1, col 1"},
+		{`args()`, "[]"},
+		{`exit("1")`, "ERROR:argument to `exit` must be INTEGER, got STRING at line 1, col 1"},
+		{`exit(255)`, "ERROR:exit status 255 at line 1, col 1"},
+		{`exit(256)`, "ERROR:argument to `exit` must be between 0 and 255, got 256 at line 1, col 1"},
+		{`exit(-1)`, "ERROR:argument to `exit` must be between 0 and 255, got -1 at line 1, col 1"},
+		{`args(1)`, "ERROR:wrong number of arguments. got=1, want=0 at line 1, col 1"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestSpawn(t *testing.T) {
+	input := `
+	let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+	let tasks = [spawn(fib, 15), spawn(fib, 16), spawn(fn() { puts("hi"); fib(17) })];
+	puts("waiting");
+	wait(tasks[0]) + wait(tasks[1]) + wait(tasks[2])
+	`
+
+	var out bytes.Buffer
+	e := New()
+	e.Stdout = &out
+	program := parser.New(lexer.New(input)).ParseProgram()
+	testIntegerObject(t, e.Eval(program, NewEnvironment(program)), 610+987+1597)
+
+	lines := strings.Fields(out.String())
+	sort.Strings(lines)
+	if strings.Join(lines, " ") != "hi waiting" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+}
+
+func TestSpawnPlainEnvironment(t *testing.T) {
+	// Tasks can't share an environment that isn't synchronized.
+	for _, input := range []string{
+		`spawn(fn() { 1 })`,
+		`pmap([1], fn(x) { x })`,
+		`after(1, fn() { 1 })`,
+	} {
+		program := parser.New(lexer.New(input)).ParseProgram()
+		err, ok := New().Eval(program, object.NewEnvironment()).(*object.Error)
+		if !ok || err.Code != diagnostic.InvalidArgument {
+			t.Errorf("%s: expected an InvalidArgument error. got=%v", input, err)
+		}
+	}
+}
+
+// TestSpawnAssignElements checks that tasks assigning to the elements of the
+// same arrays and hashes don't race, which `go test -race` reports.
+func TestSpawnAssignElements(t *testing.T) {
+	input := `#pragma version 2
+	let h = {};
+	let a = [0, 0];
+	let work = fn(n) {
+	  let i = 0;
+	  while (i < 200) {
+	    h[n] = i;
+	    h["shared"] = n;
+	    a[n % 2] += 1;
+	    h["shared"] + a[0];
+	    i += 1;
+	  }
+	  n
+	};
+	let tasks = map([1, 2, 3, 4, 5, 6, 7, 8], fn(n) { spawn(work, n) });
+	[map(tasks, wait), h["shared"] > 0, a[0] + a[1] <= 1600]
+	`
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+	evaluated := New().Eval(program, NewEnvironment(program))
+	if got := evaluated.Inspect(); got != "[[1, 2, 3, 4, 5, 6, 7, 8], true, true]" {
+		t.Errorf("wrong result. got=%s", got)
+	}
+}
+
+func TestStacks(t *testing.T) {
+	input := `
+let inner = fn(x) { probe() };
+let outer = fn() { inner(1) };
+wait(spawn(outer))
+`
+
+	e := New()
+	e.EnableInspection()
+	var stacks []Stack
+	e.SetBuiltin("probe", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		stacks = e.Stacks()
+		return NULL
+	}})
+	program := parser.New(lexer.New(input)).ParseProgram()
+	e.Eval(program, NewEnvironment(program))
+
+	var got []string
+	for _, stack := range stacks {
+		var frames []string
+		for _, frame := range stack.Frames {
+			frames = append(frames, fmt.Sprintf("%s@%s", frame.Function, frame.Position))
+		}
+		got = append(got, fmt.Sprintf("%d: [%s] (%d envs)",
+			stack.Task, strings.Join(frames, " "), len(stack.Envs)))
+	}
+	// Builtins like wait and spawn don't get frames: the task calls outer
+	// from spawn, so only its environment is known.
+	expected := []string{
+		"0: [] (0 envs)",
+		"1: [inner@line 3, col 20] (2 envs)",
+	}
+	if strings.Join(got, "; ") != strings.Join(expected, "; ") {
+		t.Errorf("wrong stacks. want=%q, got=%q", expected, got)
+	}
+	if x, _ := stacks[1].Envs[0].Get("x"); x == nil || x.Inspect() != "1" {
+		t.Errorf("wrong environment of inner. got x=%v", x)
+	}
+	if stacks := e.Stacks(); len(stacks) != 0 {
+		t.Errorf("stacks left after the evaluation. got=%v", stacks)
+	}
+}
+
+func TestChannels(t *testing.T) {
+	input := `
+	let produce = fn(c, n) {
+		if (n == 0) { close(c) } else { send(c, n); produce(c, n - 1) }
+	};
+	let null = if (false) { 1 };
+	let consume = fn(c, sum) {
+		let n = recv(c);
+		if (n == null) { sum } else { consume(c, sum + n) }
+	};
+	let c = chan();
+	spawn(produce, c, 100);
+	consume(c, 0)
+	`
+
+	testIntegerObject(t, testEval(input), 5050)
+}
+
+func TestTimers(t *testing.T) {
+	input := `
+	let c = chan(1);
+	let cancelled = after(10, fn() { send(c, "cancelled") });
+	cancel(cancelled);
+	after(0, fn() { 1 + true });
+	after(50, fn() { send(c, "fired") });
+	[recv(c), cancelled]
+	`
+
+	var stderr syncBuffer
+	e := New()
+	e.Stderr = &stderr
+	program := parser.New(lexer.New(input)).ParseProgram()
+	evaluated := e.Eval(program, NewEnvironment(program))
+
+	if evaluated.Inspect() != "[fired, timer(stopped)]" {
+		t.Errorf("wrong result. got=%s", evaluated.Inspect())
+	}
+	if stderr.String() != "timer: type mismatch: INTEGER + BOOLEAN\n" {
+		t.Errorf("wrong error output. got=%q", stderr.String())
+	}
+}
+
+func TestWaitTimers(t *testing.T) {
+	input := `
+	let fired = {};
+	let t = after(10, fn() { fired["by"] = "after" });
+	let c = every(1, fn() { 1 });
+	cancel(c);
+	[wait(t), fired["by"], wait(c)]
+	`
+	evaluated := testEval(input)
+	if evaluated.Inspect() != "[null, after, null]" {
+		t.Errorf("wrong result. got=%s", evaluated.Inspect())
+	}
+
+	// The timers outlive the evaluation, until they're stopped.
+	e := New()
+	env := object.NewSynchronizedEnvironment()
+	program := parser.New(lexer.New(`let h = {}; after(20, fn() { h["x"] = 1 }); h`)).ParseProgram()
+	h := e.Eval(program, env)
+	if err := e.WaitTimers(context.Background()); err != nil {
+		t.Fatalf("WaitTimers failed: %s", err)
+	}
+	if h.Inspect() != "{x: 1}" {
+		t.Errorf("timer didn't fire before WaitTimers returned. got=%s", h.Inspect())
+	}
+
+	program = parser.New(lexer.New(`every(1, fn() { 1 })`)).ParseProgram()
+	e.Eval(program, env)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := e.WaitTimers(ctx); err != context.DeadlineExceeded {
+		t.Errorf("WaitTimers returned while a timer of every was running. got=%v", err)
+	}
+}
+
+// syncBuffer is a bytes.Buffer that concurrent tasks can write to.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestOnSignal(t *testing.T) {
+	tests := []struct {
+		input    string
+		sandbox  *Sandbox
+		expected string
+	}{
+		{`let c = chan(1); onSignal("HUP", fn(name) { send(c, name) }); raise(); recv(c)`,
+			nil, "HUP"},
+		{`onSignal("HUP", fn(name) { 1 + true }); raise(); recv(chan())`,
+			nil, "ERROR:type mismatch: INTEGER + BOOLEAN at line 1, col 30"},
+		{`onSignal("HUP", len)`, &Sandbox{},
+			"ERROR:onSignal: sandbox: handling signals is not allowed at line 1, col 1"},
+		{`let c = chan(1); onSignal("HUP", fn(name) { send(c, name) }); raise(); recv(c)`,
+			&Sandbox{Signals: true}, "HUP"},
+	}
+
+	for _, tt := range tests {
+		e := New()
+		e.Sandbox = tt.sandbox
+		e.SetBuiltin("raise", &object.Builtin{
+			Fn: func(args ...object.Object) object.Object {
+				p, err := os.FindProcess(os.Getpid())
+				if err == nil {
+					err = p.Signal(syscall.SIGHUP)
+				}
+				if err != nil {
+					t.Skipf("can't send signals: %s", err)
+				}
+				return NULL
+			},
+		})
+
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		evaluated := e.EvalContext(ctx, program, NewEnvironment(program))
+		cancel()
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result. want=%s, got=%s", tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let g = fn() { yield 1; yield 2 }(); [next(g), next(g), next(g)]`,
+			"[1, 2, null]"},
+		{`let take = fn(g, n, acc) { if (n == 0) { acc } else { take(g, n - 1, push(acc, next(g))) } };
+		  let countdown = fn(n) { yield n; yield n - 1; yield n - 2 };
+		  take(countdown(3), 2, [])`, "[3, 2]"},
+		// Values are only produced on demand.
+		{`let log = chan(10);
+		  let g = fn() { send(log, "a"); yield 1; send(log, "b"); yield 2 }();
+		  next(g); close(log); [recv(log), recv(log)]`, "[a, null]"},
+		{`let g = fn() { yield 1; 1 + true }(); [next(g), next(g)]`,
+			"type mismatch: INTEGER + BOOLEAN"},
+		{`let g = fn() { yield 1 }(); next(g); next(g); next(g)`, nil},
+		{`let g = fn() { yield 1 }(); g`, "generator"},
+		// Generators are iterable, lazily mapped and filtered, and reduced.
+		{`let xs = []; for (i, x in fn() { yield "a"; yield "b" }()) { xs = push(xs, [i, x]) }; xs`,
+			"[[0, a], [1, b]]"},
+		{`for (x in fn() { yield 1; 1 + true }()) { x }`, "type mismatch: INTEGER + BOOLEAN"},
+		{`let n = fn() { let i = 0; while (true) { yield i; i += 1 } };
+		  let g = filter(map(n(), fn(x) { x * 3 }), fn(x) { x % 2 == 0 });
+		  [next(g), next(g), next(g)]`, "[0, 6, 12]"},
+		{`let g = map(fn() { yield 1; yield 2 }(), fn(x) { x + true }); next(g)`,
+			"type mismatch: INTEGER + BOOLEAN"},
+		{`reduce(fn() { yield 1; yield 2; yield 3 }(), fn(a, b) { a * 10 + b })`, 123},
+		{`reduce(fn() { yield 1; 1 + true }(), fn(a, b) { a + b }, 0)`,
+			"type mismatch: INTEGER + BOOLEAN"},
+		{`reduce(filter(fn() { yield 1 }(), fn(x) { false }), fn(a, b) { a + b })`,
+			"reduce of an empty generator without an initial value"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval("#pragma version 3\n" + tt.input)
+
+		switch expected := tt.expected.(type) {
+		case nil:
+			testNullObject(t, evaluated)
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q",
+						expected, errObj.Message)
+				}
+				continue
+			}
+			if evaluated.Inspect() != expected {
+				t.Errorf("wrong result. want=%s, got=%s", expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+
+	tests := []struct {
+		input    string
+		sandbox  *Sandbox
+		expected string
+	}{
+		// String literals can't contain escapes, but they can span lines.
+		{`let f = open("PATH", "w"); write(f, "one
+two
+"); close(f)`, nil, "null"},
+		{`let f = open("PATH", "a"); let n = write(f, "three"); close(f); n`, nil, "5"},
+		{`let f = open("PATH"); let first = readLine(f); let rest = read(f); close(f);
+		  [first, rest, f]`, nil, "[one, two\nthree, file(\"PATH\", \"r\", closed)]"},
+		{`let f = open("PATH"); readLine(f); readLine(f); readLine(f); readLine(f)`,
+			nil, "null"},
+		{`let f = open("PATH"); close(f); read(f)`, nil, "ERROR:read: file is closed"},
+		{`let f = open("PATH"); close(f); close(f)`, nil, "ERROR:close: file is closed"},
+		{`write(open("PATH"), "x")`, nil, "ERROR:write: file is not open for writing"},
+		{`open("PATH", "rw")`, nil,
+			`ERROR:open: invalid mode "rw", want "r", "w" or "a"`},
+		{`readLine(1)`, nil, "ERROR:argument to `readLine` must be FILE, got INTEGER"},
+		{`close(1)`, nil, "ERROR:argument to `close` must be CHANNEL or FILE, got INTEGER"},
+		{`read(open("PATH"))`, &Sandbox{FileRoots: []string{dir}}, "one\ntwo\nthree"},
+		{`open("PATH")`, &Sandbox{}, "ERROR:open: sandbox: access to PATH is not allowed"},
+		{`[writeFile("PATH", "ab"), appendFile("PATH", "c"), readFile("PATH")]`, nil, "[2, 1, abc]"},
+		{`writeFile("PATH", ""); [exists("PATH"), exists("PATH.missing"), readFile("PATH")]`,
+			nil, "[true, false, ]"},
+		{`readFile("PATH.missing")`, nil,
+			"ERROR:readFile: open PATH.missing: no such file or directory"},
+		{`writeFile("PATH", 1)`, nil, "ERROR:second argument to `writeFile` must be STRING, got INTEGER"},
+		{`exists()`, nil, "ERROR:wrong number of arguments. got=0, want=1"},
+		{`appendFile("PATH", "x"); readFile("PATH")`, &Sandbox{FileRoots: []string{dir}}, "x"},
+		{`readFile("PATH")`, &Sandbox{}, "ERROR:readFile: sandbox: access to PATH is not allowed"},
+		{`writeFile("PATH", "")`, &Sandbox{}, "ERROR:writeFile: sandbox: access to PATH is not allowed"},
+		{`exists("PATH")`, &Sandbox{}, "ERROR:exists: sandbox: access to PATH is not allowed"},
+	}
+
+	for _, tt := range tests {
+		input := strings.Replace(tt.input, "PATH", path, -1)
+		expected := strings.Replace(tt.expected, "PATH", path, -1)
+
+		e := New()
+		e.Sandbox = tt.sandbox
+		program := parser.New(lexer.New(input)).ParseProgram()
+		evaluated := e.Eval(program, NewEnvironment(program))
+
+		result := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			// Leave out the position, which depends on the length of the path.
+			result = "ERROR:" + errObj.Message
+		}
+		if result != expected {
+			t.Errorf("wrong result for %s. want=%q, got=%q",
+				tt.input, expected, result)
+		}
+	}
+}
+
+func TestDates(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`dateParse("2024-02-28T23:30:00Z")`, "2024-02-28T23:30:00Z"},
+		{`dateParse("2024-02-28 23:30", "2006-01-02 15:04")`, "2024-02-28T23:30:00Z"},
+		{`dateFormat(dateParse("2024-02-28", "date"), "Jan 2, 2006")`, "Feb 28, 2024"},
+		{`dateFormat(dateParse("2024-03-01T10:00:00+02:00"), "RFC1123")`,
+			"Fri, 01 Mar 2024 10:00:00 +0200"},
+		{`let p = dateParts(dateParse("2024-02-29 13:05:09", "datetime"));
+		  [p["year"], p["month"], p["day"], p["hour"], p["minute"], p["second"], p["weekday"], p["zone"]]`,
+			"[2024, 2, 29, 13, 5, 9, 4, UTC]"},
+		{`dateUnix(dateParse("1970-01-02", "date"))`, "86400"},
+		{`dateUnix(86400)`, "1970-01-02T00:00:00Z"},
+		// Arithmetic.
+		{`dateParse("2024-02-28", "date") + duration("36h")`, "2024-02-29T12:00:00Z"},
+		{`duration("1h") + dateParse("2024-02-28", "date")`, "2024-02-28T01:00:00Z"},
+		{`dateParse("2024-03-01", "date") - duration("1m")`, "2024-02-29T23:59:00Z"},
+		{`dateParse("2024-03-01", "date") - dateParse("2024-02-28", "date")`, "48h0m0s"},
+		{`duration("1h30m") - duration(600000)`, "1h20m0s"},
+		{`[duration("1m") * 3, 2 * duration("1s"), duration("1h") / 4]`, "[3m0s, 2s, 15m0s]"},
+		{`duration("1h") / duration("20m")`, "3"},
+		{`durationMs(duration("1.5s"))`, "1500"},
+		{`let a = dateParse("2024-01-01", "date"); let b = a + duration("1s");
+		  [a < b, a > b, a == a + duration("0s"), a != b, duration("1s") < duration("1m")]`,
+			"[true, false, true, true, true]"},
+		{`let a = dateParse("2024-01-01", "date"); let b = a + duration("1s");
+		  [a <= b, a >= b, a <= a, b >= a, duration("1s") >= duration("1m")]`,
+			"[true, false, true, true, false]"},
+		{`dateParse("2024-01-01", "date") == 1`, "false"},
+		{`csvStringify([[dateUnix(0), duration("1s")]])`, "1970-01-01T00:00:00Z,1s\n"},
+		// Errors.
+		{`dateParse("2024-13-01", "date")`,
+			`ERROR:dateParse: parsing time "2024-13-01": month out of range`},
+		{`duration("soon")`, `ERROR:duration: time: invalid duration "soon"`},
+		{`dateParse("2024-01-01", "date") + 1`, "ERROR:type mismatch: DATE + INTEGER"},
+		{`duration("1s") * duration("1s")`, "ERROR:unknown operator: DURATION * DURATION"},
+		{`duration("1s") / 0`, "ERROR:division by zero"},
+		{`dateFormat(1)`, "ERROR:argument to `dateFormat` must be DATE, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		result := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			result = "ERROR:" + errObj.Message
+		}
+		if result != tt.expected {
+			t.Errorf("wrong result for %s. want=%q, got=%q", tt.input, tt.expected, result)
+		}
+	}
+
+	if d, ok := testEval("dateNow()").(*object.Date); !ok || time.Since(d.Time) > time.Minute {
+		t.Errorf("dateNow isn't now. got=%v", d)
+	}
+}
+
+func TestStores(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	tests := []struct {
+		input    string
+		sandbox  *Sandbox
+		expected string
+	}{
+		{`let s = storeOpen("PATH"); [storeGet(s, "n"), storeGet(s, "n", 0), storeKeys(s)]`,
+			nil, "[null, 0, []]"},
+		{`let s = storeOpen("PATH"); storeSet(s, "n", 1); storeSet(s, "h", {"a": [1, true], 2: "b"})`,
+			nil, "{a: [1, true], 2: b}"},
+		// The store persists between programs.
+		{`let s = storeOpen("PATH"); [s, storeGet(s, "n"), storeGet(s, "h"), storeKeys(s)]`,
+			nil, `[store("PATH", 2 keys), 1, {a: [1, true], 2: b}, [h, n]]`},
+		{`let s = storeOpen("PATH"); [storeDelete(s, "n"), storeDelete(s, "n"), storeKeys(s)]`,
+			nil, "[true, false, [h]]"},
+		{`storeSet(storeOpen("PATH"), "f", fn() { 1 })`,
+			nil, "ERROR:storeSet: can't store a value of type FUNCTION"},
+		{`storeGet(storeOpen("PATH"), 1)`,
+			nil, "ERROR:second argument to `storeGet` must be STRING, got INTEGER"},
+		{`storeKeys(1)`, nil, "ERROR:argument to `storeKeys` must be STORE, got INTEGER"},
+		{`storeKeys(storeOpen("PATH"))`, &Sandbox{FileRoots: []string{dir}}, "[h]"},
+		{`storeOpen("PATH")`, &Sandbox{}, "ERROR:storeOpen: sandbox: access to PATH is not allowed"},
+	}
+
+	for _, tt := range tests {
+		input := strings.Replace(tt.input, "PATH", path, -1)
+		expected := strings.Replace(tt.expected, "PATH", path, -1)
+
+		e := New()
+		e.Sandbox = tt.sandbox
+		program := parser.New(lexer.New(input)).ParseProgram()
+		evaluated := e.Eval(program, NewEnvironment(program))
+
+		result := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			result = "ERROR:" + errObj.Message
+		}
+		if result != expected {
+			t.Errorf("wrong result for %s. want=%q, got=%q",
+				tt.input, expected, result)
+		}
+	}
+}
+
+type abort struct{ err *object.Error }
+
+func (a *abort) ErrorObject() *object.Error { return a.err }
+
+func TestInternalErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		internal bool
+	}{
+		{"let f = fn() { boom() }; f()",
+			"internal interpreter error: boom at line 1, col 16", true},
+		{"wait(spawn(fn() { boom() }))",
+			"internal interpreter error: boom at line 1, col 19", true},
+		{"abort()", "aborted at line 1, col 1", false},
+	}
+
+	e := New()
+	e.SetBuiltin("boom", &object.Builtin{
+		Fn: func(args ...object.Object) object.Object { panic("boom") },
+	})
+	e.SetBuiltin("abort", &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			panic(&abort{err: &object.Error{Message: "aborted"}})
+		},
+	})
+	env := object.NewSynchronizedEnvironment()
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := e.Eval(program, env)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)",
+				tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Describe() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q",
+				tt.input, tt.expected, errObj.Describe())
+		}
+		if internal := errObj.Code == diagnostic.InternalError; internal != tt.internal {
+			t.Errorf("%s: wrong code %s", tt.input, errObj.Code)
+		}
+		if tt.internal && !strings.Contains(errObj.Stack, "goroutine") {
+			t.Errorf("%s: expected the Go stack. got=%q", tt.input, errObj.Stack)
+		}
+	}
+
+	// The evaluator is still usable after a panic.
+	program := parser.New(lexer.New("1 + 2")).ParseProgram()
+	testIntegerObject(t, e.Eval(program, env), 3)
+}
+
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		warnings *Warnings
+		expected string
+	}{
+		{"old(1); old(2); fn() { old(3) }()", nil,
+			"warning: `old` is deprecated: use new instead at line 1, col 1\n"},
+		{"old(1)",
+			&Warnings{Disabled: map[WarningCategory]bool{DeprecatedWarnings: true}}, ""},
+	}
+
+	for _, tt := range tests {
+		var stderr bytes.Buffer
+		e := New()
+		e.Stderr = &stderr
+		e.Warnings = tt.warnings
+		e.SetBuiltin("old", &object.Builtin{
+			Fn:         func(args ...object.Object) object.Object { return NULL },
+			Deprecated: "use new instead",
+		})
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		if result := e.Eval(program, NewEnvironment(program)); isError(result) {
+			t.Errorf("%s: unexpected error: %s", tt.input, result.Inspect())
+		}
+
+		if stderr.String() != tt.expected {
+			t.Errorf("%s: wrong warnings. want=%q, got=%q",
+				tt.input, tt.expected, stderr.String())
+		}
+	}
+}
+
+func TestWarningsHandle(t *testing.T) {
+	var warnings []Warning
+	var out bytes.Buffer
+	e := New()
+	e.Stderr = &out
+	e.Warnings = &Warnings{
+		Out:    &out,
+		Handle: func(w Warning) { warnings = append(warnings, w) },
+	}
+	e.SetBuiltin("old", &object.Builtin{
+		Fn:         func(args ...object.Object) object.Object { return NULL },
+		Deprecated: "use new instead",
+	})
+	program := parser.New(lexer.New("1;\n2 * old()")).ParseProgram()
+	e.Eval(program, NewEnvironment(program))
+
+	if out.Len() != 0 {
+		t.Errorf("warnings written although handled: %q", out.String())
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("wrong number of warnings. want=1, got=%d", len(warnings))
+	}
+	d := warnings[0].Diagnostic()
+	if d.String() != "2:5: warning W2002: `old` is deprecated: use new instead" {
+		t.Errorf("wrong diagnostic. got=%q", d.String())
+	}
+}
+
+func TestCheckedArithmetic(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "integer overflow: 9223372036854775807 + 1"},
+		{"let min = -9223372036854775807 - 1; -min",
+			"integer overflow: -(-9223372036854775808)"},
+		{"let min = -9223372036854775807 - 1; min / -1",
+			"integer overflow: -9223372036854775808 / -1"},
+		{"let x = 4611686018427387904; x *= 2",
+			"integer overflow: 4611686018427387904 * 2"},
+		{"let a = [2]; a[0] *= 9223372036854775807",
+			"integer overflow: 2 * 9223372036854775807"},
+		{"2 ** 64", "integer overflow: 2 ** 64"},
+		{"3 ** 40", "integer overflow: 3 ** 40"},
+		{"-9223372036854775807 - 2", "integer overflow: -9223372036854775807 - 2"},
+		{"let f = fn(x) { x + 1 }; f(9223372036854775807)",
+			"integer overflow: 9223372036854775807 + 1"},
+		{"(9223372036854775807 - 1) + 1", ""},
+		{"4611686018427387904 * -2; 2 ** 62; -3 * 5", ""},
+		{"(-2) ** 63; 1 ** 1000000; (-1) ** 1000001", ""},
+	}
+
+	for _, tt := range tests {
+		var stderr bytes.Buffer
+		e := New()
+		e.Stderr = &stderr
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := e.Eval(program, NewEnvironment(program))
+
+		errObj, isErr := evaluated.(*object.Error)
+		switch {
+		case tt.expected == "" && isErr:
+			t.Errorf("%s: unexpected error: %s", tt.input, errObj.Message)
+		case tt.expected != "" && !isErr:
+			t.Errorf("%s: no error object returned. got=%s", tt.input, evaluated.Inspect())
+		case isErr && (errObj.Code != diagnostic.ArithmeticOverflow ||
+			errObj.Message != tt.expected):
+			t.Errorf("%s: wrong error. want=%q, got=%s %q",
+				tt.input, tt.expected, errObj.Code, errObj.Message)
+		}
+		// Overflows are errors, not warnings.
+		if stderr.Len() != 0 {
+			t.Errorf("%s: unexpected warnings: %q", tt.input, stderr.String())
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	// The busy task starts first. With a single slot, it has to let the
+	// program start the quick task and let the quick task run long before
+	// it's done, unless its budget is large enough to finish first.
+	input := `
+	let log = chan(10);
+	let started = chan();
+	let busy = fn(n) { if (n == 0) { send(log, "busy done") } else { busy(n - 1) } };
+	let a = spawn(fn() { send(started, true); busy(2000) });
+	recv(started);
+	let b = spawn(fn() { send(log, "quick done") });
+	wait(b);
+	wait(a);
+	[recv(log), recv(log)]
+	`
+
+	tests := []struct {
+		parallelism int
+		budget      int64
+		expected    string
+	}{
+		{1, 100, "[quick done, busy done]"},
+		{1, 0, "[quick done, busy done]"},
+		{1, 1 << 40, "[busy done, quick done]"},
+		{2, 100, "[quick done, busy done]"},
+	}
+
+	for _, tt := range tests {
+		e := New()
+		e.Parallelism = tt.parallelism
+		e.TaskBudget = tt.budget
+		program := parser.New(lexer.New(input)).ParseProgram()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		evaluated := e.EvalContext(ctx, program, NewEnvironment(program))
+		cancel()
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result with parallelism %d and budget %d. want=%s, got=%s",
+				tt.parallelism, tt.budget, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	// Blocking operations and generators give up the slot, so they don't
+	// deadlock with a single one.
+	blocking := []string{
+		`let c = chan(); spawn(fn() { send(c, 1) }); recv(c)`,
+		"#pragma version 3\nlet g = fn() { yield 1 }(); next(g)",
+		`pmap([1], fn(x) { x })[0]`,
+		`let c = chan(1); after(1, fn() { send(c, 1) }); recv(c)`,
+	}
+	for _, input := range blocking {
+		e := New()
+		e.Parallelism = 1
+		program := parser.New(lexer.New(input)).ParseProgram()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		testIntegerObject(t, e.EvalContext(ctx, program, NewEnvironment(program)), 1)
+		cancel()
+	}
+}
+
+func TestSpawnCancelled(t *testing.T) {
+	tests := []string{
+		`let loop = fn() { loop() }; wait(spawn(loop))`,
+		`recv(chan())`,
+		`let c = chan(); send(c, 1)`,
+	}
+
+	for _, input := range tests {
+		testCancelled(t, input)
+	}
+}
+
+// TestDecodedAST tests that ASTs encoded to JSON and decoded back evaluate
+// like the ones the parser returned.
+func TestDecodedAST(t *testing.T) {
+	tests := []string{
+		`let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)`,
+		`let h = {"a": [1, 2.5, "x"]}; h["a"][1] * 2`,
+		`#pragma version 2
+let total = 0; for (i, x in [1, 2, 3]) { total += i * x }; match (total) { case 8: { null } default: { total } }`,
+		`#pragma version 3
+let gen = fn() { yield 1; yield 2 }; let g = gen(); [next(g), next(g)]`,
+		`let f = fn(x) { x.y }; f({})`,
+		`let f = fn fact(n, acc = 1) { if (n < 2) { acc } else { fact(n - 1, acc * n) } }; f(5)`,
+	}
+
+	for _, input := range tests {
+		program := parser.New(lexer.New(input)).ParseProgram()
+		data, err := ast.Encode(program)
+		if err != nil {
+			t.Fatalf("Encode failed for %s: %s", input, err)
+		}
+		decoded, err := ast.Decode(data)
+		if err != nil {
+			t.Fatalf("Decode failed for %s: %s", input, err)
+		}
+
+		expected := Eval(program, NewEnvironment(program)).Inspect()
+		got := Eval(decoded, NewEnvironment(decoded)).Inspect()
+		if got != expected {
+			t.Errorf("wrong result of the decoded AST of %s. want=%q, got=%q", input, expected, got)
+		}
+	}
+}
+
+func testCancelled(t *testing.T, input string) {
+	e := New()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	program := parser.New(lexer.New(input)).ParseProgram()
+	evaluated := e.EvalContext(ctx, program, NewEnvironment(program))
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Message != "evaluation cancelled: context deadline exceeded" {
+		t.Errorf("wrong result for %s. got=%T (%+v)", input, evaluated, evaluated)
+	}
+}
+
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -616,7 +2760,7 @@ func testEval(input string) object.Object {
 	// case. Each call to testEval should have a fresh environment so we don't
 	// run into weird bugs involving global state caused by the order in which
 	// tests are run.
-	env := object.NewEnvironment()
+	env := NewEnvironment(program)
 
 	// The heart of the test is the call to Eval.
 	return Eval(program, env)
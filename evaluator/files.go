@@ -0,0 +1,106 @@
+package evaluator
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/cedrickchee/hou/builtinerr"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// open opens the file at path with the mode, if the sandbox allows it.
+func (e *Evaluator) open(path, mode string) object.Object {
+	if err := e.Sandbox.CheckPath(path); err != nil {
+		return builtinerr.Wrap(diagnostic.AccessDenied, "open", err)
+	}
+
+	f, err := object.OpenFile(path, mode)
+	if err != nil {
+		return builtinerr.Wrap(diagnostic.IOError, "open", err)
+	}
+	return f
+}
+
+// fileArgument returns the file that's the only argument to the builtin
+// called name.
+func fileArgument(name string, args []object.Object) (*object.File, *object.Error) {
+	if err := builtinerr.ArgCount(args, 1, 1); err != nil {
+		return nil, err
+	}
+	f, ok := args[0].(*object.File)
+	if !ok {
+		return nil, builtinerr.ArgType(name, args, 0, object.FILE_OBJ)
+	}
+	return f, nil
+}
+
+// readFile returns the contents of the file at path, if the sandbox allows it.
+func (e *Evaluator) readFile(path string) object.Object {
+	if err := e.Sandbox.CheckPath(path); err != nil {
+		return builtinerr.Wrap(diagnostic.AccessDenied, "readFile", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return builtinerr.Wrap(diagnostic.IOError, "readFile", err)
+	}
+	return e.allocated(&object.String{Value: string(data)})
+}
+
+// writeFile writes the contents to the file at path, if the sandbox allows it,
+// and returns the number of bytes written. The file is created if it doesn't
+// exist, and truncated first unless flag has os.O_APPEND, for the builtin
+// called name.
+func (e *Evaluator) writeFile(name, path, contents string, flag int) object.Object {
+	if err := e.Sandbox.CheckPath(path); err != nil {
+		return builtinerr.Wrap(diagnostic.AccessDenied, name, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|flag, 0666)
+	if err != nil {
+		return builtinerr.Wrap(diagnostic.IOError, name, err)
+	}
+	n, err := f.WriteString(contents)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return builtinerr.Wrap(diagnostic.IOError, name, err)
+	}
+	return object.NewInteger(int64(n))
+}
+
+// exists reports whether there's a file or directory at path, if the sandbox
+// allows to look.
+func (e *Evaluator) exists(path string) object.Object {
+	if err := e.Sandbox.CheckPath(path); err != nil {
+		return builtinerr.Wrap(diagnostic.AccessDenied, "exists", err)
+	}
+
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return FALSE
+	}
+	if err != nil {
+		return builtinerr.Wrap(diagnostic.IOError, "exists", err)
+	}
+	return TRUE
+}
+
+// pathArguments checks the arguments of the builtin called name that takes a
+// path and, if want is 2, a string to write, and returns them.
+func pathArguments(name string, args []object.Object, want int) ([]string, *object.Error) {
+	if err := builtinerr.ArgCount(args, want, want); err != nil {
+		return nil, err
+	}
+	values := make([]string, want)
+	for i, arg := range args {
+		str, ok := arg.(*object.String)
+		if !ok {
+			return nil, builtinerr.ArgType(name, args, i, object.STRING_OBJ)
+		}
+		values[i] = str.Value
+	}
+	return values, nil
+}
@@ -0,0 +1,68 @@
+package evaluator
+
+import (
+	"math"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// isNumber reports whether obj is a number, an integer or a float.
+func isNumber(obj object.Object) bool {
+	_, ok := toFloat(obj)
+	return ok
+}
+
+// toFloat returns the value of the number obj as a float, and whether obj is
+// a number at all, an integer or a float.
+func toFloat(obj object.Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value), true
+	case *object.Float:
+		return obj.Value, true
+	}
+	return 0, false
+}
+
+// evalFloatInfixExpression applies the operator to two numbers, at least one
+// of which is a float. Integers are converted to floats first, so 1 + 0.5 is
+// 1.5, and 1 == 1.0. Floats follow IEEE 754: dividing by zero makes an
+// infinity, not an error, and so does the remainder, a NaN.
+func evalFloatInfixExpression(
+	operator string,
+	left, right object.Object,
+) object.Object {
+	l, _ := toFloat(left)
+	r, _ := toFloat(right)
+
+	switch operator {
+	case "+":
+		return &object.Float{Value: l + r}
+	case "-":
+		return &object.Float{Value: l - r}
+	case "*":
+		return &object.Float{Value: l * r}
+	case "/":
+		return &object.Float{Value: l / r}
+	case "%":
+		return &object.Float{Value: math.Mod(l, r)}
+	case "**":
+		return &object.Float{Value: math.Pow(l, r)}
+	case "<":
+		return nativeBoolToBooleanObject(l < r)
+	case ">":
+		return nativeBoolToBooleanObject(l > r)
+	case "<=":
+		return nativeBoolToBooleanObject(l <= r)
+	case ">=":
+		return nativeBoolToBooleanObject(l >= r)
+	case "==":
+		return nativeBoolToBooleanObject(l == r)
+	case "!=":
+		return nativeBoolToBooleanObject(l != r)
+	default:
+		return newError(diagnostic.UnknownOperator, "unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
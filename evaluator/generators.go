@@ -0,0 +1,128 @@
+package evaluator
+
+import (
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// newGenerator returns the generator produced by calling the generator
+// function fn with args.
+func (e *Evaluator) newGenerator(
+	fn *object.Function,
+	args []object.Object,
+) *object.Generator {
+	return e.generator(func(child *Evaluator) object.Object {
+		return child.callFunction(fn, args)
+	})
+}
+
+// NewGenerator returns a generator producing the values body passes to yield,
+// which evaluates to null, or to the error that stops body. It's how the VM
+// runs its generator functions.
+func (e *Evaluator) NewGenerator(
+	body func(yield func(value object.Object) object.Object) object.Object,
+) *object.Generator {
+	return e.generator(func(child *Evaluator) object.Object {
+		return body(child.yieldValue)
+	})
+}
+
+// generator returns a generator whose body runs on an Evaluator of its own,
+// like a task started by `spawn`, but only while the consumer waits for a
+// value.
+func (e *Evaluator) generator(body func(child *Evaluator) object.Object) *object.Generator {
+	child := e.fork()
+
+	return object.NewGenerator(child.ctx, func(yield object.YieldFunc) object.Object {
+		child.yield = yield
+		return child.runTask(func() object.Object {
+			return body(child)
+		})
+	})
+}
+
+// yieldValue hands the value over to the consumer of the generator being
+// evaluated and waits until the next value is requested.
+func (e *Evaluator) yieldValue(value object.Object) object.Object {
+	if e.yield == nil {
+		// The parser only allows `yield` in functions, which become
+		// generators, so this can't happen.
+		return newError(diagnostic.YieldOutsideGenerator,
+			"yield outside of a generator")
+	}
+	// The consumer needs to run to take the value.
+	e.release()
+	resumed := e.yield(value)
+	if err := e.acquire(); err != nil {
+		return err
+	}
+	if !resumed {
+		return newError(diagnostic.Cancelled,
+			"evaluation cancelled: %s", e.context().Err())
+	}
+	return NULL
+}
+
+// next returns the next value of the iterator, or NULL once it's exhausted.
+func (e *Evaluator) next(it object.Iterator) object.Object {
+	value, ok := e.pull(it)
+	if !ok {
+		return NULL
+	}
+	return value
+}
+
+// pull returns the next value of the iterator, or the error that stopped it.
+// ok is false once it's exhausted.
+func (e *Evaluator) pull(it object.Iterator) (value object.Object, ok bool) {
+	// The generator needs to run to produce the value.
+	e.release()
+	value, ok = it.Next(e.context())
+	if err := e.acquire(); err != nil {
+		return err, true
+	}
+	return value, ok
+}
+
+// mapGenerator returns a generator producing the results of calling fn with
+// every value of it, which are only computed as they're consumed.
+func (e *Evaluator) mapGenerator(it object.Iterator, fn object.Object) *object.Generator {
+	return e.generator(func(child *Evaluator) object.Object {
+		for {
+			value, ok := child.pull(it)
+			if !ok || isError(value) {
+				return value
+			}
+			result := child.applyFunction(fn, []object.Object{value})
+			if isError(result) {
+				return result
+			}
+			if err := child.yieldValue(result); isError(err) {
+				return err
+			}
+		}
+	})
+}
+
+// filterGenerator returns a generator producing the values of it that fn
+// returns a truthy value for.
+func (e *Evaluator) filterGenerator(it object.Iterator, fn object.Object) *object.Generator {
+	return e.generator(func(child *Evaluator) object.Object {
+		for {
+			value, ok := child.pull(it)
+			if !ok || isError(value) {
+				return value
+			}
+			result := child.applyFunction(fn, []object.Object{value})
+			if isError(result) {
+				return result
+			}
+			if !isTruthy(result) {
+				continue
+			}
+			if err := child.yieldValue(value); isError(err) {
+				return err
+			}
+		}
+	})
+}
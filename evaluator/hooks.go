@@ -0,0 +1,87 @@
+package evaluator
+
+import (
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/object"
+)
+
+// Hooks are functions the Evaluator calls as it evaluates a program, so that
+// tools and embedders can observe what the program does: trace it, profile it
+// or audit it. Any of the hooks may be nil. Hooks must not modify the nodes or
+// objects they're passed. Tasks started by `spawn` call the same hooks, so
+// they may be called concurrently.
+type Hooks struct {
+	// EnterNode is called before a node is evaluated.
+	EnterNode func(node ast.Node)
+	// LeaveNode is called after a node was evaluated, with the result.
+	LeaveNode func(node ast.Node, result object.Object)
+
+	// Call is called before a function or builtin is called. name is the
+	// identifier the function was called by, or "" if it was called by an
+	// expression such as a function literal.
+	Call func(name string, fn object.Object, args []object.Object)
+
+	// SetGlobal is called when a `let` statement binds a name in the
+	// environment the program is evaluated in.
+	SetGlobal func(name string, value object.Object)
+
+	// Error is called when the evaluation of a program ends with an error.
+	Error func(err *object.Error)
+}
+
+// Depth returns the number of calls to Hou functions that are in progress. It
+// lets hooks tell how deep in the call stack a node is evaluated.
+func (e *Evaluator) Depth() int {
+	return e.depth
+}
+
+// tracesNodes reports whether eval must call the node hooks.
+func (e *Evaluator) tracesNodes() bool {
+	return e.Hooks != nil && (e.Hooks.EnterNode != nil || e.Hooks.LeaveNode != nil)
+}
+
+// evalTraced evaluates the node between calls to the node hooks.
+func (e *Evaluator) evalTraced(node ast.Node, env *object.Environment) object.Object {
+	if e.Hooks.EnterNode != nil {
+		e.Hooks.EnterNode(node)
+	}
+	result := e.evalNode(node, env)
+	if e.Hooks.LeaveNode != nil {
+		e.Hooks.LeaveNode(node, result)
+	}
+	return result
+}
+
+func (e *Evaluator) hookCall(
+	function ast.Expression,
+	fn object.Object,
+	args []object.Object,
+) {
+	if e.Hooks == nil || e.Hooks.Call == nil {
+		return
+	}
+	name := ""
+	if ident, ok := function.(*ast.Identifier); ok {
+		name = ident.Value
+	}
+	e.Hooks.Call(name, fn, args)
+}
+
+func (e *Evaluator) hookSet(
+	name string,
+	value object.Object,
+	env *object.Environment,
+) {
+	if e.Hooks != nil && e.Hooks.SetGlobal != nil && env == e.globals {
+		e.Hooks.SetGlobal(name, value)
+	}
+}
+
+func (e *Evaluator) hookError(result object.Object) {
+	if e.Hooks == nil || e.Hooks.Error == nil {
+		return
+	}
+	if err, ok := result.(*object.Error); ok {
+		e.Hooks.Error(err)
+	}
+}
@@ -0,0 +1,163 @@
+package evaluator
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/object"
+)
+
+// Stack is a snapshot of the calls a program, or one of the tasks it started,
+// is running.
+type Stack struct {
+	// Task is 0 for the program and numbers the tasks, generators and timers
+	// it started from 1 in the order they started.
+	Task int
+	// Frames are the calls of Hou functions in progress, innermost first.
+	// The position of a frame is the position of the call.
+	Frames []object.Frame
+	// Envs are the environments of the functions being called, innermost
+	// first. Calls by the host or by builtins have an environment but no
+	// frame, so there may be more environments than frames.
+	Envs []*object.Environment
+}
+
+// inspector keeps track of the Evaluators running a program and its tasks,
+// and guards their calls in progress, so that other goroutines can look at
+// them. It's shared by an Evaluator and the Evaluators of its tasks.
+type inspector struct {
+	mu   sync.Mutex
+	live map[*Evaluator]int
+	next int
+}
+
+// EnableInspection makes the Evaluator, and the Evaluators of the tasks it
+// starts, keep track of their calls in progress for Stacks. It has to be
+// called before evaluating anything and makes calls slightly slower.
+func (e *Evaluator) EnableInspection() {
+	e.inspector = &inspector{live: map[*Evaluator]int{}}
+}
+
+// Stacks returns the calls in progress of the program the Evaluator is
+// evaluating and of its running tasks, ordered by task. Unlike the other
+// methods, it may be called from any goroutine while a program is running.
+// It returns nothing unless inspection is enabled.
+func (e *Evaluator) Stacks() []Stack {
+	in := e.inspector
+	if in == nil {
+		return nil
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	stacks := make([]Stack, 0, len(in.live))
+	for running, task := range in.live {
+		stack := Stack{Task: task}
+		for i := len(running.calls) - 1; i >= 0; i-- {
+			call := running.calls[i]
+			stack.Frames = append(stack.Frames, object.Frame{
+				Function: call.name(),
+				Position: callee(call.node).Pos(),
+			})
+		}
+		for i := len(running.envs) - 1; i >= 0; i-- {
+			stack.Envs = append(stack.Envs, running.envs[i])
+		}
+		stacks = append(stacks, stack)
+	}
+	sort.Slice(stacks, func(i, j int) bool { return stacks[i].Task < stacks[j].Task })
+	return stacks
+}
+
+// enter registers the Evaluator as running the program, or a task if it's
+// the Evaluator of one.
+func (e *Evaluator) enter(task bool) {
+	in := e.inspector
+	if in == nil {
+		return
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	e.calls, e.envs = nil, nil
+	id := 0
+	if task {
+		in.next++
+		id = in.next
+	}
+	in.live[e] = id
+}
+
+// leave unregisters the Evaluator once it's done running.
+func (e *Evaluator) leave() {
+	in := e.inspector
+	if in == nil {
+		return
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	delete(in.live, e)
+}
+
+// call is a call of a Hou function in progress.
+type call struct {
+	node *ast.CallExpression
+	fn   *object.Function
+}
+
+// name returns the name of the function called, or else the name it was
+// called by, or else "fn".
+func (c call) name() string {
+	if c.fn.Name != "" {
+		return c.fn.Name
+	}
+	if ident, ok := c.node.Function.(*ast.Identifier); ok {
+		return ident.Value
+	}
+	return "fn"
+}
+
+// pushCall records the call of fn by node as in progress.
+func (e *Evaluator) pushCall(node *ast.CallExpression, fn *object.Function) {
+	if e.inspector == nil {
+		e.calls = append(e.calls, call{node, fn})
+		return
+	}
+	e.inspector.mu.Lock()
+	e.calls = append(e.calls, call{node, fn})
+	e.inspector.mu.Unlock()
+}
+
+// popCall records that the innermost call returned.
+func (e *Evaluator) popCall() {
+	if e.inspector == nil {
+		e.calls = e.calls[:len(e.calls)-1]
+		return
+	}
+	e.inspector.mu.Lock()
+	e.calls = e.calls[:len(e.calls)-1]
+	e.inspector.mu.Unlock()
+}
+
+// pushEnv records the environment of a function being called, if inspection
+// is enabled.
+func (e *Evaluator) pushEnv(env *object.Environment) {
+	if e.inspector == nil {
+		return
+	}
+	e.inspector.mu.Lock()
+	e.envs = append(e.envs, env)
+	e.inspector.mu.Unlock()
+}
+
+// popEnv records that the innermost function returned.
+func (e *Evaluator) popEnv() {
+	if e.inspector == nil {
+		return
+	}
+	e.inspector.mu.Lock()
+	e.envs = e.envs[:len(e.envs)-1]
+	e.inspector.mu.Unlock()
+}
@@ -0,0 +1,195 @@
+package evaluator
+
+// Integer arithmetic allocates an *object.Integer for every result, which is
+// most of the time spent on arithmetic-heavy programs. But the result of an
+// infix expression that's the operand of another one is consumed right away,
+// e.g. the a * b in a * b + c, so it doesn't need to be an object. The
+// evaluator keeps such results unboxed, as plain int64s, and only allocates
+// an object for the result of the whole chain of operations, when a consumer
+// that isn't an infix expression needs it.
+
+import (
+	"math"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// evalInfix evaluates the infix expression. If the result is an integer, it's
+// returned unboxed as value, with a nil object. Otherwise, the object is the
+// result.
+func (e *Evaluator) evalInfix(
+	node *ast.InfixExpression,
+	env *object.Environment,
+) (value int64, result object.Object) {
+	if node.Operator == "??" {
+		return 0, e.evalCoalesce(node, env)
+	}
+
+	l, left := e.evalOperand(node.Left, env)
+	if isError(left) {
+		return 0, left
+	}
+
+	r, right := e.evalOperand(node.Right, env)
+	if isError(right) {
+		return 0, right
+	}
+
+	if left == nil && right == nil {
+		return evalUnboxedInfixExpression(node.Operator, l, r)
+	}
+
+	// At least one of the operands isn't an integer, so box the other one
+	// and take the general path.
+	if left == nil {
+		left = object.NewInteger(l)
+	}
+	if right == nil {
+		right = object.NewInteger(r)
+	}
+	if err := e.checkEquality(node.Operator, left, right); err != nil {
+		return 0, err
+	}
+	return 0, e.allocated(evalInfixExpression(node.Operator, left, right))
+}
+
+// evalOperand evaluates the operand of an infix expression like eval does, but
+// returns integers unboxed as value, with a nil object.
+func (e *Evaluator) evalOperand(
+	node ast.Expression,
+	env *object.Environment,
+) (value int64, result object.Object) {
+	// Hooks see the result of every node, so they need objects.
+	infix, ok := node.(*ast.InfixExpression)
+	if !ok || e.tracesNodes() {
+		result = e.eval(node, env)
+		if integer, ok := result.(*object.Integer); ok {
+			return integer.Value, nil
+		}
+		return 0, result
+	}
+
+	// Account for the node and locate its errors like eval.
+	if err := e.step(); err != nil {
+		return 0, e.locate(err, node)
+	}
+	value, result = e.evalInfix(infix, env)
+	if err, ok := result.(*object.Error); ok {
+		e.locate(err, node)
+	}
+	return value, result
+}
+
+// evalUnboxedInfixExpression applies the operator to the integers. Arithmetic
+// results are returned unboxed as value, with a nil object. Hou integers are
+// 64 bits wide, and arithmetic that overflows is an error rather than
+// wrapping around.
+func evalUnboxedInfixExpression(
+	operator string,
+	left, right int64,
+) (value int64, result object.Object) {
+	if overflows(operator, left, right) {
+		return 0, newError(diagnostic.ArithmeticOverflow,
+			"integer overflow: %d %s %d", left, operator, right)
+	}
+
+	switch operator {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/", "%":
+		if right == 0 {
+			return 0, newError(diagnostic.DivisionByZero,
+				"division by zero: %d %s 0", left, operator)
+		}
+		if operator == "/" {
+			return left / right, nil
+		}
+		return left % right, nil
+	case "**":
+		if left == 0 && right < 0 {
+			return 0, newError(diagnostic.DivisionByZero,
+				"division by zero: 0 ** %d", right)
+		}
+		value, _ := power(left, right)
+		return value, nil
+	case "<":
+		return 0, nativeBoolToBooleanObject(left < right)
+	case ">":
+		return 0, nativeBoolToBooleanObject(left > right)
+	case "<=":
+		return 0, nativeBoolToBooleanObject(left <= right)
+	case ">=":
+		return 0, nativeBoolToBooleanObject(left >= right)
+	case "==":
+		return 0, nativeBoolToBooleanObject(left == right)
+	case "!=":
+		return 0, nativeBoolToBooleanObject(left != right)
+	default:
+		return 0, newError(diagnostic.UnknownOperator, "unknown operator: %s %s %s",
+			object.INTEGER_OBJ, operator, object.INTEGER_OBJ)
+	}
+}
+
+// overflows reports whether applying the infix operator to the integers l and
+// r overflows.
+func overflows(operator string, l, r int64) bool {
+	switch operator {
+	case "+":
+		sum := l + r
+		return (l >= 0) == (r >= 0) && (sum >= 0) != (l >= 0)
+	case "-":
+		diff := l - r
+		return (l >= 0) != (r >= 0) && (diff >= 0) != (l >= 0)
+	case "*":
+		return multiplyOverflows(l, r)
+	case "/", "%":
+		return l == math.MinInt64 && r == -1
+	case "**":
+		_, overflows := power(l, r)
+		return overflows
+	}
+	return false
+}
+
+// power returns base raised to the power of exp, and whether that overflowed
+// and wrapped around. Like integer division, negative powers are truncated
+// towards zero, so 2 ** -1 is 0 but (-1) ** -1 is -1.
+func power(base, exp int64) (value int64, overflows bool) {
+	if exp < 0 {
+		if base == -1 && exp%2 == 0 {
+			return 1, false
+		}
+		// base ** -n is 1 / base ** n, which is 0 unless base is 1 or -1.
+		// It's a division by zero if base is 0, which callers check for.
+		if base == 0 {
+			return 0, false
+		}
+		return 1 / base, false
+	}
+
+	// Exponentiation by squaring.
+	value = 1
+	for exp > 0 {
+		if exp&1 == 1 {
+			overflows = overflows || multiplyOverflows(value, base)
+			value *= base
+		}
+		exp >>= 1
+		if exp > 0 {
+			overflows = overflows || multiplyOverflows(base, base)
+			base *= base
+		}
+	}
+	return value, overflows
+}
+
+// multiplyOverflows reports whether l * r overflows.
+func multiplyOverflows(l, r int64) bool {
+	return l != 0 && ((l*r)/l != r || l == -1 && r == math.MinInt64)
+}
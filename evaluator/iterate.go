@@ -0,0 +1,114 @@
+package evaluator
+
+import (
+	"unicode/utf8"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// Iterator iterates over the elements of an array, the pairs of a hash, the
+// characters of a string or the values of a generator, for the iterations of
+// a for loop. It's exported so that the VM runs for loops with the same
+// semantics.
+//
+// The iterator sees the array or hash as it was when the loop started: a loop
+// doesn't iterate over the pairs its body adds to the hash.
+type Iterator struct {
+	// kind is the type of the iterable.
+	kind      object.ObjectType
+	elements  []object.Object
+	pairs     []object.HashPair
+	str       string
+	generator object.Iterator
+
+	// e is the Evaluator that resumes the generator, and err the error
+	// that stopped it.
+	e   *Evaluator
+	err *object.Error
+
+	// index is the number of iterations so far, and offset the byte offset
+	// of the next character of a string.
+	index  int
+	offset int
+}
+
+// NewIterator returns an iterator over the iterable, or an error if it isn't
+// an array, a hash, a string or a generator.
+func (e *Evaluator) NewIterator(iterable object.Object) (*Iterator, *object.Error) {
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		return &Iterator{kind: object.ARRAY_OBJ, elements: iterable.Elements}, nil
+	case *object.Hash:
+		return &Iterator{kind: object.HASH_OBJ, pairs: iterable.Pairs()}, nil
+	case *object.String:
+		return &Iterator{kind: object.STRING_OBJ, str: iterable.Value}, nil
+	case object.Iterator:
+		return &Iterator{kind: object.GENERATOR_OBJ, generator: iterable, e: e}, nil
+	default:
+		return nil, newError(diagnostic.NotIterable,
+			"cannot iterate over %s", iterable.Type())
+	}
+}
+
+// Next returns what the next iteration binds to the names of a loop with two
+// of them: the index and the element of an array, the key and the value of a
+// pair of a hash, or the index and the character of a string or the value of
+// a generator. ok is false if there are no iterations left, or if the
+// generator failed, see Err.
+func (it *Iterator) Next() (key, value object.Object, ok bool) {
+	switch it.kind {
+	case object.GENERATOR_OBJ:
+		value, ok := it.e.pull(it.generator)
+		if !ok {
+			return nil, nil, false
+		}
+		if err, failed := value.(*object.Error); failed {
+			it.err = err
+			return nil, nil, false
+		}
+		key = object.NewInteger(int64(it.index))
+		it.index++
+		return key, value, true
+	case object.STRING_OBJ:
+		if it.offset >= len(it.str) {
+			return nil, nil, false
+		}
+		// Invalid UTF-8 is iterated over byte by byte.
+		_, size := utf8.DecodeRuneInString(it.str[it.offset:])
+		key = object.NewInteger(int64(it.index))
+		value = &object.String{Value: it.str[it.offset : it.offset+size]}
+		it.offset += size
+	case object.HASH_OBJ:
+		if it.index >= len(it.pairs) {
+			return nil, nil, false
+		}
+		pair := it.pairs[it.index]
+		key, value = pair.Key, pair.Value
+	default:
+		if it.index >= len(it.elements) {
+			return nil, nil, false
+		}
+		key = object.NewInteger(int64(it.index))
+		value = it.elements[it.index]
+	}
+	it.index++
+	return key, value, true
+}
+
+// NextElement returns what the next iteration binds to the name of a loop with
+// one: the element of an array, the key of a pair of a hash, the character of
+// a string or the value of a generator. ok is false if there are no
+// iterations left.
+func (it *Iterator) NextElement() (element object.Object, ok bool) {
+	key, value, ok := it.Next()
+	if it.kind == object.HASH_OBJ {
+		return key, ok
+	}
+	return value, ok
+}
+
+// Err returns the error that stopped the generator iterated over, if any.
+func (it *Iterator) Err() *object.Error {
+	return it.err
+}
@@ -0,0 +1,118 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/object"
+)
+
+// Profile counts what a program does as it's evaluated, to find its hot
+// spots: the nodes evaluated by type, the calls by function, and the heap
+// allocations of the run. Its Hooks count the nodes and the calls, and Start
+// and Stop measure the allocations.
+type Profile struct {
+	mu sync.Mutex
+
+	// Nodes is the number of nodes evaluated by type, e.g. "InfixExpression".
+	Nodes map[string]int
+	// Calls is the number of calls by function, named like the frames of
+	// backtraces, see object.Frame.
+	Calls map[string]int
+
+	// Allocations and AllocatedBytes are the number and the size of the
+	// heap allocations between Start and Stop, the objects of the program
+	// and what the interpreter needs to evaluate it.
+	Allocations    uint64
+	AllocatedBytes uint64
+
+	start runtime.MemStats
+}
+
+// NewProfile returns an empty profile.
+func NewProfile() *Profile {
+	return &Profile{
+		Nodes: map[string]int{},
+		Calls: map[string]int{},
+	}
+}
+
+// Hooks returns the hooks that count the nodes and the calls of the programs
+// an Evaluator evaluates, including the tasks they spawn.
+func (p *Profile) Hooks() *Hooks {
+	return &Hooks{
+		EnterNode: func(node ast.Node) {
+			kind := reflect.TypeOf(node).Elem().Name()
+			p.mu.Lock()
+			p.Nodes[kind]++
+			p.mu.Unlock()
+		},
+		Call: func(name string, fn object.Object, args []object.Object) {
+			if f, ok := fn.(*object.Function); ok && f.Name != "" {
+				name = f.Name
+			} else if name == "" {
+				name = "fn"
+			}
+			p.mu.Lock()
+			p.Calls[name]++
+			p.mu.Unlock()
+		},
+	}
+}
+
+// Start starts measuring the heap allocations.
+func (p *Profile) Start() {
+	runtime.ReadMemStats(&p.start)
+}
+
+// Stop stops measuring the heap allocations, and records them.
+func (p *Profile) Stop() {
+	var end runtime.MemStats
+	runtime.ReadMemStats(&end)
+	p.Allocations = end.Mallocs - p.start.Mallocs
+	p.AllocatedBytes = end.TotalAlloc - p.start.TotalAlloc
+}
+
+// Write writes a report of the profile to out: the totals, and the counts of
+// the nodes and the calls by decreasing count, e.g.
+//
+//	nodes: 117056
+//	   29263  Identifier
+//	...
+//	calls: 8361
+//	    8361  fib
+//	allocations: 16758 (1207144 bytes)
+func (p *Profile) Write(out io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	writeCounts(out, "nodes", p.Nodes)
+	writeCounts(out, "calls", p.Calls)
+	fmt.Fprintf(out, "allocations: %d (%d bytes)\n", p.Allocations, p.AllocatedBytes)
+}
+
+// writeCounts writes the total of the counts under the title, then the counts
+// by decreasing count and name.
+func writeCounts(out io.Writer, title string, counts map[string]int) {
+	names := make([]string, 0, len(counts))
+	total := 0
+	for name, n := range counts {
+		names = append(names, name)
+		total += n
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	fmt.Fprintf(out, "%s: %d\n", title, total)
+	for _, name := range names {
+		fmt.Fprintf(out, "%8d  %s\n", counts[name], name)
+	}
+}
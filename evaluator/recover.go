@@ -0,0 +1,43 @@
+package evaluator
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// errorPanic is implemented by the values code working with Hou objects
+// panics with on purpose to stop at an error object, e.g. native.Abort. Such
+// panics are turned back into their error objects rather than into internal
+// errors.
+type errorPanic interface {
+	ErrorObject() *object.Error
+}
+
+// recovered returns the error object for the value a panic was recovered
+// with. Anything but an errorPanic is a bug in the interpreter or in a
+// builtin, which becomes an internal interpreter error with the stack of the
+// goroutine that panicked.
+func recovered(r interface{}) *object.Error {
+	if p, ok := r.(errorPanic); ok {
+		return p.ErrorObject()
+	}
+	return &object.Error{
+		Code:    diagnostic.InternalError,
+		Message: fmt.Sprintf("internal interpreter error: %v", r),
+		Stack:   string(debug.Stack()),
+	}
+}
+
+// callBuiltin calls the builtin with the arguments. A panic becomes an error
+// object, which gets the position of the call like any error of a builtin.
+func callBuiltin(fn *object.Builtin, args []object.Object) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = recovered(r)
+		}
+	}()
+	return fn.Fn(args...)
+}
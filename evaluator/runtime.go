@@ -0,0 +1,87 @@
+package evaluator
+
+import (
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/object"
+)
+
+// The functions in this file expose the semantics of Hou's operators, calls
+// and builtins to code that works with Hou objects without walking an AST,
+// e.g. programs translated to Go by the transpiler. They are thin wrappers so
+// that there is exactly one implementation of what `1 + 2` or `a[0]` means.
+
+// EvalPrefix applies the prefix operator to the operand.
+func EvalPrefix(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+// EvalInfix applies the infix operator to the left and right operands.
+func EvalInfix(operator string, left, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+// EvalInfixWith applies the infix operator like EvalInfix, with the semantics
+// the features of a language version give it, e.g. lang.StrictEquality.
+func EvalInfixWith(
+	features lang.FeatureSet,
+	operator string,
+	left, right object.Object,
+) object.Object {
+	if err := checkEquality(features, operator, left, right); err != nil {
+		return err
+	}
+	return evalInfixExpression(operator, left, right)
+}
+
+// CheckArity returns the error for calling a function that has params
+// parameters, required of them without a default value, with args, if any,
+// with the semantics the features of a language version give it, e.g.
+// lang.StrictArity.
+func CheckArity(
+	features lang.FeatureSet,
+	args []object.Object,
+	required, params int,
+) *object.Error {
+	return checkArity(features, args, required, params)
+}
+
+// Overflows reports whether applying the infix operator to the integers left
+// and right overflows 64 bits, which is an error.
+func Overflows(operator string, left, right int64) bool {
+	return overflows(operator, left, right)
+}
+
+// EvalIndex applies the index operator to left, e.g: left[index].
+func EvalIndex(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+// EvalMember applies the member operator to obj, e.g: obj.name.
+func EvalMember(obj object.Object, name string) object.Object {
+	return evalMemberExpression(obj, name)
+}
+
+// SetIndex sets the element of left at index to value, e.g: left[index] =
+// value, and returns value.
+func SetIndex(left, index, value object.Object) object.Object {
+	return evalSetIndex(left, index, value)
+}
+
+// EvalMatch reports whether the subject of a match expression matches the value
+// of a case, as TRUE or FALSE.
+func EvalMatch(subject, value object.Object) object.Object {
+	return evalMatch(subject, value)
+}
+
+// IsTruthy reports whether obj counts as true in a conditional.
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+// ApplyFunction calls the function or builtin fn with the arguments args.
+func (e *Evaluator) ApplyFunction(
+	fn object.Object,
+	args []object.Object,
+) object.Object {
+	return e.applyFunction(fn, args)
+}
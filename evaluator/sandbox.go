@@ -0,0 +1,199 @@
+package evaluator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// Sandbox restricts what the programs run by an Evaluator may do, so that
+// untrusted scripts can be run inside a host application. The zero value
+// allows builtins but denies any access to files and signals and sets no
+// limits; a nil *Sandbox allows everything.
+//
+// Builtins that access files must ask the sandbox first with CheckPath, the
+// ones that handle signals with CheckSignals.
+type Sandbox struct {
+	// AllowBuiltins, if not nil, lists the only builtins programs may call.
+	AllowBuiltins []string
+	// DenyBuiltins lists builtins programs may not call.
+	DenyBuiltins []string
+
+	// FileRoots lists the directories programs may access files in,
+	// including their subdirectories. No files can be accessed if it's empty.
+	FileRoots []string
+	// Signals allows programs to handle the signals of the process with
+	// `onSignal`, which the host then doesn't receive anymore.
+	Signals bool
+
+	// MaxSteps is the maximum number of AST nodes a single evaluation may
+	// evaluate, its tasks included. Zero means no limit.
+	MaxSteps int64
+	// MaxMemory is the maximum number of bytes a single evaluation may
+	// allocate for strings, arrays and hashes, its tasks included. It's an
+	// estimate of what the program allocates, not of what it keeps alive.
+	// Zero means no limit.
+	MaxMemory int64
+	// MaxTasks is the maximum number of tasks started by `spawn`, `async`,
+	// `pmap` and timers that may be running at the same time. Starting one
+	// more is an error. Zero means no limit.
+	MaxTasks int64
+	// Timeout is the maximum duration of a single evaluation. Zero means no
+	// limit.
+	Timeout time.Duration
+}
+
+// AllowsBuiltin reports whether programs may call the builtin called name.
+func (s *Sandbox) AllowsBuiltin(name string) bool {
+	if s == nil {
+		return true
+	}
+	if s.AllowBuiltins != nil && !contains(s.AllowBuiltins, name) {
+		return false
+	}
+	return !contains(s.DenyBuiltins, name)
+}
+
+// CheckPath returns an error unless path is inside one of the file roots.
+// Symbolic links are resolved first, in the roots as well as in the path, so
+// that a link inside a root can't give access to a file outside of it.
+func (s *Sandbox) CheckPath(path string) error {
+	if s == nil {
+		return nil
+	}
+
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return fmt.Errorf("sandbox: %s", err)
+	}
+	for _, root := range s.FileRoots {
+		root, err := resolvePath(root)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, resolved)
+		if err != nil {
+			continue
+		}
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("sandbox: access to %s is not allowed", path)
+}
+
+// resolvePath returns the absolute path of the file at path, with symbolic
+// links resolved. The file doesn't have to exist, e.g. if it's about to be
+// created, in which case its closest existing parent is resolved.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err == nil {
+		return resolved, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	// A dangling link would still be followed to create the file it points
+	// to.
+	if target, err := os.Readlink(abs); err == nil {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(abs), target)
+		}
+		return resolvePath(target)
+	}
+
+	parent := filepath.Dir(abs)
+	if parent == abs {
+		return abs, nil
+	}
+	dir, err := resolvePath(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(abs)), nil
+}
+
+// CheckSignals returns an error unless handling signals is allowed.
+func (s *Sandbox) CheckSignals() error {
+	if s == nil || s.Signals {
+		return nil
+	}
+	return fmt.Errorf("sandbox: handling signals is not allowed")
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Approximate sizes in bytes of the values accounted against MaxMemory.
+const (
+	objectSize    = 16 // an interface value referencing an object
+	hashPairSize  = 64 // a hash key, a pair and the map overhead
+	stringMinSize = 16
+)
+
+// allocated accounts for the memory of obj, which the program just created,
+// and returns obj or an error object if the memory limit was exceeded.
+func (e *Evaluator) allocated(obj object.Object) object.Object {
+	if e.Sandbox == nil || e.Sandbox.MaxMemory <= 0 {
+		return obj
+	}
+
+	var size int64
+	switch obj := obj.(type) {
+	case *object.String:
+		size = stringMinSize + int64(len(obj.Value))
+	case *object.Array:
+		size = objectSize * int64(1+len(obj.Elements))
+	case *object.Hash:
+		size = objectSize + hashPairSize*int64(obj.Len())
+	case *object.Builder:
+		size = stringMinSize + int64(obj.Len())
+	default:
+		return obj
+	}
+
+	if err := e.allocatedBytes(size); err != nil {
+		return err
+	}
+	return obj
+}
+
+// allocatedArray accounts for the array made from another one, which had to
+// allocate the given number of elements, and returns it or an error.
+func (e *Evaluator) allocatedArray(arr *object.Array, allocated int) object.Object {
+	if err := e.allocatedBytes(objectSize * int64(1+allocated)); err != nil {
+		return err
+	}
+	return arr
+}
+
+// allocatedBytes accounts for size bytes the program just allocated, e.g. by
+// growing an object, and returns an error if the memory limit was exceeded.
+func (e *Evaluator) allocatedBytes(size int64) *object.Error {
+	if e.Sandbox == nil || e.Sandbox.MaxMemory <= 0 {
+		return nil
+	}
+
+	if atomic.AddInt64(&e.usage.memory, size) > e.Sandbox.MaxMemory {
+		return newError(diagnostic.MemoryLimitExceeded,
+			"memory limit exceeded: %d bytes", e.Sandbox.MaxMemory)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package evaluator
+
+import (
+	"runtime"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// defaultTaskBudget is the number of steps a task may evaluate before it has
+// to let other tasks run, if TaskBudget isn't set.
+const defaultTaskBudget = 10000
+
+// scheduler limits how many tasks evaluate at the same time, see
+// Evaluator.Parallelism. A task has to hold a slot to evaluate nodes. It gives
+// the slot up when it's done, when it blocks, when it calls `yieldTask` and
+// after evaluating its budget of steps, and then queues for a slot again.
+// Queued tasks get slots in the order they asked for them, so one busy task
+// can't starve the others.
+type scheduler struct {
+	slots  chan struct{}
+	budget int64
+}
+
+func newScheduler(parallelism int, budget int64) *scheduler {
+	if budget <= 0 {
+		budget = defaultTaskBudget
+	}
+	return &scheduler{slots: make(chan struct{}, parallelism), budget: budget}
+}
+
+// acquire waits for a slot for the Evaluator, unless it holds one already.
+func (e *Evaluator) acquire() *object.Error {
+	if e.sched == nil || e.holding {
+		return nil
+	}
+
+	ctx := e.context()
+	select {
+	case e.sched.slots <- struct{}{}:
+		e.holding = true
+		e.slice = 0
+		return nil
+	case <-ctx.Done():
+		return newError(diagnostic.Cancelled, "evaluation cancelled: %s", ctx.Err())
+	}
+}
+
+// release gives up the slot of the Evaluator, if it holds one.
+func (e *Evaluator) release() {
+	if e.sched == nil || !e.holding {
+		return
+	}
+	<-e.sched.slots
+	e.holding = false
+}
+
+// tick accounts for one step of the task and lets other tasks run once it
+// used up its budget.
+func (e *Evaluator) tick() *object.Error {
+	e.slice++
+	if e.slice < e.sched.budget {
+		return nil
+	}
+	return e.yieldTask()
+}
+
+// yieldTask lets other tasks run before the Evaluator continues.
+func (e *Evaluator) yieldTask() *object.Error {
+	e.release()
+	// Let the tasks that are ready to run queue for a slot, or just run if
+	// there's no limit.
+	runtime.Gosched()
+	return e.acquire()
+}
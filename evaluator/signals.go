@@ -0,0 +1,205 @@
+package evaluator
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/cedrickchee/hou/builtinerr"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// signalNames maps the names programs use in `onSignal` to the signals.
+var signalNames = map[string]os.Signal{
+	"INT":  os.Interrupt,
+	"TERM": syscall.SIGTERM,
+	"HUP":  syscall.SIGHUP,
+	"QUIT": syscall.SIGQUIT,
+}
+
+// signals holds the signal handlers a program installed with `onSignal`.
+//
+// Signals aren't handled the moment they arrive: that could interrupt the
+// program in the middle of anything. Instead, they're queued and the
+// handlers run between two evaluation steps, or while the program is blocked
+// waiting for a task or a channel.
+type signals struct {
+	mu       sync.Mutex
+	handlers map[string]object.Object
+	queue    []string
+
+	// arrived is set when the queue isn't empty, so that checking it on
+	// every step is cheap.
+	arrived int32
+	// wake interrupts the program while it's blocked.
+	wake chan struct{}
+
+	incoming chan os.Signal
+	done     chan struct{}
+}
+
+// onSignal installs fn as the handler of the signal called name, replacing
+// any previous handler. Handlers stay installed until the evaluation ends.
+// Signals are process-wide, so a sandboxed program may only handle them if the
+// sandbox allows it.
+func (e *Evaluator) onSignal(name string, fn object.Object) object.Object {
+	if err := e.Sandbox.CheckSignals(); err != nil {
+		return builtinerr.Wrap(diagnostic.AccessDenied, "onSignal", err)
+	}
+
+	sig, ok := signalNames[name]
+	if !ok {
+		names := make([]string, 0, len(signalNames))
+		for name := range signalNames {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return newError(diagnostic.InvalidArgument,
+			"unknown signal: %q, want one of %v", name, names)
+	}
+
+	if e.signals == nil {
+		s := &signals{
+			handlers: map[string]object.Object{},
+			wake:     make(chan struct{}, 1),
+			incoming: make(chan os.Signal, 1),
+			done:     make(chan struct{}),
+		}
+		go s.receive()
+		e.signals = s
+	}
+
+	s := e.signals
+	s.mu.Lock()
+	s.handlers[name] = fn
+	s.mu.Unlock()
+	signal.Notify(s.incoming, sig)
+
+	return NULL
+}
+
+// receive queues the incoming signals until the signals are stopped.
+func (s *signals) receive() {
+	for {
+		select {
+		case sig := <-s.incoming:
+			s.mu.Lock()
+			for name, named := range signalNames {
+				if named == sig {
+					s.queue = append(s.queue, name)
+				}
+			}
+			s.mu.Unlock()
+			atomic.StoreInt32(&s.arrived, 1)
+
+			select {
+			case s.wake <- struct{}{}:
+			default:
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// stopSignals uninstalls the signal handlers, so the signals get their
+// default behavior back.
+func (e *Evaluator) stopSignals() {
+	if e.signals == nil {
+		return
+	}
+	signal.Stop(e.signals.incoming)
+	close(e.signals.done)
+	e.signals = nil
+}
+
+// handleSignals runs the handlers of the signals that arrived, if any. It
+// returns the error object a handler resulted in.
+func (e *Evaluator) handleSignals() *object.Error {
+	s := e.signals
+	if s == nil || atomic.LoadInt32(&s.arrived) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	queue := s.queue
+	s.queue = nil
+	atomic.StoreInt32(&s.arrived, 0)
+	s.mu.Unlock()
+
+	for _, name := range queue {
+		s.mu.Lock()
+		handler := s.handlers[name]
+		s.mu.Unlock()
+
+		result := e.applyFunction(handler, []object.Object{&object.String{Value: name}})
+		if err, ok := result.(*object.Error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// objectError is the error block returns when it failed with an error
+// object, e.g. when a signal handler failed.
+type objectError struct {
+	err *object.Error
+}
+
+func (e *objectError) Error() string { return e.err.Message }
+
+// block calls op, which blocks until it's done or ctx is done. If a signal
+// arrives in the meantime, op is interrupted, the signal handlers run and op
+// is called again. Other tasks may run while op blocks.
+func (e *Evaluator) block(op func(ctx context.Context) error) (err error) {
+	e.release()
+	defer func() {
+		if aerr := e.acquire(); aerr != nil && err == nil {
+			err = &objectError{err: aerr}
+		}
+	}()
+
+	for {
+		if e.signals == nil {
+			return op(e.context())
+		}
+
+		ctx, cancel := context.WithCancel(e.context())
+		go func(wake <-chan struct{}) {
+			select {
+			case <-wake:
+				cancel()
+			case <-ctx.Done():
+			}
+		}(e.signals.wake)
+
+		err := op(ctx)
+		interrupted := err != nil && err == ctx.Err()
+		cancel()
+		if !interrupted || e.context().Err() != nil {
+			return err
+		}
+
+		if err := e.acquire(); err != nil {
+			return &objectError{err: err}
+		}
+		herr := e.handleSignals()
+		e.release()
+		if herr != nil {
+			return &objectError{err: herr}
+		}
+	}
+}
+
+// blockError turns an error returned by block into an error object.
+func blockError(err error) *object.Error {
+	if err, ok := err.(*objectError); ok {
+		return err.err
+	}
+	return newError(diagnostic.Cancelled, "evaluation cancelled: %s", err)
+}
@@ -0,0 +1,420 @@
+package evaluator
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/object"
+)
+
+// DefaultMaxDepth is the maximum number of calls of Hou functions in progress
+// if MaxDepth isn't set.
+const DefaultMaxDepth = 10000
+
+// Evaluator holds the configuration and the state of evaluating Hou programs.
+// The tree-walker functions hang off it, so everything a running program can
+// observe or change (its output, its builtins, its limits) belongs to one
+// Evaluator and not to the package. An Evaluator evaluates one program at a
+// time and must not be used by multiple goroutines at once.
+type Evaluator struct {
+	// Stdin is where the `input` builtin reads from.
+	Stdin io.Reader
+	// Stdout is where builtins such as `puts` write their output.
+	Stdout io.Writer
+	// Stderr is where diagnostics meant for the user are written.
+	Stderr io.Writer
+
+	// MaxSteps is the maximum number of AST nodes a single call to Eval may
+	// evaluate, the tasks it starts included. Zero means no limit.
+	MaxSteps int64
+	// MaxDepth is the maximum number of calls of Hou functions in progress.
+	// Calling a function with as many calls in progress is a stack overflow
+	// error, which stops infinite recursion before it exhausts the stack of
+	// the Go runtime. Zero means DefaultMaxDepth.
+	MaxDepth int
+
+	// Parallelism is the maximum number of tasks started by `spawn` and
+	// similar builtins, the program itself included, that may evaluate at
+	// the same time. A task that evaluated TaskBudget steps lets the others
+	// run before it continues. Zero means no limit, the tasks being
+	// scheduled by the Go runtime.
+	Parallelism int
+	// TaskBudget is the number of steps in a time slice of a task. Zero
+	// means a default of 10000 steps.
+	TaskBudget int64
+
+	// Hooks are called as programs are evaluated. Nil means no hooks.
+	Hooks *Hooks
+
+	// Sandbox restricts what programs may do. Nil means no restrictions.
+	Sandbox *Sandbox
+
+	// Warnings configures where warnings about programs go. Nil means that
+	// all warnings are written to Stderr.
+	Warnings *Warnings
+
+	// Checked makes the evaluator assert the type annotations of programs:
+	// evaluating a let statement, calling a function or returning from it
+	// with a value of another type than the annotated one is an error.
+	// Otherwise, annotations are ignored.
+	Checked bool
+
+	// Assertions records the failed assertions of programs, which then go
+	// on, see the `assert` builtin. Nil means that a failed assertion is an
+	// error.
+	Assertions *Assertions
+
+	// Args are the command-line arguments of the program, returned by the
+	// `args` builtin, e.g. the ones after the script in `hou script.hou a b`.
+	Args []string
+
+	builtins map[string]*object.Builtin
+	// features are the features of the language version of the program or
+	// function being evaluated.
+	features lang.FeatureSet
+	// custom holds the names of the builtins set by SetBuiltin.
+	custom map[string]bool
+
+	// streams is shared with the Evaluators of tasks started by `spawn`.
+	streams *streams
+
+	ctx   context.Context
+	steps int64
+	depth int
+	// usage is shared with the Evaluators of tasks, so that the limits apply
+	// to the program and its tasks together.
+	usage *usage
+
+	// calls holds the calls of Hou functions in progress, outermost first,
+	// for the traces of errors.
+	calls []call
+	// envs holds the environments of the functions being called, outermost
+	// first, if inspection is enabled.
+	envs []*object.Environment
+	// inspector is shared with the Evaluators of tasks if inspection is
+	// enabled. It guards calls and envs.
+	inspector *inspector
+
+	// deprecated holds the deprecated builtins the program was warned about.
+	deprecated map[string]bool
+
+	// yield hands values over to the consumer if the Evaluator runs the body
+	// of a generator.
+	yield object.YieldFunc
+
+	// signals holds the signal handlers installed by the program, if any.
+	signals *signals
+
+	// timers counts the timers started with `after` and `every` that haven't
+	// stopped yet, see WaitTimers. It's shared with the Evaluators of tasks.
+	timers *sync.WaitGroup
+
+	// sched is shared with the Evaluators of tasks if Parallelism is set.
+	sched   *scheduler
+	holding bool
+	slice   int64
+
+	// globals is the environment the program being evaluated was started in.
+	globals *object.Environment
+
+	// site is the call of the last builtin called, for the positions of the
+	// failed assertions it records.
+	site *ast.CallExpression
+}
+
+// New returns a new Evaluator writing to the standard output and error of the
+// process, without any limits.
+func New() *Evaluator {
+	e := &Evaluator{
+		Stdin:   os.Stdin,
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+		custom:  map[string]bool{},
+		streams: &streams{},
+		usage:   &usage{},
+		timers:  &sync.WaitGroup{},
+	}
+	e.builtins = e.newBuiltins()
+	return e
+}
+
+// streams serializes the use of the standard streams by concurrent tasks.
+type streams struct {
+	mu sync.Mutex
+
+	// stdin buffers Stdin for reading it line by line. It's recreated when
+	// Stdin is replaced.
+	stdin       *bufio.Reader
+	stdinSource io.Reader
+}
+
+// usage counts what an evaluation and the tasks it started used up against
+// the limits. The counters are updated atomically by the tasks.
+type usage struct {
+	steps  int64
+	memory int64
+	// tasks is the number of tasks running, see Sandbox.MaxTasks.
+	tasks int64
+}
+
+// Fork returns a new Evaluator with the same configuration and builtins as e,
+// for evaluating programs concurrently with it, e.g. expressions typed in a
+// debugger while e runs a program. Its output goes to the same streams.
+func (e *Evaluator) Fork() *Evaluator {
+	child := &Evaluator{
+		Stdin:       e.Stdin,
+		Stdout:      e.Stdout,
+		Stderr:      e.Stderr,
+		MaxSteps:    e.MaxSteps,
+		MaxDepth:    e.MaxDepth,
+		Parallelism: e.Parallelism,
+		TaskBudget:  e.TaskBudget,
+		Hooks:       e.Hooks,
+		Sandbox:     e.Sandbox,
+		Warnings:    e.Warnings,
+		Checked:     e.Checked,
+		Assertions:  e.Assertions,
+		Args:        e.Args,
+		custom:      map[string]bool{},
+		streams:     e.streams,
+		usage:       &usage{},
+		timers:      &sync.WaitGroup{},
+	}
+
+	// The standard builtins are bound to the Evaluator they're created by,
+	// the ones set by the embedder are shared as they are.
+	child.builtins = child.newBuiltins()
+	for name := range child.builtins {
+		if _, ok := e.builtins[name]; !ok {
+			delete(child.builtins, name)
+		}
+	}
+	for name := range e.custom {
+		child.builtins[name] = e.builtins[name]
+		child.custom[name] = true
+	}
+
+	return child
+}
+
+// fork returns a new Evaluator for running a task concurrently with e. It's
+// like Fork, but the task also shares the context, the scheduler and the
+// inspector of e, as well as the steps and the memory counted against the
+// limits and the pending timers, while having its own state otherwise.
+func (e *Evaluator) fork() *Evaluator {
+	child := e.Fork()
+	child.sched = e.sched
+	child.inspector = e.inspector
+	child.usage = e.usage
+	child.timers = e.timers
+	child.ctx = e.ctx
+	if child.ctx == nil {
+		child.ctx = context.Background()
+	}
+	return child
+}
+
+// Eval evaluates the node and returns an object. It's a shortcut for
+// evaluating a node with a new Evaluator.
+func Eval(node ast.Node, env *object.Environment) object.Object {
+	return New().Eval(node, env)
+}
+
+// Eval evaluates the node in the environment env and returns an object.
+func (e *Evaluator) Eval(node ast.Node, env *object.Environment) object.Object {
+	return e.EvalContext(context.Background(), node, env)
+}
+
+// EvalContext is like Eval but stops the evaluation with an error object once
+// the context is done. That's how embedders put a time limit on a program.
+func (e *Evaluator) EvalContext(
+	ctx context.Context,
+	node ast.Node,
+	env *object.Environment,
+) object.Object {
+	e.globals = env
+	return e.run(ctx, func() object.Object { return e.eval(node, env) })
+}
+
+// CallContext calls the function or builtin fn with the arguments args, with
+// the same limits as EvalContext. It's how host code calls back into functions
+// defined by a program.
+func (e *Evaluator) CallContext(
+	ctx context.Context,
+	fn object.Object,
+	args []object.Object,
+) object.Object {
+	e.globals = nil
+	return e.run(ctx, func() object.Object { return e.applyFunction(fn, args) })
+}
+
+func (e *Evaluator) run(
+	ctx context.Context,
+	f func() object.Object,
+) (result object.Object) {
+	if e.Sandbox != nil && e.Sandbox.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Sandbox.Timeout)
+		defer cancel()
+	}
+
+	e.ctx = ctx
+	e.steps = 0
+	e.usage = &usage{}
+	e.depth = 0
+	e.calls = nil
+	e.deprecated = nil
+	e.enter(false)
+	defer func() {
+		e.leave()
+		// One bad program mustn't take down the REPL or the host process.
+		if r := recover(); r != nil {
+			result = recovered(r)
+			e.hookError(result)
+		}
+		e.stopSignals()
+		e.release()
+		e.ctx, e.globals = nil, nil
+	}()
+
+	if e.Parallelism > 0 && e.sched == nil {
+		e.sched = newScheduler(e.Parallelism, e.TaskBudget)
+	}
+	if err := e.acquire(); err != nil {
+		return err
+	}
+
+	result = f()
+	e.hookError(result)
+	return result
+}
+
+// Builtin returns the builtin function bound to name.
+func (e *Evaluator) Builtin(name string) (*object.Builtin, bool) {
+	builtin, ok := e.builtins[name]
+	return builtin, ok
+}
+
+// MaxCallDepth returns the maximum number of calls of Hou functions in
+// progress, MaxDepth or DefaultMaxDepth.
+func (e *Evaluator) MaxCallDepth() int {
+	if e.MaxDepth > 0 {
+		return e.MaxDepth
+	}
+	return DefaultMaxDepth
+}
+
+// BuiltinNames returns the sorted names of the builtin functions programs may
+// call, e.g. for the REPL to complete them.
+func (e *Evaluator) BuiltinNames() []string {
+	names := make([]string, 0, len(e.builtins))
+	for name := range e.builtins {
+		if e.Sandbox.AllowsBuiltin(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetBuiltin binds the builtin function to name, replacing any builtin of the
+// same name.
+func (e *Evaluator) SetBuiltin(name string, builtin *object.Builtin) {
+	e.builtins[name] = builtin
+	e.custom[name] = true
+}
+
+// RemoveBuiltin removes the builtin function bound to name, so that programs
+// can't call it.
+func (e *Evaluator) RemoveBuiltin(name string) {
+	delete(e.builtins, name)
+	delete(e.custom, name)
+}
+
+// readLine writes the prompt to Stdout and reads a line from Stdin, without
+// the line terminator.
+func (e *Evaluator) readLine(prompt string) (string, error) {
+	s := e.streams
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprint(e.Stdout, prompt)
+	if s.stdin == nil || s.stdinSource != e.Stdin {
+		s.stdin = bufio.NewReader(e.Stdin)
+		s.stdinSource = e.Stdin
+	}
+
+	line, err := s.stdin.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// println writes the values to Stdout, one per line.
+func (e *Evaluator) println(values ...string) {
+	e.streams.mu.Lock()
+	defer e.streams.mu.Unlock()
+
+	for _, v := range values {
+		fmt.Fprintln(e.Stdout, v)
+	}
+}
+
+// ctxCheckInterval is the number of steps between two checks of whether the
+// context is done. Checking a channel on every node would slow evaluation
+// down considerably.
+const ctxCheckInterval = 1024
+
+// step accounts for the evaluation of one node and returns an error object if
+// a limit was exceeded.
+func (e *Evaluator) step() *object.Error {
+	e.steps++
+
+	sandboxed := e.Sandbox != nil && e.Sandbox.MaxSteps > 0
+	if e.MaxSteps > 0 || sandboxed {
+		// The steps of all tasks count, or spawning would get around the
+		// limit.
+		steps := atomic.AddInt64(&e.usage.steps, 1)
+		if e.MaxSteps > 0 && steps > e.MaxSteps {
+			return newError(diagnostic.StepLimitExceeded,
+				"step limit exceeded: %d steps", e.MaxSteps)
+		}
+		if sandboxed && steps > e.Sandbox.MaxSteps {
+			return newError(diagnostic.StepLimitExceeded,
+				"step limit exceeded: %d steps", e.Sandbox.MaxSteps)
+		}
+	}
+
+	if e.signals != nil {
+		if err := e.handleSignals(); err != nil {
+			return err
+		}
+	}
+	if e.sched != nil {
+		if err := e.tick(); err != nil {
+			return err
+		}
+	}
+
+	if e.ctx != nil && e.steps%ctxCheckInterval == 0 {
+		select {
+		case <-e.ctx.Done():
+			return newError(diagnostic.Cancelled,
+				"evaluation cancelled: %s", e.ctx.Err())
+		default:
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package evaluator
+
+import (
+	"github.com/cedrickchee/hou/builtinerr"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// openStore opens the store in the file at path, if the sandbox allows it.
+func (e *Evaluator) openStore(path string) object.Object {
+	if err := e.Sandbox.CheckPath(path); err != nil {
+		return builtinerr.Wrap(diagnostic.AccessDenied, "storeOpen", err)
+	}
+
+	s, err := object.OpenStore(path)
+	if err != nil {
+		return builtinerr.Wrap(diagnostic.IOError, "storeOpen", err)
+	}
+	return s
+}
+
+// storeArguments returns the store and the key that are the first arguments
+// to the builtin called name, which takes min to max arguments.
+func storeArguments(
+	name string,
+	args []object.Object,
+	min, max int,
+) (*object.Store, string, *object.Error) {
+	if err := builtinerr.ArgCount(args, min, max); err != nil {
+		return nil, "", err
+	}
+	s, ok := args[0].(*object.Store)
+	if !ok {
+		return nil, "", builtinerr.ArgType(name, args, 0, object.STORE_OBJ)
+	}
+	if min < 2 {
+		return s, "", nil
+	}
+	key, ok := args[1].(*object.String)
+	if !ok {
+		return nil, "", builtinerr.ArgType(name, args, 1, object.STRING_OBJ)
+	}
+	return s, key.Value, nil
+}
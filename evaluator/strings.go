@@ -0,0 +1,89 @@
+package evaluator
+
+import (
+	"strings"
+
+	"github.com/cedrickchee/hou/builtinerr"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// stringArguments checks that the builtin called name was called with min to
+// max arguments, all strings, and returns their values.
+func stringArguments(
+	name string,
+	args []object.Object,
+	min, max int,
+) ([]string, *object.Error) {
+	if err := builtinerr.ArgCount(args, min, max); err != nil {
+		return nil, err
+	}
+	values := make([]string, len(args))
+	for i, arg := range args {
+		str, ok := arg.(*object.String)
+		if !ok {
+			return nil, builtinerr.ArgType(name, args, i, object.STRING_OBJ)
+		}
+		values[i] = str.Value
+	}
+	return values, nil
+}
+
+// split splits s around sep, into its characters if sep is empty, or around
+// runs of whitespace if there's no sep.
+func split(s string, sep []string) *object.Array {
+	var parts []string
+	switch {
+	case len(sep) == 0:
+		parts = strings.Fields(s)
+	case sep[0] == "":
+		for _, c := range s {
+			parts = append(parts, string(c))
+		}
+	default:
+		parts = strings.Split(s, sep[0])
+	}
+
+	elements := make([]object.Object, len(parts))
+	for i, part := range parts {
+		elements[i] = &object.String{Value: part}
+	}
+	return &object.Array{Elements: elements}
+}
+
+// join joins the strings of the array, separated by sep.
+func join(array *object.Array, sep string) object.Object {
+	parts := make([]string, len(array.Elements))
+	for i, element := range array.Elements {
+		str, ok := element.(*object.String)
+		if !ok {
+			return newError(diagnostic.WrongArgumentType,
+				"element %d of the array to `join` must be STRING, got %s",
+				i, element.Type())
+		}
+		parts[i] = str.Value
+	}
+	return &object.String{Value: strings.Join(parts, sep)}
+}
+
+// substr returns the characters of s from start on, up to length of them if
+// length isn't negative. start counts from the end of s if it's negative.
+// Unlike `len`, which counts the bytes of a string, substr counts characters,
+// so that it never cuts one in half.
+func substr(s string, start, length int64) object.Object {
+	chars := []rune(s)
+	n := int64(len(chars))
+	from := start
+	if from < 0 {
+		from += n
+	}
+	if from < 0 || from > n {
+		return newError(diagnostic.InvalidArgument,
+			"substr: start %d out of range for %d characters", start, n)
+	}
+	end := n
+	if length >= 0 && length < n-from {
+		end = from + length
+	}
+	return &object.String{Value: string(chars[from:end])}
+}
@@ -0,0 +1,85 @@
+package evaluator
+
+import (
+	"sort"
+
+	"github.com/cedrickchee/hou/object"
+)
+
+// suggest returns the name bound in env, or the builtin, that name is most
+// likely a typo of, or "" if there's no such name. It's used to add a hint to
+// the error about an unbound identifier.
+func (e *Evaluator) suggest(name string, env *object.Environment) string {
+	return Suggest(name, append(env.Names(), e.BuiltinNames()...))
+}
+
+// Suggest returns the candidate that name is most likely a typo of: the one
+// the fewest edits away, see editDistance, the first in sorted order of
+// those, or "" if none is close enough. One edit is allowed for every four
+// characters of name, so that names get no suggestions that merely share a
+// part with them, e.g. build for builder. Both engines use it for their
+// errors about unbound identifiers.
+func Suggest(name string, candidates []string) string {
+	// Sort the candidates to make ties deterministic.
+	sorted := append([]string(nil), candidates...)
+	sort.Strings(sorted)
+
+	limit := len(name) / 4
+	if limit < 1 {
+		limit = 1
+	}
+
+	best, bestDistance := "", limit+1
+	for _, candidate := range sorted {
+		if candidate == name {
+			continue
+		}
+		d := editDistance(name, candidate)
+		if d < bestDistance && d < len(name) {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the number of insertions, deletions, substitutions and
+// transpositions of adjacent characters it takes to turn a into b, also known
+// as the optimal string alignment distance.
+func editDistance(a, b string) int {
+	// d[i][j] is the distance between the first i characters of a and the
+	// first j characters of b.
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				if t := d[i-2][j-2] + 1; t < d[i][j] {
+					d[i][j] = t
+				}
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
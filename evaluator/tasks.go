@@ -0,0 +1,297 @@
+package evaluator
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/builtinerr"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// spawn calls fn with args on a new goroutine, with an Evaluator of its own,
+// and returns the task running it. The task shares the environment of fn with
+// the rest of the program, which must be synchronized, see startTasks. The
+// task is cancelled together with the evaluation that started it.
+func (e *Evaluator) spawn(fn object.Object, args []object.Object) object.Object {
+	if err := e.startTasks(1, fn); err != nil {
+		return err
+	}
+	task := object.NewTask()
+	child := e.fork()
+
+	go func() {
+		defer child.finishTasks(1)
+		task.Finish(child.callTask(fn, args))
+	}()
+
+	return task
+}
+
+// startTasks accounts for n tasks about to be started to call fn and returns
+// an error if that's more than the sandbox lets run at the same time, or if fn
+// is a function bound in an environment that isn't synchronized, which the
+// tasks would share. finishTasks must be called once they're done.
+func (e *Evaluator) startTasks(n int64, fn object.Object) *object.Error {
+	if f, ok := fn.(*object.Function); ok && f.Env != nil && !f.Env.Synchronized() {
+		return newError(diagnostic.InvalidArgument,
+			"cannot call a function on a task: it's bound in an environment that isn't synchronized")
+	}
+	tasks := atomic.AddInt64(&e.usage.tasks, n)
+	if e.Sandbox != nil && e.Sandbox.MaxTasks > 0 && tasks > e.Sandbox.MaxTasks {
+		atomic.AddInt64(&e.usage.tasks, -n)
+		return newError(diagnostic.TaskLimitExceeded,
+			"task limit exceeded: %d tasks", e.Sandbox.MaxTasks)
+	}
+	atomic.AddInt64(&running, n)
+	return nil
+}
+
+// finishTasks accounts for n tasks started with startTasks being done.
+func (e *Evaluator) finishTasks(n int64) {
+	atomic.AddInt64(&e.usage.tasks, -n)
+	atomic.AddInt64(&running, -n)
+}
+
+// NewEnvironment returns a new environment to evaluate the program in. It's
+// synchronized, see object.NewSynchronizedEnvironment, if the program uses
+// tasks, see UsesTasks, and plain, which is faster, otherwise. Environments
+// that programs are evaluated in one after the other, like the one of a REPL,
+// must be synchronized if any may start tasks.
+func NewEnvironment(program ast.Node) *object.Environment {
+	if UsesTasks(program) {
+		return object.NewSynchronizedEnvironment()
+	}
+	return object.NewEnvironment()
+}
+
+// UsesTasks reports whether the program spawns tasks or refers to a builtin
+// that calls functions on them, e.g. pmap, so that its variables may be shared
+// between goroutines.
+func UsesTasks(program ast.Node) bool {
+	tasks := false
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.Identifier:
+			tasks = tasks || taskBuiltins[node.Value]
+		case *ast.SpawnExpression:
+			tasks = true
+		}
+		return !tasks
+	})
+	return tasks
+}
+
+// taskBuiltins are the builtins that call functions on tasks.
+var taskBuiltins = map[string]bool{
+	"spawn": true,
+	"async": true,
+	"pmap":  true,
+	"after": true,
+	"every": true,
+}
+
+// running is the number of tasks running in the process, of any evaluation.
+// While there are some, arrays and hashes may be shared between goroutines,
+// so reading and assigning to their elements by index, e.g. a[0] or h.k, takes
+// elementLock. Without tasks, only the goroutine that could start one uses
+// them, and they're used without locking.
+var (
+	running     int64
+	elementLock sync.RWMutex
+)
+
+// tasksRunning reports whether any tasks are running, see running.
+func tasksRunning() bool {
+	return atomic.LoadInt64(&running) > 0
+}
+
+// callTask calls fn with args on behalf of a concurrent task, see runTask.
+func (e *Evaluator) callTask(fn object.Object, args []object.Object) object.Object {
+	return e.runTask(func() object.Object {
+		return unwrapReturnValue(e.applyFunction(fn, args))
+	})
+}
+
+// runTask calls f on behalf of a concurrent task. The steps and the memory of
+// the task count against the limits of the evaluation that started it. A panic
+// becomes an error object instead of crashing the process, see recovered.
+func (e *Evaluator) runTask(f func() object.Object) (result object.Object) {
+	e.enter(true)
+	defer func() {
+		if r := recover(); r != nil {
+			result = recovered(r)
+		}
+		e.stopSignals()
+		e.release()
+		e.leave()
+	}()
+
+	e.steps = 0
+	if err := e.acquire(); err != nil {
+		return err
+	}
+	result = f()
+	if result == nil {
+		result = NULL
+	}
+	return result
+}
+
+// wait blocks until the task is done and returns its result, or an error
+// object if the evaluation is cancelled in the meantime.
+func (e *Evaluator) wait(task *object.Task) object.Object {
+	err := e.block(func(ctx context.Context) error {
+		select {
+		case <-task.Done():
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != nil {
+		return blockError(err)
+	}
+	return task.Result()
+}
+
+// await returns the result of obj if it's a task and obj itself otherwise.
+func (e *Evaluator) await(obj object.Object) object.Object {
+	if task, ok := obj.(*object.Task); ok {
+		return e.wait(task)
+	}
+	return obj
+}
+
+// maxChannelCapacity is the largest capacity of a channel made by `chan`. The
+// buffer of a channel is allocated when it's made, whether it's used or not.
+const maxChannelCapacity = 1 << 20
+
+// send sends obj to the channel, blocking while it's full.
+func (e *Evaluator) send(ch *object.Channel, obj object.Object) object.Object {
+	err := e.block(func(ctx context.Context) error {
+		return ch.Send(ctx, obj)
+	})
+	if err != nil {
+		return e.channelError("send", err)
+	}
+	return NULL
+}
+
+// recv receives an object from the channel, blocking while it's empty. It
+// returns NULL once the channel is closed and empty.
+func (e *Evaluator) recv(ch *object.Channel) object.Object {
+	var obj object.Object
+	var ok bool
+	err := e.block(func(ctx context.Context) (err error) {
+		obj, ok, err = ch.Recv(ctx)
+		return err
+	})
+	if err != nil {
+		return e.channelError("recv", err)
+	}
+	if !ok {
+		return NULL
+	}
+	return obj
+}
+
+func (e *Evaluator) channelError(op string, err error) *object.Error {
+	if err == object.ErrChannelClosed {
+		return builtinerr.Wrap(diagnostic.IOError, op, err)
+	}
+	return blockError(err)
+}
+
+// context returns the context of the running evaluation.
+func (e *Evaluator) context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+func isCallable(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Function, *object.Builtin, object.Callable:
+		return true
+	}
+	return false
+}
+
+// pmap calls fn with every element of arr on a pool of workers goroutines,
+// each with an Evaluator of its own, and returns the results in the order of
+// the elements. Once a call fails, no new calls are started and the first
+// error is returned.
+func (e *Evaluator) pmap(arr *object.Array, fn object.Object, workers int) object.Object {
+	results := make([]object.Object, len(arr.Elements))
+	indexes := make(chan int)
+	done := make(chan struct{})
+	failed := make(chan *object.Error, 1)
+
+	if workers > len(arr.Elements) {
+		workers = len(arr.Elements)
+	}
+	if err := e.startTasks(int64(workers), fn); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		child := e.fork()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer child.finishTasks(1)
+			for i := range indexes {
+				result := child.callTask(fn, []object.Object{arr.Elements[i]})
+				if err, ok := result.(*object.Error); ok {
+					select {
+					case failed <- err:
+					default:
+					}
+					continue
+				}
+				results[i] = result
+			}
+		}()
+	}
+
+	go func() {
+		defer close(done)
+		defer wg.Wait()
+		defer close(indexes)
+		ctx := e.context()
+		for i := range arr.Elements {
+			select {
+			case indexes <- i:
+			case <-ctx.Done():
+				return
+			}
+			if len(failed) > 0 {
+				return
+			}
+		}
+	}()
+
+	err := e.block(func(ctx context.Context) error {
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != nil {
+		return blockError(err)
+	}
+
+	select {
+	case err := <-failed:
+		return err
+	default:
+		return e.allocated(&object.Array{Elements: results})
+	}
+}
@@ -0,0 +1,133 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cedrickchee/hou/builtinerr"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// startTimer calls fn on a new goroutine after the duration d has passed, and
+// again every d if repeat is true, until the returned timer is stopped. The
+// calls happen on an Evaluator of their own, like tasks started by `spawn`.
+// Pending calls are dropped when the evaluation that started the timer is
+// cancelled.
+//
+// Nobody waits for the result of a call, so an error is reported on Stderr
+// and stops the timer. The timer counts as a task until it's stopped.
+func (e *Evaluator) startTimer(
+	d time.Duration,
+	fn object.Object,
+	repeat bool,
+) object.Object {
+	if err := e.startTasks(1, fn); err != nil {
+		return err
+	}
+	timer := object.NewTimer()
+	child := e.fork()
+	e.timers.Add(1)
+
+	go func() {
+		defer child.finishTasks(1)
+		defer e.timers.Done()
+		defer timer.Stop()
+
+		t := time.NewTimer(d)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+			case <-timer.Stopped():
+				return
+			case <-child.ctx.Done():
+				return
+			}
+
+			if err, ok := child.callTask(fn, nil).(*object.Error); ok {
+				child.reportError("timer", err)
+				return
+			}
+			if !repeat {
+				return
+			}
+			t.Reset(d)
+		}
+	}()
+
+	return timer
+}
+
+// waitTimer waits for the timer to be stopped: by `cancel`, or once it's done
+// calling its function, after the call of `after` or a failed call of
+// `every`.
+func (e *Evaluator) waitTimer(timer *object.Timer) object.Object {
+	err := e.block(func(ctx context.Context) error {
+		select {
+		case <-timer.Stopped():
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != nil {
+		return blockError(err)
+	}
+	return NULL
+}
+
+// WaitTimers waits for the timers started by the evaluations of e and their
+// tasks, with `after` and `every`, to be stopped, or for ctx to be done. Timers
+// outlive the evaluation that started them, e.g. in the REPL, so that's how
+// the runner of a script lets the pending ones fire before exiting. Timers of
+// `every` run until they're cancelled.
+func (e *Evaluator) WaitTimers(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		e.timers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// timerBuiltin checks the arguments of the builtin `after` or `every` and
+// starts the timer.
+func (e *Evaluator) timerBuiltin(
+	name string,
+	args []object.Object,
+	repeat bool,
+) object.Object {
+	if err := builtinerr.ArgCount(args, 2, 2); err != nil {
+		return err
+	}
+	ms, ok := args[0].(*object.Integer)
+	if !ok {
+		return builtinerr.ArgType(name, args, 0, object.INTEGER_OBJ)
+	}
+	if ms.Value < 0 || (repeat && ms.Value == 0) {
+		return newError(diagnostic.InvalidArgument,
+			"invalid interval for `%s`: %d ms", name, ms.Value)
+	}
+	if !isCallable(args[1]) {
+		return builtinerr.ArgType(name, args, 1, object.FUNCTION_OBJ)
+	}
+
+	return e.startTimer(time.Duration(ms.Value)*time.Millisecond, args[1], repeat)
+}
+
+// reportError writes an error that can't be returned to the program to
+// Stderr.
+func (e *Evaluator) reportError(source string, err *object.Error) {
+	e.streams.mu.Lock()
+	defer e.streams.mu.Unlock()
+
+	fmt.Fprintf(e.Stderr, "%s: %s\n", source, err.Message)
+}
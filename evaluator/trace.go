@@ -0,0 +1,58 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/object"
+)
+
+// traceWidth is the maximum width of the source and the result of a node in
+// traces. Function literals in particular would make traces unreadable.
+const traceWidth = 50
+
+// TraceHooks returns hooks that write a trace of the evaluation to out, the
+// runtime analogue of the parser tracing: every node that was evaluated, with
+// its position, indented by the depth of calls, and what it evaluated to,
+// e.g.
+//
+//	[1:17] InfixExpression (x * 2) => 6
+//
+// Nodes are written once they're evaluated, so the nodes inside an expression
+// come before it. Tasks started by `spawn` are traced too, but indented by the
+// depth of calls of e.
+func (e *Evaluator) TraceHooks(out io.Writer) *Hooks {
+	return &Hooks{
+		LeaveNode: func(node ast.Node, result object.Object) {
+			switch node.(type) {
+			case *ast.Program, *ast.ExpressionStatement:
+				// Their results are the ones of their last expression.
+				return
+			}
+
+			pos := node.Pos()
+			kind := strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+			value := "nil"
+			if result != nil {
+				value = result.Inspect()
+			}
+
+			e.streams.mu.Lock()
+			defer e.streams.mu.Unlock()
+			fmt.Fprintf(out, "%s[%d:%d] %s %s => %s\n",
+				strings.Repeat("  ", e.Depth()), pos.Line, pos.Column, kind,
+				truncate(node.String()), truncate(value))
+		},
+	}
+}
+
+// truncate shortens s to traceWidth characters, on a single line.
+func truncate(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > traceWidth {
+		s = s[:traceWidth-3] + "..."
+	}
+	return s
+}
@@ -0,0 +1,116 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+	"github.com/cedrickchee/hou/token"
+)
+
+// WarningCategory groups warnings so that they can be turned off together.
+type WarningCategory string
+
+const (
+	// DeprecatedWarnings are about the use of deprecated builtins.
+	DeprecatedWarnings WarningCategory = "deprecated"
+)
+
+// WarningCategories lists all the categories of warnings.
+var WarningCategories = []WarningCategory{DeprecatedWarnings}
+
+// warningCodes are the diagnostic codes of the categories.
+var warningCodes = map[WarningCategory]diagnostic.Code{
+	DeprecatedWarnings: diagnostic.DeprecatedBuiltin,
+}
+
+// Warning is a problem the Evaluator found in a program that doesn't stop
+// it, unlike an error.
+type Warning struct {
+	Category WarningCategory
+	Message  string
+	// Position is where in the source code the problem is.
+	Position token.Position
+}
+
+// String returns the warning the way it's written to Warnings.Out, e.g.
+// "warning: `old` is deprecated: use new instead at line 1, col 1".
+func (w Warning) String() string {
+	msg := "warning: " + w.Message
+	if w.Position.IsValid() {
+		msg += " at " + w.Position.String()
+	}
+	return msg
+}
+
+// Diagnostic returns the warning in the form tools consume.
+func (w Warning) Diagnostic() diagnostic.Diagnostic {
+	d := diagnostic.New(warningCodes[w.Category], w.Position, w.Message)
+	d.Severity = diagnostic.Warning
+	return d
+}
+
+// Warnings configures where the warnings of an Evaluator go. A nil *Warnings
+// writes all warnings to the Stderr of the Evaluator.
+type Warnings struct {
+	// Out is where warnings are written, one per line. Nil means the Stderr
+	// of the Evaluator.
+	Out io.Writer
+	// Handle, if not nil, is called with every warning instead of writing it
+	// to Out. Tasks started by `spawn` call it too, so it may be called
+	// concurrently.
+	Handle func(Warning)
+	// Disabled holds the categories of warnings that are dropped.
+	Disabled map[WarningCategory]bool
+}
+
+// Enabled reports whether warnings of the category are reported.
+func (w *Warnings) Enabled(category WarningCategory) bool {
+	return w == nil || !w.Disabled[category]
+}
+
+// warn reports a warning of the category about the node.
+func (e *Evaluator) warn(
+	category WarningCategory,
+	node ast.Node,
+	format string,
+	a ...interface{},
+) {
+	if !e.Warnings.Enabled(category) {
+		return
+	}
+
+	w := Warning{
+		Category: category,
+		Message:  fmt.Sprintf(format, a...),
+		Position: callee(node).Pos(),
+	}
+	if e.Warnings != nil && e.Warnings.Handle != nil {
+		e.Warnings.Handle(w)
+		return
+	}
+
+	out := e.Stderr
+	if e.Warnings != nil && e.Warnings.Out != nil {
+		out = e.Warnings.Out
+	}
+	e.streams.mu.Lock()
+	fmt.Fprintln(out, w)
+	e.streams.mu.Unlock()
+}
+
+// checkDeprecated warns the first time the program uses a deprecated
+// builtin.
+func (e *Evaluator) checkDeprecated(node *ast.Identifier, builtin *object.Builtin) {
+	if builtin.Deprecated == "" || e.deprecated[node.Value] {
+		return
+	}
+	if e.deprecated == nil {
+		e.deprecated = map[string]bool{}
+	}
+	e.deprecated[node.Value] = true
+	e.warn(DeprecatedWarnings, node, "`%s` is deprecated: %s",
+		node.Value, builtin.Deprecated)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/format"
+	"github.com/cedrickchee/hou/lang"
+)
+
+// formatFile implements `hou fmt`, which formats a script. It writes the
+// script formatted canonically to stdout, or back to the script with -w, see
+// package format.
+func formatFile(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write the formatted script back to the script instead of stdout")
+	diagnostics := fs.String("diagnostics", "text", "format of the errors: text or json")
+	version := lang.Default
+	fs.Var(&version, "lang", "`version` of the language of scripts without a version pragma")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: hou fmt [-w] [--diagnostics=text|json] [--lang=n] script.hou\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	diagFormat, err := diagnostic.ParseFormat(*diagnostics)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hou fmt: %s\n", err)
+		return 2
+	}
+
+	filename := fs.Arg(0)
+	program, src, ok := parseFile("fmt", filename, diagFormat, version)
+	if !ok {
+		return 1
+	}
+
+	formatted := format.Source(program, src)
+	if !*write {
+		fmt.Print(formatted)
+		return 0
+	}
+	if formatted == src {
+		return 0
+	}
+	info, err := os.Stat(filename)
+	if err == nil {
+		err = ioutil.WriteFile(filename, []byte(formatted), info.Mode())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hou fmt: %s\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,474 @@
+package format
+
+// Package format formats Hou programs canonically: two spaces of indentation
+// per block, one statement per line, single spaces around binary operators,
+// only the parentheses the precedence of operators needs, and the arguments of
+// calls, the elements of arrays and the pairs of hashes one per line when they
+// don't fit on a line. It's what `hou fmt` runs.
+//
+// The formatted source parses back to the same program. Source keeps the
+// comments and the blank lines between statements of the source; comments
+// inside expressions are moved before the next statement.
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/lexer"
+	"github.com/cedrickchee/hou/parser"
+	"github.com/cedrickchee/hou/token"
+)
+
+const (
+	indentation = "  "
+	// lineWidth is the width lists are wrapped at, counting the indentation.
+	lineWidth = 80
+)
+
+// Program returns the canonical source of program. Programs of a version of
+// the language other than lang.Default start with its pragma.
+func Program(program *ast.Program) string {
+	p := &printer{}
+	var out strings.Builder
+	if v := program.Features.Version(); v != lang.Default {
+		out.WriteString("#pragma version " + v.String() + "\n\n")
+	}
+	out.WriteString(p.statements(program.Statements, 0, true))
+	return out.String()
+}
+
+// Source returns the canonical source of program, which was parsed from src,
+// with the comments of src. The pragma naming the version of the language is
+// kept if src has one.
+func Source(program *ast.Program, src string) string {
+	p := &printer{src: src, closing: map[int]int{}}
+
+	var braces []int
+	pragma := -1
+	for _, span := range lexer.Classify(src) {
+		p.spans = append(p.spans, span)
+		switch span.Type {
+		case token.COMMENT:
+			p.comments = append(p.comments, span)
+		case token.PRAGMA:
+			if pragma < 0 {
+				pragma = span.Start
+			}
+		case token.LBRACE:
+			braces = append(braces, span.Start)
+		case token.RBRACE:
+			if len(braces) > 0 {
+				p.closing[braces[len(braces)-1]] = span.Start
+				braces = braces[:len(braces)-1]
+			}
+		}
+	}
+
+	var out strings.Builder
+	if pragma >= 0 {
+		out.WriteString(p.commentsBefore(pragma, true))
+		out.WriteString("#pragma version " + program.Features.Version().String() + "\n\n")
+	}
+	out.WriteString(p.statements(program.Statements, len(src)+1, true))
+	return out.String()
+}
+
+// printer formats the nodes of a program. Nodes are formatted to strings whose
+// lines after the first are indented, so that the lines of nested blocks are
+// indented by their depth.
+type printer struct {
+	depth int
+
+	// src, spans and comments are the source, its tokens and its comments,
+	// if the program is formatted with its source.
+	src      string
+	spans    []lexer.Span
+	comments []lexer.Span
+	// next is the index of the first comment that hasn't been written yet.
+	next int
+	// closing maps the offsets of the braces of the source to the offsets
+	// of the braces closing them.
+	closing map[int]int
+}
+
+func (p *printer) indent() string {
+	return strings.Repeat(indentation, p.depth)
+}
+
+// statement is a formatted statement, without its terminating semicolon.
+type statement struct {
+	leading  string // the comments before the statement, one per line
+	text     string
+	trailing string // the comment after the statement on its line
+}
+
+// statements formats stmts, which end before the offset end, one per line.
+// Expression statements end with a semicolon, except the last statement of
+// blocks, which is their value, and loops and conditionals that don't need
+// one.
+func (p *printer) statements(stmts []ast.Statement, end int, topLevel bool) string {
+	formatted := make([]statement, len(stmts))
+	first := true
+	for i, stmt := range stmts {
+		offset := offsetOf(stmt)
+		s := &formatted[i]
+		s.leading = p.commentsBefore(offset, first)
+		if (!first || s.leading != "") && p.blankBefore(offset) {
+			s.leading += "\n"
+		}
+		first = false
+
+		s.text = p.statement(stmt)
+		if p.next < len(p.comments) {
+			next := end
+			if i+1 < len(stmts) {
+				next = offsetOf(stmts[i+1])
+			}
+			c := p.comments[p.next]
+			if c.Start > offset && c.Start < next && p.trailing(c) {
+				s.trailing = p.src[c.Start:c.End]
+				p.next++
+			}
+		}
+	}
+
+	var out strings.Builder
+	for i, s := range formatted {
+		out.WriteString(s.leading)
+		out.WriteString(p.indent())
+		out.WriteString(s.text)
+
+		last := i == len(stmts)-1
+		switch stmt := stmts[i].(type) {
+		case *ast.ExpressionStatement:
+			if last && !topLevel {
+				break
+			}
+			if blockLike(stmt.Expression) &&
+				(last || !strings.ContainsAny(formatted[i+1].text[:1], "([-")) {
+				break
+			}
+			out.WriteString(";")
+		default:
+			out.WriteString(";")
+		}
+
+		if s.trailing != "" {
+			out.WriteString(" " + s.trailing)
+		}
+		out.WriteString("\n")
+	}
+
+	out.WriteString(p.commentsBefore(end, len(stmts) == 0))
+	return out.String()
+}
+
+// commentsBefore formats the comments before the offset end that haven't been
+// written yet, one per line. Blank lines before them are kept, unless first
+// tells that they start a block.
+func (p *printer) commentsBefore(end int, first bool) string {
+	var out strings.Builder
+	for ; p.next < len(p.comments) && p.comments[p.next].Start < end; p.next++ {
+		c := p.comments[p.next]
+		if (!first || out.Len() > 0) && p.blankBefore(c.Start) {
+			out.WriteString("\n")
+		}
+		out.WriteString(p.indent() + p.src[c.Start:c.End] + "\n")
+	}
+	return out.String()
+}
+
+// trailing reports whether the comment follows code on its line, e.g. the
+// closing brace of a block, rather than starting the line.
+func (p *printer) trailing(c lexer.Span) bool {
+	i := sort.Search(len(p.spans), func(i int) bool { return p.spans[i].Start >= c.Start })
+	if i == 0 {
+		return false
+	}
+	prev := p.spans[i-1]
+	end := prev.Line + strings.Count(p.src[prev.Start:prev.End], "\n")
+	return prev.Type != token.COMMENT && end == c.Line
+}
+
+// blankBefore reports whether the source has a blank line before the token or
+// comment at offset.
+func (p *printer) blankBefore(offset int) bool {
+	i := sort.Search(len(p.spans), func(i int) bool { return p.spans[i].Start >= offset })
+	if i == 0 || i == len(p.spans) {
+		return false
+	}
+	prev := p.spans[i-1]
+	end := prev.Line + strings.Count(p.src[prev.Start:prev.End], "\n")
+	return p.spans[i].Line-end > 1
+}
+
+// offsetOf returns the offset of the first token of stmt.
+func offsetOf(stmt ast.Statement) int {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return stmt.Token.Offset
+	case *ast.ReturnStatement:
+		return stmt.Token.Offset
+	case *ast.ExpressionStatement:
+		return stmt.Token.Offset
+	case *ast.BlockStatement:
+		return stmt.Token.Offset
+	}
+	return 0
+}
+
+// blockLike reports whether expr ends with the closing brace of a block and
+// needs no semicolon when it's a statement, unless the next statement could
+// be read as continuing it, e.g. as a call of it.
+func blockLike(expr ast.Expression) bool {
+	switch expr.(type) {
+	case *ast.IfExpression, *ast.WhileExpression, *ast.ForInExpression, *ast.MatchExpression:
+		return true
+	}
+	return false
+}
+
+// statement formats stmt, without its terminating semicolon.
+func (p *printer) statement(stmt ast.Statement) string {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		s := "let " + stmt.Name.Value
+		if stmt.Type != nil {
+			s += ": " + stmt.Type.Name
+		}
+		if stmt.Value != nil {
+			s += " = " + p.expr(stmt.Value, parser.LOWEST)
+		}
+		return s
+	case *ast.ReturnStatement:
+		if stmt.ReturnValue == nil {
+			return "return"
+		}
+		return "return " + p.expr(stmt.ReturnValue, parser.LOWEST)
+	case *ast.ExpressionStatement:
+		return p.expr(stmt.Expression, parser.LOWEST)
+	case *ast.BlockStatement:
+		return p.block(stmt)
+	}
+	return stmt.String()
+}
+
+// block formats a block, with its statements one per line.
+func (p *printer) block(block *ast.BlockStatement) string {
+	p.depth++
+	body := p.statements(block.Statements, p.closing[block.Token.Offset], false)
+	p.depth--
+	if body == "" {
+		return "{}"
+	}
+	return "{\n" + body + p.indent() + "}"
+}
+
+// primary is the precedence of expressions that don't need parentheses
+// anywhere, like literals and identifiers.
+const primary = parser.MEMBER + 1
+
+// precedence returns the precedence of the operator of expr, the one the
+// parser parses it with.
+func precedence(expr ast.Expression) int {
+	switch expr := expr.(type) {
+	case *ast.AssignExpression:
+		return parser.ASSIGN
+	case *ast.InfixExpression:
+		return infixPrecedence(expr.Operator)
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.YieldExpression:
+		// The value of yield is everything after it.
+		return parser.LOWEST
+	case *ast.CallExpression:
+		return parser.CALL
+	case *ast.IndexExpression:
+		return parser.INDEX
+	case *ast.MemberExpression:
+		return parser.MEMBER
+	}
+	return primary
+}
+
+func infixPrecedence(operator string) int {
+	switch operator {
+	case "??":
+		return parser.COALESCE
+	case "==", "!=":
+		return parser.EQUALS
+	case "<", ">", "<=", ">=":
+		return parser.LESSGREATER
+	case "+", "-":
+		return parser.SUM
+	case "*", "/", "%":
+		return parser.PRODUCT
+	case "**":
+		return parser.POWER
+	}
+	return parser.LOWEST
+}
+
+// expr formats expr as an operand of an operator of precedence min, in
+// parentheses if its own operator binds looser.
+func (p *printer) expr(expr ast.Expression, min int) string {
+	s := p.node(expr)
+	if precedence(expr) < min {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+func (p *printer) node(expr ast.Expression) string {
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		return expr.Value
+	case *ast.IntegerLiteral:
+		return expr.Token.Literal
+	case *ast.FloatLiteral:
+		return expr.Token.Literal
+	case *ast.StringLiteral:
+		return `"` + expr.Value + `"`
+	case *ast.Boolean:
+		if expr.Value {
+			return "true"
+		}
+		return "false"
+	case *ast.Null:
+		return "null"
+
+	case *ast.PrefixExpression:
+		right := p.expr(expr.Right, parser.PREFIX)
+		if expr.Operator == "-" && strings.HasPrefix(right, "-") {
+			// --x would read as one operator.
+			right = "(" + right + ")"
+		}
+		return expr.Operator + right
+	case *ast.InfixExpression:
+		prec := infixPrecedence(expr.Operator)
+		left, right := prec, prec+1
+		if expr.Operator == "**" {
+			// ** is right-associative.
+			left, right = prec+1, prec
+		}
+		return p.expr(expr.Left, left) + " " + expr.Operator + " " + p.expr(expr.Right, right)
+	case *ast.AssignExpression:
+		var target string
+		if expr.Name != nil {
+			target = expr.Name.Value
+		} else {
+			target = p.node(expr.Index)
+		}
+		return target + " " + expr.Operator + " " + p.expr(expr.Value, parser.LOWEST)
+	case *ast.YieldExpression:
+		return "yield " + p.expr(expr.Value, parser.LOWEST)
+	case *ast.SpawnExpression:
+		return "spawn " + p.expr(expr.Function, parser.LOWEST)
+
+	case *ast.CallExpression:
+		return p.expr(expr.Function, parser.CALL) + p.list("(", ")", len(expr.Arguments), func(i int) string {
+			return p.expr(expr.Arguments[i], parser.LOWEST)
+		})
+	case *ast.IndexExpression:
+		return p.expr(expr.Left, parser.CALL) + "[" + p.expr(expr.Index, parser.LOWEST) + "]"
+	case *ast.MemberExpression:
+		return p.expr(expr.Object, parser.CALL) + "." + expr.Property.Value
+
+	case *ast.ArrayLiteral:
+		return p.list("[", "]", len(expr.Elements), func(i int) string {
+			return p.expr(expr.Elements[i], parser.LOWEST)
+		})
+	case *ast.HashLiteral:
+		return p.list("{", "}", len(expr.Keys), func(i int) string {
+			key := expr.Keys[i]
+			return p.expr(key, parser.LOWEST) + ": " + p.expr(expr.Pairs[key], parser.LOWEST)
+		})
+
+	case *ast.FunctionLiteral:
+		params := make([]string, len(expr.Parameters))
+		for i, param := range expr.Parameters {
+			params[i] = param.Value
+			if i < len(expr.ParameterTypes) && expr.ParameterTypes[i] != nil {
+				params[i] += ": " + expr.ParameterTypes[i].Name
+			}
+			if value := expr.Default(i); value != nil {
+				params[i] += " = " + p.expr(value, parser.LOWEST)
+			}
+		}
+		s := "fn("
+		if expr.Name != nil {
+			s = "fn " + expr.Name.Value + "("
+		}
+		s += strings.Join(params, ", ") + ") "
+		if expr.ReturnType != nil {
+			s += "-> " + expr.ReturnType.Name + " "
+		}
+		return s + p.block(expr.Body)
+	case *ast.IfExpression:
+		s := "if (" + p.expr(expr.Condition, parser.LOWEST) + ") " + p.block(expr.Consequence)
+		if expr.Alternative != nil {
+			s += " else " + p.block(expr.Alternative)
+		}
+		return s
+	case *ast.WhileExpression:
+		return "while (" + p.expr(expr.Condition, parser.LOWEST) + ") " + p.block(expr.Body)
+	case *ast.ForInExpression:
+		s := "for ("
+		if expr.Key != nil {
+			s += expr.Key.Value + ", "
+		}
+		s += expr.Value.Value + " in " + p.expr(expr.Iterable, parser.LOWEST) + ") "
+		return s + p.block(expr.Body)
+	case *ast.MatchExpression:
+		var out strings.Builder
+		out.WriteString("match (" + p.expr(expr.Subject, parser.LOWEST) + ") {\n")
+		p.depth++
+		for _, c := range expr.Cases {
+			values := make([]string, len(c.Values))
+			for i, v := range c.Values {
+				values[i] = p.expr(v, parser.LOWEST)
+			}
+			out.WriteString(p.indent() + "case " + strings.Join(values, ", ") + ": " + p.block(c.Body) + "\n")
+		}
+		if expr.Default != nil {
+			out.WriteString(p.indent() + "default: " + p.block(expr.Default) + "\n")
+		}
+		p.depth--
+		out.WriteString(p.indent() + "}")
+		return out.String()
+	}
+	return expr.String()
+}
+
+// list formats the n items of a call, an array or a hash between open and
+// close. They're on one line if it fits, or else one per line.
+func (p *printer) list(open, close string, n int, item func(i int) string) string {
+	if n == 0 {
+		return open + close
+	}
+
+	next := p.next
+	items := make([]string, n)
+	for i := range items {
+		items[i] = item(i)
+	}
+	flat := open + strings.Join(items, ", ") + close
+	// Items formatted on several lines, like functions, hug the delimiters.
+	if strings.Contains(flat, "\n") ||
+		len(p.indent())+utf8.RuneCountInString(flat) <= lineWidth {
+		return flat
+	}
+
+	// The items are formatted again one level deeper.
+	p.next = next
+	p.depth++
+	for i := range items {
+		items[i] = item(i)
+	}
+	inner := p.indent()
+	p.depth--
+	return open + "\n" + inner + strings.Join(items, ",\n"+inner) + "\n" + p.indent() + close
+}
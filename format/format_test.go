@@ -0,0 +1,203 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/lexer"
+	"github.com/cedrickchee/hou/parser"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"let   x=1+2*3 ;puts( x )",
+			"let x = 1 + 2 * 3;\nputs(x);\n",
+		},
+		{
+			"let f = fn(a,b){ if(a>b){return a;} else { b } };",
+			`let f = fn(a, b) {
+  if (a > b) {
+    return a;
+  } else {
+    b
+  }
+};
+`,
+		},
+		// Only the parentheses the precedence of operators needs are kept.
+		{
+			"((1 + 2)) * (3 * 4); (1 - 2) - 3; 1 - (2 - 3); -(2 ** 2); (-2) ** 2; 2 ** (3 ** 2); (2 ** 3) ** 2",
+			"(1 + 2) * (3 * 4);\n1 - 2 - 3;\n1 - (2 - 3);\n-2 ** 2;\n(-2) ** 2;\n2 ** 3 ** 2;\n(2 ** 3) ** 2;\n",
+		},
+		{
+			"-(-x); !(!x); (-a)(1); (a + b)[0]; f(1)[0].x; (fn(x) { x })(1)",
+			"-(-x);\n!!x;\n(-a)(1);\n(a + b)[0];\nf(1)[0].x;\nfn(x) {\n  x\n}(1);\n",
+		},
+		{
+			"a = b = 1; (a = 1) + 2; a ?? (b == c); (a ?? b) == c",
+			"a = b = 1;\n(a = 1) + 2;\na ?? b == c;\n(a ?? b) == c;\n",
+		},
+		{
+			`let h = {"a": [1, 2], "b": {}}; h["a"][0] += 1`,
+			`let h = {"a": [1, 2], "b": {}};` + "\n" + `h["a"][0] += 1;` + "\n",
+		},
+		{
+			"let t=spawn   fn(){ 1 }; wait( t )",
+			"let t = spawn fn() {\n  1\n};\nwait(t);\n",
+		},
+		// Long lists are wrapped, one item per line.
+		{
+			`puts("aaaaaaaaaaaaaaaaaaaa", "bbbbbbbbbbbbbbbbbbbb", "cccccccccccccccccccc", [1, 2, 3]);`,
+			`puts(
+  "aaaaaaaaaaaaaaaaaaaa",
+  "bbbbbbbbbbbbbbbbbbbb",
+  "cccccccccccccccccccc",
+  [1, 2, 3]
+);
+`,
+		},
+		// Functions passed as arguments hug the parentheses.
+		{
+			"map(xs, fn(x) { x * 2 });",
+			"map(xs, fn(x) {\n  x * 2\n});\n",
+		},
+		{
+			"let add = fn(a: Int, b: Int) -> Int { a + b }; let n: Int = add(1, 2);",
+			"let add = fn(a: Int, b: Int) -> Int {\n  a + b\n};\nlet n: Int = add(1, 2);\n",
+		},
+		{
+			"let f = fn fact(n) { if (n < 2) { 1 } else { n * fact(n - 1) } };",
+			"let f = fn fact(n) {\n  if (n < 2) {\n    1\n  } else {\n    n * fact(n - 1)\n  }\n};\n",
+		},
+		{
+			"let f = fn(a, b: Int = a*2) { a + b };",
+			"let f = fn(a, b: Int = a * 2) {\n  a + b\n};\n",
+		},
+		// Loops and conditionals need no semicolon, unless the next statement
+		// would continue them.
+		{
+			`#pragma version 2
+let n = 3; while (n > 0) { n -= 1; } for (k, v in {"a": 1}) { puts(k, v) } if (n) { 1 }; [n][0]`,
+			`#pragma version 2
+
+let n = 3;
+while (n > 0) {
+  n -= 1
+}
+for (k, v in {"a": 1}) {
+  puts(k, v)
+}
+if (n) {
+  1
+};
+[n][0];
+`,
+		},
+		{
+			`#pragma version 2
+match (x) { case 1, 2: { "small" } default: { null } }`,
+			`#pragma version 2
+
+match (x) {
+  case 1, 2: {
+    "small"
+  }
+  default: {
+    null
+  }
+}
+`,
+		},
+		// Comments and single blank lines between statements are kept.
+		{
+			`// Package comment.
+
+let x = 1;  // one
+
+
+// Two.
+let y = 2;
+let f = fn() {
+  // Nothing.
+};
+/* The end. */
+`,
+			`// Package comment.
+
+let x = 1; // one
+
+// Two.
+let y = 2;
+let f = fn() {
+  // Nothing.
+};
+/* The end. */
+`,
+		},
+		{
+			"if (x) { // Why.\n  y\n\n  // Done.\n}",
+			"if (x) {\n  // Why.\n  y\n\n  // Done.\n}\n",
+		},
+		// Comments after the closing brace of a block stay on its line.
+		{
+			"let f = fn(x) {\n  x + 1\n} // Add one.\nif (x) { 1 } else { 2 } // Pick.\nlet g = fn() {\n  f(1); // Call.\n}   /* Done. */\n// Next.\nf(1)",
+			`let f = fn(x) {
+  x + 1
+}; // Add one.
+if (x) {
+  1
+} else {
+  2
+} // Pick.
+let g = fn() {
+  f(1) // Call.
+}; /* Done. */
+// Next.
+f(1);
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		formatted := Source(program, tt.input)
+		if formatted != tt.expected {
+			t.Errorf("wrong format of %q. want=\n%s\ngot=\n%s", tt.input, tt.expected, formatted)
+			continue
+		}
+
+		// The formatted source is the same program, and formatted already.
+		reparsed := parse(t, formatted)
+		if reparsed.String() != program.String() {
+			t.Errorf("formatting %q changed the program. want=%q, got=%q",
+				tt.input, program.String(), reparsed.String())
+		}
+		if again := Source(reparsed, formatted); again != formatted {
+			t.Errorf("formatting %q isn't stable. want=\n%s\ngot=\n%s", tt.input, formatted, again)
+		}
+	}
+}
+
+func TestProgram(t *testing.T) {
+	input := "#pragma version 2\n// Dropped.\nlet x = [1, 2]; x[0]"
+	expected := "#pragma version 2\n\nlet x = [1, 2];\nx[0];\n"
+
+	if formatted := Program(parse(t, input)); formatted != expected {
+		t.Errorf("wrong format. want=%q, got=%q", expected, formatted)
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) > 0 {
+		t.Fatalf("parser errors for %q: %s", input, strings.Join(errors, "; "))
+	}
+	return program
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/highlight"
+	"github.com/cedrickchee/hou/lexer"
+	"github.com/cedrickchee/hou/parser"
+)
+
+// highlightFile implements `hou highlight`, which highlights a script to
+// stdout. --errors underlines syntax errors and reports them to stderr.
+func highlightFile(args []string) int {
+	fs := flag.NewFlagSet("highlight", flag.ExitOnError)
+	format := fs.String("format", "ansi", "format of the output: ansi or html")
+	lineNumbers := fs.Bool("line-numbers", false, "number the lines")
+	underline := fs.Bool("errors", false, "underline syntax errors and report them to stderr")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: hou highlight [--format=ansi|html] [--line-numbers] [--errors] script.hou\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	f, err := highlight.ParseFormat(*format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hou highlight: %s\n", err)
+		return 2
+	}
+
+	filename := fs.Arg(0)
+	input, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hou highlight: %s\n", err)
+		return 1
+	}
+
+	opts := highlight.Options{Format: f, LineNumbers: *lineNumbers}
+	if *underline {
+		p := parser.New(lexer.NewBytes(input))
+		p.ParseProgram()
+		opts.Errors = p.Diagnostics()
+		report(filename, diagnostic.Text, opts.Errors)
+	}
+
+	if err := highlight.Write(os.Stdout, string(input), opts); err != nil {
+		fmt.Fprintf(os.Stderr, "hou highlight: %s\n", err)
+		return 1
+	}
+	return 0
+}
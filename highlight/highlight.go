@@ -0,0 +1,165 @@
+package highlight
+
+// Package highlight writes Hou source code highlighted for terminals or web
+// pages, with the colors picked by the classes of lexer.Classify. It's what
+// `hou highlight` runs.
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/lexer"
+)
+
+// Format is the format of highlighted code.
+type Format string
+
+const (
+	// ANSI colors the code with ANSI escape sequences, for terminals.
+	ANSI Format = "ansi"
+	// HTML marks up the code as a <pre> element whose tokens are <span>s
+	// with the classes hou-keyword, hou-string and so on, after the class of
+	// the token. Line numbers have the class hou-line and errors hou-error.
+	HTML Format = "html"
+)
+
+// ParseFormat returns the format called name.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(name); f {
+	case ANSI, HTML:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown highlighting format %q, want %q or %q",
+		name, ANSI, HTML)
+}
+
+// Options tells how to highlight code.
+type Options struct {
+	Format Format
+	// LineNumbers numbers the lines.
+	LineNumbers bool
+	// Errors are underlined: the token at the position of each of them is.
+	Errors []diagnostic.Diagnostic
+}
+
+// ansiColors are the escape sequences that color the classes of tokens in
+// terminals. Identifiers and delimiters keep the color of the terminal.
+var ansiColors = map[lexer.Class]string{
+	lexer.Keyword:  "\x1b[35m",
+	lexer.String:   "\x1b[32m",
+	lexer.Number:   "\x1b[36m",
+	lexer.Comment:  "\x1b[90m",
+	lexer.Operator: "\x1b[33m",
+	lexer.Illegal:  "\x1b[31m",
+}
+
+const (
+	ansiLine      = "\x1b[90m"
+	ansiUnderline = "\x1b[4;31m"
+	ansiReset     = "\x1b[0m"
+)
+
+// style is how a byte of the source is highlighted.
+type style struct {
+	class lexer.Class // empty for whitespace
+	err   string      // the message of the error underlining it, if any
+}
+
+// Write writes src to w, highlighted as opts says.
+func Write(w io.Writer, src string, opts Options) error {
+	styles := styles(src, opts.Errors)
+
+	var b strings.Builder
+	if opts.Format == HTML {
+		b.WriteString(`<pre class="hou">`)
+	}
+
+	lines := strings.SplitAfter(src, "\n")
+	if lines[len(lines)-1] == "" {
+		// Don't number the line after the last newline.
+		lines = lines[:len(lines)-1]
+	}
+	width := len(fmt.Sprint(len(lines)))
+
+	offset := 0
+	for i, line := range lines {
+		if opts.LineNumbers {
+			number := fmt.Sprintf("%*d ", width, i+1)
+			if opts.Format == HTML {
+				fmt.Fprintf(&b, `<span class="hou-line">%s</span>`, number)
+			} else {
+				b.WriteString(ansiLine + number + ansiReset)
+			}
+		}
+
+		text := strings.TrimSuffix(line, "\n")
+		// Write runs of bytes of the same style at once.
+		for start := 0; start < len(text); {
+			s := styles[offset+start]
+			end := start + 1
+			for end < len(text) && styles[offset+end] == s {
+				end++
+			}
+			if opts.Format == HTML {
+				writeHTML(&b, text[start:end], s)
+			} else {
+				writeANSI(&b, text[start:end], s)
+			}
+			start = end
+		}
+		if len(text) < len(line) {
+			b.WriteByte('\n')
+		}
+		offset += len(line)
+	}
+
+	if opts.Format == HTML {
+		b.WriteString("</pre>\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// styles returns the style of every byte of src.
+func styles(src string, errors []diagnostic.Diagnostic) []style {
+	styles := make([]style, len(src))
+	for _, span := range lexer.Classify(src) {
+		message := ""
+		for _, d := range errors {
+			if d.Line == span.Line && d.Column == span.Column {
+				message = d.Message
+			}
+		}
+		for i := span.Start; i < span.End; i++ {
+			styles[i] = style{class: span.Class, err: message}
+		}
+	}
+	return styles
+}
+
+func writeANSI(b *strings.Builder, text string, s style) {
+	color := ansiColors[s.class]
+	if s.err != "" {
+		color = ansiUnderline
+	}
+	if color == "" {
+		b.WriteString(text)
+		return
+	}
+	b.WriteString(color + text + ansiReset)
+}
+
+func writeHTML(b *strings.Builder, text string, s style) {
+	text = html.EscapeString(text)
+	if s.class != "" {
+		text = fmt.Sprintf(`<span class="hou-%s">%s</span>`, s.class, text)
+	}
+	if s.err != "" {
+		text = fmt.Sprintf(`<span class="hou-error" title="%s">%s</span>`,
+			html.EscapeString(s.err), text)
+	}
+	b.WriteString(text)
+}
@@ -0,0 +1,81 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/token"
+)
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		input    string
+		opts     Options
+		expected string
+	}{
+		{
+			`let s = "a<b";`,
+			Options{Format: ANSI},
+			"\x1b[35mlet\x1b[0m s \x1b[33m=\x1b[0m \x1b[32m\"a<b\"\x1b[0m;",
+		},
+		{
+			"1\n+ 2\n",
+			Options{Format: ANSI, LineNumbers: true},
+			"\x1b[90m1 \x1b[0m\x1b[36m1\x1b[0m\n" +
+				"\x1b[90m2 \x1b[0m\x1b[33m+\x1b[0m \x1b[36m2\x1b[0m\n",
+		},
+		{
+			`let s = "a<b";`,
+			Options{Format: HTML},
+			`<pre class="hou"><span class="hou-keyword">let</span> ` +
+				`<span class="hou-identifier">s</span> ` +
+				`<span class="hou-operator">=</span> ` +
+				`<span class="hou-string">&#34;a&lt;b&#34;</span>` +
+				`<span class="hou-delimiter">;</span></pre>` + "\n",
+		},
+		{
+			"x @",
+			Options{
+				Format: ANSI,
+				Errors: []diagnostic.Diagnostic{
+					diagnostic.New(diagnostic.NoPrefixParseFn, token.Position{Line: 1, Column: 3}, "oops"),
+				},
+			},
+			"x \x1b[4;31m@\x1b[0m",
+		},
+		{
+			"x @",
+			Options{
+				Format:      HTML,
+				LineNumbers: true,
+				Errors: []diagnostic.Diagnostic{
+					diagnostic.New(diagnostic.NoPrefixParseFn, token.Position{Line: 1, Column: 3}, "<oops>"),
+				},
+			},
+			`<pre class="hou"><span class="hou-line">1 </span>` +
+				`<span class="hou-identifier">x</span> ` +
+				`<span class="hou-error" title="&lt;oops&gt;"><span class="hou-illegal">@</span></span>` +
+				"</pre>\n",
+		},
+	}
+
+	for i, tt := range tests {
+		var out strings.Builder
+		if err := Write(&out, tt.input, tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.expected {
+			t.Errorf("tests[%d] - wrong output.\nwant=%q\ngot= %q", i, tt.expected, out.String())
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := ParseFormat("html"); f != HTML || err != nil {
+		t.Errorf("wrong format. got=%q, %v", f, err)
+	}
+	if _, err := ParseFormat("svg"); err == nil {
+		t.Errorf("no error for unknown format")
+	}
+}
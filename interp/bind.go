@@ -0,0 +1,210 @@
+package interp
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// Bind exposes the Go struct or map v to scripts under name. Scripts read the
+// exported fields of a struct, or the entries of a map with string keys, with
+// the index operator: `config["Port"]`. A field tagged `hou:"port"` is exposed
+// as "port" instead and a field tagged `hou:"-"` is hidden. Reads always see
+// the current value of v, and nested structs and maps are exposed the same way.
+//
+// If writable is true and v is a pointer to a struct or a map, the bound object
+// also accepts assignments to its existing fields or keys.
+func (i *Interpreter) Bind(name string, v interface{}, writable bool) error {
+	rv := reflect.ValueOf(v)
+	if !isBindable(rv) {
+		return fmt.Errorf("interp: Bind(%q): cannot bind %T, want a struct "+
+			"or a map with string keys", name, v)
+	}
+	if writable && !isSettable(rv) {
+		return fmt.Errorf("interp: Bind(%q): cannot bind %T as writable, want "+
+			"a pointer to a struct or a map", name, v)
+	}
+
+	i.env.Set(name, &Host{value: rv, writable: writable})
+	return nil
+}
+
+// Host is the object a Go struct or map bound by Bind is exposed as.
+type Host struct {
+	value    reflect.Value
+	writable bool
+}
+
+// Type returns the type of the object.
+func (h *Host) Type() object.ObjectType { return object.HOST_OBJ }
+
+// Inspect returns a stringified version of the object for debugging.
+func (h *Host) Inspect() string {
+	var out bytes.Buffer
+
+	pairs := []string{}
+	for _, key := range h.keys() {
+		pairs = append(pairs, fmt.Sprintf("%s: %s",
+			key, h.Index(&object.String{Value: key}).Inspect()))
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// Index returns the field or map entry named by key.
+func (h *Host) Index(key object.Object) object.Object {
+	name, ok := key.(*object.String)
+	if !ok {
+		return &object.Error{
+			Code:    diagnostic.UnusableHashKey,
+			Message: fmt.Sprintf("unusable as host key: %s", key.Type()),
+		}
+	}
+
+	v, ok := h.lookup(name.Value)
+	if !ok {
+		return object.NULL
+	}
+	obj, err := toObject(v, h.writable)
+	if err != nil {
+		return &object.Error{Code: diagnostic.HostError, Message: err.Error()}
+	}
+	return obj
+}
+
+// SetIndex replaces the field or map entry named by key with value.
+func (h *Host) SetIndex(key, value object.Object) error {
+	if !h.writable {
+		return fmt.Errorf("host value is read-only")
+	}
+	name, ok := key.(*object.String)
+	if !ok {
+		return fmt.Errorf("unusable as host key: %s", key.Type())
+	}
+
+	v := indirect(h.value)
+	if v.Kind() == reflect.Map {
+		ev, err := toValue(value, v.Type().Elem())
+		if err != nil {
+			return err
+		}
+		v.SetMapIndex(reflect.ValueOf(name.Value).Convert(v.Type().Key()), ev)
+		return nil
+	}
+
+	field, ok := h.lookup(name.Value)
+	if !ok {
+		return fmt.Errorf("host value has no field %s", name.Value)
+	}
+	fv, err := toValue(value, field.Type())
+	if err != nil {
+		return err
+	}
+	field.Set(fv)
+	return nil
+}
+
+// lookup returns the struct field or map entry for name.
+func (h *Host) lookup(name string) (reflect.Value, bool) {
+	v := indirect(h.value)
+
+	if v.Kind() == reflect.Map {
+		ev := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		return ev, ev.IsValid()
+	}
+
+	t := v.Type()
+	for n := 0; n < t.NumField(); n++ {
+		if fieldName(t.Field(n)) == name {
+			return v.Field(n), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// keys returns the names of the fields or the map keys in a stable order.
+func (h *Host) keys() []string {
+	v := indirect(h.value)
+	var keys []string
+
+	if v.Kind() == reflect.Map {
+		for _, k := range v.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	t := v.Type()
+	for n := 0; n < t.NumField(); n++ {
+		if name := fieldName(t.Field(n)); name != "" {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// fieldName returns the name a struct field is exposed as, or "" if it's not
+// exposed at all.
+func fieldName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return "" // unexported
+	}
+	tag := f.Tag.Get("hou")
+	if tag == "-" {
+		return ""
+	}
+	if tag != "" {
+		return tag
+	}
+	return f.Name
+}
+
+// toObject converts a field or map entry to an object, binding nested structs
+// and maps instead of copying them.
+func toObject(v reflect.Value, writable bool) (object.Object, error) {
+	if isBindable(v) {
+		return &Host{value: v, writable: writable && isSettable(v)}, nil
+	}
+	return fromValue(v)
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	return v
+}
+
+func isBindable(v reflect.Value) bool {
+	if !v.IsValid() {
+		return false
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return false
+	}
+	v = indirect(v)
+	switch v.Kind() {
+	case reflect.Struct:
+		return true
+	case reflect.Map:
+		return v.Type().Key().Kind() == reflect.String && !v.IsNil()
+	}
+	return false
+}
+
+// isSettable reports whether the fields or entries of the bindable value can be
+// assigned to.
+func isSettable(v reflect.Value) bool {
+	v = indirect(v)
+	return v.Kind() == reflect.Map || v.CanSet()
+}
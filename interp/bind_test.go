@@ -0,0 +1,151 @@
+package interp
+
+import (
+	"testing"
+
+	"github.com/cedrickchee/hou/object"
+)
+
+type serverConfig struct {
+	Host    string
+	Port    int    `hou:"port"`
+	Secret  string `hou:"-"`
+	Debug   bool
+	Limits  limits
+	Tags    []string
+	private int
+}
+
+type limits struct {
+	MaxConns int
+}
+
+func TestBind(t *testing.T) {
+	cfg := &serverConfig{
+		Host:   "localhost",
+		Port:   8080,
+		Secret: "hunter2",
+		Limits: limits{MaxConns: 10},
+		Tags:   []string{"a", "b"},
+	}
+	env := map[string]string{"HOME": "/home/hou"}
+
+	i := New()
+	if err := i.Bind("config", cfg, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Bind("env", env, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`config["Host"]`, "localhost"},
+		{`config["port"] + 1`, "8081"},
+		{`config["Debug"]`, "false"},
+		{`if (config["Debug"]) { 1 } else { 2 }`, "2"},
+		{`config["Limits"]["MaxConns"]`, "10"},
+		{`config.Limits.MaxConns`, "10"},
+		{`len(config["Tags"])`, "2"},
+		{`config["Secret"]`, "null"},
+		{`config["private"]`, "null"},
+		{`env["HOME"]`, "/home/hou"},
+		{`env["missing"]`, "null"},
+		{`config`, "{Host: localhost, port: 8080, Debug: false, " +
+			"Limits: {MaxConns: 10}, Tags: [a, b]}"},
+	}
+
+	for _, tt := range tests {
+		val, err := i.Eval(tt.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if val.Inspect() != tt.expected {
+			t.Errorf("%s: wrong result. got=%s, want=%s",
+				tt.input, val.Inspect(), tt.expected)
+		}
+	}
+
+	// Reads see the current value of the host struct.
+	cfg.Port = 9090
+	val, _ := i.Eval(`config["port"]`)
+	if val.Inspect() != "9090" {
+		t.Errorf("bound value isn't live. got=%s", val.Inspect())
+	}
+
+	if _, err := i.Eval(`config[1]`); err == nil {
+		t.Errorf("expected an error for a non-string key")
+	}
+
+	host, _ := i.Environment().Get("config")
+	err := host.(object.IndexAssignable).SetIndex(
+		&object.String{Value: "Host"}, &object.String{Value: "example.com"})
+	if err == nil {
+		t.Errorf("expected read-only host to reject assignment")
+	}
+}
+
+func TestBindWritable(t *testing.T) {
+	cfg := &serverConfig{Limits: limits{MaxConns: 10}}
+	counters := map[string]int{"hits": 1}
+
+	i := New()
+	if err := i.Bind("config", cfg, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Bind("counters", counters, true); err != nil {
+		t.Fatal(err)
+	}
+
+	set := func(name, key string, value object.Object) error {
+		obj, _ := i.Environment().Get(name)
+		return obj.(object.IndexAssignable).SetIndex(&object.String{Value: key}, value)
+	}
+
+	if err := set("config", "port", &object.Integer{Value: 443}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 443 {
+		t.Errorf("port wasn't written. got=%d", cfg.Port)
+	}
+	if err := set("config", "Host", &object.Integer{Value: 1}); err == nil {
+		t.Errorf("expected a type error assigning an integer to Host")
+	}
+	if err := set("config", "Nope", object.NULL); err == nil {
+		t.Errorf("expected an error assigning an unknown field")
+	}
+
+	limits, _ := i.Eval(`config["Limits"]`)
+	err := limits.(object.IndexAssignable).SetIndex(
+		&object.String{Value: "MaxConns"}, &object.Integer{Value: 99})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Limits.MaxConns != 99 {
+		t.Errorf("nested field wasn't written. got=%d", cfg.Limits.MaxConns)
+	}
+
+	if err := set("counters", "hits", &object.Integer{Value: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if counters["hits"] != 2 {
+		t.Errorf("map entry wasn't written. got=%d", counters["hits"])
+	}
+}
+
+func TestBindInvalid(t *testing.T) {
+	i := New()
+
+	if err := i.Bind("n", 42, false); err == nil {
+		t.Errorf("expected an error binding an integer")
+	}
+	if err := i.Bind("m", map[int]int{}, false); err == nil {
+		t.Errorf("expected an error binding a map with integer keys")
+	}
+	if err := i.Bind("s", serverConfig{}, true); err == nil {
+		t.Errorf("expected an error binding a struct value as writable")
+	}
+}
@@ -0,0 +1,133 @@
+package interp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/cedrickchee/hou/object"
+)
+
+// Call calls the function bound to name in the interpreter's environment, e.g.
+// a handler defined by a script, with the Go values args converted to objects.
+func (i *Interpreter) Call(name string, args ...interface{}) (object.Object, error) {
+	return i.CallContext(context.Background(), name, args...)
+}
+
+// CallContext is like Call but stops the evaluation once ctx is done.
+func (i *Interpreter) CallContext(
+	ctx context.Context,
+	name string,
+	args ...interface{},
+) (object.Object, error) {
+	fn, ok := i.env.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("interp: Call(%q): identifier not found", name)
+	}
+
+	objs := make([]object.Object, len(args))
+	for n, arg := range args {
+		obj, err := object.FromGoValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("interp: Call(%q): argument %d: %s", name, n+1, err)
+		}
+		objs[n] = obj
+	}
+
+	return i.call(ctx, fn, objs)
+}
+
+func (i *Interpreter) call(
+	ctx context.Context,
+	fn object.Object,
+	args []object.Object,
+) (result object.Object, err error) {
+	// A Go builtin misbehaving in the middle of a callback shouldn't take the
+	// host down with it.
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("interp: panic during call: %v", r)
+		}
+	}()
+
+	ctx, cancel := i.context(ctx)
+	defer cancel()
+
+	return toResult(i.eval.CallContext(ctx, fn, args))
+}
+
+// Func makes the function bound to name callable as an ordinary, typed Go
+// function. fptr must be a pointer to a variable of function type, which Func
+// sets to a function that converts its arguments to objects, calls the Hou
+// function and converts the result back:
+//
+//	var add func(a, b int) (int, error)
+//	err := i.Func("add", &add)
+//	sum, err := add(1, 2)
+//
+// The function type may have at most one result besides an optional trailing
+// error. If it has no error result, a failing call panics with the error.
+// The Hou function is looked up on every call, so rebinding name is seen.
+func (i *Interpreter) Func(name string, fptr interface{}) error {
+	pv := reflect.ValueOf(fptr)
+	if pv.Kind() != reflect.Ptr || pv.Elem().Kind() != reflect.Func {
+		return fmt.Errorf("interp: Func(%q): %T is not a pointer to a function",
+			name, fptr)
+	}
+
+	t := pv.Elem().Type()
+	results := t.NumOut()
+	returnsError := results > 0 && t.Out(results-1) == errorType
+	if returnsError {
+		results--
+	}
+	if results > 1 {
+		return fmt.Errorf("interp: Func(%q): %s returns more than one value",
+			name, t)
+	}
+
+	fn := reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		out := make([]reflect.Value, t.NumOut())
+		for n := range out {
+			out[n] = reflect.Zero(t.Out(n))
+		}
+
+		fail := func(err error) []reflect.Value {
+			if !returnsError {
+				panic(err)
+			}
+			out[len(out)-1] = reflect.ValueOf(&err).Elem()
+			return out
+		}
+
+		args := make([]interface{}, len(in))
+		for n, v := range in {
+			args[n] = v.Interface()
+		}
+		if t.IsVariadic() && len(in) > 0 {
+			// Spread the variadic slice into separate arguments.
+			last := in[len(in)-1]
+			args = args[:len(args)-1]
+			for n := 0; n < last.Len(); n++ {
+				args = append(args, last.Index(n).Interface())
+			}
+		}
+
+		result, err := i.Call(name, args...)
+		if err != nil {
+			return fail(err)
+		}
+		if results == 1 {
+			v, err := toValue(result, t.Out(0))
+			if err != nil {
+				return fail(fmt.Errorf("interp: %s returned %s: %s",
+					name, result.Type(), err))
+			}
+			out[0] = v
+		}
+		return out
+	})
+
+	pv.Elem().Set(fn)
+	return nil
+}
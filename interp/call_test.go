@@ -0,0 +1,127 @@
+package interp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cedrickchee/hou/object"
+)
+
+const handlers = `
+let add = fn(a, b) { a + b };
+let greet = fn(name) { "hello " + name };
+let count = fn(xs) { len(xs) };
+let fail = fn() { 1 + true };
+let loop = fn(n) { loop(n + 1) };
+`
+
+func TestCall(t *testing.T) {
+	i := New(WithMaxSteps(10000))
+	if _, err := i.Eval(handlers); err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := i.Call("add", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Inspect() != "3" {
+		t.Errorf("wrong result. got=%s", val.Inspect())
+	}
+
+	val, err = i.Call("count", []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Inspect() != "3" {
+		t.Errorf("wrong result. got=%s", val.Inspect())
+	}
+
+	if _, err := i.Call("missing"); err == nil {
+		t.Errorf("expected an error calling an unknown function")
+	}
+
+	_, err = i.Call("fail")
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Errorf("expected a *RuntimeError. got=%T (%v)", err, err)
+	}
+
+	_, err = i.Call("loop", 0)
+	if err == nil || !strings.Contains(err.Error(), "step limit exceeded") {
+		t.Errorf("expected the step limit to apply to calls. got=%v", err)
+	}
+
+	i.RegisterBuiltin("boom", func(args ...object.Object) object.Object {
+		panic("boom")
+	})
+	if _, err := i.Eval("let explode = fn() { boom() };"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = i.Call("explode")
+	if err == nil || !strings.Contains(err.Error(), "internal interpreter error: boom") {
+		t.Errorf("expected the panic to be recovered. got=%v", err)
+	}
+}
+
+func TestFunc(t *testing.T) {
+	i := New()
+	if _, err := i.Eval(handlers); err != nil {
+		t.Fatal(err)
+	}
+
+	var add func(a, b int) (int, error)
+	if err := i.Func("add", &add); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := add(1, 2)
+	if err != nil || sum != 3 {
+		t.Errorf("add(1, 2) = %d, %v, want 3, nil", sum, err)
+	}
+
+	var greet func(string) string
+	if err := i.Func("greet", &greet); err != nil {
+		t.Fatal(err)
+	}
+	if got := greet("hou"); got != "hello hou" {
+		t.Errorf("greet(hou) = %q", got)
+	}
+
+	var addAll func(...int) int
+	if err := i.Func("add", &addAll); err != nil {
+		t.Fatal(err)
+	}
+	if got := addAll(2, 3); got != 5 {
+		t.Errorf("addAll(2, 3) = %d, want 5", got)
+	}
+
+	var fail func() error
+	if err := i.Func("fail", &fail); err != nil {
+		t.Fatal(err)
+	}
+	if err := fail(); err == nil {
+		t.Errorf("expected an error from fail()")
+	}
+
+	// Without an error result, failures panic.
+	var wrong func(int, int) bool
+	if err := i.Func("add", &wrong); err != nil {
+		t.Fatal(err)
+	}
+	if !panics(func() { wrong(1, 2) }) {
+		t.Errorf("expected converting an INTEGER result to bool to panic")
+	}
+
+	if err := i.Func("add", add); err == nil {
+		t.Errorf("expected an error for a non-pointer")
+	}
+}
+
+func panics(f func()) (panicked bool) {
+	defer func() {
+		panicked = recover() != nil
+	}()
+	f()
+	return false
+}
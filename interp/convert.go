@@ -0,0 +1,118 @@
+package interp
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/cedrickchee/hou/object"
+)
+
+var nullObject = object.NULL
+
+// toValue converts the object to a Go value of type t.
+func toValue(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	if t == objectType {
+		return reflect.ValueOf(&obj).Elem(), nil
+	}
+
+	v := reflect.New(t).Elem()
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := obj.(*object.Integer)
+		if !ok {
+			return v, typeError(obj, t)
+		}
+		if v.OverflowInt(i.Value) {
+			return v, fmt.Errorf("%d overflows %s", i.Value, t)
+		}
+		v.SetInt(i.Value)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, ok := obj.(*object.Integer)
+		if !ok {
+			return v, typeError(obj, t)
+		}
+		if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+			return v, fmt.Errorf("%d overflows %s", i.Value, t)
+		}
+		v.SetUint(uint64(i.Value))
+
+	case reflect.Bool:
+		b, ok := obj.(*object.Boolean)
+		if !ok {
+			return v, typeError(obj, t)
+		}
+		v.SetBool(b.Value)
+
+	case reflect.String:
+		s, ok := obj.(*object.String)
+		if !ok {
+			return v, typeError(obj, t)
+		}
+		v.SetString(s.Value)
+
+	case reflect.Slice:
+		arr, ok := obj.(*object.Array)
+		if !ok {
+			return v, typeError(obj, t)
+		}
+		v.Set(reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements)))
+		for n, el := range arr.Elements {
+			ev, err := toValue(el, t.Elem())
+			if err != nil {
+				return v, fmt.Errorf("element %d: %s", n, err)
+			}
+			v.Index(n).Set(ev)
+		}
+
+	case reflect.Map:
+		hash, ok := obj.(*object.Hash)
+		if !ok {
+			return v, typeError(obj, t)
+		}
+		v.Set(reflect.MakeMapWithSize(t, hash.Len()))
+		for _, pair := range hash.Pairs() {
+			kv, err := toValue(pair.Key, t.Key())
+			if err != nil {
+				return v, fmt.Errorf("key %s: %s", pair.Key.Inspect(), err)
+			}
+			ev, err := toValue(pair.Value, t.Elem())
+			if err != nil {
+				return v, fmt.Errorf("value of %s: %s", pair.Key.Inspect(), err)
+			}
+			v.SetMapIndex(kv, ev)
+		}
+
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return v, typeError(obj, t)
+		}
+		gv, err := object.ToGoValue(obj)
+		if err != nil {
+			// Objects without a Go counterpart, such as functions, are
+			// passed as they are.
+			gv = obj
+		}
+		if gv != nil {
+			v.Set(reflect.ValueOf(gv))
+		}
+
+	default:
+		return v, typeError(obj, t)
+	}
+
+	return v, nil
+}
+
+func typeError(obj object.Object, t reflect.Type) error {
+	return fmt.Errorf("cannot use %s as %s", obj.Type(), t)
+}
+
+// fromValue converts the Go value to an object.
+func fromValue(v reflect.Value) (object.Object, error) {
+	if !v.IsValid() {
+		return nullObject, nil
+	}
+	return object.FromGoValue(v.Interface())
+}
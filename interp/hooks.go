@@ -0,0 +1,51 @@
+package interp
+
+import (
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/object"
+)
+
+// The On* methods register functions the Interpreter calls when scripts do
+// certain things, so that applications can audit and meter them. Registering
+// a function again replaces the previous one and nil removes it. The
+// functions run on the goroutine evaluating the script and block it.
+
+// OnError registers fn to be called when the evaluation of a script, or a
+// call through Call, ends with a runtime error.
+func (i *Interpreter) OnError(fn func(err *RuntimeError)) {
+	if fn == nil {
+		i.hooks().Error = nil
+		return
+	}
+	i.hooks().Error = func(err *object.Error) {
+		fn(&RuntimeError{Err: err})
+	}
+}
+
+// OnFunctionCall registers fn to be called before a script calls a function
+// or builtin. name is the name the script called it by, or "" for calls of
+// anonymous functions, e.g. `fn(x) { x }(1)`.
+func (i *Interpreter) OnFunctionCall(fn func(name string, args []object.Object)) {
+	if fn == nil {
+		i.hooks().Call = nil
+		return
+	}
+	i.hooks().Call = func(name string, _ object.Object, args []object.Object) {
+		fn(name, args)
+	}
+}
+
+// OnGlobalSet registers fn to be called when a script binds a name in the
+// Interpreter's environment with a top-level `let` statement.
+func (i *Interpreter) OnGlobalSet(fn func(name string, value object.Object)) {
+	i.hooks().SetGlobal = fn
+}
+
+// hooks returns the evaluator hooks of the Interpreter, creating them if
+// needed.
+func (i *Interpreter) hooks() *evaluator.Hooks {
+	if i.eval.Hooks == nil {
+		i.eval.Hooks = &evaluator.Hooks{}
+	}
+	return i.eval.Hooks
+}
@@ -0,0 +1,69 @@
+package interp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cedrickchee/hou/object"
+)
+
+func TestHooks(t *testing.T) {
+	i := New()
+
+	var calls, globals, errs []string
+	i.OnFunctionCall(func(name string, args []object.Object) {
+		var inspected []string
+		for _, arg := range args {
+			inspected = append(inspected, arg.Inspect())
+		}
+		calls = append(calls, name+"("+strings.Join(inspected, ", ")+")")
+	})
+	i.OnGlobalSet(func(name string, value object.Object) {
+		globals = append(globals, name+"="+value.Inspect())
+	})
+	i.OnError(func(err *RuntimeError) {
+		errs = append(errs, err.Error())
+	})
+
+	_, err := i.Eval(`
+		let double = fn(x) { let y = x * 2; y };
+		let a = double(len("abc"));
+		fn(x) { x }(a);
+		a + true
+	`)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	wantCalls := []string{"len(abc)", "double(3)", "(6)"}
+	if !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("wrong calls. want=%q, got=%q", wantCalls, calls)
+	}
+	// y is bound inside double and isn't a global.
+	wantGlobals := []string{"double=" + mustGet(t, i, "double").Inspect(), "a=6"}
+	if !reflect.DeepEqual(globals, wantGlobals) {
+		t.Errorf("wrong globals. want=%q, got=%q", wantGlobals, globals)
+	}
+	wantErrs := []string{"type mismatch: INTEGER + BOOLEAN at line 5, col 5"}
+	if !reflect.DeepEqual(errs, wantErrs) {
+		t.Errorf("wrong errors. want=%q, got=%q", wantErrs, errs)
+	}
+
+	// Removed hooks aren't called anymore.
+	i.OnFunctionCall(nil)
+	i.OnError(nil)
+	calls, errs = nil, nil
+	i.Eval(`len("x"); 1 + true`)
+	if calls != nil || errs != nil {
+		t.Errorf("removed hooks called. calls=%q, errors=%q", calls, errs)
+	}
+}
+
+func mustGet(t *testing.T, i *Interpreter, name string) object.Object {
+	obj, ok := i.Environment().Get(name)
+	if !ok {
+		t.Fatalf("%s not bound", name)
+	}
+	return obj
+}
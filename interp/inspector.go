@@ -0,0 +1,164 @@
+package interp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+
+	"github.com/cedrickchee/hou/lexer"
+	"github.com/cedrickchee/hou/object"
+	"github.com/cedrickchee/hou/parser"
+)
+
+// The inspector lets `hou attach`, or any client speaking its protocol, look
+// into an Interpreter embedded in a running application: its global bindings,
+// the calls the program and its tasks are running and the environments of
+// those calls. It can also evaluate expressions in the global environment,
+// concurrently with the program. It's opt-in: the application enables it with
+// WithInspection and serves it on a listener of its choice, usually a unix
+// socket only the user running the application can access:
+//
+//	i := interp.New(interp.WithInspection())
+//	l, err := net.Listen("unix", "/tmp/app.sock")
+//	...
+//	go i.ServeInspector(l)
+//
+// The protocol is line-based text. Clients send one command per line and get
+// back lines of text ending with a line holding a single dot, in the format of
+// net/textproto's dot-encoding.
+
+// inspectorHelp describes the commands of the inspector.
+const inspectorHelp = `commands:
+  globals      list the global bindings and their values
+  envs         list the bindings of the calls in progress, innermost first
+  stacks       list the calls in progress of the program and its tasks
+  eval <expr>  evaluate the expression in the global environment
+  help         show this help`
+
+// WithInspection makes the Interpreter keep track of the calls its programs
+// are running, so that ServeInspector can report them.
+func WithInspection() Option {
+	return func(i *Interpreter) {
+		i.eval.EnableInspection()
+		i.inspection = true
+	}
+}
+
+// ServeInspector accepts connections of inspector clients on l and serves
+// each of them on a goroutine of its own, until l is closed. It may be called
+// while the Interpreter evaluates programs, but it requires WithInspection.
+func (i *Interpreter) ServeInspector(l net.Listener) error {
+	if !i.inspection {
+		return errors.New("interp: inspection isn't enabled, see WithInspection")
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go i.serveInspectorConn(conn)
+	}
+}
+
+// serveInspectorConn answers the commands of one client until it hangs up.
+func (i *Interpreter) serveInspectorConn(conn net.Conn) {
+	c := textproto.NewConn(conn)
+	defer c.Close()
+
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		w := c.DotWriter()
+		i.inspect(w, strings.TrimSpace(line))
+		if err := w.Close(); err != nil {
+			return
+		}
+	}
+}
+
+// inspect runs the command and writes the answer to w.
+func (i *Interpreter) inspect(w io.Writer, command string) {
+	name, arg := command, ""
+	if n := strings.IndexByte(command, ' '); n >= 0 {
+		name, arg = command[:n], strings.TrimSpace(command[n+1:])
+	}
+
+	switch name {
+	case "globals":
+		writeBindings(w, i.env, i.env.Names(), "")
+	case "envs":
+		fmt.Fprintf(w, "globals: %d bindings\n", len(i.env.Names()))
+		for _, stack := range i.eval.Stacks() {
+			for n, env := range stack.Envs {
+				fmt.Fprintf(w, "%s, call %d:\n", taskName(stack.Task), n)
+				writeBindings(w, env, env.LocalNames(), "  ")
+			}
+		}
+	case "stacks":
+		for _, stack := range i.eval.Stacks() {
+			fmt.Fprintf(w, "%s:\n", taskName(stack.Task))
+			if len(stack.Frames) == 0 {
+				fmt.Fprintln(w, "  no calls")
+			}
+			for _, frame := range stack.Frames {
+				fmt.Fprintf(w, "  in %s called at %s\n", frame.Function, frame.Position)
+			}
+		}
+	case "eval":
+		fmt.Fprintln(w, i.inspectEval(arg))
+	case "help", "":
+		fmt.Fprintln(w, inspectorHelp)
+	default:
+		fmt.Fprintf(w, "unknown command %q\n%s\n", name, inspectorHelp)
+	}
+}
+
+// inspectEval evaluates src in the global environment concurrently with the
+// program and returns the result.
+func (i *Interpreter) inspectEval(src string) string {
+	p := parser.New(lexer.New(src))
+	p.SetVersion(i.version)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return newParseError(p).Error()
+	}
+
+	ctx, cancel := i.context(context.Background())
+	defer cancel()
+
+	result, err := toResult(i.eval.Fork().EvalContext(ctx, program, i.env))
+	if err != nil {
+		return err.Error()
+	}
+	return result.Inspect()
+}
+
+// writeBindings writes the bindings of the names in env, one per line,
+// indented.
+func writeBindings(
+	w io.Writer,
+	env *object.Environment,
+	names []string,
+	indent string,
+) {
+	for _, name := range names {
+		if value, ok := env.Get(name); ok {
+			fmt.Fprintf(w, "%s%s = %s\n", indent, name, value.Inspect())
+		}
+	}
+}
+
+// taskName returns the name of the task numbered task in the stacks of the
+// evaluator.
+func taskName(task int) string {
+	if task == 0 {
+		return "program"
+	}
+	return fmt.Sprintf("task %d", task)
+}
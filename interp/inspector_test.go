@@ -0,0 +1,97 @@
+package interp
+
+import (
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/object"
+)
+
+func TestInspector(t *testing.T) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "inspector.sock"))
+	if err != nil {
+		t.Skipf("can't listen on a unix socket: %s", err)
+	}
+	defer l.Close()
+
+	i := New(WithInspection(), WithVersion(lang.Version(2)))
+	go i.ServeInspector(l)
+
+	// The program blocks in pause until the test inspected it.
+	paused := make(chan bool)
+	resume := make(chan bool)
+	i.RegisterBuiltin("pause", func(args ...object.Object) object.Object {
+		paused <- true
+		<-resume
+		return object.NULL
+	})
+	done := make(chan error)
+	go func() {
+		_, err := i.Eval(`
+let answer = 42;
+let wait = fn(x) { pause() };
+let run = fn() { wait(1) };
+run();
+`)
+		done <- err
+	}()
+	<-paused
+
+	conn, err := textproto.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		command  string
+		expected []string
+	}{
+		{"eval answer + 1", []string{"43"}},
+		{"eval let x = 1;", []string{"null"}},
+		// Inspection parses with the version of the interpreter.
+		{"eval null == null", []string{"true"}},
+		{"eval 1 +", []string{"parser errors: no prefix parse function for EOF found at line 1, col 4"}},
+		{"stacks", []string{
+			"program:",
+			"  in wait called at line 4, col 18",
+			"  in run called at line 5, col 1",
+		}},
+		{"envs", []string{
+			"globals: 4 bindings",
+			"program, call 0:",
+			"  x = 1",
+			"program, call 1:",
+		}},
+		{"nope", append([]string{`unknown command "nope"`},
+			strings.Split(inspectorHelp, "\n")...)},
+	}
+
+	for _, tt := range tests {
+		if err := conn.PrintfLine("%s", tt.command); err != nil {
+			t.Fatal(err)
+		}
+		lines, err := conn.ReadDotLines()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(lines, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("wrong answer to %q. want=%q, got=%q", tt.command, tt.expected, lines)
+		}
+	}
+
+	close(resume)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInspectorNotEnabled(t *testing.T) {
+	if err := New().ServeInspector(nil); err == nil {
+		t.Errorf("no error without WithInspection")
+	}
+}
@@ -0,0 +1,254 @@
+package interp
+
+// Package interp implements the public API for embedding the Hou interpreter
+// in Go applications. It wires the lexer, parser and evaluator together so an
+// application only has to do:
+//
+// 	i := interp.New(interp.WithStdout(&buf), interp.WithTimeout(time.Second))
+// 	val, err := i.Eval("let x = 1; x + 2")
+//
+// Bindings made by one call to Eval are visible to the following ones, just
+// like in the REPL.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/lexer"
+	"github.com/cedrickchee/hou/object"
+	"github.com/cedrickchee/hou/parser"
+)
+
+// Interpreter is an embedded Hou interpreter. It's not safe for concurrent use
+// by multiple goroutines, but Interpreters don't share any mutable state: each
+// one has its own environment, builtins, streams and limits, so separate
+// Interpreters can run concurrently in one process.
+type Interpreter struct {
+	env     *object.Environment
+	eval    *evaluator.Evaluator
+	timeout time.Duration
+	// inspection is set by WithInspection.
+	inspection bool
+	// version is the version of the language of programs, see WithVersion.
+	version lang.Version
+}
+
+// Option configures an Interpreter.
+type Option func(*Interpreter)
+
+// WithEnvironment makes the Interpreter evaluate programs in env instead of a
+// new, empty environment.
+func WithEnvironment(env *object.Environment) Option {
+	return func(i *Interpreter) { i.env = env }
+}
+
+// WithVersion makes the Interpreter parse programs that don't name a version
+// of the language with a pragma as the version v, instead of lang.Default.
+func WithVersion(v lang.Version) Option {
+	return func(i *Interpreter) { i.version = v }
+}
+
+// WithStdin sets the reader that builtins such as `input` read from.
+func WithStdin(r io.Reader) Option {
+	return func(i *Interpreter) { i.eval.Stdin = r }
+}
+
+// WithStdout sets the writer that builtins such as `puts` write to.
+func WithStdout(w io.Writer) Option {
+	return func(i *Interpreter) { i.eval.Stdout = w }
+}
+
+// WithStderr sets the writer that diagnostics are written to.
+func WithStderr(w io.Writer) Option {
+	return func(i *Interpreter) { i.eval.Stderr = w }
+}
+
+// WithMaxSteps limits the number of AST nodes a single call to Eval may
+// evaluate.
+func WithMaxSteps(n int64) Option {
+	return func(i *Interpreter) { i.eval.MaxSteps = n }
+}
+
+// WithMaxDepth limits the number of calls of Hou functions in progress,
+// which is evaluator.DefaultMaxDepth otherwise. Deeper calls fail with a stack
+// overflow error.
+func WithMaxDepth(n int) Option {
+	return func(i *Interpreter) { i.eval.MaxDepth = n }
+}
+
+// WithParallelism limits the number of concurrent tasks of a script that may
+// evaluate at the same time, and makes each of them let the others run after
+// evaluating budget steps. A budget of zero means the default.
+func WithParallelism(n int, budget int64) Option {
+	return func(i *Interpreter) {
+		i.eval.Parallelism = n
+		i.eval.TaskBudget = budget
+	}
+}
+
+// WithTimeout limits how long a single call to Eval may run.
+func WithTimeout(d time.Duration) Option {
+	return func(i *Interpreter) { i.timeout = d }
+}
+
+// WithSandbox restricts what scripts may do, e.g. which builtins they may call
+// and which files they may access, and limits the steps, memory and time a
+// single call to Eval may use. See evaluator.Sandbox.
+func WithSandbox(sandbox evaluator.Sandbox) Option {
+	return func(i *Interpreter) { i.eval.Sandbox = &sandbox }
+}
+
+// WithWarnings configures where the warnings about scripts go, e.g. to turn
+// some categories off. By default, they're written to the standard error of
+// the process. See evaluator.Warnings.
+func WithWarnings(warnings evaluator.Warnings) Option {
+	return func(i *Interpreter) { i.eval.Warnings = &warnings }
+}
+
+// WithoutBuiltins removes the named builtin functions, so that scripts can't
+// call them.
+func WithoutBuiltins(names ...string) Option {
+	return func(i *Interpreter) {
+		for _, name := range names {
+			i.eval.RemoveBuiltin(name)
+		}
+	}
+}
+
+// New returns a new Interpreter configured by the options.
+func New(opts ...Option) *Interpreter {
+	i := &Interpreter{
+		env:  object.NewSynchronizedEnvironment(),
+		eval: evaluator.New(),
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+// ParseError is returned by Eval when the source has syntax errors.
+type ParseError struct {
+	Errors []string
+	// Diagnostics are the errors with their codes and positions.
+	Diagnostics []diagnostic.Diagnostic
+}
+
+func newParseError(p *parser.Parser) *ParseError {
+	return &ParseError{Errors: p.Errors(), Diagnostics: p.Diagnostics()}
+}
+
+// Error returns the messages of the errors with their positions, e.g.
+// `parser errors: no prefix parse function for EOF found at line 1, col 4`.
+func (e *ParseError) Error() string {
+	if len(e.Diagnostics) == 0 {
+		return "parser errors: " + strings.Join(e.Errors, "; ")
+	}
+	errors := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		errors[i] = d.Describe()
+	}
+	return "parser errors: " + strings.Join(errors, "; ")
+}
+
+// RuntimeError is returned by Eval when evaluation stopped at an error object.
+// Its message includes the position of the error in the source code, if it's
+// known.
+type RuntimeError struct {
+	Err *object.Error
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Err.Describe()
+}
+
+// Eval evaluates the source code and returns the resulting object.
+func (i *Interpreter) Eval(src string) (object.Object, error) {
+	return i.EvalContext(context.Background(), src)
+}
+
+// EvalContext is like Eval but stops the evaluation once ctx is done.
+func (i *Interpreter) EvalContext(
+	ctx context.Context,
+	src string,
+) (object.Object, error) {
+	l := lexer.New(src)
+	p := parser.New(l)
+	p.SetVersion(i.version)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, newParseError(p)
+	}
+
+	ctx, cancel := i.context(ctx)
+	defer cancel()
+
+	return toResult(i.eval.EvalContext(ctx, program, i.env))
+}
+
+// context returns the context a single evaluation runs with.
+func (i *Interpreter) context(
+	ctx context.Context,
+) (context.Context, context.CancelFunc) {
+	if i.timeout > 0 {
+		return context.WithTimeout(ctx, i.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// toResult turns the object an evaluation resulted in into the results of
+// Eval.
+func toResult(result object.Object) (object.Object, error) {
+	if err, ok := result.(*object.Error); ok {
+		return nil, &RuntimeError{Err: err}
+	}
+	if result == nil {
+		// Statements such as `let` don't produce a value.
+		result = object.NULL
+	}
+
+	return result, nil
+}
+
+// Set binds name to the Go value v in the environment programs are evaluated
+// in, converted to an object with object.FromGoValue, so scripts can use it
+// like a value of their own:
+//
+//	i.Set("ports", []int{80, 443})
+//	i.Eval("len(ports)") // 2
+func (i *Interpreter) Set(name string, v interface{}) error {
+	obj, err := object.FromGoValue(v)
+	if err != nil {
+		return fmt.Errorf("interp: Set(%q): %s", name, err)
+	}
+	i.env.Set(name, obj)
+	return nil
+}
+
+// Get returns the value bound to name in the environment programs are
+// evaluated in, converted to the natural Go value for it as described by
+// object.ToGoValue, e.g. int64 for an integer. Objects that have no Go
+// counterpart, such as functions, are returned as they are. ok is false if
+// name isn't bound.
+func (i *Interpreter) Get(name string) (v interface{}, ok bool) {
+	obj, ok := i.env.Get(name)
+	if !ok {
+		return nil, false
+	}
+	if v, err := object.ToGoValue(obj); err == nil {
+		return v, true
+	}
+	return obj, true
+}
+
+// Environment returns the environment programs are evaluated in.
+func (i *Interpreter) Environment() *object.Environment {
+	return i.env
+}
@@ -0,0 +1,312 @@
+package interp
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/object"
+)
+
+func TestEval(t *testing.T) {
+	i := New()
+
+	val, err := i.Eval("let x = 1; x + 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Inspect() != "3" {
+		t.Errorf("wrong result. got=%s, want=3", val.Inspect())
+	}
+
+	// Bindings persist between calls.
+	val, err = i.Eval("x * 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Inspect() != "10" {
+		t.Errorf("wrong result. got=%s, want=10", val.Inspect())
+	}
+
+	val, err = i.Eval("let y = 2;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Type() != object.NULL_OBJ {
+		t.Errorf("let should evaluate to null. got=%s", val.Type())
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	i := New()
+
+	_, err := i.Eval("let = 5;")
+	if _, ok := err.(*ParseError); !ok {
+		t.Errorf("expected *ParseError. got=%T (%v)", err, err)
+	}
+	if !strings.Contains(err.Error(), "expected next token to be IDENT, got = instead at line 1, col 5") {
+		t.Errorf("parse error doesn't tell the position. got=%q", err)
+	}
+
+	_, err = i.Eval("1 + true")
+	rerr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError. got=%T (%v)", err, err)
+	}
+	if rerr.Error() != "type mismatch: INTEGER + BOOLEAN at line 1, col 3" {
+		t.Errorf("wrong error message. got=%q", rerr.Error())
+	}
+}
+
+func TestWithStdout(t *testing.T) {
+	var out bytes.Buffer
+	i := New(WithStdout(&out))
+
+	if _, err := i.Eval(`puts("hello", 42); print("a", 1, [2])`); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "hello\n42\na 1 [2]\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+}
+
+func TestWithStdin(t *testing.T) {
+	var out bytes.Buffer
+	i := New(WithStdin(strings.NewReader("hou\r\nlast")), WithStdout(&out))
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`input("name? ")`, "hou"},
+		{`input()`, "last"},
+		{`input()`, "null"},
+	}
+
+	for _, tt := range tests {
+		val, err := i.Eval(tt.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%q, got=%q",
+				tt.input, tt.expected, val.Inspect())
+		}
+	}
+	if out.String() != "name? " {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+}
+
+func TestWithEnvironment(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Set("answer", &object.Integer{Value: 42})
+
+	val, err := New(WithEnvironment(env)).Eval("answer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Inspect() != "42" {
+		t.Errorf("wrong result. got=%s, want=42", val.Inspect())
+	}
+}
+
+func TestSetAndGet(t *testing.T) {
+	i := New()
+
+	if err := i.Set("ports", []int{80, 443}); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Set("name", "hou"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.Eval(`let total = ports[0] + ports[1]; let greeting = "hi " + name; let double = fn(x) { x * 2 }`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expected interface{}
+	}{
+		{"total", int64(523)},
+		{"greeting", "hi hou"},
+		{"ports", []interface{}{int64(80), int64(443)}},
+	}
+
+	for _, tt := range tests {
+		v, ok := i.Get(tt.name)
+		if !ok {
+			t.Errorf("%s not found", tt.name)
+			continue
+		}
+		if !reflect.DeepEqual(v, tt.expected) {
+			t.Errorf("wrong value of %s. want=%#v, got=%#v", tt.name, tt.expected, v)
+		}
+	}
+
+	// Functions have no Go counterpart.
+	if v, _ := i.Get("double"); reflect.TypeOf(v) != reflect.TypeOf(&object.Function{}) {
+		t.Errorf("wrong value of double. got=%T", v)
+	}
+	if _, ok := i.Get("missing"); ok {
+		t.Errorf("missing should not be found")
+	}
+	if err := i.Set("ch", make(chan int)); err == nil {
+		t.Errorf("expected an error for a channel")
+	}
+}
+
+const infiniteLoop = `let loop = fn(n) { loop(n + 1) }; loop(0);`
+
+func TestWithMaxSteps(t *testing.T) {
+	_, err := New(WithMaxSteps(1000)).Eval(infiniteLoop)
+	if err == nil || !strings.Contains(err.Error(), "step limit exceeded") {
+		t.Errorf("expected step limit error. got=%v", err)
+	}
+}
+
+func TestWithMaxDepth(t *testing.T) {
+	_, err := New(WithMaxDepth(100)).Eval(infiniteLoop)
+	if err == nil || !strings.Contains(err.Error(), "stack overflow: max call depth 100 exceeded") {
+		t.Errorf("expected stack overflow error. got=%v", err)
+	}
+
+	result, err := New(WithMaxDepth(100)).Eval(`let f = fn(n) { if (n > 0) { f(n - 1) } else { n } }; f(99)`)
+	if err != nil || result.Inspect() != "0" {
+		t.Errorf("wrong result of recursion within the limit. got=%v (%v)", result, err)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	_, err := New(WithTimeout(10 * time.Millisecond)).Eval(infiniteLoop)
+	if err == nil || !strings.Contains(err.Error(), "evaluation cancelled") {
+		t.Errorf("expected cancellation error. got=%v", err)
+	}
+}
+
+func TestWithVersion(t *testing.T) {
+	if _, err := New().Eval("1 == true"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	_, err := New(WithVersion(2)).Eval("1 == true")
+	if err == nil || !strings.Contains(err.Error(), "type mismatch") {
+		t.Errorf("expected a type mismatch. got=%v", err)
+	}
+}
+
+func TestWithoutBuiltins(t *testing.T) {
+	_, err := New(WithoutBuiltins("puts")).Eval(`puts("hello")`)
+	if err == nil || err.Error() != "identifier not found: puts at line 1, col 1" {
+		t.Errorf("expected puts to be removed. got=%v", err)
+	}
+}
+
+func TestWithSandbox(t *testing.T) {
+	i := New(WithSandbox(evaluator.Sandbox{
+		DenyBuiltins: []string{"puts"},
+		MaxSteps:     50,
+	}))
+
+	if _, err := i.Eval(`len("abc")`); err != nil {
+		t.Fatal(err)
+	}
+	_, err := i.Eval(`puts("hi")`)
+	if err == nil || err.Error() != "builtin not allowed: puts at line 1, col 1" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+	_, err = i.Eval(`let f = fn(n) { f(n + 1) }; f(0)`)
+	if err == nil || err.Error() != "step limit exceeded: 50 steps at line 1, col 17" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+// TestConcurrentInterpreters runs separate Interpreters at the same time. Run
+// it with the race detector, `go test -race`, to check they share no state.
+func TestConcurrentInterpreters(t *testing.T) {
+	const n = 8
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for g := 0; g < n; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			var out bytes.Buffer
+			i := New(WithStdout(&out), WithMaxSteps(100000))
+			i.RegisterBuiltin("id", func(args ...object.Object) object.Object {
+				return &object.Integer{Value: int64(g)}
+			})
+
+			val, err := i.Eval(`
+				let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+				puts(id());
+				fib(15) + id()
+			`)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if val.Inspect() != fmt.Sprint(610+g) || out.String() != fmt.Sprintf("%d\n", g) {
+				errs <- fmt.Errorf("interpreter %d: wrong result %s, output %q",
+					g, val.Inspect(), out.String())
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestWithParallelism(t *testing.T) {
+	i := New(WithParallelism(1, 100), WithTimeout(5*time.Second))
+
+	val, err := i.Eval(`
+		let sum = fn(n) { if (n == 0) { 0 } else { n + sum(n - 1) } };
+		let tasks = [spawn(sum, 100), spawn(sum, 200), spawn(sum, 300)];
+		wait(tasks[0]) + wait(tasks[1]) + wait(tasks[2])
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Inspect() != "70300" {
+		t.Errorf("wrong result. got=%s", val.Inspect())
+	}
+}
+
+func TestWithWarnings(t *testing.T) {
+	deprecate := func(i *Interpreter) {
+		i.eval.SetBuiltin("old", &object.Builtin{
+			Fn:         func(args ...object.Object) object.Object { return evaluator.NULL },
+			Deprecated: "use new instead",
+		})
+	}
+
+	var stderr bytes.Buffer
+	i := New(WithStderr(&stderr), deprecate)
+	if _, err := i.Eval("old()"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr.String(), "warning: `old` is deprecated") {
+		t.Errorf("expected a deprecation warning. got=%q", stderr.String())
+	}
+
+	stderr.Reset()
+	i = New(WithStderr(&stderr), WithWarnings(evaluator.Warnings{
+		Disabled: map[evaluator.WarningCategory]bool{evaluator.DeprecatedWarnings: true},
+	}), deprecate)
+	if _, err := i.Eval("old()"); err != nil {
+		t.Fatal(err)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("expected no warnings. got=%q", stderr.String())
+	}
+}
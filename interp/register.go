@@ -0,0 +1,116 @@
+package interp
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+var (
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+	objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+)
+
+// RegisterBuiltin makes fn callable from scripts under name.
+func (i *Interpreter) RegisterBuiltin(name string, fn object.BuiltinFunction) {
+	i.eval.SetBuiltin(name, &object.Builtin{Fn: fn})
+}
+
+// RegisterFunc makes the ordinary Go function fn callable from scripts under
+// name, e.g:
+//
+//	i.RegisterFunc("clamp", func(x, lo, hi int64) int64 { ... })
+//
+// Arguments are converted from Hou objects to the parameter types of fn and
+// the results back into objects. A call with the wrong number of arguments or
+// with arguments that can't be converted returns an error object, and so does a
+// non-nil error returned as the last result of fn. Parameters and results of
+// type object.Object are passed through unconverted.
+func (i *Interpreter) RegisterFunc(name string, fn interface{}) error {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return fmt.Errorf("interp: RegisterFunc(%q): %T is not a function", name, fn)
+	}
+
+	t := v.Type()
+	results := t.NumOut()
+	returnsError := results > 0 && t.Out(results-1) == errorType
+	if returnsError {
+		results--
+	}
+	if results > 1 {
+		return fmt.Errorf("interp: RegisterFunc(%q): %s returns more than one value",
+			name, t)
+	}
+
+	i.RegisterBuiltin(name, func(args ...object.Object) object.Object {
+		in, err := convertArgs(t, args)
+		if err != nil {
+			return hostError(name, err)
+		}
+
+		out := v.Call(in)
+
+		if returnsError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return hostError(name, err)
+			}
+			out = out[:len(out)-1]
+		}
+		if len(out) == 0 {
+			return nullObject
+		}
+
+		result, err := fromValue(out[0])
+		if err != nil {
+			return hostError(name, err)
+		}
+		return result
+	})
+
+	return nil
+}
+
+// convertArgs converts the arguments of a call to the parameter types of the
+// function type t.
+func convertArgs(t reflect.Type, args []object.Object) ([]reflect.Value, error) {
+	params := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < params-1 {
+			return nil, fmt.Errorf("wrong number of arguments. got=%d, want>=%d",
+				len(args), params-1)
+		}
+	} else if len(args) != params {
+		return nil, fmt.Errorf("wrong number of arguments. got=%d, want=%d",
+			len(args), params)
+	}
+
+	in := make([]reflect.Value, len(args))
+	for n, arg := range args {
+		var pt reflect.Type
+		if t.IsVariadic() && n >= params-1 {
+			pt = t.In(params - 1).Elem()
+		} else {
+			pt = t.In(n)
+		}
+
+		v, err := toValue(arg, pt)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %s", n+1, err)
+		}
+		in[n] = v
+	}
+
+	return in, nil
+}
+
+// hostError returns the error object for the error of the Go function
+// registered as name.
+func hostError(name string, err error) *object.Error {
+	return &object.Error{
+		Code:    diagnostic.HostError,
+		Message: fmt.Sprintf("%s: %s", name, err),
+	}
+}
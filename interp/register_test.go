@@ -0,0 +1,109 @@
+package interp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cedrickchee/hou/object"
+)
+
+func TestRegisterFunc(t *testing.T) {
+	i := New()
+
+	funcs := map[string]interface{}{
+		"clamp": func(x, lo, hi int64) int64 {
+			if x < lo {
+				return lo
+			}
+			if x > hi {
+				return hi
+			}
+			return x
+		},
+		"repeat": strings.Repeat,
+		"sum": func(nums ...int) int {
+			total := 0
+			for _, n := range nums {
+				total += n
+			}
+			return total
+		},
+		"keys": func(m map[string]int) []string {
+			var keys []string
+			for k := range m {
+				keys = append(keys, k)
+			}
+			return keys
+		},
+		"check": func(ok bool) (string, error) {
+			if !ok {
+				return "", errors.New("not ok")
+			}
+			return "ok", nil
+		},
+		"typeOf":  func(obj object.Object) string { return string(obj.Type()) },
+		"nothing": func() {},
+	}
+	for name, fn := range funcs {
+		if err := i.RegisterFunc(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"clamp(15, 0, 10)", "10"},
+		{"clamp(-5, 0, 10)", "0"},
+		{`repeat("ab", 3)`, "ababab"},
+		{"sum()", "0"},
+		{"sum(1, 2, 3)", "6"},
+		{`keys({"a": 1})`, "[a]"},
+		{"check(true)", "ok"},
+		{"typeOf([1])", "ARRAY"},
+		{"nothing()", "null"},
+	}
+
+	for _, tt := range tests {
+		val, err := i.Eval(tt.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if val.Inspect() != tt.expected {
+			t.Errorf("%s: wrong result. got=%s, want=%s",
+				tt.input, val.Inspect(), tt.expected)
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"clamp(1, 2)", "clamp: wrong number of arguments. got=2, want=3 at line 1, col 1"},
+		{`clamp(1, 2, "3")`, "clamp: argument 3: cannot use STRING as int64 at line 1, col 1"},
+		{`sum(1, true)`, "sum: argument 2: cannot use BOOLEAN as int at line 1, col 1"},
+		{`keys({"a": "b"})`, "keys: argument 1: value of a: cannot use STRING as int at line 1, col 1"},
+		{"check(false)", "check: not ok at line 1, col 1"},
+	}
+
+	for _, tt := range errorTests {
+		_, err := i.Eval(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. got=%v, want=%q", tt.input, err, tt.expected)
+		}
+	}
+}
+
+func TestRegisterFuncInvalid(t *testing.T) {
+	i := New()
+
+	if err := i.RegisterFunc("notfunc", 42); err == nil {
+		t.Errorf("expected an error registering a non-function")
+	}
+	if err := i.RegisterFunc("two", func() (int, int) { return 1, 2 }); err == nil {
+		t.Errorf("expected an error registering a function with two results")
+	}
+}
@@ -0,0 +1,155 @@
+package lang
+
+// Package lang defines the versions of the Hou language and the features they
+// introduce, so that new keywords and changes of behavior don't break scripts
+// written for an older version.
+//
+// A script is read as the version its `#pragma version N` line names, or
+// else as the version the --lang flag names, or else as Default. The parser
+// records the FeatureSet of the version in the program, and the parser,
+// resolver and evaluator consult it for every feature that behaves
+// differently across versions.
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Version is a version of the language.
+type Version int
+
+const (
+	// Default is the version of scripts that don't name one: the language
+	// as it was before there were versions.
+	Default Version = 1
+	// Latest is the newest version.
+	Latest Version = 3
+)
+
+// ParseVersion parses the version s, e.g. "2".
+func ParseVersion(s string) (Version, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < int(Default) || n > int(Latest) {
+		return 0, fmt.Errorf("unknown language version %q, want %d to %d",
+			s, Default, Latest)
+	}
+	return Version(n), nil
+}
+
+// String returns the version as a number, e.g. "2".
+func (v Version) String() string {
+	return strconv.Itoa(int(v))
+}
+
+// Set sets the version to the one s names, which makes *Version a flag.Value,
+// e.g. of the --lang flag.
+func (v *Version) Set(s string) error {
+	parsed, err := ParseVersion(s)
+	if err != nil {
+		return err
+	}
+	*v = parsed
+	return nil
+}
+
+// Feature is a keyword or behavior that a version introduced.
+type Feature int
+
+// The features that came after Default.
+const (
+	// BlockScoping makes the blocks of if expressions scopes of their own:
+	// the names they bind with let aren't visible after the block.
+	BlockScoping Feature = iota
+	// StrictEquality makes comparing values of different types with == or !=
+	// an error instead of false, except for comparisons with null.
+	StrictEquality
+	// While adds the `while (condition) { ... }` loop, and makes `while` a
+	// keyword.
+	While
+	// ForIn adds the `for (x in collection) { ... }` loop, and makes `for`
+	// and `in` keywords.
+	ForIn
+	// NullLiteral adds the `null` literal, and makes `null` a keyword.
+	NullLiteral
+	// Match adds the `match (value) { case x: { ... } }` expression, and makes
+	// `match`, `case` and `default` keywords.
+	Match
+	// StrictArity makes calling a function with more arguments than it has
+	// parameters an error instead of ignoring the extra ones.
+	StrictArity
+	// Generators adds `yield`, which makes the function containing it a
+	// generator, and makes `yield` a keyword.
+	Generators
+)
+
+// features holds the names of the features and the versions that introduced
+// them.
+var features = []struct {
+	name  string
+	since Version
+}{
+	BlockScoping:   {"block scoping", 2},
+	StrictEquality: {"strict equality", 2},
+	While:          {"while loops", 2},
+	ForIn:          {"for loops", 2},
+	NullLiteral:    {"the null literal", 2},
+	Match:          {"match expressions", 2},
+	StrictArity:    {"strict arity", 3},
+	Generators:     {"generators", 3},
+}
+
+// String returns the name of the feature.
+func (f Feature) String() string {
+	return features[f].name
+}
+
+// Since returns the version that introduced the feature.
+func (f Feature) Since() Version {
+	return features[f].since
+}
+
+// keywords maps keywords to the features that introduced them. In versions
+// without the feature, the keyword is an identifier, so that scripts that use
+// it as a name keep working.
+var keywords = map[string]Feature{
+	"while":   While,
+	"for":     ForIn,
+	"in":      ForIn,
+	"null":    NullLiteral,
+	"match":   Match,
+	"case":    Match,
+	"default": Match,
+	"yield":   Generators,
+}
+
+// Keyword returns the feature that introduced the keyword, if a version after
+// Default did.
+func Keyword(keyword string) (Feature, bool) {
+	f, ok := keywords[keyword]
+	return f, ok
+}
+
+// FeatureSet is the set of features of a version. The zero FeatureSet is the
+// one of Default.
+type FeatureSet struct {
+	// since is the number of versions between Default and the version.
+	since Version
+}
+
+// For returns the features of the version.
+func For(v Version) FeatureSet {
+	if v < Default {
+		v = Default
+	}
+	return FeatureSet{since: v - Default}
+}
+
+// Version returns the version whose features the set holds.
+func (fs FeatureSet) Version() Version {
+	return Default + fs.since
+}
+
+// Has reports whether the set holds the feature.
+func (fs FeatureSet) Has(f Feature) bool {
+	return fs.Version() >= f.Since()
+}
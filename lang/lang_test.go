@@ -0,0 +1,70 @@
+package lang
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Version
+		ok       bool
+	}{
+		{"1", 1, true},
+		{"2", 2, true},
+		{"0", 0, false},
+		{"3", 3, true},
+		{"4", 0, false},
+		{"two", 0, false},
+	}
+
+	for _, tt := range tests {
+		v, err := ParseVersion(tt.input)
+		if (err == nil) != tt.ok || v != tt.expected {
+			t.Errorf("ParseVersion(%q) = %d, %v", tt.input, v, err)
+		}
+	}
+}
+
+func TestKeyword(t *testing.T) {
+	if f, ok := Keyword("while"); !ok || f != While {
+		t.Errorf("while isn't the keyword of While. got=%v, %v", f, ok)
+	}
+	for _, keyword := range []string{"for", "in"} {
+		if f, ok := Keyword(keyword); !ok || f != ForIn {
+			t.Errorf("%s isn't a keyword of ForIn. got=%v, %v", keyword, f, ok)
+		}
+	}
+	if f, ok := Keyword("null"); !ok || f != NullLiteral {
+		t.Errorf("null isn't the keyword of NullLiteral. got=%v, %v", f, ok)
+	}
+	for _, keyword := range []string{"match", "case", "default"} {
+		if f, ok := Keyword(keyword); !ok || f != Match {
+			t.Errorf("%s isn't a keyword of Match. got=%v, %v", keyword, f, ok)
+		}
+	}
+	if f, ok := Keyword("yield"); !ok || f != Generators {
+		t.Errorf("yield isn't the keyword of Generators. got=%v, %v", f, ok)
+	}
+	if _, ok := Keyword("let"); ok {
+		t.Errorf("let is a keyword of a later version")
+	}
+}
+
+func TestFeatureSet(t *testing.T) {
+	var zero FeatureSet
+	if zero.Version() != Default || zero != For(Default) {
+		t.Errorf("the zero FeatureSet isn't the one of Default")
+	}
+
+	for f := range features {
+		feature := Feature(f)
+		if zero.Has(feature) {
+			t.Errorf("Default has %s", feature)
+		}
+		if !For(Latest).Has(feature) {
+			t.Errorf("Latest doesn't have %s", feature)
+		}
+		if !For(feature.Since()).Has(feature) || For(feature.Since()-1).Has(feature) {
+			t.Errorf("%s wasn't introduced by version %d", feature, feature.Since())
+		}
+	}
+}
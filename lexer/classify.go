@@ -0,0 +1,143 @@
+package lexer
+
+import (
+	"unicode/utf8"
+
+	"github.com/cedrickchee/hou/token"
+)
+
+// Class is the class of a token for syntax highlighting. Editor plugins, the
+// REPL and `hou highlight` pick the color of tokens by their classes, so they
+// all agree on how Hou code looks.
+type Class string
+
+// The classes of tokens.
+const (
+	Keyword    Class = "keyword"    // fn, let, true, ... and pragmas
+	Identifier Class = "identifier" // names, including the ones of builtins
+	String     Class = "string"     // string literals, quotes included
+	Number     Class = "number"     // integer and float literals
+	Comment    Class = "comment"    // line and block comments
+	Operator   Class = "operator"   // +, ==, !, ...
+	Delimiter  Class = "delimiter"  // parentheses, braces, commas, ...
+	Illegal    Class = "illegal"    // characters Hou doesn't know about
+)
+
+// Span is a classified token of the source code.
+type Span struct {
+	Type  token.TokenType
+	Class Class
+	// Start and End are the byte offsets of the start of the token and of
+	// the byte after it, so src[Start:End] is the text of the token.
+	Start, End int
+	// Position is where the token starts.
+	token.Position
+}
+
+// Classify splits src into tokens and classifies them. The whitespace between
+// the spans is the only part of src they don't cover.
+func Classify(src string) []Span {
+	l := NewLazy([]byte(src))
+	l.comments = true
+
+	var spans []Span
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.EOF {
+			return spans
+		}
+		// The lexer stops right after the token it read.
+		end := l.position
+		if end > len(l.input) {
+			end = len(l.input)
+		}
+		spans = append(spans, Span{
+			Type:     tok.Type,
+			Class:    classOf(tok.Type),
+			Start:    tok.Offset,
+			End:      end,
+			Position: tok.Position,
+		})
+	}
+}
+
+// Tokenize splits src into tokens, trivia included: comments, and the
+// whitespace between tokens as WHITESPACE tokens, for editors and the REPL to
+// color src. Unlike the tokens of NextToken, the literal of every token is its
+// text in src, quotes of strings included, so the literals put together are
+// src.
+func Tokenize(src string) []token.Token {
+	var tokens []token.Token
+	pos := token.Position{Line: 1, Column: 1}
+	offset := 0
+	// whitespace adds the whitespace from offset to end, and moves past it.
+	whitespace := func(end int) {
+		if end > offset {
+			text := src[offset:end]
+			tokens = append(tokens, token.Token{
+				Type:     token.WHITESPACE,
+				Literal:  text,
+				Position: pos,
+				Offset:   offset,
+			})
+			pos = advance(pos, text)
+			offset = end
+		}
+	}
+
+	for _, span := range Classify(src) {
+		whitespace(span.Start)
+		text := src[span.Start:span.End]
+		tokens = append(tokens, token.Token{
+			Type:     span.Type,
+			Literal:  text,
+			Position: span.Position,
+			Offset:   span.Start,
+		})
+		pos = advance(span.Position, text)
+		offset = span.End
+	}
+	whitespace(len(src))
+	return tokens
+}
+
+// advance returns the position after text, which starts at pos.
+func advance(pos token.Position, text string) token.Position {
+	for len(text) > 0 {
+		r, size := utf8.DecodeRuneInString(text)
+		if r == '\n' {
+			pos.Line++
+			pos.Column = 1
+		} else {
+			pos.Column++
+		}
+		text = text[size:]
+	}
+	return pos
+}
+
+// classOf returns the class of tokens of type t.
+func classOf(t token.TokenType) Class {
+	switch t {
+	case token.IDENT:
+		return Identifier
+	case token.INT, token.FLOAT:
+		return Number
+	case token.STRING:
+		return String
+	case token.COMMENT:
+		return Comment
+	case token.ILLEGAL:
+		return Illegal
+	case token.ASSIGN, token.PLUS, token.MINUS, token.BANG, token.ASTERISK,
+		token.SLASH, token.PERCENT, token.POW, token.LT, token.GT, token.LT_EQ,
+		token.GT_EQ, token.EQ, token.NOT_EQ, token.ARROW, token.COALESCE,
+		token.PLUS_ASSIGN, token.MINUS_ASSIGN, token.ASTERISK_ASSIGN, token.SLASH_ASSIGN:
+		return Operator
+	case token.COMMA, token.SEMICOLON, token.COLON, token.DOT, token.LPAREN,
+		token.RPAREN, token.LBRACE, token.RBRACE, token.LBRACKET, token.RBRACKET:
+		return Delimiter
+	}
+	// All the other tokens are keywords.
+	return Keyword
+}
@@ -1,23 +1,142 @@
 package lexer
 
-import "github.com/cedrickchee/hou/token"
+import (
+	"bytes"
+	"io"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cedrickchee/hou/token"
+)
 
 // Package lexer implements the lexical analysis that is used to transform the
 // source code input into a stream of tokens for parsing by the parser.
-// The lexer only supports ASCII characters instead of the full Unicode range
-// for now to keep things simple.
+// The input is UTF-8: identifiers may use any Unicode letters, and strings
+// and comments any characters. The columns of positions count characters, but
+// the offsets of tokens count bytes.
+//
+// The lexer works on the bytes of the input and doesn't allocate for most
+// tokens: operators and delimiters share static literals and identifiers and
+// integers are interned, so only the first occurrence of a name allocates.
+//
+// A lexer made by NewFromReader reads its input in chunks as it needs them,
+// and drops the bytes of the tokens it has returned, so that a script doesn't
+// need to be in memory all at once and a stream can be lexed as it comes.
 
 // Lexer represents the lexer and contains the source input and internal state.
 type Lexer struct {
-	input        string
+	input        []byte
 	position     int  // current position in input (points to current char)
 	readPosition int  // current reading position in input (after current char)
-	ch           byte // current char under examination
+	ch           rune // current char under examination
+	line         int  // line of the current char
+	column       int  // column of the current char
+
+	// names interns the literals of identifiers and integers, so that every
+	// occurrence of a name shares one string. Since the literals don't point
+	// into the input, it can be freed once the tokens are read.
+	names map[string]string
+	// lazy is set for lexers that leave the literals of identifiers,
+	// integers and strings to Literal.
+	lazy bool
+	// comments is set for lexers that return comments as tokens rather
+	// than skipping them like whitespace.
+	comments bool
+
+	// reader is the rest of the input of a lexer made by NewFromReader,
+	// nil once it's read to the end, and input the part of it that's
+	// buffered, which starts at the offset base. err is the error reading
+	// it, other than io.EOF.
+	reader io.Reader
+	base   int
+	err    error
 }
 
+// readSize is the number of bytes a lexer made by NewFromReader reads at
+// once.
+const readSize = 4096
+
 // New returns a new Lexer.
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewAt(input, 1)
+}
+
+// NewAt returns a new Lexer for input that starts at the given line, e.g. a
+// line typed in the REPL that continues the lines typed before it.
+func NewAt(input string, line int) *Lexer {
+	return newLexer([]byte(input), line)
+}
+
+// NewBytes returns a new Lexer reading input, e.g. the contents of a file,
+// without copying it. input must not be modified while it's being read.
+func NewBytes(input []byte) *Lexer {
+	return newLexer(input, 1)
+}
+
+// NewLazy returns a new Lexer reading input like NewBytes, except that the
+// identifiers, keywords, integers and strings it returns have no Literal:
+// call Literal for the ones that are needed. That saves tools that look at
+// the types and offsets of tokens, like syntax highlighters, from making
+// strings they don't need.
+func NewLazy(input []byte) *Lexer {
+	l := newLexer(input, 1)
+	l.lazy = true
+	return l
+}
+
+// NewFromReader returns a new Lexer reading its input from r as it needs it,
+// e.g. a script piped to the interpreter. Reading stops at the first error,
+// as if the input ended there: Err returns it.
+func NewFromReader(r io.Reader) *Lexer {
+	l := &Lexer{reader: r, line: 1, names: map[string]string{}}
+	l.readChar()
+	return l
+}
+
+// Err returns the error reading the input of a lexer made by NewFromReader,
+// if any.
+func (l *Lexer) Err() error {
+	return l.err
+}
+
+// fill reads from the reader until at least n bytes past the current char are
+// buffered, or until the end of the input.
+func (l *Lexer) fill(n int) {
+	for l.reader != nil && len(l.input)-l.readPosition < n {
+		size := len(l.input)
+		if cap(l.input)-size < readSize {
+			input := make([]byte, size, 2*cap(l.input)+readSize)
+			copy(input, l.input)
+			l.input = input
+		}
+		read, err := l.reader.Read(l.input[size : size+readSize])
+		l.input = l.input[:size+read]
+		if err != nil {
+			if err != io.EOF {
+				l.err = err
+			}
+			l.reader = nil
+		}
+	}
+}
+
+// discard drops the buffered input before the current char, which the tokens
+// already returned were read from, once it's at least half of the buffer.
+// It's only called between tokens, since the ones being read refer to the
+// input by their offset in the buffer.
+func (l *Lexer) discard() {
+	if l.reader == nil || 2*l.position < len(l.input) {
+		return
+	}
+	n := copy(l.input, l.input[l.position:])
+	l.input = l.input[:n]
+	l.base += l.position
+	l.readPosition -= l.position
+	l.position = 0
+}
+
+func newLexer(input []byte, line int) *Lexer {
+	l := &Lexer{input: input, line: line, names: map[string]string{}}
 	l.readChar()
 	return l
 }
@@ -26,8 +145,35 @@ func New(input string) *Lexer {
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
+	l.discard()
 	l.skipWhitespace()
 
+	// Remember where the token starts, since reading it moves the lexer past
+	// it.
+	pos := token.Position{Line: l.line, Column: l.column}
+	start := l.position
+
+	// Comments are skipped like whitespace, unless the lexer returns them.
+	for l.ch == '/' && (l.peekChar() == '/' || l.peekChar() == '*') {
+		if !l.readComment() {
+			// The block comment is missing its closing */.
+			tok = token.Token{Type: token.ILLEGAL, Literal: "/*"}
+			l.locate(&tok, pos, start)
+			return tok
+		}
+		if l.comments {
+			tok.Type = token.COMMENT
+			if !l.lazy {
+				tok.Literal = string(l.input[start:l.offset()])
+			}
+			l.locate(&tok, pos, start)
+			return tok
+		}
+		l.skipWhitespace()
+		pos = token.Position{Line: l.line, Column: l.column}
+		start = l.position
+	}
+
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -35,9 +181,8 @@ func (l *Lexer) NextToken() token.Token {
 			// again. This way we don’t lose the current character and can
 			// safely advance the lexer so it leaves the NextToken() with
 			// l.position and l.readPosition in the correct state.
-			ch := l.ch
 			l.readChar()
-			tok = token.Token{Type: token.EQ, Literal: string(ch) + string(l.ch)}
+			tok = token.Token{Type: token.EQ, Literal: token.EQ}
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
@@ -45,6 +190,15 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.SEMICOLON, l.ch)
 	case ':':
 		tok = newToken(token.COLON, l.ch)
+	case '.':
+		tok = newToken(token.DOT, l.ch)
+	case '?':
+		if l.peekChar() == '?' {
+			l.readChar()
+			tok = token.Token{Type: token.COALESCE, Literal: token.COALESCE}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case '(':
 		tok = newToken(token.LPAREN, l.ch)
 	case ')':
@@ -52,32 +206,58 @@ func (l *Lexer) NextToken() token.Token {
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.readOperator(token.PLUS, token.PLUS_ASSIGN)
 	case '{':
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
 		tok = newToken(token.RBRACE, l.ch)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: token.ARROW}
+		} else {
+			tok = l.readOperator(token.MINUS, token.MINUS_ASSIGN)
+		}
 	case '!':
 		if l.peekChar() == '=' {
-			ch := l.ch
 			l.readChar()
-			tok = token.Token{Type: token.NOT_EQ, Literal: string(ch) + string(l.ch)}
+			tok = token.Token{Type: token.NOT_EQ, Literal: token.NOT_EQ}
 		} else {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		tok = l.readOperator(token.SLASH, token.SLASH_ASSIGN)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '*' {
+			l.readChar()
+			tok = token.Token{Type: token.POW, Literal: token.POW}
+		} else {
+			tok = l.readOperator(token.ASTERISK, token.ASTERISK_ASSIGN)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		tok = l.readOperator(token.LT, token.LT_EQ)
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		tok = l.readOperator(token.GT, token.GT_EQ)
 	case '"':
 		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		str := l.readString()
+		if !l.lazy {
+			tok.Literal = string(str)
+		}
+	case '#':
+		l.fill(len("#pragma"))
+		if !bytes.HasPrefix(l.input[l.position:], []byte("#pragma")) {
+			tok = newToken(token.ILLEGAL, l.ch)
+			break
+		}
+		// A pragma runs to the end of the line. Its literal is what follows
+		// `#pragma`, e.g. `version 2`.
+		tok.Type = token.PRAGMA
+		tok.Literal = string(bytes.TrimSpace(l.readLine()[len("#pragma"):]))
+		l.locate(&tok, pos, start)
+		return tok
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
@@ -87,61 +267,177 @@ func (l *Lexer) NextToken() token.Token {
 		tok.Type = token.EOF
 	default:
 		if isLetter(l.ch) {
-			tok.Literal = l.readIdentifier()
-			tok.Type = token.LookupIdent(tok.Literal)
+			ident := l.readIdentifier()
+			tok.Type = token.LookupIdent(string(ident))
+			if string(ident) == "spawn" && l.beforeFunction() {
+				tok.Type = token.SPAWN
+			}
+			if !l.lazy {
+				tok.Literal = l.intern(ident)
+			}
+			l.locate(&tok, pos, start)
 			// Early exit here. We don't need the call to readChar() below.
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			number, typ := l.readNumber()
+			tok.Type = typ
+			if !l.lazy {
+				tok.Literal = l.intern(number)
+			}
+			l.locate(&tok, pos, start)
 			return tok
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			// The character as it is in the input, even if it isn't valid
+			// UTF-8.
+			tok.Type = token.ILLEGAL
+			tok.Literal = string(l.input[l.position:l.readPosition])
 		}
 	}
 
 	l.readChar()
+	l.locate(&tok, pos, start)
 	return tok
 }
 
+// readOperator returns a token of the type assign, e.g. +=, if the current
+// char is followed by =, and a token of the type op otherwise.
+func (l *Lexer) readOperator(op, assign token.TokenType) token.Token {
+	if l.peekChar() == '=' {
+		l.readChar()
+		return token.Token{Type: assign, Literal: string(assign)}
+	}
+	return newToken(op, l.ch)
+}
+
+// locate sets the position of tok, which started at the offset start in the
+// buffered input.
+func (l *Lexer) locate(tok *token.Token, pos token.Position, start int) {
+	tok.Position = pos
+	// Past the end of the input, the position keeps growing.
+	if start > len(l.input) {
+		start = len(l.input)
+	}
+	tok.Offset = l.base + start
+}
+
+// Literal returns the literal of tok, which the lexer returned, whether it's
+// lazy or not.
+func (l *Lexer) Literal(tok token.Token) string {
+	offset := tok.Offset - l.base
+	if tok.Literal != "" || offset < 0 || offset >= len(l.input) {
+		return tok.Literal
+	}
+	text := l.input[offset:]
+	switch {
+	case tok.Type == token.STRING:
+		// Up to the closing quote, if the string isn't missing it.
+		text = text[1:]
+		if end := bytes.IndexByte(text, '"'); end >= 0 {
+			text = text[:end]
+		}
+		return string(text)
+	case tok.Type == token.COMMENT:
+		end := len(text)
+		if bytes.HasPrefix(text, []byte("//")) {
+			if i := bytes.IndexByte(text, '\n'); i >= 0 {
+				end = i
+			}
+		} else if i := bytes.Index(text[2:], []byte("*/")); i >= 0 {
+			end = 2 + i + 2
+		}
+		return string(text[:end])
+	case tok.Type == token.INT, tok.Type == token.FLOAT:
+		n, _ := scanNumber(text)
+		return l.intern(text[:n])
+	case startsWith(text, isLetter):
+		// Identifiers and keywords.
+		return l.intern(text[:span(text, isLetter)])
+	}
+	return tok.Literal
+}
+
+// span returns the length in bytes of the prefix of text made of characters in
+// the class.
+func span(text []byte, class func(rune) bool) int {
+	n := 0
+	for n < len(text) {
+		ch, size := rune(text[n]), 1
+		if ch >= utf8.RuneSelf {
+			ch, size = utf8.DecodeRune(text[n:])
+		}
+		if !class(ch) {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+// startsWith reports whether text starts with a character in the class.
+func startsWith(text []byte, class func(rune) bool) bool {
+	return span(text, class) > 0
+}
+
 // Helper method to make the usage of these lexer fields easier to understand.
 // It gives us the next character and advance our position in the input string.
 func (l *Lexer) readChar() {
+	// Keep track of the line and column of the character we're about to
+	// read. A newline ends the line of the character before it.
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	if l.reader != nil {
+		l.fill(utf8.UTFMax)
+	}
+	if l.readPosition <= len(l.input) {
+		l.column++
+	}
+
 	// First, check whether we've reached the end of input.
+	size := 1
 	if l.readPosition >= len(l.input) {
 		// 0 is the ASCII code for the "NUL" character and signifies either
 		// "we haven't read anything yet" or "end of file".
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, size = decodeChar(l.input[l.readPosition:])
 	}
 	// After that, l.readPosition always point to the next position where we're
 	// going to read from next and l.position always points to the position
-	// where we last read.
+	// where we last read. Characters outside of ASCII are several bytes wide.
 	l.position = l.readPosition
-	l.readPosition++
+	l.readPosition += size
+}
 
-	// Note: Unicode support
-	// ---------------------
-	// In order to fully support Unicode and UTF-8 we would need to change l.ch
-	// from a byte to rune and change the way we read the next characters,
-	// since they could be multiple bytes wide now.
+// decodeChar returns the character text starts with and its width in bytes.
+// A byte that isn't valid UTF-8 is a character of its own, utf8.RuneError.
+func decodeChar(text []byte) (rune, int) {
+	if text[0] < utf8.RuneSelf {
+		// ASCII, which most source code is.
+		return rune(text[0]), 1
+	}
+	return utf8.DecodeRune(text)
 }
 
 // peekChar is similar to readChar except that it doesn’t increment l.position
 // and l.readPosition.
 // We only want to “peek” ahead in the input and not move around in it, so we
 // know what a call to readChar would return.
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
+	if l.reader != nil {
+		l.fill(utf8.UTFMax)
+	}
 	if l.readPosition >= len(l.input) {
 		return 0
 	}
-	return l.input[l.readPosition]
+	ch, _ := decodeChar(l.input[l.readPosition:])
+	return ch
 }
 
 // Reads in an identifier and advances our lexer’s positions until it encounters
 // a non-letter-character.
-func (l *Lexer) readIdentifier() string {
+func (l *Lexer) readIdentifier() []byte {
 	position := l.position
 	for isLetter(l.ch) {
 		l.readChar()
@@ -149,15 +445,132 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// beforeFunction reports whether the current char starts the `fn` keyword,
+// after whitespace, e.g. after the spawn of `spawn fn() { ... }`.
+func (l *Lexer) beforeFunction() bool {
+	i := l.position
+	for {
+		if l.reader != nil {
+			l.fill(i - l.readPosition + len("fn") + utf8.UTFMax)
+		}
+		if i >= len(l.input) || !isSpace(l.input[i]) {
+			break
+		}
+		i++
+	}
+	rest := l.input[i:]
+	if !bytes.HasPrefix(rest, []byte("fn")) {
+		return false
+	}
+	if len(rest) == len("fn") {
+		return true
+	}
+	ch, _ := decodeChar(rest[len("fn"):])
+	return !isLetter(ch)
+}
+
+// readNumber reads in an integer or a float and advances our lexer's
+// positions past it. It returns the number and its type, token.INT or
+// token.FLOAT.
+func (l *Lexer) readNumber() ([]byte, token.TokenType) {
 	position := l.position
-	for isDigit(l.ch) {
+	n, typ := scanNumber(l.input[position:])
+	// The two characters after a number decide whether it goes on, e.g.
+	// `1.5` or `1e+9`, so they must be buffered.
+	for l.reader != nil && position+n+2 >= len(l.input) {
+		l.fill(len(l.input) - l.readPosition + readSize)
+		n, typ = scanNumber(l.input[position:])
+	}
+	for l.position < position+n {
 		l.readChar()
 	}
+	return l.input[position:l.position], typ
+}
+
+// scanNumber returns the length of the number text starts with, and whether
+// it's an integer or a float. A float has a fraction, an exponent or both,
+// e.g. `3.14`, `1e9` or `6.02e+23`. A dot or an `e` that isn't followed by
+// digits isn't part of the number, so `1.` is the integer 1 and a dot.
+func scanNumber(text []byte) (int, token.TokenType) {
+	var typ token.TokenType = token.INT
+	n := span(text, isDigit)
+	if n+1 < len(text) && text[n] == '.' && isDigit(rune(text[n+1])) {
+		n++
+		n += span(text[n:], isDigit)
+		typ = token.FLOAT
+	}
+	if n < len(text) && (text[n] == 'e' || text[n] == 'E') {
+		exp := n + 1
+		if exp < len(text) && (text[exp] == '+' || text[exp] == '-') {
+			exp++
+		}
+		if digits := span(text[exp:], isDigit); digits > 0 {
+			n = exp + digits
+			typ = token.FLOAT
+		}
+	}
+	return n, typ
+}
+
+// intern returns the string holding the bytes b, the same one every time.
+func (l *Lexer) intern(b []byte) string {
+	// The compiler doesn't allocate for the conversion of b when it's only
+	// used to look up a key.
+	if s, ok := l.names[string(b)]; ok {
+		return s
+	}
+	s := string(b)
+	l.names[s] = s
+	return s
+}
+
+// offset returns the offset of the current char, which is the length of the
+// input past its end.
+func (l *Lexer) offset() int {
+	if l.position > len(l.input) {
+		return len(l.input)
+	}
+	return l.position
+}
+
+// readComment reads a comment, either a line comment from // to the end of
+// the line, or a block comment from /* to the next */. It returns false if
+// a block comment is missing its closing */, after reading to the end of the
+// input.
+func (l *Lexer) readComment() bool {
+	if l.peekChar() == '/' {
+		l.readLine()
+		return true
+	}
+
+	// Skip the /* so that the * can't also start the closing */, e.g. in /*/.
+	l.readChar()
+	l.readChar()
+	for l.ch != 0 {
+		if l.ch == '*' && l.peekChar() == '/' {
+			l.readChar()
+			l.readChar()
+			return true
+		}
+		l.readChar()
+	}
+	return false
+}
+
+// readLine reads up to the end of the line, and returns the line without the
+// newline.
+func (l *Lexer) readLine() []byte {
+	position := l.position
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+	if l.position > len(l.input) {
+		return l.input[position:]
+	}
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readString() string {
+func (l *Lexer) readString() []byte {
 	position := l.position + 1
 	for {
 		// Call readChar until it encounters either a closing double quote or
@@ -167,6 +580,10 @@ func (l *Lexer) readString() string {
 			break
 		}
 	}
+	if l.position > len(l.input) {
+		// The string is missing its closing quote.
+		return l.input[position:]
+	}
 	return l.input[position:l.position]
 }
 
@@ -180,16 +597,35 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
-	return token.Token{Type: tokenType, Literal: string(ch)}
+// isSpace reports whether the byte is whitespace that skipWhitespace skips.
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// newToken returns a token of the single ASCII character ch.
+func newToken(tokenType token.TokenType, ch rune) token.Token {
+	return token.Token{Type: tokenType, Literal: charLiterals[ch]}
 }
 
-// Helper function just checks whether the given argument is a letter.
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// charLiterals holds the literals of single-character tokens, so that they
+// don't need to be allocated for every token.
+var charLiterals = func() (literals [utf8.RuneSelf]string) {
+	for i := range literals {
+		literals[i] = string([]byte{byte(i)})
+	}
+	return literals
+}()
+
+// Helper function just checks whether the given argument is a letter: an
+// underscore or a letter of any script, e.g. `x`, `é` or `名`.
+func isLetter(ch rune) bool {
+	if ch < utf8.RuneSelf {
+		return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+	}
+	return unicode.IsLetter(ch)
 }
 
-// isDigit returns whether the passed in byte is a Latin digit between 0 and 9.
-func isDigit(ch byte) bool {
+// isDigit returns whether the passed in char is a Latin digit between 0 and 9.
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
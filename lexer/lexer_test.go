@@ -1,7 +1,11 @@
 package lexer
 
 import (
+	"errors"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/cedrickchee/hou/token"
 )
@@ -17,7 +21,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -32,7 +36,18 @@ if (5 < 10) {
 "foo bar"
 [1, 2];
 {"foo": "bar"}
-`
+yield x;
+fn(x) -> Int
+x += 1 -= *= /=-1
+a <= b >= c % 2 ** 3 ***
+3.14 1e9 6.02E+23 2.5e-3 1. 2e person.name
+null ?? x ?
+4 / 2; // a line comment
+/* a block
+comment */ 1 /*/ still a comment */ "/* not a comment */"
+#pragma version 2
+#
+/* unterminated`
 
 	tests := []struct {
 		expectedType    token.TokenType
@@ -124,6 +139,58 @@ if (5 < 10) {
 		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
+		{token.YIELD, "yield"},
+		{token.IDENT, "x"},
+		{token.SEMICOLON, ";"},
+		{token.FUNCTION, "fn"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.ARROW, "->"},
+		{token.IDENT, "Int"},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.SLASH_ASSIGN, "/="},
+		{token.MINUS, "-"},
+		{token.INT, "1"},
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.PERCENT, "%"},
+		{token.INT, "2"},
+		{token.POW, "**"},
+		{token.INT, "3"},
+		{token.POW, "**"},
+		{token.ASTERISK, "*"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e9"},
+		{token.FLOAT, "6.02E+23"},
+		{token.FLOAT, "2.5e-3"},
+		{token.INT, "1"},
+		{token.DOT, "."},
+		{token.INT, "2"},
+		{token.IDENT, "e"},
+		{token.IDENT, "person"},
+		{token.DOT, "."},
+		{token.IDENT, "name"},
+		{token.NULL, "null"},
+		{token.COALESCE, "??"},
+		{token.IDENT, "x"},
+		{token.ILLEGAL, "?"},
+		{token.INT, "4"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.INT, "1"},
+		{token.STRING, "/* not a comment */"},
+		{token.PRAGMA, "version 2"},
+		{token.ILLEGAL, "#"},
+		{token.ILLEGAL, "/*"},
 		{token.EOF, ""},
 	}
 
@@ -143,3 +210,367 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+if (x == 10) {
+	"foo bar"
+}`
+
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"let", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"5", 1, 9},
+		{";", 1, 10},
+		{"if", 2, 1},
+		{"(", 2, 4},
+		{"x", 2, 5},
+		{"==", 2, 7},
+		{"10", 2, 10},
+		{")", 2, 12},
+		{"{", 2, 14},
+		{"foo bar", 3, 2},
+		{"}", 4, 1},
+		{"", 4, 2},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Line != tt.expectedLine || tok.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position of %q wrong. expected=%d:%d, got=%d:%d",
+				i, tok.Literal, tt.expectedLine, tt.expectedColumn,
+				tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestNewAt(t *testing.T) {
+	l := NewAt("x\n  y", 7)
+
+	for _, expected := range []token.Position{{Line: 7, Column: 1}, {Line: 8, Column: 3}} {
+		tok := l.NextToken()
+		if tok.Position != expected {
+			t.Errorf("position of %q wrong. expected=%v, got=%v",
+				tok.Literal, expected, tok.Position)
+		}
+	}
+}
+
+func TestLazyLiterals(t *testing.T) {
+	input := `let five = 5 == "five"; // five
+let café = "naïve"; // ünïcode
+/* six */ 6.5e1 /* seven
+"unterminated`
+
+	// With comments, so that their literals are tested too.
+	eager := New(input)
+	eager.comments = true
+	lazy := NewLazy([]byte(input))
+	lazy.comments = true
+	for {
+		expected := eager.NextToken()
+		tok := lazy.NextToken()
+
+		if tok.Offset != expected.Offset {
+			t.Fatalf("wrong offset of %q. want=%d, got=%d",
+				expected.Literal, expected.Offset, tok.Offset)
+		}
+		switch tok.Type {
+		case token.LET, token.IDENT, token.INT, token.FLOAT, token.STRING, token.COMMENT:
+			if tok.Literal != "" {
+				t.Errorf("literal of %s is not lazy. got=%q", tok.Type, tok.Literal)
+			}
+		}
+		if literal := lazy.Literal(tok); literal != expected.Literal {
+			t.Errorf("wrong literal. want=%q, got=%q", expected.Literal, literal)
+		}
+		if tok.Type == token.EOF {
+			break
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let café = \"naïve 🙂\";\n名前 + é_x \u00a7 \xff"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedColumn  int
+		expectedOffset  int
+	}{
+		{token.LET, "let", 1, 0},
+		{token.IDENT, "café", 5, 4},
+		{token.ASSIGN, "=", 10, 10},
+		{token.STRING, "naïve 🙂", 12, 12},
+		{token.SEMICOLON, ";", 21, 25},
+		// Columns count characters, and offsets bytes.
+		{token.IDENT, "名前", 1, 27},
+		{token.PLUS, "+", 4, 34},
+		{token.IDENT, "é_x", 6, 36},
+		// Characters that aren't letters, or aren't valid UTF-8, are illegal.
+		{token.ILLEGAL, "\u00a7", 10, 41},
+		{token.ILLEGAL, "\xff", 12, 44},
+		{token.EOF, "", 13, 45},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%s %q, got=%s %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Column != tt.expectedColumn || tok.Offset != tt.expectedOffset {
+			t.Errorf("tests[%d] - wrong position of %q. expected=col %d at %d, got=col %d at %d",
+				i, tok.Literal, tt.expectedColumn, tt.expectedOffset, tok.Column, tok.Offset)
+		}
+	}
+}
+
+func TestNewFromReader(t *testing.T) {
+	input := `#pragma version 2
+let café = "naïve 🙂"; // ünïcode
+/* six */ 6.5e1 + 12345678901234 ** x;
+let 名前 = fn(a, b = 10) { a >= b != !true };
+`
+	// Long enough for the lexer to read it in several chunks, and to drop
+	// the ones it's done with.
+	input = strings.Repeat(input, 200) + `"unterminated`
+
+	readers := map[string]io.Reader{
+		"reader":       strings.NewReader(input),
+		"one byte":     iotest.OneByteReader(strings.NewReader(input)),
+		"half reader":  iotest.HalfReader(strings.NewReader(input)),
+		"data and EOF": iotest.DataErrReader(strings.NewReader(input)),
+	}
+	for name, r := range readers {
+		expected := New(input)
+		expected.comments = true
+		l := NewFromReader(r)
+		l.comments = true
+		for {
+			want := expected.NextToken()
+			tok := l.NextToken()
+			if tok != want {
+				t.Fatalf("%s: wrong token. want=%+v, got=%+v", name, want, tok)
+			}
+			if literal := l.Literal(tok); literal != want.Literal {
+				t.Fatalf("%s: wrong literal. want=%q, got=%q", name, want.Literal, literal)
+			}
+			if tok.Type == token.EOF {
+				break
+			}
+		}
+		if l.Err() != nil {
+			t.Errorf("%s: unexpected error %v", name, l.Err())
+		}
+		if cap(l.input) >= len(input) {
+			t.Errorf("%s: the input wasn't dropped. buffered %d bytes of %d",
+				name, cap(l.input), len(input))
+		}
+	}
+
+	// An error ends the input.
+	errRead := errors.New("read failed")
+	l := NewFromReader(io.MultiReader(strings.NewReader("let x"), errReader{errRead}))
+	for _, want := range []token.TokenType{token.LET, token.IDENT, token.EOF} {
+		if tok := l.NextToken(); tok.Type != want {
+			t.Errorf("wrong token. want=%s, got=%s", want, tok.Type)
+		}
+	}
+	if l.Err() != errRead {
+		t.Errorf("wrong error. want=%v, got=%v", errRead, l.Err())
+	}
+}
+
+// errReader is a reader that fails with its error.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestTokenOffsets(t *testing.T) {
+	input := "let s =\n  \"hi\";"
+	expected := []int{0, 4, 6, 10, 14, 15}
+
+	l := New(input)
+	for i, want := range expected {
+		tok := l.NextToken()
+		if tok.Offset != want {
+			t.Errorf("tests[%d] - wrong offset of %q. want=%d, got=%d",
+				i, tok.Literal, want, tok.Offset)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	input := "let s = \"hi\"; // greet\nif (!x) { /* call */ f(10) } @\"open"
+
+	expected := []struct {
+		text  string
+		class Class
+		line  int
+	}{
+		{"let", Keyword, 1},
+		{"s", Identifier, 1},
+		{"=", Operator, 1},
+		{`"hi"`, String, 1},
+		{";", Delimiter, 1},
+		{"// greet", Comment, 1},
+		{"if", Keyword, 2},
+		{"(", Delimiter, 2},
+		{"!", Operator, 2},
+		{"x", Identifier, 2},
+		{")", Delimiter, 2},
+		{"{", Delimiter, 2},
+		{"/* call */", Comment, 2},
+		{"f", Identifier, 2},
+		{"(", Delimiter, 2},
+		{"10", Number, 2},
+		{")", Delimiter, 2},
+		{"}", Delimiter, 2},
+		{"@", Illegal, 2},
+		// A string missing its closing quote ends with the input.
+		{`"open`, String, 2},
+	}
+
+	spans := Classify(input)
+	if len(spans) != len(expected) {
+		t.Fatalf("wrong number of spans. want=%d, got=%d", len(expected), len(spans))
+	}
+	for i, want := range expected {
+		span := spans[i]
+		if text := input[span.Start:span.End]; text != want.text {
+			t.Errorf("spans[%d] - wrong text. want=%q, got=%q", i, want.text, text)
+		}
+		if span.Class != want.class {
+			t.Errorf("spans[%d] - wrong class of %q. want=%s, got=%s",
+				i, want.text, want.class, span.Class)
+		}
+		if span.Line != want.line {
+			t.Errorf("spans[%d] - wrong line of %q. want=%d, got=%d",
+				i, want.text, want.line, span.Line)
+		}
+	}
+}
+
+func TestSpawnKeyword(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected token.TokenType
+	}{
+		{"spawn fn() {}", token.SPAWN},
+		{"spawn\n\tfn() {}", token.SPAWN},
+		{"spawn(f)", token.IDENT},
+		{"spawn fns", token.IDENT},
+		{"spawn", token.IDENT},
+	}
+
+	for _, tt := range tests {
+		tok := New(tt.input).NextToken()
+		if tok.Type != tt.expected || tok.Literal != "spawn" {
+			t.Errorf("wrong token of %q. want=%s, got=%s %q",
+				tt.input, tt.expected, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	input := "let é = \"a\nb\"; // c\n\t/* d */ f(1.5)\n"
+
+	expected := []token.Token{
+		{Type: token.LET, Literal: "let", Position: token.Position{Line: 1, Column: 1}, Offset: 0},
+		{Type: token.WHITESPACE, Literal: " ", Position: token.Position{Line: 1, Column: 4}, Offset: 3},
+		{Type: token.IDENT, Literal: "é", Position: token.Position{Line: 1, Column: 5}, Offset: 4},
+		{Type: token.WHITESPACE, Literal: " ", Position: token.Position{Line: 1, Column: 6}, Offset: 6},
+		{Type: token.ASSIGN, Literal: "=", Position: token.Position{Line: 1, Column: 7}, Offset: 7},
+		{Type: token.WHITESPACE, Literal: " ", Position: token.Position{Line: 1, Column: 8}, Offset: 8},
+		{Type: token.STRING, Literal: "\"a\nb\"", Position: token.Position{Line: 1, Column: 9}, Offset: 9},
+		{Type: token.SEMICOLON, Literal: ";", Position: token.Position{Line: 2, Column: 3}, Offset: 14},
+		{Type: token.WHITESPACE, Literal: " ", Position: token.Position{Line: 2, Column: 4}, Offset: 15},
+		{Type: token.COMMENT, Literal: "// c", Position: token.Position{Line: 2, Column: 5}, Offset: 16},
+		{Type: token.WHITESPACE, Literal: "\n\t", Position: token.Position{Line: 2, Column: 9}, Offset: 20},
+		{Type: token.COMMENT, Literal: "/* d */", Position: token.Position{Line: 3, Column: 2}, Offset: 22},
+		{Type: token.WHITESPACE, Literal: " ", Position: token.Position{Line: 3, Column: 9}, Offset: 29},
+		{Type: token.IDENT, Literal: "f", Position: token.Position{Line: 3, Column: 10}, Offset: 30},
+		{Type: token.LPAREN, Literal: "(", Position: token.Position{Line: 3, Column: 11}, Offset: 31},
+		{Type: token.FLOAT, Literal: "1.5", Position: token.Position{Line: 3, Column: 12}, Offset: 32},
+		{Type: token.RPAREN, Literal: ")", Position: token.Position{Line: 3, Column: 15}, Offset: 35},
+		{Type: token.WHITESPACE, Literal: "\n", Position: token.Position{Line: 3, Column: 16}, Offset: 36},
+	}
+
+	tokens := Tokenize(input)
+	if len(tokens) != len(expected) {
+		t.Fatalf("wrong number of tokens. want=%d, got=%d: %v", len(expected), len(tokens), tokens)
+	}
+	var text strings.Builder
+	for i, tok := range tokens {
+		if tok != expected[i] {
+			t.Errorf("tokens[%d] wrong. want=%+v, got=%+v", i, expected[i], tok)
+		}
+		text.WriteString(tok.Literal)
+	}
+	if text.String() != input {
+		t.Errorf("the literals aren't the input. got=%q", text.String())
+	}
+
+	if tokens := Tokenize(""); len(tokens) != 0 {
+		t.Errorf("expected no tokens for empty input, got %v", tokens)
+	}
+}
+
+// benchmarkInput is a program that repeats the same identifiers, keywords and
+// numbers over and over, like real programs do.
+var benchmarkInput = strings.Repeat(`
+let fib = fn(n) {
+	if (n < 2) { return n; }
+	fib(n - 1) + fib(n - 2)
+};
+let names = ["alice", "bob"];
+puts(len(names) == 2 != false);
+`, 100)
+
+func BenchmarkNextToken(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkInput)))
+	for i := 0; i < b.N; i++ {
+		l := New(benchmarkInput)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		}
+	}
+}
+
+func BenchmarkNextTokenBytes(b *testing.B) {
+	input := []byte(benchmarkInput)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	for i := 0; i < b.N; i++ {
+		l := NewBytes(input)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		}
+	}
+}
+
+func BenchmarkNextTokenLazy(b *testing.B) {
+	input := []byte(benchmarkInput)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	for i := 0; i < b.N; i++ {
+		l := NewLazy(input)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		}
+	}
+}
@@ -1,22 +1,150 @@
 package main
 
-// Package main implements the main process which invokes the interpreter's
-// REPL and waits for user input before lexing, parsing nad evaulating.
+// Package main implements hou, the command that runs the interpreter. Without
+// a script, it starts the REPL, which waits for user input before lexing,
+// parsing and evaluating it. Its subcommands are:
+//
+//	hou [--no-color] [--lang=n]       start the REPL
+//	hou [flags] script [arg ...]      short for hou run
+//	hou run [flags] script [arg ...]  evaluate a .hou or .houc script
+//	hou bench [flags] script          time a script
+//	hou check [flags] script          check the type annotations of a script
+//	hou test [flags] [path ...]       run the tests of _test.hou files
+//	hou fmt [flags] script            format a script
+//	hou build [flags] script          compile a script ahead of time
+//	hou highlight [flags] script      highlight a script
+//	hou attach socket [command]       inspect an embedded interpreter
+//
+// `hou <command> -h` lists the flags of a command. The REPL colors its output
+// in a terminal, unless --no-color is given or NO_COLOR is set.
 
 import (
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/user"
 
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/lexer"
+	"github.com/cedrickchee/hou/parser"
 	"github.com/cedrickchee/hou/repl"
 )
 
+// commands are the subcommands of `hou`, by name.
+var commands = map[string]func(args []string) int{
+	"run":       run,
+	"bench":     bench,
+	"build":     build,
+	"check":     check,
+	"test":      test,
+	"fmt":       formatFile,
+	"highlight": highlightFile,
+	"attach":    attach,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
+	// `hou script.hou` is short for `hou run script.hou`, with the same
+	// flags, e.g. `hou --engine vm script.hou`, which are parsed first, so
+	// that their values aren't taken for the script. The arguments after
+	// the script may look like flags, e.g. `hou script.hou -v`.
+	fs, runScript := runCommand("hou")
+	noColor := fs.Bool("no-color", false, "don't color the output of the REPL")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: hou [--no-color] [--lang=n]\n")
+		fmt.Fprintf(fs.Output(), "       hou [run flags] script.hou|script.houc [arg ...]\n")
+		fmt.Fprintf(fs.Output(), "       hou run|bench|build|check|test|fmt|highlight|attach [flags] ...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+	if fs.NArg() > 0 {
+		if _, ok := commands[fs.Arg(0)]; ok {
+			fmt.Fprintf(os.Stderr, "hou: flags go after the subcommand: hou %s [flags]\n", fs.Arg(0))
+			os.Exit(2)
+		}
+		if *noColor {
+			fmt.Fprintln(os.Stderr, "hou: --no-color is a flag of the REPL, not of scripts")
+			os.Exit(2)
+		}
+		os.Exit(runScript())
+	}
+	// The REPL reads the inputs as the version --lang names.
+	replFlags := true
+	fs.Visit(func(f *flag.Flag) {
+		replFlags = replFlags && (f.Name == "no-color" || f.Name == "lang")
+	})
+	if !replFlags {
+		fmt.Fprintln(os.Stderr, "hou: only --no-color and --lang can be given without a script")
+		fs.Usage()
+		os.Exit(2)
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
 	fmt.Fprintf(os.Stdout, "Hello %s! This is the Hou programming language!\n", user.Username)
-	fmt.Fprintf(os.Stdout, "Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	fmt.Fprintf(os.Stdout, "Feel free to type in commands, or :help for the commands of the REPL\n")
+	opts := repl.DefaultOptions(os.Stdin, os.Stdout)
+	if *noColor {
+		opts.Color = false
+	}
+	opts.Version = *fs.Lookup("lang").Value.(*lang.Version)
+	repl.Run(opts)
+}
+
+// flagSet reports whether the flag with the name was given.
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// parseFile reads and parses the script for the subcommand cmd, as the
+// version of the language unless it names one, and returns it and its source.
+// It reports any errors in the format and returns false if there were some.
+func parseFile(
+	cmd string,
+	filename string,
+	format diagnostic.Format,
+	version lang.Version,
+) (*ast.Program, string, bool) {
+	input, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hou %s: %s\n", cmd, err)
+		return nil, "", false
+	}
+
+	p := parser.NewWithArena(lexer.NewBytes(input))
+	p.SetVersion(version)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		report(filename, format, p.Diagnostics())
+		return nil, "", false
+	}
+	return program, string(input), true
+}
+
+// report writes the diagnostics about the script to stderr.
+func report(
+	filename string,
+	format diagnostic.Format,
+	diagnostics []diagnostic.Diagnostic,
+) {
+	for i := range diagnostics {
+		diagnostics[i].File = filename
+	}
+	diagnostic.Write(os.Stderr, format, diagnostics)
 }
@@ -0,0 +1,224 @@
+package native
+
+// Package native implements the runtime support for Hou programs that were
+// translated to Go by the transpiler. Generated code holds every Hou value as
+// an object.Object and calls into this package for everything that has Hou
+// semantics: operators, indexing, calls, truthiness and builtins. The actual
+// semantics come from the evaluator, so a native program behaves exactly like
+// the same program run by the tree-walker.
+//
+// Hou errors stop the evaluation of the whole program. Instead of checking for
+// error objects after every single operation the generated code calls Check,
+// which panics with an *Abort that Main recovers from.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/cedrickchee/hou/builtinerr"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/object"
+)
+
+var (
+	// True is the cached Boolean object holding the `true` value.
+	True = evaluator.TRUE
+
+	// False is the cached Boolean object holding the `false` value.
+	False = evaluator.FALSE
+
+	// Null is the cached Null object.
+	Null = evaluator.NULL
+
+	// eval provides the builtins and calls of the program.
+	eval = evaluator.New()
+
+	// mu guards the variables of programs that use tasks, see Load.
+	mu sync.RWMutex
+)
+
+// Abort is the panic value used to unwind a native program when it runs into a
+// Hou error object.
+type Abort struct {
+	Err *object.Error
+}
+
+// ErrorObject returns the error the program was aborted with. It lets the
+// evaluator tell an Abort from a crash when it recovers from the panic, e.g.
+// in a builtin that called a native function.
+func (a *Abort) ErrorObject() *object.Error { return a.Err }
+
+// Check returns obj unless it's an error object, in which case it aborts the
+// program.
+func Check(obj object.Object) object.Object {
+	if err, ok := obj.(*object.Error); ok {
+		panic(&Abort{Err: err})
+	}
+	return obj
+}
+
+// Errorf aborts the program with a Hou error with the code, built from the
+// format string.
+func Errorf(code diagnostic.Code, format string, a ...interface{}) object.Object {
+	return Check(&object.Error{Code: code, Message: fmt.Sprintf(format, a...)})
+}
+
+// Get returns the value of a variable. A variable that is nil hasn't been
+// bound by a `let` statement yet.
+func Get(value object.Object, name string) object.Object {
+	if value == nil {
+		return Errorf(diagnostic.IdentifierNotFound, "identifier not found: %s", name)
+	}
+	return value
+}
+
+// Load returns the value of the variable in *v. Programs that use tasks read
+// their variables with Load and write them with Store, since the tasks share
+// them, like the environments of the evaluator.
+func Load(v *object.Object) object.Object {
+	mu.RLock()
+	value := *v
+	mu.RUnlock()
+	return value
+}
+
+// Store sets the variable in *v to value.
+func Store(v *object.Object, value object.Object) {
+	mu.Lock()
+	*v = value
+	mu.Unlock()
+}
+
+// Assigned returns the value of a variable that's assigned to, which must
+// have been bound by a `let` statement.
+func Assigned(value object.Object, name string) object.Object {
+	if value == nil {
+		return Errorf(diagnostic.UndeclaredAssignment,
+			"cannot assign to %s: it wasn't declared with let", name)
+	}
+	return value
+}
+
+// Builtin returns the builtin function bound to name.
+func Builtin(name string) object.Object {
+	if builtin, ok := eval.Builtin(name); ok {
+		return builtin
+	}
+	return Errorf(diagnostic.IdentifierNotFound, "identifier not found: %s", name)
+}
+
+// Prefix applies the prefix operator to right.
+func Prefix(operator string, right object.Object) object.Object {
+	return Check(evaluator.EvalPrefix(operator, right))
+}
+
+// Infix applies the infix operator to left and right.
+func Infix(operator string, left, right object.Object) object.Object {
+	return Check(evaluator.EvalInfix(operator, left, right))
+}
+
+// Index applies the index operator to left, e.g: left[index].
+func Index(left, index object.Object) object.Object {
+	return Check(evaluator.EvalIndex(left, index))
+}
+
+// Member applies the member operator to obj, e.g: obj.name.
+func Member(obj object.Object, name string) object.Object {
+	return Check(evaluator.EvalMember(obj, name))
+}
+
+// SetIndex sets the element of left at index to value, e.g: left[index] =
+// value, and returns value.
+func SetIndex(left, index, value object.Object) object.Object {
+	return Check(evaluator.SetIndex(left, index, value))
+}
+
+// Truthy reports whether obj counts as true in a conditional.
+func Truthy(obj object.Object) bool {
+	return evaluator.IsTruthy(obj)
+}
+
+// Call calls fn with the given arguments.
+func Call(fn object.Object, args ...object.Object) object.Object {
+	return Check(eval.ApplyFunction(fn, args))
+}
+
+// Function wraps the Go function implementing a Hou function literal into a
+// callable object. required is the number of its parameters without a default
+// value, the arguments a call needs at least, and params the number of all its
+// parameters. Extra arguments are ignored, unless the function has default
+// values.
+func Function(required, params int, fn func(args []object.Object) object.Object) object.Object {
+	max := builtinerr.Variadic
+	if required < params {
+		max = params
+	}
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if err := builtinerr.ArgCount(args, required, max); err != nil {
+				return err
+			}
+			return fn(args)
+		},
+	}
+}
+
+// Array returns a new array holding the elements.
+func Array(elements ...object.Object) object.Object {
+	return &object.Array{Elements: elements}
+}
+
+// Hash returns a new hash built from alternating keys and values.
+func Hash(keysAndValues ...object.Object) object.Object {
+	hash := object.NewHash(len(keysAndValues) / 2)
+
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, value := keysAndValues[i], keysAndValues[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return Errorf(diagnostic.UnusableHashKey, "unusable as hash key: %s", key.Type())
+		}
+
+		hash.Set(hashKey.HashKey(), object.HashPair{Key: key, Value: value})
+	}
+
+	return hash
+}
+
+// Run runs the program and returns its result, or the error object that
+// stopped it.
+func Run(program func() object.Object) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			abort, ok := r.(*Abort)
+			if !ok {
+				panic(r)
+			}
+			result = abort.Err
+		}
+	}()
+
+	return program()
+}
+
+// Main is the entry point of a native program. It runs the program and exits
+// with a non-zero status if it was stopped by an error.
+func Main(program func() object.Object) {
+	os.Exit(run(os.Stderr, program))
+}
+
+func run(stderr io.Writer, program func() object.Object) int {
+	if err, ok := Run(program).(*object.Error); ok {
+		fmt.Fprintln(stderr, err.Inspect())
+		return 1
+	}
+	// Let the timers the program started fire before exiting, as `hou run`
+	// does.
+	eval.WaitTimers(context.Background())
+	return 0
+}
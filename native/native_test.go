@@ -0,0 +1,82 @@
+package native
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cedrickchee/hou/object"
+)
+
+func TestRun(t *testing.T) {
+	result := Run(func() object.Object {
+		sum := Infix("+", &object.Integer{Value: 1}, &object.Integer{Value: 2})
+		return Call(Function(1, 1, func(args []object.Object) object.Object {
+			return Infix("*", args[0], &object.Integer{Value: 2})
+		}), sum)
+	})
+	if result.Inspect() != "6" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+
+	var stderr bytes.Buffer
+	status := run(&stderr, func() object.Object {
+		Infix("+", &object.Integer{Value: 1}, True)
+		t.Fatal("the program went on after an error")
+		return Null
+	})
+	if status != 1 || stderr.String() != "ERROR:type mismatch: INTEGER + BOOLEAN\n" {
+		t.Errorf("wrong status or error. got=%d %q", status, stderr.String())
+	}
+}
+
+func TestTasks(t *testing.T) {
+	// Native programs run the builtins that start tasks on the Evaluator
+	// of the package, outside of any evaluation.
+	double := Function(1, 1, func(args []object.Object) object.Object {
+		return Infix("*", args[0], &object.Integer{Value: 2})
+	})
+	one := &object.Integer{Value: 1}
+	tests := []struct {
+		program  func() object.Object
+		expected string
+	}{
+		{func() object.Object { return Call(Builtin("wait"), Call(Builtin("spawn"), double, one)) }, "2"},
+		{func() object.Object { return Call(Builtin("await"), Call(Builtin("async"), double, one)) }, "2"},
+		{func() object.Object { return Call(Builtin("pmap"), Array(one, one, one), double) }, "[2, 2, 2]"},
+		{func() object.Object {
+			c := Call(Builtin("chan"), one)
+			Call(Builtin("after"), &object.Integer{Value: 1}, Function(0, 0, func([]object.Object) object.Object {
+				return Call(Builtin("send"), c, one)
+			}))
+			return Call(Builtin("recv"), c)
+		}, "1"},
+	}
+
+	for i, tt := range tests {
+		if result := Run(tt.program); result.Inspect() != tt.expected {
+			t.Errorf("tests[%d]: wrong result. want=%s, got=%s", i, tt.expected, result.Inspect())
+		}
+	}
+}
+
+// TestLoadStore checks that tasks assigning to the same variable through Load
+// and Store don't race, which `go test -race` reports.
+func TestLoadStore(t *testing.T) {
+	var n object.Object = &object.Integer{Value: 0}
+	one := &object.Integer{Value: 1}
+	work := Function(0, 0, func([]object.Object) object.Object {
+		for i := 0; i < 100; i++ {
+			Store(&n, Infix("+", Load(&n), one))
+		}
+		return Load(&n)
+	})
+
+	result := Run(func() object.Object {
+		tasks := Array(Call(Builtin("spawn"), work), Call(Builtin("spawn"), work))
+		Call(Builtin("map"), tasks, Builtin("wait"))
+		return Load(&n)
+	})
+	if result.Type() != object.INTEGER_OBJ {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+}
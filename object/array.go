@@ -0,0 +1,89 @@
+package object
+
+import "sync/atomic"
+
+// backing describes a Go array that's shared by the Elements of several
+// arrays. Each of them uses a prefix of what's left of the Go array after the
+// offset of their Elements, and the elements after the longest one are free.
+//
+// Pushing onto the array that ends where the used elements end appends in
+// place, like Go's append, so building an array with a chain of pushes takes
+// amortized constant time per push. Pushing onto any other array copies it.
+type backing struct {
+	// capacity is the length of the Go array.
+	capacity int
+	// used is the number of elements of the Go array used by some array. It's
+	// accessed atomically, since tasks can push onto the same array.
+	used int64
+}
+
+// minCapacity is the capacity of the Go array made by the first push onto an
+// empty array.
+const minCapacity = 4
+
+// Push returns an array of the elements of a followed by el. a is unchanged.
+// allocated is the number of elements of the Go array Push had to allocate,
+// or 0 if el went into free capacity after a.
+func (a *Array) Push(el Object) (result *Array, allocated int) {
+	n := len(a.Elements)
+	if b := a.backing; b != nil && n < cap(a.Elements) {
+		// Where a ends in the Go array.
+		end := int64(b.capacity - cap(a.Elements) + n)
+		if atomic.CompareAndSwapInt64(&b.used, end, end+1) {
+			elements := a.Elements[:n+1]
+			elements[n] = el
+			return &Array{Elements: elements, backing: b}, 0
+		}
+	}
+
+	// Double the capacity, so that a chain of pushes copies every element a
+	// constant number of times on average.
+	capacity := 2 * n
+	if capacity < minCapacity {
+		capacity = minCapacity
+	}
+	elements := make([]Object, n+1, capacity)
+	copy(elements, a.Elements)
+	elements[n] = el
+	b := &backing{capacity: capacity, used: int64(n + 1)}
+	return &Array{Elements: elements, backing: b}, capacity
+}
+
+// With returns a copy of a with the element at index i, which must exist, set
+// to el. a is unchanged.
+func (a *Array) With(i int, el Object) *Array {
+	elements := make([]Object, len(a.Elements))
+	copy(elements, a.Elements)
+	elements[i] = el
+	return &Array{Elements: elements}
+}
+
+// Rest returns an array of all elements of a but the first, which must
+// exist. Like Push, it doesn't copy the elements unless a owns them.
+// allocated is the number of elements Rest had to allocate.
+func (a *Array) Rest() (result *Array, allocated int) {
+	if a.backing != nil {
+		return &Array{Elements: a.Elements[1:], backing: a.backing}, 0
+	}
+
+	// a owns its elements, and may be changed by whoever made it, so copy
+	// them once into a Go array that can be shared from now on.
+	n := len(a.Elements) - 1
+	elements := make([]Object, n)
+	copy(elements, a.Elements[1:])
+	b := &backing{capacity: n, used: int64(n)}
+	return &Array{Elements: elements, backing: b}, n
+}
+
+// Set sets the element at index i, which must exist, to el. If a shares its
+// Go array with other arrays, Set first copies the elements into one of its
+// own, so the others don't change, and later calls set them in place.
+func (a *Array) Set(i int, el Object) {
+	if a.backing != nil {
+		elements := make([]Object, len(a.Elements))
+		copy(elements, a.Elements)
+		a.Elements = elements
+		a.backing = nil
+	}
+	a.Elements[i] = el
+}
@@ -0,0 +1,46 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Builder is a string built piece by piece with `append`. Concatenating
+// strings with + copies both of them, so building a string of n characters
+// out of small pieces takes O(n²) time. Appending to a Builder only copies the
+// piece, which makes it O(n). A Builder is safe for concurrent use.
+type Builder struct {
+	mu sync.Mutex
+	sb strings.Builder
+}
+
+// Type returns the type of the object.
+func (b *Builder) Type() ObjectType { return BUILDER_OBJ }
+
+// Inspect returns a stringified version of the object for debugging.
+func (b *Builder) Inspect() string {
+	return fmt.Sprintf("strBuilder(%d bytes)", b.Len())
+}
+
+// Append appends s to the string.
+func (b *Builder) Append(s string) {
+	b.mu.Lock()
+	b.sb.WriteString(s)
+	b.mu.Unlock()
+}
+
+// Len returns the length of the string in bytes.
+func (b *Builder) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sb.Len()
+}
+
+// String returns the string built so far. It doesn't copy the string, and
+// appending more doesn't change the strings returned before.
+func (b *Builder) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sb.String()
+}
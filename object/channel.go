@@ -0,0 +1,97 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrChannelClosed is returned when sending to or closing a closed Channel.
+var ErrChannelClosed = errors.New("channel is closed")
+
+// Channel is a queue of objects that tasks started by `spawn` use to
+// communicate. Sending blocks while the queue is full and receiving blocks
+// while it's empty, until the channel is closed.
+type Channel struct {
+	queue chan Object
+
+	mu     sync.Mutex
+	closed chan struct{}
+}
+
+// NewChannel returns a new Channel holding up to capacity objects. With a
+// capacity of 0, a send blocks until another task receives the object.
+func NewChannel(capacity int) *Channel {
+	return &Channel{
+		queue:  make(chan Object, capacity),
+		closed: make(chan struct{}),
+	}
+}
+
+// Type returns the type of the object.
+func (c *Channel) Type() ObjectType { return CHANNEL_OBJ }
+
+// Inspect returns a stringified version of the object for debugging.
+func (c *Channel) Inspect() string {
+	return fmt.Sprintf("chan(%d/%d)", len(c.queue), cap(c.queue))
+}
+
+// Cap returns the capacity of the channel.
+func (c *Channel) Cap() int { return cap(c.queue) }
+
+// Send adds obj to the channel, waiting for room if it's full. It fails if
+// the channel is closed or ctx is done first.
+func (c *Channel) Send(ctx context.Context, obj Object) error {
+	// The queue is never closed, as sending to a closed Go channel panics.
+	// Closing the channel closes c.closed instead.
+	select {
+	case <-c.closed:
+		return ErrChannelClosed
+	default:
+	}
+
+	select {
+	case c.queue <- obj:
+		return nil
+	case <-c.closed:
+		return ErrChannelClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Recv removes the next object from the channel, waiting for one if it's
+// empty. ok is false if the channel is closed and empty. It fails if ctx is
+// done first.
+func (c *Channel) Recv(ctx context.Context) (obj Object, ok bool, err error) {
+	select {
+	case obj := <-c.queue:
+		return obj, true, nil
+	case <-c.closed:
+		// Objects sent before the channel was closed are still received.
+		select {
+		case obj := <-c.queue:
+			return obj, true, nil
+		default:
+			return nil, false, nil
+		}
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// Close closes the channel. Pending and later receives get the objects left
+// in the channel and then fail, and sends fail right away.
+func (c *Channel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.closed:
+		return ErrChannelClosed
+	default:
+		close(c.closed)
+		return nil
+	}
+}
@@ -0,0 +1,201 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+var (
+	objectType   = reflect.TypeOf((*Object)(nil)).Elem()
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// FromGoValue converts a Go value to an object. It handles integers, floats,
+// strings, booleans, time.Time and time.Duration, nil, slices, arrays, maps
+// and pointers to any of them, nested in any combination. Values that already
+// are objects are returned unchanged.
+//
+//	obj, err := object.FromGoValue(map[string]interface{}{
+//		"name":  "hou",
+//		"ports": []int{80, 443},
+//	})
+func FromGoValue(v interface{}) (Object, error) {
+	return fromReflectValue(reflect.ValueOf(v))
+}
+
+func fromReflectValue(v reflect.Value) (Object, error) {
+	if !v.IsValid() {
+		return NULL, nil
+	}
+	if v.Type().Implements(objectType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return NULL, nil
+		}
+		return v.Interface().(Object), nil
+	}
+	switch v.Type() {
+	case timeType:
+		return &Date{Time: v.Interface().(time.Time)}, nil
+	case durationType:
+		return &Duration{Value: time.Duration(v.Int())}, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Integer{Value: int64(v.Uint())}, nil
+
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+
+	case reflect.Bool:
+		return NativeBoolToBooleanObject(v.Bool()), nil
+
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return NULL, nil
+		}
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			el, err := fromReflectValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = el
+		}
+		return &Array{Elements: elements}, nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		// Go maps have no order, so sort the pairs by key to make the order
+		// of the hash deterministic.
+		keys := make([]HashKey, 0, v.Len())
+		pairs := make(map[HashKey]HashPair, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := fromReflectValue(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			hashKey, ok := key.(Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			value, err := fromReflectValue(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, hashKey.HashKey())
+			pairs[hashKey.HashKey()] = HashPair{Key: key, Value: value}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return pairs[keys[i]].Key.Inspect() < pairs[keys[j]].Key.Inspect()
+		})
+
+		hash := NewHash(len(keys))
+		for _, key := range keys {
+			hash.Set(key, pairs[key])
+		}
+		return hash, nil
+
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return fromReflectValue(v.Elem())
+	}
+
+	return nil, fmt.Errorf("cannot convert Go value of type %s to an object",
+		v.Type())
+}
+
+// ToGoValue converts an object to the natural Go value for it: int64, float64,
+// string, bool, time.Time for dates, time.Duration for durations, nil,
+// []interface{} for arrays and, for hashes, map[string]interface{} if all
+// keys are strings or map[interface{}]interface{} otherwise. Objects
+// that have no Go counterpart, such as functions, result in an error.
+func ToGoValue(obj Object) (interface{}, error) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return obj.Value, nil
+
+	case *Float:
+		return obj.Value, nil
+
+	case *Boolean:
+		return obj.Value, nil
+
+	case *String:
+		return obj.Value, nil
+
+	case *Null:
+		return nil, nil
+
+	case *Date:
+		return obj.Time, nil
+
+	case *Duration:
+		return obj.Value, nil
+
+	case *Array:
+		values := make([]interface{}, len(obj.Elements))
+		for i, el := range obj.Elements {
+			v, err := ToGoValue(el)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return values, nil
+
+	case *Hash:
+		stringKeys := true
+		for _, pair := range obj.Pairs() {
+			if pair.Key.Type() != STRING_OBJ {
+				stringKeys = false
+				break
+			}
+		}
+
+		if stringKeys {
+			values := make(map[string]interface{}, obj.Len())
+			for _, pair := range obj.Pairs() {
+				v, err := ToGoValue(pair.Value)
+				if err != nil {
+					return nil, err
+				}
+				values[pair.Key.(*String).Value] = v
+			}
+			return values, nil
+		}
+
+		values := make(map[interface{}]interface{}, obj.Len())
+		for _, pair := range obj.Pairs() {
+			k, err := ToGoValue(pair.Key)
+			if err != nil {
+				return nil, err
+			}
+			v, err := ToGoValue(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+			values[k] = v
+		}
+		return values, nil
+	}
+
+	if obj == nil {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to a Go value", obj.Type())
+}
@@ -0,0 +1,31 @@
+package object
+
+import (
+	"time"
+)
+
+// Date is a point in time, returned by `dateNow` and `dateParse`. Dates keep
+// their time zone, which their parts and formatting use.
+type Date struct {
+	Time time.Time
+}
+
+// Type returns the type of the object.
+func (d *Date) Type() ObjectType { return DATE_OBJ }
+
+// Inspect returns a stringified version of the object for debugging, the date
+// in RFC 3339 format, e.g. 2024-03-01T14:05:00Z.
+func (d *Date) Inspect() string { return d.Time.Format(time.RFC3339Nano) }
+
+// Duration is the time between two dates, returned by `duration` and by
+// subtracting dates.
+type Duration struct {
+	Value time.Duration
+}
+
+// Type returns the type of the object.
+func (d *Duration) Type() ObjectType { return DURATION_OBJ }
+
+// Inspect returns a stringified version of the object for debugging, e.g.
+// 1h30m0s.
+func (d *Duration) Inspect() string { return d.Value.String() }
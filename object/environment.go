@@ -1,33 +1,132 @@
 package object
 
+import (
+	"sort"
+	"sync"
+)
+
 // NewEnclosedEnvironment returns a new Environment with the outer set to the
-// current environment (enclosing environment).
+// current environment (enclosing environment). It's synchronized if outer is.
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.synchronized = outer.synchronized
 	return env
 }
 
 // NewEnvironment constructs a new Environment object to hold bindings of
-// identifiers to their names.
+// identifiers to their names. It's not safe for concurrent use, see
+// NewSynchronizedEnvironment.
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
 	return &Environment{store: s, outer: nil}
 }
 
+// NewSynchronizedEnvironment returns a new Environment that's safe for
+// concurrent use, like the environments enclosed by it. Programs that start
+// tasks, e.g. with `spawn`, must be evaluated in one, since the tasks share
+// the environments of the functions they run with the rest of the program.
+func NewSynchronizedEnvironment() *Environment {
+	env := NewEnvironment()
+	env.synchronized = true
+	return env
+}
+
+// NewScopeEnvironment returns a new Environment enclosed by outer that binds
+// the distinct names in slots, which the evaluator reads and writes by index with
+// GetSlot and SetSlot instead of looking the names up, see
+// ast.BlockStatement.Names. The environment doesn't copy names, which are
+// shared by all the environments of the scope. Other names are bound by name
+// as usual, and the slots can be looked up by name too.
+func NewScopeEnvironment(outer *Environment, names []string) *Environment {
+	env := &Environment{names: names, outer: outer}
+	if outer != nil {
+		env.synchronized = outer.synchronized
+	}
+	if len(names) <= len(env.small) {
+		// Most functions bind few names, so their slots don't need an
+		// allocation of their own.
+		env.slots = env.small[:len(names)]
+	} else {
+		env.slots = make([]Object, len(names))
+	}
+	return env
+}
+
 // Environment is what we use to keep track of value by associating them with a
 // name. Technically, it's an object that holds a mapping of names to bound
-// objets.
+// objets. Only synchronized environments, see NewSynchronizedEnvironment, are
+// safe for concurrent use: the others don't lock, which is faster.
 type Environment struct {
+	// mu guards the bindings if the environment is synchronized.
+	mu           sync.RWMutex
+	synchronized bool
+	// store holds the bindings that aren't in slots. It's only allocated when
+	// the first one is made.
 	store map[string]Object
+	// names are the names of the slots, and slots their values, nil until
+	// they're bound.
+	names []string
+	slots []Object
+	small [2]Object
 	// outer is a reference to another Environment, which is the enclosing
 	// environment, the one it’s extending.
 	outer *Environment
 }
 
+// Synchronized reports whether the environment is safe for concurrent use.
+func (e *Environment) Synchronized() bool { return e.synchronized }
+
+func (e *Environment) rlock() {
+	if e.synchronized {
+		e.mu.RLock()
+	}
+}
+
+func (e *Environment) runlock() {
+	if e.synchronized {
+		e.mu.RUnlock()
+	}
+}
+
+func (e *Environment) lock() {
+	if e.synchronized {
+		e.mu.Lock()
+	}
+}
+
+func (e *Environment) unlock() {
+	if e.synchronized {
+		e.mu.Unlock()
+	}
+}
+
+// slot returns the index of the slot of name, or -1 if name isn't bound in a
+// slot.
+func (e *Environment) slot(name string) int {
+	for i, n := range e.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// local returns the object bound by name in the environment itself. The
+// caller must hold the lock of e.
+func (e *Environment) local(name string) (Object, bool) {
+	if i := e.slot(name); i >= 0 {
+		return e.slots[i], e.slots[i] != nil
+	}
+	obj, ok := e.store[name]
+	return obj, ok
+}
+
 // Get returns the object bound by name.
 func (e *Environment) Get(name string) (Object, bool) {
-	obj, ok := e.store[name]
+	e.rlock()
+	obj, ok := e.local(name)
+	e.runlock()
 	if !ok && e.outer != nil {
 		// Check the enclosing environment for the given name.
 		obj, ok = e.outer.Get(name)
@@ -37,6 +136,113 @@ func (e *Environment) Get(name string) (Object, bool) {
 
 // Set stores the object with the given name.
 func (e *Environment) Set(name string, val Object) Object {
-	e.store[name] = val
+	e.lock()
+	if i := e.slot(name); i >= 0 {
+		e.slots[i] = val
+	} else {
+		if e.store == nil {
+			e.store = make(map[string]Object)
+		}
+		e.store[name] = val
+	}
+	e.unlock()
+	return val
+}
+
+// GetSlot returns the object bound in the slot i of the environment itself,
+// if it's bound yet. Environments without such a slot bind nothing in it.
+func (e *Environment) GetSlot(i int) (Object, bool) {
+	var obj Object
+	e.rlock()
+	if i < len(e.slots) {
+		obj = e.slots[i]
+	}
+	e.runlock()
+	return obj, obj != nil
+}
+
+// SetSlot binds the object in the slot i, which must be less than the number
+// of names the environment was created with.
+func (e *Environment) SetSlot(i int, val Object) Object {
+	e.lock()
+	e.slots[i] = val
+	e.unlock()
 	return val
 }
+
+// Assign rebinds name to val in the environment that binds it, this one or
+// the innermost one enclosing it that does, and returns that environment. It
+// returns nil, and binds nothing, if no environment binds name.
+func (e *Environment) Assign(name string, val Object) *Environment {
+	for env := e; env != nil; env = env.outer {
+		env.lock()
+		if i := env.slot(name); i >= 0 {
+			if env.slots[i] != nil {
+				env.slots[i] = val
+				env.unlock()
+				return env
+			}
+		} else if _, ok := env.store[name]; ok {
+			env.store[name] = val
+			env.unlock()
+			return env
+		}
+		env.unlock()
+	}
+	return nil
+}
+
+// AssignSlot rebinds the slot i of the environment itself to val if it's
+// bound, and reports whether it was.
+func (e *Environment) AssignSlot(i int, val Object) bool {
+	e.lock()
+	defer e.unlock()
+	if i >= len(e.slots) || e.slots[i] == nil {
+		return false
+	}
+	e.slots[i] = val
+	return true
+}
+
+// Outer returns the environment enclosing this one, or nil.
+func (e *Environment) Outer() *Environment {
+	return e.outer
+}
+
+// Names returns the sorted names bound in the environment and the
+// environments enclosing it.
+func (e *Environment) Names() []string {
+	seen := map[string]bool{}
+	for env := e; env != nil; env = env.outer {
+		for _, name := range env.LocalNames() {
+			seen[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LocalNames returns the sorted names bound in the environment itself, not in
+// the environments enclosing it, e.g. the parameters and local bindings of a
+// function call.
+func (e *Environment) LocalNames() []string {
+	e.rlock()
+	names := make([]string, 0, len(e.store)+len(e.slots))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	for i, name := range e.names {
+		if e.slots[i] != nil {
+			names = append(names, name)
+		}
+	}
+	e.runlock()
+
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,146 @@
+package object
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// ErrFileClosed is returned when using a closed File.
+var ErrFileClosed = errors.New("file is closed")
+
+// File is an open file, returned by `open`. Programs should close files
+// explicitly, but a File that's garbage collected while still open is closed
+// by a finalizer, so leaked handles are eventually released.
+type File struct {
+	Path string
+	Mode string
+
+	mu     sync.Mutex
+	file   *os.File
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// OpenFile opens the file at path with the mode "r" for reading, "w" for
+// writing, creating or truncating the file, or "a" for appending to it.
+func OpenFile(path, mode string) (*File, error) {
+	var flag int
+	switch mode {
+	case "r":
+		flag = os.O_RDONLY
+	case "w":
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case "a":
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	default:
+		return nil, fmt.Errorf("invalid mode %q, want \"r\", \"w\" or \"a\"", mode)
+	}
+
+	file, err := os.OpenFile(path, flag, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{Path: path, Mode: mode, file: file}
+	if mode == "r" {
+		f.reader = bufio.NewReader(file)
+	} else {
+		f.writer = bufio.NewWriter(file)
+	}
+	runtime.SetFinalizer(f, (*File).Close)
+	return f, nil
+}
+
+// Type returns the type of the object.
+func (f *File) Type() ObjectType { return FILE_OBJ }
+
+// Inspect returns a stringified version of the object for debugging.
+func (f *File) Inspect() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	state := "open"
+	if f.file == nil {
+		state = "closed"
+	}
+	return fmt.Sprintf("file(%q, %q, %s)", f.Path, f.Mode, state)
+}
+
+// ReadAll reads the rest of the file.
+func (f *File) ReadAll() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check(f.reader != nil, "reading"); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	_, err := io.Copy(&b, f.reader)
+	return b.String(), err
+}
+
+// ReadLine reads the next line of the file, without the line terminator. It
+// returns io.EOF at the end of the file.
+func (f *File) ReadLine() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check(f.reader != nil, "reading"); err != nil {
+		return "", err
+	}
+	line, err := f.reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// Write writes s to the file. Writes are buffered until the file is closed.
+func (f *File) Write(s string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check(f.writer != nil, "writing"); err != nil {
+		return 0, err
+	}
+	return f.writer.WriteString(s)
+}
+
+// check returns an error if the file is closed or, according to ok, not open
+// for op.
+func (f *File) check(ok bool, op string) error {
+	if f.file == nil {
+		return ErrFileClosed
+	}
+	if !ok {
+		return fmt.Errorf("file is not open for %s", op)
+	}
+	return nil
+}
+
+// Close flushes the buffered writes and closes the file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return ErrFileClosed
+	}
+	runtime.SetFinalizer(f, nil)
+
+	var err error
+	if f.writer != nil {
+		err = f.writer.Flush()
+	}
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	f.file, f.reader, f.writer = nil, nil, nil
+	return err
+}
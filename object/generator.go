@@ -0,0 +1,123 @@
+package object
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cedrickchee/hou/diagnostic"
+)
+
+// YieldFunc hands a value produced by a generator over to its consumer and
+// waits until the consumer asks for the next one. It returns false if the
+// generator has to stop instead, e.g. because the evaluation was cancelled.
+type YieldFunc func(value Object) bool
+
+// Generator is the Iterator returned by calling a generator function. The
+// body of the function runs on its own goroutine, which is started by the
+// first call to Next and suspended between two values, so values are only
+// produced as they're consumed.
+type Generator struct {
+	ctx context.Context
+	run func(yield YieldFunc) Object
+
+	once     sync.Once
+	requests chan struct{}
+	values   chan Object
+	done     chan struct{}
+	// result is the result of run, set before done is closed.
+	result Object
+}
+
+// NewGenerator returns a Generator producing the values run passes to yield.
+// The result of run is only used if it's an Error object, to report that the
+// generator failed. ctx stops a suspended generator once it's done, so that
+// abandoned generators don't leak.
+func NewGenerator(ctx context.Context, run func(yield YieldFunc) Object) *Generator {
+	return &Generator{
+		ctx:      ctx,
+		run:      run,
+		requests: make(chan struct{}),
+		values:   make(chan Object),
+		done:     make(chan struct{}),
+	}
+}
+
+// yield is the YieldFunc run is called with.
+func (g *Generator) yield(value Object) bool {
+	select {
+	case g.values <- value:
+	case <-g.ctx.Done():
+		return false
+	}
+
+	select {
+	case <-g.requests:
+		return true
+	case <-g.ctx.Done():
+		return false
+	}
+}
+
+// Type returns the type of the object.
+func (g *Generator) Type() ObjectType { return GENERATOR_OBJ }
+
+// Inspect returns a stringified version of the object for debugging.
+func (g *Generator) Inspect() string {
+	select {
+	case <-g.done:
+		return "generator(done)"
+	default:
+		return "generator"
+	}
+}
+
+// Next resumes the generator and returns the next value it yields. It must
+// not be called concurrently.
+func (g *Generator) Next(ctx context.Context) (Object, bool) {
+	started := false
+	g.once.Do(func() {
+		started = true
+		go func() {
+			g.result = g.run(g.yield)
+			close(g.done)
+		}()
+	})
+
+	if !started {
+		// Resume the generator suspended in yield.
+		select {
+		case g.requests <- struct{}{}:
+		case <-g.done:
+			return g.finished()
+		case <-ctx.Done():
+			return cancelled(ctx), true
+		}
+	}
+
+	select {
+	case value := <-g.values:
+		return value, true
+	case <-g.done:
+		return g.finished()
+	case <-ctx.Done():
+		return cancelled(ctx), true
+	}
+}
+
+// finished returns what Next returns once the generator is done: the error
+// it failed with, once, and then nothing.
+func (g *Generator) finished() (Object, bool) {
+	if err, ok := g.result.(*Error); ok {
+		g.result = nil
+		return err, true
+	}
+	return nil, false
+}
+
+// cancelled returns the error Next returns when ctx is done.
+func cancelled(ctx context.Context) *Error {
+	return &Error{
+		Code:    diagnostic.Cancelled,
+		Message: "evaluation cancelled: " + ctx.Err().Error(),
+	}
+}
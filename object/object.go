@@ -6,17 +6,26 @@ package object
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/token"
 )
 
 const (
 	// INTEGER_OBJ is the Integer object type.
 	INTEGER_OBJ = "INTEGER"
 
+	// FLOAT_OBJ is the Float object type.
+	FLOAT_OBJ = "FLOAT"
+
 	// BOOLEAN_OBJ is the Boolean object type.
 	BOOLEAN_OBJ = "BOOLEAN"
 
@@ -43,14 +52,126 @@ const (
 
 	// HASH_OBJ is the Hash object type.
 	HASH_OBJ = "HASH"
+
+	// HOST_OBJ is the object type of Go values bound into scripts.
+	HOST_OBJ = "HOST"
+
+	// TASK_OBJ is the Task object type.
+	TASK_OBJ = "TASK"
+
+	// CHANNEL_OBJ is the Channel object type.
+	CHANNEL_OBJ = "CHANNEL"
+
+	// GENERATOR_OBJ is the Generator object type.
+	GENERATOR_OBJ = "GENERATOR"
+
+	// FILE_OBJ is the File object type.
+	FILE_OBJ = "FILE"
+
+	// TIMER_OBJ is the Timer object type.
+	TIMER_OBJ = "TIMER"
+
+	// BUILDER_OBJ is the Builder object type.
+	BUILDER_OBJ = "BUILDER"
+
+	// STORE_OBJ is the Store object type.
+	STORE_OBJ = "STORE"
+
+	// DATE_OBJ is the Date object type.
+	DATE_OBJ = "DATE"
+
+	// DURATION_OBJ is the Duration object type.
+	DURATION_OBJ = "DURATION"
+)
+
+var (
+	// TRUE is the cached Boolean object holding the `true` value. The
+	// evaluator compares booleans by pointer, so every `true` in a running
+	// program must be this object.
+	TRUE = &Boolean{Value: true}
+
+	// FALSE is the cached Boolean object holding the `false` value.
+	FALSE = &Boolean{Value: false}
+
+	// NULL is the cached Null object.
+	NULL = &Null{}
+)
+
+// Small integers are interned like TRUE and FALSE: NewInteger returns the
+// same object for every integer from MinCachedInteger to MaxCachedInteger, so
+// loop counters, indexes and most arithmetic in between don't allocate.
+// Integers are immutable, so sharing them is safe, but unlike booleans they
+// aren't compared by pointer: integers outside the range are distinct
+// objects.
+const (
+	MinCachedInteger = -128
+	MaxCachedInteger = 255
 )
 
+// integers holds the cached integers, from MinCachedInteger up.
+var integers = func() []*Integer {
+	cache := make([]*Integer, MaxCachedInteger-MinCachedInteger+1)
+	for i := range cache {
+		cache[i] = &Integer{Value: int64(i + MinCachedInteger)}
+	}
+	return cache
+}()
+
+// NewInteger returns an Integer holding value, the cached one if there's one.
+func NewInteger(value int64) *Integer {
+	if value >= MinCachedInteger && value <= MaxCachedInteger {
+		return integers[value-MinCachedInteger]
+	}
+	return &Integer{Value: value}
+}
+
+// NativeBoolToBooleanObject returns the cached TRUE or FALSE object for input.
+func NativeBoolToBooleanObject(input bool) *Boolean {
+	if input {
+		return TRUE
+	}
+	return FALSE
+}
+
 // Hashable is the interface for all hashable objects which must implement the
 // HashKey() method which returns a HashKey result.
 type Hashable interface {
 	HashKey() HashKey
 }
 
+// Indexable is the interface for objects that implement the index operator
+// themselves, such as Go values bound into scripts by an embedder. Index returns
+// an Error object for keys that can't be used and NULL for missing keys.
+type Indexable interface {
+	Object
+	Index(key Object) Object
+}
+
+// Iterator is the interface for objects that produce a sequence of values one
+// at a time, such as generators. Next returns the next value, or false once
+// the sequence is exhausted. If producing the value failed, it's an Error
+// object. Next gives up waiting for a value when ctx is done.
+type Iterator interface {
+	Object
+	Next(ctx context.Context) (Object, bool)
+}
+
+// IndexAssignable is the interface for Indexable objects whose elements can
+// also be replaced.
+type IndexAssignable interface {
+	Indexable
+	SetIndex(key, value Object) error
+}
+
+// Callable is the interface for functions that aren't evaluated by walking
+// the AST, such as the closures of the virtual machine. The evaluator calls
+// them like any function, e.g. when they're passed to builtins. Call returns
+// an Error object if the call failed.
+type Callable interface {
+	Object
+	Call(args ...Object) Object
+}
+
 // BuiltinFunction represents the builtin function type.
 // It's the type definition of a callable Go function.
 type BuiltinFunction func(args ...Object) Object
@@ -84,6 +205,32 @@ func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 // Inspect returns a stringified version of the object for debugging.
 func (i *Integer) Inspect() string { return fmt.Sprintf("%d", i.Value) }
 
+// Float is the floating point type used to represent float literals like 3.14
+// and holds an internal float64 value.
+type Float struct {
+	Value float64
+}
+
+// Type returns the type of the object.
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect returns a stringified version of the object for debugging. Floats
+// are printed with the fewest digits that read back as the same value, with a
+// fraction even if it's zero, e.g. 3.0, so they aren't mistaken for integers.
+// Like in JavaScript, very large and very small floats are printed with an
+// exponent, e.g. 1e+21.
+func (f *Float) Inspect() string {
+	format := byte('f')
+	if abs := math.Abs(f.Value); abs != 0 && (abs < 1e-4 || abs >= 1e21) {
+		format = 'g'
+	}
+	s := strconv.FormatFloat(f.Value, format, -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
 // Boolean is the boolean type and used to represent boolean literals and holds
 // an internal bool value.
 type Boolean struct {
@@ -122,39 +269,135 @@ func (rv *ReturnValue) Inspect() string { return rv.Value.Inspect() }
 // Error is the error type and used to hold a message denoting the details of
 // error encountered. This object is tracked through the evaluator and when
 // encountered stops evaulation of the program or body of a function.
-// In a production-ready interpreter we'd want to attach a stack trace to such
-// error objects, add the line and column numbers of its origin.
 type Error struct {
+	// Code identifies the kind of error for tools, see package diagnostic.
+	Code    diagnostic.Code
 	Message string
+	// Position is where in the source code the error happened, i.e. the
+	// position of the node that resulted in it. It's the zero Position if
+	// the error didn't come from evaluating a node.
+	Position token.Position
+	// Trace holds the calls that were in progress when the error happened,
+	// innermost first. The first frame is the function the error happened
+	// in, at Position.
+	Trace []Frame
+	// Stack is the stack of the goroutine that panicked, if the error is an
+	// internal interpreter error, for reporting the bug.
+	Stack string
+	// Status is the exit status the program asked for, if it stopped by
+	// calling `exit`, i.e. if Code is diagnostic.Exited.
+	Status int
+}
+
+// Frame is a call in the trace of an error.
+type Frame struct {
+	// Function is the name of the function if it's a named function, or
+	// else the name it was called by, "fn" for functions called by an
+	// expression such as a function literal, or "<main>" for the outermost
+	// frame.
+	Function string
+	// Position is where the frame was when the error happened: the position
+	// of the call to the next frame, or the position of the error.
+	Position token.Position
 }
 
 // Type returns the type of the object.
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 
 // Inspect returns a stringified version of the object for debugging.
-func (e *Error) Inspect() string { return "ERROR:" + e.Message }
+func (e *Error) Inspect() string { return "ERROR:" + e.Describe() }
+
+// Describe returns the message of the error followed by its position, if
+// it's known, e.g. `type mismatch: INTEGER + BOOLEAN at line 12, col 8`.
+func (e *Error) Describe() string {
+	if !e.Position.IsValid() {
+		return e.Message
+	}
+	return e.Message + " at " + e.Position.String()
+}
+
+// Backtrace returns the error like Inspect, followed by the calls that were
+// in progress when it happened, innermost first, one per line:
+//
+//	ERROR:type mismatch: INTEGER + BOOLEAN at line 1, col 24
+//	  in f at line 1, col 24
+//	  in <main> at line 3, col 1
+//
+// Inspect stays on one line, since the REPL prints it above backtraces of its
+// own, which show the source line of every frame.
+func (e *Error) Backtrace() string {
+	var out bytes.Buffer
+	out.WriteString(e.Inspect())
+	for _, frame := range e.Trace {
+		fmt.Fprintf(&out, "\n  in %s", frame.Function)
+		if frame.Position.IsValid() {
+			out.WriteString(" at " + frame.Position.String())
+		}
+	}
+	return out.String()
+}
+
+// Diagnostic returns the error in the form tools consume.
+func (e *Error) Diagnostic() diagnostic.Diagnostic {
+	return diagnostic.New(e.Code, e.Position, e.Message)
+}
 
 // Function is the function type that holds the function's formal parameters,
 // body and an environment to support closures.
 type Function struct {
+	// Name is the name of a named function literal, e.g. fact for
+	// `fn fact(n) { ... }`, or "" for anonymous functions.
+	Name       string
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+	// Generator is true if calling the function returns a Generator
+	// instead of evaluating the body.
+	Generator bool
+	// ParameterTypes and ReturnType are the type annotations of the
+	// function, see ast.FunctionLiteral.
+	ParameterTypes []*ast.TypeName
+	ReturnType     *ast.TypeName
+	// Defaults are the default values of the parameters, see
+	// ast.FunctionLiteral. They're evaluated in the environment of the call
+	// for the arguments it omits.
+	Defaults []ast.Expression
+	// Features are the features of the language version of the program
+	// that defined the function, which its body is evaluated with.
+	Features lang.FeatureSet
 }
 
 // Type returns the type of the object.
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
 
+// Required returns the number of parameters without a default value, which
+// are the arguments a call needs at least.
+func (f *Function) Required() int {
+	for i := range f.Parameters {
+		if i < len(f.Defaults) && f.Defaults[i] != nil {
+			return i
+		}
+	}
+	return len(f.Parameters)
+}
+
 // Inspect returns a stringified version of the object for debugging.
 func (f *Function) Inspect() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range f.Parameters {
-		params = append(params, p.String())
+	for i, p := range f.Parameters {
+		if i < len(f.Defaults) && f.Defaults[i] != nil {
+			params = append(params, p.String()+" = "+f.Defaults[i].String())
+		} else {
+			params = append(params, p.String())
+		}
 	}
 
 	out.WriteString("fn")
+	if f.Name != "" {
+		out.WriteString(" " + f.Name)
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") {\n")
@@ -181,6 +424,9 @@ func (s *String) Inspect() string { return s.Value }
 // an object.
 type Builtin struct {
 	Fn BuiltinFunction
+	// Deprecated, if not empty, marks the builtin as deprecated and tells
+	// what to use instead. Programs using it get a warning.
+	Deprecated string
 }
 
 // Type returns the type of the object.
@@ -190,8 +436,17 @@ func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string { return "builtin function" }
 
 // Array is the array literal type that holds a slice of Object(s).
+//
+// Arrays made by Push and Rest share the Go array backing their Elements with
+// the array they were made from rather than copying it. Don't append to
+// Elements: use Push, which knows which parts of a shared backing array are
+// free. Assign to elements with Set, which copies them first if they're
+// shared, so that assigning to an element of one array never changes another.
 type Array struct {
 	Elements []Object
+	// backing describes the Go array backing Elements if it's shared with
+	// other arrays. It's nil for arrays that own their Elements.
+	backing *backing
 }
 
 // Type returns the type of the object
@@ -252,11 +507,55 @@ type HashPair struct {
 	Value Object
 }
 
-// Hash is a hash map and holds a map of HashKey to HashPair(s).
+// Hash is a hash map that remembers the order its keys were first set in.
+// Iterating over it, printing it and converting it to JSON always go in that
+// order, so they're deterministic, unlike with a Go map.
+//
+// The pairs are kept in a slice, in order, and a Go map indexes them by key.
+// The zero Hash is an empty hash ready to use.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	pairs []HashPair
+	index map[HashKey]int
 }
 
+// NewHash returns an empty hash with room for size pairs.
+func NewHash(size int) *Hash {
+	return &Hash{
+		pairs: make([]HashPair, 0, size),
+		index: make(map[HashKey]int, size),
+	}
+}
+
+// Get returns the pair with the key.
+func (h *Hash) Get(key HashKey) (HashPair, bool) {
+	i, ok := h.index[key]
+	if !ok {
+		return HashPair{}, false
+	}
+	return h.pairs[i], true
+}
+
+// Set sets the pair with the key. A key that's already set keeps its place in
+// the order.
+func (h *Hash) Set(key HashKey, pair HashPair) {
+	if i, ok := h.index[key]; ok {
+		h.pairs[i] = pair
+		return
+	}
+	if h.index == nil {
+		h.index = make(map[HashKey]int)
+	}
+	h.index[key] = len(h.pairs)
+	h.pairs = append(h.pairs, pair)
+}
+
+// Len returns the number of pairs.
+func (h *Hash) Len() int { return len(h.pairs) }
+
+// Pairs returns the pairs in the order their keys were first set in. The
+// slice belongs to the hash and must not be modified.
+func (h *Hash) Pairs() []HashPair { return h.pairs }
+
 // Type returns the type of the object.
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
 
@@ -265,7 +564,7 @@ func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.pairs {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
 			pair.Key.Inspect(), pair.Value.Inspect()))
 	}
@@ -1,6 +1,23 @@
 package object
 
-import "testing"
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/lexer"
+	"github.com/cedrickchee/hou/parser"
+	"github.com/cedrickchee/hou/token"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -58,3 +75,708 @@ func TestIntegerHashKey(t *testing.T) {
 		t.Errorf("integers with twoerent content have same hash keys")
 	}
 }
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		input    float64
+		expected string
+	}{
+		{3.14, "3.14"},
+		{3, "3.0"},
+		{0, "0.0"},
+		{-2.5, "-2.5"},
+		{1e9, "1000000000.0"},
+		{1e21, "1e+21"},
+		{0.0001, "0.0001"},
+		{0.00001, "1e-05"},
+		{123456789.125, "123456789.125"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.input}).Inspect(); got != tt.expected {
+			t.Errorf("wrong Inspect for %g. got=%s, want=%s", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestFromGoValue(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{42, "42"},
+		{uint8(7), "7"},
+		{2.5, "2.5"},
+		{float32(3), "3.0"},
+		{"hou", "hou"},
+		{true, "true"},
+		{nil, "null"},
+		{[]int{1, 2, 3}, "[1, 2, 3]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{map[string]int{"one": 1}, "{one: 1}"},
+		{[]interface{}{1, "two", []bool{false}}, "[1, two, [false]]"},
+		{map[string][]int{"xs": {1}}, "{xs: [1]}"},
+		{&String{Value: "as is"}, "as is"},
+		{time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC), "2024-03-01T09:30:00Z"},
+		{90 * time.Minute, "1h30m0s"},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGoValue(tt.input)
+		if err != nil {
+			t.Errorf("FromGoValue(%#v) returned error: %s", tt.input, err)
+			continue
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("FromGoValue(%#v) wrong. got=%s, want=%s",
+				tt.input, obj.Inspect(), tt.expected)
+		}
+	}
+
+	if obj, _ := FromGoValue(true); obj != TRUE {
+		t.Errorf("FromGoValue(true) is not the TRUE singleton")
+	}
+
+	if _, err := FromGoValue(make(chan int)); err == nil {
+		t.Errorf("expected error converting a channel")
+	}
+	if _, err := FromGoValue(map[interface{}]int{nil: 1}); err == nil {
+		t.Errorf("expected error converting a map with a nil key")
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	hash := &Hash{}
+	set := func(key string, value int64) {
+		k := &String{Value: key}
+		hash.Set(k.HashKey(), HashPair{Key: k, Value: &Integer{Value: value}})
+	}
+	set("c", 1)
+	set("a", 2)
+	set("b", 3)
+	set("a", 4) // keeps its place
+
+	if hash.Inspect() != "{c: 1, a: 4, b: 3}" {
+		t.Errorf("wrong order. got=%s", hash.Inspect())
+	}
+	if pair, ok := hash.Get((&String{Value: "a"}).HashKey()); !ok ||
+		pair.Value.Inspect() != "4" {
+		t.Errorf("wrong pair for a. got=%v, %t", pair, ok)
+	}
+	if _, ok := hash.Get((&String{Value: "d"}).HashKey()); ok {
+		t.Errorf("unexpected pair for d")
+	}
+
+	// Hashes made from Go maps are sorted by key.
+	obj, err := FromGoValue(map[string]int{"z": 1, "x": 2, "y": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Inspect() != "{x: 2, y: 3, z: 1}" {
+		t.Errorf("wrong order of converted map. got=%s", obj.Inspect())
+	}
+}
+
+func TestArrayPush(t *testing.T) {
+	a := &Array{}
+	for i := 1; i <= 3; i++ {
+		a, _ = a.Push(&Integer{Value: int64(i)})
+	}
+
+	// Both push onto a: the first one appends in place, the second one must
+	// copy, or it would overwrite the element of the first.
+	b, allocated := a.Push(&Integer{Value: 4})
+	if allocated != 0 {
+		t.Errorf("push onto the end of a chain allocated %d elements", allocated)
+	}
+	c, allocated := a.Push(&Integer{Value: 5})
+	if allocated == 0 {
+		t.Errorf("second push onto the same array didn't copy")
+	}
+
+	// rest shares the elements too, and pushing onto it must not change b.
+	r, _ := b.Rest()
+	r, _ = r.Push(&Integer{Value: 6})
+
+	tests := []struct {
+		array    *Array
+		expected string
+	}{
+		{a, "[1, 2, 3]"},
+		{b, "[1, 2, 3, 4]"},
+		{c, "[1, 2, 3, 5]"},
+		{r, "[2, 3, 4, 6]"},
+	}
+	for i, tt := range tests {
+		if tt.array.Inspect() != tt.expected {
+			t.Errorf("tests[%d] - wrong array. want=%s, got=%s",
+				i, tt.expected, tt.array.Inspect())
+		}
+	}
+}
+
+func TestArraySet(t *testing.T) {
+	a := &Array{}
+	for i := 1; i <= 3; i++ {
+		a, _ = a.Push(&Integer{Value: int64(i)})
+	}
+	b, _ := a.Push(&Integer{Value: 4})
+	r, _ := b.Rest()
+
+	// a, b and r share a Go array, so setting an element copies it first.
+	b.Set(1, &Integer{Value: 7})
+	r.Set(0, &Integer{Value: 8})
+	a.Set(2, &Integer{Value: 9})
+	elements := b.Elements
+	b.Set(0, &Integer{Value: 6})
+	if &elements[0] != &b.Elements[0] {
+		t.Errorf("second Set copied the elements again")
+	}
+
+	tests := []struct {
+		array    *Array
+		expected string
+	}{
+		{a, "[1, 2, 9]"},
+		{b, "[6, 7, 3, 4]"},
+		{r, "[8, 3, 4]"},
+	}
+	for i, tt := range tests {
+		if tt.array.Inspect() != tt.expected {
+			t.Errorf("tests[%d] - wrong array. want=%s, got=%s",
+				i, tt.expected, tt.array.Inspect())
+		}
+	}
+}
+
+func TestArrayRestOwnedElements(t *testing.T) {
+	elements := []Object{&Integer{Value: 1}, &Integer{Value: 2}}
+	a := &Array{Elements: elements}
+	r, allocated := a.Rest()
+	if allocated != 1 {
+		t.Errorf("wrong number of allocated elements. got=%d", allocated)
+	}
+
+	// The owner of the elements may change them.
+	elements[1] = &Integer{Value: 7}
+	if r.Inspect() != "[2]" {
+		t.Errorf("rest shares elements it doesn't own. got=%s", r.Inspect())
+	}
+}
+
+func TestToGoValue(t *testing.T) {
+	hash := &Hash{}
+	for _, pair := range []HashPair{
+		{Key: &String{Value: "name"}, Value: &String{Value: "hou"}},
+		{Key: &String{Value: "ports"}, Value: &Array{Elements: []Object{
+			&Integer{Value: 80}, &Integer{Value: 443},
+		}}},
+	} {
+		hash.Set(pair.Key.(Hashable).HashKey(), pair)
+	}
+
+	v, err := ToGoValue(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		t.Fatalf("wrong type. got=%T", v)
+	}
+	if m["name"] != "hou" {
+		t.Errorf("wrong name. got=%v", m["name"])
+	}
+	ports, ok := m["ports"].([]interface{})
+	if !ok || len(ports) != 2 || ports[0] != int64(80) || ports[1] != int64(443) {
+		t.Errorf("wrong ports. got=%#v", m["ports"])
+	}
+
+	mixed := NewHash(1)
+	one := &Integer{Value: 1}
+	mixed.Set(one.HashKey(), HashPair{Key: one, Value: NULL})
+	v, err = ToGoValue(mixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mv, ok := v.(map[interface{}]interface{}); !ok || mv[int64(1)] != nil {
+		t.Errorf("wrong mixed hash. got=%#v", v)
+	}
+
+	when := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	if v, _ := ToGoValue(&Date{Time: when}); v != when {
+		t.Errorf("wrong date. got=%#v", v)
+	}
+	if v, _ := ToGoValue(&Float{Value: 0.5}); v != 0.5 {
+		t.Errorf("wrong float. got=%#v", v)
+	}
+	if v, _ := ToGoValue(&Duration{Value: time.Second}); v != time.Second {
+		t.Errorf("wrong duration. got=%#v", v)
+	}
+
+	if _, err := ToGoValue(&Builtin{}); err == nil {
+		t.Errorf("expected error converting a builtin")
+	}
+}
+
+func TestFileFinalizer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leaked.txt")
+
+	// Leak a file with a buffered write: the finalizer has to flush it.
+	func() {
+		f, err := OpenFile(path, "w")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write("leaked")
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		runtime.GC()
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) == "leaked" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("leaked file wasn't closed by the finalizer")
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+
+	hash := NewHash(0)
+	for _, key := range []Object{&String{Value: "b"}, &Integer{Value: 1}, TRUE} {
+		hash.Set(key.(Hashable).HashKey(), HashPair{Key: key, Value: NULL})
+	}
+	values := []Object{
+		&Integer{Value: -9007199254740993},
+		&Float{Value: 3},
+		&Float{Value: 6.02e23},
+		&String{Value: "quote \" and\nnewline"},
+		FALSE,
+		NULL,
+		&Array{Elements: []Object{&Integer{Value: 1}, &Array{Elements: []Object{}}}},
+		hash,
+	}
+
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, value := range values {
+		if err := s.Set(fmt.Sprint(i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Set("fn", &Function{}); err == nil {
+		t.Errorf("expected an error for storing a function")
+	}
+	if err := s.Set("inf", &Float{Value: math.Inf(1)}); err == nil {
+		t.Errorf("expected an error for storing an infinity")
+	}
+
+	// The values read back from the file are equal, down to the order of
+	// the pairs of hashes.
+	s, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := s.Keys(); len(keys) != len(values) {
+		t.Fatalf("wrong keys. got=%v", keys)
+	}
+	for i, value := range values {
+		got, ok, err := s.Get(fmt.Sprint(i))
+		if err != nil || !ok {
+			t.Fatalf("Get(%d) = %v, %v, %v", i, got, ok, err)
+		}
+		if got.Inspect() != value.Inspect() || got.Type() != value.Type() {
+			t.Errorf("value %d wrong. want=%s, got=%s", i, value.Inspect(), got.Inspect())
+		}
+	}
+
+	if ok, err := s.Delete("0"); !ok || err != nil {
+		t.Errorf("Delete = %v, %v", ok, err)
+	}
+	if _, ok, _ := s.Get("0"); ok {
+		t.Errorf("deleted key is still in the store")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("not json"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenStore(path); err == nil {
+		t.Errorf("expected an error for a file that isn't a store")
+	}
+}
+
+func TestEnvironmentConcurrent(t *testing.T) {
+	// Tasks started by `spawn` share environments; run with -race.
+	outer := NewSynchronizedEnvironment()
+	outer.Set("n", &Integer{Value: 0})
+	scope := NewScopeEnvironment(outer, []string{"a", "b"})
+	scope.SetSlot(0, &Integer{Value: 0})
+	if !scope.Synchronized() || !NewEnclosedEnvironment(scope).Synchronized() {
+		t.Fatalf("environments enclosed by a synchronized one aren't synchronized")
+	}
+	if NewScopeEnvironment(NewEnvironment(), nil).Synchronized() {
+		t.Fatalf("environment enclosed by a plain one is synchronized")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			inner := NewEnclosedEnvironment(scope)
+			for j := 0; j < 100; j++ {
+				inner.Assign("n", &Integer{Value: int64(j)})
+				scope.AssignSlot(0, &Integer{Value: int64(j)})
+				scope.Set(fmt.Sprint("t", i), TRUE)
+				inner.Get("a")
+				scope.GetSlot(1)
+				outer.Names()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if names := scope.LocalNames(); !reflect.DeepEqual(names, []string{"a", "t0", "t1", "t2", "t3"}) {
+		t.Errorf("wrong names. got=%q", names)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	env := NewEnvironment()
+	env.Set("a", &Integer{Value: 1})
+	env.Set("b", &Array{Elements: []Object{&String{Value: "x"}}})
+	snapshot := env.Snapshot()
+
+	env.Set("a", &Integer{Value: 2})
+	env.Set("c", TRUE)
+	env.Restore(snapshot)
+	if names := env.LocalNames(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("wrong names after Restore. got=%q", names)
+	}
+	if a, _ := env.Get("a"); a.Inspect() != "1" {
+		t.Errorf("wrong value of a after Restore. got=%s", a.Inspect())
+	}
+
+	scope := NewScopeEnvironment(env, []string{"s", "t"})
+	scope.SetSlot(1, &Integer{Value: 3})
+	scope.Set("u", &Integer{Value: 4})
+	scope.Restore(scope.Snapshot())
+	if names := scope.LocalNames(); !reflect.DeepEqual(names, []string{"t", "u"}) {
+		t.Errorf("wrong names of the scope after Restore. got=%q", names)
+	}
+}
+
+func TestSnapshotEncode(t *testing.T) {
+	program := parser.New(lexer.New("fn fact(n = 3) { if (n < 2) { 1 } else { n * fact(n - 1) } }")).ParseProgram()
+	literal := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+
+	env := NewEnvironment()
+	fact := &Function{
+		Name:       "fact",
+		Parameters: literal.Parameters,
+		Body:       literal.Body,
+		Defaults:   literal.Defaults,
+		Features:   lang.For(lang.Latest),
+	}
+	fact.Env = NewScopeEnvironment(env, []string{"fact"})
+	fact.Env.SetSlot(0, fact)
+	env.Set("fact", fact)
+	env.Set("x", &Float{Value: 1.5})
+	env.Set("closure", &Function{Body: literal.Body, Env: NewEnclosedEnvironment(env)})
+	env.Set("len", &Builtin{})
+
+	data, skipped, err := env.Snapshot().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(skipped, []string{"closure", "len"}) {
+		t.Errorf("wrong skipped bindings. got=%q", skipped)
+	}
+
+	snapshot, err := DecodeSnapshot(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewEnvironment()
+	restored.Restore(snapshot)
+	if names := restored.LocalNames(); !reflect.DeepEqual(names, []string{"fact", "x"}) {
+		t.Fatalf("wrong names of the restored environment. got=%q", names)
+	}
+	if x, _ := restored.Get("x"); x.Inspect() != "1.5" {
+		t.Errorf("wrong value of x. got=%s", x.Inspect())
+	}
+
+	value, _ := restored.Get("fact")
+	fn := value.(*Function)
+	if fn.Inspect() != fact.Inspect() || fn.Features != fact.Features {
+		t.Errorf("wrong function. want=%s, got=%s", fact.Inspect(), fn.Inspect())
+	}
+	if self, _ := fn.Env.GetSlot(0); self != fn || fn.Env.Outer() != restored {
+		t.Errorf("function isn't defined in the restored environment")
+	}
+
+	if _, err := DecodeSnapshot([]byte("[]")); err == nil {
+		t.Errorf("expected an error for data that isn't a snapshot")
+	}
+}
+
+func TestPrettyInspect(t *testing.T) {
+	str := func(s string) Object { return &String{Value: s} }
+	hash := func(pairs ...Object) *Hash {
+		h := NewHash(len(pairs) / 2)
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i].(Hashable).HashKey(), HashPair{Key: pairs[i], Value: pairs[i+1]})
+		}
+		return h
+	}
+	long := strings.Repeat("x", 40)
+
+	tests := []struct {
+		obj      Object
+		expected string
+	}{
+		{&Integer{Value: 1}, "1"},
+		{&Array{Elements: []Object{&Integer{Value: 1}, str("a")}}, "[1, a]"},
+		{&Array{}, "[]"},
+		{
+			&Array{Elements: []Object{
+				hash(str("song"), str("We are the World"), str("singer"), str("Michael Jackson")),
+				hash(str("song"), str("Help!"), str("singer"), str("The Beatles")),
+			}},
+			`[
+  {song: We are the World, singer: Michael Jackson},
+  {song: Help!, singer: The Beatles}
+]`,
+		},
+		{
+			hash(str("a"), &Array{Elements: []Object{str(long), str(long)}}, str("b"), TRUE),
+			`{
+  a: [
+    ` + long + `,
+    ` + long + `
+  ],
+  b: true
+}`,
+		},
+		// Long values other than arrays and hashes stay as they are.
+		{str(long + long), long + long},
+	}
+
+	for _, tt := range tests {
+		if got := PrettyInspect(tt.obj); got != tt.expected {
+			t.Errorf("wrong PrettyInspect. want=\n%s\ngot=\n%s", tt.expected, got)
+		}
+	}
+}
+
+func TestNewInteger(t *testing.T) {
+	for _, value := range []int64{MinCachedInteger - 1, MinCachedInteger, -1, 0, 1, MaxCachedInteger, MaxCachedInteger + 1} {
+		if got := NewInteger(value); got.Value != value {
+			t.Errorf("NewInteger(%d) has the wrong value. got=%d", value, got.Value)
+		}
+	}
+	if NewInteger(MinCachedInteger) != NewInteger(MinCachedInteger) ||
+		NewInteger(MaxCachedInteger) != NewInteger(MaxCachedInteger) {
+		t.Errorf("small integers aren't cached")
+	}
+	if NewInteger(MaxCachedInteger+1) == NewInteger(MaxCachedInteger+1) {
+		t.Errorf("large integers are cached")
+	}
+}
+
+func TestEnvironmentNames(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("b", &Integer{Value: 1})
+	outer.Set("a", &Integer{Value: 2})
+	inner := NewEnclosedEnvironment(outer)
+	inner.Set("c", &Integer{Value: 3})
+	inner.Set("a", &Integer{Value: 4})
+
+	expected := []string{"a", "b", "c"}
+	if names := inner.Names(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("wrong names. want=%q, got=%q", expected, names)
+	}
+	if names := outer.Names(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("wrong names of the outer environment. got=%q", names)
+	}
+}
+
+func TestEnvironmentAssign(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("a", &Integer{Value: 1})
+	inner := NewEnclosedEnvironment(outer)
+	inner.Set("b", &Integer{Value: 2})
+
+	if env := inner.Assign("a", &Integer{Value: 3}); env != outer {
+		t.Errorf("a wasn't assigned in the outer environment")
+	}
+	if val, _ := outer.Get("a"); val.Inspect() != "3" {
+		t.Errorf("wrong value of a. got=%s", val.Inspect())
+	}
+	if env := inner.Assign("b", &Integer{Value: 4}); env != inner {
+		t.Errorf("b wasn't assigned in the inner environment")
+	}
+	if _, ok := outer.Get("b"); ok {
+		t.Errorf("b leaked into the outer environment")
+	}
+	if env := inner.Assign("c", &Integer{Value: 5}); env != nil {
+		t.Errorf("c was assigned without being bound")
+	}
+	if _, ok := inner.Get("c"); ok {
+		t.Errorf("c was bound by a failed assignment")
+	}
+}
+
+func TestScopeEnvironment(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("a", &Integer{Value: 1})
+	inner := NewScopeEnvironment(outer, []string{"a", "b", "c"})
+	inner.SetSlot(1, &Integer{Value: 2})
+	inner.Set("c", &Integer{Value: 3})
+	inner.Set("d", &Integer{Value: 4})
+
+	// Slots that aren't bound yet don't hide the names outside.
+	if val, ok := inner.GetSlot(0); ok {
+		t.Errorf("slot 0 is bound. got=%v", val)
+	}
+	if val, _ := inner.Get("a"); val.Inspect() != "1" {
+		t.Errorf("wrong value of a. got=%s", val.Inspect())
+	}
+	if val, ok := inner.GetSlot(2); !ok || val.Inspect() != "3" {
+		t.Errorf("c isn't bound in its slot. got=%v", val)
+	}
+	if val, _ := inner.Get("b"); val.Inspect() != "2" {
+		t.Errorf("b can't be looked up by name. got=%v", val)
+	}
+	if _, ok := inner.GetSlot(3); ok {
+		t.Errorf("slot 3 is bound")
+	}
+	if names := inner.LocalNames(); !reflect.DeepEqual(names, []string{"b", "c", "d"}) {
+		t.Errorf("wrong local names. got=%q", names)
+	}
+
+	if inner.AssignSlot(0, &Integer{Value: 5}) {
+		t.Errorf("unbound slot 0 was assigned")
+	}
+	if env := inner.Assign("a", &Integer{Value: 5}); env != outer {
+		t.Errorf("a wasn't assigned in the outer environment")
+	}
+	if !inner.AssignSlot(1, &Integer{Value: 6}) {
+		t.Errorf("slot 1 wasn't assigned")
+	}
+	if val, _ := inner.Get("b"); val.Inspect() != "6" {
+		t.Errorf("wrong value of b. got=%s", val.Inspect())
+	}
+}
+
+func TestErrorBacktrace(t *testing.T) {
+	err := &Error{
+		Message:  "type mismatch: INTEGER + BOOLEAN",
+		Position: token.Position{Line: 1, Column: 24},
+		Trace: []Frame{
+			{Function: "f", Position: token.Position{Line: 1, Column: 24}},
+			{Function: "<main>", Position: token.Position{Line: 3, Column: 1}},
+		},
+	}
+	expected := `ERROR:type mismatch: INTEGER + BOOLEAN at line 1, col 24
+  in f at line 1, col 24
+  in <main> at line 3, col 1`
+	if err.Backtrace() != expected {
+		t.Errorf("wrong backtrace.\nwant=%q\ngot=%q", expected, err.Backtrace())
+	}
+
+	// Errors that didn't happen in the program have no trace.
+	err = &Error{Message: "boom"}
+	if err.Backtrace() != "ERROR:boom" {
+		t.Errorf("wrong backtrace. got=%q", err.Backtrace())
+	}
+}
+
+// hashKeys returns n distinct keys, made before benchmarks start timing.
+func hashKeys(n int) []HashPair {
+	pairs := make([]HashPair, n)
+	for i := range pairs {
+		pairs[i] = HashPair{Key: &Integer{Value: int64(i)}, Value: NULL}
+	}
+	return pairs
+}
+
+func BenchmarkHashSet(b *testing.B) {
+	// Grows the hash from empty, to measure the amortized cost of growing.
+	for _, n := range []int{10, 1000, 100000} {
+		pairs := hashKeys(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				hash := &Hash{}
+				for _, pair := range pairs {
+					hash.Set(pair.Key.(*Integer).HashKey(), pair)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkHashGet(b *testing.B) {
+	pairs := hashKeys(1000)
+	hash := NewHash(len(pairs))
+	for _, pair := range pairs {
+		hash.Set(pair.Key.(*Integer).HashKey(), pair)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := pairs[i%len(pairs)].Key.(*Integer).HashKey()
+		if _, ok := hash.Get(key); !ok {
+			b.Fatal("missing key")
+		}
+	}
+}
+
+func BenchmarkEnvironment(b *testing.B) {
+	// The cost of the locks that make environments safe for concurrent
+	// use, when there's no contention.
+	outer := NewEnvironment()
+	outer.Set("x", &Integer{Value: 1})
+	scope := NewScopeEnvironment(outer, []string{"y"})
+	scope.SetSlot(0, &Integer{Value: 2})
+
+	b.Run("Get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			scope.Get("x")
+		}
+	})
+	b.Run("GetSlot", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			scope.GetSlot(0)
+		}
+	})
+	b.Run("SetSlot", func(b *testing.B) {
+		val := &Integer{Value: 3}
+		for i := 0; i < b.N; i++ {
+			scope.SetSlot(0, val)
+		}
+	})
+}
+
+func BenchmarkArrayPush(b *testing.B) {
+	el := &Integer{Value: 1}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		a := &Array{}
+		for j := 0; j < 100000; j++ {
+			a, _ = a.Push(el)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package object
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// prettyWidth is the width of the lines PrettyInspect tries to keep to.
+const prettyWidth = 72
+
+// PrettyInspect returns obj like Inspect, except that arrays and hashes that
+// don't fit on a line are written with one element or pair per line,
+// indented by two spaces, e.g.
+//
+//	[
+//	  {song: We are the World, singer: Michael Jackson, year: 1985},
+//	  {song: Help!, singer: The Beatles, year: 1965}
+//	]
+//
+// It's how the REPL prints results.
+func PrettyInspect(obj Object) string {
+	var b strings.Builder
+	prettyInspect(&b, obj, 0, 0)
+	return b.String()
+}
+
+// prettyInspect writes obj, which starts at the column of a line indented by
+// indent levels, to b.
+func prettyInspect(b *strings.Builder, obj Object, indent, column int) {
+	flat := obj.Inspect()
+	if column+utf8.RuneCountInString(flat) <= prettyWidth {
+		b.WriteString(flat)
+		return
+	}
+
+	margin := strings.Repeat("  ", indent+1)
+	switch obj := obj.(type) {
+	case *Array:
+		if len(obj.Elements) == 0 {
+			break
+		}
+		b.WriteString("[\n")
+		for i, el := range obj.Elements {
+			b.WriteString(margin)
+			prettyInspect(b, el, indent+1, len(margin))
+			if i < len(obj.Elements)-1 {
+				b.WriteByte(',')
+			}
+			b.WriteByte('\n')
+		}
+		b.WriteString(margin[2:] + "]")
+		return
+	case *Hash:
+		if obj.Len() == 0 {
+			break
+		}
+		b.WriteString("{\n")
+		for i, pair := range obj.Pairs() {
+			key := pair.Key.Inspect() + ": "
+			b.WriteString(margin + key)
+			prettyInspect(b, pair.Value, indent+1, len(margin)+utf8.RuneCountInString(key))
+			if i < obj.Len()-1 {
+				b.WriteByte(',')
+			}
+			b.WriteByte('\n')
+		}
+		b.WriteString(margin[2:] + "}")
+		return
+	}
+	b.WriteString(flat)
+}
@@ -0,0 +1,232 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/token"
+)
+
+// Snapshot is a copy of the bindings of an environment, which can be restored
+// later, in the same environment or another one, or encoded to be restored
+// by another process, e.g. the `:save` and `:restore` commands of the REPL.
+// The values themselves aren't copied.
+type Snapshot struct {
+	// Bindings are the bound values by name.
+	Bindings map[string]Object
+
+	// env is the environment the snapshot was taken of, whose functions
+	// are rebound to the environment the snapshot is restored in. It's nil
+	// for decoded snapshots, whose functions have no environment.
+	env *Environment
+}
+
+// Snapshot returns a snapshot of the bindings of the environment itself, not
+// of the environments enclosing it.
+func (e *Environment) Snapshot() *Snapshot {
+	e.rlock()
+	defer e.runlock()
+
+	bindings := make(map[string]Object, len(e.store)+len(e.slots))
+	for name, value := range e.store {
+		bindings[name] = value
+	}
+	for i, name := range e.names {
+		if e.slots[i] != nil {
+			bindings[name] = e.slots[i]
+		}
+	}
+	return &Snapshot{Bindings: bindings, env: e}
+}
+
+// Restore replaces the bindings of the environment itself with the ones of
+// the snapshot. The functions defined in the environment the snapshot was
+// taken of are bound to this one instead, so that they see its bindings.
+func (e *Environment) Restore(s *Snapshot) {
+	bindings := make(map[string]Object, len(s.Bindings))
+	for name, value := range s.Bindings {
+		if fn, ok := value.(*Function); ok && s.defines(fn) {
+			value = rebind(fn, e)
+		}
+		bindings[name] = value
+	}
+
+	e.lock()
+	defer e.unlock()
+	for i := range e.slots {
+		e.slots[i] = nil
+	}
+	e.store = nil
+	for name, value := range bindings {
+		if i := e.slot(name); i >= 0 {
+			e.slots[i] = value
+			continue
+		}
+		if e.store == nil {
+			e.store = make(map[string]Object)
+		}
+		e.store[name] = value
+	}
+}
+
+// defines reports whether the function was defined in the environment the
+// snapshot was taken of, directly or in the scope that binds its name.
+func (s *Snapshot) defines(fn *Function) bool {
+	if fn.Name != "" && fn.Env != nil {
+		return fn.Env.outer == s.env
+	}
+	return fn.Env == s.env
+}
+
+// rebind returns a copy of the function defined in env instead.
+func rebind(fn *Function, env *Environment) *Function {
+	copy := *fn
+	copy.Env = env
+	if fn.Name != "" {
+		copy.Env = NewScopeEnvironment(env, []string{fn.Name})
+		copy.Env.SetSlot(0, &copy)
+	}
+	return &copy
+}
+
+// Encode returns the snapshot encoded as JSON, like the values of a Store,
+// with the functions defined in the environment of the snapshot encoded as
+// their syntax tree. It skips the bindings it can't encode, e.g. builtins or
+// closures, and returns their names.
+func (s *Snapshot) Encode() ([]byte, []string, error) {
+	names := make([]string, 0, len(s.Bindings))
+	for name := range s.Bindings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	var skipped []string
+	buf.WriteString("{")
+	for _, name := range names {
+		var value bytes.Buffer
+		err := s.encodeBinding(&value, s.Bindings[name])
+		if _, ok := err.(*UnstorableError); ok {
+			skipped = append(skipped, name)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if buf.Len() > 1 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(&buf, "\n  %s: %s", key, value.Bytes())
+	}
+	buf.WriteString("\n}\n")
+	return buf.Bytes(), skipped, nil
+}
+
+// encodedFunction is the encoding of a function: the syntax tree of its
+// literal, and the version of the language it was defined with.
+type encodedFunction struct {
+	Fn      json.RawMessage `json:"fn"`
+	Version lang.Version    `json:"version"`
+}
+
+// encodeBinding writes the value of a binding of the snapshot as JSON.
+func (s *Snapshot) encodeBinding(buf *bytes.Buffer, value Object) error {
+	fn, ok := value.(*Function)
+	if !ok {
+		return encodeValue(buf, value)
+	}
+	if !s.defines(fn) {
+		// It closes over bindings that aren't in the snapshot.
+		return &UnstorableError{Type: fn.Type()}
+	}
+
+	literal := &ast.FunctionLiteral{
+		Token:          token.Token{Type: token.FUNCTION, Literal: "fn"},
+		Parameters:     fn.Parameters,
+		Body:           fn.Body,
+		Generator:      fn.Generator,
+		ParameterTypes: fn.ParameterTypes,
+		Defaults:       fn.Defaults,
+		ReturnType:     fn.ReturnType,
+	}
+	if fn.Name != "" {
+		literal.Name = &ast.Identifier{
+			Token: token.Token{Type: token.IDENT, Literal: fn.Name},
+			Value: fn.Name,
+		}
+	}
+	tree, err := ast.Encode(literal)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(encodedFunction{Fn: tree, Version: fn.Features.Version()})
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
+
+// DecodeSnapshot decodes a snapshot encoded by Snapshot.Encode.
+func DecodeSnapshot(data []byte) (*Snapshot, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("not a snapshot: %s", err)
+	}
+
+	s := &Snapshot{Bindings: make(map[string]Object, len(values))}
+	for name, data := range values {
+		value, err := decodeBinding(data)
+		if err != nil {
+			return nil, fmt.Errorf("binding %s: %s", name, err)
+		}
+		s.Bindings[name] = value
+	}
+	return s, nil
+}
+
+// decodeBinding reads the value of a binding written by encodeBinding.
+func decodeBinding(data json.RawMessage) (Object, error) {
+	var encoded encodedFunction
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		if err := json.Unmarshal(data, &encoded); err != nil {
+			return nil, err
+		}
+	}
+	if encoded.Fn == nil {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		return decodeValue(dec)
+	}
+
+	node, err := ast.Decode(encoded.Fn)
+	if err != nil {
+		return nil, err
+	}
+	literal, ok := node.(*ast.FunctionLiteral)
+	if !ok {
+		return nil, fmt.Errorf("malformed function")
+	}
+	fn := &Function{
+		Parameters:     literal.Parameters,
+		Body:           literal.Body,
+		Generator:      literal.Generator,
+		ParameterTypes: literal.ParameterTypes,
+		ReturnType:     literal.ReturnType,
+		Defaults:       literal.Defaults,
+		Features:       lang.For(encoded.Version),
+	}
+	if literal.Name != nil {
+		fn.Name = literal.Name.Value
+	}
+	return fn, nil
+}
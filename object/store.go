@@ -0,0 +1,309 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store is a persistent key-value store, returned by `storeOpen`. It keeps its
+// pairs in a JSON file, which is rewritten after every change, so the store
+// survives the program without being closed. Values are numbers, strings,
+// booleans, null, and arrays and hashes of them; hash keys are strings,
+// integers or booleans.
+//
+// Stores aren't meant for large data or for several processes writing to the
+// same file: the whole file is rewritten on every change, and the last
+// process to write wins.
+type Store struct {
+	Path string
+
+	mu sync.Mutex
+	// values holds the encoded values by key.
+	values map[string]json.RawMessage
+}
+
+// UnstorableError is returned when setting a key of a Store to a value that
+// can't be stored, e.g. a function.
+type UnstorableError struct {
+	Type ObjectType
+}
+
+func (e *UnstorableError) Error() string {
+	return fmt.Sprintf("can't store a value of type %s", e.Type)
+}
+
+// OpenStore opens the store in the file at path, creating an empty store if
+// the file doesn't exist.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{Path: path, values: map[string]json.RawMessage{}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.values); err != nil {
+		return nil, fmt.Errorf("%s is not a store: %s", path, err)
+	}
+	return s, nil
+}
+
+// Type returns the type of the object.
+func (s *Store) Type() ObjectType { return STORE_OBJ }
+
+// Inspect returns a stringified version of the object for debugging.
+func (s *Store) Inspect() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return fmt.Sprintf("store(%q, %d keys)", s.Path, len(s.values))
+}
+
+// Get returns the value of the key, a new copy every time.
+func (s *Store) Get(key string) (Object, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.values[key]
+	if !ok {
+		return nil, false, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	value, err := decodeValue(dec)
+	return value, true, err
+}
+
+// Set sets the value of the key and saves the store.
+func (s *Store) Set(key string, value Object) error {
+	var buf bytes.Buffer
+	if err := encodeValue(&buf, value); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, had := s.values[key]
+	s.values[key] = buf.Bytes()
+	if err := s.save(); err != nil {
+		// Keep the store as it is in the file.
+		if had {
+			s.values[key] = old
+		} else {
+			delete(s.values, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete deletes the key and saves the store. It reports whether the key was
+// in the store.
+func (s *Store) Delete(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.values[key]
+	if !ok {
+		return false, nil
+	}
+	delete(s.values, key)
+	if err := s.save(); err != nil {
+		s.values[key] = old
+		return false, err
+	}
+	return true, nil
+}
+
+// Keys returns the keys of the store, sorted.
+func (s *Store) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.keys()
+}
+
+// keys returns the keys of the store, sorted. The store must be locked.
+func (s *Store) keys() []string {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// save writes the store to a temporary file and renames it over the file of
+// the store, so that the file is never left half written. The file is a JSON
+// object with a line for every key, in order.
+func (s *Store) save() error {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, key := range s.keys() {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		name, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "\n  %s: %s", name, s.values[key])
+	}
+	buf.WriteString("\n}\n")
+
+	tmp := s.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+// encodeValue writes the value as JSON. Hashes are written as arrays of
+// [key, value] pairs, which keeps the order and the types of their keys.
+func encodeValue(buf *bytes.Buffer, obj Object) error {
+	switch obj := obj.(type) {
+	case *Integer:
+		fmt.Fprintf(buf, "%d", obj.Value)
+	case *Float:
+		// Floats are written with a fraction or an exponent, which tells
+		// them from integers. JSON has no infinities or NaN.
+		if math.IsInf(obj.Value, 0) || math.IsNaN(obj.Value) {
+			return &UnstorableError{Type: obj.Type()}
+		}
+		buf.WriteString(obj.Inspect())
+	case *String:
+		data, err := json.Marshal(obj.Value)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	case *Boolean:
+		fmt.Fprintf(buf, "%t", obj.Value)
+	case *Null:
+		buf.WriteString("null")
+	case *Array:
+		buf.WriteByte('[')
+		for i, el := range obj.Elements {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeValue(buf, el); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case *Hash:
+		buf.WriteString(`{"hash":[`)
+		for i, pair := range obj.Pairs() {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('[')
+			if err := encodeValue(buf, pair.Key); err != nil {
+				return err
+			}
+			buf.WriteByte(',')
+			if err := encodeValue(buf, pair.Value); err != nil {
+				return err
+			}
+			buf.WriteByte(']')
+		}
+		buf.WriteString("]}")
+	default:
+		return &UnstorableError{Type: obj.Type()}
+	}
+	return nil
+}
+
+// decodeValue reads a value written by encodeValue.
+func decodeValue(dec *json.Decoder) (Object, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case json.Number:
+		if strings.ContainsAny(string(tok), ".eE") {
+			f, err := tok.Float64()
+			if err != nil {
+				return nil, err
+			}
+			return &Float{Value: f}, nil
+		}
+		n, err := tok.Int64()
+		if err != nil {
+			return nil, err
+		}
+		return &Integer{Value: n}, nil
+	case string:
+		return &String{Value: tok}, nil
+	case bool:
+		if tok {
+			return TRUE, nil
+		}
+		return FALSE, nil
+	case nil:
+		return NULL, nil
+	case json.Delim:
+		if tok == '[' {
+			elements := []Object{}
+			for dec.More() {
+				el, err := decodeValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, el)
+			}
+			_, err := dec.Token()
+			return &Array{Elements: elements}, err
+		}
+		return decodeHash(dec)
+	}
+	return nil, fmt.Errorf("unexpected %v in stored value", tok)
+}
+
+// decodeHash reads the rest of a hash written by encodeValue, after its
+// opening brace.
+func decodeHash(dec *json.Decoder) (Object, error) {
+	if tok, err := dec.Token(); err != nil || tok != "hash" {
+		return nil, fmt.Errorf("malformed hash in stored value")
+	}
+	pairs, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := pairs.(*Array)
+	if !ok {
+		return nil, fmt.Errorf("malformed hash in stored value")
+	}
+
+	hash := NewHash(len(list.Elements))
+	for _, pair := range list.Elements {
+		kv, ok := pair.(*Array)
+		if !ok || len(kv.Elements) != 2 {
+			return nil, fmt.Errorf("malformed hash in stored value")
+		}
+		key, ok := kv.Elements[0].(Hashable)
+		if !ok {
+			return nil, fmt.Errorf("malformed hash in stored value")
+		}
+		hash.Set(key.HashKey(), HashPair{Key: kv.Elements[0], Value: kv.Elements[1]})
+	}
+
+	// The closing brace.
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
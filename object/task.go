@@ -0,0 +1,41 @@
+package object
+
+// Task is a handle to a function running concurrently with the program that
+// started it with `spawn`. The result of the function becomes available once
+// the task is done.
+type Task struct {
+	done   chan struct{}
+	result Object
+}
+
+// NewTask returns a new, running Task.
+func NewTask() *Task {
+	return &Task{done: make(chan struct{})}
+}
+
+// Type returns the type of the object.
+func (t *Task) Type() ObjectType { return TASK_OBJ }
+
+// Inspect returns a stringified version of the object for debugging.
+func (t *Task) Inspect() string {
+	select {
+	case <-t.done:
+		return "task(done)"
+	default:
+		return "task(running)"
+	}
+}
+
+// Finish marks the task as done with the result of its function. It must be
+// called exactly once.
+func (t *Task) Finish(result Object) {
+	t.result = result
+	close(t.done)
+}
+
+// Done returns a channel that's closed when the task is done.
+func (t *Task) Done() <-chan struct{} { return t.done }
+
+// Result returns the result of the task's function. It must only be called
+// once the task is done.
+func (t *Task) Result() Object { return t.result }
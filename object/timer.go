@@ -0,0 +1,36 @@
+package object
+
+import "sync"
+
+// Timer is a handle to a function scheduled with `after` or `every`. Stopping
+// the timer cancels the calls that haven't started yet.
+type Timer struct {
+	once    sync.Once
+	stopped chan struct{}
+}
+
+// NewTimer returns a new, active Timer.
+func NewTimer() *Timer {
+	return &Timer{stopped: make(chan struct{})}
+}
+
+// Type returns the type of the object.
+func (t *Timer) Type() ObjectType { return TIMER_OBJ }
+
+// Inspect returns a stringified version of the object for debugging.
+func (t *Timer) Inspect() string {
+	select {
+	case <-t.stopped:
+		return "timer(stopped)"
+	default:
+		return "timer(active)"
+	}
+}
+
+// Stop stops the timer. It's safe to stop a timer more than once.
+func (t *Timer) Stop() {
+	t.once.Do(func() { close(t.stopped) })
+}
+
+// Stopped returns a channel that's closed when the timer is stopped.
+func (t *Timer) Stopped() <-chan struct{} { return t.stopped }
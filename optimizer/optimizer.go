@@ -0,0 +1,268 @@
+package optimizer
+
+// Package optimizer rewrites Hou programs into equivalent ones that do less
+// work when they're run: it folds operators applied to constants into the
+// constants they evaluate to, e.g. `60 * 60 * 24` into `86400`, drops the
+// branch of an if expression that its constant condition never takes, and
+// drops the statements of a block after a return statement. It's what
+// `hou run --optimize` runs before evaluating a script.
+//
+// The operators are applied by the evaluator, with the features of the
+// version of the program, so the folded constants are the values the
+// expressions evaluate to. Expressions that would fail when they're
+// evaluated, like `1 / 0` or an integer overflow, are left as they are, so
+// that they still do.
+//
+// Names keep the slots the parser resolved them to: a let statement that's
+// dropped never runs, which is the same as not binding its name.
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/object"
+	"github.com/cedrickchee/hou/token"
+)
+
+// Optimize rewrites the program in place and returns it.
+func Optimize(program *ast.Program) *ast.Program {
+	o := &optimizer{features: program.Features}
+	program.Statements = o.statements(program.Statements)
+	return program
+}
+
+// optimizer holds the options of the rewriting of a program.
+type optimizer struct {
+	// features are the features of the version of the program, which give
+	// the operators their semantics.
+	features lang.FeatureSet
+}
+
+// statements rewrites the statements, dropping the ones after a return
+// statement, which are never run.
+func (o *optimizer) statements(statements []ast.Statement) []ast.Statement {
+	for i, s := range statements {
+		statements[i] = o.statement(s)
+		if _, ok := s.(*ast.ReturnStatement); ok {
+			return statements[:i+1]
+		}
+	}
+	return statements
+}
+
+func (o *optimizer) statement(s ast.Statement) ast.Statement {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		s.Value = o.expression(s.Value)
+	case *ast.ReturnStatement:
+		s.ReturnValue = o.expression(s.ReturnValue)
+	case *ast.ExpressionStatement:
+		s.Expression = o.expression(s.Expression)
+	case *ast.BlockStatement:
+		o.block(s)
+	}
+	return s
+}
+
+func (o *optimizer) block(block *ast.BlockStatement) {
+	if block != nil {
+		block.Statements = o.statements(block.Statements)
+	}
+}
+
+// expression rewrites the expression e, and returns it or the constant it
+// was folded into.
+func (o *optimizer) expression(e ast.Expression) ast.Expression {
+	switch e := e.(type) {
+	case *ast.PrefixExpression:
+		e.Right = o.expression(e.Right)
+		right, ok := constant(e.Right)
+		if !ok {
+			return e
+		}
+		return fold(e, evaluator.EvalPrefix(e.Operator, right))
+
+	case *ast.InfixExpression:
+		e.Left = o.expression(e.Left)
+		e.Right = o.expression(e.Right)
+		left, ok := constant(e.Left)
+		right, ok2 := constant(e.Right)
+		if !ok || !ok2 || e.Operator == token.COALESCE {
+			return e
+		}
+		result := evaluator.EvalInfixWith(o.features, e.Operator, left, right)
+		return fold(e, result)
+
+	case *ast.IfExpression:
+		e.Condition = o.expression(e.Condition)
+		o.block(e.Consequence)
+		o.block(e.Alternative)
+		condition, ok := constant(e.Condition)
+		if !ok {
+			return e
+		}
+		// The branch the condition takes is the consequence of an if
+		// expression whose condition is true, and the other one is dropped.
+		// Without an else, a false condition keeps the expression, which is
+		// null, with an empty consequence.
+		truthy := evaluator.IsTruthy(condition)
+		tok := startToken(e.Condition)
+		switch {
+		case truthy:
+			e.Alternative = nil
+		case e.Alternative != nil:
+			e.Consequence, e.Alternative = e.Alternative, nil
+			truthy = true
+		default:
+			e.Consequence.Statements = nil
+		}
+		e.Condition = &ast.Boolean{
+			Token: token.Token{
+				Type:     token.LookupIdent(strconv.FormatBool(truthy)),
+				Literal:  strconv.FormatBool(truthy),
+				Position: tok.Position,
+				Offset:   tok.Offset,
+			},
+			Value: truthy,
+		}
+		return e
+
+	case *ast.WhileExpression:
+		e.Condition = o.expression(e.Condition)
+		o.block(e.Body)
+	case *ast.ForInExpression:
+		e.Iterable = o.expression(e.Iterable)
+		o.block(e.Body)
+	case *ast.MatchExpression:
+		e.Subject = o.expression(e.Subject)
+		for _, c := range e.Cases {
+			for i, v := range c.Values {
+				c.Values[i] = o.expression(v)
+			}
+			o.block(c.Body)
+		}
+		o.block(e.Default)
+	case *ast.AssignExpression:
+		if e.Index != nil {
+			o.expression(e.Index)
+		}
+		e.Value = o.expression(e.Value)
+	case *ast.FunctionLiteral:
+		for i, value := range e.Defaults {
+			if value != nil {
+				e.Defaults[i] = o.expression(value)
+			}
+		}
+		o.block(e.Body)
+	case *ast.YieldExpression:
+		e.Value = o.expression(e.Value)
+	case *ast.SpawnExpression:
+		o.expression(e.Function)
+	case *ast.CallExpression:
+		e.Function = o.expression(e.Function)
+		for i, arg := range e.Arguments {
+			e.Arguments[i] = o.expression(arg)
+		}
+	case *ast.ArrayLiteral:
+		for i, el := range e.Elements {
+			e.Elements[i] = o.expression(el)
+		}
+	case *ast.IndexExpression:
+		e.Left = o.expression(e.Left)
+		e.Index = o.expression(e.Index)
+	case *ast.MemberExpression:
+		e.Object = o.expression(e.Object)
+	case *ast.HashLiteral:
+		// The pairs are keyed by the nodes of the keys, which may be
+		// replaced.
+		pairs := make(map[ast.Expression]ast.Expression, len(e.Pairs))
+		for i, key := range e.Keys {
+			value := e.Pairs[key]
+			e.Keys[i] = o.expression(key)
+			pairs[e.Keys[i]] = o.expression(value)
+		}
+		e.Pairs = pairs
+	}
+	return e
+}
+
+// constant returns the value of the expression if it's a literal of a
+// constant.
+func constant(e ast.Expression) (object.Object, bool) {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return object.NewInteger(e.Value), true
+	case *ast.FloatLiteral:
+		return &object.Float{Value: e.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: e.Value}, true
+	case *ast.Boolean:
+		if e.Value {
+			return object.TRUE, true
+		}
+		return object.FALSE, true
+	case *ast.Null:
+		return object.NULL, true
+	}
+	return nil, false
+}
+
+// fold returns the literal of the value the expression e evaluates to, at the
+// position e starts at, or e itself if the value has no literal, e.g. an
+// error.
+func fold(e ast.Expression, value object.Object) ast.Expression {
+	start := startToken(e)
+	tok := token.Token{Position: start.Position, Offset: start.Offset}
+	switch value := value.(type) {
+	case *object.Integer:
+		tok.Type, tok.Literal = token.INT, strconv.FormatInt(value.Value, 10)
+		return &ast.IntegerLiteral{Token: tok, Value: value.Value}
+	case *object.Float:
+		if math.IsInf(value.Value, 0) || math.IsNaN(value.Value) {
+			return e
+		}
+		literal := strconv.FormatFloat(value.Value, 'g', -1, 64)
+		if !strings.ContainsAny(literal, ".e") {
+			literal += ".0"
+		}
+		tok.Type, tok.Literal = token.FLOAT, literal
+		return &ast.FloatLiteral{Token: tok, Value: value.Value}
+	case *object.String:
+		tok.Type, tok.Literal = token.STRING, value.Value
+		return &ast.StringLiteral{Token: tok, Value: value.Value}
+	case *object.Boolean:
+		tok.Type = token.LookupIdent(value.Inspect())
+		tok.Literal = value.Inspect()
+		return &ast.Boolean{Token: tok, Value: value.Value}
+	}
+	return e
+}
+
+// startToken returns the token the source of the expression starts with,
+// e.g. the one of the left operand of an infix expression.
+func startToken(e ast.Expression) token.Token {
+	for {
+		switch n := e.(type) {
+		case *ast.InfixExpression:
+			e = n.Left
+		case *ast.IntegerLiteral:
+			return n.Token
+		case *ast.FloatLiteral:
+			return n.Token
+		case *ast.StringLiteral:
+			return n.Token
+		case *ast.Boolean:
+			return n.Token
+		case *ast.Null:
+			return n.Token
+		case *ast.PrefixExpression:
+			return n.Token
+		default:
+			return token.Token{Position: e.Pos()}
+		}
+	}
+}
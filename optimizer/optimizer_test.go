@@ -0,0 +1,103 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/format"
+	"github.com/cedrickchee/hou/lexer"
+	"github.com/cedrickchee/hou/object"
+	"github.com/cedrickchee/hou/parser"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"60 * 60 * 24", "86400;\n"},
+		{"let x = -(1 + 2) * x;", "let x = -3 * x;\n"},
+		{"[1.5 * 2, 1 / 4.0, \"a\" + \"b\", !(1 < 2), 1 == 1.0]", "[3.0, 0.25, \"ab\", false, true];\n"},
+		// Only constants are folded.
+		{"x + 1 + 2", "x + 1 + 2;\n"},
+		{"x + (1 + 2)", "x + 3;\n"},
+		// Expressions that fail or warn are left alone.
+		{"1 / 0; 1 + true; -true; 9223372036854775807 + 1", "1 / 0;\n1 + true;\n-true;\n9223372036854775807 + 1;\n"},
+		{"#pragma version 2\n1 == true", "#pragma version 2\n\n1 == true;\n"},
+		{"#pragma version 2\nnull ?? 1", "#pragma version 2\n\nnull ?? 1;\n"},
+		// Constant conditions drop the branch they never take.
+		{"if (1 < 2) { a } else { b }", "if (true) {\n  a\n}\n"},
+		{"#pragma version 2\nif (null) { a } else { b }", "#pragma version 2\n\nif (true) {\n  b\n}\n"},
+		{"if (false) { a }", "if (false) {}\n"},
+		{"if (x) { 1 + 1 }", "if (x) {\n  2\n}\n"},
+		// And return statements the statements after them.
+		{"fn() { let a = 1; return a; a = 2; puts(a) }", "fn() {\n  let a = 1;\n  return a;\n};\n"},
+		{"fn() { if (x) { return 1; 2 } 3 }", "fn() {\n  if (x) {\n    return 1;\n  }\n  3\n};\n"},
+		// Everywhere in the program.
+		{"let f = fn(a = 2 * 3) { [a * (4 - 1)] }; f({1 + 1: 2 * 2}[2 * 1]).x", "let f = fn(a = 6) {\n  [a * 3]\n};\nf({2: 4}[2]).x;\n"},
+	}
+
+	for _, tt := range tests {
+		program := Optimize(parse(t, tt.input))
+		if got := format.Program(program); got != tt.expected {
+			t.Errorf("wrong program for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestEquivalence(t *testing.T) {
+	// The optimized programs evaluate to the same values, and fail and warn
+	// the same way.
+	tests := []string{
+		"let day = 60 * 60 * 24; day * 2",
+		"let f = fn(n) { if (1 > 2) { return 0; } else { return n * 2; n } }; f(21)",
+		"let x = 1; let f = fn() { return x; let x = 2; }; f()",
+		"let f = fn(b) { if (false) { let x = 2; } x }; let x = 1; f(true)",
+		"if (false) { 1 }",
+		"[1 / 0]",
+		"9223372036854775807 + 2 - 3",
+		"#pragma version 3\n[2 ** 62 * 2]",
+		"#pragma version 2\nlet n = 0; while (n < 2 * 5) { n += 1 }; match (n) { case 5 * 2: { \"ten\" } default: { n } }",
+		"#pragma version 2\nlet x = 1; if (true) { let x = 2; x } + x",
+	}
+
+	for _, input := range tests {
+		want, wantWarnings := eval(parse(t, input))
+		got, gotWarnings := eval(Optimize(parse(t, input)))
+		if got != want {
+			t.Errorf("%q: wrong result. want=%s, got=%s", input, want, got)
+		}
+		if strings.Join(gotWarnings, "\n") != strings.Join(wantWarnings, "\n") {
+			t.Errorf("%q: wrong warnings. want=%q, got=%q", input, wantWarnings, gotWarnings)
+		}
+	}
+}
+
+// eval evaluates the program and returns its result, with the position of
+// errors, and its warnings.
+func eval(program *ast.Program) (string, []string) {
+	var warnings []string
+	e := evaluator.New()
+	e.Warnings = &evaluator.Warnings{
+		Handle: func(w evaluator.Warning) {
+			warnings = append(warnings, w.String())
+		},
+	}
+	result := e.Eval(program, object.NewEnvironment())
+	if err, ok := result.(*object.Error); ok {
+		return err.Inspect() + " at " + err.Position.String(), warnings
+	}
+	return result.Inspect(), warnings
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) > 0 {
+		t.Fatalf("parser errors for %q: %s", input, strings.Join(errors, "; "))
+	}
+	return program
+}
@@ -0,0 +1,168 @@
+package parser
+
+import "github.com/cedrickchee/hou/ast"
+
+// arenaChunk is the number of nodes of a type an arena allocates at once.
+const arenaChunk = 128
+
+// arena allocates the most common AST nodes in chunks rather than one by one,
+// which cuts the number of allocations, and the work of the garbage
+// collector, for tools that parse many or large programs. Nodes are freed
+// together: a chunk stays alive as long as any node in it is referenced.
+//
+// A nil *arena allocates every node on its own, so the parser doesn't need to
+// care whether it has one. The methods copy n before taking its address in
+// that case: taking the address of n itself would make every n escape to the
+// heap, arena or not.
+type arena struct {
+	identifiers          []ast.Identifier
+	integerLiterals      []ast.IntegerLiteral
+	stringLiterals       []ast.StringLiteral
+	booleans             []ast.Boolean
+	prefixExpressions    []ast.PrefixExpression
+	infixExpressions     []ast.InfixExpression
+	callExpressions      []ast.CallExpression
+	indexExpressions     []ast.IndexExpression
+	expressionStatements []ast.ExpressionStatement
+	letStatements        []ast.LetStatement
+	blockStatements      []ast.BlockStatement
+}
+
+// full reports whether a chunk with the length and capacity has no room for
+// another node. The methods start a new chunk then rather than growing the
+// slice, which would move the nodes that are already in use.
+func full(length, capacity int) bool {
+	return length == capacity
+}
+
+func (a *arena) identifier(n ast.Identifier) *ast.Identifier {
+	if a == nil {
+		node := n
+		return &node
+	}
+	if full(len(a.identifiers), cap(a.identifiers)) {
+		a.identifiers = make([]ast.Identifier, 0, arenaChunk)
+	}
+	a.identifiers = append(a.identifiers, n)
+	return &a.identifiers[len(a.identifiers)-1]
+}
+
+func (a *arena) integerLiteral(n ast.IntegerLiteral) *ast.IntegerLiteral {
+	if a == nil {
+		node := n
+		return &node
+	}
+	if full(len(a.integerLiterals), cap(a.integerLiterals)) {
+		a.integerLiterals = make([]ast.IntegerLiteral, 0, arenaChunk)
+	}
+	a.integerLiterals = append(a.integerLiterals, n)
+	return &a.integerLiterals[len(a.integerLiterals)-1]
+}
+
+func (a *arena) stringLiteral(n ast.StringLiteral) *ast.StringLiteral {
+	if a == nil {
+		node := n
+		return &node
+	}
+	if full(len(a.stringLiterals), cap(a.stringLiterals)) {
+		a.stringLiterals = make([]ast.StringLiteral, 0, arenaChunk)
+	}
+	a.stringLiterals = append(a.stringLiterals, n)
+	return &a.stringLiterals[len(a.stringLiterals)-1]
+}
+
+func (a *arena) boolean(n ast.Boolean) *ast.Boolean {
+	if a == nil {
+		node := n
+		return &node
+	}
+	if full(len(a.booleans), cap(a.booleans)) {
+		a.booleans = make([]ast.Boolean, 0, arenaChunk)
+	}
+	a.booleans = append(a.booleans, n)
+	return &a.booleans[len(a.booleans)-1]
+}
+
+func (a *arena) prefixExpression(n ast.PrefixExpression) *ast.PrefixExpression {
+	if a == nil {
+		node := n
+		return &node
+	}
+	if full(len(a.prefixExpressions), cap(a.prefixExpressions)) {
+		a.prefixExpressions = make([]ast.PrefixExpression, 0, arenaChunk)
+	}
+	a.prefixExpressions = append(a.prefixExpressions, n)
+	return &a.prefixExpressions[len(a.prefixExpressions)-1]
+}
+
+func (a *arena) infixExpression(n ast.InfixExpression) *ast.InfixExpression {
+	if a == nil {
+		node := n
+		return &node
+	}
+	if full(len(a.infixExpressions), cap(a.infixExpressions)) {
+		a.infixExpressions = make([]ast.InfixExpression, 0, arenaChunk)
+	}
+	a.infixExpressions = append(a.infixExpressions, n)
+	return &a.infixExpressions[len(a.infixExpressions)-1]
+}
+
+func (a *arena) callExpression(n ast.CallExpression) *ast.CallExpression {
+	if a == nil {
+		node := n
+		return &node
+	}
+	if full(len(a.callExpressions), cap(a.callExpressions)) {
+		a.callExpressions = make([]ast.CallExpression, 0, arenaChunk)
+	}
+	a.callExpressions = append(a.callExpressions, n)
+	return &a.callExpressions[len(a.callExpressions)-1]
+}
+
+func (a *arena) indexExpression(n ast.IndexExpression) *ast.IndexExpression {
+	if a == nil {
+		node := n
+		return &node
+	}
+	if full(len(a.indexExpressions), cap(a.indexExpressions)) {
+		a.indexExpressions = make([]ast.IndexExpression, 0, arenaChunk)
+	}
+	a.indexExpressions = append(a.indexExpressions, n)
+	return &a.indexExpressions[len(a.indexExpressions)-1]
+}
+
+func (a *arena) expressionStatement(n ast.ExpressionStatement) *ast.ExpressionStatement {
+	if a == nil {
+		node := n
+		return &node
+	}
+	if full(len(a.expressionStatements), cap(a.expressionStatements)) {
+		a.expressionStatements = make([]ast.ExpressionStatement, 0, arenaChunk)
+	}
+	a.expressionStatements = append(a.expressionStatements, n)
+	return &a.expressionStatements[len(a.expressionStatements)-1]
+}
+
+func (a *arena) letStatement(n ast.LetStatement) *ast.LetStatement {
+	if a == nil {
+		node := n
+		return &node
+	}
+	if full(len(a.letStatements), cap(a.letStatements)) {
+		a.letStatements = make([]ast.LetStatement, 0, arenaChunk)
+	}
+	a.letStatements = append(a.letStatements, n)
+	return &a.letStatements[len(a.letStatements)-1]
+}
+
+func (a *arena) blockStatement(n ast.BlockStatement) *ast.BlockStatement {
+	if a == nil {
+		node := n
+		return &node
+	}
+	if full(len(a.blockStatements), cap(a.blockStatements)) {
+		a.blockStatements = make([]ast.BlockStatement, 0, arenaChunk)
+	}
+	a.blockStatements = append(a.blockStatements, n)
+	return &a.blockStatements[len(a.blockStatements)-1]
+}
@@ -6,8 +6,11 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/lang"
 	"github.com/cedrickchee/hou/lexer"
 	"github.com/cedrickchee/hou/token"
 )
@@ -19,13 +22,17 @@ import (
 const (
 	_           int = iota
 	LOWEST          // lowest possible precedence
+	ASSIGN          // = or +=
+	COALESCE        // ??
 	EQUALS          // ==
 	LESSGREATER     // > or <
 	SUM             // +
 	PRODUCT         // *
 	PREFIX          // -X or !X
+	POWER           // X ** Y, so -2 ** 2 is -(2 ** 2)
 	CALL            // myFunction(X)
 	INDEX           // array[index]
+	MEMBER          // hash.member
 )
 
 // Precedence table for infix expression.
@@ -35,12 +42,27 @@ var precedences = map[token.TokenType]int{
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.LT_EQ:    LESSGREATER,
+	token.GT_EQ:    LESSGREATER,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
+	token.PERCENT:  PRODUCT,
+	token.POW:      POWER,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
+	token.DOT:      MEMBER,
+
+	// ?? binds looser than comparisons, so `a ?? b == c` compares b.
+	token.COALESCE: COALESCE,
+
+	// Assignments bind looser than all the other operators.
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
 }
 
 // Pratt parser's idea is the association of parsing functions with token types.
@@ -60,6 +82,12 @@ type Parser struct {
 	l *lexer.Lexer
 
 	errors []string
+	// diagnostics holds the errors in errors with their codes and positions.
+	diagnostics []diagnostic.Diagnostic
+	// recovering is set by the first error in a statement. The parser is lost
+	// until it synchronizes on the next statement, so the errors it finds
+	// until then are follow-on errors of the first one and aren't reported.
+	recovering bool
 
 	curToken  token.Token
 	peekToken token.Token
@@ -68,6 +96,37 @@ type Parser struct {
 	// token type.
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	// functions is the stack of function literals being parsed, innermost
+	// last. A `yield` turns the innermost one into a generator.
+	functions []*ast.FunctionLiteral
+
+	// traceLevel is the indentation of the tracing output, see trace.
+	traceLevel int
+
+	// arena allocates the nodes, if the parser was made by NewWithArena.
+	arena *arena
+
+	// features are the features of the language version of the program, see
+	// SetVersion.
+	features lang.FeatureSet
+	// keyword is the identifier that is a keyword of a later version whose
+	// call is being parsed, e.g. `for` in `for (x in xs) { ... }`, which is
+	// likely a loop of that version rather than a call. Errors at other
+	// keywords of later versions in the call, like `in`, are reported as
+	// the keyword needing the version.
+	keyword *ast.Identifier
+}
+
+// NewWithArena constructs a new Parser like New that allocates the nodes of
+// the AST in bulk rather than one by one, which is faster and easier on the
+// garbage collector for large programs. The nodes are kept in memory
+// together, until none of them are referenced anymore, so don't keep a few
+// nodes of a large program around longer than the rest.
+func NewWithArena(l *lexer.Lexer) *Parser {
+	p := New(l)
+	p.arena = &arena{}
+	return p
 }
 
 // New constructs a new Parser with a Lexer as input.
@@ -81,16 +140,23 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
+	p.registerPrefix(token.NULL, p.parseNull)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.WHILE, p.parseWhileExpression)
+	p.registerPrefix(token.FOR, p.parseForInExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.YIELD, p.parseYieldExpression)
+	p.registerPrefix(token.SPAWN, p.parseSpawnExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -101,9 +167,21 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POW, p.parsePowerExpression)
+	p.registerInfix(token.COALESCE, p.parseInfixExpression)
+
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parseMemberExpression)
 
 	// Read two tokens, so curToken and peekToken are both set.
 	p.nextToken()
@@ -117,18 +195,103 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// Diagnostics returns the errors the parser encountered with their codes and
+// positions, for tools.
+func (p *Parser) Diagnostics() []diagnostic.Diagnostic {
+	return p.diagnostics
+}
+
+// addError records an error with its code, found at the token tok, unless
+// it's a follow-on error of an earlier one in the same statement.
+func (p *Parser) addError(code diagnostic.Code, tok token.Token, msg string) {
+	if p.recovering {
+		return
+	}
+	if _, later := p.laterKeyword(&ast.Identifier{Token: tok, Value: tok.Literal}); later && p.keyword != nil {
+		code, tok, msg = p.keywordError(p.keyword)
+	}
+	p.recovering = true
+	p.report(code, tok, msg)
+}
+
+// report records an error like addError, even while recovering. It's for the
+// errors found by the lexer, which aren't follow-on errors of anything.
+func (p *Parser) report(code diagnostic.Code, tok token.Token, msg string) {
+	p.errors = append(p.errors, msg)
+	p.diagnostics = append(p.diagnostics, diagnostic.New(code, tok.Position, msg))
+}
+
+// SetVersion sets the version of the language to parse programs as, unless
+// they name one with a pragma. It must be called before parsing.
+func (p *Parser) SetVersion(v lang.Version) {
+	p.features = lang.For(v)
+	p.checkKeyword(&p.curToken)
+	p.checkKeyword(&p.peekToken)
+}
+
+// checkKeyword makes tok an identifier if it's a keyword of a version after
+// the one being parsed, and a keyword again if it is one of the version.
+func (p *Parser) checkKeyword(tok *token.Token) {
+	f, ok := lang.Keyword(tok.Literal)
+	if !ok {
+		return
+	}
+	keyword := token.LookupIdent(tok.Literal)
+	if tok.Type != token.IDENT && tok.Type != keyword {
+		// Not an identifier or keyword, e.g. a string.
+		return
+	}
+	tok.Type = token.IDENT
+	if p.features.Has(f) {
+		tok.Type = keyword
+	}
+}
+
+// laterKeyword reports whether the expression is an identifier that is a
+// keyword of a later version than the one being parsed.
+func (p *Parser) laterKeyword(exp ast.Expression) (*ast.Identifier, bool) {
+	ident, ok := exp.(*ast.Identifier)
+	if !ok {
+		return nil, false
+	}
+	f, ok := lang.Keyword(ident.Value)
+	return ident, ok && !p.features.Has(f)
+}
+
+// keywordError returns the error for the identifier, a keyword of a later
+// version used as one.
+func (p *Parser) keywordError(ident *ast.Identifier) (diagnostic.Code, token.Token, string) {
+	f, _ := lang.Keyword(ident.Value)
+	msg := fmt.Sprintf("%s requires #pragma version %s (%s)", ident.Value, f.Since(), f)
+	return diagnostic.KeywordNeedsVersion, ident.Token, msg
+}
+
 // Add an error to errors when the type of peekToken doesn’t match the
 // expectation.
 func (p *Parser) peekError(t token.TokenType) {
 	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
 		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.addError(diagnostic.UnexpectedToken, p.peekToken, msg)
 }
 
 // Helper method that advances both curToken and peekToken.
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	// The parser needs the literals of all tokens, even if the lexer is
+	// lazy.
+	if p.peekToken.Literal == "" {
+		p.peekToken.Literal = p.l.Literal(p.peekToken)
+	}
+	if p.peekToken.Type == token.ILLEGAL && p.peekToken.Literal == "/*" {
+		// The lexer read a block comment to the end of the input without
+		// finding its closing */, so the program ends there.
+		p.report(diagnostic.UnterminatedComment, p.peekToken,
+			"unterminated block comment")
+		p.peekToken.Type = token.EOF
+		p.peekToken.Literal = ""
+	}
+	p.checkKeyword(&p.peekToken)
 }
 
 // ParseProgram starts the parsing process and is the entry point for all other
@@ -138,21 +301,139 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
 	program.Statements = []ast.Statement{}
 
+	// Pragmas come first, since they tell how to parse the rest.
+	for p.curToken.Type == token.PRAGMA {
+		p.parsePragma()
+		p.recovering = false
+		p.nextToken()
+	}
+	program.Features = p.features
+
 	// Iterate over every token in the input until it encounters an token.EOF
 	// token.
 	for p.curToken.Type != token.EOF {
-		stmt := p.parseStatement()
+		stmt, ok := p.parseStatementOrSync(false)
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
-		p.nextToken()
+		if ok {
+			p.nextToken()
+		}
 	}
+
+	if !p.features.Has(lang.NullLiteral) {
+		p.checkNull(program)
+	}
+	resolve(program)
 	return program
 }
 
+// checkNull reports the uses of `null` in a program of a version without the
+// null literal, unless the program binds `null` itself, which it can since
+// it isn't a keyword there.
+func (p *Parser) checkNull(program *ast.Program) {
+	var uses []*ast.Identifier
+	bound := false
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			bound = bound || node.Name.Value == "null"
+		case *ast.FunctionLiteral:
+			bound = bound || (node.Name != nil && node.Name.Value == "null")
+			for _, param := range node.Parameters {
+				bound = bound || param.Value == "null"
+			}
+		case *ast.Identifier:
+			if node.Value == "null" {
+				uses = append(uses, node)
+			}
+		}
+		return true
+	})
+	if bound || len(uses) == 0 {
+		return
+	}
+	// Report the first use only, like the first error of a statement.
+	p.report(p.keywordError(uses[0]))
+}
+
+// parsePragma parses the pragma in curToken, e.g. `#pragma version 2`.
+func (p *Parser) parsePragma() {
+	fields := strings.Fields(p.curToken.Literal)
+	if len(fields) != 2 || fields[0] != "version" {
+		p.addError(diagnostic.InvalidPragma, p.curToken,
+			fmt.Sprintf("unknown pragma %q", p.curToken.Literal))
+		return
+	}
+	v, err := lang.ParseVersion(fields[1])
+	if err != nil {
+		p.addError(diagnostic.InvalidPragma, p.curToken, err.Error())
+		return
+	}
+	p.SetVersion(v)
+}
+
+// parseStatementOrSync parses a statement of a program, or of a block if
+// inBlock, like parseStatement. If the statement has an error, it then skips
+// to the next statement with synchronize, and ok is false since curToken is
+// already the first token after the statement.
+func (p *Parser) parseStatementOrSync(inBlock bool) (stmt ast.Statement, ok bool) {
+	if p.recovering {
+		// The statement is in a block of a statement with an error, which
+		// synchronizes when it's done.
+		return p.parseStatement(), true
+	}
+	stmt = p.parseStatement()
+	if !p.recovering {
+		return stmt, true
+	}
+	p.synchronize(inBlock)
+	p.recovering = false
+	return stmt, false
+}
+
+// synchronize skips the rest of a statement with an error, so that parsing
+// carries on with the next statement, and a program with several mistakes
+// gets an error for each of them rather than a cascade for the first one.
+//
+// The statement ends at a semicolon, before the next let or return, or before
+// the closing brace of the block it's in, if inBlock. Blocks in the statement
+// are skipped as a whole, and a stray closing brace in a program is skipped
+// as part of the statement.
+func (p *Parser) synchronize(inBlock bool) {
+	depth := 0
+	for first := true; !p.curTokenIs(token.EOF); first = false {
+		switch p.curToken.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth > 0 {
+				depth--
+			} else if inBlock {
+				return
+			}
+		case token.SEMICOLON:
+			if depth == 0 {
+				p.nextToken()
+				return
+			}
+		case token.LET, token.RETURN:
+			// The statement with the error may start with one.
+			if depth == 0 && !first {
+				return
+			}
+		}
+		p.nextToken()
+	}
+}
+
 // Parse a statement.
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
+	case token.PRAGMA:
+		p.addError(diagnostic.InvalidPragma, p.curToken,
+			"pragmas must come before the first statement")
+		return nil
 	case token.LET:
 		return p.parseLetStatement()
 	case token.RETURN:
@@ -165,7 +446,7 @@ func (p *Parser) parseStatement() ast.Statement {
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	// Constructs an *ast.LetStatement node with the token it’s currently
 	// sitting on (a token.LET token).
-	stmt := &ast.LetStatement{Token: p.curToken}
+	stmt := p.arena.letStatement(ast.LetStatement{Token: p.curToken})
 
 	// Advances the tokens while making assertions about the next token.
 	if !p.expectPeek(token.IDENT) {
@@ -173,7 +454,15 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	}
 
 	// Use token.IDENT token to construct an *ast.Identifier node.
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	stmt.Name = p.curIdentifier()
+
+	// The type annotation is optional: let x: Int = 5;
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		if stmt.Type = p.parseTypeName(); stmt.Type == nil {
+			return nil
+		}
+	}
 
 	// Expects an equal sign and jumps over the expression following the
 	// equal sign.
@@ -185,7 +474,9 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Value = p.parseExpression(LOWEST)
 
-	for p.peekTokenIs(token.SEMICOLON) {
+	// After an error, the semicolon may not be the one of this statement,
+	// e.g. in `{ x + }; y`, so synchronize deals with it.
+	for !p.recovering && p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -199,7 +490,7 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt.ReturnValue = p.parseExpression(LOWEST)
 
 	// Take care of optional semicolons.
-	if p.peekTokenIs(token.SEMICOLON) {
+	if !p.recovering && p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -208,12 +499,14 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 // The top-level method that kicks off expression parsing.
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
-	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt := p.arena.expressionStatement(ast.ExpressionStatement{
+		Token: p.curToken,
+	})
 
 	stmt.Expression = p.parseExpression(LOWEST)
 
 	// Take care of optional semicolons.
-	if p.peekTokenIs(token.SEMICOLON) {
+	if !p.recovering && p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -259,21 +552,44 @@ func (p *Parser) parseIdentifier() ast.Expression {
 	// start with curToken being the type of token you’re associated with and
 	// return with curToken being the last token that’s part of your expression
 	// type. Never advance the tokens too far.
-	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	return p.curIdentifier()
+}
+
+// curIdentifier returns the identifier that's the current token.
+func (p *Parser) curIdentifier() *ast.Identifier {
+	return p.arena.identifier(ast.Identifier{
+		Token: p.curToken,
+		Value: p.curToken.Literal,
+	})
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.addError(diagnostic.NoPrefixParseFn, p.curToken, msg)
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
-	lit := &ast.IntegerLiteral{Token: p.curToken}
+	lit := p.arena.integerLiteral(ast.IntegerLiteral{Token: p.curToken})
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(diagnostic.InvalidInteger, p.curToken, msg)
+		return nil
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		msg := fmt.Sprintf("could not parse %q as float", p.curToken.Literal)
+		p.addError(diagnostic.InvalidFloat, p.curToken, msg)
 		return nil
 	}
 
@@ -283,14 +599,17 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 }
 
 func (p *Parser) parseStringLiteral() ast.Expression {
-	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	return p.arena.stringLiteral(ast.StringLiteral{
+		Token: p.curToken,
+		Value: p.curToken.Literal,
+	})
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
-	expression := &ast.PrefixExpression{
+	expression := p.arena.prefixExpression(ast.PrefixExpression{
 		Token:    p.curToken,
 		Operator: p.curToken.Literal,
-	}
+	})
 
 	// Advances our tokens in order to correctly parse a prefix expression
 	// like `-5` more than one token has to be "consumed".
@@ -304,11 +623,11 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 }
 
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
-	expression := &ast.InfixExpression{
+	expression := p.arena.infixExpression(ast.InfixExpression{
 		Token:    p.curToken, // the operator of the infix expression
 		Operator: p.curToken.Literal,
 		Left:     left,
-	}
+	})
 
 	// Precedence of the operator token.
 	precedence := p.curPrecedence()
@@ -318,12 +637,63 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// parsePowerExpression parses an exponentiation. It's right-associative, so
+// 2 ** 3 ** 2 is 2 ** (3 ** 2): the operand on the right is parsed with a
+// lower precedence, which lets it take the next ** in.
+func (p *Parser) parsePowerExpression(left ast.Expression) ast.Expression {
+	expression := p.arena.infixExpression(ast.InfixExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Left:     left,
+	})
+
+	precedence := p.curPrecedence()
+	p.nextToken()
+	expression.Right = p.parseExpression(precedence - 1)
+
+	return expression
+}
+
+// parseAssignExpression parses an assignment to the name or the element of an
+// array or hash on its left, e.g. a[0] = 1. Unlike the other infix operators,
+// assignments are right-associative, so a = b = 1 assigns 1 to b and then to
+// a.
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+	}
+
+	switch left := left.(type) {
+	case *ast.Identifier:
+		expression.Name = left
+	case *ast.IndexExpression:
+		expression.Index = left
+	default:
+		msg := fmt.Sprintf("cannot assign to %s", left)
+		p.addError(diagnostic.InvalidAssignment, p.curToken, msg)
+		return nil
+	}
+
+	p.nextToken()
+	expression.Value = p.parseExpression(ASSIGN - 1)
+
+	return expression
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	// The structure of our parser serves us well.
 	// That actually is one of the beauties of Pratt's approach: it's so easy
 	// to extend.
 
-	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
+	return p.arena.boolean(ast.Boolean{
+		Token: p.curToken,
+		Value: p.curTokenIs(token.TRUE),
+	})
+}
+
+func (p *Parser) parseNull() ast.Expression {
+	return &ast.Null{Token: p.curToken}
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
@@ -384,7 +754,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 }
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
-	block := &ast.BlockStatement{Token: p.curToken}
+	block := p.arena.blockStatement(ast.BlockStatement{Token: p.curToken})
 	block.Statements = []ast.Statement{}
 
 	p.nextToken()
@@ -393,16 +763,137 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	// the end of the block statement, or a token.EOF, which tells us that
 	// there’s no more tokens left to parse.
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
+		stmt, ok := p.parseStatementOrSync(true)
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
-		p.nextToken()
+		if ok {
+			p.nextToken()
+		}
 	}
 
 	return block
 }
 
+func (p *Parser) parseWhileExpression() ast.Expression {
+	expression := &ast.WhileExpression{Token: p.curToken}
+
+	// The condition and the body are parsed like the ones of an if.
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Body = p.parseBlockStatement()
+
+	return expression
+}
+
+func (p *Parser) parseForInExpression() ast.Expression {
+	expression := &ast.ForInExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	// One name, or two separated by a comma, then `in`.
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	expression.Value = p.curIdentifier()
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		expression.Key = expression.Value
+		expression.Value = p.curIdentifier()
+	}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Body = p.parseBlockStatement()
+
+	return expression
+}
+
+// parseMatchExpression parses a match expression. Every case has one value or
+// more separated by commas, and the default case, if there's one, comes last.
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for p.peekTokenIs(token.CASE) {
+		p.nextToken()
+		c := &ast.MatchCase{Token: p.curToken}
+
+		p.nextToken()
+		c.Values = append(c.Values, p.parseExpression(LOWEST))
+		for p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+			p.nextToken()
+			c.Values = append(c.Values, p.parseExpression(LOWEST))
+		}
+
+		if !p.expectPeek(token.COLON) || !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		c.Body = p.parseBlockStatement()
+		expression.Cases = append(expression.Cases, c)
+	}
+
+	if p.peekTokenIs(token.DEFAULT) {
+		p.nextToken()
+		if !p.expectPeek(token.COLON) || !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Default = p.parseBlockStatement()
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return expression
+}
+
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	// One of the great things about our parser is that once we define function
 	// literals as expressions and provide a function to correctly parse them
@@ -410,51 +901,150 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 
 	lit := &ast.FunctionLiteral{Token: p.curToken}
 
+	// The name is optional: fn fact(n) { ... }
+	if p.peekTokenIs(token.IDENT) {
+		p.nextToken()
+		lit.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	lit.Parameters, lit.ParameterTypes, lit.Defaults = p.parseFunctionParameters()
+
+	// The result type is optional: fn(x) -> Int { ... }
+	if p.peekTokenIs(token.ARROW) {
+		p.nextToken()
+		if lit.ReturnType = p.parseTypeName(); lit.ReturnType == nil {
+			return nil
+		}
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
 
+	p.functions = append(p.functions, lit)
 	lit.Body = p.parseBlockStatement()
+	p.functions = p.functions[:len(p.functions)-1]
 
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	// Method to parse the literal's parameters.
+// parseTypeName parses the type of a type annotation, which follows the
+// current token, e.g. a colon.
+func (p *Parser) parseTypeName() *ast.TypeName {
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	return &ast.TypeName{Token: p.curToken, Name: p.curToken.Literal}
+}
+
+func (p *Parser) parseYieldExpression() ast.Expression {
+	expression := &ast.YieldExpression{Token: p.curToken}
+
+	if len(p.functions) == 0 {
+		p.addError(diagnostic.YieldOutsideFunction, p.curToken,
+			"yield outside of a function")
+		return nil
+	}
+	p.functions[len(p.functions)-1].Generator = true
+
+	p.nextToken()
+	expression.Value = p.parseExpression(LOWEST)
+	if expression.Value == nil {
+		return nil
+	}
+
+	return expression
+}
+
+// parseSpawnExpression parses `spawn fn() { ... }`. The lexer only makes spawn
+// a keyword before `fn`, so the function literal follows it.
+func (p *Parser) parseSpawnExpression() ast.Expression {
+	expression := &ast.SpawnExpression{Token: p.curToken}
+	if !p.expectPeek(token.FUNCTION) {
+		return nil
+	}
+	fn, ok := p.parseFunctionLiteral().(*ast.FunctionLiteral)
+	if !ok {
+		return nil
+	}
+	expression.Function = fn
+	return expression
+}
+
+func (p *Parser) parseFunctionParameters() (
+	[]*ast.Identifier,
+	[]*ast.TypeName,
+	[]ast.Expression,
+) {
+	// Method to parse the literal's parameters and their optional type
+	// annotations and default values.
 
 	identifiers := []*ast.Identifier{}
+	var types []*ast.TypeName
+	var defaults []ast.Expression
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return identifiers, types, defaults
 	}
 
-	p.nextToken()
-
 	// Constructs the slice of parameters by repeatedly building identifiers
 	// from the comma separated list. It also makes an early exit if the list is
 	// empty and it carefully handles lists of varying sizes.
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
-
-	for p.peekTokenIs(token.COMMA) {
+	for {
 		p.nextToken()
+		identifiers = append(identifiers, p.curIdentifier())
+
+		var typ *ast.TypeName
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			if typ = p.parseTypeName(); typ == nil {
+				return nil, nil, nil
+			}
+			if types == nil {
+				types = make([]*ast.TypeName, len(identifiers)-1, len(identifiers))
+			}
+		}
+		if types != nil {
+			types = append(types, typ)
+		}
+
+		// The default value is optional too: fn(a, b = 10) { ... }, but
+		// once a parameter has one, the following ones need one as well.
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			value := p.parseExpression(LOWEST)
+			if value == nil {
+				return nil, nil, nil
+			}
+			if defaults == nil {
+				defaults = make([]ast.Expression, len(identifiers)-1, len(identifiers))
+			}
+			defaults = append(defaults, value)
+		} else if defaults != nil {
+			param := identifiers[len(identifiers)-1]
+			msg := fmt.Sprintf("parameter %s needs a default value, since %s has one",
+				param, identifiers[len(identifiers)-2])
+			p.addError(diagnostic.MissingDefault, param.Token, msg)
+			return nil, nil, nil
+		}
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 		p.nextToken()
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
 	}
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return nil, nil, nil
 	}
 
-	return identifiers
+	return identifiers, types, defaults
 
 	// For a method like this it really pays off to have another set of tests
 	// that check the edge cases: an empty parameter list, a list with one
@@ -463,8 +1053,26 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
-	exp := &ast.CallExpression{Token: p.curToken, Function: function}
+	exp := p.arena.callExpression(ast.CallExpression{
+		Token:    p.curToken,
+		Function: function,
+	})
+	keyword, later := p.laterKeyword(function)
+	if !later {
+		exp.Arguments = p.parseExpressionList(token.RPAREN)
+		return exp
+	}
+
+	// A call of a keyword of a later version followed by a block, e.g.
+	// `while (x) { ... }`, or with arguments that don't parse, e.g.
+	// `for (x in xs)`, is a use of the keyword.
+	outer := p.keyword
+	p.keyword = keyword
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	if p.peekTokenIs(token.LBRACE) {
+		p.addError(p.keywordError(keyword))
+	}
+	p.keyword = outer
 	return exp
 }
 
@@ -509,7 +1117,10 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	exp := p.arena.indexExpression(ast.IndexExpression{
+		Token: p.curToken,
+		Left:  left,
+	})
 
 	p.nextToken()
 	exp.Index = p.parseExpression(LOWEST)
@@ -521,6 +1132,19 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// parseMemberExpression parses `left.name`. The name is an identifier, so a
+// hash key that isn't one, e.g. "first name", still needs the index operator.
+func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
+	exp := &ast.MemberExpression{Token: p.curToken, Object: left}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Property = p.curIdentifier()
+
+	return exp
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
@@ -537,6 +1161,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		value := p.parseExpression(LOWEST)
 
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
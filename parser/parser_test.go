@@ -2,9 +2,12 @@ package parser
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/lang"
 	"github.com/cedrickchee/hou/lexer"
 )
 
@@ -142,6 +145,42 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e9;", 1e9},
+		{"2.5e-3;", 0.0025},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+		if literal.String() != strings.TrimSuffix(tt.input, ";") {
+			t.Errorf("literal.String() wrong. got=%s", literal.String())
+		}
+	}
+
+	p := New(lexer.New("1e999"))
+	p.ParseProgram()
+	if len(p.Errors()) != 1 || p.Errors()[0] != `could not parse "1e999" as float` {
+		t.Errorf("wrong errors for 1e999. got=%q", p.Errors())
+	}
+}
+
 func TestParsingPrefixExpression(t *testing.T) {
 	// There are two prefix operators in the Monkey programming language: `!`
 	// and `-`. The structure of their usage is:
@@ -209,6 +248,10 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"5 < 5;", 5, "<", 5},
 		{"5 == 5;", 5, "==", 5},
 		{"5 != 5;", 5, "!=", 5},
+		{"5 >= 5;", 5, ">=", 5},
+		{"5 <= 5;", 5, "<=", 5},
+		{"5 % 5;", 5, "%", 5},
+		{"5 ** 5;", 5, "**", 5},
 		{"true == true", true, "==", true},
 		{"true != false", true, "!=", false},
 		{"false == false", false, "==", false},
@@ -337,6 +380,28 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"!(true == true)",
 			"(!(true == true))",
 		},
+		{
+			"a + b % c * d",
+			"(a + ((b % c) * d))",
+		},
+		{
+			"a <= b == b >= c",
+			"((a <= b) == (b >= c))",
+		},
+		{
+			// Exponentiation is right-associative, and binds tighter than
+			// prefix operators but looser than calls.
+			"a ** b ** c",
+			"(a ** (b ** c))",
+		},
+		{
+			"-a ** -b * c",
+			"((-(a ** (-b))) * c)",
+		},
+		{
+			"a ** f(b)[0]",
+			"(a ** (f(b)[0]))",
+		},
 		{
 			"a + add(b * c) + d",
 			"((a + add((b * c))) + d)",
@@ -357,6 +422,22 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a ?? b == c ?? d",
+			"((a ?? (b == c)) ?? d)",
+		},
+		{
+			"x = a ?? b + 1",
+			"(x = (a ?? (b + 1)))",
+		},
+		{
+			"-a.b.c * d.e",
+			"((-((a.b).c)) * (d.e))",
+		},
+		{
+			"a.b[1].c(2) ** x.y",
+			"((((a.b)[1]).c)(2) ** (x.y))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -544,6 +625,295 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestNullLiteral(t *testing.T) {
+	p := New(lexer.New("#pragma version 2\nnull"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	if _, ok := stmt.Expression.(*ast.Null); !ok {
+		t.Fatalf("exp not *ast.Null. got=%T", stmt.Expression)
+	}
+
+	// Before version 2, null is a name like any other.
+	p = New(lexer.New("let null = 1; null"))
+	program = p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt = program.Statements[1].(*ast.ExpressionStatement)
+	testIdentifier(t, stmt.Expression, "null")
+}
+
+func TestLaterKeywords(t *testing.T) {
+	// Keywords of version 2 used as such in a script of version 1 are
+	// reported as needing the version, while they can still be names.
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x < 3) { x += 1 }", "1:1: error E1010: while requires #pragma version 2 (while loops)"},
+		{"let y = while (true) {}", "1:9: error E1010: while requires #pragma version 2 (while loops)"},
+		{"for (x in [1, 2]) { x }", "1:1: error E1010: for requires #pragma version 2 (for loops)"},
+		{"let y = match (x) { case 1: { 2 } }", "1:9: error E1010: match requires #pragma version 2 (match expressions)"},
+		{"let x = null;", "1:9: error E1010: null requires #pragma version 2 (the null literal)"},
+		{"let while = fn(x) { x }; while(1)", ""},
+		{"let for = fn(x) { x }; for(1 2)", "1:30: error E1001: expected next token to be ), got INT instead"},
+		{"let f = fn(null) { null }; f(1)", ""},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		got := ""
+		if diagnostics := p.Diagnostics(); len(diagnostics) > 0 {
+			got = diagnostics[0].String()
+		}
+		if got != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestWhileExpression(t *testing.T) {
+	input := "#pragma version 2\nwhile (x < y) { x }"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+	exp, ok := stmt.Expression.(*ast.WhileExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.WhileExpression. got=%T",
+			stmt.Expression)
+	}
+	if !testInfixExpression(t, exp.Condition, "x", "<", "y") {
+		return
+	}
+	if len(exp.Body.Statements) != 1 {
+		t.Errorf("body is not 1 statements. got=%d\n", len(exp.Body.Statements))
+	}
+	body, ok := exp.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("Statements[0] is not ast.ExpressionStatement. got=%T",
+			exp.Body.Statements[0])
+	}
+	if !testIdentifier(t, body.Expression, "x") {
+		return
+	}
+	if exp.String() != "while(x < y) x" {
+		t.Errorf("exp.String() wrong. got=%q", exp.String())
+	}
+
+	// Before version 2, while is an identifier.
+	p = New(lexer.New("let while = 1; while"))
+	program = p.ParseProgram()
+	checkParserErrors(t, p)
+	if program.String() != "let while = 1;while" {
+		t.Errorf("while isn't an identifier in version 1. got=%q", program.String())
+	}
+}
+
+func TestForInExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		key      string
+		value    string
+		iterable string
+		expected string
+	}{
+		{"for (x in xs) { x }", "", "x", "xs", "for(x in xs) x"},
+		{"for (k, v in h) { k }", "k", "v", "h", "for(k, v in h) k"},
+		{"for (c in \"ab\" + s) { c }", "", "c", "(ab + s)", "for(c in (ab + s)) c"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New("#pragma version 2\n" + tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+				1, len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+		exp, ok := stmt.Expression.(*ast.ForInExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.ForInExpression. got=%T",
+				stmt.Expression)
+		}
+		if tt.key == "" && exp.Key != nil {
+			t.Errorf("%q: unexpected key %s", tt.input, exp.Key)
+		} else if tt.key != "" && !testIdentifier(t, exp.Key, tt.key) {
+			continue
+		}
+		if !testIdentifier(t, exp.Value, tt.value) {
+			continue
+		}
+		if exp.Iterable.String() != tt.iterable {
+			t.Errorf("%q: wrong iterable. want=%q, got=%q", tt.input, tt.iterable,
+				exp.Iterable.String())
+		}
+		if exp.String() != tt.expected {
+			t.Errorf("exp.String() wrong. want=%q, got=%q", tt.expected, exp.String())
+		}
+	}
+
+	for _, input := range []string{"for (x) {}", "for (1 in xs) {}", "for (a, b, c in xs) {}", "for (x in xs) x"} {
+		p := New(lexer.New("#pragma version 2\n" + input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected parser errors", input)
+		}
+	}
+
+	// Before version 2, for and in are identifiers.
+	p := New(lexer.New("let for = 1; let in = 2; for + in"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if program.String() != "let for = 1;let in = 2;(for + in)" {
+		t.Errorf("for and in aren't identifiers in version 1. got=%q", program.String())
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"match (x) {}", "match(x) { }"},
+		{"match (x) { case 1: { a } }", "match(x) { case 1: a }"},
+		{
+			`match (x + 1) { case 1, "a": { a } case f(y): { } default: { b } }`,
+			"match((x + 1)) { case 1, a: a case f(y):  default: b }",
+		},
+		{"match (x) { default: { b } }", "match(x) { default: b }"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New("#pragma version 2\n" + tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+				1, len(program.Statements))
+		}
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.MatchExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.MatchExpression. got=%T",
+				stmt.Expression)
+		}
+		if exp.String() != tt.expected {
+			t.Errorf("exp.String() wrong. want=%q, got=%q", tt.expected, exp.String())
+		}
+	}
+
+	for _, input := range []string{
+		"match x { case 1: { 1 } }",
+		"match (x) { case: { 1 } }",
+		"match (x) { case 1 { 1 } }",
+		"match (x) { case 1: 1 }",
+		"match (x) { default: { 1 } case 1: { 1 } }",
+		"match (x) { default: { 1 } default: { 2 } }",
+		"match (x) { case 1: { 1 }",
+	} {
+		p := New(lexer.New("#pragma version 2\n" + input))
+		p.ParseProgram()
+		if len(p.Errors()) != 1 {
+			t.Errorf("%q: expected one parser error. got=%v", input, p.Errors())
+		}
+	}
+
+	// Before version 2, match, case and default are identifiers.
+	p := New(lexer.New("let match = 1; let case = 2; let default = 3; match + case + default"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if program.String() != "let match = 1;let case = 2;let default = 3;((match + case) + default)" {
+		t.Errorf("match, case and default aren't identifiers in version 1. got=%q",
+			program.String())
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		name     string
+		operator string
+		expected string
+	}{
+		{"x = 5;", "x", "=", "(x = 5)"},
+		{"x += y * 2", "x", "+=", "(x += (y * 2))"},
+		{"x -= 1", "x", "-=", "(x -= 1)"},
+		{"x *= 2 + 3", "x", "*=", "(x *= (2 + 3))"},
+		{"x /= 2", "x", "/=", "(x /= 2)"},
+		// Assignments are right-associative.
+		{"a = b = 1", "a", "=", "(a = (b = 1))"},
+		{"a = b == c", "a", "=", "(a = (b == c))"},
+		// Elements of arrays and hashes can be assigned to too.
+		{"a[0] = 1", "(a[0])", "=", "((a[0]) = 1)"},
+		{`h["k"][i + 1] += 2`, "((h[k])[(i + 1)])", "+=", "(((h[k])[(i + 1)]) += 2)"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+		exp, ok := stmt.Expression.(*ast.AssignExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.AssignExpression. got=%T",
+				stmt.Expression)
+		}
+		if exp.Index != nil {
+			if exp.Name != nil || exp.Index.String() != tt.name {
+				t.Errorf("wrong target. want=%q, got=%v and %v", tt.name, exp.Name, exp.Index)
+			}
+		} else if !testIdentifier(t, exp.Name, tt.name) {
+			return
+		}
+		if exp.Operator != tt.operator {
+			t.Errorf("exp.Operator is not %q. got=%q", tt.operator, exp.Operator)
+		}
+		if exp.String() != tt.expected {
+			t.Errorf("exp.String() wrong. want=%q, got=%q", tt.expected, exp.String())
+		}
+	}
+
+	// Only names and elements can be assigned to.
+	for _, input := range []string{"5 = 1", "f() = 2", "-a[0] = 1"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) == 0 || diagnostics[0].Code != diagnostic.InvalidAssignment {
+			t.Errorf("%q: expected an invalid assignment error. got=%v", input, diagnostics)
+		}
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	// The test has three main parts.
 
@@ -599,6 +969,210 @@ func TestFunctionLiteralParsing(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestNamedFunctionLiteralParsing(t *testing.T) {
+	input := `fn fact(n) { n }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	function, ok := stmt.Expression.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.FunctionLiteral. got=%T",
+			stmt.Expression)
+	}
+
+	if function.Name == nil || function.Name.Value != "fact" {
+		t.Fatalf("function.Name is not 'fact'. got=%v", function.Name)
+	}
+	if len(function.Parameters) != 1 {
+		t.Fatalf("function literal parameters wrong. want 1, got=%d\n",
+			len(function.Parameters))
+	}
+	testLiteralExpression(t, function.Parameters[0], "n")
+
+	if function.String() != "fn fact(n) n" {
+		t.Errorf("function.String() wrong. got=%q", function.String())
+	}
+}
+
+func TestYieldExpressionParsing(t *testing.T) {
+	input := `fn(n) { yield n; let inner = fn() { 1 }; yield n + 1 }`
+
+	l := lexer.New(input)
+	p := New(l)
+	p.SetVersion(lang.Generators.Since())
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	function, ok := stmt.Expression.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.FunctionLiteral. got=%T",
+			stmt.Expression)
+	}
+	if !function.Generator {
+		t.Errorf("function.Generator is not true")
+	}
+
+	// Only the function containing the yield is a generator.
+	let := function.Body.Statements[1].(*ast.LetStatement)
+	if let.Value.(*ast.FunctionLiteral).Generator {
+		t.Errorf("inner function.Generator is not false")
+	}
+
+	yield, ok := function.Body.Statements[2].(*ast.ExpressionStatement).Expression.(*ast.YieldExpression)
+	if !ok {
+		t.Fatalf("statement is not ast.YieldExpression. got=%T",
+			function.Body.Statements[2])
+	}
+	testInfixExpression(t, yield.Value, "n", "+", 1)
+
+	p = New(lexer.New(`yield 1`))
+	p.SetVersion(lang.Generators.Since())
+	p.ParseProgram()
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0] != "yield outside of a function" {
+		t.Errorf("wrong parser errors. got=%q", errors)
+	}
+
+	// Before version 3, yield is a name like any other.
+	p = New(lexer.New(`let yield = 1; fn() { yield }`))
+	p.ParseProgram()
+	checkParserErrors(t, p)
+}
+
+func TestSpawnExpressionParsing(t *testing.T) {
+	input := `spawn fn(x) { x }; spawn(f)`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d",
+			len(program.Statements))
+	}
+	spawn, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.SpawnExpression)
+	if !ok {
+		t.Fatalf("statement is not ast.SpawnExpression. got=%T",
+			program.Statements[0])
+	}
+	if len(spawn.Function.Parameters) != 1 {
+		t.Errorf("wrong number of parameters. want=1, got=%d",
+			len(spawn.Function.Parameters))
+	}
+
+	// Without a function after it, spawn is the builtin's name.
+	if _, ok := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression); !ok {
+		t.Errorf("statement is not ast.CallExpression. got=%T",
+			program.Statements[1])
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	p := New(lexer.New("let x = 5; // five\nlet = 10;\nlet y 99999999999999999999;\n/* the end"))
+	p.ParseProgram()
+
+	expected := []string{
+		"2:5: error E1001: expected next token to be IDENT, got = instead",
+		"3:7: error E1001: expected next token to be =, got INT instead",
+		"4:1: error E1007: unterminated block comment",
+	}
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("wrong number of diagnostics. want=%d, got=%d (%v)",
+			len(expected), len(diagnostics), diagnostics)
+	}
+	for i, d := range diagnostics {
+		if d.String() != expected[i] {
+			t.Errorf("diagnostics[%d] wrong. want=%q, got=%q",
+				i, expected[i], d.String())
+		}
+		if d.Message != p.Errors()[i] {
+			t.Errorf("diagnostics[%d] doesn't match the error %q", i, p.Errors()[i])
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	// After an error, the parser skips to the next statement, so each mistake
+	// gets one error, and the statements after it are still parsed.
+	tests := []struct {
+		input          string
+		expectedErrors []string
+		statements     int
+	}{
+		{
+			"let x = (1 + ;\nlet y = 2;\nlet = 3",
+			[]string{
+				"1:14: error E1002: no prefix parse function for ; found",
+				"3:5: error E1001: expected next token to be IDENT, got = instead",
+			},
+			3,
+		},
+		{
+			// A let starts the next statement, even without a semicolon.
+			"let = 1 let y = 2",
+			[]string{"1:5: error E1001: expected next token to be IDENT, got = instead"},
+			2,
+		},
+		{
+			// The block of the if is skipped as a whole.
+			"if (x { let = 1; }\nlet z = ;",
+			[]string{
+				"1:7: error E1001: expected next token to be ), got { instead",
+				"2:9: error E1002: no prefix parse function for ; found",
+			},
+			2,
+		},
+		{
+			// Statements in blocks recover up to the end of the block.
+			"let f = fn(x) { let = 1; x + }; f(1); }",
+			[]string{
+				"1:21: error E1001: expected next token to be IDENT, got = instead",
+				"1:30: error E1002: no prefix parse function for } found",
+				"1:39: error E1002: no prefix parse function for } found",
+			},
+			3,
+		},
+		{
+			"if (true) { return }\nlet 5",
+			[]string{
+				"1:20: error E1002: no prefix parse function for } found",
+				"2:5: error E1001: expected next token to be IDENT, got INT instead",
+			},
+			2,
+		},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != len(tt.expectedErrors) {
+			t.Errorf("%q: wrong number of diagnostics. want=%d, got=%d (%v)",
+				tt.input, len(tt.expectedErrors), len(diagnostics), diagnostics)
+			continue
+		}
+		for i, d := range diagnostics {
+			if d.String() != tt.expectedErrors[i] {
+				t.Errorf("%q: diagnostics[%d] wrong. want=%q, got=%q",
+					tt.input, i, tt.expectedErrors[i], d.String())
+			}
+		}
+		if len(program.Statements) != tt.statements {
+			t.Errorf("%q: wrong number of statements. want=%d, got=%d",
+				tt.input, tt.statements, len(program.Statements))
+		}
+	}
+}
+
 func TestFunctionParameterParsing(t *testing.T) {
 	// Another set of tests (in addition to TestFunctionLiteralParsing) that
 	// check the edge cases: an empty parameter list, a list with one parameter
@@ -633,6 +1207,90 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestDefaultParameterParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		defaults []string
+	}{
+		{"fn(a, b = 10) { a };", "fn(a, b = 10) a", []string{"", "10"}},
+		{"fn(a: Int = 1 + 2) { a };", "fn(a: Int = (1 + 2)) a", []string{"(1 + 2)"}},
+		{"fn(a, b = a, c = fn() { b }) { c };", "fn(a, b = a, c = fn() b) c", []string{"", "a", "fn() b"}},
+		{"fn(a, b) { a };", "fn(a, b) a", nil},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program. want=%q, got=%q", tt.expected, program.String())
+		}
+		function := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+		var defaults []string
+		for _, value := range function.Defaults {
+			if value == nil {
+				defaults = append(defaults, "")
+			} else {
+				defaults = append(defaults, value.String())
+			}
+		}
+		if fmt.Sprintf("%q", defaults) != fmt.Sprintf("%q", tt.defaults) {
+			t.Errorf("%q: wrong defaults. want=%q, got=%q", tt.input, tt.defaults, defaults)
+		}
+	}
+
+	p := New(lexer.New("fn(a = 1, b: Int) {}"))
+	p.ParseProgram()
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].String() !=
+		"1:11: error E1009: parameter b needs a default value, since a has one" {
+		t.Errorf("wrong diagnostics. got=%v", diagnostics)
+	}
+}
+
+func TestTypeAnnotationParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: Int = 5;", "let x: Int = 5;"},
+		{"let x = 5;", "let x = 5;"},
+		{"fn(a: Int, b) -> Bool { a };", "fn(a: Int, b) -> Bool a"},
+		{"fn(a, b) { a };", "fn(a, b) a"},
+		{"fn() -> String { \"s\" };", "fn() -> String s"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program. want=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	program := New(lexer.New("fn(a, b: Int) {}")).ParseProgram()
+	function := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if len(function.ParameterTypes) != 2 || function.ParameterTypes[0] != nil ||
+		function.ParameterTypes[1].Name != "Int" {
+		t.Errorf("wrong parameter types. got=%v", function.ParameterTypes)
+	}
+	if function.ReturnType != nil {
+		t.Errorf("unexpected return type %v", function.ReturnType)
+	}
+
+	for _, input := range []string{"let x: = 5;", "fn(a: 5) {}", "fn() -> {}"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected parser errors", input)
+		}
+	}
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	// The test case for call expressions is just like the rest of our test
 	// suite and makes assertions about the *ast.CallExpression structure.
@@ -824,6 +1482,37 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingMemberExpressions(t *testing.T) {
+	input := "person.name"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	memberExp, ok := stmt.Expression.(*ast.MemberExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.MemberExpression. got=%T", stmt.Expression)
+	}
+
+	if !testIdentifier(t, memberExp.Object, "person") {
+		return
+	}
+	if !testIdentifier(t, memberExp.Property, "name") {
+		return
+	}
+
+	// The member must be named by an identifier.
+	for _, input := range []string{`person."name"`, "person.1", "person."} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) != 1 {
+			t.Errorf("%q: expected one error. got=%v", input, p.Errors())
+		}
+	}
+}
+
 func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	input := `{"one": 1, "two": 2, "three": 3}`
 
@@ -1143,3 +1832,211 @@ func checkParserErrors(t *testing.T, p *Parser) {
 	}
 	t.FailNow()
 }
+
+func TestNewWithArena(t *testing.T) {
+	// More nodes of every type than fit in a chunk.
+	input := strings.Repeat(`let x = f(-a[1], "s" + 2 * 3, true); if (x) { x };
+`, arenaChunk+1)
+
+	expected := New(lexer.New(input)).ParseProgram()
+	p := NewWithArena(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if program.String() != expected.String() {
+		t.Fatalf("wrong program. want=%q, got=%q",
+			expected.String(), program.String())
+	}
+}
+
+func TestPragmas(t *testing.T) {
+	tests := []struct {
+		input    string
+		version  lang.Version
+		expected lang.Version
+	}{
+		{"let x = 1;", 0, lang.Default},
+		{"let x = 1;", 2, 2},
+		{"#pragma version 2\nlet x = 1;", 0, 2},
+		// A pragma takes precedence over SetVersion.
+		{"#pragma version 1\nlet x = 1;", 2, 1},
+		{"#pragma   version 2  \n#pragma version 1\n", 0, 1},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		if tt.version != 0 {
+			p.SetVersion(tt.version)
+		}
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if v := program.Features.Version(); v != tt.expected {
+			t.Errorf("%q: wrong version. want=%d, got=%d", tt.input, tt.expected, v)
+		}
+	}
+
+	program := New(lexer.New("#pragma version 2\nlet x = 1;")).ParseProgram()
+	if program.String() != "#pragma version 2\nlet x = 1;" {
+		t.Errorf("wrong program. got=%q", program.String())
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{"#pragma version 9", `1:1: error E1005: unknown language version "9", want 1 to 3`},
+		{"#pragma strict", `1:1: error E1005: unknown pragma "strict"`},
+		{"let x = 1;\n#pragma version 2", "2:1: error E1005: pragmas must come before the first statement"},
+		{"#include", "1:1: error E1002: no prefix parse function for ILLEGAL found"},
+	}
+
+	for _, tt := range errors {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) == 0 || diagnostics[0].String() != tt.expected {
+			t.Errorf("%q: wrong diagnostics. want=%q, got=%v", tt.input, tt.expected, diagnostics)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	// The expected Skip of the identifiers looked up, in source order.
+	tests := []struct {
+		input string
+		skips []int
+	}{
+		{"a", []int{0}},
+		{"fn(x) { x + a }", []int{0, 1}},
+		{"fn(x) { fn(y) { x + y + a } }", []int{1, 0, 2}},
+		// A let binds the name in the whole function, even before it.
+		{"fn() { a; let a = 1; a }", []int{0, 0}},
+		{"fn() { if (true) { let a = 1; } fn() { a } }", []int{1}},
+		// The value of a let is looked up, not its name.
+		{"fn(x) { let a = fn() { x }; }", []int{1}},
+		{"let f = fn(n) { f(n - 1) }", []int{1, 0}},
+		// The name of a named function is bound between the function and
+		// the scope it's defined in.
+		{"let f = fn g(n) { g(n - 1) + f }", []int{1, 0, 2}},
+		// With block scoping, the blocks of ifs are scopes too.
+		{"#pragma version 2\nfn() { if (true) { let a = 1; a } a }", []int{0, 1}},
+		{"#pragma version 2\nif (x) { let a = 1; fn() { a + b } }", []int{0, 1, 2}},
+		// And so are the bodies of while loops.
+		{"#pragma version 2\nfn() { while (a) { let a = 1; fn() { a } } }", []int{1, 1}},
+		// And so are the bodies of for loops, which bind the names of the
+		// loop too. The first identifier is the name of the loop.
+		{"#pragma version 2\nfn() { for (x in a) { let a = 1; fn() { x + a } } }", []int{0, 1, 1, 1}},
+		// And so are the cases of match expressions.
+		{"#pragma version 2\nfn() { match (a) { case a: { let a = 1; fn() { a } } default: { a } } }", []int{1, 1, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		program := New(lexer.New(tt.input)).ParseProgram()
+
+		var skips []int
+		var inspect func(ast.Node) bool
+		inspect = func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.LetStatement:
+				ast.Inspect(node.Value, inspect)
+				return false
+			case *ast.FunctionLiteral:
+				ast.Inspect(node.Body, inspect)
+				return false
+			case *ast.Identifier:
+				skips = append(skips, node.Skip)
+			}
+			return true
+		}
+		ast.Inspect(program, inspect)
+
+		if fmt.Sprint(skips) != fmt.Sprint(tt.skips) {
+			t.Errorf("wrong skips for %q. want=%v, got=%v", tt.input, tt.skips, skips)
+		}
+	}
+}
+
+func TestResolveSlots(t *testing.T) {
+	// The Slot of the identifiers, let names and parameters in source order,
+	// and the Names of the blocks that are scopes.
+	tests := []struct {
+		input string
+		slots []int
+		names [][]string
+	}{
+		{"let a = 1; a", []int{0, 0}, nil},
+		{"fn(x, y) { let a = x; let x = 2; y + a + b }", []int{1, 2, 3, 1, 1, 2, 3, 0},
+			[][]string{{"x", "y", "a"}}},
+		{"fn(x) { fn() { x } }", []int{1, 1}, [][]string{{"x"}, nil}},
+		// Without block scoping, lets in ifs bind names in the function.
+		{"fn() { if (true) { let a = 1; } a }", []int{1, 1}, [][]string{{"a"}, nil}},
+		{"#pragma version 2\nfn() { if (true) { let a = 1; } a }", []int{1, 0},
+			[][]string{nil, {"a"}}},
+		{"#pragma version 2\nfor (k, v in a) { let v = k; }", []int{1, 2, 0, 2, 1},
+			[][]string{{"k", "v"}}},
+	}
+
+	for _, tt := range tests {
+		program := New(lexer.New(tt.input)).ParseProgram()
+
+		var slots []int
+		var names [][]string
+		ast.Inspect(program, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.Identifier:
+				slots = append(slots, node.Slot)
+			case *ast.BlockStatement:
+				names = append(names, node.Names)
+			}
+			return true
+		})
+
+		if fmt.Sprint(slots) != fmt.Sprint(tt.slots) {
+			t.Errorf("wrong slots for %q. want=%v, got=%v", tt.input, tt.slots, slots)
+		}
+		if fmt.Sprint(names) != fmt.Sprint(tt.names) {
+			t.Errorf("wrong names for %q. want=%v, got=%v", tt.input, tt.names, names)
+		}
+	}
+}
+
+// benchmarkInput is a large program, like the ones tools parse.
+var benchmarkInput = strings.Repeat(`
+let fib = fn(n) {
+	if (n < 2) { return n; }
+	fib(n - 1) + fib(n - 2)
+};
+let people = [{"name": "alice", "age": 30}, {"name": "bob", "age": 25}];
+let ages = fn(xs) { if (len(xs) == 0) { [] } else { push(ages(rest(xs)), first(xs)["age"]) } };
+puts(ages(people)[0] * -2 != !true);
+`, 1000)
+
+func BenchmarkParseProgram(b *testing.B) {
+	benchmarkParse(b, New, lexer.NewBytes)
+}
+
+func BenchmarkParseProgramLazy(b *testing.B) {
+	benchmarkParse(b, New, lexer.NewLazy)
+}
+
+func BenchmarkParseProgramArena(b *testing.B) {
+	benchmarkParse(b, NewWithArena, lexer.NewBytes)
+}
+
+func benchmarkParse(
+	b *testing.B,
+	newParser func(*lexer.Lexer) *Parser,
+	newLexer func([]byte) *lexer.Lexer,
+) {
+	input := []byte(benchmarkInput)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	for i := 0; i < b.N; i++ {
+		p := newParser(newLexer(input))
+		p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			b.Fatalf("parser errors: %v", p.Errors())
+		}
+	}
+}
@@ -16,27 +16,27 @@ import (
 parser/parser.go
 
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
-    defer untrace(trace("parseExpressionStatement"))
+    defer p.untrace(p.trace("parseExpressionStatement"))
 	   // [...]
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
-    defer untrace(trace("parseExpression"))
+    defer p.untrace(p.trace("parseExpression"))
 	   // [...]
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
-    defer untrace(trace("parseIntegerLiteral"))
+    defer p.untrace(p.trace("parseIntegerLiteral"))
     // [...]
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
-    defer untrace(trace("parsePrefixExpression"))
+    defer p.untrace(p.trace("parsePrefixExpression"))
     // [...]
 }
 
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
-    defer untrace(trace("parseInfixExpression"))
+    defer p.untrace(p.trace("parseInfixExpression"))
     // [...]
 }
 */
@@ -74,28 +74,30 @@ END parseExpressionStatement
 PASS
 */
 
-var traceLevel int = 0
+// The trace level is kept in the Parser rather than in a package variable, so
+// that parsers running in different goroutines don't mess up each other's
+// indentation.
 
 const traceIdentPlaceholder string = "\t"
 
-func identLevel() string {
-	return strings.Repeat(traceIdentPlaceholder, traceLevel-1)
+func (p *Parser) identLevel() string {
+	return strings.Repeat(traceIdentPlaceholder, p.traceLevel-1)
 }
 
-func tracePrint(fs string) {
-	fmt.Printf("%s%s\n", identLevel(), fs)
+func (p *Parser) tracePrint(fs string) {
+	fmt.Printf("%s%s\n", p.identLevel(), fs)
 }
 
-func incIdent() { traceLevel = traceLevel + 1 }
-func decIdent() { traceLevel = traceLevel - 1 }
+func (p *Parser) incIdent() { p.traceLevel = p.traceLevel + 1 }
+func (p *Parser) decIdent() { p.traceLevel = p.traceLevel - 1 }
 
-func trace(msg string) string {
-	incIdent()
-	tracePrint("BEGIN " + msg)
+func (p *Parser) trace(msg string) string {
+	p.incIdent()
+	p.tracePrint("BEGIN " + msg)
 	return msg
 }
 
-func untrace(msg string) {
-	tracePrint("END " + msg)
-	decIdent()
+func (p *Parser) untrace(msg string) {
+	p.tracePrint("END " + msg)
+	p.decIdent()
 }
@@ -0,0 +1,209 @@
+package parser
+
+import (
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/lang"
+)
+
+// resolve is a pass over the parsed program that records in every identifier
+// how many of the functions enclosing it don't bind it, see
+// ast.Identifier.Skip. The evaluator creates an environment for every function
+// call and nowhere else, and only parameters and let statements bind names in
+// them, so it can skip looking the identifier up in the environments of those
+// functions. References to globals and builtins from deep inside closures and
+// recursive functions then need one lookup instead of one per enclosing
+// function.
+//
+// A function binds a name if any let statement in it does, even one that's
+// never executed, so the pass never skips an environment that could bind
+// the name.
+//
+// With lang.BlockScoping, the evaluator creates an environment for the blocks
+// of if expressions and the bodies of while loops too, and the let statements
+// in them bind names there. The bodies of for loops, which came in the same
+// version, are always scopes, which bind the names of the loop too, and so
+// are the cases of match expressions. Named functions, like `fn fact(n)`,
+// are defined in an environment of their own binding their name.
+//
+// Within the scopes, the pass also numbers the names each one binds, and
+// records the numbers in the identifiers that refer to them, see
+// ast.Identifier.Slot, and the names in the blocks, see
+// ast.BlockStatement.Names. The evaluator binds the names in slots of the
+// environments of the scopes, so looking a local name up is indexing a
+// slice rather than looking the name up in a map in every environment from
+// the innermost one out. Globals are still looked up by name, since the
+// REPL and embedding applications bind them too.
+func resolve(program *ast.Program) {
+	r := &resolver{blocks: program.Features.Has(lang.BlockScoping)}
+	r.resolveIn(program, nil)
+}
+
+// resolver holds the options of the resolve pass.
+type resolver struct {
+	// blocks is set if the blocks of if expressions and while loops are
+	// scopes.
+	blocks bool
+}
+
+// resolveIn resolves the identifiers in node, which is enclosed by scopes
+// binding the names in scopes to their slots, innermost last.
+func (r *resolver) resolveIn(node ast.Node, scopes []map[string]int) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			// The name of a named function is bound in a scope of its own,
+			// between the scope the function is defined in and the one of
+			// its calls.
+			outer := scopes
+			if n.Name != nil {
+				n.Name.Skip, n.Name.Slot = 0, 1
+				outer = enclose(scopes, map[string]int{n.Name.Value: 0})
+			}
+			names := make([]string, len(n.Parameters))
+			for i, param := range n.Parameters {
+				names[i] = param.Value
+			}
+			slots := r.scope(n.Body, names...)
+			for _, param := range n.Parameters {
+				param.Skip, param.Slot = 0, slots[param.Value]+1
+			}
+			// Default values are evaluated in the environment of the call,
+			// like the body.
+			inner := enclose(outer, slots)
+			for _, value := range n.Defaults {
+				if value != nil {
+					r.resolveIn(value, inner)
+				}
+			}
+			r.resolveIn(n.Body, inner)
+			return false
+
+		case *ast.IfExpression:
+			if !r.blocks {
+				break
+			}
+			r.resolveIn(n.Condition, scopes)
+			r.resolveBlock(n.Consequence, scopes)
+			if n.Alternative != nil {
+				r.resolveBlock(n.Alternative, scopes)
+			}
+			return false
+
+		case *ast.WhileExpression:
+			if !r.blocks {
+				break
+			}
+			r.resolveIn(n.Condition, scopes)
+			r.resolveBlock(n.Body, scopes)
+			return false
+
+		case *ast.ForInExpression:
+			r.resolveIn(n.Iterable, scopes)
+			names := []string{n.Value.Value}
+			if n.Key != nil {
+				names = []string{n.Key.Value, n.Value.Value}
+			}
+			slots := r.scope(n.Body, names...)
+			if n.Key != nil {
+				n.Key.Skip, n.Key.Slot = 0, slots[n.Key.Value]+1
+			}
+			n.Value.Skip, n.Value.Slot = 0, slots[n.Value.Value]+1
+			r.resolveIn(n.Body, enclose(scopes, slots))
+			return false
+
+		case *ast.MatchExpression:
+			r.resolveIn(n.Subject, scopes)
+			for _, c := range n.Cases {
+				for _, v := range c.Values {
+					r.resolveIn(v, scopes)
+				}
+				r.resolveBlock(c.Body, scopes)
+			}
+			if n.Default != nil {
+				r.resolveBlock(n.Default, scopes)
+			}
+			return false
+
+		case *ast.MemberExpression:
+			// The property is a name of a member, not of a variable.
+			r.resolveIn(n.Object, scopes)
+			return false
+
+		case *ast.LetStatement:
+			// The name is bound, not looked up, in the innermost scope.
+			r.resolveIn(n.Value, scopes)
+			if n.Name != nil {
+				n.Name.Skip, n.Name.Slot = 0, 0
+				if len(scopes) > 0 {
+					if slot, ok := scopes[len(scopes)-1][n.Name.Value]; ok {
+						n.Name.Slot = slot + 1
+					}
+				}
+			}
+			return false
+
+		case *ast.Identifier:
+			n.Skip, n.Slot = 0, 0
+			for i := len(scopes) - 1; i >= 0; i-- {
+				if slot, ok := scopes[i][n.Value]; ok {
+					n.Slot = slot + 1
+					break
+				}
+				n.Skip++
+			}
+		}
+		return true
+	})
+}
+
+// resolveBlock resolves the identifiers in the block, which is a scope of its
+// own that only binds the names of its let statements.
+func (r *resolver) resolveBlock(block *ast.BlockStatement, scopes []map[string]int) {
+	r.resolveIn(block, enclose(scopes, r.scope(block)))
+}
+
+// enclose returns scopes with the scope binding slots innermost, without
+// changing scopes.
+func enclose(scopes []map[string]int, slots map[string]int) []map[string]int {
+	inner := make([]map[string]int, len(scopes), len(scopes)+1)
+	copy(inner, scopes)
+	return append(inner, slots)
+}
+
+// scope records in the block, which is a scope, the names it binds: names, and
+// then the ones its let statements bind. It returns their slots.
+func (r *resolver) scope(block *ast.BlockStatement, names ...string) map[string]int {
+	slots := map[string]int{}
+	block.Names = nil
+	for _, name := range append(names, r.bindings(block)...) {
+		if _, ok := slots[name]; !ok {
+			slots[name] = len(block.Names)
+			block.Names = append(block.Names, name)
+		}
+	}
+	return slots
+}
+
+// bindings returns the names the let statements in the block bind in its
+// environment, in order: the ones in it, but not in the functions, for loops
+// and match expressions in it, or in the blocks of if expressions and while
+// loops if they're scopes.
+func (r *resolver) bindings(block *ast.BlockStatement) []string {
+	var names []string
+	ast.Inspect(block, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral, *ast.ForInExpression, *ast.MatchExpression:
+			return false
+		case *ast.IfExpression, *ast.WhileExpression:
+			if r.blocks {
+				return false
+			}
+		case *ast.LetStatement:
+			if n.Name != nil {
+				names = append(names, n.Name.Value)
+			}
+		}
+		return true
+	})
+	return names
+}
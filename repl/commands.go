@@ -0,0 +1,160 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/object"
+)
+
+// commandsHelp lists the commands of the REPL.
+const commandsHelp = `:help            print this help
+:quit            leave the REPL
+:env             print the bindings of the session
+:reset           forget the bindings of the session
+:load <file>     evaluate the file in the session
+:save <file>     save the bindings of the session to the file
+:restore <file>  replace the bindings of the session with the saved ones
+:type <expr>     print the type of the value of the expression
+:trace           turn tracing every node evaluated on or off
+:maxdepth [n]    print or set the maximum depth of calls
+:lang [n]        print or set the version of the language of the inputs
+`
+
+// command runs the REPL command cmd, e.g. `:load lib.hou`. It returns false
+// if the REPL should stop.
+func (s *session) command(cmd string) bool {
+	name, arg := cmd, ""
+	if i := strings.IndexAny(cmd, " \t"); i >= 0 {
+		name, arg = cmd[:i], strings.TrimSpace(cmd[i:])
+	}
+
+	out := s.opts.Out
+	switch name {
+	case ":help":
+		io.WriteString(out, commandsHelp)
+
+	case ":quit":
+		return false
+
+	case ":env":
+		for _, name := range s.env.Names() {
+			value, _ := s.env.Get(name)
+			fmt.Fprintf(out, "%s = %s\n", name, value.Inspect())
+		}
+
+	case ":reset":
+		s.env = object.NewSynchronizedEnvironment()
+		io.WriteString(out, "bindings reset\n")
+
+	case ":load":
+		if arg == "" {
+			fmt.Fprintln(s.opts.Err, "usage: :load <file>")
+			break
+		}
+		src, err := ioutil.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintln(s.opts.Err, err)
+			break
+		}
+		// The lines of the file are numbered as lines of the session, so
+		// that the functions it defines show their source in backtraces.
+		input := string(src)
+		if !strings.HasSuffix(input, "\n") {
+			input += "\n"
+		}
+		if _, ok := s.evaluate(input); ok {
+			fmt.Fprintf(out, "loaded %s\n", arg)
+		}
+
+	case ":save":
+		if arg == "" {
+			fmt.Fprintln(s.opts.Err, "usage: :save <file>")
+			break
+		}
+		data, skipped, err := s.env.Snapshot().Encode()
+		if err == nil {
+			err = ioutil.WriteFile(arg, data, 0666)
+		}
+		if err != nil {
+			fmt.Fprintln(s.opts.Err, err)
+			break
+		}
+		if len(skipped) > 0 {
+			fmt.Fprintf(s.opts.Err, "can't save %s\n", strings.Join(skipped, ", "))
+		}
+		fmt.Fprintf(out, "saved %s\n", arg)
+
+	case ":restore":
+		if arg == "" {
+			fmt.Fprintln(s.opts.Err, "usage: :restore <file>")
+			break
+		}
+		data, err := ioutil.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintln(s.opts.Err, err)
+			break
+		}
+		snapshot, err := object.DecodeSnapshot(data)
+		if err != nil {
+			fmt.Fprintf(s.opts.Err, "%s: %s\n", arg, err)
+			break
+		}
+		s.env.Restore(snapshot)
+		fmt.Fprintf(out, "restored %s\n", arg)
+
+	case ":type":
+		if arg == "" {
+			fmt.Fprintln(s.opts.Err, "usage: :type <expr>")
+			break
+		}
+		if value, ok := s.evaluate(arg + "\n"); ok && value != nil {
+			fmt.Fprintln(out, value.Type())
+		}
+
+	case ":trace":
+		// Toggles printing every node evaluated and its result to the error
+		// stream.
+		if s.eval.Hooks == nil {
+			s.eval.Hooks = s.eval.TraceHooks(s.opts.Err)
+			io.WriteString(out, "tracing on\n")
+		} else {
+			s.eval.Hooks = nil
+			io.WriteString(out, "tracing off\n")
+		}
+
+	case ":maxdepth":
+		if arg == "" {
+			fmt.Fprintln(out, s.eval.MaxCallDepth())
+			break
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			fmt.Fprintln(s.opts.Err, "usage: :maxdepth [n], n > 0")
+			break
+		}
+		s.eval.MaxDepth = n
+		fmt.Fprintf(out, "max depth %d\n", n)
+
+	case ":lang":
+		if arg == "" {
+			fmt.Fprintln(out, s.version)
+			break
+		}
+		v, err := lang.ParseVersion(arg)
+		if err != nil {
+			fmt.Fprintf(s.opts.Err, "%s\n", err)
+			break
+		}
+		s.version = v
+		fmt.Fprintf(out, "language version %s\n", v)
+
+	default:
+		fmt.Fprintf(s.opts.Err, "unknown command %s, see :help\n", name)
+	}
+	return !s.exited
+}
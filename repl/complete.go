@@ -0,0 +1,84 @@
+package repl
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/token"
+)
+
+// complete returns the completions of the word before the cursor, given the
+// line up to the cursor, and the number of characters of the word: the
+// keywords, the builtins and the names bound in the session that start with
+// the word, or the commands of the REPL that start with a line like `:lo`.
+// The arguments of commands are completed for :type, whose argument is an
+// expression.
+func (s *session) complete(before string) ([]string, int) {
+	if strings.HasPrefix(before, ":") {
+		fields := strings.Fields(before)
+		if !strings.ContainsAny(before, " \t") {
+			return withPrefix(commandNames(), before), utf8.RuneCountInString(before)
+		}
+		if fields[0] != ":type" {
+			return nil, 0
+		}
+	}
+
+	start := len(before)
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(before[:start])
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			break
+		}
+		start -= size
+	}
+	word := before[start:]
+	// Numbers aren't names, and the keys of hashes after a dot aren't known
+	// before the hash is evaluated.
+	if r, _ := utf8.DecodeRuneInString(word); word == "" || unicode.IsDigit(r) ||
+		strings.HasSuffix(before[:start], ".") {
+		return nil, 0
+	}
+
+	// The keywords of versions after the one of the session are names.
+	features := lang.For(s.version)
+	var names []string
+	for _, keyword := range token.Keywords() {
+		if f, ok := lang.Keyword(keyword); !ok || features.Has(f) {
+			names = append(names, keyword)
+		}
+	}
+	names = append(names, s.eval.BuiltinNames()...)
+	names = append(names, s.env.Names()...)
+	sort.Strings(names)
+
+	return withPrefix(names, word), utf8.RuneCountInString(word)
+}
+
+// withPrefix returns the sorted names that start with prefix, without
+// duplicates.
+func withPrefix(names []string, prefix string) []string {
+	var matches []string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) &&
+			(len(matches) == 0 || matches[len(matches)-1] != name) {
+			matches = append(matches, name)
+		}
+	}
+	return matches
+}
+
+// commandNames returns the names of the commands of the REPL, e.g. `:load`.
+func commandNames() []string {
+	var names []string
+	for _, line := range strings.Split(commandsHelp, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			names = append(names, fields[0])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
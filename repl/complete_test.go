@@ -0,0 +1,50 @@
+package repl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/object"
+)
+
+func TestComplete(t *testing.T) {
+	s := &session{env: object.NewEnvironment(), eval: evaluator.New()}
+	s.env.Set("results", &object.Integer{Value: 1})
+	s.env.Set("rest", &object.Integer{Value: 2})
+	s.env.Set("lenient", &object.Integer{Value: 3})
+
+	tests := []struct {
+		before     string
+		candidates []string
+		word       int
+	}{
+		{"re", []string{"read", "readFile", "readLine", "recv", "reduce", "replace", "rest", "results", "return"}, 2},
+		{"let x = res", []string{"rest", "results"}, 3},
+		{"le", []string{"len", "lenient", "let"}, 2},
+		{"puts(fi", []string{"filter", "first"}, 2},
+		// Keywords of later versions are names in the REPL.
+		{"whi", nil, 3},
+		{"h.re", nil, 0},
+		{"12", nil, 0},
+		{"x + ", nil, 0},
+		{":lo", []string{":load"}, 3},
+		{":load fi", nil, 0},
+		{":type fi", []string{"filter", "first"}, 2},
+	}
+
+	for _, tt := range tests {
+		candidates, word := s.complete(tt.before)
+		if fmt.Sprint(candidates) != fmt.Sprint(tt.candidates) || word != tt.word {
+			t.Errorf("wrong completions of %q. want=%v (%d), got=%v (%d)",
+				tt.before, tt.candidates, tt.word, candidates, word)
+		}
+	}
+
+	// They're keywords once the session reads a later version.
+	s.version = lang.Version(2)
+	if candidates, _ := s.complete("whi"); fmt.Sprint(candidates) != "[while]" {
+		t.Errorf("wrong completions of %q in version 2. got=%v", "whi", candidates)
+	}
+}
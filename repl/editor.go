@@ -0,0 +1,504 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// maxHistory is the number of lines of history the editor keeps.
+const maxHistory = 1000
+
+// errInterrupted is returned by the editor when Ctrl-C is typed, to drop the
+// input typed so far.
+var errInterrupted = errors.New("interrupted")
+
+// editor edits the lines typed in a terminal, in the style of readline:
+//
+//	Left, Right, Ctrl-B, Ctrl-F   move the cursor by a character
+//	Home, End, Ctrl-A, Ctrl-E     move the cursor to the start or end
+//	Backspace, Delete, Ctrl-D     delete a character
+//	Ctrl-K, Ctrl-U, Ctrl-W        delete to the end, to the start, a word
+//	Up, Down, Ctrl-P, Ctrl-N      recall the previous or next line
+//	Ctrl-R                        search the history backwards
+//	Tab                           complete the word, list the completions
+//	Ctrl-L                        clear the screen
+//	Ctrl-C                        drop the input
+//	Ctrl-D                        end the session, on an empty line
+type editor struct {
+	in  *bufio.Reader
+	out io.Writer
+	// raw puts the terminal in raw mode while a line is read, and returns a
+	// function restoring its mode. It's nil if there's nothing to switch.
+	raw func() (func(), error)
+
+	// history holds the lines read, oldest first. They're appended to
+	// historyFile, if it's set, to recall them in later sessions.
+	// fileLines is the number of lines in historyFile, which is trimmed to
+	// the last maxHistory once it holds twice as many.
+	history     []string
+	historyFile string
+	fileLines   int
+
+	// complete returns the words that complete the one before the cursor,
+	// given the line up to the cursor, and the number of characters of the
+	// word. Words aren't completed if it's nil.
+	complete func(before string) (candidates []string, word int)
+
+	// The line being edited, and the position of the cursor in it.
+	prompt string
+	line   []rune
+	pos    int
+}
+
+// newEditor returns an editor for the lines typed in the terminal fd, whose
+// history is kept in historyFile, if it's set.
+func newEditor(in *bufio.Reader, out io.Writer, fd uintptr, historyFile string) *editor {
+	e := &editor{
+		in:          in,
+		out:         out,
+		raw:         func() (func(), error) { return makeRaw(fd) },
+		historyFile: historyFile,
+	}
+	e.loadHistory()
+	return e
+}
+
+// defaultHistoryFile returns the file the history of the REPL is kept in,
+// ~/.hou_history, or "" if there's no home directory.
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".hou_history")
+}
+
+// loadHistory reads the history of earlier sessions. The history is a
+// convenience, so it's not an error if it can't be read.
+func (e *editor) loadHistory() {
+	if e.historyFile == "" {
+		return
+	}
+	lines, err := readHistory(e.historyFile)
+	if err != nil {
+		return
+	}
+	e.fileLines = len(lines)
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+	}
+	e.history = lines
+}
+
+// readHistory returns the lines of the history file that aren't blank.
+func readHistory(file string) ([]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// addHistory adds the line read to the history, unless it's empty or it
+// repeats the last one.
+func (e *editor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" ||
+		len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+
+	if e.historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.historyFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, line)
+	f.Close()
+
+	e.fileLines++
+	if e.fileLines >= 2*maxHistory {
+		e.trimHistory()
+	}
+}
+
+// trimHistory rewrites the history file with its last maxHistory lines. It
+// reads the file again rather than writing the history of the editor, so the
+// lines other sessions appended meanwhile are kept, and replaces it with a
+// new file, so a session that fails half way leaves the old one.
+func (e *editor) trimHistory() {
+	lines, err := readHistory(e.historyFile)
+	if err != nil {
+		return
+	}
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(e.historyFile), filepath.Base(e.historyFile))
+	if err != nil {
+		return
+	}
+	_, err = io.WriteString(f, strings.Join(lines, "\n")+"\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || os.Rename(f.Name(), e.historyFile) != nil {
+		os.Remove(f.Name())
+		return
+	}
+	e.fileLines = len(lines)
+}
+
+// key is a key typed: the character typed, a control character like
+// ctrl('a'), or one of the keys without a character below.
+type key rune
+
+const (
+	keyUnknown key = -1 - iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+)
+
+const (
+	keyTab       key = '\t'
+	keyEnter     key = '\r'
+	keyEscape    key = 27
+	keyBackspace key = 127
+)
+
+// ctrl returns the key typed with the control key and the letter c.
+func ctrl(c byte) key {
+	return key(c & 0x1f)
+}
+
+// readKey reads a key, decoding the escape sequences terminals send for the
+// keys without characters, e.g. ESC [ A for Up.
+func (e *editor) readKey() (key, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	// Terminals write escape sequences at once, so an escape that isn't
+	// followed by more input is the escape key, which is ignored.
+	if key(r) != keyEscape || e.in.Buffered() == 0 {
+		return key(r), nil
+	}
+
+	if b, _ := e.in.ReadByte(); b != '[' && b != 'O' {
+		return keyUnknown, nil
+	}
+	var params []byte
+	for {
+		b, err := e.in.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b >= '0' && b <= '9' || b == ';' {
+			params = append(params, b)
+			continue
+		}
+
+		switch b {
+		case 'A':
+			return keyUp, nil
+		case 'B':
+			return keyDown, nil
+		case 'C':
+			return keyRight, nil
+		case 'D':
+			return keyLeft, nil
+		case 'H':
+			return keyHome, nil
+		case 'F':
+			return keyEnd, nil
+		case '~':
+			switch string(params) {
+			case "1", "7":
+				return keyHome, nil
+			case "4", "8":
+				return keyEnd, nil
+			case "3":
+				return keyDelete, nil
+			}
+		}
+		return keyUnknown, nil
+	}
+}
+
+// readLine prints the prompt and returns the line typed and edited after it,
+// without its newline. It returns io.EOF at the end of the input, and
+// errInterrupted if Ctrl-C was typed.
+func (e *editor) readLine(prompt string) (string, error) {
+	if e.raw != nil {
+		restore, err := e.raw()
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+
+	e.prompt, e.line, e.pos = prompt, nil, 0
+	// recalled is the index in the history of the line shown, or the length
+	// of the history for the new line, which draft keeps while it's not.
+	recalled := len(e.history)
+	var draft []rune
+
+	recall := func(i int) {
+		if i < 0 || i > len(e.history) || i == recalled {
+			return
+		}
+		if recalled == len(e.history) {
+			draft = e.line
+		}
+		recalled = i
+		if i == len(e.history) {
+			e.line = draft
+		} else {
+			e.line = []rune(e.history[i])
+		}
+		e.pos = len(e.line)
+	}
+
+	e.refresh()
+	// tabs counts the Tabs typed in a row, to list the completions on the
+	// second one.
+	tabs := 0
+loop:
+	for {
+		k, err := e.readKey()
+		if err == nil && k == ctrl('r') {
+			k, err = e.search()
+		}
+		if err != nil {
+			if err == io.EOF && len(e.line) > 0 {
+				break loop
+			}
+			io.WriteString(e.out, "\n")
+			return "", err
+		}
+
+		if k == keyTab {
+			tabs++
+		} else {
+			tabs = 0
+		}
+
+		switch k {
+		case keyEnter, '\n':
+			break loop
+		case keyTab:
+			e.completeWord(tabs > 1)
+		case ctrl('c'):
+			io.WriteString(e.out, "^C\n")
+			return "", errInterrupted
+		case ctrl('d'):
+			if len(e.line) == 0 {
+				io.WriteString(e.out, "\n")
+				return "", io.EOF
+			}
+			e.delete(e.pos, e.pos+1)
+		case keyDelete:
+			e.delete(e.pos, e.pos+1)
+		case keyBackspace, ctrl('h'):
+			e.delete(e.pos-1, e.pos)
+		case ctrl('k'):
+			e.delete(e.pos, len(e.line))
+		case ctrl('u'):
+			e.delete(0, e.pos)
+		case ctrl('w'):
+			start := e.pos
+			for start > 0 && unicode.IsSpace(e.line[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(e.line[start-1]) {
+				start--
+			}
+			e.delete(start, e.pos)
+		case keyLeft, ctrl('b'):
+			if e.pos > 0 {
+				e.pos--
+			}
+		case keyRight, ctrl('f'):
+			if e.pos < len(e.line) {
+				e.pos++
+			}
+		case keyHome, ctrl('a'):
+			e.pos = 0
+		case keyEnd, ctrl('e'):
+			e.pos = len(e.line)
+		case keyUp, ctrl('p'):
+			recall(recalled - 1)
+		case keyDown, ctrl('n'):
+			recall(recalled + 1)
+		case ctrl('l'):
+			io.WriteString(e.out, "\x1b[H\x1b[2J")
+		default:
+			if k >= ' ' {
+				e.insert(rune(k))
+			}
+		}
+		e.refresh()
+	}
+
+	io.WriteString(e.out, "\n")
+	line := string(e.line)
+	e.addHistory(line)
+	return line, nil
+}
+
+// completeWord completes the word before the cursor with the longest prefix
+// its completions have in common. If that doesn't add to the word, and list
+// is set, the completions are listed below the line.
+func (e *editor) completeWord(list bool) {
+	if e.complete == nil {
+		return
+	}
+	candidates, word := e.complete(string(e.line[:e.pos]))
+	if len(candidates) == 0 {
+		return
+	}
+
+	common := []rune(candidates[0])
+	for _, c := range candidates[1:] {
+		r := []rune(c)
+		n := 0
+		for n < len(common) && n < len(r) && common[n] == r[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	if len(common) > word {
+		for _, r := range common[word:] {
+			e.insert(r)
+		}
+		return
+	}
+	if list && len(candidates) > 1 {
+		io.WriteString(e.out, "\n"+strings.Join(candidates, "  ")+"\n")
+	}
+}
+
+// insert inserts r at the cursor.
+func (e *editor) insert(r rune) {
+	e.line = append(e.line, 0)
+	copy(e.line[e.pos+1:], e.line[e.pos:])
+	e.line[e.pos] = r
+	e.pos++
+}
+
+// delete deletes the characters of the line from start to end, and moves the
+// cursor to start. Positions out of the line are clamped to it.
+func (e *editor) delete(start, end int) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(e.line) {
+		end = len(e.line)
+	}
+	if start >= end {
+		return
+	}
+	e.line = append(e.line[:start:start], e.line[end:]...)
+	e.pos = start
+}
+
+// refresh redraws the prompt and the line, and puts the cursor back.
+func (e *editor) refresh() {
+	var b strings.Builder
+	b.WriteString("\r" + e.prompt + string(e.line) + "\x1b[K")
+	if n := len(e.line) - e.pos; n > 0 {
+		fmt.Fprintf(&b, "\x1b[%dD", n)
+	}
+	io.WriteString(e.out, b.String())
+}
+
+// search searches the history backwards for the lines containing what's
+// typed, like Ctrl-R in shells: Ctrl-R again finds the previous match and
+// Ctrl-G gives up. Typing any other key puts the match in the line, and the
+// key is returned to be handled as usual, e.g. Enter to read the line.
+func (e *editor) search() (key, error) {
+	var query []rune
+	found := len(e.history)
+
+	// find returns the index of the last line before from that contains the
+	// query, or -1.
+	find := func(from int) int {
+		if from > len(e.history) {
+			from = len(e.history)
+		}
+		for i := from - 1; i >= 0; i-- {
+			if strings.Contains(e.history[i], string(query)) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	failing := false
+	for {
+		label, match := "reverse-i-search", ""
+		if failing {
+			label = "failing " + label
+		}
+		if found < len(e.history) {
+			match = e.history[found]
+		}
+		fmt.Fprintf(e.out, "\r(%s)`%s': %s\x1b[K", label, string(query), match)
+
+		k, err := e.readKey()
+		if err != nil {
+			return 0, err
+		}
+
+		next := -1
+		switch {
+		case k == ctrl('r'):
+			next = find(found)
+		case k == keyBackspace || k == ctrl('h'):
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+			}
+			next = find(len(e.history))
+		case k == ctrl('g'):
+			return keyUnknown, nil
+		case k >= ' ':
+			query = append(query, rune(k))
+			next = find(found + 1)
+		default:
+			if found < len(e.history) {
+				e.line = []rune(match)
+				e.pos = len(e.line)
+			}
+			return k, nil
+		}
+
+		failing = next < 0
+		if !failing {
+			found = next
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEditorReadLine(t *testing.T) {
+	const (
+		left  = "\x1b[D"
+		right = "\x1b[C"
+		up    = "\x1b[A"
+		down  = "\x1b[B"
+		home  = "\x1b[H"
+		del   = "\x1b[3~"
+	)
+	tests := []struct {
+		history []string
+		keys    string
+		line    string
+	}{
+		{nil, "let x = 1;\r", "let x = 1;"},
+		{nil, "ac" + left + "b\r", "abc"},
+		{nil, "bc\x01a\x05d\r", "abcd"},
+		{nil, "abc" + home + right + del + "\r", "ac"},
+		{nil, "abc\x7f\x7fx\r", "ax"},
+		{nil, "let x = 1\x0b\r", "let x = 1"},
+		{nil, "let x = 1\x01\x06\x06\x06\x06\x0b\r", "let "},
+		{nil, "let x = 1" + left + left + "\x15\r", " 1"},
+		{nil, "puts(x, foo bar\x17\x17baz\r", "puts(x, baz"},
+		{nil, "héllo" + left + left + left + left + "\x7f\r", "éllo"},
+		{[]string{"one", "two"}, up + "\r", "two"},
+		{[]string{"one", "two"}, up + up + up + "\r", "one"},
+		{[]string{"one", "two"}, "new" + up + up + down + down + "\r", "new"},
+		{[]string{"one", "two"}, up + "!\r", "two!"},
+		// Ctrl-R searches the history, backwards.
+		{[]string{"let a = 1", "puts(a)", "let b = 2"}, "\x12let\r", "let b = 2"},
+		{[]string{"let a = 1", "puts(a)", "let b = 2"}, "\x12let\x12\r", "let a = 1"},
+		{[]string{"let a = 1", "puts(a)", "let b = 2"}, "\x12put\x05;\r", "puts(a);"},
+		{[]string{"let a = 1", "puts(a)"}, "x\x12put\x07\r", "x"},
+		// The end of the input ends the line.
+		{nil, "abc", "abc"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		e := &editor{in: bufio.NewReader(strings.NewReader(tt.keys)), out: &out}
+		e.history = append(e.history, tt.history...)
+
+		line, err := e.readLine(">> ")
+		if err != nil {
+			t.Errorf("readLine failed for %q: %s", tt.keys, err)
+			continue
+		}
+		if line != tt.line {
+			t.Errorf("wrong line for %q. want=%q, got=%q", tt.keys, tt.line, line)
+		}
+	}
+}
+
+func TestEditorEnd(t *testing.T) {
+	tests := []struct {
+		keys string
+		err  error
+	}{
+		{"", io.EOF},
+		{"\x04", io.EOF},
+		{"abc\x03", errInterrupted},
+	}
+
+	for _, tt := range tests {
+		e := &editor{in: bufio.NewReader(strings.NewReader(tt.keys)), out: ioutil.Discard}
+		if _, err := e.readLine(">> "); err != tt.err {
+			t.Errorf("wrong error for %q. want=%v, got=%v", tt.keys, tt.err, err)
+		}
+	}
+}
+
+func TestEditorHistoryFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	if err := ioutil.WriteFile(file, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	in := bufio.NewReader(strings.NewReader("let x = 1\r\rlet x = 1\r\x1b[A\r"))
+	e := &editor{in: in, out: ioutil.Discard, historyFile: file}
+	e.loadHistory()
+	for i := 0; i < 4; i++ {
+		if _, err := e.readLine(">> "); err != nil {
+			t.Fatalf("readLine failed: %s", err)
+		}
+	}
+
+	// Empty and repeated lines aren't kept.
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "old\nlet x = 1\n" {
+		t.Errorf("wrong history file. got=%q", data)
+	}
+
+	// A new session recalls the lines of the earlier ones.
+	e = &editor{in: bufio.NewReader(strings.NewReader("\x1b[A\x1b[A\r")), out: ioutil.Discard, historyFile: file}
+	e.loadHistory()
+	if line, _ := e.readLine(">> "); line != "old" {
+		t.Errorf("wrong line recalled. want=%q, got=%q", "old", line)
+	}
+}
+
+func TestEditorHistoryFileTrimmed(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	var old strings.Builder
+	for i := 0; i < 2*maxHistory-1; i++ {
+		fmt.Fprintf(&old, "old %d\n", i)
+	}
+	if err := ioutil.WriteFile(file, []byte(old.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &editor{in: bufio.NewReader(strings.NewReader("new\r")), out: ioutil.Discard, historyFile: file}
+	e.loadHistory()
+	if _, err := e.readLine(">> "); err != nil {
+		t.Fatalf("readLine failed: %s", err)
+	}
+
+	// The file keeps the last maxHistory lines once it has twice as many.
+	lines, err := readHistory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != maxHistory || lines[0] != fmt.Sprintf("old %d", maxHistory) ||
+		lines[len(lines)-1] != "new" {
+		t.Errorf("wrong history file. got %d lines, %q ... %q", len(lines), lines[0], lines[len(lines)-1])
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("wrong mode of the history file. got=%v", info.Mode())
+	}
+}
+
+func TestEditorComplete(t *testing.T) {
+	complete := func(before string) ([]string, int) {
+		switch before {
+		case "le":
+			return []string{"len", "let"}, 2
+		case "puts(fil":
+			return []string{"filter"}, 3
+		}
+		return nil, 0
+	}
+
+	tests := []struct {
+		keys   string
+		line   string
+		listed bool
+	}{
+		{"puts(fil\t\r", "puts(filter", false},
+		{"puts(fil\x1b[D\x1b[C\t)\r", "puts(filter)", false},
+		{"le\t\r", "le", false},
+		{"le\t\tn\r", "len", true},
+		{"z\t\r", "z", false},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		e := &editor{in: bufio.NewReader(strings.NewReader(tt.keys)), out: &out, complete: complete}
+		line, err := e.readLine(">> ")
+		if err != nil {
+			t.Errorf("readLine failed for %q: %s", tt.keys, err)
+			continue
+		}
+		if line != tt.line {
+			t.Errorf("wrong line for %q. want=%q, got=%q", tt.keys, tt.line, line)
+		}
+		if listed := strings.Contains(out.String(), "\nlen  let\n"); listed != tt.listed {
+			t.Errorf("wrong listing for %q. want=%t, got=%t", tt.keys, tt.listed, listed)
+		}
+	}
+}
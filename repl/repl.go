@@ -5,18 +5,29 @@ package repl
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"os"
+	"os/signal"
+	"strings"
 
+	"github.com/cedrickchee/hou/diagnostic"
 	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/lang"
 	"github.com/cedrickchee/hou/lexer"
 	"github.com/cedrickchee/hou/object"
 	"github.com/cedrickchee/hou/parser"
+	"github.com/cedrickchee/hou/token"
 )
 
 // PROMPT is the REPL prompt displayed for each input.
 const PROMPT = ">> "
 
+// CONTINUATION_PROMPT is the prompt displayed for the lines that continue an
+// input, e.g. the body of a function literal spanning several lines.
+const CONTINUATION_PROMPT = ".. "
+
 // MONKEYFACE is the REPL's face if we run into any parser errors. You get to
 // see a monkey :D
 const MONKEYFACE = `            __,__
@@ -32,46 +43,352 @@ const MONKEYFACE = `            __,__
            '-----'
 `
 
-// Start starts the REPL in a continuous loop.
+// DefaultMaxFrames is the number of frames of backtraces Start prints.
+const DefaultMaxFrames = 10
+
+// Options configures the I/O streams of the REPL. Programs evaluated in the
+// REPL share them: `input()` reads from In and `puts` writes to Out.
+//
+// If In and Out are a terminal, the lines typed can be edited, and earlier
+// lines recalled, with the keys of readline, e.g. Up for the previous line and
+// Ctrl-R to search them.
+type Options struct {
+	In  io.Reader // where input lines are read from
+	Out io.Writer // where prompts, results and program output are written
+	Err io.Writer // where parser and runtime errors are written
+
+	// MaxFrames is the maximum number of frames printed for the backtrace of
+	// a runtime error. Zero means all of them.
+	MaxFrames int
+
+	// HistoryFile is the file the lines typed in a terminal are kept in, to
+	// recall them in later sessions. Empty means they're forgotten.
+	HistoryFile string
+
+	// Color makes the REPL color its output with ANSI escapes: results in
+	// green, parser and runtime errors in red, and prompts dimmed.
+	Color bool
+
+	// Version is the version of the language the inputs are read as. An
+	// input with a `#pragma version` line changes it for the inputs after
+	// it too, and so does the :lang command.
+	Version lang.Version
+}
+
+// DefaultOptions returns the options of a REPL reading from in and writing
+// everything, errors included, to out. The lines typed are kept in
+// ~/.hou_history, and the output is colored if out is a terminal, unless the
+// NO_COLOR environment variable is set, see https://no-color.org.
+func DefaultOptions(in io.Reader, out io.Writer) Options {
+	return Options{
+		In:          in,
+		Out:         out,
+		Err:         out,
+		MaxFrames:   DefaultMaxFrames,
+		HistoryFile: defaultHistoryFile(),
+		Color:       terminal(out) && os.Getenv("NO_COLOR") == "",
+		Version:     lang.Default,
+	}
+}
+
+// Start starts the REPL with the DefaultOptions in a continuous loop.
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+	Run(DefaultOptions(in, out))
+}
+
+// Run starts the REPL configured by opts in a continuous loop.
+func Run(opts Options) {
+	// The reader is shared with the evaluator, so that lines read by a
+	// program's `input()` calls aren't swallowed by the REPL's buffering.
+	in := bufio.NewReader(opts.In)
+	s := &session{
+		opts:    opts,
+		in:      in,
+		env:     object.NewSynchronizedEnvironment(),
+		eval:    evaluator.New(),
+		version: opts.Version,
+		lineNo:  1,
+	}
+	s.eval.Stdin = in
+	s.eval.Stdout = opts.Out
+	s.eval.Stderr = opts.Err
+	if terminal(opts.In) && terminal(opts.Out) {
+		s.editor = newEditor(in, opts.Out, opts.In.(*os.File).Fd(), opts.HistoryFile)
+		s.editor.complete = s.complete
+	}
 
 	for {
-		fmt.Printf(PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		line, err := s.readLine(PROMPT)
+		if err == errInterrupted {
+			continue
+		}
+		if err != nil {
 			return
 		}
 
-		line := scanner.Text()
-
-		// A REPL that tokenizes and parses Monkey source code and prints
-		// the AST.
-		l := lexer.New(line)
-		p := parser.New(l)
+		// Lines starting with a colon are commands to the REPL, see
+		// commandsHelp.
+		if cmd := strings.TrimSpace(line); strings.HasPrefix(cmd, ":") {
+			if !s.command(cmd) {
+				return
+			}
+			continue
+		}
 
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParseErrors(out, p.Errors())
+		// Keep reading lines while the input is incomplete. An empty line
+		// ends the input anyway, to get out of a typo like a missing `}`.
+		interrupted := false
+		for incomplete(line, s.version) {
+			more, err := s.readLine(CONTINUATION_PROMPT)
+			interrupted = err == errInterrupted
+			if err != nil {
+				break
+			}
+			line += more
+			if strings.TrimSpace(more) == "" {
+				break
+			}
+		}
+		if interrupted {
 			continue
 		}
 
-		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil {
-			// Print string representation of the object to stdout.
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
+		// Print the string representation of the result to the output
+		// stream.
+		if evaluated, ok := s.evaluate(line); ok && evaluated != nil {
+			io.WriteString(opts.Out, s.colored(colorResult, object.PrettyInspect(evaluated)))
+			io.WriteString(opts.Out, "\n")
+		}
+		if s.exited {
+			return
+		}
+	}
+}
+
+// terminal reports whether the stream is a terminal.
+func terminal(stream interface{}) bool {
+	f, ok := stream.(*os.File)
+	return ok && isTerminal(f.Fd())
+}
+
+// session is the state of a REPL that persists across inputs.
+type session struct {
+	opts Options
+	in   *bufio.Reader
+	// editor edits the lines typed, if the REPL runs in a terminal.
+	editor *editor
+	env    *object.Environment
+	eval   *evaluator.Evaluator
+	// version is the version of the language the next input is read as.
+	version lang.Version
+
+	// Lines are numbered across the session and kept, since functions
+	// defined on earlier lines may fail when they're called on later ones.
+	history strings.Builder
+	lineNo  int
+
+	// exited is set once a program called `exit`, which ends the session.
+	exited bool
+}
+
+// The ANSI escapes of the colors of the REPL, see Options.Color.
+const (
+	colorResult = "\x1b[32m"
+	colorError  = "\x1b[31m"
+	colorPrompt = "\x1b[2m"
+	colorReset  = "\x1b[0m"
+)
+
+// colored returns the text in the color if the REPL colors its output, and
+// as it is otherwise.
+func (s *session) colored(color, text string) string {
+	if !s.opts.Color {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// readLine prints the prompt and reads a line of input, with its newline.
+func (s *session) readLine(prompt string) (string, error) {
+	prompt = s.colored(colorPrompt, prompt)
+	if s.editor != nil {
+		line, err := s.editor.readLine(prompt)
+		return line + "\n", err
+	}
+
+	io.WriteString(s.opts.Out, prompt)
+	line, err := s.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+	return line, nil
+}
+
+// evaluate parses and evaluates the source code in the environment of the
+// session. Parser and runtime errors are printed, with backtraces, to the
+// error stream, and reported by returning false.
+func (s *session) evaluate(src string) (object.Object, bool) {
+	s.history.WriteString(src)
+
+	// A REPL that tokenizes and parses Monkey source code and prints
+	// the AST.
+	l := lexer.NewAt(src, s.lineNo)
+	p := parser.New(l)
+	p.SetVersion(s.version)
+	s.lineNo += strings.Count(src, "\n")
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		var out strings.Builder
+		printParseErrors(&out, p.Diagnostics())
+		io.WriteString(s.opts.Err, s.colored(colorError, out.String()))
+		return nil, false
+	}
+	// A version pragma applies to the rest of the session.
+	s.version = program.Features.Version()
+
+	// Ctrl-C cancels the evaluation, and the tasks it started, instead of
+	// ending the session. Tasks started by earlier inputs keep running.
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := cancelOnInterrupt(cancel)
+	evaluated := s.eval.EvalContext(ctx, program, s.env)
+	stop()
+	if err, ok := evaluated.(*object.Error); ok && err.Code == diagnostic.Exited {
+		s.exited = true
+		return nil, false
+	}
+	if err, ok := evaluated.(*object.Error); ok {
+		var out strings.Builder
+		PrintError(&out, s.history.String(), err, s.opts.MaxFrames)
+		io.WriteString(s.opts.Err, s.colored(colorError, out.String()))
+		return nil, false
+	}
+	return evaluated, true
+}
+
+// cancelOnInterrupt calls cancel when the process is interrupted, e.g. by
+// Ctrl-C, until the returned function is called.
+func cancelOnInterrupt(cancel context.CancelFunc) (stop func()) {
+	interrupts := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(interrupts)
+		close(done)
+	}
+}
+
+// incomplete reports whether more lines could complete the input, read as
+// the version of the language: it ends in an unclosed parenthesis, brace or
+// bracket, string or block comment, or the parser ran into its end, e.g.
+// after `let x =`.
+func incomplete(input string, version lang.Version) bool {
+	l := lexer.New(input)
+	depth := 0
+	for {
+		tok := l.NextToken()
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth--
+		case token.STRING:
+			// Strings run to the end of the input if they're missing their
+			// closing quote.
+			if tok.Offset+1+len(tok.Literal) == len(input) {
+				return true
+			}
+		case token.ILLEGAL:
+			if tok.Literal == "/*" {
+				// The block comment is missing its closing */.
+				return true
+			}
+		case token.EOF:
+			if depth > 0 {
+				return true
+			}
+			p := parser.New(lexer.New(input))
+			p.SetVersion(version)
+			p.ParseProgram()
+			for _, d := range p.Diagnostics() {
+				if d.Line == tok.Line && d.Column == tok.Column {
+					return true
+				}
+			}
+			return false
+		}
+	}
+}
+
+// PrintError prints the runtime error err of the program src, followed by
+// the line it happened at or, if it happened in a function, a backtrace of at
+// most maxFrames frames, innermost first, with the line of each frame. Zero
+// maxFrames means all frames.
+func PrintError(out io.Writer, src string, err *object.Error, maxFrames int) {
+	io.WriteString(out, err.Inspect()+"\n")
+	if len(err.Trace) <= 1 {
+		printErrorLine(out, src, err.Position)
+		return
+	}
+
+	frames := err.Trace
+	if maxFrames > 0 && len(frames) > maxFrames {
+		frames = frames[:maxFrames]
+	}
+	io.WriteString(out, "backtrace (innermost first):\n")
+	for _, frame := range frames {
+		fmt.Fprintf(out, "  in %s at %s\n", frame.Function, frame.Position)
+		printErrorLine(out, src, frame.Position)
+	}
+	if more := len(err.Trace) - len(frames); more > 0 {
+		fmt.Fprintf(out, "  ... %d more frames\n", more)
+	}
+}
+
+// printErrorLine prints the line of src at pos and a caret pointing at the
+// column below it, if the position is known.
+func printErrorLine(out io.Writer, src string, pos token.Position) {
+	lines := strings.Split(src, "\n")
+	if !pos.IsValid() || pos.Line > len(lines) {
+		return
+	}
+	text := strings.TrimRight(lines[pos.Line-1], "\r")
+
+	// Line the caret up with the source, tabs included. Columns count
+	// characters, not bytes.
+	caret := make([]byte, 0, pos.Column)
+	for _, ch := range text {
+		if len(caret) == pos.Column-1 {
+			break
+		}
+		if ch == '\t' {
+			caret = append(caret, '\t')
+		} else {
+			caret = append(caret, ' ')
 		}
 	}
+	caret = append(caret, '^')
+
+	io.WriteString(out, "\t"+text+"\n")
+	io.WriteString(out, "\t"+string(caret)+"\n")
 }
 
-// Print parser errors to stdout.
-func printParseErrors(out io.Writer, errors []string) {
+// Print parser errors to the given writer.
+func printParseErrors(out io.Writer, errors []diagnostic.Diagnostic) {
 	io.WriteString(out, MONKEYFACE)
 	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
 	io.WriteString(out, "parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+	for _, d := range errors {
+		io.WriteString(out, "\t"+d.Describe()+"\n")
 	}
 }
@@ -0,0 +1,46 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/object"
+)
+
+func TestSessionVersion(t *testing.T) {
+	var out strings.Builder
+	s := &session{
+		opts:    Options{Out: &out, Err: &out},
+		env:     object.NewSynchronizedEnvironment(),
+		eval:    evaluator.New(),
+		version: lang.Default,
+		lineNo:  1,
+	}
+
+	// A version pragma applies to the inputs after it.
+	if _, ok := s.evaluate("#pragma version 2\nlet x = 1;\n"); !ok {
+		t.Fatalf("pragma failed: %s", out.String())
+	}
+	if value, ok := s.evaluate("null ?? x\n"); !ok || value.Inspect() != "1" {
+		t.Errorf("null isn't a keyword after the pragma. got=%v: %s", value, out.String())
+	}
+	if !incomplete("while (x < 1) {\n", s.version) {
+		t.Errorf("an open while loop is complete")
+	}
+
+	// :lang sets the version.
+	s.command(":lang 1")
+	if s.version != lang.Default {
+		t.Errorf("wrong version after :lang 1. got=%s", s.version)
+	}
+	if value, ok := s.evaluate("let null = 2; null\n"); !ok || value.Inspect() != "2" {
+		t.Errorf("null is a keyword in version 1. got=%v: %s", value, out.String())
+	}
+	out.Reset()
+	s.command(":lang 0")
+	if s.version != lang.Default || !strings.Contains(out.String(), "unknown language version") {
+		t.Errorf("wrong result of :lang 0. got=%s, %q", s.version, out.String())
+	}
+}
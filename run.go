@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/compiler"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/lexer"
+	"github.com/cedrickchee/hou/object"
+	"github.com/cedrickchee/hou/optimizer"
+	"github.com/cedrickchee/hou/repl"
+	"github.com/cedrickchee/hou/token"
+	"github.com/cedrickchee/hou/vm"
+)
+
+// run implements `hou run`, which evaluates a script.
+func run(args []string) int {
+	fs, runScript := runCommand("run")
+	fs.Parse(args)
+	return runScript()
+}
+
+// runCommand returns the flag set of `hou run`, named name, and the function
+// that runs the script it names once the flags are parsed. `hou script.hou`
+// takes the same flags.
+//
+// The script is evaluated in a fresh environment, with the args after it
+// returned by the `args` builtin. hou exits with status 1 if it fails, or with
+// the status the script passed to `exit`. With --diagnostics=json, errors and
+// warnings are written to stderr as one JSON object per line, with a stable
+// code, the message and the position, for editors and CI. Otherwise, runtime
+// errors are printed with a backtrace of at most --frames frames. --no-warn
+// turns categories of warnings off, e.g. --no-warn=deprecated.
+//
+// --trace prints every node evaluated and its result to stderr, indented by
+// the depth of calls. --profile prints to stderr, once the script ran, how many
+// nodes of each type were evaluated, how many times each function was called
+// and how much was allocated. --checked asserts the type annotations of the
+// script at runtime, see package typecheck. --lang sets the version of the
+// language of scripts without a `#pragma version n` line, see package lang.
+//
+// --engine=vm compiles the script to bytecode and runs it on the virtual
+// machine, see packages compiler and vm; --no-opt runs the bytecode as
+// compiled, to debug the compiler. --optimize folds constant expressions and
+// drops dead code first, see package optimizer. --tokens and --ast print the
+// tokens of the script or its syntax tree, as text or JSON, to stdout instead
+// of running it.
+func runCommand(name string) (*flag.FlagSet, func() int) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	diagnostics := fs.String("diagnostics", "text", "format of the errors: text or json")
+	frames := fs.Int("frames", repl.DefaultMaxFrames, "maximum number of frames of backtraces, 0 for all")
+	noWarn := fs.String("no-warn", "", "comma-separated categories of warnings to turn off")
+	trace := fs.Bool("trace", false, "print every node evaluated and its result to stderr")
+	profile := fs.Bool("profile", false, "print the nodes evaluated, the calls and the allocations of the script to stderr")
+	checked := fs.Bool("checked", false, "assert the type annotations at runtime")
+	version := lang.Default
+	fs.Var(&version, "lang", "`version` of the language of scripts without a version pragma")
+	engine := fs.String("engine", "eval", "engine running the script: eval, the tree-walker, or vm, the bytecode virtual machine")
+	tokens := fs.Bool("tokens", false, "print the tokens of the script instead of running it")
+	var tree treeFormat
+	fs.Var(&tree, "ast", "print the syntax tree of the script instead of running it, as text or `json`")
+	optimize := fs.Bool("optimize", false, "fold constants and drop dead code before running the script, or printing its syntax tree")
+	noOpt := fs.Bool("no-opt", false, "don't optimize the bytecode of --engine=vm, for debugging")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: hou run [--diagnostics=text|json] [--frames=n] [--no-warn=categories] [--trace] [--profile] [--checked] [--lang=n] [--engine=eval|vm] [--no-opt] [--tokens] [--ast[=text|json]] [--optimize] script.hou|script.houc [arg ...]\n")
+		fs.PrintDefaults()
+	}
+
+	return fs, func() int {
+
+		if fs.NArg() < 1 {
+			fs.Usage()
+			return 2
+		}
+		format, err := diagnostic.ParseFormat(*diagnostics)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hou run: %s\n", err)
+			return 2
+		}
+
+		disabled, err := parseWarningCategories(*noWarn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hou run: %s\n", err)
+			return 2
+		}
+
+		// Scripts compiled by `hou build` run on the virtual machine.
+		compiled := filepath.Ext(fs.Arg(0)) == ".houc"
+		if compiled {
+			if *tokens || tree != "" || *optimize || *noOpt {
+				fmt.Fprintln(os.Stderr, "hou run: --tokens, --ast, --optimize and --no-opt need a script, not compiled code")
+				return 2
+			}
+			if *engine == "eval" && flagSet(fs, "engine") {
+				fmt.Fprintln(os.Stderr, "hou run: compiled code needs --engine=vm")
+				return 2
+			}
+			*engine = "vm"
+		}
+
+		if *tokens || tree != "" {
+			return dump(fs.Arg(0), format, version, *tokens, tree, *optimize)
+		}
+
+		switch *engine {
+		case "eval":
+			if *noOpt {
+				fmt.Fprintln(os.Stderr, "hou run: --no-opt needs --engine=vm")
+				return 2
+			}
+		case "vm":
+			if *trace || *profile || *checked {
+				fmt.Fprintln(os.Stderr, "hou run: --trace, --profile and --checked need --engine=eval")
+				return 2
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "hou run: unknown engine %q\n", *engine)
+			return 2
+		}
+		if *trace && *profile {
+			fmt.Fprintln(os.Stderr, "hou run: --trace and --profile can't be used together")
+			return 2
+		}
+
+		filename := fs.Arg(0)
+		var program *ast.Program
+		var bytecode *compiler.Bytecode
+		var src string
+		if compiled {
+			data, err := ioutil.ReadFile(filename)
+			if err == nil {
+				bytecode, err = compiler.Decode(data)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "hou run: %s\n", err)
+				return 1
+			}
+		} else {
+			var ok bool
+			program, src, ok = parseFile("run", filename, format, version)
+			if !ok {
+				return 1
+			}
+			if *optimize {
+				optimizer.Optimize(program)
+			}
+		}
+
+		e := evaluator.New()
+		e.Args = fs.Args()[1:]
+		e.Warnings = &evaluator.Warnings{
+			Disabled: disabled,
+			Handle: func(w evaluator.Warning) {
+				report(filename, format, []diagnostic.Diagnostic{w.Diagnostic()})
+			},
+		}
+		if *trace {
+			e.Hooks = e.TraceHooks(os.Stderr)
+		}
+		var p *evaluator.Profile
+		if *profile {
+			p = evaluator.NewProfile()
+			e.Hooks = p.Hooks()
+			p.Start()
+		}
+		e.Checked = *checked
+
+		var result object.Object
+		if *engine == "vm" {
+			if bytecode == nil {
+				c := compiler.New()
+				c.Optimize = !*noOpt
+				bytecode, err = c.Compile(program)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "hou run: %s\n", err)
+					return 1
+				}
+			}
+			result = vm.New(bytecode, e).Run()
+		} else {
+			result = e.Eval(program, evaluator.NewEnvironment(program))
+		}
+		if p != nil {
+			p.Stop()
+			p.Write(os.Stderr)
+		}
+		if err, ok := result.(*object.Error); ok && err.Code == diagnostic.Exited {
+			return err.Status
+		}
+		if err, ok := result.(*object.Error); ok {
+			if format == diagnostic.Text {
+				fmt.Fprintf(os.Stderr, "%s: ", filename)
+				repl.PrintError(os.Stderr, src, err, *frames)
+			} else {
+				report(filename, format, []diagnostic.Diagnostic{err.Diagnostic()})
+			}
+			return 1
+		}
+		// Let the timers the script started fire before exiting.
+		e.WaitTimers(context.Background())
+		return 0
+	}
+}
+
+// treeFormat is the format of the syntax trees printed by `hou run --ast`,
+// "text" or "json", or "" to run the script. It's a flag that can be given
+// without a value, for text.
+type treeFormat string
+
+func (f *treeFormat) String() string { return string(*f) }
+
+func (f *treeFormat) Set(s string) error {
+	switch s {
+	case "true", "text":
+		*f = "text"
+	case "false":
+		*f = ""
+	case "json":
+		*f = "json"
+	default:
+		return fmt.Errorf("unknown format %q, want text or json", s)
+	}
+	return nil
+}
+
+func (f *treeFormat) IsBoolFlag() bool { return true }
+
+// dump prints the tokens of the script if tokens is set, and its syntax tree
+// in the format tree unless it's "", instead of running it.
+func dump(
+	filename string,
+	format diagnostic.Format,
+	version lang.Version,
+	tokens bool,
+	tree treeFormat,
+	optimize bool,
+) int {
+	if tokens {
+		// The tokens are printed as they're read, without reading the
+		// whole script first.
+		f, err := os.Open(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hou run: %s\n", err)
+			return 1
+		}
+		l := lexer.NewFromReader(f)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			fmt.Printf("[%d:%d] %s %q\n", tok.Line, tok.Column, tok.Type, tok.Literal)
+		}
+		f.Close()
+		if err := l.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "hou run: %s\n", err)
+			return 1
+		}
+	}
+	if tree == "" {
+		return 0
+	}
+
+	program, _, ok := parseFile("run", filename, format, version)
+	if !ok {
+		return 1
+	}
+	if optimize {
+		optimizer.Optimize(program)
+	}
+	if tree == "text" {
+		ast.Fprint(os.Stdout, program)
+		return 0
+	}
+	data, err := ast.Encode(program)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hou run: %s\n", err)
+		return 1
+	}
+	var out bytes.Buffer
+	json.Indent(&out, data, "", "  ")
+	out.WriteByte('\n')
+	out.WriteTo(os.Stdout)
+	return 0
+}
+
+// parseWarningCategories parses a comma-separated list of categories of
+// warnings.
+func parseWarningCategories(list string) (map[evaluator.WarningCategory]bool, error) {
+	categories := map[evaluator.WarningCategory]bool{}
+	if list == "" {
+		return categories, nil
+	}
+	for _, name := range strings.Split(list, ",") {
+		category := evaluator.WarningCategory(strings.TrimSpace(name))
+		known := false
+		for _, c := range evaluator.WarningCategories {
+			known = known || c == category
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown category of warnings %q, want one of %v",
+				category, evaluator.WarningCategories)
+		}
+		categories[category] = true
+	}
+	return categories, nil
+}
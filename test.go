@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/tester"
+)
+
+// test implements `hou test`, which runs the tests of scripts. It runs the
+// _test.hou files among the paths, and in the directories among them, the
+// current directory by default, and reports the assertions that failed, see
+// package tester.
+func test(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	version := lang.Default
+	fs.Var(&version, "lang", "`version` of the language of scripts without a version pragma")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: hou test [--lang=n] [path ...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := tester.Find(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hou test: %s\n", err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "hou test: no test files")
+		return 1
+	}
+
+	passed, failed := 0, 0
+	for _, filename := range files {
+		program, _, ok := parseFile("test", filename, diagnostic.Text, version)
+		if !ok {
+			fmt.Printf("FAIL\t%s\n", filename)
+			failed++
+			continue
+		}
+
+		filePassed, fileFailed := 0, 0
+		for _, r := range tester.Run(context.Background(), evaluator.New(), program) {
+			if r.Passed() {
+				filePassed++
+				continue
+			}
+			fileFailed++
+			name := r.Name
+			if name == "" {
+				name = "(top level)"
+			}
+			fmt.Printf("--- FAIL: %s\n", name)
+			for _, err := range r.Failures {
+				fmt.Printf("    %s:%d:%d: %s\n", filename,
+					err.Position.Line, err.Position.Column, err.Message)
+			}
+		}
+		if fileFailed > 0 {
+			fmt.Printf("FAIL\t%s\t%d passed, %d failed\n", filename, filePassed, fileFailed)
+		} else {
+			fmt.Printf("ok\t%s\t%d passed\n", filename, filePassed)
+		}
+		passed += filePassed
+		failed += fileFailed
+	}
+
+	if failed > 0 {
+		fmt.Printf("FAIL: %d passed, %d failed\n", passed, failed)
+		return 1
+	}
+	fmt.Printf("PASS: %d passed\n", passed)
+	return 0
+}
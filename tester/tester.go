@@ -0,0 +1,134 @@
+package tester
+
+// Package tester runs the tests of Hou scripts, for `hou test`. Tests live in
+// files whose names end in _test.hou, as functions bound by a let statement
+// at the top level, whose names start with test followed by an underscore or
+// an uppercase letter, e.g.
+//
+//	let test_add = fn() {
+//	  assertEq(1 + 1, 2);
+//	};
+//
+// The assertions of a test, see the `assert` and `assertEq` builtins, record
+// their failures and the test goes on, so that it reports all of them. A test
+// fails if an assertion failed or if it stopped with an error.
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/object"
+)
+
+// IsTestFile reports whether the file at path holds tests.
+func IsTestFile(path string) bool {
+	return strings.HasSuffix(filepath.Base(path), "_test.hou")
+}
+
+// IsTest reports whether name is the name of a test function, e.g. test_add
+// or testAdd, but not tested.
+func IsTest(name string) bool {
+	if !strings.HasPrefix(name, "test") {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(name[len("test"):])
+	return r == '_' || unicode.IsUpper(r)
+}
+
+// Find returns the test files of the paths, sorted: the paths of files as they
+// are, and the test files in the directories and their subdirectories.
+func Find(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && IsTestFile(file) {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Result is the outcome of a test.
+type Result struct {
+	// Name is the name of the test function, or "" for the top level of
+	// the file, which is run before the tests.
+	Name string
+	// Failures are the assertions that failed, in order, followed by the
+	// error that stopped the test, if any.
+	Failures []*object.Error
+}
+
+// Passed reports whether the test passed.
+func (r Result) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// Run evaluates the program of a test file with e in a fresh environment, then
+// calls its tests in the order they're defined, and returns their results.
+// The top level of the file only has a result if it failed, in which case the
+// tests aren't run.
+func Run(ctx context.Context, e *evaluator.Evaluator, program *ast.Program) []Result {
+	assertions := &evaluator.Assertions{}
+	e.Assertions = assertions
+	env := evaluator.NewEnvironment(program)
+
+	top := run(assertions, func() object.Object {
+		return e.EvalContext(ctx, program, env)
+	})
+	if !top.Passed() {
+		return []Result{top}
+	}
+
+	var results []Result
+	for _, s := range program.Statements {
+		let, ok := s.(*ast.LetStatement)
+		if !ok || !IsTest(let.Name.Value) {
+			continue
+		}
+		fn, ok := env.Get(let.Name.Value)
+		if _, isFunction := fn.(*object.Function); !ok || !isFunction {
+			continue
+		}
+		result := run(assertions, func() object.Object {
+			return e.CallContext(ctx, fn, nil)
+		})
+		result.Name = let.Name.Value
+		results = append(results, result)
+	}
+	return results
+}
+
+// run calls f, which runs a test, and returns its result.
+func run(assertions *evaluator.Assertions, f func() object.Object) Result {
+	assertions.Reset()
+	result := f()
+	failures := assertions.Failures()
+	if err, ok := result.(*object.Error); ok {
+		failures = append(failures, err)
+	}
+	return Result{Failures: failures}
+}
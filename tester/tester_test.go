@@ -0,0 +1,120 @@
+package tester
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/lexer"
+	"github.com/cedrickchee/hou/parser"
+)
+
+func TestIsTest(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"test_add": true,
+		"testAdd":  true,
+		"test":     false,
+		"tested":   false,
+		"add":      false,
+		"my_test":  false,
+	} {
+		if got := IsTest(name); got != expected {
+			t.Errorf("IsTest(%q) = %t, want %t", name, got, expected)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b_test.hou", "a.hou", "sub/c_test.hou", "sub/d_test.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := Find([]string{filepath.Join(dir, "a.hou"), dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join(dir, "a.hou"),
+		filepath.Join(dir, "b_test.hou"),
+		filepath.Join(dir, "sub", "c_test.hou"),
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("wrong files. want=%q, got=%q", expected, files)
+	}
+
+	if _, err := Find([]string{filepath.Join(dir, "missing")}); err == nil {
+		t.Errorf("expected an error for a missing path")
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			`let add = fn(a, b) { a + b };
+let test_add = fn() { assertEq(add(1, 2), 3) };
+let testBroken = fn() {
+  assertEq(add(1, 1), 3);
+  assert(false, "still runs");
+  add(1, true);
+  assert(false, "stopped");
+};
+let helper = fn() { assert(false) };
+let test_value = 1;`,
+			[]string{
+				"test_add: ok",
+				"testBroken: 4:3: assertion failed: got 2, want 3; " +
+					"5:3: assertion failed: still runs; " +
+					"1:24: type mismatch: INTEGER + BOOLEAN",
+			},
+		},
+		{
+			`assert(false, "top"); let test_never = fn() {};`,
+			[]string{": 1:1: assertion failed: top"},
+		},
+		{
+			`let test_never = fn() {}; 1 / 0`,
+			[]string{": 1:29: division by zero: 1 / 0"},
+		},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("parser errors for %q: %v", tt.input, p.Errors())
+		}
+
+		var got []string
+		for _, r := range Run(context.Background(), evaluator.New(), program) {
+			if r.Passed() {
+				got = append(got, r.Name+": ok")
+				continue
+			}
+			var failures []string
+			for _, err := range r.Failures {
+				failures = append(failures, fmt.Sprintf("%d:%d: %s",
+					err.Position.Line, err.Position.Column, err.Message))
+			}
+			got = append(got, r.Name+": "+strings.Join(failures, "; "))
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("wrong results for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package token
 
+import (
+	"fmt"
+	"sort"
+)
+
 // Package token defines the tokens our lexer is going to output.
 
 // There is a limited number of different token types in the Monkey language.
@@ -10,36 +15,56 @@ const (
 	//
 	ILLEGAL = "ILLEGAL" // a token/character we don't know about
 	EOF     = "EOF"     // stands for "end of file", which tells parser that it can stop
+	COMMENT = "COMMENT" // a comment, which the lexer only returns to tools
+	// WHITESPACE is spaces, tabs and newlines between tokens, which only
+	// lexer.Tokenize returns.
+	WHITESPACE = "WHITESPACE"
 
 	//
 	// Identifiers + literals
 	//
 	IDENT  = "IDENT"  // add, foobar, x, y, ...
 	INT    = "INT"    // an integer, e.g: 1343456
+	FLOAT  = "FLOAT"  // a floating point number, e.g: 3.14 or 1e9
 	STRING = "STRING" // a string, e.g: "foobar"
+	PRAGMA = "PRAGMA" // a directive to the parser, e.g: #pragma version 2
 
 	//
 	// Operators
 	//
-	ASSIGN   = "=" // the assignment operator
-	PLUS     = "+" // the addition operator
-	MINUS    = "-" // the substraction operator
-	BANG     = "!" // the factorial operator
-	ASTERISK = "*" // the multiplication operator
-	SLASH    = "/" // the division operator
+	ASSIGN   = "="  // the assignment operator
+	PLUS     = "+"  // the addition operator
+	MINUS    = "-"  // the substraction operator
+	BANG     = "!"  // the factorial operator
+	ASTERISK = "*"  // the multiplication operator
+	SLASH    = "/"  // the division operator
+	PERCENT  = "%"  // the remainder operator
+	POW      = "**" // the exponentiation operator
 
-	LT = "<" // the less than comparision operator
-	GT = ">" // the greater than comparision operator
+	LT    = "<"  // the less than comparision operator
+	GT    = ">"  // the greater than comparision operator
+	LT_EQ = "<=" // the less than or equal comparison operator
+	GT_EQ = ">=" // the greater than or equal comparison operator
 
 	EQ     = "==" // the equality operator
 	NOT_EQ = "!=" // the inequality operator
 
+	ARROW = "->" // the arrow before the result type of a function
+
+	COALESCE = "??" // the nil-coalescing operator
+
+	PLUS_ASSIGN     = "+=" // the addition assignment operator
+	MINUS_ASSIGN    = "-=" // the substraction assignment operator
+	ASTERISK_ASSIGN = "*=" // the multiplication assignment operator
+	SLASH_ASSIGN    = "/=" // the division assignment operator
+
 	//
 	// Delimiters
 	//
 	COMMA     = "," // a comma
 	SEMICOLON = ";" // a semi-colon
 	COLON     = ":" // a colon
+	DOT       = "." // a dot, for member access
 
 	LPAREN   = "(" // a left paranthesis
 	RPAREN   = ")" // a right parenthesis
@@ -58,17 +83,48 @@ const (
 	IF       = "IF"       // the `if` keyword (if)
 	ELSE     = "ELSE"     // the `else` keyword (else)
 	RETURN   = "RETURN"   // the `return` keyword (return)
+	YIELD    = "YIELD"    // the `yield` keyword (yield), since version 3
+	WHILE    = "WHILE"    // the `while` keyword (while), since version 2
+	FOR      = "FOR"      // the `for` keyword (for), since version 2
+	IN       = "IN"       // the `in` keyword (in), since version 2
+	NULL     = "NULL"     // the `null` keyword (null), since version 2
+	MATCH    = "MATCH"    // the `match` keyword (match), since version 2
+	CASE     = "CASE"     // the `case` keyword (case), since version 2
+	DEFAULT  = "DEFAULT"  // the `default` keyword (default), since version 2
+	// SPAWN is the `spawn` keyword (spawn), which it only is before `fn`, as
+	// in `spawn fn() { ... }`. Elsewhere, spawn is a name, e.g. of the
+	// builtin, so it's not in the keywords table.
+	SPAWN = "SPAWN"
 )
 
 // Language keywords table
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":      FUNCTION,
+	"let":     LET,
+	"true":    TRUE,
+	"false":   FALSE,
+	"if":      IF,
+	"else":    ELSE,
+	"return":  RETURN,
+	"yield":   YIELD,
+	"while":   WHILE,
+	"for":     FOR,
+	"in":      IN,
+	"null":    NULL,
+	"match":   MATCH,
+	"case":    CASE,
+	"default": DEFAULT,
+}
+
+// Keywords returns the sorted keywords of the language, including the ones
+// that came with later versions, see lang.Keyword.
+func Keywords() []string {
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // TokenType distinguishes between different types of tokens.
@@ -78,6 +134,29 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	// Position is where the token starts in the source code. The position of
+	// tokens that weren't read from the source is the zero Position.
+	Position
+	// Offset is the byte offset of the token in the source. Tokens are
+	// embedded in every AST node, so there's no end offset to keep them
+	// small: the literal, and the quotes of strings, tell where tokens end.
+	Offset int
+}
+
+// Position is a location in the source code. Lines and columns start at 1.
+// Columns count characters, not bytes, so they line up with what editors show
+// for lines with characters outside of ASCII.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// IsValid reports whether the position is known.
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String returns the position in the form `line 12, col 8`.
+func (p Position) String() string {
+	return fmt.Sprintf("line %d, col %d", p.Line, p.Column)
 }
 
 // LookupIdent looks up the identifier in ident and returns the appropriate
@@ -0,0 +1,69 @@
+package transpiler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// goMod is the go.mod of the temporary module a native program is built in.
+// The generated code only depends on Hou itself, which is replaced with a local
+// checkout so that no network access is needed.
+const goMod = `module houprogram
+
+go 1.13
+
+require github.com/cedrickchee/hou v0.0.0
+
+replace github.com/cedrickchee/hou => %s
+`
+
+// Build compiles the Go source of a transpiled program into the executable
+// output using the go tool. houRoot is the directory of a checkout of the Hou
+// source the program is linked against.
+func Build(src []byte, output, houRoot string) error {
+	if houRoot == "" {
+		return fmt.Errorf("transpiler: the Hou source directory is unknown, " +
+			"set HOUROOT to a checkout of github.com/cedrickchee/hou")
+	}
+
+	root, err := filepath.Abs(houRoot)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(root, "native")); err != nil {
+		return fmt.Errorf("transpiler: %s is not a Hou source directory", root)
+	}
+
+	output, err = filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "hou-build")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "go.mod"),
+		[]byte(fmt.Sprintf(goMod, root)), 0644)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "main.go"), src, 0644)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("go", "build", "-o", output, ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("transpiler: go build failed: %v\n%s", err, out)
+	}
+
+	return nil
+}
@@ -0,0 +1,603 @@
+package transpiler
+
+// Package transpiler implements an ahead-of-time translation of a parsed Hou
+// program into a Go program. The generated code is linked against the object
+// and native packages and can be compiled into a standalone binary with the
+// regular Go toolchain, which removes the overhead of walking the AST at run
+// time and gives a single file to distribute.
+//
+// The translation is straightforward: every Hou value is an object.Object,
+// every Hou function literal becomes a Go closure and every expression is
+// evaluated into a temporary variable, so evaluation order is exactly the same
+// as in the tree-walker.
+//
+// Hou variables become Go variables. The tasks of programs that use them,
+// e.g. started by spawn, share the variables, so those programs read and write
+// them through native.Load and native.Store, which a lock guards like the
+// environments of the evaluator.
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/lang"
+)
+
+// Transpile translates the program into the source code of a Go main package.
+func Transpile(program *ast.Program) ([]byte, error) {
+	// The generated code has the semantics of the default version.
+	if v := program.Features.Version(); v != lang.Default {
+		return nil, fmt.Errorf("transpiler: unsupported language version %d", v)
+	}
+
+	g := &generator{
+		constants: make(map[string]string),
+		guard:     evaluator.UsesTasks(program),
+	}
+
+	body, err := g.function(nil, nil, program.Statements)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString("// Code generated by hou build --native. DO NOT EDIT.\n\n")
+	out.WriteString("package main\n\n")
+	out.WriteString("import (\n")
+	out.WriteString("\t\"github.com/cedrickchee/hou/native\"\n")
+	out.WriteString("\t\"github.com/cedrickchee/hou/object\"\n")
+	out.WriteString(")\n\n")
+
+	// Integer and string literals are immutable, so each distinct literal is
+	// allocated once, when the program starts.
+	if len(g.constantDecls) > 0 {
+		out.WriteString("var (\n")
+		for _, decl := range g.constantDecls {
+			out.WriteString("\t" + decl + "\n")
+		}
+		out.WriteString(")\n\n")
+	}
+
+	out.WriteString("func main() {\n")
+	out.WriteString("\tnative.Main(program)\n")
+	out.WriteString("}\n\n")
+	out.WriteString("func program() object.Object ")
+	out.WriteString(body)
+	out.WriteString("\n")
+
+	return format.Source(out.Bytes())
+}
+
+// scope tracks the Go variables of a single Hou environment, i.e. the program
+// or the body of a function.
+type scope struct {
+	names map[string]string // Hou identifier to Go variable
+	outer *scope
+}
+
+func (s *scope) lookup(name string) (string, bool) {
+	for ; s != nil; s = s.outer {
+		if v, ok := s.names[name]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+type generator struct {
+	out   *bytes.Buffer
+	scope *scope
+	temps int
+	// guard is whether variables are read and written through native.Load
+	// and native.Store, since tasks share them.
+	guard bool
+
+	constants     map[string]string // literal to Go variable
+	constantDecls []string
+}
+
+func (g *generator) emit(format string, a ...interface{}) {
+	fmt.Fprintf(g.out, format, a...)
+	g.out.WriteString("\n")
+}
+
+// load returns the Go expression reading the variable v.
+func (g *generator) load(v string) string {
+	if g.guard {
+		return "native.Load(&" + v + ")"
+	}
+	return v
+}
+
+// store emits the code setting the variable v to value.
+func (g *generator) store(v, value string) {
+	if g.guard {
+		g.emit("native.Store(&%s, %s)", v, value)
+	} else {
+		g.emit("%s = %s", v, value)
+	}
+}
+
+func (g *generator) temp() string {
+	g.temps++
+	return fmt.Sprintf("t%d", g.temps)
+}
+
+func (g *generator) constant(key, decl string) string {
+	if name, ok := g.constants[key]; ok {
+		return name
+	}
+	name := fmt.Sprintf("c%d", len(g.constantDecls))
+	g.constants[key] = name
+	g.constantDecls = append(g.constantDecls, name+" = "+decl)
+	return name
+}
+
+// function generates the body of a Go function, including the surrounding
+// braces, that binds params from its arguments and runs the statements.
+func (g *generator) function(
+	params []*ast.Identifier,
+	defaults []ast.Expression,
+	statements []ast.Statement,
+) (string, error) {
+	outerOut, outerScope := g.out, g.scope
+	defer func() { g.out, g.scope = outerOut, outerScope }()
+
+	g.out = &bytes.Buffer{}
+	g.scope = &scope{names: make(map[string]string), outer: outerScope}
+
+	g.emit("{")
+
+	// In Hou, `let` binds a name in the environment of the enclosing function
+	// no matter how deeply it's nested in if/else blocks, so all names are
+	// declared up front. That also makes recursive functions work, since the
+	// variable exists before the function literal refers to it.
+	for i, param := range params {
+		v := g.declare(param.Value)
+		if i < len(defaults) && defaults[i] != nil {
+			g.emit("var %s object.Object", v)
+		} else {
+			g.emit("var %s object.Object = args[%d]", v, i)
+		}
+		g.emit("_ = %s", v)
+	}
+	for _, name := range letNames(statements) {
+		if _, ok := g.scope.names[name]; ok {
+			continue
+		}
+		v := g.declare(name)
+		g.emit("var %s object.Object", v)
+		g.emit("_ = %s", v)
+	}
+
+	// The default values of the parameters are evaluated for the arguments
+	// the call omits, in order, with the names of the function in scope.
+	for i, value := range defaults {
+		if value == nil {
+			continue
+		}
+		v := g.scope.names[params[i].Value]
+		g.emit("if len(args) > %d {", i)
+		g.store(v, fmt.Sprintf("args[%d]", i))
+		g.emit("} else {")
+		t, err := g.expression(value)
+		if err != nil {
+			return "", err
+		}
+		g.store(v, t)
+		g.emit("}")
+	}
+
+	// The result of a function is the result of its last statement, just as
+	// in evalBlockStatement.
+	g.emit("var result object.Object")
+	if err := g.statements(statements, "result"); err != nil {
+		return "", err
+	}
+	g.emit("return result")
+	g.out.WriteString("}")
+
+	return g.out.String(), nil
+}
+
+func (g *generator) declare(name string) string {
+	v := fmt.Sprintf("v%d_%s", len(g.scope.names), name)
+	g.scope.names[name] = v
+	return v
+}
+
+// letNames returns the names bound by `let` statements, including the ones in
+// nested blocks but excluding the ones in nested function literals.
+func letNames(statements []ast.Statement) []string {
+	var names []string
+
+	var walkExpression func(ast.Expression)
+	var walk func([]ast.Statement)
+	walk = func(statements []ast.Statement) {
+		for _, s := range statements {
+			switch s := s.(type) {
+			case *ast.LetStatement:
+				names = append(names, s.Name.Value)
+				walkExpression(s.Value)
+			case *ast.ReturnStatement:
+				walkExpression(s.ReturnValue)
+			case *ast.ExpressionStatement:
+				walkExpression(s.Expression)
+			case *ast.BlockStatement:
+				walk(s.Statements)
+			}
+		}
+	}
+	walkExpression = func(e ast.Expression) {
+		switch e := e.(type) {
+		case *ast.IfExpression:
+			walkExpression(e.Condition)
+			walk(e.Consequence.Statements)
+			if e.Alternative != nil {
+				walk(e.Alternative.Statements)
+			}
+		case *ast.PrefixExpression:
+			walkExpression(e.Right)
+		case *ast.AssignExpression:
+			if e.Index != nil {
+				walkExpression(e.Index)
+			}
+			walkExpression(e.Value)
+		case *ast.InfixExpression:
+			walkExpression(e.Left)
+			walkExpression(e.Right)
+		case *ast.CallExpression:
+			walkExpression(e.Function)
+			for _, a := range e.Arguments {
+				walkExpression(a)
+			}
+		case *ast.IndexExpression:
+			walkExpression(e.Left)
+			walkExpression(e.Index)
+		case *ast.MemberExpression:
+			walkExpression(e.Object)
+		case *ast.ArrayLiteral:
+			for _, el := range e.Elements {
+				walkExpression(el)
+			}
+		case *ast.HashLiteral:
+			for _, k := range e.Keys {
+				walkExpression(k)
+				walkExpression(e.Pairs[k])
+			}
+		}
+	}
+
+	walk(statements)
+	return names
+}
+
+// statements generates the code for a list of statements and assigns the
+// result of the last one to the Go variable result.
+func (g *generator) statements(statements []ast.Statement, result string) error {
+	for _, s := range statements {
+		switch s := s.(type) {
+		case *ast.LetStatement:
+			value, err := g.expression(s.Value)
+			if err != nil {
+				return err
+			}
+			v, _ := g.scope.lookup(s.Name.Value)
+			g.store(v, value)
+			// A let statement doesn't produce a value.
+			g.emit("%s = nil", result)
+
+		case *ast.ReturnStatement:
+			value, err := g.expression(s.ReturnValue)
+			if err != nil {
+				return err
+			}
+			g.emit("return %s", value)
+
+		case *ast.ExpressionStatement:
+			value, err := g.expression(s.Expression)
+			if err != nil {
+				return err
+			}
+			g.emit("%s = %s", result, value)
+
+		case *ast.BlockStatement:
+			if err := g.statements(s.Statements, result); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("transpiler: unsupported statement %T", s)
+		}
+	}
+
+	return nil
+}
+
+// expression generates the code that evaluates the expression and returns the
+// Go expression holding its value.
+func (g *generator) expression(e ast.Expression) (string, error) {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return g.constant(fmt.Sprintf("int:%d", e.Value),
+			fmt.Sprintf("&object.Integer{Value: %d}", e.Value)), nil
+
+	case *ast.FloatLiteral:
+		value := strconv.FormatFloat(e.Value, 'g', -1, 64)
+		return g.constant("float:"+value,
+			fmt.Sprintf("&object.Float{Value: %s}", value)), nil
+
+	case *ast.StringLiteral:
+		return g.constant("string:"+e.Value,
+			fmt.Sprintf("&object.String{Value: %s}", strconv.Quote(e.Value))), nil
+
+	case *ast.Boolean:
+		if e.Value {
+			return "native.True", nil
+		}
+		return "native.False", nil
+
+	case *ast.Identifier:
+		// The value is read into a temporary as well, since a `let` in a later
+		// operand could rebind the name before the value is used.
+		t := g.temp()
+		if v, ok := g.scope.lookup(e.Value); ok {
+			g.emit("%s := native.Get(%s, %q)", t, g.load(v), e.Value)
+		} else {
+			// Anything that isn't bound lexically can only be a builtin.
+			g.emit("%s := native.Builtin(%q)", t, e.Value)
+		}
+		return t, nil
+
+	case *ast.AssignExpression:
+		if e.Index != nil {
+			return g.indexAssignment(e)
+		}
+		// Names that aren't bound lexically are builtins or unbound, which
+		// can't be assigned to.
+		v, ok := g.scope.lookup(e.Name.Value)
+		variable := "nil"
+		if ok {
+			variable = g.load(v)
+		}
+		var current string
+		if e.Operator != "=" {
+			current = g.temp()
+			g.emit("%s := native.Assigned(%s, %q)", current, variable, e.Name.Value)
+		}
+		value, err := g.expression(e.Value)
+		if err != nil {
+			return "", err
+		}
+		if e.Operator == "=" {
+			g.emit("native.Assigned(%s, %q)", variable, e.Name.Value)
+		} else {
+			t := g.temp()
+			g.emit("%s := native.Infix(%q, %s, %s)",
+				t, strings.TrimSuffix(e.Operator, "="), current, value)
+			value = t
+		}
+		if ok {
+			g.store(v, value)
+		}
+		return value, nil
+
+	case *ast.PrefixExpression:
+		right, err := g.expression(e.Right)
+		if err != nil {
+			return "", err
+		}
+		t := g.temp()
+		g.emit("%s := native.Prefix(%q, %s)", t, e.Operator, right)
+		return t, nil
+
+	case *ast.InfixExpression:
+		if e.Operator == "??" {
+			return g.coalesce(e)
+		}
+		left, err := g.expression(e.Left)
+		if err != nil {
+			return "", err
+		}
+		right, err := g.expression(e.Right)
+		if err != nil {
+			return "", err
+		}
+		t := g.temp()
+		g.emit("%s := native.Infix(%q, %s, %s)", t, e.Operator, left, right)
+		return t, nil
+
+	case *ast.IfExpression:
+		cond, err := g.expression(e.Condition)
+		if err != nil {
+			return "", err
+		}
+		t := g.temp()
+		g.emit("var %s object.Object = native.Null", t)
+		g.emit("if native.Truthy(%s) {", cond)
+		if err := g.statements(e.Consequence.Statements, t); err != nil {
+			return "", err
+		}
+		if e.Alternative != nil {
+			g.emit("} else {")
+			if err := g.statements(e.Alternative.Statements, t); err != nil {
+				return "", err
+			}
+		}
+		g.emit("}")
+		return t, nil
+
+	case *ast.FunctionLiteral:
+		if e.Generator {
+			return "", fmt.Errorf("transpiler: unsupported generator function")
+		}
+		if e.Name == nil {
+			body, err := g.function(e.Parameters, e.Defaults, e.Body.Statements)
+			if err != nil {
+				return "", err
+			}
+			t := g.temp()
+			g.emit("%s := native.Function(%d, %d, func(args []object.Object) object.Object %s)",
+				t, e.Required(), len(e.Parameters), body)
+			return t, nil
+		}
+
+		// The name of a named function is a variable of a scope of its own,
+		// which the body refers to.
+		t := g.temp()
+		g.emit("var %s object.Object", t)
+		outer := g.scope
+		g.scope = &scope{names: map[string]string{e.Name.Value: t}, outer: outer}
+		body, err := g.function(e.Parameters, e.Defaults, e.Body.Statements)
+		g.scope = outer
+		if err != nil {
+			return "", err
+		}
+		g.emit("%s = native.Function(%d, %d, func(args []object.Object) object.Object %s)",
+			t, e.Required(), len(e.Parameters), body)
+		return t, nil
+
+	case *ast.CallExpression:
+		fn, err := g.expression(e.Function)
+		if err != nil {
+			return "", err
+		}
+		args, err := g.expressions(e.Arguments)
+		if err != nil {
+			return "", err
+		}
+		t := g.temp()
+		g.emit("%s := native.Call(%s)", t, join(fn, args))
+		return t, nil
+
+	case *ast.SpawnExpression:
+		fn, err := g.expression(e.Function)
+		if err != nil {
+			return "", err
+		}
+		t := g.temp()
+		g.emit("%s := native.Call(native.Builtin(\"spawn\"), %s)", t, fn)
+		return t, nil
+
+	case *ast.ArrayLiteral:
+		elements, err := g.expressions(e.Elements)
+		if err != nil {
+			return "", err
+		}
+		t := g.temp()
+		g.emit("%s := native.Array(%s)", t, join("", elements))
+		return t, nil
+
+	case *ast.IndexExpression:
+		left, err := g.expression(e.Left)
+		if err != nil {
+			return "", err
+		}
+		index, err := g.expression(e.Index)
+		if err != nil {
+			return "", err
+		}
+		t := g.temp()
+		g.emit("%s := native.Index(%s, %s)", t, left, index)
+		return t, nil
+
+	case *ast.MemberExpression:
+		obj, err := g.expression(e.Object)
+		if err != nil {
+			return "", err
+		}
+		t := g.temp()
+		g.emit("%s := native.Member(%s, %q)", t, obj, e.Property.Value)
+		return t, nil
+
+	case *ast.HashLiteral:
+		var keysAndValues []string
+		for _, k := range e.Keys {
+			v := e.Pairs[k]
+			key, err := g.expression(k)
+			if err != nil {
+				return "", err
+			}
+			value, err := g.expression(v)
+			if err != nil {
+				return "", err
+			}
+			keysAndValues = append(keysAndValues, key, value)
+		}
+		t := g.temp()
+		g.emit("%s := native.Hash(%s)", t, join("", keysAndValues))
+		return t, nil
+	}
+
+	return "", fmt.Errorf("transpiler: unsupported expression %T", e)
+}
+
+// indexAssignment generates the code for an assignment to an element of an
+// array or hash, which evaluates the value after the array or hash and the
+// index, like the evaluator does.
+func (g *generator) indexAssignment(e *ast.AssignExpression) (string, error) {
+	operands, err := g.expressions([]ast.Expression{e.Index.Left, e.Index.Index, e.Value})
+	if err != nil {
+		return "", err
+	}
+	left, index, value := operands[0], operands[1], operands[2]
+	if e.Operator != "=" {
+		current := g.temp()
+		g.emit("%s := native.Index(%s, %s)", current, left, index)
+		t := g.temp()
+		g.emit("%s := native.Infix(%q, %s, %s)",
+			t, strings.TrimSuffix(e.Operator, "="), current, value)
+		value = t
+	}
+	t := g.temp()
+	g.emit("%s := native.SetIndex(%s, %s, %s)", t, left, index, value)
+	return t, nil
+}
+
+// coalesce generates the code for `left ?? right`, which only evaluates right
+// if left is null.
+func (g *generator) coalesce(e *ast.InfixExpression) (string, error) {
+	left, err := g.expression(e.Left)
+	if err != nil {
+		return "", err
+	}
+	t := g.temp()
+	g.emit("var %s object.Object = %s", t, left)
+	g.emit("if %s.Type() == object.NULL_OBJ {", t)
+	right, err := g.expression(e.Right)
+	if err != nil {
+		return "", err
+	}
+	g.emit("%s = %s", t, right)
+	g.emit("}")
+	return t, nil
+}
+
+func (g *generator) expressions(exps []ast.Expression) ([]string, error) {
+	var values []string
+	for _, e := range exps {
+		v, err := g.expression(e)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func join(first string, rest []string) string {
+	var out bytes.Buffer
+	out.WriteString(first)
+	for _, r := range rest {
+		if out.Len() > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(r)
+	}
+	return out.String()
+}
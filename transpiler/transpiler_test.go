@@ -0,0 +1,180 @@
+package transpiler
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/lexer"
+	"github.com/cedrickchee/hou/parser"
+)
+
+func TestTranspile(t *testing.T) {
+	src, err := Transpile(parse(t, `let x = 5; let y = "hou"; puts(x + 5, y, x, 1.5e3);`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(src)
+	expected := []string{
+		"// Code generated by hou build --native. DO NOT EDIT.",
+		"package main",
+		`c0 = &object.Integer{Value: 5}`,
+		`c1 = &object.String{Value: "hou"}`,
+		`c2 = &object.Float{Value: 1500}`,
+		"native.Main(program)",
+	}
+	for _, e := range expected {
+		if !strings.Contains(got, e) {
+			t.Errorf("generated code doesn't contain %q. got=\n%s", e, got)
+		}
+	}
+
+	// Identical literals share one constant.
+	if strings.Count(got, "&object.Integer{Value: 5}") != 1 {
+		t.Errorf("literal 5 wasn't deduplicated. got=\n%s", got)
+	}
+}
+
+func TestTranspileTasks(t *testing.T) {
+	// Only programs that use tasks guard their variables, which tasks share.
+	tests := []struct {
+		input   string
+		guarded bool
+	}{
+		{`let x = 1; x = x + 1`, false},
+		{`let x = 1; wait(spawn(fn() { x = x + 1 }))`, true},
+		{`let x = 1; wait(spawn fn() { x = x + 1 })`, true},
+		{`let x = 1; pmap([1, 2], fn(n) { x + n })`, true},
+	}
+
+	for _, tt := range tests {
+		src, err := Transpile(parse(t, tt.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(src)
+		if guarded := strings.Contains(got, "native.Store(&") &&
+			strings.Contains(got, "native.Load(&"); guarded != tt.guarded {
+			t.Errorf("wrong guarding of %q. want=%t, got=\n%s", tt.input, tt.guarded, got)
+		}
+	}
+}
+
+func TestBuildAndRun(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go tool not available")
+	}
+
+	input := `
+let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) };
+puts(fib(15));
+puts(fn fact(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }(10));
+let scale = fn(x, by = x) { x * by };
+puts(scale(3), scale(3, 2));
+
+let newAdder = fn(x) { fn(y) { x + y } };
+let addTwo = newAdder(2);
+puts(addTwo(3));
+
+let map = fn(arr, f) {
+	let iter = fn(arr, accumulated) {
+		if (len(arr) == 0) {
+			accumulated
+		} else {
+			iter(rest(arr), push(accumulated, f(first(arr))));
+		}
+	};
+	iter(arr, []);
+};
+puts(map([1, 2, 3], fn(x) { x * x }));
+
+let h = {"one": 1, true: 2, 3: "three"};
+puts(h["one"], h[true], h[3], h["missing"]);
+
+if (10 > 1) { let x = "hoisted"; }
+puts(x);
+puts(!true, -5, "a" + "b", [1, 2, 3][1]);
+h["one"] += 10; h["four"] = 4;
+puts(h, h.four, h["five"] ?? 5);
+
+let counter = fn() { let n = 0; fn() { n += 1 } };
+let count = counter();
+count(); count();
+let total = 1;
+total *= count();
+puts(total = total + 1);
+puts(1 + true);
+puts("unreachable");
+`
+	expected := `610
+3628800
+9
+6
+5
+[1, 4, 9]
+1
+2
+three
+null
+hoisted
+false
+-5
+ab
+2
+{one: 11, true: 2, 3: three, four: 4}
+4
+5
+4
+`
+	src, err := Transpile(parse(t, input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binary := filepath.Join(t.TempDir(), "program")
+	if err := Build(src, binary, ".."); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr strings.Builder
+	cmd := exec.Command(binary)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Errorf("wrong exit status. got=%v", err)
+	}
+
+	if stdout.String() != expected {
+		t.Errorf("wrong output. got=\n%s\nwant=\n%s", stdout.String(), expected)
+	}
+	if stderr.String() != "ERROR:type mismatch: INTEGER + BOOLEAN\n" {
+		t.Errorf("wrong error output. got=%q", stderr.String())
+	}
+}
+
+func TestBuildUnknownRoot(t *testing.T) {
+	if err := Build(nil, "program", ""); err == nil {
+		t.Errorf("expected an error for an unknown Hou source directory")
+	}
+}
+
+func TestTranspileVersion(t *testing.T) {
+	program := parse(t, "#pragma version 2\nlet x = 1;")
+	if _, err := Transpile(program); err == nil {
+		t.Errorf("expected an error for a program of version 2")
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return program
+}
@@ -0,0 +1,595 @@
+package typecheck
+
+// Package typecheck implements the optional type annotations of Hou:
+//
+//	let x: Int = 5;
+//	let greet = fn(name: String, times: Int) -> String { ... };
+//
+// Check verifies the annotations of a program before it runs, which is what
+// `hou check` does. It's gradual: the types of expressions it can't tell, like
+// the results of builtins or of functions without annotations, are accepted
+// everywhere, so annotations can be added to a program bit by bit. It's also
+// flow-insensitive: the type of a name is the one it's annotated with, or the
+// type of its value if it's bound only once in its function, wherever it's
+// used. The evaluator ignores annotations, unless it's asked to assert them at
+// runtime, see Assert.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/object"
+)
+
+// Type is a type of Hou values, as annotations name it.
+type Type string
+
+// The types of values.
+const (
+	Any      Type = "Any" // every value
+	Int      Type = "Int"
+	Float    Type = "Float"
+	String   Type = "String"
+	Bool     Type = "Bool"
+	Array    Type = "Array"
+	Hash     Type = "Hash"
+	Function Type = "Function" // functions and builtins
+	Null     Type = "Null"
+
+	// unknown is the type of expressions the checker can't tell the type of.
+	unknown Type = ""
+)
+
+// types maps the types annotations can name to them.
+var types = map[string]Type{}
+
+func init() {
+	for _, t := range []Type{Any, Int, Float, String, Bool, Array, Hash, Function, Null} {
+		types[string(t)] = t
+	}
+}
+
+// Lookup returns the type called name.
+func Lookup(name string) (Type, bool) {
+	t, ok := types[name]
+	return t, ok
+}
+
+// Of returns the type of the value, or "" if it's a value of a type
+// annotations can't name, e.g. a channel, which only Any accepts.
+func Of(obj object.Object) Type {
+	switch obj.Type() {
+	case object.INTEGER_OBJ:
+		return Int
+	case object.FLOAT_OBJ:
+		return Float
+	case object.STRING_OBJ:
+		return String
+	case object.BOOLEAN_OBJ:
+		return Bool
+	case object.ARRAY_OBJ:
+		return Array
+	case object.HASH_OBJ:
+		return Hash
+	case object.FUNCTION_OBJ, object.BUILTIN_OBJ:
+		return Function
+	case object.NULL_OBJ:
+		return Null
+	}
+	return unknown
+}
+
+// Assert returns an error object if the value doesn't have the type
+// annotation names, or if annotation names no type. what tells what the
+// value is, e.g. "let x" or "argument 1".
+func Assert(annotation *ast.TypeName, obj object.Object, what string) *object.Error {
+	t, ok := Lookup(annotation.Name)
+	if !ok {
+		return &object.Error{
+			Code:    diagnostic.UnknownType,
+			Message: fmt.Sprintf("unknown type %s", annotation.Name),
+		}
+	}
+	if t != Any && Of(obj) != t {
+		return &object.Error{
+			Code: diagnostic.TypeAssertion,
+			Message: fmt.Sprintf("type assertion failed: %s is %s, want %s",
+				what, obj.Type(), t),
+		}
+	}
+	return nil
+}
+
+// accepts reports whether values of the type u can be used where values of
+// the type t are wanted. Like unknown, Any is accepted everywhere: the
+// annotation only says the value may be of any type.
+func (t Type) accepts(u Type) bool {
+	return t == Any || t == unknown || u == Any || u == unknown || t == u
+}
+
+// binding is what the checker knows about a name.
+type binding struct {
+	typ Type
+	// fn is the function the name is bound to, if it's known.
+	fn *ast.FunctionLiteral
+}
+
+// checker checks the annotations of a program.
+type checker struct {
+	// scopes holds the bindings of the functions and for loops being
+	// checked, the program first.
+	scopes []map[string]binding
+	// fn is the function being checked, nil for the program.
+	fn          *ast.FunctionLiteral
+	diagnostics []diagnostic.Diagnostic
+}
+
+// Check checks the type annotations of the program and returns the problems
+// found.
+func Check(program *ast.Program) []diagnostic.Diagnostic {
+	c := &checker{}
+	c.checkBody(program.Statements, nil)
+	return c.diagnostics
+}
+
+func (c *checker) errorf(
+	code diagnostic.Code,
+	node ast.Node,
+	format string,
+	args ...interface{},
+) {
+	c.diagnostics = append(c.diagnostics,
+		diagnostic.New(code, node.Pos(), fmt.Sprintf(format, args...)))
+}
+
+// checkBody checks the statements of the program or of the body of the
+// function fn.
+func (c *checker) checkBody(statements []ast.Statement, fn *ast.FunctionLiteral) {
+	scope := map[string]binding{}
+	if fn != nil {
+		if fn.Name != nil {
+			scope[fn.Name.Value] = binding{typ: Function, fn: fn}
+		}
+		for i, param := range fn.Parameters {
+			scope[param.Value] = binding{typ: c.annotated(parameterType(fn, i))}
+		}
+		c.annotated(fn.ReturnType)
+	}
+
+	c.scopes = append(c.scopes, scope)
+	outer := c.fn
+	c.fn = fn
+	defer func() {
+		c.scopes = c.scopes[:len(c.scopes)-1]
+		c.fn = outer
+	}()
+
+	// Default values are evaluated in the scope of the function, and must
+	// have the types of their parameters.
+	if fn != nil {
+		for i, param := range fn.Parameters {
+			value := fn.Default(i)
+			if value == nil {
+				continue
+			}
+			valueType := c.typeOf(value, true)
+			annotation := parameterType(fn, i)
+			if annotation == nil {
+				continue
+			}
+			if t, ok := Lookup(annotation.Name); ok && !t.accepts(valueType) {
+				c.errorf(diagnostic.IncompatibleType, value,
+					"cannot use %s (%s) as %s in parameter %s",
+					value, valueType, t, param)
+			}
+		}
+	}
+
+	// Flow-insensitively, a name bound once has the type of its value
+	// everywhere in the function, and a name bound several times only the
+	// type it's annotated with. The types of values may depend on the
+	// bindings before them.
+	lets := letStatements(statements)
+	count := map[string]int{}
+	for _, let := range lets {
+		count[let.Name.Value]++
+		// The name is local everywhere in the function, even before it's
+		// bound.
+		scope[let.Name.Value] = binding{}
+	}
+	// Assignments, even in the functions in this one, bind the names again.
+	for _, name := range assignedNames(statements) {
+		if count[name] == 1 {
+			count[name]++
+		}
+	}
+	for _, let := range lets {
+		name := let.Name.Value
+		if count[name] < 0 {
+			// Typed by its first let.
+			continue
+		}
+		b := binding{typ: lookup(let.Type)}
+		if count[name] == 1 {
+			if lit, ok := let.Value.(*ast.FunctionLiteral); ok {
+				b.fn = lit
+			}
+			if let.Type == nil {
+				b.typ = c.typeOf(let.Value, false)
+			}
+		}
+		scope[name] = b
+		if count[name] > 1 {
+			count[name] = -1
+		}
+	}
+
+	for _, s := range statements {
+		c.checkStatement(s)
+	}
+	if fn != nil && fn.ReturnType != nil && len(statements) > 0 {
+		if last, ok := statements[len(statements)-1].(*ast.ExpressionStatement); ok {
+			c.checkReturn(last.Expression)
+		}
+	}
+}
+
+// annotated returns the type the annotation names, or unknown if there's no
+// annotation. It reports annotations naming unknown types.
+func (c *checker) annotated(annotation *ast.TypeName) Type {
+	if annotation == nil {
+		return unknown
+	}
+	t, ok := Lookup(annotation.Name)
+	if !ok {
+		c.errorf(diagnostic.UnknownType, annotation, "unknown type %s", annotation.Name)
+	}
+	return t
+}
+
+// lookup returns the type the annotation names, or unknown if there's no
+// annotation or it names no type.
+func lookup(annotation *ast.TypeName) Type {
+	if annotation == nil {
+		return unknown
+	}
+	t, _ := Lookup(annotation.Name)
+	return t
+}
+
+func (c *checker) checkStatement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		valueType := c.typeOf(s.Value, true)
+		if t := c.annotated(s.Type); !t.accepts(valueType) {
+			c.errorf(diagnostic.IncompatibleType, s.Value,
+				"cannot use %s (%s) as %s in let %s", s.Value, valueType, t, s.Name)
+		}
+	case *ast.ReturnStatement:
+		c.typeOf(s.ReturnValue, true)
+		c.checkReturn(s.ReturnValue)
+	case *ast.ExpressionStatement:
+		c.typeOf(s.Expression, true)
+	}
+}
+
+// checkReturn checks that the function being checked returns a value of the
+// annotated type.
+func (c *checker) checkReturn(value ast.Expression) {
+	if c.fn == nil || c.fn.Generator || value == nil {
+		return
+	}
+	t := lookup(c.fn.ReturnType)
+	if valueType := c.typeOf(value, false); !t.accepts(valueType) {
+		c.errorf(diagnostic.IncompatibleType, value,
+			"cannot use %s (%s) as %s in return", value, valueType, t)
+	}
+}
+
+// typeOf returns the type of the expression. It checks the expression, and
+// the functions in it, if check is set. Checking an expression twice would
+// report its problems twice.
+func (c *checker) typeOf(node ast.Expression, check bool) Type {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return Int
+	case *ast.FloatLiteral:
+		return Float
+	case *ast.StringLiteral:
+		return String
+	case *ast.Boolean:
+		return Bool
+	case *ast.Null:
+		return Null
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			c.typeOf(el, check)
+		}
+		return Array
+	case *ast.HashLiteral:
+		for _, key := range node.Keys {
+			c.typeOf(key, check)
+			c.typeOf(node.Pairs[key], check)
+		}
+		return Hash
+	case *ast.FunctionLiteral:
+		if check {
+			c.checkBody(node.Body.Statements, node)
+		}
+		return Function
+
+	case *ast.Identifier:
+		if b, ok := c.lookupName(node.Value); ok {
+			return b.typ
+		}
+	case *ast.PrefixExpression:
+		right := c.typeOf(node.Right, check)
+		switch {
+		case node.Operator == "!":
+			return Bool
+		case node.Operator == "-" && (right == Int || right == Float):
+			return right
+		}
+	case *ast.InfixExpression:
+		left := c.typeOf(node.Left, check)
+		right := c.typeOf(node.Right, check)
+		return infixType(node.Operator, left, right)
+	case *ast.AssignExpression:
+		return c.assignType(node, check)
+	case *ast.IfExpression:
+		c.typeOf(node.Condition, check)
+		consequence := c.blockType(node.Consequence, check)
+		if node.Alternative == nil {
+			return unknown
+		}
+		if alternative := c.blockType(node.Alternative, check); alternative == consequence {
+			return consequence
+		}
+	case *ast.WhileExpression:
+		c.typeOf(node.Condition, check)
+		c.blockType(node.Body, check)
+		return Null
+	case *ast.ForInExpression:
+		c.typeOf(node.Iterable, check)
+		// The names of the loop hide the ones outside of it, and nothing's
+		// known about them.
+		scope := map[string]binding{node.Value.Value: {}}
+		if node.Key != nil {
+			scope[node.Key.Value] = binding{}
+		}
+		c.scopes = append(c.scopes, scope)
+		c.blockType(node.Body, check)
+		c.scopes = c.scopes[:len(c.scopes)-1]
+		return Null
+	case *ast.MatchExpression:
+		return c.matchType(node, check)
+	case *ast.CallExpression:
+		return c.callType(node, check)
+	case *ast.IndexExpression:
+		c.typeOf(node.Left, check)
+		c.typeOf(node.Index, check)
+	case *ast.MemberExpression:
+		c.typeOf(node.Object, check)
+	case *ast.YieldExpression:
+		c.typeOf(node.Value, check)
+	case *ast.SpawnExpression:
+		c.typeOf(node.Function, check)
+	}
+	return unknown
+}
+
+// infixType returns the type of the result of the infix operator applied to
+// values of the types left and right.
+func infixType(operator string, left, right Type) Type {
+	switch operator {
+	case "<", ">", "<=", ">=", "==", "!=":
+		return Bool
+	case "+", "-", "*", "/", "%", "**":
+		if left == right && (left == Int || left == Float || left == String && operator == "+") {
+			return left
+		}
+		// Mixing integers and floats makes a float.
+		if left == Int && right == Float || left == Float && right == Int {
+			return Float
+		}
+	case "??":
+		// Values of a known type other than Null are never null.
+		if left == Null {
+			return right
+		}
+		if left != unknown {
+			return left
+		}
+	}
+	return unknown
+}
+
+// assignType returns the type of the assignment, the one of the value
+// assigned, and checks that the name accepts it. Names that are assigned to
+// have the type they're annotated with, see checkBody. Nothing's known about
+// the types of the elements of arrays and hashes, so they accept anything.
+func (c *checker) assignType(node *ast.AssignExpression, check bool) Type {
+	if node.Index != nil {
+		c.typeOf(node.Index, check)
+		t := c.typeOf(node.Value, check)
+		if node.Operator != "=" {
+			return unknown
+		}
+		return t
+	}
+
+	t := c.typeOf(node.Value, check)
+	b, ok := c.lookupName(node.Name.Value)
+	if node.Operator != "=" {
+		t = infixType(strings.TrimSuffix(node.Operator, "="), b.typ, t)
+	}
+	if check && ok && !b.typ.accepts(t) {
+		c.errorf(diagnostic.IncompatibleType, node.Value,
+			"cannot use %s (%s) as %s in assignment to %s", node.Value, t, b.typ, node.Name)
+	}
+	return t
+}
+
+// blockType returns the type of the value of the block, the one of its last
+// statement.
+func (c *checker) blockType(block *ast.BlockStatement, check bool) Type {
+	t := unknown
+	for _, s := range block.Statements {
+		if check {
+			c.checkStatement(s)
+		}
+		t = unknown
+		if s, ok := s.(*ast.ExpressionStatement); ok {
+			t = c.typeOf(s.Expression, false)
+		}
+	}
+	return t
+}
+
+// matchType returns the type of the match expression: the one of all its
+// cases, if they have the same one and there's a default case, since it's
+// null if no case matches otherwise.
+func (c *checker) matchType(node *ast.MatchExpression, check bool) Type {
+	c.typeOf(node.Subject, check)
+	var types []Type
+	for _, mc := range node.Cases {
+		for _, v := range mc.Values {
+			c.typeOf(v, check)
+		}
+		types = append(types, c.caseType(mc.Body, check))
+	}
+	if node.Default == nil {
+		return unknown
+	}
+	t := c.caseType(node.Default, check)
+	for _, other := range types {
+		if other != t {
+			return unknown
+		}
+	}
+	return t
+}
+
+// caseType returns the type of the body of a case of a match expression, which
+// is a scope of its own.
+func (c *checker) caseType(body *ast.BlockStatement, check bool) Type {
+	c.scopes = append(c.scopes, map[string]binding{})
+	defer func() { c.scopes = c.scopes[:len(c.scopes)-1] }()
+	return c.blockType(body, check)
+}
+
+// callType returns the type of the result of the call, and checks the
+// arguments of calls of functions with annotations.
+func (c *checker) callType(node *ast.CallExpression, check bool) Type {
+	argTypes := make([]Type, len(node.Arguments))
+	for i, arg := range node.Arguments {
+		argTypes[i] = c.typeOf(arg, check)
+	}
+
+	var fn *ast.FunctionLiteral
+	name := "fn"
+	switch function := node.Function.(type) {
+	case *ast.Identifier:
+		b, _ := c.lookupName(function.Value)
+		fn, name = b.fn, function.Value
+	case *ast.FunctionLiteral:
+		fn = function
+		if function.Name != nil {
+			name = function.Name.Value
+		}
+	}
+	c.typeOf(node.Function, check)
+	if fn == nil {
+		return unknown
+	}
+
+	if check {
+		n, required := len(node.Arguments), fn.Required()
+		if n < required || n > len(fn.Parameters) {
+			want := fmt.Sprint(required)
+			switch len(fn.Parameters) {
+			case required:
+			case required + 1:
+				want = fmt.Sprintf("%d or %d", required, len(fn.Parameters))
+			default:
+				want = fmt.Sprintf("%d to %d", required, len(fn.Parameters))
+			}
+			c.errorf(diagnostic.ArgumentCountMismatch, node,
+				"wrong number of arguments to %s. got=%d, want=%s",
+				name, n, want)
+		}
+		for i, arg := range node.Arguments {
+			annotation := parameterType(fn, i)
+			if annotation == nil {
+				continue
+			}
+			if t, ok := Lookup(annotation.Name); ok && !t.accepts(argTypes[i]) {
+				c.errorf(diagnostic.IncompatibleType, arg,
+					"cannot use %s (%s) as %s in argument %d of %s",
+					arg, argTypes[i], t, i+1, name)
+			}
+		}
+	}
+
+	if fn.ReturnType == nil || fn.Generator {
+		return unknown
+	}
+	t, _ := Lookup(fn.ReturnType.Name)
+	return t
+}
+
+// lookup returns what's known about the name, looking in the innermost
+// function first.
+func (c *checker) lookupName(name string) (binding, bool) {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if b, ok := c.scopes[i][name]; ok {
+			return b, true
+		}
+	}
+	return binding{}, false
+}
+
+// parameterType returns the annotation of the i-th parameter of fn, nil if
+// it has none.
+func parameterType(fn *ast.FunctionLiteral, i int) *ast.TypeName {
+	if i < len(fn.ParameterTypes) {
+		return fn.ParameterTypes[i]
+	}
+	return nil
+}
+
+// assignedNames returns the names assigned to in the statements, including
+// the ones in nested functions.
+func assignedNames(statements []ast.Statement) []string {
+	var names []string
+	for _, s := range statements {
+		ast.Inspect(s, func(node ast.Node) bool {
+			if assign, ok := node.(*ast.AssignExpression); ok && assign.Name != nil {
+				names = append(names, assign.Name.Value)
+			}
+			return true
+		})
+	}
+	return names
+}
+
+// letStatements returns the let statements of the statements and of the
+// blocks in them, but not the ones of the functions in them, which bind names
+// in the environments of their own calls.
+func letStatements(statements []ast.Statement) []*ast.LetStatement {
+	var lets []*ast.LetStatement
+	for _, s := range statements {
+		ast.Inspect(s, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.FunctionLiteral:
+				return false
+			case *ast.LetStatement:
+				lets = append(lets, node)
+			}
+			return true
+		})
+	}
+	return lets
+}
@@ -0,0 +1,184 @@
+package typecheck
+
+import (
+	"testing"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/lexer"
+	"github.com/cedrickchee/hou/object"
+	"github.com/cedrickchee/hou/parser"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		// Programs without annotations, or whose annotations hold, pass.
+		{"let x = 5; x + 1", nil},
+		{`let x: Int = 5; let s: String = "s"; let b: Bool = x < 10;`, nil},
+		{"let a: Array = [1]; let h: Hash = {}; let f: Function = len;", nil},
+		{"let add = fn(a: Int, b: Int) -> Int { a + b }; let x: Int = add(1, 2);", nil},
+		// Parameters with default values can be left out.
+		{"let add = fn(a: Int, b: Int = a) -> Int { a + b }; add(1); add(1, 2);", nil},
+		{
+			`let f = fn(a, b: Int = "b") { a }; f(); f(1, 2, 3);`,
+			[]string{
+				"1:24: error E4002: cannot use b (String) as Int in parameter b",
+				"1:37: error E4003: wrong number of arguments to f. got=0, want=1 or 2",
+				"1:42: error E4003: wrong number of arguments to f. got=3, want=1 or 2",
+			},
+		},
+		// Unknown types are accepted everywhere.
+		{`let x: Int = len("abc"); let y: String = first([1]);`, nil},
+		{"let f = fn(x) { x }; let s: String = f(1);", nil},
+		{"let any: Any = 5; let s: String = any;", nil},
+		{"#pragma version 2\nlet n: Null = while (false) { let x: Int = 1; };", nil},
+		// Match expressions have the type of their cases if there's a default
+		// case and they all have the same one.
+		{"#pragma version 2\nlet s: String = match (1) { case 1, 2: { \"a\" } default: { \"b\" } };", nil},
+		{"#pragma version 2\nlet x = 1; let s: String = match (x) { case 1: { let x = \"a\"; x } default: { \"b\" } }; let i: Int = x;", nil},
+		{"#pragma version 2\nlet s: String = match (1) { case 1: { \"a\" } default: { 2 } };", nil},
+		{"#pragma version 2\nlet s: String = match (1) { case 1: { \"a\" } };", nil},
+		{
+			"#pragma version 2\nlet s: String = match (1) { case 1: { 1 } default: { 2 } };",
+			[]string{"2:17: error E4002: cannot use match(1) { case 1: 1 default: 2 } (Int) as String in let s"},
+		},
+		// Nor about the elements of arrays and hashes.
+		{`let a = [1]; let s: String = a[0] = "s"; let t: String = a[0] += 1;`, nil},
+		{`let h = {"n": 1}; let s: String = h.n;`, nil},
+		{"#pragma version 2\nlet n: Null = null; let i: Int = null ?? 1; let j: Int = 2 ?? true;", nil},
+		{
+			"#pragma version 2\nlet s: String = null ?? 1;",
+			[]string{"2:22: error E4002: cannot use (null ?? 1) (Int) as String in let s"},
+		},
+		{
+			`fn() { let x: Int = "s"; {} }().n`,
+			[]string{"1:21: error E4002: cannot use s (String) as Int in let x"},
+		},
+		{
+			"let a = [1]; let i: Int = a[0] = true;",
+			[]string{"1:32: error E4002: cannot use ((a[0]) = true) (Bool) as Int in let i"},
+		},
+		// Nothing's known about the names of for loops, even if they hide
+		// names whose type is.
+		{"#pragma version 2\nlet x = 1; let n: Null = for (x in [\"a\"]) { let s: String = x; };", nil},
+		{
+			"#pragma version 2\nfor (k, v in {}) { let i: Int = true; }",
+			[]string{"2:33: error E4002: cannot use true (Bool) as Int in let i"},
+		},
+		{"let f: Float = 1.5; let g: Float = f * 2 + -f; let i: Int = 7 / 2;", nil},
+		{"let i: Int = 2 ** 8 % 7; let f: Float = 2 ** 0.5; let b: Bool = i >= 1 == f <= 2;", nil},
+		{
+			"let x: Int = 7 % 2.0;",
+			[]string{"1:16: error E4002: cannot use (7 % 2.0) (Float) as Int in let x"},
+		},
+		{
+			"let x: Int = 1 + 0.5;",
+			[]string{"1:16: error E4002: cannot use (1 + 0.5) (Float) as Int in let x"},
+		},
+		{
+			`let x: Int = "five";`,
+			[]string{"1:14: error E4002: cannot use five (String) as Int in let x"},
+		},
+		{
+			"let x: Num = 5;",
+			[]string{"1:8: error E4001: unknown type Num"},
+		},
+		{
+			"let add = fn(a: Int, b: Int) -> Int { a + b };\nadd(\"a\", true);",
+			[]string{
+				"2:5: error E4002: cannot use a (String) as Int in argument 1 of add",
+				"2:10: error E4002: cannot use true (Bool) as Int in argument 2 of add",
+			},
+		},
+		{
+			"let add = fn(a: Int, b: Int) { a + b };\nadd(1);",
+			[]string{"2:4: error E4003: wrong number of arguments to add. got=1, want=2"},
+		},
+		{
+			`let f = fn() -> Int { "s" };`,
+			[]string{`1:23: error E4002: cannot use s (String) as Int in return`},
+		},
+		{
+			`let f = fn(x) -> Int { if (x) { return true; } 1 };`,
+			[]string{"1:40: error E4002: cannot use true (Bool) as Int in return"},
+		},
+		{
+			"let f = fn() -> Bool { true };\nlet x: Int = f();",
+			[]string{"2:15: error E4002: cannot use f() (Bool) as Int in let x"},
+		},
+		// The types of parameters are known in the body.
+		{
+			"let f = fn(n: Int) { let s: String = n; };",
+			[]string{"1:38: error E4002: cannot use n (Int) as String in let s"},
+		},
+		// A name bound more than once has the type it's first annotated with.
+		{"let x = 1; let x = \"s\"; let y: Int = x;", nil},
+		{
+			"let x: Int = 1; let x: Int = \"s\";",
+			[]string{"1:30: error E4002: cannot use s (String) as Int in let x"},
+		},
+		// Assignments must keep annotated names to their type, and make the
+		// type of the others unknown.
+		{"let x: Int = 1; x += 2; x = x * 3;", nil},
+		{"let x = 1; x = \"s\"; let y: Int = x; let z: String = x;", nil},
+		{
+			"let x: Int = 1; x = \"s\";",
+			[]string{"1:21: error E4002: cannot use s (String) as Int in assignment to x"},
+		},
+		{
+			"let f: Float = 1.5; let g = fn() { f = f < 2 };",
+			[]string{"1:42: error E4002: cannot use (f < 2) (Bool) as Float in assignment to f"},
+		},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%q: parser errors: %v", tt.input, p.Errors())
+		}
+
+		diagnostics := Check(program)
+		if len(diagnostics) != len(tt.expected) {
+			t.Errorf("%q: wrong number of diagnostics. want=%d, got=%d (%v)",
+				tt.input, len(tt.expected), len(diagnostics), diagnostics)
+			continue
+		}
+		for i, d := range diagnostics {
+			if d.String() != tt.expected[i] {
+				t.Errorf("%q: diagnostics[%d] wrong. want=%q, got=%q",
+					tt.input, i, tt.expected[i], d.String())
+			}
+		}
+	}
+}
+
+func TestAssert(t *testing.T) {
+	tests := []struct {
+		annotation string
+		value      object.Object
+		expected   diagnostic.Code
+	}{
+		{"Int", &object.Integer{Value: 1}, ""},
+		{"Any", &object.String{Value: "s"}, ""},
+		{"Null", &object.Null{}, ""},
+		{"Int", &object.String{Value: "s"}, diagnostic.TypeAssertion},
+		{"Num", &object.Integer{Value: 1}, diagnostic.UnknownType},
+	}
+
+	for _, tt := range tests {
+		err := Assert(&ast.TypeName{Name: tt.annotation}, tt.value, "x")
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %s", tt.annotation, err.Message)
+			}
+			continue
+		}
+		if err == nil || err.Code != tt.expected {
+			t.Errorf("%s: want error %s, got=%v", tt.annotation, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,828 @@
+package vm
+
+// Package vm implements the virtual machine that runs the bytecode of
+// package compiler, an alternative to the tree-walker of package evaluator
+// for programs that spend their time in hot loops and recursive functions.
+//
+// The VM is stack-based: instructions pop their operands from the stack and
+// push their results onto it. Every call has a frame, whose locals are kept on
+// the stack below the values its instructions work with. The operators,
+// indexing and builtins are the ones of package evaluator, so a program
+// computes the same results with either engine, see package compiler for the
+// differences in how names are bound.
+//
+// The VM doesn't call hooks, evaluate the steps of programs or account for
+// their memory, so it ignores the limits, the sandbox, but for the builtins it
+// allows, and the type assertions of the Evaluator it takes its builtins from.
+// Builtins call the closures of the program on stacks of their own, and the
+// closures that tasks, e.g. of spawn or pmap, call on other goroutines share
+// the globals and captured variables with the rest of the program, which a
+// lock guards like the environments of the evaluator.
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/cedrickchee/hou/compiler"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/lang"
+	"github.com/cedrickchee/hou/object"
+)
+
+// stackSize is the initial size of the stack, which grows as needed.
+const stackSize = 256
+
+// operators are the infix operators of the opcodes.
+var operators = map[compiler.Opcode]string{
+	compiler.OpAdd:          "+",
+	compiler.OpSub:          "-",
+	compiler.OpMul:          "*",
+	compiler.OpDiv:          "/",
+	compiler.OpMod:          "%",
+	compiler.OpPow:          "**",
+	compiler.OpEqual:        "==",
+	compiler.OpNotEqual:     "!=",
+	compiler.OpLessThan:     "<",
+	compiler.OpGreaterThan:  ">",
+	compiler.OpLessEqual:    "<=",
+	compiler.OpGreaterEqual: ">=",
+}
+
+// VM runs a compiled program.
+type VM struct {
+	constants []object.Object
+	// mu guards globals and the values of cells, which tasks share.
+	mu       sync.RWMutex
+	globals  []object.Object
+	main     *compiler.CompiledFunction
+	features lang.FeatureSet
+
+	eval *evaluator.Evaluator
+}
+
+// New returns a VM running the bytecode with the builtins of the Evaluator e,
+// which write to its output.
+func New(bytecode *compiler.Bytecode, e *evaluator.Evaluator) *VM {
+	return &VM{
+		constants: bytecode.Constants,
+		globals:   make([]object.Object, bytecode.NumGlobals),
+		main:      bytecode.Main,
+		features:  bytecode.Features,
+		eval:      e,
+	}
+}
+
+// Run runs the program and returns its result, or the error object that
+// stopped it.
+func (vm *VM) Run() object.Object {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext is like Run, with the context of the builtins that take one,
+// e.g. to wait for tasks.
+func (vm *VM) RunContext(ctx context.Context) object.Object {
+	// Running the program as a builtin of the Evaluator gives it the context,
+	// the scheduler of tasks and the recovery from panics that builtins
+	// rely on.
+	program := &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			return vm.call(&Closure{Fn: vm.main, vm: vm}, nil)
+		},
+	}
+	return vm.eval.CallContext(ctx, program, nil)
+}
+
+// Closure is a function of the program run by the VM, with the cells of the
+// free variables it captured.
+type Closure struct {
+	Fn   *compiler.CompiledFunction
+	Free []*cell
+
+	vm *VM
+}
+
+// Type returns the type of the object.
+func (c *Closure) Type() object.ObjectType { return object.FUNCTION_OBJ }
+
+// Inspect returns a stringified version of the object for debugging.
+func (c *Closure) Inspect() string { return c.Fn.Inspect() }
+
+// Call calls the closure with the arguments, on a stack of its own. It's how
+// builtins call back into the program.
+func (c *Closure) Call(args ...object.Object) object.Object {
+	return c.vm.call(c, args)
+}
+
+// cell holds a variable captured by closures. Cells are kept in the locals of
+// the function that binds the variable, and in the closures that capture it.
+// The lock of the VM guards their values.
+type cell struct {
+	value object.Object
+}
+
+// load returns the value of the variable in *slot, a global or the value of
+// a cell.
+func (vm *VM) load(slot *object.Object) object.Object {
+	vm.mu.RLock()
+	value := *slot
+	vm.mu.RUnlock()
+	return value
+}
+
+// store sets the variable in *slot to value.
+func (vm *VM) store(slot *object.Object, value object.Object) {
+	vm.mu.Lock()
+	*slot = value
+	vm.mu.Unlock()
+}
+
+// assign sets the variable in *slot to value, like store, unless it's unbound
+// yet, and reports whether it did.
+func (vm *VM) assign(slot *object.Object, value object.Object) bool {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	if *slot == nil {
+		return false
+	}
+	*slot = value
+	return true
+}
+
+// Type returns the type of the object.
+func (c *cell) Type() object.ObjectType { return "CELL" }
+
+// Inspect returns a stringified version of the object for debugging.
+func (c *cell) Inspect() string { return "cell" }
+
+// iterator is the state of a for loop, which is kept on the stack while the
+// loop runs.
+type iterator struct {
+	*evaluator.Iterator
+}
+
+// Type returns the type of the object.
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+
+// Inspect returns a stringified version of the object for debugging.
+func (it *iterator) Inspect() string { return "iterator" }
+
+// frame is a call in progress.
+type frame struct {
+	cl *Closure
+	// ip is the offset of the next instruction.
+	ip int
+	// base is the index of the first local of the call on the stack.
+	base int
+	// numArgs is the number of arguments the call was passed.
+	numArgs int
+}
+
+// machine holds the state of running a call: its stack and the frames of
+// the calls in progress.
+type machine struct {
+	vm     *VM
+	stack  []object.Object
+	sp     int // the next free slot of the stack
+	frames []frame
+	// yield hands the values of the generator the machine runs over to its
+	// consumer, nil if it doesn't run one.
+	yield func(value object.Object) object.Object
+}
+
+// call calls the closure with the arguments on a new machine. Calling a
+// generator function returns a generator, whose body runs on a machine of
+// its own as values are asked for.
+func (vm *VM) call(cl *Closure, args []object.Object) object.Object {
+	if cl.Fn.Generator() {
+		return vm.eval.NewGenerator(func(yield func(object.Object) object.Object) object.Object {
+			return vm.run(cl, args, yield)
+		})
+	}
+	return vm.run(cl, args, nil)
+}
+
+// run runs the call of the closure with the arguments on a new machine.
+func (vm *VM) run(cl *Closure, args []object.Object, yield func(object.Object) object.Object) object.Object {
+	m := &machine{vm: vm, stack: make([]object.Object, stackSize), yield: yield}
+	m.push(cl)
+	for _, arg := range args {
+		m.push(arg)
+	}
+	if err := m.enter(cl, len(args)); err != nil {
+		return err
+	}
+	return m.run()
+}
+
+func (m *machine) push(obj object.Object) {
+	if m.sp == len(m.stack) {
+		m.grow(1)
+	}
+	m.stack[m.sp] = obj
+	m.sp++
+}
+
+func (m *machine) pop() object.Object {
+	m.sp--
+	obj := m.stack[m.sp]
+	m.stack[m.sp] = nil
+	return obj
+}
+
+// grow makes room for at least n more values on the stack.
+func (m *machine) grow(n int) {
+	size := 2 * len(m.stack)
+	for size < m.sp+n {
+		size *= 2
+	}
+	stack := make([]object.Object, size)
+	copy(stack, m.stack[:m.sp])
+	m.stack = stack
+}
+
+// enter starts a call of the closure, which is on the stack below its
+// arguments. Missing arguments are an error, unless their parameters have
+// default values, and extra ones are ignored, unless the function has default
+// values or the features have lang.StrictArity, as in the evaluator.
+func (m *machine) enter(cl *Closure, numArgs int) *object.Error {
+	fn := cl.Fn
+	if numArgs < fn.NumRequired || numArgs > fn.NumParameters {
+		args := m.stack[m.sp-numArgs : m.sp]
+		if err := evaluator.CheckArity(m.vm.features, args, fn.NumRequired, fn.NumParameters); err != nil {
+			return err
+		}
+	}
+	// The program's own frame doesn't count as a call, as in the evaluator.
+	max := m.vm.eval.MaxCallDepth() + 1
+	if len(m.frames) >= max {
+		return &object.Error{
+			Code:    diagnostic.StackOverflow,
+			Message: fmt.Sprintf("stack overflow: max call depth %d exceeded", max-1),
+		}
+	}
+
+	base := m.sp - numArgs
+	if base+fn.NumLocals > len(m.stack) {
+		m.grow(base + fn.NumLocals - m.sp)
+	}
+	// The locals that aren't parameters with arguments start out unbound,
+	// which extra arguments mustn't change.
+	bound := numArgs
+	if bound > fn.NumParameters {
+		bound = fn.NumParameters
+	}
+	for i := base + bound; i < base+fn.NumLocals || i < m.sp; i++ {
+		m.stack[i] = nil
+	}
+	m.sp = base + fn.NumLocals
+
+	m.frames = append(m.frames, frame{cl: cl, base: base, numArgs: numArgs})
+	return nil
+}
+
+// run runs the instructions of the calls in progress until the first one
+// returns, and returns its result.
+func (m *machine) run() object.Object {
+	vm := m.vm
+
+	for {
+		f := &m.frames[len(m.frames)-1]
+		ins := f.cl.Fn.Instructions
+		start := f.ip
+		op := compiler.Opcode(ins[start])
+		f.ip++
+
+		switch op {
+		case compiler.OpConstant:
+			index := compiler.ReadUint16(ins[f.ip:])
+			f.ip += 2
+			m.push(vm.constants[index])
+
+		case compiler.OpPop:
+			m.pop()
+
+		case compiler.OpTrue:
+			m.push(object.TRUE)
+
+		case compiler.OpFalse:
+			m.push(object.FALSE)
+
+		case compiler.OpNull:
+			m.push(object.NULL)
+
+		case compiler.OpAdd, compiler.OpSub, compiler.OpMul, compiler.OpDiv,
+			compiler.OpMod, compiler.OpPow, compiler.OpEqual, compiler.OpNotEqual,
+			compiler.OpLessThan, compiler.OpGreaterThan,
+			compiler.OpLessEqual, compiler.OpGreaterEqual:
+			right := m.pop()
+			left := m.pop()
+			result := vm.infix(op, left, right)
+			if err, ok := result.(*object.Error); ok {
+				return m.fail(err, start)
+			}
+			m.push(result)
+
+		case compiler.OpMinus:
+			right := m.pop()
+			var result object.Object
+			if integer, ok := right.(*object.Integer); ok && integer.Value != math.MinInt64 {
+				result = object.NewInteger(-integer.Value)
+			} else {
+				result = evaluator.EvalPrefix("-", right)
+			}
+			if err, ok := result.(*object.Error); ok {
+				return m.fail(err, start)
+			}
+			m.push(result)
+
+		case compiler.OpBang:
+			m.push(evaluator.EvalPrefix("!", m.pop()))
+
+		case compiler.OpJump:
+			f.ip = int(compiler.ReadUint16(ins[f.ip:]))
+
+		case compiler.OpJumpNotTruthy:
+			target := int(compiler.ReadUint16(ins[f.ip:]))
+			f.ip += 2
+			if !evaluator.IsTruthy(m.pop()) {
+				f.ip = target
+			}
+
+		case compiler.OpJumpNotNull:
+			target := int(compiler.ReadUint16(ins[f.ip:]))
+			f.ip += 2
+			if m.stack[m.sp-1].Type() != object.NULL_OBJ {
+				f.ip = target
+			} else {
+				m.pop()
+			}
+
+		case compiler.OpMatch:
+			target := int(compiler.ReadUint16(ins[f.ip:]))
+			f.ip += 2
+			value := m.pop()
+			matched := evaluator.EvalMatch(m.stack[m.sp-1], value)
+			if err, ok := matched.(*object.Error); ok {
+				return m.fail(err, start)
+			}
+			if matched == evaluator.TRUE {
+				m.pop()
+				f.ip = target
+			}
+
+		case compiler.OpIter:
+			it, err := vm.eval.NewIterator(m.pop())
+			if err != nil {
+				return m.fail(err, start)
+			}
+			m.push(&iterator{it})
+
+		case compiler.OpNext:
+			target := int(compiler.ReadUint16(ins[f.ip:]))
+			numNames := int(ins[f.ip+2])
+			f.ip += 3
+			it := m.stack[m.sp-1].(*iterator)
+			if numNames == 1 {
+				element, ok := it.NextElement()
+				if !ok {
+					if err := it.Err(); err != nil {
+						return m.fail(err, start)
+					}
+					m.pop()
+					f.ip = target
+					continue
+				}
+				m.push(element)
+			} else {
+				key, value, ok := it.Next()
+				if !ok {
+					if err := it.Err(); err != nil {
+						return m.fail(err, start)
+					}
+					m.pop()
+					f.ip = target
+					continue
+				}
+				m.push(key)
+				m.push(value)
+			}
+
+		case compiler.OpGetGlobal:
+			index := compiler.ReadUint16(ins[f.ip:])
+			f.ip += 2
+			value := vm.load(&vm.globals[index])
+			if value == nil {
+				return m.fail(m.notFound(f, start), start)
+			}
+			m.push(value)
+
+		case compiler.OpSetGlobal:
+			index := compiler.ReadUint16(ins[f.ip:])
+			f.ip += 2
+			vm.store(&vm.globals[index], m.pop())
+
+		case compiler.OpGetLocal:
+			index := int(ins[f.ip])
+			f.ip++
+			value := m.stack[f.base+index]
+			if value == nil {
+				return m.fail(m.notFound(f, start), start)
+			}
+			m.push(value)
+
+		case compiler.OpSetLocal:
+			index := int(ins[f.ip])
+			f.ip++
+			m.stack[f.base+index] = m.pop()
+
+		case compiler.OpGetCell:
+			index := int(ins[f.ip])
+			f.ip++
+			value := vm.load(&m.stack[f.base+index].(*cell).value)
+			if value == nil {
+				return m.fail(m.notFound(f, start), start)
+			}
+			m.push(value)
+
+		case compiler.OpSetCell:
+			index := int(ins[f.ip])
+			f.ip++
+			vm.store(&m.stack[f.base+index].(*cell).value, m.pop())
+
+		case compiler.OpGetFree:
+			index := int(ins[f.ip])
+			f.ip++
+			value := vm.load(&f.cl.Free[index].value)
+			if value == nil {
+				return m.fail(m.notFound(f, start), start)
+			}
+			m.push(value)
+
+		case compiler.OpSetFree:
+			index := int(ins[f.ip])
+			f.ip++
+			vm.store(&f.cl.Free[index].value, m.pop())
+
+		case compiler.OpFreeCell:
+			index := int(ins[f.ip])
+			f.ip++
+			m.push(f.cl.Free[index])
+
+		case compiler.OpNewCell:
+			index := int(ins[f.ip])
+			f.ip++
+			m.stack[f.base+index] = &cell{}
+
+		case compiler.OpBox:
+			index := int(ins[f.ip])
+			f.ip++
+			m.stack[f.base+index] = &cell{value: m.stack[f.base+index]}
+
+		case compiler.OpGetBuiltin:
+			index := compiler.ReadUint16(ins[f.ip:])
+			f.ip += 2
+			name := vm.constants[index].(*object.String).Value
+			builtin := vm.builtin(name, f.cl.Fn.Locations[start].Suggestion)
+			if err, ok := builtin.(*object.Error); ok {
+				return m.fail(err, start)
+			}
+			m.push(builtin)
+
+		case compiler.OpAssignGlobal:
+			index := compiler.ReadUint16(ins[f.ip:])
+			f.ip += 2
+			if !vm.assign(&vm.globals[index], m.stack[m.sp-1]) {
+				return m.fail(m.undeclared(f, start), start)
+			}
+
+		case compiler.OpAssignLocal:
+			index := int(ins[f.ip])
+			f.ip++
+			if m.stack[f.base+index] == nil {
+				return m.fail(m.undeclared(f, start), start)
+			}
+			m.stack[f.base+index] = m.stack[m.sp-1]
+
+		case compiler.OpAssignCell:
+			index := int(ins[f.ip])
+			f.ip++
+			c := m.stack[f.base+index].(*cell)
+			if !vm.assign(&c.value, m.stack[m.sp-1]) {
+				return m.fail(m.undeclared(f, start), start)
+			}
+
+		case compiler.OpAssignFree:
+			index := int(ins[f.ip])
+			f.ip++
+			if !vm.assign(&f.cl.Free[index].value, m.stack[m.sp-1]) {
+				return m.fail(m.undeclared(f, start), start)
+			}
+
+		case compiler.OpArray:
+			n := int(compiler.ReadUint16(ins[f.ip:]))
+			f.ip += 2
+			elements := make([]object.Object, n)
+			copy(elements, m.stack[m.sp-n:m.sp])
+			m.sp -= n
+			m.push(&object.Array{Elements: elements})
+
+		case compiler.OpHash:
+			n := int(compiler.ReadUint16(ins[f.ip:]))
+			f.ip += 2
+			hash, err := buildHash(m.stack[m.sp-2*n : m.sp])
+			if err != nil {
+				return m.fail(err, start)
+			}
+			m.sp -= 2 * n
+			m.push(hash)
+
+		case compiler.OpIndex:
+			index := m.pop()
+			left := m.pop()
+			result := evaluator.EvalIndex(left, index)
+			if err, ok := result.(*object.Error); ok {
+				return m.fail(err, start)
+			}
+			m.push(result)
+
+		case compiler.OpMember:
+			index := compiler.ReadUint16(ins[f.ip:])
+			f.ip += 2
+			name := vm.constants[index].(*object.String).Value
+			result := evaluator.EvalMember(m.pop(), name)
+			if err, ok := result.(*object.Error); ok {
+				return m.fail(err, start)
+			}
+			m.push(result)
+
+		case compiler.OpSetIndex:
+			op := compiler.Opcode(ins[f.ip])
+			f.ip++
+			value := m.pop()
+			index := m.pop()
+			left := m.pop()
+			if op != compiler.OpSetIndex {
+				current := evaluator.EvalIndex(left, index)
+				if err, ok := current.(*object.Error); ok {
+					return m.fail(err, start)
+				}
+				value = vm.infix(op, current, value)
+				if err, ok := value.(*object.Error); ok {
+					return m.fail(err, start)
+				}
+			}
+			result := evaluator.SetIndex(left, index, value)
+			if err, ok := result.(*object.Error); ok {
+				return m.fail(err, start)
+			}
+			m.push(result)
+
+		case compiler.OpCall:
+			numArgs := int(ins[f.ip])
+			f.ip++
+			callee := m.stack[m.sp-1-numArgs]
+			if cl, ok := callee.(*Closure); ok && cl.vm == vm && !cl.Fn.Generator() {
+				if err := m.enter(cl, numArgs); err != nil {
+					return m.fail(err, start)
+				}
+				continue
+			}
+
+			args := make([]object.Object, numArgs)
+			copy(args, m.stack[m.sp-numArgs:m.sp])
+			result := vm.eval.ApplyFunction(callee, args)
+			if result == nil {
+				result = object.NULL
+			}
+			if err, ok := result.(*object.Error); ok {
+				return m.fail(err, start)
+			}
+			// Drop the arguments and the function.
+			for i := 0; i <= numArgs; i++ {
+				m.pop()
+			}
+			m.push(result)
+
+		case compiler.OpReturnValue:
+			result := m.pop()
+			m.frames = m.frames[:len(m.frames)-1]
+			if len(m.frames) == 0 {
+				return result
+			}
+			// Drop the locals and the closure of the call.
+			for m.sp > f.base-1 {
+				m.pop()
+			}
+			m.push(result)
+
+		case compiler.OpClosure:
+			index := compiler.ReadUint16(ins[f.ip:])
+			numFree := int(ins[f.ip+2])
+			f.ip += 3
+			free := make([]*cell, numFree)
+			for i := range free {
+				free[i] = m.stack[m.sp-numFree+i].(*cell)
+			}
+			m.sp -= numFree
+			fn := vm.constants[index].(*compiler.CompiledFunction)
+			m.push(&Closure{Fn: fn, Free: free, vm: vm})
+
+		case compiler.OpCurrentClosure:
+			m.push(f.cl)
+
+		case compiler.OpJumpArgument:
+			target := int(compiler.ReadUint16(ins[f.ip:]))
+			index := int(ins[f.ip+2])
+			f.ip += 3
+			if f.numArgs > index {
+				f.ip = target
+			}
+
+		case compiler.OpYield:
+			if m.yield == nil {
+				// The compiler only emits OpYield in generator
+				// functions, which run on machines that yield.
+				return m.fail(&object.Error{
+					Code:    diagnostic.YieldOutsideGenerator,
+					Message: "yield outside of a generator",
+				}, start)
+			}
+			result := m.yield(m.pop())
+			if err, ok := result.(*object.Error); ok {
+				return m.fail(err, start)
+			}
+			m.push(result)
+
+		case compiler.OpError:
+			index := compiler.ReadUint16(ins[f.ip:])
+			f.ip += 2
+			err := *vm.constants[index].(*object.Error)
+			return m.fail(&err, start)
+
+		default:
+			def, _ := compiler.Lookup(byte(op))
+			panic(fmt.Sprintf("vm: unknown instruction %v at %d", def, start))
+		}
+	}
+}
+
+// infix applies the operator of the opcode to the operands. Integer
+// arithmetic that doesn't overflow and comparisons are done right here, the
+// rest like in the evaluator.
+func (vm *VM) infix(op compiler.Opcode, left, right object.Object) object.Object {
+	l, ok := left.(*object.Integer)
+	r, ok2 := right.(*object.Integer)
+	if ok && ok2 {
+		switch op {
+		case compiler.OpAdd:
+			if sum := l.Value + r.Value; (sum^l.Value)&(sum^r.Value) >= 0 {
+				return object.NewInteger(sum)
+			}
+		case compiler.OpSub:
+			if diff := l.Value - r.Value; (l.Value^r.Value)&(diff^l.Value) >= 0 {
+				return object.NewInteger(diff)
+			}
+		case compiler.OpMul:
+			if !evaluator.Overflows("*", l.Value, r.Value) {
+				return object.NewInteger(l.Value * r.Value)
+			}
+		case compiler.OpLessThan:
+			return nativeBool(l.Value < r.Value)
+		case compiler.OpGreaterThan:
+			return nativeBool(l.Value > r.Value)
+		case compiler.OpLessEqual:
+			return nativeBool(l.Value <= r.Value)
+		case compiler.OpGreaterEqual:
+			return nativeBool(l.Value >= r.Value)
+		case compiler.OpEqual:
+			return nativeBool(l.Value == r.Value)
+		case compiler.OpNotEqual:
+			return nativeBool(l.Value != r.Value)
+		}
+	}
+	return evaluator.EvalInfixWith(vm.features, operators[op], left, right)
+}
+
+func nativeBool(b bool) object.Object {
+	if b {
+		return object.TRUE
+	}
+	return object.FALSE
+}
+
+// builtin returns the builtin called name, or the error for a name that
+// nothing binds. suggestion is the variable in scope the name is most likely a
+// typo of, if any, which the error suggests unless a builtin is more likely,
+// as in the evaluator.
+func (vm *VM) builtin(name, suggestion string) object.Object {
+	if builtin, ok := vm.eval.Builtin(name); ok {
+		if !vm.eval.Sandbox.AllowsBuiltin(name) {
+			return &object.Error{
+				Code:    diagnostic.BuiltinNotAllowed,
+				Message: "builtin not allowed: " + name,
+			}
+		}
+		return builtin
+	}
+
+	// A keyword of a later version is an identifier in the versions before
+	// it, as in the evaluator.
+	if f, ok := lang.Keyword(name); ok && !vm.features.Has(f) {
+		return &object.Error{
+			Code: diagnostic.IdentifierNotFound,
+			Message: fmt.Sprintf(
+				"identifier not found: %s (%s came with version %s, see #pragma version)",
+				name, f, f.Since()),
+		}
+	}
+	candidates := vm.eval.BuiltinNames()
+	if suggestion != "" {
+		candidates = append(candidates, suggestion)
+	}
+	if hint := evaluator.Suggest(name, candidates); hint != "" {
+		return &object.Error{
+			Code:    diagnostic.IdentifierNotFound,
+			Message: fmt.Sprintf("identifier not found: %s, did you mean '%s'?", name, hint),
+		}
+	}
+	return &object.Error{
+		Code:    diagnostic.IdentifierNotFound,
+		Message: "identifier not found: " + name,
+	}
+}
+
+// buildHash returns a new hash built from alternating keys and values.
+func buildHash(keysAndValues []object.Object) (object.Object, *object.Error) {
+	hash := object.NewHash(len(keysAndValues) / 2)
+
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, value := keysAndValues[i], keysAndValues[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, &object.Error{
+				Code:    diagnostic.UnusableHashKey,
+				Message: fmt.Sprintf("unusable as hash key: %s", key.Type()),
+			}
+		}
+
+		hash.Set(hashKey.HashKey(), object.HashPair{Key: key, Value: value})
+	}
+
+	return hash, nil
+}
+
+// notFound returns the error for reading the variable used by the instruction
+// at the offset of the frame before it was bound.
+func (m *machine) notFound(f *frame, offset int) *object.Error {
+	return &object.Error{
+		Code:    diagnostic.IdentifierNotFound,
+		Message: "identifier not found: " + f.cl.Fn.Locations[offset].Name,
+	}
+}
+
+// undeclared returns the error for assigning to the variable used by the
+// instruction at the offset of the frame before it was bound.
+func (m *machine) undeclared(f *frame, offset int) *object.Error {
+	return &object.Error{
+		Code: diagnostic.UndeclaredAssignment,
+		Message: fmt.Sprintf("cannot assign to %s: it wasn't declared with let",
+			f.cl.Fn.Locations[offset].Name),
+	}
+}
+
+// fail returns the error, which happened at the instruction at the offset of
+// the current frame, with its position and the calls in progress as its trace,
+// unless it has a position already.
+func (m *machine) fail(err *object.Error, offset int) *object.Error {
+	if err.Position.IsValid() {
+		return err
+	}
+
+	// Every frame but the current one is at the call of the next one, the
+	// instruction before the one it continues with.
+	callSize := len(compiler.Make(compiler.OpCall, 0))
+
+	err.Position = m.frames[len(m.frames)-1].cl.Fn.Locations[offset].Position
+	err.Trace = make([]object.Frame, 0, len(m.frames))
+	pos := err.Position
+	for i := len(m.frames) - 1; i > 0; i-- {
+		call := m.frames[i-1].cl.Fn.Locations[m.frames[i-1].ip-callSize]
+		name := call.Name
+		if literal := m.frames[i].cl.Fn.Literal; literal != nil && literal.Name != nil {
+			name = literal.Name.Value
+		}
+		err.Trace = append(err.Trace, object.Frame{Function: name, Position: pos})
+		pos = call.Position
+	}
+	// Closures called back by builtins run on a machine of their own.
+	name := "<main>"
+	if m.frames[0].cl.Fn != m.vm.main {
+		name = "fn"
+	}
+	err.Trace = append(err.Trace, object.Frame{Function: name, Position: pos})
+	return err
+}
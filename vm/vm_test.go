@@ -0,0 +1,322 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/cedrickchee/hou/ast"
+	"github.com/cedrickchee/hou/compiler"
+	"github.com/cedrickchee/hou/diagnostic"
+	"github.com/cedrickchee/hou/evaluator"
+	"github.com/cedrickchee/hou/lexer"
+	"github.com/cedrickchee/hou/object"
+	"github.com/cedrickchee/hou/parser"
+	"github.com/cedrickchee/hou/token"
+)
+
+// TestRun checks that programs compute the same with the VM as with the
+// evaluator.
+func TestRun(t *testing.T) {
+	tests := []string{
+		"1 + 2 * 3 - 4 / 2",
+		"-5 + 10; -(1.5 * 2)",
+		`"foo" + "bar"`,
+		`["a" == "a", "a" != "a", "apple" < "banana", "b" >= "c"]`,
+		"!true == !!false",
+		"1 < 2 == true != (3 > 4)",
+		"1 == 1.0",
+		"[7 % 3, -7 % 3, 7.5 % 2, 2 ** 3 ** 2, -2 ** 2, 2 ** -1, 2 ** 0.5]",
+		"[1 <= 2, 2 <= 1, 1 >= 1, 1.5 >= 2]",
+		"if (1 > 2) { 10 }",
+		"if (1 < 2) { 10 } else { 20 }",
+		"let a = 1; let b = a + 1; [a, b]",
+		`{"one": 1, true: 2, 3: "three"}[3]`,
+		`[1, 2, 3][1] + {"a": 5}["a"]`,
+		"[1, 2, 3][5]",
+		"let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(15)",
+		"let newAdder = fn(x) { fn(y) { x + y } }; newAdder(2)(3)",
+		"let f = fn() { return 1; 2 }; f()",
+		"let f = fn fact(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; f(10)",
+		"let g = fn self() { self }; [g() == g, g]",
+		"let k = fn k(k) { k }; k(5)",
+		"let f = fn g() { g }; g",
+		"let f = fn(n) { let h = fn loop(i) { if (i > n) { [] } else { push(loop(i + 1), i) } }; h(1) }; f(3)",
+		"fn bad(n) { if (n == 0) { 1 + true } else { bad(n - 1) } }(2)",
+		"let f = fn(a, b = 10) { a + b }; [f(1), f(1, 2), f(1, 2, 3)]",
+		"let f = fn(a, b = a * 2, c = b + 1) { [a, b, c] }; [f(1), f(1, 5), f(1, 5, 0)]",
+		"let f = fn(a = 1) { fn() { a += 1 } }; let g = f(); g(); [g(), f(10)()]",
+		"let f = fn(a, b) { a }; f(1)",
+		"let f = fn(a = 1 + true) { a }; [f(2), f()]",
+		"#pragma version 3\nlet f = fn(a, b = 1) { a + b }; f(1, 2, 3)",
+		"let f = fn(a, b = 1) { a + b }; f(1, 2, 3)",
+		"9223372036854775807 + 1",
+		"let m = -9223372036854775807 - 1; [m - 1]",
+		"let m = -9223372036854775807 - 1; -m",
+		"let big = 4611686018427387904; [big * 2]",
+		"let big = 4611686018427387904; [big * -2, big - 1 + big, -big - big]",
+		"let f = fn(a, b = fn() { a }) { b() }; f(7)",
+		"let f = fn(a, b = fn() { a += 1 }) { [b(), b(), a] }; f(7)",
+		"let x = 3; let f = fn(a = fn() { x }) { x = 4; a() }; f()",
+		"let f = fn(a, b) { a }; f(1, 2, 3)",
+		"let f = fn(a) { a }; f",
+		"let x = 1; x = 5; x",
+		"let x = 10; x += 2; x -= 4; x *= 3; x /= 6; x",
+		"let a = 1; let b = 2; a = b = 3; [a, b]",
+		"let a = [1, 2, 3]; let b = a; b[1] = 5; b[0] += 10; a",
+		"let b = [1, 2, 3]; let c = push(b, 5); b[1] = 77; let r = rest(c); c[2] = 55; [b, c, r]",
+		`let h = {"a": [1]}; h["a"][0] = h["b"] = 2; h["a"][0] *= 4; h`,
+		"let a = [1]; a[1] = 5",
+		`let s = "abc"; s[0] = "x"`,
+		`let h = {}; h["missing"] += 1`,
+		`let p = {"name": "hou", "tags": {"lang": true}}; [p.name, p.tags.lang, p.age]`,
+		`let h = {"f": fn(x) { x * 2 }}; h.f(21)`,
+		`[1].length`,
+		"#pragma version 2\n[null ?? 1, 2 ?? 3, null ?? null, {}.a ?? [][0] ?? 4]",
+		"#pragma version 2\nlet n = 0; let f = fn() { n += 1 }; [1 ?? f(), null ?? f(), n]",
+		"#pragma version 2\nnull ?? 1 + true",
+		"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()",
+		"let f = fn(x) { fn() { x = x * 2; x } }; let g = f(2); g(); g()",
+		"let x = 1; let f = fn(x) { x = 2 }; f(0); x",
+		"let f = fn() { let x = 1; let g = fn() { fn() { x += 10 } }; g()(); x }; f()",
+		// Names bound in the blocks of if expressions are bound in the
+		// function.
+		`if (true) { let x = "hoisted"; }; x`,
+		"let f = fn() { if (true) { let y = 2; } y }; f()",
+		"[map([1, 2], fn(x) { x * 3 }), filter([1, 2, 3], fn(x) { x != 2 }), reduce([1, 2, 3], fn(a, x) { a * x }, 1)]",
+		"map([1, 2], fn(x) { x + true })",
+		"let map = fn(arr, f) { let iter = fn(arr, acc) { if (len(arr) == 0) { acc } else { iter(rest(arr), push(acc, f(first(arr)))) } }; iter(arr, []) }; map([1, 2, 3], fn(x) { x * x })",
+		// With version 2, blocks are scopes, and loop bodies are new
+		// scopes in every iteration.
+		"#pragma version 2\nlet i = 0; let s = 0; while (i < 5) { i += 1; s += i; }; [i, s]",
+		"#pragma version 2\nlet x = 1; if (true) { let x = 2; x } + x",
+		"#pragma version 2\nlet fs = []; let i = 0; while (i < 3) { let j = i; fs = push(fs, fn() { j }); i += 1; }; [fs[0](), fs[1](), fs[2]()]",
+		"#pragma version 2\nlet f = fn(n) { while (true) { if (n > 3) { return n; } n += 1; } }; f(0)",
+		"#pragma version 2\nwhile (false) { 1 }",
+		"#pragma version 2\nlet s = 0; for (x in [1, 2, 3]) { s += x; }; s",
+		"#pragma version 2\nlet s = []; for (k, v in {\"a\": 1, \"b\": 2}) { s = push(s, [k, v]); }; s",
+		"#pragma version 2\nlet s = []; for (k in {\"a\": 1}) { s = push(s, k); }; for (i, c in \"hé\") { s = push(s, [i, c]); }; s",
+		"#pragma version 2\nlet fs = []; for (x in [1, 2]) { let y = x * 10; fs = push(fs, fn() { x + y }); }; [fs[0](), fs[1]()]",
+		"#pragma version 2\nlet f = fn(xs) { for (x in xs) { if (x > 1) { return x; } } }; [f([1, 2, 3]), f([])]",
+		"#pragma version 2\nlet f = fn() { let n = 0; for (i in [1, 2]) { for (j in [3, 4]) { n += i * j; } } n }; f()",
+		"#pragma version 2\nfor (x in [1]) { x }",
+		"#pragma version 2\nfor (x in 5) { x }",
+		"#pragma version 2\nlet f = fn(x) { match (x) { case 1, 2: { \"low\" } case \"a\": { x } default: { let y = x; [y] } } }; [f(1), f(2), f(\"a\"), f(3)]",
+		"#pragma version 2\nmatch (1.0) { case 1: { true } }",
+		"#pragma version 2\nmatch (5) { case 1: { 1 } }",
+		"#pragma version 2\nlet n = 0; let f = fn(i) { n += 1; i }; match (2) { case f(1), f(2), f(3): { n } }",
+		"#pragma version 2\nlet fs = []; let i = 0; while (i < 2) { fs = push(fs, match (i) { case 0: { let z = i; fn() { z } } default: { fn() { 10 } } }); i += 1; }; [fs[0](), fs[1]()]",
+		"#pragma version 2\nmatch (null) { case 1: { 1 } case null: { 2 } }",
+		"#pragma version 2\nmatch (1 + true) { case 1: { 1 } }",
+		"#pragma version 2\nmatch (\"x\") { case 1: { 1 } case \"x\": { 2 } }",
+		"#pragma version 3\nlet g = fn(n) { while (n > 0) { yield n; n -= 1 } }; let xs = []; for (i, x in g(3)) { xs = push(xs, [i, x]) }; xs",
+		"#pragma version 3\nlet n = fn() { let i = 0; while (true) { yield i; i += 1 } }; let g = filter(map(n(), fn(x) { x * x }), fn(x) { x % 2 == 1 }); [next(g), next(g), next(g)]",
+		"#pragma version 3\nreduce(fn() { yield 1; yield 2 }(), fn(a, b) { a + b }, 10)",
+		"#pragma version 3\nlet g = fn() { yield 1 }; [g(), next(g())]",
+		// Errors.
+		"#pragma version 3\nfor (x in fn() { yield 1; 1 + true }()) { x }",
+		"1 + true",
+		"let f = fn() { 5 + true; 10 }; f()",
+		"-true",
+		"foobar",
+		`lne("abc")`,
+		"let length = 1; let f = fn(counter) { fn() { lenght + conter } }; f(1)()",
+		"builder",
+		"while",
+		"y = 1",
+		"let x = 1; x += true",
+		`{fn(x) { x }: 1}`,
+		"5()",
+		`len(1)`,
+		"#pragma version 2\n1 == true",
+		"[1 / 0]",
+		"let x = 7; x /= 0",
+		"0 ** -2",
+		"9223372036854775807 + 1",
+		"#pragma version 3\n9223372036854775807 + 1",
+		"#pragma version 3\nlet min = -9223372036854775807 - 1; -min",
+		"#pragma version 3\nlet x = 3037000500; x * x",
+		"#pragma version 3\n[2 ** 62, -4611686018427387904 * 2]",
+	}
+
+	for _, input := range tests {
+		program := parse(t, input)
+		want := evaluator.Eval(program, evaluator.NewEnvironment(program))
+
+		got := run(t, input)
+		if got.Inspect() != want.Inspect() {
+			t.Errorf("%q: wrong result. want=%s, got=%s", input, want.Inspect(), got.Inspect())
+		}
+		if wantErr, ok := want.(*object.Error); ok {
+			gotErr, ok := got.(*object.Error)
+			if !ok {
+				continue
+			}
+			if gotErr.Code != wantErr.Code || gotErr.Position != wantErr.Position {
+				t.Errorf("%q: wrong error. want=%s at %s, got=%s at %s", input,
+					wantErr.Code, wantErr.Position, gotErr.Code, gotErr.Position)
+			}
+		}
+	}
+}
+
+func TestErrorTrace(t *testing.T) {
+	input := `let add = fn(a, b) { a + b };
+let apply = fn(f) { f(1, true) };
+apply(add);`
+
+	err, ok := run(t, input).(*object.Error)
+	if !ok {
+		t.Fatalf("expected an error")
+	}
+
+	expected := []object.Frame{
+		{Function: "f", Position: token.Position{Line: 1, Column: 24}},
+		{Function: "apply", Position: token.Position{Line: 2, Column: 21}},
+		{Function: "<main>", Position: token.Position{Line: 3, Column: 1}},
+	}
+	if len(err.Trace) != len(expected) {
+		t.Fatalf("wrong trace. want=%v, got=%v", expected, err.Trace)
+	}
+	for i, frame := range err.Trace {
+		if frame != expected[i] {
+			t.Errorf("trace[%d] wrong. want=%v, got=%v", i, expected[i], frame)
+		}
+	}
+}
+
+func TestEncodedRun(t *testing.T) {
+	inputs := []string{
+		"1 + 2 * 3",
+		`let s = "hou"; s + "!"`,
+		"let f = fn(a, b = a * 2.5) { [a, b] }; f(2)",
+		"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c()",
+		"let h = {\"a\": 1}; h.a",
+		"x = 1",
+		`let add = fn(a, b) { a + b }; add(1, true)`,
+	}
+
+	for _, input := range inputs {
+		bytecode, err := compiler.Compile(parse(t, input))
+		if err != nil {
+			t.Fatalf("%q: compiler error: %s", input, err)
+		}
+		data, err := compiler.Encode(bytecode)
+		if err != nil {
+			t.Fatalf("%q: encode error: %s", input, err)
+		}
+		decoded, err := compiler.Decode(data)
+		if err != nil {
+			t.Fatalf("%q: decode error: %s", input, err)
+		}
+
+		want := run(t, input)
+		got := New(decoded, evaluator.New()).Run()
+		if got.Inspect() != want.Inspect() {
+			t.Errorf("%q: wrong result. want=%s, got=%s", input, want.Inspect(), got.Inspect())
+		}
+		if wantErr, ok := want.(*object.Error); ok {
+			gotErr := got.(*object.Error)
+			if fmt.Sprint(gotErr.Position, gotErr.Trace) != fmt.Sprint(wantErr.Position, wantErr.Trace) {
+				t.Errorf("%q: wrong trace. want=%v %v, got=%v %v", input,
+					wantErr.Position, wantErr.Trace, gotErr.Position, gotErr.Trace)
+			}
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	err, ok := run(t, "let f = fn() { f() }; f()").(*object.Error)
+	if !ok || err.Code != diagnostic.StackOverflow {
+		t.Fatalf("infinite recursion wasn't stopped. got=%v", err)
+	}
+	// The VM allows as many calls as the evaluator.
+	want := evaluator.New().Eval(parse(t, "let f = fn() { f() }; f()"), object.NewEnvironment()).(*object.Error)
+	if err.Message != want.Message || len(err.Trace) != len(want.Trace) {
+		t.Errorf("wrong error. want=%s with %d frames, got=%s with %d frames",
+			want.Message, len(want.Trace), err.Message, len(err.Trace))
+	}
+
+	// The stack grows for deep recursion that ends.
+	result := run(t, "let sum = fn(n) { if (n == 0) { 0 } else { n + sum(n - 1) } }; sum(9999)")
+	if result.Inspect() != "49995000" {
+		t.Errorf("wrong result of deep recursion. got=%s", result.Inspect())
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	// Builtins write to the output of the Evaluator, and call the closures
+	// of the program back.
+	var out bytes.Buffer
+	e := evaluator.New()
+	e.Stdout = &out
+
+	input := `let double = fn(x) { x * 2 };
+puts(pmap([1, 2, 3], double));
+wait(spawn(fn(a, b) { a + b }, 1, 2)) + wait(spawn fn() { double(0) })`
+	bytecode, err := compiler.Compile(parse(t, input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := New(bytecode, e).Run()
+
+	if result.Inspect() != "3" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+	if out.String() != "[2, 4, 6]\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+
+	// Closures can be called by the host too.
+	fn := run(t, "fn(a, b) { a - b }")
+	if result := e.ApplyFunction(fn, []object.Object{
+		&object.Integer{Value: 5}, &object.Integer{Value: 3},
+	}); result.Inspect() != "2" {
+		t.Errorf("wrong result of calling a closure. got=%s", result.Inspect())
+	}
+}
+
+// TestSpawnAssign checks that tasks assigning to the same globals, cells and
+// elements don't race, which `go test -race` reports.
+func TestSpawnAssign(t *testing.T) {
+	input := `#pragma version 2
+let h = {};
+let a = [0, 0];
+let work = fn(n) {
+  let count = 0;
+  let bump = fn() { count += 1 };
+  while (count < 200) {
+    h[n] = count;
+    h["shared"] = n;
+    a[n % 2] += 1;
+    h["shared"] + a[0];
+    bump();
+  }
+  n
+};
+let tasks = map([1, 2, 3, 4, 5, 6, 7, 8], fn(n) { spawn(work, n) });
+[map(tasks, wait), h["shared"] > 0, a[0] + a[1] <= 1600]`
+
+	if got := run(t, input).Inspect(); got != "[[1, 2, 3, 4, 5, 6, 7, 8], true, true]" {
+		t.Errorf("wrong result. got=%s", got)
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: parser errors: %v", input, p.Errors())
+	}
+	return program
+}
+
+func run(t *testing.T, input string) object.Object {
+	t.Helper()
+	bytecode, err := compiler.Compile(parse(t, input))
+	if err != nil {
+		t.Fatalf("%q: compiler error: %s", input, err)
+	}
+	return New(bytecode, evaluator.New()).Run()
+}